@@ -0,0 +1,91 @@
+package main
+
+import "database/sql"
+
+// PriceStats - сводка по средним ценам ресторанов одного типа
+type PriceStats struct {
+    Type         string
+    Restaurants  int
+    AveragePrice float64
+    MinPrice     int
+    MaxPrice     int
+}
+
+// CountUsers возвращает число пользователей
+func (db *Database) CountUsers() (int, error) {
+    var count int
+    err := db.queryScalar("users.count", nil, &count)
+    return count, db.opError("count", "users", nil, err)
+}
+
+// CountRestaurants возвращает число ресторанов, подходящих под фильтр; Limit и Offset не учитываются
+func (db *Database) CountRestaurants(filter RestaurantFilter) (int, error) {
+    var count int
+    err := db.queryScalar("restaurants.count_filtered", filter.applyWhere, &count)
+    return count, db.opError("count", "restaurants", nil, err)
+}
+
+// AverageRestaurantPrice возвращает среднюю цену ресторанов, подходящих под фильтр,
+// или 0, если таких ресторанов нет
+func (db *Database) AverageRestaurantPrice(filter RestaurantFilter) (float64, error) {
+    var average sql.NullFloat64
+    err := db.queryScalar("restaurants.average_price_filtered", filter.applyWhere, &average)
+    return average.Float64, db.opError("average price", "restaurants", nil, err)
+}
+
+// AverageRating возвращает средний рейтинг ресторана по отзывам или 0, если отзывов нет
+func (db *Database) AverageRating(restaurantID int) (float64, error) {
+    var average sql.NullFloat64
+    err := db.queryScalar("reviews.average_rating", func(query *SelectBuilder) {
+        query.Where("restaurant_id = ?", restaurantID)
+    }, &average)
+    return average.Float64, db.opError("average rating", "restaurant", restaurantID, err)
+}
+
+// RestaurantPriceStatsByType возвращает число ресторанов и их цены по каждому типу кухни
+func (db *Database) RestaurantPriceStatsByType() ([]PriceStats, error) {
+    rows, err := db.queryNamed("restaurants.price_stats_by_type", db.tenant)
+    if err != nil {
+        return nil, db.opError("price stats", "restaurants", nil, err)
+    }
+    defer rows.Close()
+
+    var stats []PriceStats
+    for rows.Next() {
+        var s PriceStats
+        var average sql.NullFloat64
+        var minPrice, maxPrice sql.NullInt64
+        if err := rows.Scan(&s.Type, &s.Restaurants, &average, &minPrice, &maxPrice); err != nil {
+            return nil, db.opError("price stats", "restaurants", nil, err)
+        }
+        s.AveragePrice, s.MinPrice, s.MaxPrice = average.Float64, int(minPrice.Int64), int(maxPrice.Int64)
+        stats = append(stats, s)
+    }
+    return stats, db.opError("price stats", "restaurants", nil, rows.Err())
+}
+
+// queryScalar выполняет агрегирующий запрос по строкам текущей площадки с условиями,
+// которые добавляет where (может быть nil), и читает одно значение
+func (db *Database) queryScalar(name string, where func(*SelectBuilder), dest interface{}) error {
+    query, err := db.selectNamed(name)
+    if err != nil {
+        return err
+    }
+    query.Where("tenant_id = ?", db.tenant)
+    if where != nil {
+        where(query)
+    }
+
+    rows, err := db.queryBuilt(name, query)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        if err := rows.Scan(dest); err != nil {
+            return err
+        }
+    }
+    return rows.Err()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// exportSinceNamespace - пространство имен инкрементальных выгрузок: строки, измененные за период
+const exportSinceNamespace = "export_since."
+
+// AnalyticsFormat - формат файлов выгрузки для аналитики: CSV с заголовком, сжатый gzip
+const AnalyticsFormat = "csv+gzip"
+
+// analyticsRunLayout - имя каталога запуска выгрузки по его моменту until: по имени каталоги
+// сортируются в порядке запусков
+const analyticsRunLayout = "20060102T150405Z"
+
+// AnalyticsManifest - описание одного запуска выгрузки для аналитики: файлы наборов данных, их схема
+// и период. Пишется в manifest.json каталога запуска последним, поэтому каталог с манифестом выгружен целиком
+type AnalyticsManifest struct {
+    Format   string `json:"format"`
+    TenantID int    `json:"tenant_id"`
+    // Since - начало периода инкрементальной выгрузки; nil - полная выгрузка
+    Since *time.Time `json:"since,omitempty"`
+    // Until - момент выгрузки: следующая инкрементальная выгрузка начинается с него (см. -since last)
+    Until    time.Time          `json:"until"`
+    Datasets []AnalyticsDataset `json:"datasets"`
+}
+
+// AnalyticsDataset - файл одного набора данных
+type AnalyticsDataset struct {
+    Name string `json:"name"`
+    // File - имя файла в каталоге запуска
+    File    string            `json:"file"`
+    Rows    int64             `json:"rows"`
+    SHA256  string            `json:"sha256"`
+    Columns []AnalyticsColumn `json:"columns"`
+}
+
+// AnalyticsColumn - колонка набора данных. Type - integer, double, boolean, string, timestamp (RFC 3339 в UTC)
+// или binary (base64); NULL записывается пустым полем
+type AnalyticsColumn struct {
+    Name     string `json:"name"`
+    Type     string `json:"type"`
+    Nullable bool   `json:"nullable"`
+}
+
+// AnalyticsOptions - что выгружать для аналитики
+type AnalyticsOptions struct {
+    // Datasets - выгрузки (запросы export.<name>); пусто - все. Инкрементальная выгрузка берет строки
+    // из export_since.<name> и всегда добавляет набор deletions с удаленными за период строками
+    Datasets []string
+    // Since - выгрузить только строки, добавленные или измененные начиная с этого момента; nil - все строки
+    Since *time.Time
+}
+
+// ExportAnalytics выгружает наборы данных текущей площадки для загрузки в хранилище аналитики в новый
+// каталог запуска внутри dir: по файлу <набор>.csv.gz на набор и manifest.json со схемой и периодом
+// (см. AnalyticsManifest). Каталог пишется под временным именем и переименовывается, когда выгружен
+// целиком, так что загрузчик не увидит незаконченный запуск. Изменения отбираются по журналу аудита
+func (db *Database) ExportAnalytics(dir string, options AnalyticsOptions) (AnalyticsManifest, error) {
+    return db.exportAnalytics(dir, options, nil)
+}
+
+// exportAnalytics выполняет ExportAnalytics, вызывая onRow после каждой записанной строки;
+// ошибка onRow прерывает выгрузку
+func (db *Database) exportAnalytics(dir string, options AnalyticsOptions, onRow func() error) (AnalyticsManifest, error) {
+    datasets, err := db.analyticsDatasets(options)
+    if err != nil {
+        return AnalyticsManifest{}, db.opError("export", "analytics", nil, err)
+    }
+    manifest := AnalyticsManifest{Format: AnalyticsFormat, TenantID: db.tenant, Until: db.now().UTC().Truncate(time.Microsecond)}
+    if options.Since != nil {
+        since := options.Since.UTC()
+        if !since.Before(manifest.Until) {
+            return AnalyticsManifest{}, db.opError("export", "analytics", nil, &ValidationError{Field: "since", Message: "must be in the past"})
+        }
+        manifest.Since = &since
+    }
+
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return AnalyticsManifest{}, err
+    }
+    run := filepath.Join(dir, manifest.Until.Format(analyticsRunLayout))
+    partial := run + ".partial"
+    if err := os.Mkdir(partial, 0o755); err != nil {
+        return AnalyticsManifest{}, err
+    }
+    manifest.Datasets, err = db.writeAnalyticsDatasets(partial, datasets, manifest, onRow)
+    if err == nil {
+        err = writeAnalyticsManifest(filepath.Join(partial, "manifest.json"), manifest)
+    }
+    if err == nil {
+        err = os.Rename(partial, run)
+    }
+    if err != nil {
+        os.RemoveAll(partial)
+        return AnalyticsManifest{}, err
+    }
+    return manifest, nil
+}
+
+// analyticsDatasets проверяет запрошенные наборы данных и возвращает их по порядку имен
+func (db *Database) analyticsDatasets(options AnalyticsOptions) ([]string, error) {
+    datasets := options.Datasets
+    if len(datasets) == 0 {
+        datasets = db.exportNames()
+    }
+    seen := map[string]bool{}
+    var names []string
+    for _, name := range datasets {
+        if seen[name] {
+            continue
+        }
+        seen[name] = true
+        if !db.queries.Has(exportNamespace + name) {
+            return nil, &ValidationError{Field: "datasets", Message: fmt.Sprintf("unknown dataset %q (expected %s)", name, strings.Join(db.exportNames(), ", "))}
+        }
+        if options.Since != nil && !db.queries.Has(exportSinceNamespace+name) {
+            return nil, &ValidationError{Field: "datasets", Message: fmt.Sprintf("dataset %q has no incremental query %s%s", name, exportSinceNamespace, name)}
+        }
+        names = append(names, name)
+    }
+    if options.Since != nil && db.queries.Has(exportSinceNamespace+"deletions") && !seen["deletions"] {
+        names = append(names, "deletions")
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// writeAnalyticsDatasets пишет файлы наборов данных в каталог dir
+func (db *Database) writeAnalyticsDatasets(dir string, datasets []string, manifest AnalyticsManifest, onRow func() error) ([]AnalyticsDataset, error) {
+    var written []AnalyticsDataset
+    for _, name := range datasets {
+        query, args := exportNamespace+name, []interface{}{db.tenant}
+        if manifest.Since != nil {
+            query, args = exportSinceNamespace+name, []interface{}{db.tenant, *manifest.Since, manifest.Until}
+        }
+        dataset := AnalyticsDataset{Name: name, File: name + ".csv.gz"}
+        file, err := os.Create(filepath.Join(dir, dataset.File))
+        if err != nil {
+            return nil, err
+        }
+        hash := sha256.New()
+        dataset.Rows, dataset.Columns, err = db.writeAnalyticsCSV(io.MultiWriter(file, hash), query, args, onRow)
+        if closeErr := file.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            return nil, db.opError("export", "analytics", name, err)
+        }
+        dataset.SHA256 = hex.EncodeToString(hash.Sum(nil))
+        written = append(written, dataset)
+    }
+    return written, nil
+}
+
+// writeAnalyticsCSV пишет строки запроса в w как CSV с заголовком, сжатый gzip, и возвращает число строк и колонки
+func (db *Database) writeAnalyticsCSV(w io.Writer, query string, args []interface{}, onRow func() error) (int64, []AnalyticsColumn, error) {
+    rows, err := db.queryNamed(query, args...)
+    if err != nil {
+        return 0, nil, err
+    }
+    defer rows.Close()
+
+    types, err := rows.ColumnTypes()
+    if err != nil {
+        return 0, nil, err
+    }
+    columns := make([]AnalyticsColumn, len(types))
+    header := make([]string, len(types))
+    for i, columnType := range types {
+        nullable, ok := columnType.Nullable()
+        columns[i] = AnalyticsColumn{Name: columnType.Name(), Type: analyticsColumnType(columnType.DatabaseTypeName()), Nullable: nullable || !ok}
+        header[i] = columnType.Name()
+    }
+
+    compressed := compressWriter(w, CompressionGzip)
+    buffered := bufio.NewWriter(compressed)
+    writer := csv.NewWriter(buffered)
+    if err := writer.Write(header); err != nil {
+        return 0, nil, err
+    }
+
+    values := make([]interface{}, len(columns))
+    pointers := make([]interface{}, len(columns))
+    for i := range values {
+        pointers[i] = &values[i]
+    }
+    record := make([]string, len(columns))
+    var count int64
+    for rows.Next() {
+        if err := rows.Scan(pointers...); err != nil {
+            return count, nil, err
+        }
+        for i, value := range values {
+            record[i] = analyticsValue(value, columns[i].Type)
+        }
+        if err := writer.Write(record); err != nil {
+            return count, nil, err
+        }
+        count++
+        if onRow != nil {
+            if err := onRow(); err != nil {
+                return count, nil, err
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return count, nil, err
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return count, nil, err
+    }
+    if err := buffered.Flush(); err != nil {
+        return count, nil, err
+    }
+    return count, columns, compressed.Close()
+}
+
+// analyticsColumnType сопоставляет тип колонки из драйвера с типом манифеста (см. AnalyticsColumn);
+// колонки без объявленного типа (выражения в SQLite) считаются строками
+func analyticsColumnType(databaseType string) string {
+    switch goColumnType(databaseType) {
+    case "int", "int64":
+        return "integer"
+    case "float64":
+        return "double"
+    case "bool":
+        return "boolean"
+    case "time.Time":
+        return "timestamp"
+    case "[]byte":
+        return "binary"
+    }
+    return "string"
+}
+
+// analyticsValue записывает значение колонки типа columnType полем CSV
+func analyticsValue(value interface{}, columnType string) string {
+    switch v := value.(type) {
+    case nil:
+        return ""
+    case time.Time:
+        return v.UTC().Format(time.RFC3339Nano)
+    case []byte:
+        // драйверы SQLite и MySQL отдают текст как []byte
+        if columnType == "binary" {
+            return base64.StdEncoding.EncodeToString(v)
+        }
+        return string(v)
+    case string:
+        return v
+    case int64:
+        return strconv.FormatInt(v, 10)
+    case float64:
+        return strconv.FormatFloat(v, 'g', -1, 64)
+    case bool:
+        return strconv.FormatBool(v)
+    }
+    return fmt.Sprint(value)
+}
+
+// writeAnalyticsManifest пишет манифест запуска
+func writeAnalyticsManifest(path string, manifest AnalyticsManifest) error {
+    data, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LatestAnalyticsManifest возвращает манифест последнего законченного запуска выгрузки в каталоге dir;
+// ok == false, если выгрузок там еще не было
+func LatestAnalyticsManifest(dir string) (manifest AnalyticsManifest, ok bool, err error) {
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return AnalyticsManifest{}, false, nil
+    }
+    if err != nil {
+        return AnalyticsManifest{}, false, err
+    }
+    for i := len(entries) - 1; i >= 0; i-- {
+        if !entries[i].IsDir() || strings.HasSuffix(entries[i].Name(), ".partial") {
+            continue
+        }
+        data, err := os.ReadFile(filepath.Join(dir, entries[i].Name(), "manifest.json"))
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return AnalyticsManifest{}, false, err
+        }
+        if err := json.Unmarshal(data, &manifest); err != nil {
+            return AnalyticsManifest{}, false, fmt.Errorf("%s: %v", entries[i].Name(), err)
+        }
+        return manifest, true, nil
+    }
+    return AnalyticsManifest{}, false, nil
+}
+
+// analyticsSince разбирает начало инкрементальной выгрузки в каталог dir: время RFC 3339, last - конец
+// последнего запуска в dir (полная выгрузка, если запусков не было) или "" - полная выгрузка
+func analyticsSince(dir, value string) (*time.Time, error) {
+    switch value {
+    case "":
+        return nil, nil
+    case "last":
+        manifest, ok, err := LatestAnalyticsManifest(dir)
+        if err != nil || !ok {
+            return nil, err
+        }
+        return &manifest.Until, nil
+    }
+    since, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return nil, &ValidationError{Field: "since", Message: "must be last or a time like 2026-01-02T15:04:05Z"}
+    }
+    return &since, nil
+}
+
+// SetAnalyticsDir задает каталог, в который выгружают для аналитики задания POST /jobs/analytics-export,
+// по подкаталогу на площадку; "" - выгрузка по HTTP выключена. Вызывается до Handler
+func (db *Database) SetAnalyticsDir(dir string) {
+    db.analyticsDir = dir
+}
+
+// tenantAnalyticsDir возвращает каталог выгрузок для аналитики текущей площадки
+func (db *Database) tenantAnalyticsDir() string {
+    return filepath.Join(db.analyticsDir, "tenant-"+strconv.Itoa(db.tenant))
+}
+
+// analyticsExportRequest - тело POST /jobs/analytics-export
+type analyticsExportRequest struct {
+    // Datasets - выгрузки; пусто - все
+    Datasets []string `json:"datasets,omitempty"`
+    // Since - начало инкрементальной выгрузки: время RFC 3339 или last; пусто - полная выгрузка
+    Since string `json:"since,omitempty"`
+}
+
+// serveAnalyticsExportJob запускает задание выгрузки для аналитики в каталог площадки (см. SetAnalyticsDir)
+func (db *Database) serveAnalyticsExportJob(w http.ResponseWriter, r *http.Request) {
+    if db.analyticsDir == "" {
+        writeError(w, fmt.Errorf("%w: analytics export is not configured (-analytics-dir)", ErrNotFound))
+        return
+    }
+    var request analyticsExportRequest
+    if err := decodeBody(r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+    // since проверяется до постановки задания, чтобы опечатка получила 400, а не упавшее задание
+    if _, err := analyticsSince(db.tenantAnalyticsDir(), request.Since); err != nil {
+        writeError(w, err)
+        return
+    }
+    actor, _ := requestActor(r)
+    job, err := db.SubmitJob(actor, JobAnalyticsExport, AnalyticsExportParams{Dir: db.tenantAnalyticsDir(), Datasets: request.Datasets, Since: request.Since})
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusAccepted, job)
+}
+
+// runAnalyticsExport выполняет команду analytics-export: выгружает наборы данных площадки в новый
+// каталог запуска внутри -dir и печатает его манифест
+func runAnalyticsExport(db *Database, args []string) error {
+    flags := flag.NewFlagSet("analytics-export", flag.ContinueOnError)
+    dir := flags.String("dir", "./analytics", "directory that receives a subdirectory per run with the files and manifest.json")
+    datasets := flags.String("datasets", "", "comma-separated datasets to export (default: all): "+strings.Join(db.exportNames(), ", "))
+    sinceFlag := flags.String("since", "", "export only rows added or changed since this time (RFC 3339), or since the previous run in -dir with last")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    since, err := analyticsSince(*dir, *sinceFlag)
+    if err != nil {
+        return err
+    }
+    options := AnalyticsOptions{Since: since}
+    if *datasets != "" {
+        options.Datasets = strings.Split(*datasets, ",")
+    }
+
+    manifest, err := db.ExportAnalytics(*dir, options)
+    if err != nil {
+        return err
+    }
+    period := "full export"
+    if manifest.Since != nil {
+        period = "changes since " + manifest.Since.Format(time.RFC3339)
+    }
+    fmt.Printf("%s (%s) to %s\n", period, manifest.Until.Format(time.RFC3339), filepath.Join(*dir, manifest.Until.Format(analyticsRunLayout)))
+    for _, dataset := range manifest.Datasets {
+        fmt.Printf("  %-12s %d rows, %d columns\n", dataset.Name, dataset.Rows, len(dataset.Columns))
+    }
+    return nil
+}
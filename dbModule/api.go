@@ -0,0 +1,473 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "dbModule/httpapi"
+)
+
+// Handler возвращает HTTP API модуля для площадки db:
+//   GET /export/{name} - выгрузка таблицы (см. ExportHandler)
+//   GET /restaurants - страница ресторанов; фильтры type, name_prefix, min_price, max_price, tier,
+//     user_id, category_id, open_at=now|время, сортировка sort=name,-price
+//   GET /restaurants/{id}/reviews - страница отзывов о ресторане
+//   GET /owners - страница пользователей, у каждого все его рестораны (см. ListingsPage)
+//   POST /restaurants, POST /users - создание; повтор с тем же заголовком Idempotency-Key
+//     возвращает уже созданную запись (см. WithIdempotencyKey)
+//   POST /owners - владелец вместе с первым рестораном в одной транзакции запроса (см. Transactional)
+//   PATCH /restaurants/{id}, PATCH /users/{id} - изменение только переданных полей (см. UpdateRestaurantFields)
+//   POST /restaurants/{id}/transfer - передача ресторана новому владельцу (см. TransferRestaurant)
+//   GET /categories, POST /categories, PATCH и DELETE /categories/{id} - категории ресторанов (см. Category),
+//     GET и PUT /restaurants/{id}/categories - категории ресторана (см. SetRestaurantCategories)
+//   GET, PUT и DELETE /restaurants/{id}/hours - часы работы ресторана (см. SetRestaurantHours)
+//   GET /users/{id}/favorites, PUT и DELETE /users/{id}/favorites/{restaurant_id} - избранное пользователя,
+//     GET /restaurants/{id}/fans - добавившие ресторан в избранное (см. AddFavorite)
+//   GET и POST /restaurants/{id}/slots, DELETE /restaurants/{id}/slots/{slot_id} - слоты бронирования
+//     ресторана (см. CreateBookingSlot), GET и POST /restaurants/{id}/bookings - брони в них,
+//     GET /users/{id}/bookings - брони пользователя, DELETE /bookings/{id} - отмена брони (см. CreateBooking)
+//   GET /users/{id}/preferences, PUT и DELETE /users/{id}/preferences/{name} - настройки пользователя
+//     (см. SetPreference); видны только ему самому и администратору
+//   GET /restaurants/{id}/localized?locale= - ресторан на языке запроса или Accept-Language,
+//     GET /restaurants/{id}/translations, PUT и DELETE /restaurants/{id}/translations/{locale} - его переводы
+//     (см. GetLocalizedRestaurant)
+//   POST /listings, GET /listings - импорт и выгрузка ресторанов в формате обмена (см. ImportListings),
+//     GET /listings/schema - его JSON Schema
+//   POST /jobs/import-restaurants?format=csv|jsonl - фоновый импорт ресторанов из тела (см. SubmitJob),
+//     POST /jobs/analytics-export - фоновая выгрузка для аналитики в каталог площадки (см. ExportAnalytics),
+//     GET /jobs, GET /jobs/{id} - задания и их прогресс, DELETE /jobs/{id} - отмена задания
+//   POST /graphql, GET /graphql - запросы GraphQL к пользователям и ресторанам (см. GraphQLSchema)
+//   GET /reports - отчеты из reports.yaml, GET /reports/{name}?param=value - результат отчета (см. RunReport)
+//   GET /health - состояние базы, автомата отключения и реплик, 503 - база недоступна (см. HealthCheck)
+//   GET /openapi.json - описание API в формате OpenAPI 3 (см. OpenAPI), GET /docs - Swagger UI к нему
+// С -auth перед API стоит httpapi.Authenticator (см. authOptions): POST /login выдает JWT по email и паролю,
+// остальные маршруты, кроме /health, /openapi.json и /docs, требуют его, а изменения проверяют права его
+// пользователя: ресторан и его данные меняет владелец, пользователя и его избранное - он сам, роль, категории
+// и чужие задания - администратор (см. CanManageRestaurant, authorizeUserFields, rolePermissions).
+// Списки принимают cursor, size и total=exact|estimate и отвечают Page со ссылками на соседние страницы.
+// Каждый запрос выполняется с бюджетом SetRequestBudget, а участки трассировки его запросов к базе
+// продолжают трассировку из заголовка traceparent (см. OTLPTelemetry). Логи, участки и журнал аудита
+// запроса помечаются идентификатором корреляции из заголовка X-Request-ID, который возвращается
+// и в ответе (см. WithCorrelationID). Список, отданный из кеша устаревшим из-за недоступности базы
+// (см. SetQueryCacheStale), помечается заголовками Warning: 110 и Age.
+// Чтения запроса обрываются, когда клиент отключился (см. WithRequestContext)
+func (db *Database) Handler() http.Handler {
+    mux := http.NewServeMux()
+    for _, route := range db.apiRoutes() {
+        serve := route.serve
+        if route.tx {
+            serve = inRequestTx(serve)
+        }
+        mux.HandleFunc(route.method+" "+route.pattern, db.perRequest(serve))
+    }
+    mux.HandleFunc("GET /openapi.json", db.serveOpenAPI)
+    mux.HandleFunc("GET /docs", serveAPIDocs)
+    return mux
+}
+
+// SetRequestBudget задает бюджет запросов к базе на один HTTP-запрос (см. WithBudget); вызывается до Handler
+func (db *Database) SetRequestBudget(budget QueryBudget) {
+    db.requestBudget = budget
+}
+
+// perRequest обслуживает каждый HTTP-запрос своей копией Database с бюджетом запросов, отметкой
+// устаревших чтений и контекстом запроса: трассировкой и отменой чтений, когда клиент отключился
+// (см. WithRequestContext). После первой записи чтения запроса идут мимо кешей и реплик, чтобы ответ
+// видел только что записанное (см. WithFreshReads). Запрос учитывается в потреблении площадки (см. CountRequest).
+// Идентификатор корреляции берется из заголовка X-Request-ID или создается и возвращается в том же заголовке
+// ответа; с ним запросы к базе попадают в логи, трассировку и журнал аудита (см. WithCorrelationID)
+func (db *Database) perRequest(serve func(db *Database, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        db.CountRequest(0)
+        correlationID := requestCorrelationID(r.Header.Get(CorrelationIDHeader))
+        w.Header().Set(CorrelationIDHeader, correlationID)
+        ctx := WithCorrelationID(ContextWithTraceParent(r.Context(), r.Header.Get("traceparent")), correlationID)
+        reads := &StaleReads{}
+        scoped := db.WithBudget(db.requestBudget).WithStaleReads(reads).WithRequestContext(withWriteSession(ctx))
+        serve(scoped, &staleResponseWriter{ResponseWriter: w, reads: reads}, r)
+    }
+}
+
+// staleResponseWriter добавляет к ответу заголовки устаревших данных, если при его подготовке
+// списки отдавались из кеша устаревшими
+type staleResponseWriter struct {
+    http.ResponseWriter
+    reads       *StaleReads
+    wroteHeader bool
+}
+
+func (w *staleResponseWriter) WriteHeader(status int) {
+    if !w.wroteHeader {
+        w.wroteHeader = true
+        if len(w.reads.Reads()) > 0 {
+            w.Header().Set("Warning", `110 - "Response is Stale"`)
+            w.Header().Set("Age", strconv.Itoa(int(w.reads.MaxAge().Seconds())))
+        }
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *staleResponseWriter) Write(data []byte) (int, error) {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    return w.ResponseWriter.Write(data)
+}
+
+// pageLinks - ссылки на соседние страницы с теми же параметрами запроса
+type pageLinks struct {
+    Next string `json:"next,omitempty"`
+    Prev string `json:"prev,omitempty"`
+}
+
+// pageResponse - ответ HTTP API со страницей списка
+type pageResponse[T any] struct {
+    Page[T]
+    Links pageLinks `json:"links"`
+}
+
+// serveRestaurants отдает страницу ресторанов
+func (db *Database) serveRestaurants(w http.ResponseWriter, r *http.Request) {
+    request, err := parsePageRequest(r.URL.Query())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    var filter RestaurantFilter
+    var sorts []RestaurantSort
+    for key, values := range r.URL.Query() {
+        switch key {
+        case "cursor", "size", "total":
+        case "sort":
+            for _, field := range strings.Split(values[0], ",") {
+                name, descending := strings.CutPrefix(field, "-")
+                sorts = append(sorts, RestaurantSort{Field: RestaurantSortField(name), Descending: descending})
+            }
+        default:
+            if err := filter.set(key, values[0]); err != nil {
+                writeError(w, &ValidationError{Field: key, Message: err.Error()})
+                return
+            }
+        }
+    }
+
+    page, err := db.RestaurantsPage(filter, request, sorts...)
+    writePage(w, r, page, err)
+}
+
+// serveReviews отдает страницу отзывов о ресторане
+func (db *Database) serveReviews(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be a number"})
+        return
+    }
+    request, err := parsePageRequest(r.URL.Query())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    page, err := db.ReviewsPage(restaurantID, request)
+    writePage(w, r, page, err)
+}
+
+// ownerListing - владелец со всеми его ресторанами на странице GET /owners
+type ownerListing struct {
+    User        PublicUser   `json:"user"`
+    Restaurants []Restaurant `json:"restaurants"`
+}
+
+// serveOwners отдает страницу пользователей с ресторанами; страница считается по пользователям (см. ListingsPage)
+func (db *Database) serveOwners(w http.ResponseWriter, r *http.Request) {
+    request, err := parsePageRequest(r.URL.Query())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    listings, err := db.ListingsPage(request)
+    page := Page[ownerListing]{Items: make([]ownerListing, len(listings.Items)), NextCursor: listings.NextCursor,
+        PrevCursor: listings.PrevCursor, Total: listings.Total, TotalEstimated: listings.TotalEstimated}
+    for i, listing := range listings.Items {
+        page.Items[i] = ownerListing{User: listing.User.Public(), Restaurants: listing.Restaurants}
+    }
+    writePage(w, r, page, err)
+}
+
+// parsePageRequest читает параметры страницы cursor, size и total
+func parsePageRequest(query url.Values) (PageRequest, error) {
+    request := PageRequest{Cursor: query.Get("cursor")}
+    if size := query.Get("size"); size != "" {
+        n, err := strconv.Atoi(size)
+        if err != nil || n <= 0 {
+            return request, &ValidationError{Field: "size", Message: "must be a positive number"}
+        }
+        request.Size = n
+    }
+    switch query.Get("total") {
+    case "":
+    case "exact":
+        request.Total = TotalExact
+    case "estimate":
+        request.Total = TotalEstimate
+    default:
+        return request, &ValidationError{Field: "total", Message: "must be exact or estimate"}
+    }
+    return request, nil
+}
+
+// writePage отвечает страницей списка со ссылками, в которых заменен только cursor
+func writePage[T any](w http.ResponseWriter, r *http.Request, page Page[T], err error) {
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    link := func(cursor string) string {
+        if cursor == "" {
+            return ""
+        }
+        query := r.URL.Query()
+        query.Set("cursor", cursor)
+        return r.URL.Path + "?" + query.Encode()
+    }
+    writeJSON(w, http.StatusOK, pageResponse[T]{
+        Page:  page,
+        Links: pageLinks{Next: link(page.NextCursor), Prev: link(page.PrevCursor)},
+    })
+}
+
+// userCreateBody - тело POST /users
+type userCreateBody struct {
+    Name      string  `json:"name"`
+    Lastname  string  `json:"lastname"`
+    Password  string  `json:"password"`
+    Email     string  `json:"email"`
+    Phone     *string `json:"phone"`
+    // Role учитывается, только если пользователя создает администратор (см. grantableRole)
+    Role      string  `json:"role"`
+    // PublicID - идентификатор, созданный клиентом (см. Config.IDScheme); пустой - по схеме модуля
+    PublicID  string  `json:"public_id,omitempty"`
+    // Поля профиля необязательны (см. User)
+    AvatarURL *string `json:"avatar_url,omitempty"`
+    Bio       *string `json:"bio,omitempty"`
+    Birthdate *string `json:"birthdate,omitempty"`
+    Locale    *string `json:"locale,omitempty"`
+}
+
+// user возвращает пользователя для записи из тела запроса
+func (b userCreateBody) user() User {
+    return User{Name: b.Name, Lastname: b.Lastname, Password: b.Password, Email: b.Email, Phone: b.Phone, Role: b.Role, PublicID: b.PublicID,
+        AvatarURL: b.AvatarURL, Bio: b.Bio, Birthdate: b.Birthdate, Locale: b.Locale}
+}
+
+// serveCreateUser создает пользователя и отвечает им без пароля. Роль из тела задает только администратор,
+// вошедший через -auth; остальные создают покупателя (см. grantableRole)
+func (db *Database) serveCreateUser(w http.ResponseWriter, r *http.Request) {
+    var body userCreateBody
+    if err := decodeBody(r, &body); err != nil {
+        writeError(w, err)
+        return
+    }
+    actor, _ := requestActor(r)
+    role, err := db.grantableRole(actor, body.Role, RoleCustomer)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    body.Role = role
+    db = db.WithIdempotencyKey(r.Header.Get("Idempotency-Key"))
+    id, err := db.insertUser(body.user())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    user, err := db.WithPrimary().GetUserByID(int(id))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, user.Admin())
+}
+
+// ownerCreateBody - тело POST /owners
+type ownerCreateBody struct {
+    User       userCreateBody `json:"user"`
+    Restaurant Restaurant     `json:"restaurant"`
+}
+
+// ownerResponse - ответ POST /owners
+type ownerResponse struct {
+    User       AdminUser  `json:"user"`
+    Restaurant Restaurant `json:"restaurant"`
+}
+
+// serveCreateOwner создает пользователя с ролью owner и его ресторан; другую роль может задать только
+// администратор, вошедший через -auth (см. grantableRole).
+// Выполняется в транзакции запроса: ошибка при создании ресторана отменяет и пользователя
+func (db *Database) serveCreateOwner(w http.ResponseWriter, r *http.Request) {
+    var body ownerCreateBody
+    if err := decodeBody(r, &body); err != nil {
+        writeError(w, err)
+        return
+    }
+    actor, _ := requestActor(r)
+    role, err := db.grantableRole(actor, body.User.Role, RoleOwner)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    body.User.Role = role
+    userID, err := db.insertUser(body.User.user())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    restaurant := body.Restaurant
+    restaurant.ID, restaurant.Version, restaurant.TenantID, restaurant.UserID = 0, 0, 0, int(userID)
+    restaurantID, err := db.insertRestaurant(restaurant)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    user, err := db.GetUserByID(int(userID))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    created, err := db.GetRestaurantByID(int(restaurantID))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, ownerResponse{User: user.Admin(), Restaurant: created})
+}
+
+// serveCreateRestaurant создает ресторан; id, version и tenant_id тела не учитываются. Пользователь, вошедший
+// через -auth, добавляет ресторан только себе, если он не администратор (см. authorizeNewRestaurant)
+func (db *Database) serveCreateRestaurant(w http.ResponseWriter, r *http.Request) {
+    var body Restaurant
+    if err := decodeBody(r, &body); err != nil {
+        writeError(w, err)
+        return
+    }
+    body.ID, body.Version, body.TenantID = 0, 0, 0
+    actor, _ := requestActor(r)
+    if err := db.authorizeNewRestaurant(actor, body); err != nil {
+        writeError(w, err)
+        return
+    }
+    db = db.WithIdempotencyKey(r.Header.Get("Idempotency-Key"))
+    id, err := db.insertRestaurant(body)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    restaurant, err := db.WithPrimary().GetRestaurantByID(int(id))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, restaurant)
+}
+
+// requestActor возвращает ID пользователя, аутентифицированного httpapi.Authenticator; ok == false,
+// если сервер запущен без -auth или запрос обслуживается анонимно: тогда ID - SystemActor, и владелец
+// не проверяется (см. contextActor)
+func requestActor(r *http.Request) (int, bool) {
+    return contextActor(r.Context())
+}
+
+// unknownActor - ID аутентифицированного субъекта, который не является пользователем площадки: у него нет прав
+const unknownActor = -1
+
+// contextActor возвращает ID пользователя, аутентифицированного httpapi.Authenticator, из контекста
+// запроса, в том числе в резолверах GraphQL; без аутентификации - SystemActor и ok == false
+func contextActor(ctx context.Context) (int, bool) {
+    user, ok := httpapi.UserFromContext(ctx)
+    if !ok {
+        return SystemActor, false
+    }
+    id, err := strconv.Atoi(user)
+    if err != nil || id <= 0 {
+        return unknownActor, true
+    }
+    return id, true
+}
+
+// decodeBody читает тело запроса - объект JSON - в value; неизвестные поля - ошибка
+func decodeBody(r *http.Request, value interface{}) error {
+    decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(value); err != nil {
+        return &ValidationError{Field: "body", Message: "must be a JSON object: " + err.Error()}
+    }
+    return nil
+}
+
+// writeError отвечает ошибкой с кодом по ее виду; подробности внутренних ошибок пишутся только в лог
+func writeError(w http.ResponseWriter, err error) {
+    status := errorStatus(err)
+    message := err.Error()
+    if status == http.StatusInternalServerError {
+        log.Printf("http: %v", err)
+        message = http.StatusText(status)
+    }
+    writeJSON(w, status, apiError{Error: message})
+}
+
+// statusClientClosedRequest - код nginx для запроса, клиент которого отключился до ответа:
+// ответ никто не прочтет, но в журнале доступа он отличается от ошибок сервера
+const statusClientClosedRequest = 499
+
+// errorStatus возвращает код ответа HTTP для ошибки по ее виду
+func errorStatus(err error) int {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, ErrValidation):
+        status = http.StatusBadRequest
+    case errors.Is(err, ErrNotFound):
+        status = http.StatusNotFound
+    case errors.Is(err, ErrForeignKeyViolation):
+        status = http.StatusUnprocessableEntity
+    case errors.Is(err, ErrConflict):
+        status = http.StatusConflict
+    case errors.Is(err, ErrPermissionDenied):
+        status = http.StatusForbidden
+    case errors.Is(err, ErrRowLimit):
+        // выборка больше SetMaxRows: тот же запрос без фильтра или страницы снова упрется в предел
+        status = http.StatusUnprocessableEntity
+    case errors.Is(err, ErrBudgetExceeded):
+        status = http.StatusTooManyRequests
+    case errors.Is(err, ErrMaintenance), errors.Is(err, ErrClosed), errors.Is(err, ErrCircuitOpen):
+        status = http.StatusServiceUnavailable
+    case errors.Is(err, context.DeadlineExceeded):
+        status = http.StatusGatewayTimeout
+    case errors.Is(err, context.Canceled):
+        status = statusClientClosedRequest
+    }
+    return status
+}
+
+// apiError - тело ответа с ошибкой
+type apiError struct {
+    Error string `json:"error"`
+    // Violations - нарушения в присланном документе с путями до значений (см. ImportListings)
+    Violations []SchemaViolation `json:"violations,omitempty"`
+}
+
+// writeJSON отвечает значением в JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    encoder := json.NewEncoder(w)
+    // ссылки на страницы содержат &, который иначе превратился бы в \u0026
+    encoder.SetEscapeHTML(false)
+    if err := encoder.Encode(value); err != nil {
+        log.Printf("http: write response: %v", err)
+    }
+}
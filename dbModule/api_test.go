@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want int
+    }{
+        {"validation", &ValidationError{Field: "name", Message: "is required"}, http.StatusBadRequest},
+        {"invariants", &InvariantError{Entity: "restaurant", Violations: []*ValidationError{{Field: "name", Message: "is required"}}}, http.StatusBadRequest},
+        {"not found", ErrNotFound, http.StatusNotFound},
+        {"not found in OpError", &OpError{Op: "get", Entity: "restaurant", Key: 7, Kind: ErrNotFound, Err: sql.ErrNoRows}, http.StatusNotFound},
+        {"foreign key", ErrForeignKeyViolation, http.StatusUnprocessableEntity},
+        {"restricted delete", &RestrictedDeleteError{Entity: "user", ID: 1, ReferencedBy: "restaurants"}, http.StatusUnprocessableEntity},
+        {"conflict", ErrConflict, http.StatusConflict},
+        {"stale version", fmt.Errorf("version 3: %w", ErrStaleVersion), http.StatusConflict},
+        {"locked", ErrLocked, http.StatusConflict},
+        {"slot full", ErrSlotFull, http.StatusConflict},
+        {"permission denied", ErrPermissionDenied, http.StatusForbidden},
+        {"forbidden", &OpError{Op: "update", Entity: "restaurant", Key: 7, Err: ErrForbidden}, http.StatusForbidden},
+        {"maintenance", ErrMaintenance, http.StatusServiceUnavailable},
+        {"closed", fmt.Errorf("select users: %w", ErrClosed), http.StatusServiceUnavailable},
+        {"circuit open", ErrCircuitOpen, http.StatusServiceUnavailable},
+        {"row limit", &RowLimitError{Query: "restaurants.select", Limit: 100}, http.StatusUnprocessableEntity},
+        {"budget exceeded", fmt.Errorf("users.select: %w", ErrBudgetExceeded), http.StatusTooManyRequests},
+        {"timeout", &QueryError{Query: "restaurants.select", Op: "read", Err: fmt.Errorf("restaurants.select exceeded read timeout 5s: %w", context.DeadlineExceeded)}, http.StatusGatewayTimeout},
+        {"canceled", &QueryError{Query: "restaurants.select", Op: "read", Err: context.Canceled}, statusClientClosedRequest},
+        {"unknown", errors.New("no such column: foo"), http.StatusInternalServerError},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := errorStatus(tt.err); got != tt.want {
+                t.Errorf("errorStatus(%q) = %d, want %d", tt.err, got, tt.want)
+            }
+        })
+    }
+}
+
+// cancelOnWrite - ответ, который отменяет запрос при первой записи: так клиент "отключается"
+// в середине выгрузки, когда первые строки уже отправлены, а цикл по строкам еще идет
+type cancelOnWrite struct {
+    *httptest.ResponseRecorder
+    cancel context.CancelFunc
+}
+
+func (w cancelOnWrite) Write(p []byte) (int, error) {
+    w.cancel()
+    return w.ResponseRecorder.Write(p)
+}
+
+func TestExportCanceledMidStream(t *testing.T) {
+    db := NewTestDatabase(t)
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Cancel-Passw0rd!", Role: RoleOwner}
+    if _, err := db.InsertUserReturningID(&owner); err != nil {
+        t.Fatal(err)
+    }
+    const total = 2000
+    err := db.InTx(func(tx *Database) error {
+        for i := 0; i < total; i++ {
+            restaurant := Restaurant{Name: fmt.Sprintf("Restaurant %d", i), Type: "cafe", AveragePrice: PriceTierBudget, UserID: owner.ID}
+            if err := tx.InsertRestaurant(restaurant); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var logged bytes.Buffer
+    log.SetOutput(&logged)
+    t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    request := httptest.NewRequest("GET", "/export/restaurants", nil).WithContext(ctx)
+    recorder := httptest.NewRecorder()
+    // ServeHTTP возвращается только после выхода из цикла по строкам
+    db.Handler().ServeHTTP(cancelOnWrite{ResponseRecorder: recorder, cancel: cancel}, request)
+
+    if sent := strings.Count(recorder.Body.String(), "\n"); sent == 0 || sent >= total {
+        t.Errorf("export sent %d of %d rows, want it to stop after the client is gone", sent, total)
+    }
+    if !strings.Contains(logged.String(), context.Canceled.Error()) {
+        t.Errorf("export did not fail with %v, log: %s", context.Canceled, logged.String())
+    }
+    // незакрытые строки держали бы соединение пула занятым
+    if inUse := db.DB.Stats().InUse; inUse != 0 {
+        t.Errorf("%d connections still in use, rows were not closed", inUse)
+    }
+}
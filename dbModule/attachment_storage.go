@@ -0,0 +1,258 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// AttachmentStorage хранит содержимое вложений по ключу; метаданные лежат в таблице attachments.
+// Реализации: LocalStorage (каталог) и S3Storage (S3 и совместимые с ним хранилища)
+type AttachmentStorage interface {
+    // Put сохраняет содержимое r под ключом key и возвращает его размер
+    Put(ctx context.Context, key, contentType string, r io.Reader) (int64, error)
+    // Open открывает содержимое для потокового чтения; ErrNotFound, если ключа нет
+    Open(ctx context.Context, key string) (io.ReadCloser, error)
+    // Delete удаляет содержимое; отсутствующий ключ не считается ошибкой
+    Delete(ctx context.Context, key string) error
+}
+
+// LocalStorage хранит вложения файлами в каталоге: ключ - относительный путь файла
+type LocalStorage struct {
+    dir string
+}
+
+// NewLocalStorage создает хранилище вложений в каталоге dir
+func NewLocalStorage(dir string) *LocalStorage {
+    return &LocalStorage{dir: dir}
+}
+
+// path возвращает путь к файлу ключа, не выпуская его за пределы каталога
+func (s *LocalStorage) path(key string) (string, error) {
+    clean := filepath.Clean(filepath.FromSlash(key))
+    if filepath.IsAbs(clean) || clean == "." || strings.HasPrefix(clean, "..") {
+        return "", fmt.Errorf("invalid attachment key %q", key)
+    }
+    return filepath.Join(s.dir, clean), nil
+}
+
+// Put записывает содержимое во временный файл и атомарно переименовывает его
+func (s *LocalStorage) Put(ctx context.Context, key, contentType string, r io.Reader) (int64, error) {
+    path, err := s.path(key)
+    if err != nil {
+        return 0, err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return 0, err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+    if err != nil {
+        return 0, err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+
+    size, err := io.Copy(tmp, r)
+    if err != nil {
+        return 0, err
+    }
+    if err := tmp.Sync(); err != nil {
+        return 0, err
+    }
+    if err := tmp.Close(); err != nil {
+        return 0, err
+    }
+    return size, os.Rename(tmp.Name(), path)
+}
+
+// Open открывает файл ключа
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+    path, err := s.path(key)
+    if err != nil {
+        return nil, err
+    }
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("attachment %s: %w", key, ErrNotFound)
+    }
+    return f, err
+}
+
+// Delete удаляет файл ключа
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+    path, err := s.path(key)
+    if err != nil {
+        return err
+    }
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// S3Config - параметры бакета S3
+type S3Config struct {
+    // Endpoint - адрес сервиса, например https://s3.eu-central-1.amazonaws.com или http://127.0.0.1:9000 (MinIO)
+    Endpoint string
+    Region   string
+    Bucket   string
+    // Prefix добавляется к ключам вложений, например attachments/
+    Prefix    string
+    AccessKey string
+    SecretKey string
+}
+
+// S3Storage хранит вложения в бакете S3 через REST API с подписью AWS Signature Version 4.
+// Адреса объектов строятся в path-style (<endpoint>/<bucket>/<key>), который понимают и AWS, и MinIO
+type S3Storage struct {
+    config S3Config
+    client *http.Client
+}
+
+// NewS3Storage создает хранилище вложений в бакете S3
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+    if config.Endpoint == "" || config.Bucket == "" || config.Region == "" {
+        return nil, fmt.Errorf("S3 storage needs an endpoint, a region and a bucket")
+    }
+    if config.AccessKey == "" || config.SecretKey == "" {
+        return nil, fmt.Errorf("S3 storage needs an access key and a secret key")
+    }
+    config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+    return &S3Storage{config: config, client: &http.Client{}}, nil
+}
+
+// objectURL возвращает адрес объекта ключа
+func (s *S3Storage) objectURL(key string) string {
+    return s.config.Endpoint + "/" + s.config.Bucket + "/" + (&url.URL{Path: s.config.Prefix + key}).EscapedPath()
+}
+
+// Put загружает объект. S3 требует размер тела заранее, поэтому содержимое сначала пишется
+// во временный файл, а его SHA-256 входит в подпись запроса
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, r io.Reader) (int64, error) {
+    tmp, err := os.CreateTemp("", "dbmodule-s3-*")
+    if err != nil {
+        return 0, err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+
+    h := sha256.New()
+    size, err := io.Copy(io.MultiWriter(tmp, h), r)
+    if err != nil {
+        return 0, err
+    }
+    if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+        return 0, err
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), tmp)
+    if err != nil {
+        return 0, err
+    }
+    req.ContentLength = size
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    resp, err := s.do(req, hex.EncodeToString(h.Sum(nil)))
+    if err != nil {
+        return 0, err
+    }
+    resp.Body.Close()
+    return size, nil
+}
+
+// Open скачивает объект потоком
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := s.do(req, emptySHA256)
+    if err != nil {
+        return nil, err
+    }
+    return resp.Body, nil
+}
+
+// Delete удаляет объект; S3 отвечает успехом и на отсутствующий ключ
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+    if err != nil {
+        return err
+    }
+    resp, err := s.do(req, emptySHA256)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// emptySHA256 - SHA-256 пустого тела запроса в hex
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do подписывает и выполняет запрос; ответ с ошибкой превращается в error, 404 - в ErrNotFound
+func (s *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+    s.sign(req, payloadHash, time.Now().UTC())
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 == 2 {
+        return resp, nil
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, fmt.Errorf("S3 object %s: %w", req.URL.Path, ErrNotFound)
+    }
+    return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign добавляет к запросу заголовки подписи AWS Signature Version 4 с подписанными host,
+// x-amz-content-sha256, x-amz-date и, если задан, content-type
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+    amzDate := now.Format("20060102T150405Z")
+    day := now.Format("20060102")
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+    names := []string{"host"}
+    headers := "host:" + req.URL.Host + "\n"
+    if contentType := req.Header.Get("Content-Type"); contentType != "" {
+        names = append([]string{"content-type"}, names...)
+        headers = "content-type:" + strings.TrimSpace(contentType) + "\n" + headers
+    }
+    names = append(names, "x-amz-content-sha256", "x-amz-date")
+    headers += "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n"
+    signedHeaders := strings.Join(names, ";")
+
+    canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers, signedHeaders, payloadHash}, "\n")
+    scope := day + "/" + s.config.Region + "/s3/aws4_request"
+    canonicalHash := sha256.Sum256([]byte(canonical))
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+    key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), day)
+    for _, part := range []string{s.config.Region, "s3", "aws4_request"} {
+        key = hmacSHA256(key, part)
+    }
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 возвращает HMAC-SHA256 data с ключом key
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// AttachmentTimeout ограничивает одну операцию с хранилищем вложений: загрузку, удаление или открытие
+var AttachmentTimeout = 5 * time.Minute
+
+// Attachment - метаданные файла, прикрепленного к записи (например, фото ресторана)
+type Attachment struct {
+    ID       int `json:"id"`
+    TenantID int `json:"tenant_id"`
+    // EntityType и EntityID - запись, к которой прикреплен файл, например "restaurant" и ее ID
+    EntityType  string `json:"entity_type"`
+    EntityID    int    `json:"entity_id"`
+    Filename    string `json:"filename"`
+    ContentType string `json:"content_type"`
+    Size        int64  `json:"size"`
+    // StorageKey - ключ содержимого в AttachmentStorage
+    StorageKey string    `json:"storage_key"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// attachmentEntities - записи, к которым можно прикреплять файлы, и проверка, что запись есть на площадке.
+// Внешнего ключа у attachments нет, поэтому вложения удаленных записей находит fsck
+var attachmentEntities = map[string]func(db *Database, id int) (bool, error){
+    "restaurant": func(db *Database, id int) (bool, error) {
+        restaurant, err := db.findRestaurant("restaurants.select_by_id", id, db.tenant)
+        return restaurant != nil, err
+    },
+    "user": func(db *Database, id int) (bool, error) {
+        user, err := db.findUser("users.select_by_id", id, db.tenant)
+        return user != nil, err
+    },
+    "menu_item": func(db *Database, id int) (bool, error) {
+        item, err := db.findMenuItem(id)
+        return item != nil, err
+    },
+    "review": func(db *Database, id int) (bool, error) {
+        review, err := db.findReview(id)
+        return review != nil, err
+    },
+}
+
+// Attachments хранит вложения: метаданные в таблице attachments текущей площадки,
+// содержимое - в storage
+type Attachments struct {
+    db      *Database
+    storage AttachmentStorage
+}
+
+// NewAttachments создает хранилище вложений с содержимым в storage
+func NewAttachments(db *Database, storage AttachmentStorage) *Attachments {
+    return &Attachments{db: db, storage: storage}
+}
+
+// Add сохраняет содержимое r и прикрепляет его к записи entityType с ID entityID.
+// Содержимое загружается до записи метаданных; если запись не удалась, оно удаляется
+func (a *Attachments) Add(entityType string, entityID int, filename, contentType string, r io.Reader) (Attachment, error) {
+    exists, ok := attachmentEntities[entityType]
+    if !ok {
+        return Attachment{}, a.db.opError("add", "attachment", filename, fmt.Errorf("%w: cannot attach files to %q", ErrValidation, entityType))
+    }
+    found, err := exists(a.db, entityID)
+    if err == nil && !found {
+        err = fmt.Errorf("%s %d: %w", entityType, entityID, ErrNotFound)
+    }
+    if err != nil {
+        return Attachment{}, a.db.opError("add", "attachment", filename, err)
+    }
+
+    id, err := a.db.newID()
+    if err != nil {
+        return Attachment{}, err
+    }
+    attachment := Attachment{
+        TenantID:    a.db.tenant,
+        EntityType:  entityType,
+        EntityID:    entityID,
+        Filename:    filepath.Base(filename),
+        ContentType: contentType,
+        StorageKey:  fmt.Sprintf("%d/%s/%d/%s", a.db.tenant, entityType, entityID, id),
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), AttachmentTimeout)
+    defer cancel()
+    if attachment.Size, err = a.storage.Put(ctx, attachment.StorageKey, contentType, r); err != nil {
+        return Attachment{}, a.db.opError("add", "attachment", filename, err)
+    }
+
+    err = a.db.InTx(func(tx *Database) error {
+        // запись могли удалить, пока загружалось содержимое
+        if found, err := exists(tx, entityID); err != nil || !found {
+            return notFoundIfNil(err)
+        }
+        now := tx.now().UTC()
+        id, err := tx.insertNamed("attachments.insert", tx.tenant, entityType, entityID, attachment.Filename, contentType, attachment.Size, attachment.StorageKey, now)
+        if err != nil {
+            return err
+        }
+        attachment.ID, attachment.CreatedAt = int(id), now
+        return tx.audit("attachment", attachment.ID, AuditInsert, nil, attachment)
+    })
+    if err != nil {
+        if deleteErr := a.storage.Delete(ctx, attachment.StorageKey); deleteErr != nil {
+            log.Printf("attachment %s: removing content after failed insert: %v", attachment.StorageKey, deleteErr)
+        }
+        return Attachment{}, a.db.opError("add", "attachment", filename, err)
+    }
+    return attachment, nil
+}
+
+// Get возвращает метаданные вложения или ErrNotFound
+func (a *Attachments) Get(id int) (Attachment, error) {
+    attachment, err := a.db.findAttachment(id)
+    if err == nil && attachment == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return Attachment{}, a.db.opError("get", "attachment", id, err)
+    }
+    return *attachment, nil
+}
+
+// Open возвращает метаданные вложения и его содержимое для потокового чтения; содержимое нужно закрыть
+func (a *Attachments) Open(id int) (Attachment, io.ReadCloser, error) {
+    attachment, err := a.Get(id)
+    if err != nil {
+        return Attachment{}, nil, err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), AttachmentTimeout)
+    content, err := a.storage.Open(ctx, attachment.StorageKey)
+    if err != nil {
+        cancel()
+        return Attachment{}, nil, a.db.opError("open", "attachment", id, err)
+    }
+    return attachment, cancelOnClose{ReadCloser: content, cancel: cancel}, nil
+}
+
+// cancelOnClose освобождает контекст чтения содержимого при его закрытии
+type cancelOnClose struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+// Close закрывает содержимое и освобождает контекст
+func (c cancelOnClose) Close() error {
+    defer c.cancel()
+    return c.ReadCloser.Close()
+}
+
+// List возвращает вложения записи в порядке добавления
+func (a *Attachments) List(entityType string, entityID int) ([]Attachment, error) {
+    rows, err := a.db.queryNamed("attachments.select_by_entity", entityType, entityID, a.db.tenant)
+    if err != nil {
+        return nil, a.db.opError("list", "attachments", entityID, err)
+    }
+    defer rows.Close()
+
+    var attachments []Attachment
+    for rows.Next() {
+        attachment, err := scanAttachment(rows)
+        if err != nil {
+            return nil, a.db.opError("list", "attachments", entityID, err)
+        }
+        attachments = append(attachments, attachment)
+    }
+    return attachments, a.db.opError("list", "attachments", entityID, rows.Err())
+}
+
+// Update сохраняет новое имя файла и тип содержимого; само содержимое и запись не меняются
+func (a *Attachments) Update(attachment *Attachment) error {
+    err := a.db.InTx(func(tx *Database) error {
+        old, err := tx.findAttachment(attachment.ID)
+        if err != nil || old == nil {
+            return notFoundIfNil(err)
+        }
+        filename := filepath.Base(attachment.Filename)
+        if _, err := tx.execNamed("attachments.update", filename, attachment.ContentType, attachment.ID, tx.tenant); err != nil {
+            return err
+        }
+        current := *old
+        current.Filename, current.ContentType = filename, attachment.ContentType
+        *attachment = current
+        return tx.audit("attachment", attachment.ID, AuditUpdate, old, current)
+    })
+    return a.db.opError("update", "attachment", attachment.ID, err)
+}
+
+// Delete удаляет вложение: сначала метаданные, после фиксации - содержимое.
+// Если содержимое удалить не удалось, возвращается ошибка, но метаданных уже нет
+func (a *Attachments) Delete(id int) error {
+    var old *Attachment
+    err := a.db.InTx(func(tx *Database) error {
+        var err error
+        if old, err = tx.findAttachment(id); err != nil || old == nil {
+            return notFoundIfNil(err)
+        }
+        if _, err := tx.execNamed("attachments.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        return tx.audit("attachment", id, AuditDelete, old, nil)
+    })
+    if err != nil {
+        return a.db.opError("delete", "attachment", id, err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), AttachmentTimeout)
+    defer cancel()
+    return a.db.opError("delete", "attachment", id, a.storage.Delete(ctx, old.StorageKey))
+}
+
+// findAttachment читает вложение текущей площадки; nil, если его нет
+func (db *Database) findAttachment(id int) (*Attachment, error) {
+    rows, err := db.queryNamed("attachments.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    attachment, err := scanAttachment(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &attachment, rows.Close()
+}
+
+// scanAttachment читает вложение из текущей строки
+func scanAttachment(row *queryRows) (Attachment, error) {
+    var a Attachment
+    err := row.Scan(&a.ID, &a.TenantID, &a.EntityType, &a.EntityID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.CreatedAt)
+    return a, err
+}
+
+// runAttachments управляет вложениями: attachments list|add|get|delete
+func runAttachments(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: attachments list -entity restaurant -id N | add -entity restaurant -id N -file photo.jpg | get -id N -o file | delete -id N")
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("attachments", flag.ContinueOnError)
+    entity := flags.String("entity", "restaurant", "type of the record: restaurant, user, menu_item or review")
+    id := flags.Int("id", 0, "record ID for list and add, attachment ID for get and delete")
+    file := flags.String("file", "", "file to attach")
+    contentType := flags.String("content-type", "", "content type of the file (default: guessed from its extension)")
+    output := flags.String("o", "", "where get writes the content (default: standard output)")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+    storage, err := newAttachmentStorage()
+    if err != nil {
+        return err
+    }
+    attachments := NewAttachments(db, storage)
+
+    switch args[0] {
+    case "list":
+        list, err := attachments.List(*entity, *id)
+        if err != nil {
+            return err
+        }
+        for _, a := range list {
+            fmt.Printf("%d | %s | %s | %d bytes | %s\n", a.ID, a.Filename, a.ContentType, a.Size, a.CreatedAt.Format(time.RFC3339))
+        }
+        return nil
+    case "add":
+        f, err := os.Open(*file)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        if *contentType == "" {
+            *contentType = mime.TypeByExtension(filepath.Ext(*file))
+        }
+        attachment, err := attachments.Add(*entity, *id, *file, *contentType, f)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("attachment %d: %s, %d bytes\n", attachment.ID, attachment.StorageKey, attachment.Size)
+        return nil
+    case "get":
+        _, content, err := attachments.Open(*id)
+        if err != nil {
+            return err
+        }
+        defer content.Close()
+        var w io.Writer = os.Stdout
+        if *output != "" {
+            f, err := os.Create(*output)
+            if err != nil {
+                return err
+            }
+            defer f.Close()
+            w = f
+        }
+        _, err = io.Copy(w, content)
+        return err
+    case "delete":
+        return attachments.Delete(*id)
+    }
+    return usage
+}
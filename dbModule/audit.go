@@ -0,0 +1,123 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "time"
+)
+
+// Действия, записываемые в журнал аудита
+const (
+    AuditInsert = "insert"
+    AuditUpdate = "update"
+    AuditDelete = "delete"
+)
+
+// AuditEntry - запись журнала аудита об одном изменении строки
+type AuditEntry struct {
+    ID       int64
+    Entity   string
+    EntityID int
+    Action   string
+    Actor    string
+    // CorrelationID - идентификатор операции, в которой сделано изменение (см. WithCorrelationID)
+    CorrelationID string
+    // OldValue и NewValue - JSON строки до и после изменения; пусто для insert и delete соответственно.
+    // Пароли пользователей в журнал не попадают
+    OldValue  string
+    NewValue  string
+    CreatedAt time.Time
+}
+
+// WithActor возвращает копию Database, изменения через которую записываются в журнал аудита
+// от имени actor (пользователь, сервис или задача). Соединение и площадка общие с исходной Database
+func (db *Database) WithActor(actor string) *Database {
+    scoped := *db
+    scoped.actor = actor
+    return &scoped
+}
+
+// AuditTrail возвращает историю изменений записи в порядке их выполнения,
+// например AuditTrail("user", 42)
+func (db *Database) AuditTrail(entity string, id int) ([]AuditEntry, error) {
+    rows, err := db.queryNamed("audit_log.select_by_entity", entity, id, db.tenant)
+    if err != nil {
+        return nil, db.opError("audit trail", entity, id, err)
+    }
+    defer rows.Close()
+
+    var entries []AuditEntry
+    for rows.Next() {
+        var entry AuditEntry
+        var actor, oldValue, newValue, correlationID sql.NullString
+        if err := rows.Scan(&entry.ID, &entry.Entity, &entry.EntityID, &entry.Action, &actor, &oldValue, &newValue, &entry.CreatedAt, &correlationID); err != nil {
+            return nil, db.opError("audit trail", entity, id, err)
+        }
+        entry.Actor, entry.OldValue, entry.NewValue, entry.CorrelationID = actor.String, oldValue.String, newValue.String, correlationID.String
+        entries = append(entries, entry)
+    }
+    return entries, db.opError("audit trail", entity, id, rows.Err())
+}
+
+// audit записывает изменение строки в журнал и в историю версий (см. SetHistory), сбрасывает ее
+// из кеша записей и вызывает обработчики сущности (см. RegisterHooks). Вызывается в той же транзакции, что и изменение,
+// чтобы запись журнала и изменение фиксировались или откатывались вместе
+func (db *Database) audit(entity string, id int, action string, oldValue, newValue interface{}) error {
+    db.invalidateEntity(entity, id, action)
+
+    sealedOld, err := db.sealedAuditValue(oldValue)
+    if err != nil {
+        return err
+    }
+    sealedNew, err := db.sealedAuditValue(newValue)
+    if err != nil {
+        return err
+    }
+    before, err := auditJSON(sealedOld)
+    if err != nil {
+        return err
+    }
+    after, err := auditJSON(sealedNew)
+    if err != nil {
+        return err
+    }
+    correlationID := db.correlationID()
+    if _, err := db.execNamed("audit_log.insert", db.tenant, entity, id, action, sql.NullString{String: db.actor, Valid: db.actor != ""}, before, after, db.now().UTC(),
+        sql.NullString{String: correlationID, Valid: correlationID != ""}); err != nil {
+        return err
+    }
+    if err := db.recordHistory(entity, id, action, before, after); err != nil {
+        return err
+    }
+    payload := after
+    if action == AuditDelete {
+        payload = before
+    }
+    if !payload.Valid {
+        payload.String = "null"
+    }
+    if err := db.recordChange(entity, id, action, json.RawMessage(payload.String)); err != nil {
+        return err
+    }
+    return db.afterChange(entity, action, oldValue, newValue)
+}
+
+// auditChange записывает результат upsert: вставку, если строки до него не было, иначе обновление
+func (db *Database) auditChange(entity string, id int, oldValue, newValue interface{}, inserted bool) error {
+    if inserted {
+        return db.audit(entity, id, AuditInsert, nil, newValue)
+    }
+    return db.audit(entity, id, AuditUpdate, oldValue, newValue)
+}
+
+// auditJSON сериализует значение для журнала; nil (в том числе nil-указатель) становится NULL
+func auditJSON(value interface{}) (sql.NullString, error) {
+    if value == nil {
+        return sql.NullString{}, nil
+    }
+    data, err := json.Marshal(value)
+    if err != nil || string(data) == "null" {
+        return sql.NullString{}, err
+    }
+    return sql.NullString{String: string(data), Valid: true}, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "strings"
+)
+
+// backupObject - таблица, индекс, представление или триггер в sqlite_master
+type backupObject struct {
+    kind string
+    name string
+    sql  string
+}
+
+// Backup сохраняет согласованный снимок работающей базы SQLite в файл path через VACUUM INTO.
+// Приложение можно не останавливать: снимок делается в одной читающей транзакции.
+// Файл path не должен существовать
+func (db *Database) Backup(path string) error {
+    if err := db.requireSQLite("backup"); err != nil {
+        return db.opError("backup", "database", path, err)
+    }
+    _, err := db.execNamed("backup.vacuum_into", path)
+    return db.opError("backup", "database", path, err)
+}
+
+// RestoreFrom заменяет содержимое базы данными из снимка, сделанного Backup.
+// Схема и данные переносятся в одной транзакции, поэтому при ошибке база остается прежней.
+// С префиксом таблиц (см. Config.TablePrefix) заменяются только объекты этого экземпляра
+func (db *Database) RestoreFrom(path string) error {
+    if err := db.requireSQLite("restore"); err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+
+    done, err := db.beginOperation()
+    if err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    defer done()
+
+    // ATTACH действует только на одно соединение, поэтому все шаги выполняются на выделенном
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    defer conn.Close()
+
+    if err := db.execOn(ctx, conn, "backup.attach", path); err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    defer db.execOn(ctx, conn, "backup.detach")
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    defer tx.Rollback()
+
+    // внешние ключи проверяются при фиксации, когда все таблицы уже заполнены
+    if err := db.execOn(ctx, tx, "backup.defer_foreign_keys"); err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+
+    current, err := db.selectObjects(ctx, tx, "backup.select_main_objects")
+    if err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    for _, object := range current {
+        if !db.ownTable(object.name) {
+            continue
+        }
+        if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP %s main.%s;", strings.ToUpper(object.kind), quoteIdentifier(object.name))); err != nil {
+            return db.opError("restore", "database", path, fmt.Errorf("drop %s %s: %w", object.kind, object.name, err))
+        }
+    }
+
+    objects, err := db.selectObjects(ctx, tx, "backup.select_backup_objects")
+    if err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    for _, object := range objects {
+        if !db.ownTable(object.name) {
+            continue
+        }
+        if _, err := tx.ExecContext(ctx, object.sql); err != nil {
+            return db.opError("restore", "database", path, fmt.Errorf("create %s %s: %w", object.kind, object.name, err))
+        }
+        if object.kind != "table" {
+            continue
+        }
+        name := quoteIdentifier(object.name)
+        if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM backup.%s;", name, name)); err != nil {
+            return db.opError("restore", "database", path, fmt.Errorf("copy table %s: %w", object.name, err))
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return db.opError("restore", "database", path, err)
+    }
+    db.clearEntityCache()
+    return nil
+}
+
+// requireSQLite возвращает ошибку, если текущий драйвер не SQLite
+func (db *Database) requireSQLite(operation string) error {
+    if name := db.driver.dialect.Name(); name != "sqlite" {
+        return fmt.Errorf("%s is only supported for SQLite, current dialect is %s", operation, name)
+    }
+    return nil
+}
+
+// sqlExecer - выполнение запросов с контекстом, общее для sql.Conn и sql.Tx
+type sqlExecer interface {
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execOn выполняет именованный запрос на конкретном соединении или транзакции
+func (db *Database) execOn(ctx context.Context, conn sqlExecer, name string, args ...interface{}) error {
+    query, err := db.lookupQuery(name)
+    if err != nil {
+        return err
+    }
+    _, err = conn.ExecContext(ctx, db.driver.dialect.Rebind(query), args...)
+    return err
+}
+
+// selectObjects читает список объектов схемы именованным запросом
+func (db *Database) selectObjects(ctx context.Context, conn sqlExecer, name string, args ...interface{}) ([]backupObject, error) {
+    query, err := db.lookupQuery(name)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var objects []backupObject
+    for rows.Next() {
+        var object backupObject
+        if err := rows.Scan(&object.kind, &object.name, &object.sql); err != nil {
+            return nil, err
+        }
+        objects = append(objects, object)
+    }
+    return objects, rows.Err()
+}
+
+// quoteIdentifier заключает имя таблицы в двойные кавычки
+func quoteIdentifier(name string) string {
+    return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// runBackup сохраняет снимок базы в файл
+func runBackup(db *Database, args []string) error {
+    path, err := parsePathArg("backup", args)
+    if err != nil {
+        return err
+    }
+    if err := db.Backup(path); err != nil {
+        return err
+    }
+    fmt.Printf("Database saved to %s\n", path)
+    return nil
+}
+
+// runRestore восстанавливает базу из снимка
+func runRestore(db *Database, args []string) error {
+    path, err := parsePathArg("restore", args)
+    if err != nil {
+        return err
+    }
+    if err := db.RestoreFrom(path); err != nil {
+        return err
+    }
+    fmt.Printf("Database restored from %s\n", path)
+    return nil
+}
+
+// parsePathArg разбирает единственный позиционный аргумент команды - путь к файлу снимка
+func parsePathArg(name string, args []string) (string, error) {
+    flags := flag.NewFlagSet(name, flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return "", err
+    }
+    if flags.NArg() != 1 {
+        return "", fmt.Errorf("usage: %s <file>", name)
+    }
+    return flags.Arg(0), nil
+}
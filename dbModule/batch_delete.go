@@ -0,0 +1,249 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// UserFilter задает условия выборки пользователей для DeleteUsersWhere; пустые поля не ограничивают выборку.
+// Условия по email и телефону нет: они могут храниться зашифрованными (см. PIIKeysEnv)
+type UserFilter struct {
+    Role       string
+    NamePrefix string
+    IDs        []int
+}
+
+// empty сообщает, что фильтр не задает ни одного условия и подходит всем пользователям
+func (filter UserFilter) empty() bool {
+    return filter.Role == "" && filter.NamePrefix == "" && len(filter.IDs) == 0
+}
+
+// applyWhere добавляет в запрос условия фильтра
+func (filter UserFilter) applyWhere(query *SelectBuilder) {
+    if filter.Role != "" {
+        query.Where("role = ?", filter.Role)
+    }
+    if filter.NamePrefix != "" {
+        query.Where(`name LIKE ? ESCAPE '\'`, escapeLike(filter.NamePrefix)+"%")
+    }
+    if len(filter.IDs) > 0 {
+        args := make([]interface{}, len(filter.IDs))
+        for i, id := range filter.IDs {
+            args[i] = id
+        }
+        query.Where("id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(filter.IDs)), ", ")+")", args...)
+    }
+}
+
+// DeleteUsersWhere удаляет пользователей площадки, подходящих под фильтр, одной транзакцией и возвращает
+// их число. Рестораны пользователей обрабатываются по policy, как в DeleteUser: с DeleteRestrict
+// владелец ресторанов отменяет все удаление. Пустой фильтр запрещен, все данные удаляет TruncateAll
+func (db *Database) DeleteUsersWhere(filter UserFilter, policy DeletePolicy) (int64, error) {
+    if filter.empty() {
+        return 0, db.opError("delete", "users", nil, fmt.Errorf("%w: empty filter matches every user, use TruncateAll to remove all data", ErrValidation))
+    }
+    var deleted int64
+    err := db.InTx(func(tx *Database) error {
+        query, err := tx.selectNamed("users.select_filtered")
+        if err != nil {
+            return err
+        }
+        query.Where("tenant_id = ?", tx.tenant)
+        filter.applyWhere(query)
+        query.OrderBy("id", false)
+        rows, err := tx.queryBuilt("users.select_filtered", query)
+        if err != nil {
+            return err
+        }
+        // удаляются все подходящие пользователи, сколько бы их ни было
+        users, err := collectRows(tx.WithMaxRows(0), rows, tx.scanUser)
+        if err != nil {
+            return err
+        }
+        for _, user := range users {
+            if err := tx.DeleteUser(user.ID, policy); err != nil {
+                return err
+            }
+        }
+        deleted = int64(len(users))
+        return nil
+    })
+    if err != nil {
+        return 0, db.opError("delete", "users", nil, err)
+    }
+    return deleted, nil
+}
+
+// DeleteRestaurantsWhere удаляет рестораны площадки, подходящие под фильтр (Limit и Offset учитываются),
+// одной транзакцией и возвращает их число. Меню, отзывы и другие записи ресторанов удаляются вместе
+// с ними, каждое удаление попадает в журнал аудита. Пустой фильтр запрещен, все данные удаляет TruncateAll
+func (db *Database) DeleteRestaurantsWhere(filter RestaurantFilter) (int64, error) {
+    if len(filter.values()) == 0 {
+        return 0, db.opError("delete", "restaurants", nil, fmt.Errorf("%w: empty filter matches every restaurant, use TruncateAll to remove all data", ErrValidation))
+    }
+    var deleted int64
+    err := db.InTx(func(tx *Database) error {
+        restaurants, err := tx.SelectRestaurantsWhere(filter)
+        if err != nil {
+            return err
+        }
+        for i := range restaurants {
+            if _, err := tx.execNamed("restaurants.delete", restaurants[i].ID, tx.tenant); err != nil {
+                return err
+            }
+            if err := tx.audit("restaurant", restaurants[i].ID, AuditDelete, &restaurants[i], nil); err != nil {
+                return err
+            }
+        }
+        deleted = int64(len(restaurants))
+        return nil
+    })
+    if err != nil {
+        return 0, db.opError("delete", "restaurants", filter.values(), err)
+    }
+    return deleted, nil
+}
+
+// truncateTables - таблицы, которые очищает TruncateAll, в порядке initializeDrops: ссылающиеся раньше
+// тех, на которые они ссылаются. Журнал аудита, история версий, настройки, миграции и статистика запросов не очищаются
+var truncateTables = []string{
+    "password_resets",
+    "sessions",
+    "menu_items",
+    "restaurant_hours",
+    "restaurant_tags",
+    "reviews",
+    "images",
+    "attachments",
+    "translations",
+    "idempotency_keys",
+    "outbox",
+    "webhook_dead_letters",
+    "favorites",
+    "user_preferences",
+    "bookings",
+    "booking_slots",
+    "restaurant_categories",
+    "categories",
+    "jobs",
+    "documents",
+    "blobs",
+    "restaurant_embeddings",
+    "restaurants",
+    "users",
+    "counters",
+    "tenant_usage",
+}
+
+// TruncateOptions - подтверждение для TruncateAll
+type TruncateOptions struct {
+    // Confirm должен быть true: без него TruncateAll ничего не удаляет
+    Confirm bool
+}
+
+// TruncateAll удаляет все данные всех площадок одной транзакцией, сохраняя схему, миграции, настройки
+// и журнал аудита, и возвращает число удаленных строк по таблицам. В отличие от Initialize, таблицы
+// не пересоздаются. Файлы изображений и документов остаются в хранилище, их удаляет blob-gc.
+// Без options.Confirm возвращает ErrValidation
+func (db *Database) TruncateAll(options TruncateOptions) (map[string]int64, error) {
+    if !options.Confirm {
+        return nil, db.opError("truncate", "database", nil, fmt.Errorf("%w: truncating removes all data of every tenant and must be confirmed", ErrValidation))
+    }
+    counts := make(map[string]int64, len(truncateTables))
+    err := db.InTx(func(tx *Database) error {
+        for _, table := range truncateTables {
+            result, err := tx.execNamed(table + ".truncate")
+            if err != nil {
+                return fmt.Errorf("%s: %w", table, err)
+            }
+            if counts[table], err = result.RowsAffected(); err != nil {
+                return fmt.Errorf("%s: %w", table, err)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, db.opError("truncate", "database", nil, err)
+    }
+    db.clearEntityCache()
+    return counts, nil
+}
+
+// runDelete удаляет пользователей или рестораны по фильтру либо все данные:
+// delete users -role R -name-prefix P -ids 1,2 [-cascade], delete restaurants -filter type=italian,
+// delete all -confirm
+func runDelete(db *Database, args []string) error {
+    if len(args) == 0 {
+        return fmt.Errorf("usage: delete users|restaurants|all [flags]")
+    }
+    flags := flag.NewFlagSet("delete "+args[0], flag.ContinueOnError)
+    switch args[0] {
+    case "users":
+        role := flags.String("role", "", "delete users with this role")
+        namePrefix := flags.String("name-prefix", "", "delete users whose name starts with this prefix")
+        ids := flags.String("ids", "", "comma-separated user IDs to delete")
+        cascade := flags.Bool("cascade", false, "also delete the users' restaurants instead of refusing to delete owners")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        filter := UserFilter{Role: *role, NamePrefix: *namePrefix}
+        for _, part := range strings.Split(*ids, ",") {
+            if part = strings.TrimSpace(part); part == "" {
+                continue
+            }
+            id, err := strconv.Atoi(part)
+            if err != nil {
+                return fmt.Errorf("-ids: %q is not an integer", part)
+            }
+            filter.IDs = append(filter.IDs, id)
+        }
+        policy := DeleteRestrict
+        if *cascade {
+            policy = DeleteCascade
+        }
+        deleted, err := db.DeleteUsersWhere(filter, policy)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Deleted %d users\n", deleted)
+    case "restaurants":
+        expression := flags.String("filter", "", "restaurant filter, e.g. type=italian,user_id=3")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        filter, err := parseRestaurantFilter(*expression)
+        if err != nil {
+            return err
+        }
+        deleted, err := db.DeleteRestaurantsWhere(filter)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Deleted %d restaurants\n", deleted)
+    case "all":
+        confirm := flags.Bool("confirm", false, "confirm deleting all data of every tenant")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        counts, err := db.TruncateAll(TruncateOptions{Confirm: *confirm})
+        if err != nil {
+            return err
+        }
+        tables := make([]string, 0, len(counts))
+        for table := range counts {
+            tables = append(tables, table)
+        }
+        sort.Strings(tables)
+        for _, table := range tables {
+            if counts[table] > 0 {
+                fmt.Printf("%-24s %d\n", table, counts[table])
+            }
+        }
+    default:
+        return fmt.Errorf("unknown delete target %q (expected users, restaurants or all)", args[0])
+    }
+    return nil
+}
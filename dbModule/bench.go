@@ -0,0 +1,324 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+// BenchBatchSize - сколько записей SeedFakeUsers и SeedFakeRestaurants добавляют одной транзакцией
+var BenchBatchSize = 500
+
+// Словари FakeData: имена и фамилии покупателей площадки, части названий и типы ресторанов
+var (
+    fakeFirstNames  = []string{"Анна", "Иван", "Мария", "Дмитрий", "Елена", "Сергей", "Ольга", "Алексей", "Наталья", "Павел", "Emma", "James", "Olivia", "Liam", "Sophia", "Noah"}
+    fakeLastNames   = []string{"Иванов", "Смирнов", "Кузнецов", "Попов", "Соколов", "Лебедев", "Новиков", "Морозов", "Smith", "Johnson", "Brown", "Taylor", "Wilson", "Evans"}
+    fakeAdjectives  = []string{"Уютная", "Старая", "Белая", "Золотая", "Северная", "Городская", "Green", "Little", "Royal", "Blue"}
+    fakeNouns       = []string{"пельменная", "кофейня", "пекарня", "таверна", "столовая", "чайная", "Kitchen", "Garden", "Bistro", "Grill"}
+    fakeTypes       = []string{"cafe", "bistro", "bakery", "pizzeria", "grill", "sushi", "canteen", "bar"}
+    fakeKeywords    = []string{"wifi", "terrace", "vegan", "delivery", "breakfast", "kids", "parking", "late"}
+    fakeEmailDomain = "example.com"
+)
+
+// FakeData порождает правдоподобных пользователей и рестораны для нагрузочных прогонов: имена из
+// словарей, уникальные email, телефоны у части пользователей, роли и цены в разумных пропорциях.
+// Один seed дает одну и ту же последовательность записей
+type FakeData struct {
+    rng *rand.Rand
+    // seq делает email и названия уникальными
+    seq int
+}
+
+// NewFakeData создает генератор с начальным значением seed
+func NewFakeData(seed int64) *FakeData {
+    return &FakeData{rng: rand.New(rand.NewSource(seed))}
+}
+
+// pick возвращает случайный элемент words
+func (f *FakeData) pick(words []string) string {
+    return words[f.rng.Intn(len(words))]
+}
+
+// User возвращает нового пользователя: в основном покупателей, около 10% владельцев и редких администраторов
+func (f *FakeData) User() User {
+    f.seq++
+    first, last := f.pick(fakeFirstNames), f.pick(fakeLastNames)
+    user := User{
+        Name:     first,
+        Lastname: last,
+        Password: fmt.Sprintf("pw-%08x", f.rng.Uint32()),
+        Email:    fmt.Sprintf("user%d.%d@%s", f.seq, f.rng.Intn(1000), fakeEmailDomain),
+        Role:     RoleCustomer,
+    }
+    switch n := f.rng.Intn(100); {
+    case n == 0:
+        user.Role = RoleAdmin
+    case n <= 10:
+        user.Role = RoleOwner
+    }
+    if f.rng.Intn(3) > 0 {
+        phone := fmt.Sprintf("+79%09d", f.rng.Intn(1000000000))
+        user.Phone = &phone
+    }
+    return user
+}
+
+// Restaurant возвращает новый ресторан владельца ownerID. Уровень цен чаще средний, чем крайний,
+// а средний чек в рублях растет вместе с ним
+func (f *FakeData) Restaurant(ownerID int) Restaurant {
+    f.seq++
+    level := 1 + (f.rng.Intn(5)+f.rng.Intn(5))/2
+    keys := f.pick(fakeKeywords) + " " + f.pick(fakeKeywords)
+    restaurant := Restaurant{
+        Name:         fmt.Sprintf("%s %s №%d", f.pick(fakeAdjectives), f.pick(fakeNouns), f.seq),
+        Type:         f.pick(fakeTypes),
+        Keys:         &keys,
+        AveragePrice: PriceTier(level),
+        UserID:       ownerID,
+    }
+    if f.rng.Intn(4) > 0 {
+        // от 300 до 1500 рублей за уровень, с точностью до рубля
+        restaurant.SetPrice(&Money{Amount: int64(level*(300+f.rng.Intn(1200))) * 100, Currency: "RUB"})
+    }
+    return restaurant
+}
+
+// Owner выбирает владельца ресторана из owners с экспоненциальным перекосом: у первых пользователей
+// много ресторанов, у большинства - по одному или ни одного, как у сетей и одиночных заведений
+func (f *FakeData) Owner(owners []int) int {
+    i := int(f.rng.ExpFloat64() * float64(len(owners)) / 8)
+    if i >= len(owners) {
+        i = f.rng.Intn(len(owners))
+    }
+    return owners[i]
+}
+
+// SeedFakeUsers добавляет n пользователей из data транзакциями по BenchBatchSize и возвращает их ID
+func (db *Database) SeedFakeUsers(data *FakeData, n int) ([]int, error) {
+    ids := make([]int, 0, n)
+    err := seedBatches(db, n, func(tx *Database) error {
+        id, err := tx.insertUser(data.User())
+        ids = append(ids, int(id))
+        return err
+    })
+    return ids, err
+}
+
+// SeedFakeRestaurants добавляет n ресторанов владельцев owners (см. FakeData.Owner) транзакциями
+// по BenchBatchSize и возвращает их ID
+func (db *Database) SeedFakeRestaurants(data *FakeData, owners []int, n int) ([]int, error) {
+    if n > 0 && len(owners) == 0 {
+        return nil, fmt.Errorf("%w: restaurants need at least one owner", ErrValidation)
+    }
+    ids := make([]int, 0, n)
+    err := seedBatches(db, n, func(tx *Database) error {
+        id, err := tx.insertRestaurant(data.Restaurant(data.Owner(owners)))
+        ids = append(ids, int(id))
+        return err
+    })
+    return ids, err
+}
+
+// seedBatches вызывает insert n раз, по BenchBatchSize вызовов в транзакции
+func seedBatches(db *Database, n int, insert func(tx *Database) error) error {
+    for done := 0; done < n; {
+        batch := min(max(BenchBatchSize, 1), n-done)
+        err := db.InTx(func(tx *Database) error {
+            for i := 0; i < batch; i++ {
+                if err := insert(tx); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        done += batch
+    }
+    return nil
+}
+
+// BenchOptions - параметры RunBenchmark
+type BenchOptions struct {
+    // Start, Factor и Steps задают размеры базы, на которых идут замеры: Start, Start*Factor, ...
+    // всего Steps размеров. Размер - число пользователей и число ресторанов
+    Start  int
+    Factor int
+    Steps  int
+    // Reads - сколько выборок каждого вида делается на каждом размере
+    Reads int
+    Seed  int64
+}
+
+// DefaultBenchOptions - 1000, 10000 и 100000 записей по 1000 выборок
+var DefaultBenchOptions = BenchOptions{Start: 1000, Factor: 10, Steps: 3, Reads: 1000, Seed: 1}
+
+// BenchResult - замер одной операции на одном размере базы
+type BenchResult struct {
+    Size      int
+    Operation string
+    Count     int
+    Elapsed   time.Duration
+}
+
+// PerSecond возвращает пропускную способность: операций в секунду
+func (r BenchResult) PerSecond() float64 {
+    if r.Elapsed <= 0 {
+        return 0
+    }
+    return float64(r.Count) / r.Elapsed.Seconds()
+}
+
+func (r BenchResult) String() string {
+    return fmt.Sprintf("%8d | %-24s | %8d ops | %12v | %10.0f ops/s", r.Size, r.Operation, r.Count, r.Elapsed.Round(time.Microsecond), r.PerSecond())
+}
+
+// RunBenchmark наращивает базу по размерам options и на каждом замеряет добавление пользователей
+// и ресторанов, выборку ресторана по ID, страницу ресторанов и поиск по типу и цене. Размеры растут
+// экспоненциально, поэтому видно, как пропускная способность зависит от объема данных.
+// db должна быть отдельной базой с примененными миграциями: прогон пишет в нее
+func RunBenchmark(db *Database, options BenchOptions) ([]BenchResult, error) {
+    if options.Start < 1 || options.Factor < 1 || options.Steps < 1 || options.Reads < 1 {
+        return nil, fmt.Errorf("%w: start, factor, steps and reads must be positive", ErrValidation)
+    }
+    data := NewFakeData(options.Seed)
+    var results []BenchResult
+    var owners, restaurants []int
+    measure := func(size int, operation string, count int, fn func(i int) error) error {
+        started := time.Now()
+        for i := 0; i < count; i++ {
+            if err := fn(i); err != nil {
+                return fmt.Errorf("bench %s at %d: %w", operation, size, err)
+            }
+        }
+        results = append(results, BenchResult{Size: size, Operation: operation, Count: count, Elapsed: time.Since(started)})
+        return nil
+    }
+
+    size := options.Start
+    for step := 0; step < options.Steps; step, size = step+1, size*options.Factor {
+        added := size - len(owners)
+        err := measure(size, "insert users", 1, func(int) error {
+            ids, err := db.SeedFakeUsers(data, added)
+            owners = append(owners, ids...)
+            return err
+        })
+        if err != nil {
+            return results, err
+        }
+        err = measure(size, "insert restaurants", 1, func(int) error {
+            ids, err := db.SeedFakeRestaurants(data, owners, added)
+            restaurants = append(restaurants, ids...)
+            return err
+        })
+        if err != nil {
+            return results, err
+        }
+        // замер добавления - одна операция на весь прогон, а пропускная способность считается по записям
+        results[len(results)-2].Count, results[len(results)-1].Count = added, added
+
+        err = measure(size, "select restaurant by id", options.Reads, func(int) error {
+            _, err := db.GetRestaurantByID(restaurants[data.rng.Intn(len(restaurants))])
+            return err
+        })
+        if err != nil {
+            return results, err
+        }
+        err = measure(size, "restaurants page", options.Reads, func(int) error {
+            _, err := db.RestaurantsPage(RestaurantFilter{}, PageRequest{}, RestaurantSort{Field: SortByName})
+            return err
+        })
+        if err != nil {
+            return results, err
+        }
+        err = measure(size, "filter type and price", options.Reads, func(int) error {
+            level := PriceTier(1 + data.rng.Intn(5))
+            _, err := db.SelectRestaurantsWhere(RestaurantFilter{Type: data.pick(fakeTypes), MinPrice: &level, Limit: 20})
+            return err
+        })
+        if err != nil {
+            return results, err
+        }
+    }
+    return results, nil
+}
+
+// runBench выполняет bench seed - заполнение базы сгенерированными данными - или bench run -
+// замеры RunBenchmark на временной базе SQLite
+func runBench(db *Database, args []string) error {
+    if len(args) == 0 {
+        return fmt.Errorf("usage: bench seed -users N -restaurants M | bench run [-start N] [-factor F] [-steps S] [-reads R]")
+    }
+    // пароли сгенерированных пользователей никто не вводит, а bcrypt обычной стоимости занял бы все время замеров
+    PasswordHashCost = bcrypt.MinCost
+    switch args[0] {
+    case "seed":
+        flags := flag.NewFlagSet("bench seed", flag.ContinueOnError)
+        users := flags.Int("users", 1000, "number of users to add")
+        restaurants := flags.Int("restaurants", 1000, "number of restaurants to add, owned by the new users")
+        seed := flags.Int64("seed", 1, "random seed; the same seed generates the same data")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        if *users < 1 && *restaurants > 0 {
+            return fmt.Errorf("restaurants need at least one user to own them")
+        }
+        data := NewFakeData(*seed)
+        started := time.Now()
+        owners, err := db.SeedFakeUsers(data, *users)
+        if err != nil {
+            return err
+        }
+        if _, err := db.SeedFakeRestaurants(data, owners, *restaurants); err != nil {
+            return err
+        }
+        fmt.Printf("added %d users and %d restaurants in %v\n", *users, *restaurants, time.Since(started).Round(time.Millisecond))
+        return nil
+    case "run":
+        options := DefaultBenchOptions
+        flags := flag.NewFlagSet("bench run", flag.ContinueOnError)
+        flags.IntVar(&options.Start, "start", options.Start, "number of users and restaurants at the first step")
+        flags.IntVar(&options.Factor, "factor", options.Factor, "how many times the data grows at each next step")
+        flags.IntVar(&options.Steps, "steps", options.Steps, "number of steps")
+        flags.IntVar(&options.Reads, "reads", options.Reads, "number of selects of each kind at each step")
+        flags.Int64Var(&options.Seed, "seed", options.Seed, "random seed")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        if err := db.requireSQLite("bench run"); err != nil {
+            return err
+        }
+
+        dir, err := ioutil.TempDir("", "dbmodule-bench")
+        if err != nil {
+            return err
+        }
+        defer os.RemoveAll(dir)
+        scratch, err := NewDatabaseWithConfig(Config{Driver: db.driver.name, DSN: filepath.Join(dir, "bench.db"), Timeouts: db.timeouts}, db.queries)
+        if err != nil {
+            return err
+        }
+        defer scratch.Close()
+        if err := scratch.Migrate(); err != nil {
+            return err
+        }
+
+        fmt.Printf("%8s | %-24s | %12s | %12s | %14s\n", "size", "operation", "count", "time", "throughput")
+        fmt.Println(strings.Repeat("-", 82))
+        results, err := RunBenchmark(scratch, options)
+        for _, result := range results {
+            fmt.Println(result)
+        }
+        return err
+    default:
+        return fmt.Errorf("unknown bench mode %q, expected seed or run", args[0])
+    }
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "regexp"
+    "strings"
+    "sync"
+)
+
+// comparedColumn находит колонку перед плейсхолдером: "name = ", "u.tenant_id <= ", "email LIKE "
+var comparedColumn = regexp.MustCompile(`(?i)([A-Za-z_][A-Za-z0-9_]*)\s*(=|<>|!=|<=|>=|<|>|\bLIKE)\s*$`)
+
+// insertColumns находит список колонок и значений INSERT INTO t (a, b) VALUES (?, ?)
+var insertColumns = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+[^\s(]+\s*\(([^)]*)\)\s*VALUES\s*\(`)
+
+// placeholderColumnsCache - колонки плейсхолдеров уже разобранных запросов
+var placeholderColumnsCache sync.Map
+
+// BindArgs возвращает аргументы запроса query в порядке его плейсхолдеров ?. Для каждого
+// плейсхолдера определяется колонка - по списку колонок INSERT или по сравнению "колонка = ?" -
+// и значение берется из overrides, а если там его нет, из поля value с тегом db:"колонка".
+// Так аргументы не зависят от порядка колонок в тексте запроса и его вариантах для диалектов.
+// Колонка, для которой нет ни значения, ни поля, - ошибка
+func BindArgs(query string, value interface{}, overrides map[string]interface{}) ([]interface{}, error) {
+    columns, err := placeholderColumns(query)
+    if err != nil {
+        return nil, err
+    }
+    fields, err := structColumns(value)
+    if err != nil {
+        return nil, err
+    }
+
+    args := make([]interface{}, len(columns))
+    for i, column := range columns {
+        if arg, ok := overrides[column]; ok {
+            args[i] = arg
+            continue
+        }
+        arg, ok := fields[column]
+        if !ok {
+            return nil, fmt.Errorf("no value for column %s (placeholder %d) in %T", column, i+1, value)
+        }
+        args[i] = arg
+    }
+    return args, nil
+}
+
+// bindNamed связывает аргументы именованного запроса в варианте текущего диалекта (см. BindArgs)
+func (db *Database) bindNamed(name string, value interface{}, overrides map[string]interface{}) ([]interface{}, error) {
+    // lookupQuery не используется, чтобы связывание не считалось лишним вызовом устаревшего запроса
+    if variant := name + "@" + db.driver.dialect.Name(); db.queries.Has(variant) {
+        name = variant
+    }
+    query, err := db.queries.Get(name)
+    if err != nil {
+        return nil, err
+    }
+    args, err := BindArgs(query, value, overrides)
+    if err != nil {
+        return nil, fmt.Errorf("binding %s: %w", name, err)
+    }
+    return args, nil
+}
+
+// placeholderColumns возвращает колонку каждого плейсхолдера запроса по порядку
+func placeholderColumns(query string) ([]string, error) {
+    if cached, ok := placeholderColumnsCache.Load(query); ok {
+        return cached.([]string), nil
+    }
+
+    // значения VALUES (...) сопоставляются со списком колонок INSERT по позиции
+    valueColumns := map[int]string{}
+    if match := insertColumns.FindStringSubmatchIndex(query); match != nil {
+        names := splitList(query[match[2]:match[3]])
+        values := splitList(query[match[1]:closingParen(query, match[1])])
+        for i, value := range values {
+            if value.text == "?" && i < len(names) {
+                valueColumns[match[1]+value.offset] = names[i].text
+            }
+        }
+    }
+
+    var columns []string
+    var quote byte
+    for i := 0; i < len(query); i++ {
+        c := query[i]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '\'' || c == '"':
+            quote = c
+        case c == '?':
+            column, ok := valueColumns[i]
+            if !ok {
+                match := comparedColumn.FindStringSubmatch(query[:i])
+                if match == nil {
+                    return nil, fmt.Errorf("cannot tell the column of placeholder %d at %q", len(columns)+1, query[max(0, i-30):i+1])
+                }
+                column = match[1]
+            }
+            columns = append(columns, strings.ToLower(column))
+        }
+    }
+    placeholderColumnsCache.Store(query, columns)
+    return columns, nil
+}
+
+// listItem - элемент списка через запятую и его смещение от начала списка
+type listItem struct {
+    text   string
+    offset int
+}
+
+// splitList делит список через запятую верхнего уровня (без вложенных скобок), обрезая пробелы
+func splitList(list string) []listItem {
+    var items []listItem
+    depth, start := 0, 0
+    for i := 0; i <= len(list); i++ {
+        if i < len(list) {
+            switch list[i] {
+            case '(':
+                depth++
+                continue
+            case ')':
+                depth--
+                continue
+            case ',':
+                if depth > 0 {
+                    continue
+                }
+            default:
+                continue
+            }
+        }
+        item := list[start:i]
+        trimmed := strings.TrimSpace(item)
+        items = append(items, listItem{text: trimmed, offset: start + strings.Index(item, trimmed)})
+        start = i + 1
+    }
+    return items
+}
+
+// closingParen возвращает позицию скобки, закрывающей список, который начинается в start
+func closingParen(query string, start int) int {
+    depth := 0
+    for i := start; i < len(query); i++ {
+        switch query[i] {
+        case '(':
+            depth++
+        case ')':
+            if depth == 0 {
+                return i
+            }
+            depth--
+        }
+    }
+    return len(query)
+}
+
+// structColumns возвращает значения полей структуры (или указателя на нее) с тегом db по именам колонок
+func structColumns(value interface{}) (map[string]interface{}, error) {
+    v := reflect.ValueOf(value)
+    for v.Kind() == reflect.Pointer && !v.IsNil() {
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("binding arguments: want a struct, got %T", value)
+    }
+
+    fields := map[string]interface{}{}
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        column, _, _ := strings.Cut(t.Field(i).Tag.Get("db"), ",")
+        if column == "" || column == "-" || !t.Field(i).IsExported() {
+            continue
+        }
+        fields[strings.ToLower(column)] = v.Field(i).Interface()
+    }
+    return fields, nil
+}
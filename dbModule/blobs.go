@@ -0,0 +1,375 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// orphanGracePeriod - сколько хранится файл без строки в blobs: столько может длиться
+// еще не зафиксированная транзакция, которая на него сошлется
+const orphanGracePeriod = time.Hour
+
+// Blob - файл в хранилище, адресуемый SHA-256 содержимого
+type Blob struct {
+    Hash     string
+    Size     int64
+    RefCount int
+}
+
+// Image - изображение ресторана
+type Image struct {
+    ID           int
+    RestaurantID int
+    BlobHash     string
+    Name         string
+    ContentType  string
+}
+
+// Document - документ пользователя
+type Document struct {
+    ID          int
+    UserID      int
+    BlobHash    string
+    Name        string
+    ContentType string
+}
+
+// BlobStore хранит содержимое изображений и документов в каталоге dir по хешу SHA-256
+// (<dir>/ab/abcdef...). Одинаковые файлы хранятся один раз, а в таблице blobs
+// считается число ссылок на них из images и documents
+type BlobStore struct {
+    db  *Database
+    dir string
+}
+
+// NewBlobStore создает хранилище файлов в каталоге dir
+func NewBlobStore(db *Database, dir string) *BlobStore {
+    return &BlobStore{db: db, dir: dir}
+}
+
+// path возвращает путь к файлу с указанным хешем
+func (s *BlobStore) path(hash string) string {
+    return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// write сохраняет поток в хранилище, вычисляя хеш по мере записи
+func (s *BlobStore) write(r io.Reader) (Blob, error) {
+    if err := os.MkdirAll(s.dir, 0755); err != nil {
+        return Blob{}, err
+    }
+    tmp, err := os.CreateTemp(s.dir, "tmp-*")
+    if err != nil {
+        return Blob{}, err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+
+    h := sha256.New()
+    size, err := io.Copy(io.MultiWriter(tmp, h), r)
+    if err != nil {
+        return Blob{}, err
+    }
+    if err := tmp.Sync(); err != nil {
+        return Blob{}, err
+    }
+    if err := tmp.Close(); err != nil {
+        return Blob{}, err
+    }
+
+    blob := Blob{Hash: hex.EncodeToString(h.Sum(nil)), Size: size}
+    path := s.path(blob.Hash)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return Blob{}, err
+    }
+    // файл с тем же содержимым заменяется атомарно, даже если он уже есть
+    if err := os.Rename(tmp.Name(), path); err != nil {
+        return Blob{}, err
+    }
+    return blob, nil
+}
+
+// Open открывает содержимое blob для потокового чтения или возвращает ErrNotFound
+func (s *BlobStore) Open(hash string) (io.ReadCloser, error) {
+    if _, err := s.Stat(hash); err != nil {
+        return nil, err
+    }
+    return os.Open(s.path(hash))
+}
+
+// Stat возвращает сведения о blob или ErrNotFound
+func (s *BlobStore) Stat(hash string) (Blob, error) {
+    rows, err := s.db.queryNamed("blobs.select_by_hash", hash)
+    if err != nil {
+        return Blob{}, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        if err := rows.Err(); err != nil {
+            return Blob{}, err
+        }
+        return Blob{}, fmt.Errorf("blob %s: %w", hash, ErrNotFound)
+    }
+    var blob Blob
+    err = rows.Scan(&blob.Hash, &blob.Size, &blob.RefCount)
+    return blob, err
+}
+
+// AddImage сохраняет изображение ресторана из потока r
+func (s *BlobStore) AddImage(restaurantID int, name, contentType string, r io.Reader) (Image, error) {
+    image := Image{RestaurantID: restaurantID, Name: name, ContentType: contentType}
+    id, hash, err := s.addReference("images.insert", restaurantID, name, contentType, r)
+    if s.db.driver.isForeignKeyError(err) {
+        return Image{}, fmt.Errorf("restaurant %d does not exist: %w", restaurantID, ErrForeignKeyViolation)
+    }
+    if err != nil {
+        return Image{}, err
+    }
+    image.ID, image.BlobHash = int(id), hash
+    return image, nil
+}
+
+// AddDocument сохраняет документ пользователя из потока r
+func (s *BlobStore) AddDocument(userID int, name, contentType string, r io.Reader) (Document, error) {
+    document := Document{UserID: userID, Name: name, ContentType: contentType}
+    id, hash, err := s.addReference("documents.insert", userID, name, contentType, r)
+    if s.db.driver.isForeignKeyError(err) {
+        return Document{}, fmt.Errorf("user %d does not exist: %w", userID, ErrForeignKeyViolation)
+    }
+    if err != nil {
+        return Document{}, err
+    }
+    document.ID, document.BlobHash = int(id), hash
+    return document, nil
+}
+
+// addReference записывает файл и в одной транзакции создает строку blobs (если ее нет),
+// строку images или documents и увеличивает счетчик ссылок
+func (s *BlobStore) addReference(insert string, ownerID int, name, contentType string, r io.Reader) (int64, string, error) {
+    blob, err := s.write(r)
+    if err != nil {
+        return 0, "", err
+    }
+
+    var id int64
+    err = s.db.InTx(func(tx *Database) error {
+        if _, err := tx.execNamed("blobs.insert_missing", blob.Hash, blob.Size); err != nil {
+            return err
+        }
+        if id, err = tx.insertNamed(insert, ownerID, blob.Hash, name, contentType); err != nil {
+            return err
+        }
+        _, err := tx.execNamed("blobs.increment_refs", blob.Hash)
+        return err
+    })
+    return id, blob.Hash, err
+}
+
+// OpenImage возвращает изображение и поток его содержимого
+func (s *BlobStore) OpenImage(id int) (Image, io.ReadCloser, error) {
+    var image Image
+    err := s.db.selectReference("images.select_by_id", "image", id, &image.ID, &image.RestaurantID, &image.BlobHash, &image.Name, &image.ContentType)
+    if err != nil {
+        return Image{}, nil, err
+    }
+    r, err := s.Open(image.BlobHash)
+    return image, r, err
+}
+
+// OpenDocument возвращает документ и поток его содержимого
+func (s *BlobStore) OpenDocument(id int) (Document, io.ReadCloser, error) {
+    var document Document
+    err := s.db.selectReference("documents.select_by_id", "document", id, &document.ID, &document.UserID, &document.BlobHash, &document.Name, &document.ContentType)
+    if err != nil {
+        return Document{}, nil, err
+    }
+    r, err := s.Open(document.BlobHash)
+    return document, r, err
+}
+
+// ImagesByRestaurant возвращает изображения ресторана
+func (s *BlobStore) ImagesByRestaurant(restaurantID int) ([]Image, error) {
+    rows, err := s.db.queryNamed("images.select_by_restaurant", restaurantID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var images []Image
+    for rows.Next() {
+        var image Image
+        if err := rows.Scan(&image.ID, &image.RestaurantID, &image.BlobHash, &image.Name, &image.ContentType); err != nil {
+            return nil, err
+        }
+        images = append(images, image)
+    }
+    return images, rows.Err()
+}
+
+// DocumentsByUser возвращает документы пользователя
+func (s *BlobStore) DocumentsByUser(userID int) ([]Document, error) {
+    rows, err := s.db.queryNamed("documents.select_by_user", userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var documents []Document
+    for rows.Next() {
+        var document Document
+        if err := rows.Scan(&document.ID, &document.UserID, &document.BlobHash, &document.Name, &document.ContentType); err != nil {
+            return nil, err
+        }
+        documents = append(documents, document)
+    }
+    return documents, rows.Err()
+}
+
+// DeleteImage удаляет изображение; файл удаляется сборкой мусора, когда на него не останется ссылок
+func (s *BlobStore) DeleteImage(id int) error {
+    return s.deleteReference("images", "image", id)
+}
+
+// DeleteDocument удаляет документ; файл удаляется сборкой мусора, когда на него не останется ссылок
+func (s *BlobStore) DeleteDocument(id int) error {
+    return s.deleteReference("documents", "document", id)
+}
+
+// deleteReference удаляет строку images или documents и уменьшает счетчик ссылок на ее blob
+func (s *BlobStore) deleteReference(namespace, entity string, id int) error {
+    return s.db.InTx(func(tx *Database) error {
+        var ownerID int
+        var hash, name, contentType string
+        err := tx.selectReference(namespace+".select_by_id", entity, id, &id, &ownerID, &hash, &name, &contentType)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.execNamed(namespace+".delete", id); err != nil {
+            return err
+        }
+        _, err = tx.execNamed("blobs.decrement_refs", hash)
+        return err
+    })
+}
+
+// selectReference читает одну строку images или documents по id или возвращает ErrNotFound
+func (db *Database) selectReference(name, entity string, id int, dest ...interface{}) error {
+    rows, err := db.queryNamed(name, id)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        if err := rows.Err(); err != nil {
+            return err
+        }
+        return fmt.Errorf("%s %d: %w", entity, id, ErrNotFound)
+    }
+    return rows.Scan(dest...)
+}
+
+// CollectGarbage удаляет blob без ссылок и файлы, оставшиеся от откатившихся загрузок.
+// Сначала счетчики пересчитываются по images и documents, чтобы учесть каскадные удаления
+// вместе с ресторанами и пользователями. Возвращает число удаленных файлов
+func (s *BlobStore) CollectGarbage() (int, error) {
+    if _, err := s.db.execNamed("blobs.recount_refs"); err != nil {
+        return 0, err
+    }
+
+    unreferenced, err := s.selectHashes("blobs.select_unreferenced")
+    if err != nil {
+        return 0, err
+    }
+    removed := 0
+    for hash := range unreferenced {
+        result, err := s.db.execNamed("blobs.delete_unreferenced", hash)
+        if err != nil {
+            return removed, err
+        }
+        // за время сборки на blob могла появиться новая ссылка
+        if affected, _ := result.RowsAffected(); affected == 0 {
+            continue
+        }
+        if err := os.Remove(s.path(hash)); err != nil && !os.IsNotExist(err) {
+            return removed, err
+        }
+        removed++
+    }
+
+    known, err := s.selectHashes("blobs.select_hashes")
+    if err != nil {
+        return removed, err
+    }
+    orphans, err := s.orphanFiles(known)
+    if err != nil {
+        return removed, err
+    }
+    for _, path := range orphans {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            return removed, err
+        }
+        removed++
+    }
+    return removed, nil
+}
+
+// selectHashes выполняет запрос, возвращающий столбец хешей
+func (s *BlobStore) selectHashes(name string) (map[string]bool, error) {
+    rows, err := s.db.queryNamed(name)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    hashes := make(map[string]bool)
+    for rows.Next() {
+        var hash string
+        if err := rows.Scan(&hash); err != nil {
+            return nil, err
+        }
+        hashes[strings.TrimSpace(hash)] = true
+    }
+    return hashes, rows.Err()
+}
+
+// orphanFiles находит в каталоге хранилища файлы старше orphanGracePeriod, которых нет в known
+func (s *BlobStore) orphanFiles(known map[string]bool) ([]string, error) {
+    var orphans []string
+    cutoff := time.Now().Add(-orphanGracePeriod)
+    err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        if err != nil || info.IsDir() {
+            return err
+        }
+        if !known[info.Name()] && info.ModTime().Before(cutoff) {
+            orphans = append(orphans, path)
+        }
+        return nil
+    })
+    return orphans, err
+}
+
+// runBlobGC удаляет неиспользуемые файлы из хранилища
+func runBlobGC(db *Database, args []string) error {
+    flags := flag.NewFlagSet("blob-gc", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    removed, err := NewBlobStore(db, *blobsFlag).CollectGarbage()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Removed %d unreferenced files\n", removed)
+    return nil
+}
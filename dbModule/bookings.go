@@ -0,0 +1,521 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// bookingSlotsWindow - период, за который GET /restaurants/{id}/slots отдает слоты, если to не задан
+const bookingSlotsWindow = 7 * 24 * time.Hour
+
+// BookingSlot - период, в который ресторан принимает брони столиков, и число мест в нем.
+// Слоты одного ресторана не пересекаются
+type BookingSlot struct {
+    ID           int       `json:"id"`
+    TenantID     int       `json:"tenant_id"`
+    RestaurantID int       `json:"restaurant_id"`
+    StartsAt     time.Time `json:"starts_at"`
+    EndsAt       time.Time `json:"ends_at"`
+    // Capacity - число мест, Booked - места, занятые бронями
+    Capacity  int       `json:"capacity"`
+    Booked    int       `json:"booked"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// Available возвращает число свободных мест в слоте
+func (slot BookingSlot) Available() int {
+    return slot.Capacity - slot.Booked
+}
+
+// Booking - бронь пользователя на PartySize гостей в слоте ресторана; период и ресторан берутся из слота
+type Booking struct {
+    ID           int       `json:"id"`
+    TenantID     int       `json:"tenant_id"`
+    SlotID       int       `json:"slot_id"`
+    RestaurantID int       `json:"restaurant_id"`
+    UserID       int       `json:"user_id"`
+    PartySize    int       `json:"party_size"`
+    StartsAt     time.Time `json:"starts_at"`
+    EndsAt       time.Time `json:"ends_at"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateBookingSlot добавляет слот ресторану и заполняет его ID, площадку и время создания.
+// Ресторана нет на площадке - ErrForeignKeyViolation, слот пересекается с другим слотом ресторана - ErrConflict
+func (db *Database) CreateBookingSlot(slot *BookingSlot) error {
+    slot.StartsAt, slot.EndsAt = slot.StartsAt.UTC(), slot.EndsAt.UTC()
+    if err := checkInvariants("booking slot", *slot); err != nil {
+        return db.opError("insert", "booking slot", slot.RestaurantID, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        restaurant, err := tx.findRestaurant("restaurants.select_by_id", slot.RestaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if restaurant == nil {
+            return fmt.Errorf("restaurant %d does not exist: %w", slot.RestaurantID, ErrForeignKeyViolation)
+        }
+        overlapping, err := tx.countNamed("booking_slots.count_overlapping", slot.RestaurantID, tx.tenant, slot.EndsAt, slot.StartsAt)
+        if err != nil {
+            return err
+        }
+        if overlapping > 0 {
+            return fmt.Errorf("%w: slot overlaps another slot of restaurant %d", ErrConflict, slot.RestaurantID)
+        }
+
+        now := tx.now().UTC()
+        id, err := tx.insertNamed("booking_slots.insert", tx.tenant, slot.RestaurantID, slot.StartsAt, slot.EndsAt, slot.Capacity, now, now)
+        if err != nil {
+            return err
+        }
+        created, err := tx.findBookingSlot(int(id))
+        if err != nil {
+            return err
+        }
+        if created == nil {
+            return ErrNotFound
+        }
+        *slot = *created
+        return tx.audit("booking_slot", slot.ID, AuditInsert, nil, created)
+    })
+    return db.opError("insert", "booking slot", slot.RestaurantID, err)
+}
+
+// GetBookingSlot возвращает слот площадки по ID или ErrNotFound
+func (db *Database) GetBookingSlot(id int) (BookingSlot, error) {
+    slot, err := db.findBookingSlot(id)
+    if err == nil && slot == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return BookingSlot{}, db.opError("get", "booking slot", id, err)
+    }
+    return *slot, nil
+}
+
+// BookingSlots возвращает слоты ресторана, пересекающиеся с периодом [from, to), по времени начала
+func (db *Database) BookingSlots(restaurantID int, from, to time.Time) ([]BookingSlot, error) {
+    rows, err := db.queryNamed("booking_slots.select_by_restaurant", restaurantID, db.tenant, from.UTC(), to.UTC())
+    if err != nil {
+        return nil, db.opError("list", "booking slots", restaurantID, err)
+    }
+    slots, err := collectRows(db, rows, scanBookingSlot)
+    return slots, db.opError("list", "booking slots", restaurantID, err)
+}
+
+// DeleteBookingSlot удаляет слот вместе с его бронями и возвращает слот
+func (db *Database) DeleteBookingSlot(id int) (BookingSlot, error) {
+    var deleted BookingSlot
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findBookingSlot(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("booking_slots.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        deleted = *old
+        return tx.audit("booking_slot", id, AuditDelete, old, nil)
+    })
+    return deleted, db.opError("delete", "booking slot", id, err)
+}
+
+// CreateBooking бронирует для пользователя partySize мест в слоте ресторана. Слот блокируется до конца
+// транзакции, поэтому конкурирующие брони проверяют вместимость по очереди. Слота нет у ресторана -
+// ErrNotFound, слот уже начался - ошибка проверки, мест не хватает - ErrSlotFull, у пользователя уже
+// есть бронь на пересекающееся время - ErrConflict, пользователя нет на площадке - ErrForeignKeyViolation
+func (db *Database) CreateBooking(userID, restaurantID, slotID, partySize int) (Booking, error) {
+    booking := Booking{SlotID: slotID, RestaurantID: restaurantID, UserID: userID, PartySize: partySize}
+    key := fmt.Sprintf("%d/%d", userID, slotID)
+    if err := checkInvariants("booking", booking); err != nil {
+        return Booking{}, db.opError("insert", "booking", key, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        // слот блокируется первой записью транзакции, до любых чтений
+        now := tx.now().UTC()
+        if _, err := tx.execNamed("booking_slots.lock", now, slotID, tx.tenant); err != nil {
+            return err
+        }
+        user, err := tx.findUser("users.select_by_id", userID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if user == nil {
+            return fmt.Errorf("user %d does not exist: %w", userID, ErrForeignKeyViolation)
+        }
+        slot, err := tx.findBookingSlot(slotID)
+        if err != nil {
+            return err
+        }
+        if slot == nil || slot.RestaurantID != restaurantID {
+            return fmt.Errorf("booking slot %d of restaurant %d: %w", slotID, restaurantID, ErrNotFound)
+        }
+        if !slot.StartsAt.After(now) {
+            return &ValidationError{Field: "slot_id", Message: "has already started"}
+        }
+        overlapping, err := tx.countNamed("bookings.count_overlapping_by_user", userID, tx.tenant, slot.EndsAt, slot.StartsAt)
+        if err != nil {
+            return err
+        }
+        if overlapping > 0 {
+            return fmt.Errorf("%w: user %d already has a booking at that time", ErrConflict, userID)
+        }
+        if partySize > slot.Available() {
+            return fmt.Errorf("%w: %d of %d seats left", ErrSlotFull, slot.Available(), slot.Capacity)
+        }
+
+        id, err := tx.insertNamed("bookings.insert", tx.tenant, slotID, userID, partySize, now)
+        if err != nil {
+            return err
+        }
+        created, err := tx.findBooking(int(id))
+        if err != nil {
+            return err
+        }
+        if created == nil {
+            return ErrNotFound
+        }
+        booking = *created
+        return tx.audit("booking", booking.ID, AuditInsert, nil, created)
+    })
+    if err != nil {
+        return Booking{}, db.opError("insert", "booking", key, err)
+    }
+    return booking, nil
+}
+
+// GetBooking возвращает бронь площадки по ID или ErrNotFound
+func (db *Database) GetBooking(id int) (Booking, error) {
+    booking, err := db.findBooking(id)
+    if err == nil && booking == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return Booking{}, db.opError("get", "booking", id, err)
+    }
+    return *booking, nil
+}
+
+// CancelBooking удаляет бронь и возвращает ее; ее места в слоте освобождаются
+func (db *Database) CancelBooking(id int) (Booking, error) {
+    var canceled Booking
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findBooking(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("bookings.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        canceled = *old
+        return tx.audit("booking", id, AuditDelete, old, nil)
+    })
+    return canceled, db.opError("delete", "booking", id, err)
+}
+
+// ListBookingsByUser возвращает брони пользователя по времени начала
+func (db *Database) ListBookingsByUser(userID int) ([]Booking, error) {
+    rows, err := db.queryNamed("bookings.select_by_user", userID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "bookings", userID, err)
+    }
+    bookings, err := collectRows(db, rows, scanBooking)
+    return bookings, db.opError("list", "bookings", userID, err)
+}
+
+// ListBookingsByRestaurant возвращает брони во всех слотах ресторана по времени начала
+func (db *Database) ListBookingsByRestaurant(restaurantID int) ([]Booking, error) {
+    rows, err := db.queryNamed("bookings.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "bookings", restaurantID, err)
+    }
+    bookings, err := collectRows(db, rows, scanBooking)
+    return bookings, db.opError("list", "bookings", restaurantID, err)
+}
+
+// findBookingSlot читает слот текущей площадки; nil, если его нет
+func (db *Database) findBookingSlot(id int) (*BookingSlot, error) {
+    rows, err := db.queryNamed("booking_slots.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    slot, err := scanBookingSlot(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &slot, rows.Close()
+}
+
+// findBooking читает бронь текущей площадки; nil, если ее нет
+func (db *Database) findBooking(id int) (*Booking, error) {
+    rows, err := db.queryNamed("bookings.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    booking, err := scanBooking(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &booking, rows.Close()
+}
+
+// countNamed выполняет запрос COUNT(*) и возвращает число
+func (db *Database) countNamed(name string, args ...interface{}) (int, error) {
+    rows, err := db.queryNamed(name, args...)
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+
+    var count int
+    if rows.Next() {
+        if err := rows.Scan(&count); err != nil {
+            return 0, err
+        }
+    }
+    return count, rows.Err()
+}
+
+// scanBookingSlot читает слот из текущей строки
+func scanBookingSlot(row *queryRows) (BookingSlot, error) {
+    var slot BookingSlot
+    err := row.Scan(&slot.ID, &slot.TenantID, &slot.RestaurantID, &slot.StartsAt, &slot.EndsAt, &slot.Capacity, &slot.Booked, &slot.CreatedAt)
+    return slot, err
+}
+
+// scanBooking читает бронь из текущей строки
+func scanBooking(row *queryRows) (Booking, error) {
+    var booking Booking
+    err := row.Scan(&booking.ID, &booking.TenantID, &booking.SlotID, &booking.RestaurantID, &booking.UserID,
+        &booking.PartySize, &booking.StartsAt, &booking.EndsAt, &booking.CreatedAt)
+    return booking, err
+}
+
+// bookingSlotBody - тело POST /restaurants/{id}/slots
+type bookingSlotBody struct {
+    StartsAt time.Time `json:"starts_at"`
+    EndsAt   time.Time `json:"ends_at"`
+    Capacity int       `json:"capacity"`
+}
+
+// bookingBody - тело POST /restaurants/{id}/bookings; без user_id бронирует пользователь, вошедший через -auth
+type bookingBody struct {
+    UserID    int `json:"user_id"`
+    SlotID    int `json:"slot_id"`
+    PartySize int `json:"party_size"`
+}
+
+// serveBookingSlots отдает слоты ресторана за период from..to (GET) или добавляет слот (POST).
+// Добавляет слоты только владелец ресторана или администратор
+func (db *Database) serveBookingSlots(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if r.Method == http.MethodPost {
+        var body bookingSlotBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        if actor, ok := requestActor(r); ok {
+            if err := db.authorizeRestaurantID(actor, restaurantID); err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        slot := BookingSlot{RestaurantID: restaurantID, StartsAt: body.StartsAt, EndsAt: body.EndsAt, Capacity: body.Capacity}
+        if err := db.CreateBookingSlot(&slot); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusCreated, slot)
+        return
+    }
+
+    from, to := time.Now(), time.Time{}
+    for key, at := range map[string]*time.Time{"from": &from, "to": &to} {
+        if value := r.URL.Query().Get(key); value != "" {
+            if *at, err = time.Parse(time.RFC3339, value); err != nil {
+                writeError(w, &ValidationError{Field: key, Message: "must be a time like 2006-01-02T15:04:05+03:00"})
+                return
+            }
+        }
+    }
+    if to.IsZero() {
+        to = from.Add(bookingSlotsWindow)
+    }
+    if _, err := db.GetRestaurantByID(restaurantID); err != nil {
+        writeError(w, err)
+        return
+    }
+    slots, err := db.BookingSlots(restaurantID, from, to)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if slots == nil {
+        slots = []BookingSlot{}
+    }
+    writeJSON(w, http.StatusOK, slots)
+}
+
+// serveDeleteBookingSlot удаляет слот ресторана вместе с бронями в нем
+func (db *Database) serveDeleteBookingSlot(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    slotID, err := strconv.Atoi(r.PathValue("slot_id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "slot_id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeRestaurantID(actor, restaurantID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    slot, err := db.GetBookingSlot(slotID)
+    if err == nil && slot.RestaurantID != restaurantID {
+        err = db.opError("get", "booking slot", slotID, ErrNotFound)
+    }
+    if err == nil {
+        slot, err = db.DeleteBookingSlot(slotID)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, slot)
+}
+
+// serveRestaurantBookings отдает брони ресторана (GET) владельцу или администратору
+// либо бронирует место в слоте ресторана (POST) для пользователя из тела
+func (db *Database) serveRestaurantBookings(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    actor, authenticated := requestActor(r)
+    if r.Method == http.MethodPost {
+        var body bookingBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        if authenticated {
+            if body.UserID == 0 {
+                body.UserID = actor
+            }
+            if err := db.authorizeUser(actor, body.UserID); err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        booking, err := db.CreateBooking(body.UserID, restaurantID, body.SlotID, body.PartySize)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusCreated, booking)
+        return
+    }
+
+    if authenticated {
+        if err := db.authorizeRestaurantID(actor, restaurantID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    if _, err := db.GetRestaurantByID(restaurantID); err != nil {
+        writeError(w, err)
+        return
+    }
+    bookings, err := db.ListBookingsByRestaurant(restaurantID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if bookings == nil {
+        bookings = []Booking{}
+    }
+    writeJSON(w, http.StatusOK, bookings)
+}
+
+// serveUserBookings отдает брони пользователя ему самому или администратору
+func (db *Database) serveUserBookings(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeUser(actor, userID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    if _, err := db.GetUserByID(userID); err != nil {
+        writeError(w, err)
+        return
+    }
+    bookings, err := db.ListBookingsByUser(userID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if bookings == nil {
+        bookings = []Booking{}
+    }
+    writeJSON(w, http.StatusOK, bookings)
+}
+
+// serveCancelBooking отменяет бронь; пользователь, вошедший через -auth, - только свою, если он не администратор
+func (db *Database) serveCancelBooking(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    booking, err := db.GetBooking(id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeUser(actor, booking.UserID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    if booking, err = db.CancelBooking(id); err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, booking)
+}
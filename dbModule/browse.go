@@ -0,0 +1,474 @@
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+    "unicode/utf8"
+)
+
+// BrowsePageSize - строк на странице команды browse по умолчанию
+var BrowsePageSize = 20
+
+// browseMaxWidth - длина, до которой browse обрезает значения в таблице (show выводит их целиком)
+const browseMaxWidth = 40
+
+// browseHiddenColumns - колонки с паролями и хешами токенов: browse не показывает их значения
+var browseHiddenColumns = map[string]bool{"password": true, "token_hash": true}
+
+// browseFilterOps - допустимые сравнения filter и их запись в SQL
+var browseFilterOps = map[string]string{"=": "=", "!=": "<>", "<>": "<>", "<": "<", ">": ">", "<=": "<=", ">=": ">=", "like": "LIKE"}
+
+// browseHelp - подсказка по командам browse
+const browseHelp = `Commands:
+  tables                     list tables with row counts
+  open TABLE                 show the first page of a table
+  next, prev, page N         page through the rows (n and p for short)
+  filter COLUMN OP VALUE     keep rows where COLUMN OP VALUE; OP is =, !=, <, >, <=, >= or like
+  filter                     list filters; clear removes them
+  show ID                    print one row with full values
+  edit ID COLUMN=VALUE ...   change a user, restaurant or category through the module API; null clears a column
+  help, quit`
+
+// browseFilter - условие filter: колонка из каталога, сравнение и значение
+type browseFilter struct {
+    column string
+    op     string
+    value  string
+}
+
+// browser - состояние сеанса команды browse
+type browser struct {
+    db       *Database
+    out      io.Writer
+    pageSize int
+    tables   []string
+    table    *TableDoc
+    filters  []browseFilter
+    page     int
+}
+
+// runBrowse выполняет команду browse: интерактивный просмотр таблиц этого экземпляра в терминале.
+// Строки таблиц с tenant_id видны только для площадки -tenant; изменения идут через API модуля
+// (UpdateUserFields, UpdateRestaurantFields, RenameCategory), поэтому проверяются, пишутся в журнал
+// аудита и сбрасывают кеши. Команды читаются построчно, так что их можно передать и через stdin
+func runBrowse(db *Database, args []string) error {
+    flags := flag.NewFlagSet("browse", flag.ContinueOnError)
+    pageSize := flags.Int("page-size", BrowsePageSize, "rows per page")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *pageSize <= 0 {
+        return fmt.Errorf("page size must be positive, got %d", *pageSize)
+    }
+
+    tables, err := db.introspectStrings("introspection.tables")
+    if err != nil {
+        return err
+    }
+    b := &browser{db: db, out: os.Stdout, pageSize: *pageSize, tables: db.ownTables(tables)}
+    interactive := isTerminal(os.Stdin)
+    if interactive {
+        fmt.Fprintf(b.out, "Browsing %s database, tenant %d. Type help for commands.\n", db.driver.dialect.Name(), db.tenant)
+    }
+
+    scanner := bufio.NewScanner(os.Stdin)
+    for {
+        if interactive {
+            prompt := "browse"
+            if b.table != nil {
+                prompt += " " + b.table.Name
+            }
+            fmt.Fprint(b.out, prompt+"> ")
+        }
+        if !scanner.Scan() {
+            return scanner.Err()
+        }
+        words, err := splitBrowseArgs(scanner.Text())
+        if err != nil {
+            fmt.Fprintln(b.out, "error:", err)
+            continue
+        }
+        if len(words) == 0 {
+            continue
+        }
+        if words[0] == "quit" || words[0] == "exit" || words[0] == "q" {
+            return nil
+        }
+        if err := b.run(words[0], words[1:]); err != nil {
+            fmt.Fprintln(b.out, "error:", err)
+        }
+    }
+}
+
+// isTerminal сообщает, что file - терминал, а не файл или канал
+func isTerminal(file *os.File) bool {
+    info, err := file.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run выполняет одну команду сеанса
+func (b *browser) run(command string, args []string) error {
+    switch command {
+    case "help", "?":
+        fmt.Fprintln(b.out, browseHelp)
+        return nil
+    case "tables":
+        return b.listTables()
+    case "open":
+        if len(args) != 1 {
+            return fmt.Errorf("usage: open TABLE")
+        }
+        return b.open(args[0])
+    }
+
+    if b.table == nil {
+        return fmt.Errorf("no table is open: use tables and open TABLE")
+    }
+    switch command {
+    case "next", "n":
+        b.page++
+        return b.printPage()
+    case "prev", "p":
+        if b.page == 0 {
+            return fmt.Errorf("already on the first page")
+        }
+        b.page--
+        return b.printPage()
+    case "page":
+        page, err := strconv.Atoi(strings.Join(args, ""))
+        if err != nil || page < 1 {
+            return fmt.Errorf("usage: page N, N from 1")
+        }
+        b.page = page - 1
+        return b.printPage()
+    case "filter":
+        if len(args) == 0 {
+            for _, f := range b.filters {
+                fmt.Fprintf(b.out, "%s %s %s\n", f.column, f.op, f.value)
+            }
+            return nil
+        }
+        return b.addFilter(args)
+    case "clear":
+        b.filters, b.page = nil, 0
+        return b.printPage()
+    case "show":
+        if len(args) != 1 {
+            return fmt.Errorf("usage: show ID")
+        }
+        return b.show(args[0])
+    case "edit":
+        if len(args) < 2 {
+            return fmt.Errorf("usage: edit ID COLUMN=VALUE ...")
+        }
+        return b.edit(args[0], args[1:])
+    }
+    return fmt.Errorf("unknown command %q, type help for commands", command)
+}
+
+// listTables печатает таблицы экземпляра и число строк в них (всех площадок)
+func (b *browser) listTables() error {
+    w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "TABLE\tROWS")
+    for _, table := range b.tables {
+        count, err := b.db.countRows(table)
+        if err != nil {
+            return err
+        }
+        fmt.Fprintf(w, "%s\t%d\n", table, count)
+    }
+    return w.Flush()
+}
+
+// open делает таблицу текущей и печатает ее первую страницу
+func (b *browser) open(name string) error {
+    for _, table := range b.tables {
+        if strings.EqualFold(table, name) || strings.EqualFold(table, b.db.tablePrefix+name) {
+            doc, err := b.db.describeTable(table, TableDescription{})
+            if err != nil {
+                return err
+            }
+            b.table, b.filters, b.page = &doc, nil, 0
+            return b.printPage()
+        }
+    }
+    return fmt.Errorf("no table %q, see tables", name)
+}
+
+// addFilter добавляет условие filter COLUMN OP VALUE и печатает первую страницу
+func (b *browser) addFilter(args []string) error {
+    if len(args) != 3 {
+        return fmt.Errorf("usage: filter COLUMN OP VALUE")
+    }
+    column, ok := b.column(args[0])
+    if !ok {
+        return fmt.Errorf("table %s has no column %q", b.table.Name, args[0])
+    }
+    if browseHiddenColumns[column] {
+        return fmt.Errorf("column %s is hidden", column)
+    }
+    op, ok := browseFilterOps[strings.ToLower(args[1])]
+    if !ok {
+        return fmt.Errorf("unknown comparison %q", args[1])
+    }
+    b.filters = append(b.filters, browseFilter{column: column, op: op, value: args[2]})
+    b.page = 0
+    return b.printPage()
+}
+
+// column возвращает имя колонки текущей таблицы, как оно записано в каталоге
+func (b *browser) column(name string) (string, bool) {
+    for _, column := range b.table.Columns {
+        if strings.EqualFold(column.Name, name) {
+            return column.Name, isSimpleIdentifier(column.Name)
+        }
+    }
+    return "", false
+}
+
+// query собирает выборку текущей таблицы: площадка, условия filter и сортировка по первичному ключу
+func (b *browser) query() *SelectBuilder {
+    columns := make([]string, len(b.table.Columns))
+    for i, column := range b.table.Columns {
+        columns[i] = column.Name
+    }
+    query := NewSelectBuilder("SELECT " + strings.Join(columns, ", ") + " FROM " + b.db.tableIdentifier(b.table.Name))
+    if _, ok := b.column("tenant_id"); ok {
+        query.Where("tenant_id = ?", b.db.tenant)
+    }
+    for _, f := range b.filters {
+        query.Where(f.column+" "+f.op+" ?", f.value)
+    }
+    ordered := false
+    for _, column := range b.table.Columns {
+        if column.PrimaryKey {
+            query.OrderBy(column.Name, false)
+            ordered = true
+        }
+    }
+    if !ordered {
+        query.OrderBy(b.table.Columns[0].Name, false)
+    }
+    return query
+}
+
+// printPage печатает текущую страницу таблицы
+func (b *browser) printPage() error {
+    // строка сверх страницы показывает, есть ли следующая
+    rows, err := b.fetch(b.query().Limit(b.pageSize+1, b.page*b.pageSize))
+    if err != nil {
+        return err
+    }
+    more := len(rows) > b.pageSize
+    if more {
+        rows = rows[:b.pageSize]
+    }
+
+    w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0)
+    for i, column := range b.table.Columns {
+        if i > 0 {
+            fmt.Fprint(w, "\t")
+        }
+        fmt.Fprint(w, strings.ToUpper(column.Name))
+    }
+    fmt.Fprintln(w)
+    for _, row := range rows {
+        for i, value := range row {
+            if i > 0 {
+                fmt.Fprint(w, "\t")
+            }
+            fmt.Fprint(w, truncateBrowseValue(value))
+        }
+        fmt.Fprintln(w)
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+
+    status := fmt.Sprintf("%s, page %d, %d rows", b.table.Name, b.page+1, len(rows))
+    if len(b.filters) > 0 {
+        status += fmt.Sprintf(", %d filters", len(b.filters))
+    }
+    if more {
+        status += ", next for more"
+    }
+    fmt.Fprintf(b.out, "-- %s\n", status)
+    return nil
+}
+
+// show печатает строку текущей таблицы по первичному ключу id столбцом, со значениями целиком
+func (b *browser) show(id string) error {
+    if _, ok := b.column("id"); !ok {
+        return fmt.Errorf("table %s has no id column", b.table.Name)
+    }
+    rows, err := b.fetch(b.query().Where("id = ?", id).Limit(1, 0))
+    if err != nil {
+        return err
+    }
+    if len(rows) == 0 {
+        return fmt.Errorf("%s %s: %w", b.table.Name, id, ErrNotFound)
+    }
+    w := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0)
+    for i, column := range b.table.Columns {
+        fmt.Fprintf(w, "%s\t%s\n", column.Name, rows[0][i])
+    }
+    return w.Flush()
+}
+
+// fetch выполняет выборку и возвращает значения строк, готовые к выводу
+func (b *browser) fetch(query *SelectBuilder) ([][]string, error) {
+    rows, err := b.db.queryBuilt("browse.select", query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    values := make([]interface{}, len(b.table.Columns))
+    pointers := make([]interface{}, len(values))
+    for i := range values {
+        pointers[i] = &values[i]
+    }
+    var result [][]string
+    for rows.Next() {
+        if err := rows.Scan(pointers...); err != nil {
+            return nil, err
+        }
+        row := make([]string, len(values))
+        for i, value := range values {
+            row[i] = formatBrowseValue(value)
+            if value != nil && browseHiddenColumns[strings.ToLower(b.table.Columns[i].Name)] {
+                row[i] = redacted
+            }
+        }
+        result = append(result, row)
+    }
+    return result, rows.Err()
+}
+
+// edit меняет колонки записи через API модуля и печатает ее. Меняются только таблицы, у которых
+// есть API изменения: пользователи, рестораны и категории
+func (b *browser) edit(idText string, assignments []string) error {
+    id, err := strconv.Atoi(idText)
+    if err != nil {
+        return fmt.Errorf("id must be an integer")
+    }
+    switch strings.TrimPrefix(strings.ToLower(b.table.Name), strings.ToLower(b.db.tablePrefix)) {
+    case "users":
+        fields, err := browsePatchFields(userPatchFields, assignments)
+        if err != nil {
+            return err
+        }
+        if _, err := b.db.UpdateUserFields(id, fields); err != nil {
+            return err
+        }
+    case "restaurants":
+        fields, err := browsePatchFields(restaurantPatchFields, assignments)
+        if err != nil {
+            return err
+        }
+        if _, err := b.db.UpdateRestaurantFields(SystemActor, id, fields); err != nil {
+            return err
+        }
+    case "categories":
+        name, value, ok := strings.Cut(assignments[0], "=")
+        if !ok || name != "name" || len(assignments) != 1 {
+            return fmt.Errorf("only the name of a category can be changed: edit ID name=VALUE")
+        }
+        if _, err := b.db.RenameCategory(id, value); err != nil {
+            return err
+        }
+    default:
+        return fmt.Errorf("table %s cannot be edited: only users, restaurants and categories have an update API", b.table.Name)
+    }
+    return b.show(idText)
+}
+
+// browsePatchFields переводит присваивания COLUMN=VALUE в поля частичного изменения по их описанию:
+// числа разбираются, null очищает необязательное поле
+func browsePatchFields[T any](spec map[string]patchField[T], assignments []string) (map[string]interface{}, error) {
+    fields := make(map[string]interface{})
+    for _, assignment := range assignments {
+        name, text, ok := strings.Cut(assignment, "=")
+        if !ok {
+            return nil, fmt.Errorf("%q must be written as COLUMN=VALUE", assignment)
+        }
+        field, ok := spec[name]
+        if !ok {
+            return nil, fmt.Errorf("column %q cannot be changed", name)
+        }
+        switch {
+        case text == "null" && field.nullable:
+            fields[name] = nil
+        case field.kind == patchInt:
+            n, err := strconv.Atoi(text)
+            if err != nil {
+                return nil, &ValidationError{Field: name, Message: "must be an integer"}
+            }
+            fields[name] = n
+        default:
+            fields[name] = text
+        }
+    }
+    return fields, nil
+}
+
+// formatBrowseValue записывает значение колонки для вывода; NULL - как NULL
+func formatBrowseValue(value interface{}) string {
+    switch v := value.(type) {
+    case nil:
+        return "NULL"
+    case []byte:
+        if utf8.Valid(v) {
+            return string(v)
+        }
+        return fmt.Sprintf("<%d bytes>", len(v))
+    case time.Time:
+        return v.Format(time.RFC3339)
+    }
+    return fmt.Sprint(value)
+}
+
+// truncateBrowseValue укорачивает значение до browseMaxWidth символов и убирает переводы строк
+func truncateBrowseValue(value string) string {
+    value = strings.NewReplacer("\n", " ", "\t", " ").Replace(value)
+    if utf8.RuneCountInString(value) <= browseMaxWidth {
+        return value
+    }
+    return string([]rune(value)[:browseMaxWidth-1]) + "…"
+}
+
+// splitBrowseArgs делит строку команды на слова по пробелам; значение с пробелами берется в кавычки
+func splitBrowseArgs(line string) ([]string, error) {
+    var words []string
+    var word strings.Builder
+    inWord, quoted := false, false
+    for _, r := range line {
+        switch {
+        case r == '"':
+            quoted, inWord = !quoted, true
+        case (r == ' ' || r == '\t') && !quoted:
+            if inWord {
+                words = append(words, word.String())
+                word.Reset()
+                inWord = false
+            }
+        default:
+            word.WriteRune(r)
+            inWord = true
+        }
+    }
+    if quoted {
+        return nil, fmt.Errorf("unterminated quote")
+    }
+    if inWord {
+        words = append(words, word.String())
+    }
+    return words, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+// QueryBudget ограничивает число запросов и суммарное время в базе для одного запроса к API.
+// Превышение обычно означает N+1: выборку в цикле вместо одной пачки (см. Loader)
+type QueryBudget struct {
+    // MaxQueries - сколько запросов разрешено; 0 - без ограничения
+    MaxQueries int
+    // MaxTime - сколько суммарно можно провести в базе; 0 - без ограничения
+    MaxTime time.Duration
+    // Abort - после превышения следующие запросы получают ErrBudgetExceeded; иначе превышение только логируется
+    Abort bool
+}
+
+// enabled сообщает, задано ли хоть одно ограничение
+func (b QueryBudget) enabled() bool {
+    return b.MaxQueries > 0 || b.MaxTime > 0
+}
+
+// budgetTopSites - сколько самых частых мест вызова попадает в лог превышения
+const budgetTopSites = 5
+
+// budgetTracker считает запросы копии Database, созданной WithBudget, включая ее транзакции
+type budgetTracker struct {
+    budget QueryBudget
+
+    mu       sync.Mutex
+    queries  int
+    elapsed  time.Duration
+    exceeded bool
+    // sites - сколько запросов пришло из каждого места вызова снаружи слоя базы
+    sites map[string]int
+}
+
+// WithBudget возвращает копию Database для одного запроса к API с бюджетом budget.
+// При первом превышении в лог пишутся самые частые места вызова. Копию нельзя
+// переиспользовать между запросами: счетчики не сбрасываются
+func (db *Database) WithBudget(budget QueryBudget) *Database {
+    scoped := *db
+    scoped.budget = nil
+    if budget.enabled() {
+        scoped.budget = &budgetTracker{budget: budget, sites: make(map[string]int)}
+    }
+    return &scoped
+}
+
+// BudgetUsage возвращает, сколько запросов выполнено и сколько времени проведено в базе через копию WithBudget
+func (db *Database) BudgetUsage() (queries int, elapsed time.Duration) {
+    t := db.budget
+    if t == nil {
+        return 0, 0
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return t.queries, t.elapsed
+}
+
+// checkBudget возвращает ErrBudgetExceeded перед запросом, если бюджет уже превышен и задан Abort
+func (db *Database) checkBudget(name string) error {
+    t := db.budget
+    if t == nil || !t.budget.Abort {
+        return nil
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.exceeded {
+        return fmt.Errorf("%s: %w", name, ErrBudgetExceeded)
+    }
+    return nil
+}
+
+// chargeBudget учитывает выполненный запрос и логирует первое превышение бюджета
+func (db *Database) chargeBudget(name string, elapsed time.Duration) {
+    t := db.budget
+    if t == nil {
+        return
+    }
+    site := callSite()
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.queries++
+    t.elapsed += elapsed
+    t.sites[site]++
+    if t.exceeded {
+        return
+    }
+    if max := t.budget.MaxQueries; max > 0 && t.queries > max || t.budget.MaxTime > 0 && t.elapsed > t.budget.MaxTime {
+        t.exceeded = true
+        log.Printf("query budget exceeded at %s: %d queries in %v (limit %d queries, %v); top call sites: %s",
+            name, t.queries, t.elapsed, t.budget.MaxQueries, t.budget.MaxTime, t.topSites())
+    }
+}
+
+// topSites возвращает самые частые места вызова с числом запросов
+func (t *budgetTracker) topSites() string {
+    sites := make([]string, 0, len(t.sites))
+    for site := range t.sites {
+        sites = append(sites, site)
+    }
+    sort.Slice(sites, func(i, j int) bool {
+        if t.sites[sites[i]] != t.sites[sites[j]] {
+            return t.sites[sites[i]] > t.sites[sites[j]]
+        }
+        return sites[i] < sites[j]
+    })
+    if len(sites) > budgetTopSites {
+        sites = sites[:budgetTopSites]
+    }
+    for i, site := range sites {
+        sites[i] = fmt.Sprintf("%s (%d)", site, t.sites[site])
+    }
+    return strings.Join(sites, ", ")
+}
+
+// callSite описывает, откуда пришел запрос: первый экспортированный метод модуля в стеке
+// и место его вызова, например "GetUserByID <- serveReviews (api.go:81)". Вызывающим считается
+// кадр после последнего кадра этого метода, чтобы пропустить его замыкания и вспомогательные функции
+func callSite() string {
+    pcs := make([]uintptr, 32)
+    iter := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+    var frames []runtime.Frame
+    for {
+        frame, more := iter.Next()
+        frames = append(frames, frame)
+        if !more {
+            break
+        }
+    }
+
+    // первый кадр - всегда функция модуля, выполняющая запрос
+    own, _ := splitFunction(frames[0].Function)
+    api, last := "", -1
+    for i, frame := range frames {
+        pkg, function := splitFunction(frame.Function)
+        if api == "" && pkg == own && isExported(function) {
+            api = function
+        }
+        if api != "" && function == api {
+            last = i
+        }
+    }
+    switch {
+    case api == "":
+        return "unknown"
+    case last+1 >= len(frames):
+        return api
+    }
+    caller := frames[last+1]
+    _, function := splitFunction(caller.Function)
+    return fmt.Sprintf("%s <- %s (%s:%d)", api, function, filepath.Base(caller.File), caller.Line)
+}
+
+// splitFunction делит полное имя функции на пакет и имя без получателя, параметров типа и замыканий
+func splitFunction(name string) (pkg, function string) {
+    slash := strings.LastIndex(name, "/") + 1
+    dot := strings.Index(name[slash:], ".")
+    if dot < 0 {
+        return name, name
+    }
+    pkg, function = name[:slash+dot], name[slash+dot+1:]
+    if strings.HasPrefix(function, "(") {
+        function = function[strings.Index(function, ").")+2:]
+    }
+    if i := strings.IndexAny(function, ".["); i > 0 {
+        function = function[:i]
+    }
+    return pkg, function
+}
+
+// isExported сообщает, что функция - часть API модуля
+func isExported(name string) bool {
+    for _, r := range name {
+        return unicode.IsUpper(r)
+    }
+    return false
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "slices"
+    "strconv"
+    "time"
+)
+
+// Category - категория ресторанов площадки, например "пиццерия". Ресторан может входить
+// в несколько категорий (см. SetRestaurantCategories); категории заменяют текстовое поле Restaurant.Type
+type Category struct {
+    ID       int    `json:"id"`
+    TenantID int    `json:"tenant_id"`
+    // Name уникально в пределах площадки
+    Name      string    `json:"name"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateCategory добавляет категорию и заполняет ее ID, площадку и время создания.
+// Категория с тем же именем на площадке - ErrConflict
+func (db *Database) CreateCategory(category *Category) error {
+    if err := validateCategory(*category); err != nil {
+        return db.opError("insert", "category", category.Name, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        id, err := tx.insertNamed("categories.insert", tx.tenant, category.Name, tx.now().UTC())
+        if err != nil {
+            return err
+        }
+        created, err := tx.findCategory(int(id))
+        if err != nil {
+            return err
+        }
+        if created == nil {
+            return ErrNotFound
+        }
+        *category = *created
+        return tx.audit("category", category.ID, AuditInsert, nil, created)
+    })
+    return db.opError("insert", "category", category.Name, err)
+}
+
+// GetCategoryByID возвращает категорию площадки по ID или ErrNotFound
+func (db *Database) GetCategoryByID(id int) (Category, error) {
+    category, err := db.findCategory(id)
+    if err == nil && category == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return Category{}, db.opError("get", "category", id, err)
+    }
+    return *category, nil
+}
+
+// Categories возвращает категории площадки по имени
+func (db *Database) Categories() ([]Category, error) {
+    rows, err := db.queryNamed("categories.select", db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "categories", nil, err)
+    }
+    defer rows.Close()
+
+    var categories []Category
+    for rows.Next() {
+        category, err := scanCategory(rows)
+        if err != nil {
+            return nil, db.opError("list", "categories", nil, err)
+        }
+        categories = append(categories, category)
+    }
+    return categories, db.opError("list", "categories", nil, rows.Err())
+}
+
+// RenameCategory меняет имя категории; рестораны остаются в ней
+func (db *Database) RenameCategory(id int, name string) (Category, error) {
+    var renamed Category
+    if err := validateCategory(Category{ID: id, Name: name}); err != nil {
+        return renamed, db.opError("update", "category", id, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findCategory(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("categories.update", name, id, tx.tenant); err != nil {
+            return err
+        }
+        current, err := tx.findCategory(id)
+        if err != nil {
+            return err
+        }
+        renamed = *current
+        return tx.audit("category", id, AuditUpdate, old, current)
+    })
+    return renamed, db.opError("update", "category", id, err)
+}
+
+// DeleteCategory удаляет категорию и возвращает ее; рестораны из нее выходят, но не удаляются
+func (db *Database) DeleteCategory(id int) (Category, error) {
+    var deleted Category
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findCategory(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("categories.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        deleted = *old
+        return tx.audit("category", id, AuditDelete, old, nil)
+    })
+    return deleted, db.opError("delete", "category", id, err)
+}
+
+// RestaurantCategories возвращает категории ресторана по имени
+func (db *Database) RestaurantCategories(restaurantID int) ([]Category, error) {
+    rows, err := db.queryNamed("restaurant_categories.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "restaurant categories", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var categories []Category
+    for rows.Next() {
+        category, err := scanCategory(rows)
+        if err != nil {
+            return nil, db.opError("list", "restaurant categories", restaurantID, err)
+        }
+        categories = append(categories, category)
+    }
+    return categories, db.opError("list", "restaurant categories", restaurantID, rows.Err())
+}
+
+// SetRestaurantCategories заменяет категории ресторана на categoryIDs одной транзакцией и
+// возвращает их. Категория другой площадки или несуществующая - ErrForeignKeyViolation
+func (db *Database) SetRestaurantCategories(restaurantID int, categoryIDs []int) ([]Category, error) {
+    var categories []Category
+    err := db.InTx(func(tx *Database) error {
+        restaurant, err := tx.findRestaurant("restaurants.select_by_id", restaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if restaurant == nil {
+            return ErrNotFound
+        }
+        old, err := tx.RestaurantCategories(restaurantID)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.execNamed("restaurant_categories.delete_by_restaurant", restaurantID, tx.tenant); err != nil {
+            return err
+        }
+        var linked []int
+        for _, categoryID := range categoryIDs {
+            if slices.Contains(linked, categoryID) {
+                continue
+            }
+            result, err := tx.execNamed("restaurant_categories.insert", restaurantID, categoryID, tx.tenant)
+            if err != nil {
+                return err
+            }
+            if n, err := result.RowsAffected(); err == nil && n == 0 {
+                return fmt.Errorf("category %d does not exist: %w", categoryID, ErrForeignKeyViolation)
+            }
+            linked = append(linked, categoryID)
+        }
+        if categories, err = tx.RestaurantCategories(restaurantID); err != nil {
+            return err
+        }
+        return tx.audit("restaurant_category", restaurantID, AuditUpdate, categoryIDList(old), categoryIDList(categories))
+    })
+    return categories, db.opError("update", "restaurant categories", restaurantID, err)
+}
+
+// categoryIDList возвращает ID категорий для журнала аудита
+func categoryIDList(categories []Category) []int {
+    ids := make([]int, len(categories))
+    for i, category := range categories {
+        ids[i] = category.ID
+    }
+    return ids
+}
+
+// findCategory читает категорию текущей площадки; nil, если ее нет
+func (db *Database) findCategory(id int) (*Category, error) {
+    rows, err := db.queryNamed("categories.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    category, err := scanCategory(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &category, rows.Close()
+}
+
+// scanCategory читает категорию из текущей строки
+func scanCategory(row *queryRows) (Category, error) {
+    var category Category
+    err := row.Scan(&category.ID, &category.TenantID, &category.Name, &category.CreatedAt)
+    return category, err
+}
+
+// validateCategory проверяет категорию перед записью по правилам сущности "category"
+func validateCategory(category Category) error {
+    return checkInvariants("category", category)
+}
+
+// categoryBody - тело POST и PATCH /categories
+type categoryBody struct {
+    Name string `json:"name"`
+}
+
+// restaurantCategoriesBody - тело PUT /restaurants/{id}/categories
+type restaurantCategoriesBody struct {
+    CategoryIDs []int `json:"category_ids"`
+}
+
+// serveCategories отдает категории площадки
+func (db *Database) serveCategories(w http.ResponseWriter, r *http.Request) {
+    categories, err := db.Categories()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if categories == nil {
+        categories = []Category{}
+    }
+    writeJSON(w, http.StatusOK, categories)
+}
+
+// serveCreateCategory создает категорию; пользователь, вошедший через -auth, - только с PermissionManageCategories
+func (db *Database) serveCreateCategory(w http.ResponseWriter, r *http.Request) {
+    var body categoryBody
+    if err := decodeBody(r, &body); err != nil {
+        writeError(w, err)
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizePermission(actor, PermissionManageCategories); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    category := Category{Name: body.Name}
+    if err := db.CreateCategory(&category); err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, category)
+}
+
+// serveCategory переименовывает (PATCH) или удаляет (DELETE) категорию, как serveCreateCategory
+func (db *Database) serveCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizePermission(actor, PermissionManageCategories); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    var category Category
+    if r.Method == http.MethodDelete {
+        category, err = db.DeleteCategory(id)
+    } else {
+        var body categoryBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        category, err = db.RenameCategory(id, body.Name)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, category)
+}
+
+// serveRestaurantCategories отдает (GET) или заменяет (PUT) категории ресторана; заменяет их пользователь,
+// вошедший через -auth, только у своего ресторана, если он не администратор (см. CanManageRestaurant)
+func (db *Database) serveRestaurantCategories(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    var categories []Category
+    if r.Method == http.MethodPut {
+        var body restaurantCategoriesBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        if actor, ok := requestActor(r); ok {
+            if err := db.authorizeRestaurantID(actor, restaurantID); err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        categories, err = db.SetRestaurantCategories(restaurantID, body.CategoryIDs)
+    } else if _, err = db.GetRestaurantByID(restaurantID); err == nil {
+        categories, err = db.RestaurantCategories(restaurantID)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if categories == nil {
+        categories = []Category{}
+    }
+    writeJSON(w, http.StatusOK, categories)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+// PublishTimeout ограничивает отправку событий одной зафиксированной транзакции
+const PublishTimeout = 5 * time.Second
+
+// ChangeEvent - событие потока изменений (CDC) об одной записанной строке
+type ChangeEvent struct {
+    // Entity - сущность в тех же именах, что в журнале аудита: user, restaurant, menu_item, review
+    Entity string `json:"entity"`
+    // Op - AuditInsert, AuditUpdate или AuditDelete
+    Op     string `json:"op"`
+    ID     int    `json:"id"`
+    Tenant int    `json:"tenant"`
+    Actor  string `json:"actor,omitempty"`
+    // Payload - строка после изменения, для delete - до него; пароли пользователей не попадают
+    Payload json.RawMessage `json:"payload"`
+    Time    time.Time       `json:"time"`
+    // EventID - номер события в outbox (см. SetOutbox); событие, повторно отправленное после сбоя,
+    // приходит с тем же номером, и получатель может отбросить дубликат. 0 - событие не из outbox
+    EventID int64 `json:"event_id,omitempty"`
+}
+
+// Publisher отправляет события изменений во внешний брокер (NATS, Kafka и т. п.).
+// Publish получает события одной транзакции в порядке изменений и вызывается только
+// после ее фиксации; ошибка не откатывает изменения, а пишется в лог
+type Publisher interface {
+    Publish(ctx context.Context, events []ChangeEvent) error
+}
+
+// SetPublisher включает поток изменений в publisher или выключает его (nil); вызывается до начала работы
+func (db *Database) SetPublisher(publisher Publisher) {
+    db.publisher = publisher
+}
+
+// publishers отправляет события в несколько потоков изменений по очереди: ошибка одного не мешает остальным
+type publishers []Publisher
+
+// Publish отправляет события в каждый поток и возвращает их ошибки вместе
+func (p publishers) Publish(ctx context.Context, events []ChangeEvent) error {
+    var errs []error
+    for _, publisher := range p {
+        if err := publisher.Publish(ctx, events); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// combinePublishers объединяет потоки изменений, пропуская nil; без потоков возвращает nil
+func combinePublishers(list ...Publisher) Publisher {
+    var combined publishers
+    for _, publisher := range list {
+        if publisher != nil {
+            combined = append(combined, publisher)
+        }
+    }
+    switch len(combined) {
+    case 0:
+        return nil
+    case 1:
+        return combined[0]
+    }
+    return combined
+}
+
+// recordChange записывает событие в outbox (см. SetOutbox), добавляет его к изменениям транзакции
+// или сразу отправляет, если транзакции нет
+func (db *Database) recordChange(entity string, id int, action string, payload json.RawMessage) error {
+    event := ChangeEvent{Entity: entity, Op: action, ID: id, Tenant: db.tenant, Actor: db.actor, Payload: payload, Time: db.now().UTC()}
+    if db.outbox {
+        return db.insertOutboxEvent(event)
+    }
+    if db.publisher == nil {
+        return nil
+    }
+    if db.changes != nil {
+        *db.changes = append(*db.changes, event)
+        return nil
+    }
+    db.publish([]ChangeEvent{event})
+    return nil
+}
+
+// publish отправляет события зафиксированной транзакции
+func (db *Database) publish(events []ChangeEvent) {
+    if db.publisher == nil || len(events) == 0 {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+    defer cancel()
+    if err := db.publisher.Publish(ctx, events); err != nil {
+        log.Printf("cdc: publishing %d events: %v", len(events), err)
+    }
+}
+
+// WriterPublisher пишет события строками JSON в w, например в stdout для передачи
+// в kafka-console-producer или kcat
+type WriterPublisher struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+// NewWriterPublisher создает публикацию строк JSON в w
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+    return &WriterPublisher{w: w}
+}
+
+// Publish пишет каждое событие отдельной строкой
+func (p *WriterPublisher) Publish(ctx context.Context, events []ChangeEvent) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    encoder := json.NewEncoder(p.w)
+    for _, event := range events {
+        if err := encoder.Encode(event); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// NATSPublisher публикует события в NATS по текстовому протоколу ядра: каждое событие -
+// в тему <prefix>.<entity>.<op>, например dbmodule.restaurant.update. После событий транзакции
+// отправляется PING, и Publish ждет PONG, чтобы знать, что сервер их принял.
+// Разорванное соединение восстанавливается при следующей отправке
+type NATSPublisher struct {
+    addr   string
+    prefix string
+
+    mu     sync.Mutex
+    conn   net.Conn
+    reader *bufio.Reader
+}
+
+// NewNATSPublisher подключается к серверу NATS по адресу addr, например "127.0.0.1:4222"
+func NewNATSPublisher(addr, prefix string) (*NATSPublisher, error) {
+    p := &NATSPublisher{addr: addr, prefix: prefix}
+    ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+    defer cancel()
+    if err := p.connect(ctx); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// Publish отправляет события и дожидается подтверждения сервера
+func (p *NATSPublisher) Publish(ctx context.Context, events []ChangeEvent) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.conn == nil {
+        if err := p.connect(ctx); err != nil {
+            return err
+        }
+    }
+    if err := p.publish(ctx, events); err != nil {
+        p.conn.Close()
+        p.conn = nil
+        return err
+    }
+    return nil
+}
+
+// Close закрывает соединение с NATS
+func (p *NATSPublisher) Close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.conn == nil {
+        return nil
+    }
+    err := p.conn.Close()
+    p.conn = nil
+    return err
+}
+
+// connect устанавливает соединение: сервер присылает INFO, клиент отвечает CONNECT
+func (p *NATSPublisher) connect(ctx context.Context) error {
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+    if err != nil {
+        return fmt.Errorf("connecting to NATS %s: %w", p.addr, err)
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    reader := bufio.NewReader(conn)
+    line, err := reader.ReadString('\n')
+    if err == nil && !strings.HasPrefix(line, "INFO ") {
+        err = fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+    }
+    if err == nil {
+        _, err = io.WriteString(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"dbModule\"}\r\n")
+    }
+    if err != nil {
+        conn.Close()
+        return fmt.Errorf("connecting to NATS %s: %w", p.addr, err)
+    }
+    p.conn, p.reader = conn, reader
+    return nil
+}
+
+// publish отправляет PUB для каждого события и PING, затем читает ответ до PONG
+func (p *NATSPublisher) publish(ctx context.Context, events []ChangeEvent) error {
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(PublishTimeout)
+    }
+    p.conn.SetDeadline(deadline)
+
+    writer := bufio.NewWriter(p.conn)
+    for _, event := range events {
+        data, err := json.Marshal(event)
+        if err != nil {
+            return err
+        }
+        fmt.Fprintf(writer, "PUB %s.%s.%s %d\r\n", p.prefix, event.Entity, event.Op, len(data))
+        writer.Write(data)
+        writer.WriteString("\r\n")
+    }
+    writer.WriteString("PING\r\n")
+    if err := writer.Flush(); err != nil {
+        return err
+    }
+    for {
+        line, err := p.reader.ReadString('\n')
+        if err != nil {
+            return err
+        }
+        switch line = strings.TrimSpace(line); {
+        case line == "PONG":
+            return nil
+        case line == "PING":
+            if _, err := io.WriteString(p.conn, "PONG\r\n"); err != nil {
+                return err
+            }
+        case strings.HasPrefix(line, "-ERR"):
+            return fmt.Errorf("NATS: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+        }
+    }
+}
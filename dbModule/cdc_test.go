@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// blockingPublisher задерживает первую отправку, пока не закрыт unblock, как брокер, который не отвечает
+type blockingPublisher struct {
+    blocked atomic.Bool
+    entered chan struct{}
+    unblock chan struct{}
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, events []ChangeEvent) error {
+    if p.blocked.CompareAndSwap(false, true) {
+        close(p.entered)
+        <-p.unblock
+    }
+    return nil
+}
+
+// TestPublishAfterWriteTurn проверяет, что события транзакции отправляются после того, как она
+// отдала очередь записи: следующая запись не ждет медленного брокера
+func TestPublishAfterWriteTurn(t *testing.T) {
+    db := NewTestDatabase(t)
+    publisher := &blockingPublisher{entered: make(chan struct{}), unblock: make(chan struct{})}
+    db.SetPublisher(publisher)
+
+    committed := make(chan error, 1)
+    go func() {
+        committed <- db.InTx(func(tx *Database) error {
+            return tx.InsertUser(User{Name: "Ivan", Lastname: "First", Email: "first@example.com", Password: "Publish-Passw0rd!"})
+        })
+    }()
+    select {
+    case <-publisher.entered:
+    case <-time.After(5 * time.Second):
+        t.Fatal("transaction did not publish its changes")
+    }
+
+    written := make(chan error, 1)
+    go func() {
+        written <- db.InsertUser(User{Name: "Olga", Lastname: "Second", Email: "second@example.com", Password: "Publish-Passw0rd!"})
+    }()
+    select {
+    case err := <-written:
+        if err != nil {
+            t.Error(err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Error("write waited for the previous transaction to publish its changes")
+    }
+
+    close(publisher.unblock)
+    if err := <-committed; err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// CircuitProbeTimeout - сколько ждать ответа базы при проверке открытого автомата и в HealthCheck
+var CircuitProbeTimeout = 2 * time.Second
+
+// CircuitBreakerOptions настраивают автомат отключения: после Failures ошибок недоступности базы
+// подряд (см. isUnavailableError) запросы сразу получают ErrCircuitOpen, не дожидаясь таймаутов,
+// а база проверяется ping не чаще раза в ProbeInterval. Первая удачная проверка закрывает автомат:
+// пул соединений sql.DB при этом заново подключается вместо разорванных соединений
+type CircuitBreakerOptions struct {
+    // Failures - число ошибок недоступности подряд, открывающее автомат; 0 - автомат выключен
+    Failures int
+    // ProbeInterval - как часто открытый автомат проверяет базу
+    ProbeInterval time.Duration
+}
+
+// DefaultCircuitBreaker - автомат, который не открывается от единичных сбоев сети
+var DefaultCircuitBreaker = CircuitBreakerOptions{Failures: 5, ProbeInterval: 5 * time.Second}
+
+// Состояния автомата отключения
+const (
+    CircuitClosed = "closed"
+    CircuitOpen   = "open"
+)
+
+// CircuitState - состояние автомата отключения (см. HealthCheck)
+type CircuitState struct {
+    // State - closed (запросы идут в базу) или open (запросы получают ErrCircuitOpen)
+    State string `json:"state"`
+    // Failures - ошибок недоступности подряд с последнего удачного запроса
+    Failures int `json:"failures"`
+    // OpenedAt и NextProbe заданы у открытого автомата: когда он открылся и когда база будет проверена
+    OpenedAt  *time.Time `json:"opened_at,omitempty"`
+    NextProbe *time.Time `json:"next_probe,omitempty"`
+    // LastError - последняя ошибка недоступности
+    LastError string `json:"last_error,omitempty"`
+}
+
+// circuitBreaker - автомат отключения, общий для всех копий Database
+type circuitBreaker struct {
+    options   CircuitBreakerOptions
+    mu        sync.Mutex
+    failures  int
+    open      bool
+    openedAt  time.Time
+    nextProbe time.Time
+    // probing задан, пока одна из операций проверяет базу; остальные в это время получают ErrCircuitOpen
+    probing   bool
+    lastError error
+}
+
+// record учитывает итог обращения к базе: ошибка недоступности приближает открытие автомата,
+// удачный запрос или ошибка самого запроса (ограничение, синтаксис) показывают, что база отвечает
+func (c *circuitBreaker) record(err error, now time.Time) {
+    if c == nil || c.options.Failures <= 0 || errors.Is(err, ErrCircuitOpen) {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !isUnavailableError(err) {
+        if c.open {
+            log.Printf("database is available again, circuit breaker closed after %v", now.Sub(c.openedAt).Round(time.Millisecond))
+        }
+        c.failures, c.open, c.lastError = 0, false, nil
+        return
+    }
+    c.failures++
+    c.lastError = err
+    if !c.open && c.failures >= c.options.Failures {
+        c.open, c.openedAt, c.nextProbe = true, now, now.Add(c.options.ProbeInterval)
+        log.Printf("database is unavailable, circuit breaker open after %d failures: %v", c.failures, err)
+    }
+}
+
+// allow решает, можно ли обратиться к базе. У открытого автомата, если подошло время проверки,
+// одна операция проверяет базу ping и при успехе закрывает автомат; иначе возвращается ErrCircuitOpen
+func (c *circuitBreaker) allow(ctx context.Context, ping func(context.Context) error, now func() time.Time) error {
+    if c == nil || c.options.Failures <= 0 {
+        return nil
+    }
+    c.mu.Lock()
+    if !c.open {
+        c.mu.Unlock()
+        return nil
+    }
+    if c.probing || now().Before(c.nextProbe) {
+        err := fmt.Errorf("%w since %s: %v", ErrCircuitOpen, c.openedAt.Format(time.RFC3339), c.lastError)
+        c.mu.Unlock()
+        return err
+    }
+    c.probing = true
+    c.mu.Unlock()
+
+    ctx, cancel := context.WithTimeout(ctx, CircuitProbeTimeout)
+    err := ping(ctx)
+    cancel()
+
+    c.mu.Lock()
+    c.probing = false
+    c.mu.Unlock()
+    c.record(err, now())
+    if err != nil {
+        c.mu.Lock()
+        c.nextProbe = now().Add(c.options.ProbeInterval)
+        c.mu.Unlock()
+        return fmt.Errorf("%w: probe failed: %v", ErrCircuitOpen, err)
+    }
+    return nil
+}
+
+// state возвращает текущее состояние автомата
+func (c *circuitBreaker) state() CircuitState {
+    if c == nil {
+        return CircuitState{State: CircuitClosed}
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    state := CircuitState{State: CircuitClosed, Failures: c.failures}
+    if c.lastError != nil {
+        state.LastError = c.lastError.Error()
+    }
+    if c.open {
+        openedAt, nextProbe := c.openedAt, c.nextProbe
+        state.State, state.OpenedAt, state.NextProbe = CircuitOpen, &openedAt, &nextProbe
+    }
+    return state
+}
+
+// checkCircuit возвращает ErrCircuitOpen для запроса name, пока автомат открыт (см. CircuitBreakerOptions).
+// Внутри транзакции не проверяет: начатая транзакция доходит до конца
+func (db *Database) checkCircuit(name string) error {
+    if db.tx != nil {
+        return nil
+    }
+    if err := db.breaker.allow(db.baseContext(), db.DB.PingContext, db.now); err != nil {
+        return fmt.Errorf("%s: %w", name, err)
+    }
+    return nil
+}
+
+// Circuit возвращает состояние автомата отключения
+func (db *Database) Circuit() CircuitState {
+    return db.breaker.state()
+}
+
+// Health - результат HealthCheck
+type Health struct {
+    // Status - ok, degraded (основная база отвечает, но часть реплик недоступна) или unavailable
+    Status   string          `json:"status"`
+    Circuit  CircuitState    `json:"circuit"`
+    Replicas []ReplicaStatus `json:"replicas,omitempty"`
+    // Error - почему база недоступна
+    Error    string          `json:"error,omitempty"`
+}
+
+// HealthCheck проверяет основную базу ping (у открытого автомата - не чаще его ProbeInterval)
+// и возвращает состояние базы, автомата отключения и реплик. Ошибка - основная база недоступна
+func (db *Database) HealthCheck(ctx context.Context) (Health, error) {
+    err := db.breaker.allow(ctx, db.DB.PingContext, db.now)
+    if err == nil && db.breaker.state().State == CircuitClosed {
+        pingCtx, cancel := context.WithTimeout(ctx, CircuitProbeTimeout)
+        err = db.DB.PingContext(pingCtx)
+        cancel()
+        db.breaker.record(err, db.now())
+    }
+
+    health := Health{Status: "ok", Circuit: db.breaker.state(), Replicas: db.Replicas()}
+    for _, replica := range health.Replicas {
+        if !replica.Healthy {
+            health.Status = "degraded"
+        }
+    }
+    if err != nil {
+        health.Status, health.Error = "unavailable", err.Error()
+    }
+    return health, db.opError("health check", "database", nil, err)
+}
+
+// serveHealth отвечает состоянием базы (см. HealthCheck): 200, пока основная база отвечает, иначе 503
+func (db *Database) serveHealth(w http.ResponseWriter, r *http.Request) {
+    health, err := db.HealthCheck(r.Context())
+    status := http.StatusOK
+    if err != nil {
+        status = http.StatusServiceUnavailable
+    }
+    writeJSON(w, status, health)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// Clock - источник текущего времени для всего, что модуль записывает в базу или сравнивает
+// со сроками: времена создания отзывов и записей журнала, сроки сессий и токенов, истечение кешей.
+// Длительности запросов и времена файлов хранилища всегда берутся по настоящим часам
+type Clock interface {
+    Now() time.Time
+}
+
+// IDGenerator - источник случайных идентификаторов, которые модуль выдает клиентам:
+// токенов сессий и сброса пароля
+type IDGenerator interface {
+    NewID() (string, error)
+}
+
+// SetClock задает часы модуля; nil - системные часы. Вызывается до начала работы с базой
+func (db *Database) SetClock(clock Clock) {
+    db.clock = clock
+}
+
+// SetIDGenerator задает источник идентификаторов; nil - случайные токены. Вызывается до начала работы с базой
+func (db *Database) SetIDGenerator(ids IDGenerator) {
+    db.ids = ids
+}
+
+// now возвращает текущее время по часам модуля
+func (db *Database) now() time.Time {
+    if db.clock == nil {
+        return time.Now()
+    }
+    return db.clock.Now()
+}
+
+// newID возвращает новый идентификатор из источника модуля
+func (db *Database) newID() (string, error) {
+    if db.ids == nil {
+        return newToken()
+    }
+    return db.ids.NewID()
+}
+
+// ManualClock - часы, которые стоят, пока их не переведут; для тестов
+type ManualClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+// NewManualClock создает часы, показывающие now
+func NewManualClock(now time.Time) *ManualClock {
+    return &ManualClock{now: now}
+}
+
+// Now возвращает заданное время
+func (c *ManualClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+// Set переводит часы на now
+func (c *ManualClock) Set(now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = now
+}
+
+// Advance переводит часы вперед на d
+func (c *ManualClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+// SequentialIDs выдает предсказуемые идентификаторы <Prefix>-1, <Prefix>-2 и т. д.; для тестов
+type SequentialIDs struct {
+    Prefix string
+
+    mu   sync.Mutex
+    next int
+}
+
+// NewID возвращает следующий идентификатор
+func (s *SequentialIDs) NewID() (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.next++
+    return fmt.Sprintf("%s-%d", s.Prefix, s.next), nil
+}
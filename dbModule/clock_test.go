@@ -0,0 +1,70 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+// TestFreezeTestClock проверяет, что на остановленных часах FreezeTestClock времена создания записей
+// и выданные идентификаторы повторяются от запуска к запуску
+func TestFreezeTestClock(t *testing.T) {
+    db := NewTestDatabase(t)
+    at := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+    clock := FreezeTestClock(t, db, at)
+
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Clock-Passw0rd!", Role: RoleOwner}
+    if _, err := db.InsertUserReturningID(&owner); err != nil {
+        t.Fatal(err)
+    }
+    clock.Advance(time.Minute)
+    restaurant := Restaurant{Name: "Cafe", Type: "cafe", AveragePrice: PriceTierBudget, UserID: owner.ID}
+    if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+        t.Fatal(err)
+    }
+    if owner.ID != 1 || restaurant.ID != 1 {
+        t.Errorf("got user %d and restaurant %d, want both 1 in a new database", owner.ID, restaurant.ID)
+    }
+
+    for _, tt := range []struct {
+        entity string
+        id     int
+        want   time.Time
+    }{
+        {"user", owner.ID, at},
+        {"restaurant", restaurant.ID, at.Add(time.Minute)},
+    } {
+        trail, err := db.AuditTrail(tt.entity, tt.id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(trail) != 1 || !trail[0].CreatedAt.Equal(tt.want) {
+            t.Errorf("%s %d audit trail is %v, want one insert at %v", tt.entity, tt.id, trail, tt.want)
+        }
+    }
+
+    clock.Advance(time.Hour)
+    for i, want := range []string{"test-1", "test-2"} {
+        session, err := db.CreateSession(owner.ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if session.Token != want {
+            t.Errorf("session %d token is %q, want %q", i, session.Token, want)
+        }
+    }
+    session, err := db.ValidateSession("test-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    created := at.Add(time.Minute + time.Hour)
+    if !session.CreatedAt.Equal(created) || !session.ExpiresAt.Equal(created.Add(SessionTTL)) {
+        t.Errorf("session is %v - %v, want %v - %v", session.CreatedAt, session.ExpiresAt, created, created.Add(SessionTTL))
+    }
+
+    // сессия истекает ровно через SessionTTL по часам теста
+    clock.Advance(SessionTTL)
+    if _, err := db.ValidateSession("test-1"); !errors.Is(err, ErrInvalidSession) {
+        t.Errorf("session after SessionTTL: got %v, want ErrInvalidSession", err)
+    }
+}
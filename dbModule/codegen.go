@@ -0,0 +1,140 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "go/format"
+    "io/ioutil"
+    "os"
+    "strings"
+)
+
+// WriteGoModels выводит модели пакета pkg по таблицам схемы: структуру строки (см. WriteGoStructs),
+// константы с именами таблицы и колонок, список колонок в порядке таблицы и функцию Scan<Структура>,
+// которая читает строку, выбранную с этими колонками. Имена таблиц в константах - без префикса
+// экземпляра, как в файлах запросов. source попадает в заголовок файла
+func (doc SchemaDoc) WriteGoModels(source, pkg string) ([]byte, error) {
+    var b strings.Builder
+    fmt.Fprintf(&b, "// Code generated by dbModule codegen from %s; DO NOT EDIT.\n\npackage %s\n", source, pkg)
+
+    var body strings.Builder
+    body.WriteString("\n// RowScanner - строка результата запроса: *sql.Row или *sql.Rows\n")
+    body.WriteString("type RowScanner interface {\nScan(dest ...interface{}) error\n}\n")
+    usesTime := false
+    for _, table := range doc.Tables {
+        if doc.writeGoStruct(&body, table) {
+            usesTime = true
+        }
+        doc.writeGoColumns(&body, table)
+    }
+    if usesTime {
+        b.WriteString("\nimport \"time\"\n")
+    }
+    b.WriteString(body.String())
+
+    formatted, err := format.Source([]byte(b.String()))
+    if err != nil {
+        return nil, fmt.Errorf("formatting generated models: %w", err)
+    }
+    return formatted, nil
+}
+
+// writeGoColumns выводит константы таблицы и ее колонок, список колонок и функцию чтения строки
+func (doc SchemaDoc) writeGoColumns(b *strings.Builder, table TableDoc) {
+    name := doc.goTableName(table)
+    fmt.Fprintf(b, "\n// Таблица %s и ее колонки\nconst (\n%sTable = %q\n", doc.unprefixed(table), name, doc.unprefixed(table))
+    columns := make([]string, len(table.Columns))
+    fields := make([]string, len(table.Columns))
+    for i, column := range table.Columns {
+        columns[i] = name + "Column" + goIdentifier(column.Name)
+        fields[i] = "&row." + goIdentifier(column.Name)
+        fmt.Fprintf(b, "%s = %q\n", columns[i], strings.ToLower(column.Name))
+    }
+    b.WriteString(")\n")
+
+    fmt.Fprintf(b, "\n// %sColumns - колонки %s в порядке таблицы, как их читает Scan%s\n", name, doc.unprefixed(table), name)
+    fmt.Fprintf(b, "var %sColumns = []string{%s}\n", name, strings.Join(columns, ", "))
+    fmt.Fprintf(b, "\n// Scan%s читает строку %s, выбранную с колонками %sColumns\n", name, doc.unprefixed(table), name)
+    fmt.Fprintf(b, "func Scan%s(scanner RowScanner) (%s, error) {\nvar row %s\nerr := scanner.Scan(%s)\nreturn row, err\n}\n",
+        name, name, name, strings.Join(fields, ", "))
+}
+
+// codegenSource - откуда codegen берет схему, для заголовка сгенерированного файла
+const codegenSource = "the migrations in the query files"
+
+// runCodegen генерирует модели Go по схеме, которую дают миграции из файлов запросов (см. MigrationSchema):
+// CREATE TABLE и последующие ALTER TABLE применяются к временной базе SQLite, поэтому живая база
+// не нужна. С -check файл -out не перезаписывается, а сверяется со сгенерированным: так CI замечает
+// миграцию, после которой модели не пересобрали
+func runCodegen(db *Database, args []string) error {
+    flags := flag.NewFlagSet("codegen", flag.ContinueOnError)
+    pkg := flags.String("package", "models", "package name of the generated Go file")
+    output := flags.String("out", "", "output file (default: stdout)")
+    check := flags.Bool("check", false, "fail if -out differs from the generated code instead of writing it")
+    only := flags.String("tables", "", "comma-separated tables to generate models for, without the table prefix (default: all)")
+    descriptionsPath := flags.String("descriptions", "./config/schema_docs.yaml", "YAML file with table and column descriptions for Go doc comments")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *check && *output == "" {
+        return fmt.Errorf("codegen -check needs -out with the file to compare")
+    }
+
+    descriptions, err := LoadSchemaDescriptions(*descriptionsPath)
+    if err != nil {
+        return err
+    }
+    tables, err := db.MigrationSchema()
+    if err != nil {
+        return err
+    }
+    doc := SchemaDoc{Dialect: db.driver.dialect.Name(), TablePrefix: db.tablePrefix}
+    wanted := make(map[string]bool)
+    for _, table := range strings.Split(*only, ",") {
+        if table = strings.TrimSpace(table); table != "" {
+            wanted[strings.ToLower(table)] = true
+        }
+    }
+    for _, table := range tables {
+        name := doc.unprefixed(table)
+        if len(wanted) > 0 && !wanted[name] {
+            continue
+        }
+        delete(wanted, name)
+        description := descriptions[name]
+        table.Description = description.Description
+        for i := range table.Columns {
+            table.Columns[i].Description = description.Columns[table.Columns[i].Name]
+        }
+        doc.Tables = append(doc.Tables, table)
+    }
+    for table := range wanted {
+        return fmt.Errorf("table %s is not created by the migrations", table)
+    }
+
+    source, err := doc.WriteGoModels(codegenSource, *pkg)
+    if err != nil {
+        return err
+    }
+    switch {
+    case *output == "":
+        _, err = os.Stdout.Write(source)
+        return err
+    case *check:
+        existing, err := ioutil.ReadFile(*output)
+        if err != nil {
+            return err
+        }
+        if !bytes.Equal(existing, source) {
+            return fmt.Errorf("%s is out of date with the migrations, regenerate it with codegen -out %s", *output, *output)
+        }
+        fmt.Printf("%s is up to date\n", *output)
+        return nil
+    }
+    if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+        return err
+    }
+    fmt.Printf("Generated models for %d tables in %s\n", len(doc.Tables), *output)
+    return nil
+}
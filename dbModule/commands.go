@@ -0,0 +1,248 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+)
+
+// command описывает подкоманду CLI
+type command struct {
+    description string
+    run         func(db *Database, args []string) error
+}
+
+// commands содержит все подкоманды, доступные как `dbModule <команда> [флаги]`
+var commands = map[string]command{
+    "analytics-export": {
+        description: "write tables as gzipped CSV with a schema manifest for the analytics warehouse, all rows or with -since only changed ones",
+        run:         runAnalyticsExport,
+    },
+    "attachments": {
+        description: "list, add, download or delete files attached to restaurants, users, menu items and reviews",
+        run:         runAttachments,
+    },
+    "backup": {
+        description: "save a consistent snapshot of the SQLite database to a file",
+        run:         runBackup,
+    },
+    "bench": {
+        description: "bench seed fills the database with generated users and restaurants, bench run measures insert and select throughput",
+        run:         runBench,
+    },
+    "blob-gc": {
+        description: "remove stored files no longer referenced by images or documents",
+        run:         runBlobGC,
+    },
+    "browse": {
+        description: "browse, filter and edit tables interactively in the terminal",
+        run:         runBrowse,
+    },
+    "codegen": {
+        description: "generate Go model structs, column constants and scan functions from the migrations in the query files; -check fails if -out is stale",
+        run:         runCodegen,
+    },
+    "delete": {
+        description: "delete users or restaurants matching a filter, or all data with delete all -confirm",
+        run:         runDelete,
+    },
+    "docs": {
+        description: "print Markdown or HTML documentation of tables, constraints and named queries",
+        run:         runDocs,
+    },
+    "embed": {
+        description: "compute restaurant embeddings for similarity search",
+        run:         runEmbed,
+    },
+    "explain": {
+        description: "print the query plan of a named query or SQL text, e.g. explain restaurants.select_join",
+        run:         runExplain,
+    },
+    "export": {
+        description: "stream a table of the current tenant as JSON Lines, optionally gzip-compressed",
+        run:         runExport,
+    },
+    "export-listings": {
+        description: "export restaurants with menus, hours and tags in the interchange format, or its JSON Schema",
+        run:         runExportListings,
+    },
+    "fsck": {
+        description: "find orphaned rows, dangling blobs and reference count drift; -repair fixes them in one transaction",
+        run:         runFsck,
+    },
+    "golden": {
+        description: "rebuild the golden SQLite database for tests from fixture sets (see NewDatabaseFromSnapshot)",
+        run:         runGolden,
+    },
+    "history": {
+        description: "print the saved versions of a user or restaurant, or the version in effect at a time (history -entity restaurant -id N -at TIME); needs -history",
+        run:         runHistory,
+    },
+    "import-listings": {
+        description: "validate and import restaurants in the interchange format, all or nothing",
+        run:         runImportListings,
+    },
+    "jobs": {
+        description: "run an import or export as a job with progress, or list, show and cancel jobs",
+        run:         runJobs,
+    },
+    "locks": {
+        description: "list locks held by instances running migrations or seeders, with owners and expiry",
+        run:         runLocks,
+    },
+    "maintenance": {
+        description: "turn maintenance mode (read-only) on or off, or show it: maintenance on|off|status",
+        run:         runMaintenance,
+    },
+    "migrate-data": {
+        description: "copy all tables into another database (e.g. SQLite to PostgreSQL) keeping IDs, then verify row counts and checksums",
+        run:         runMigrateData,
+    },
+    "mockgen": {
+        description: "generate a mock of an interface with per-method answer functions and a call log, by default MockStore from store.go; -check fails if -out is stale",
+        run:         runMockgen,
+    },
+    "outbox": {
+        description: "publish change events written with -cdc-outbox until interrupted (outbox relay), or count pending ones (outbox status)",
+        run:         runOutbox,
+    },
+    "public-ids": {
+        description: "give users and restaurants added before -id-scheme was set a public_id",
+        run:         runPublicIDs,
+    },
+    "query-report": {
+        description: "print the most time-consuming named queries per day",
+        run:         runQueryReport,
+    },
+    "reencrypt": {
+        description: "save a copy of the SQLite database encrypted with the key from "+NewEncryptionKeyEnv+" (sqlcipher driver)",
+        run:         runReencrypt,
+    },
+    "report": {
+        description: "list the reports defined in reports.yaml (report list) or run one with parameters (report run NAME k=v)",
+        run:         runReport,
+    },
+    "restore": {
+        description: "replace the SQLite database contents with a snapshot made by backup",
+        run:         runRestore,
+    },
+    "retention": {
+        description: "list the data retention policies from -retention (retention list) or apply them now, optionally with -dry-run (retention apply)",
+        run:         runRetention,
+    },
+    "rotate-pii": {
+        description: "re-encrypt user emails and phones with the current key from "+PIIKeysEnv+", e.g. after adding a key",
+        run:         runRotatePII,
+    },
+    "schema": {
+        description: "schema dump prints the live schema as CREATE statements or, with -format go, as Go structs",
+        run:         runSchema,
+    },
+    "schema-change": {
+        description: "change large tables without long locks: add a column with a default, backfill it in batches, switch dual writes",
+        run:         runSchemaChange,
+    },
+    "script": {
+        description: "run a SQL script file in one transaction, e.g. an ad-hoc migration",
+        run:         runScript,
+    },
+    "seed": {
+        description: "load a named fixture set, or the fixture sets and post-seed hooks of an environment profile (seed -profile staging), into the database",
+        run:         runSeed,
+    },
+    "session-gc": {
+        description: "remove expired user sessions",
+        run:         runSessionGC,
+    },
+    "similar": {
+        description: "print restaurants similar to the given one",
+        run:         runSimilar,
+    },
+    "snapshot": {
+        description: "export an anonymized, referentially consistent subset of the data as a fixture set",
+        run:         runSnapshot,
+    },
+    "sql": {
+        description: "interactive SQL prompt on the -db database: read-only unless -write, ? placeholders bound with \\bind, tabular output and history",
+        run:         runSQLShell,
+    },
+    "sql-fuzz": {
+        description: "drive write and search APIs with hostile inputs and fail if any input reaches SQL text",
+        run:         runSQLFuzz,
+    },
+    "tasks": {
+        description: "list maintenance tasks with their -schedule (tasks list) or run one now (tasks run -name vacuum|analyze|sessions|purge|retention)",
+        run:         runTasks,
+    },
+    "usage": {
+        description: "print per-tenant storage and request usage for a billing period, with -record recalculate it first",
+        run:         runUsage,
+    },
+    "user-data": {
+        description: "answer a data subject request: user-data export -id N prints the user's data as JSON, anonymize scrubs it",
+        run:         runUserData,
+    },
+    "verify": {
+        description: "list deprecated queries and whether they are still in use, check views and triggers against the configuration and filter columns for indexes",
+        run:         runVerify,
+    },
+    "wal-archive": {
+        description: "continuously ship SQLite WAL frames to a directory or S3 (wal-archive run), list restore points (wal-archive list) or restore the database to a point in time (wal-archive restore -at TIME)",
+        run:         runWALArchive,
+    },
+    "webhooks": {
+        description: "list change events the webhooks could not deliver (webhooks dead-letters) or send them again (webhooks redeliver)",
+        run:         runWebhooks,
+    },
+    "write-alerts": {
+        description: "report write queries changing far more rows than usual",
+        run:         runWriteAlerts,
+    },
+}
+
+// runCommand выполняет подкоманду с ее аргументами
+func runCommand(db *Database, args []string) error {
+    cmd, ok := commands[args[0]]
+    if !ok {
+        printCommands()
+        return fmt.Errorf("unknown command %q", args[0])
+    }
+    return cmd.run(db, args[1:])
+}
+
+// printCommands выводит список подкоманд
+func printCommands() {
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    fmt.Fprintln(os.Stderr, "Commands:")
+    for _, name := range names {
+        fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].description)
+    }
+}
+
+// runQueryReport печатает отчет по собранной статистике запросов
+func runQueryReport(db *Database, args []string) error {
+    flags := flag.NewFlagSet("query-report", flag.ContinueOnError)
+    days := flags.Int("days", 7, "number of days to include")
+    top := flags.Int("top", 5, "number of queries per day")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    stats, err := db.TopQueriesByDay(*days, *top)
+    if err != nil {
+        return err
+    }
+
+    for _, stat := range stats {
+        fmt.Printf("%s | %-32s | samples: %d | est. calls: %.0f | est. time: %v | avg: %v | avg rows: %.1f\n",
+            stat.Day, stat.Name, stat.Samples, stat.EstimatedCalls,
+            stat.EstimatedTime, stat.AverageTime, stat.AverageRows)
+    }
+    return nil
+}
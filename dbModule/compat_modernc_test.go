@@ -0,0 +1,9 @@
+//go:build modernc
+
+package main
+
+import "testing"
+
+func TestDriverCompatModernc(t *testing.T) {
+    checkDriverCompat(t, "sqlite")
+}
@@ -0,0 +1,9 @@
+//go:build sqlite || sqlcipher || !(modernc || postgres || mysql || mssql || oracle)
+
+package main
+
+import "testing"
+
+func TestDriverCompatSQLite3(t *testing.T) {
+    checkDriverCompat(t, "sqlite3")
+}
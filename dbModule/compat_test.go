@@ -0,0 +1,119 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Проверка совместимости драйверов SQLite: каждый собранный драйвер проходит один и тот же сценарий,
+// и его результаты сравниваются с эталоном testdata/driver_compat.golden. Тесты драйверов лежат
+// в файлах с их тегами сборки: go test проверяет mattn/go-sqlite3, go test -tags modernc - modernc,
+// go test -tags sqlite,modernc - оба сразу
+
+// updateGolden перезаписывает эталоны тестов вместо сравнения с ними: go test -run DriverCompat -update
+var updateGolden = flag.Bool("update", false, "rewrite golden files of tests instead of comparing with them")
+
+// driverCompatGolden - эталонный протокол сценария совместимости
+const driverCompatGolden = "testdata/driver_compat.golden"
+
+// checkDriverCompat прогоняет сценарий совместимости на драйвере driverName и сравнивает протокол
+// с эталоном построчно
+func checkDriverCompat(t *testing.T, driverName string) {
+    t.Helper()
+
+    queries, err := LoadQueries(*queriesFlag)
+    if err != nil {
+        t.Fatalf("load queries: %v", err)
+    }
+    transcript, err := compatTranscript(driverName, filepath.Join(t.TempDir(), driverName+".db"), queries)
+    if err != nil {
+        t.Fatalf("driver %s: %v", driverName, err)
+    }
+
+    if *updateGolden {
+        if err := os.WriteFile(driverCompatGolden, []byte(strings.Join(transcript, "\n")+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+        return
+    }
+    data, err := os.ReadFile(driverCompatGolden)
+    if err != nil {
+        t.Fatalf("read golden transcript (create it with go test -run DriverCompat -update): %v", err)
+    }
+    reference := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+    for i := 0; i < len(reference) || i < len(transcript); i++ {
+        var want, got string
+        if i < len(reference) {
+            want = reference[i]
+        }
+        if i < len(transcript) {
+            got = transcript[i]
+        }
+        if want != got {
+            t.Errorf("step %d:\n  golden: %s\n  %s: %s", i+1, want, driverName, got)
+        }
+    }
+}
+
+// compatTranscript выполняет сценарий совместимости на чистой базе и возвращает результаты шагов
+func compatTranscript(driverName, path string, queries *QueryRegistry) ([]string, error) {
+    db, err := NewDatabaseWithDriver(driverName, path, queries)
+    if err != nil {
+        return nil, err
+    }
+    defer db.Close()
+
+    var transcript []string
+    step := func(format string, args ...interface{}) {
+        transcript = append(transcript, fmt.Sprintf(format, args...))
+    }
+
+    step("initialize: %v", db.Initialize())
+    step("migrate again: %v", db.Migrate())
+
+    step("insert user: %v", db.InsertUser(User{Name: "Ivan", Lastname: "Petrov", Password: "compat-secret", Email: "ivan@example.com", Phone: stringPtr("+70000000000")}))
+    step("insert user: %v", db.InsertUser(User{Name: "Anna", Lastname: "Smirnova", Password: "compat-secret", Email: "anna@example.com"}))
+    step("insert restaurant: %v", db.InsertRestaurant(Restaurant{Name: "100%_pasta", Type: "italian", Keys: stringPtr("pasta"), AveragePrice: 3, UserID: 1}))
+    step("insert restaurant: %v", db.InsertRestaurant(Restaurant{Name: "100 burgers", Type: "american", AveragePrice: 2, UserID: 1}))
+    step("insert restaurant: %v", db.InsertRestaurant(Restaurant{Name: "Пельменная", Type: "russian", AveragePrice: 1, UserID: 2}))
+    owner := User{Name: "Oleg", Lastname: "Sidorov", Password: "compat-secret", Email: "oleg@example.com"}
+    ownerID, err := db.InsertUserReturningID(&owner)
+    step("insert user returning id: %d %v", ownerID, err)
+    cafe := Restaurant{Name: "Чайная", Type: "russian", AveragePrice: 1, UserID: owner.ID}
+    _, err = db.InsertRestaurantReturningID(&cafe)
+    step("insert restaurant returning id: %d %v", cafe.ID, err)
+
+    err = db.InsertRestaurant(Restaurant{Name: "orphan", AveragePrice: 1, UserID: 42})
+    step("insert restaurant with unknown owner: foreign key=%v", errors.Is(err, ErrForeignKeyViolation))
+
+    users, err := db.SelectUsers()
+    step("select users: %+v %v", users, err)
+    restaurants, err := db.SelectRestaurants()
+    step("select restaurants: %+v %v", restaurants, err)
+    joined, err := db.SelectJoin()
+    step("select join: %+v %v", joined, err)
+
+    filtered, err := db.SelectRestaurantsWhere(RestaurantFilter{NamePrefix: "100%_"})
+    step("filter by escaped prefix: %+v %v", filtered, err)
+    sorted, err := db.SelectRestaurantsWhere(RestaurantFilter{}, RestaurantSort{Field: SortByPrice, Descending: true})
+    step("sort by price desc: %+v %v", sorted, err)
+
+    aggregate, err := db.GetUserWithRestaurants(1)
+    step("user with restaurants: %+v %v", aggregate, err)
+    _, err = db.GetUserWithRestaurants(42)
+    step("missing user: not found=%v", errors.Is(err, ErrNotFound))
+
+    err = db.DeleteUser(1, DeleteRestrict)
+    var restricted *RestrictedDeleteError
+    step("restricted delete: %v", errors.As(err, &restricted))
+    step("cascade delete: %v", db.DeleteUser(1, DeleteCascade))
+
+    restaurants, err = db.SelectRestaurants()
+    step("restaurants after cascade: %+v %v", restaurants, err)
+    return transcript, nil
+}
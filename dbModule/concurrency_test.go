@@ -0,0 +1,219 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Тесты этого файла работают с одной базой из параллельных подтестов и горутин: вставки, обновления,
+// кеши выборок и повторный прогрев идут одновременно. Запускать с детектором гонок:
+//   go test -race -run Concurrent
+
+// concurrentWorkers - сколько горутин каждого вида запускает подтест
+const concurrentWorkers = 8
+
+// concurrentRestaurant возвращает допустимый ресторан с именем по номеру горутины и шага
+func concurrentRestaurant(worker, step int) Restaurant {
+    return Restaurant{
+        Name:         fmt.Sprintf("Concurrent %d-%d", worker, step),
+        Type:         "cafe",
+        AveragePrice: PriceTierBudget,
+    }
+}
+
+// runWorkers запускает n горутин work и проваливает тест первой ошибкой каждой из них
+func runWorkers(t *testing.T, n int, work func(worker int) error) {
+    t.Helper()
+
+    var wg sync.WaitGroup
+    errs := make(chan error, n)
+    for worker := 0; worker < n; worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := work(worker); err != nil {
+                errs <- fmt.Errorf("worker %d: %w", worker, err)
+            }
+        }()
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+}
+
+func TestConcurrentDatabase(t *testing.T) {
+    db := NewTestDatabase(t)
+    db.SetEntityCache(DefaultEntityCacheOptions)
+    for _, name := range []string{"users.select", "restaurants.select"} {
+        if err := db.SetQueryCache(name, time.Minute); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := db.WarmUp(); err != nil {
+        t.Fatal(err)
+    }
+
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Concurrent-Passw0rd!", Role: RoleOwner}
+    ownerID, err := db.InsertUserReturningID(&owner)
+    if err != nil {
+        t.Fatal(err)
+    }
+    shared := concurrentRestaurant(-1, 0)
+    shared.UserID = ownerID
+    sharedID, err := db.InsertRestaurantReturningID(&shared)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // подтесты группы идут параллельно друг другу; Run возвращается, когда закончатся все
+    t.Run("group", func(t *testing.T) {
+        t.Run("insert users", func(t *testing.T) {
+            t.Parallel()
+            runWorkers(t, concurrentWorkers, func(worker int) error {
+                for step := 0; step < 5; step++ {
+                    user := User{
+                        Name:     "Worker",
+                        Lastname: fmt.Sprint(worker),
+                        Email:    fmt.Sprintf("worker%d-%d@example.com", worker, step),
+                        Password: "Concurrent-Passw0rd!",
+                    }
+                    if _, err := db.InsertUserReturningID(&user); err != nil {
+                        return err
+                    }
+                }
+                return nil
+            })
+        })
+
+        t.Run("insert restaurants", func(t *testing.T) {
+            t.Parallel()
+            runWorkers(t, concurrentWorkers, func(worker int) error {
+                for step := 0; step < 5; step++ {
+                    restaurant := concurrentRestaurant(worker, step)
+                    restaurant.UserID = ownerID
+                    id, err := db.InsertRestaurantReturningID(&restaurant)
+                    if err != nil {
+                        return err
+                    }
+                    found, err := db.GetRestaurantByID(id)
+                    if err != nil {
+                        return err
+                    }
+                    if found.Name != restaurant.Name {
+                        return fmt.Errorf("restaurant %d is %q, want %q", id, found.Name, restaurant.Name)
+                    }
+                }
+                return nil
+            })
+        })
+
+        t.Run("update shared restaurant", func(t *testing.T) {
+            t.Parallel()
+            runWorkers(t, concurrentWorkers, func(worker int) error {
+                for step := 0; step < 5; step++ {
+                    fields := map[string]interface{}{"name": fmt.Sprintf("Shared %d-%d", worker, step)}
+                    if _, err := db.UpdateRestaurantFields(ownerID, sharedID, fields); err != nil {
+                        return err
+                    }
+                }
+                return nil
+            })
+        })
+
+        t.Run("cached reads", func(t *testing.T) {
+            t.Parallel()
+            runWorkers(t, concurrentWorkers, func(worker int) error {
+                for step := 0; step < 20; step++ {
+                    if _, err := db.SelectUsers(); err != nil {
+                        return err
+                    }
+                    restaurants, err := db.SelectRestaurants()
+                    if err != nil {
+                        return err
+                    }
+                    // копия из кеша принадлежит вызывающему: запись в нее не должна гоняться с другими читателями
+                    for i := range restaurants {
+                        restaurants[i].Name = ""
+                    }
+                    if _, err := db.GetRestaurantByID(sharedID); err != nil {
+                        return err
+                    }
+                    if _, err := db.GetUserByID(ownerID); err != nil {
+                        return err
+                    }
+                }
+                return nil
+            })
+        })
+
+        t.Run("warm up", func(t *testing.T) {
+            t.Parallel()
+            // повторный прогрев заменяет подготовленные запросы, которыми в это время пользуются остальные подтесты
+            runWorkers(t, 2, func(worker int) error {
+                for step := 0; step < 3; step++ {
+                    if _, err := db.WarmUp(); err != nil {
+                        return err
+                    }
+                }
+                return nil
+            })
+        })
+    })
+
+    users, err := db.SelectUsers()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := 1 + concurrentWorkers*5; len(users) != want {
+        t.Errorf("got %d users, want %d", len(users), want)
+    }
+    restaurants, err := db.SelectRestaurants()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := 1 + concurrentWorkers*5; len(restaurants) != want {
+        t.Errorf("got %d restaurants, want %d", len(restaurants), want)
+    }
+    updated, err := db.GetRestaurantByID(sharedID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := 1 + concurrentWorkers*5; updated.Version != want {
+        t.Errorf("shared restaurant version is %d after %d updates, want %d", updated.Version, want-1, want)
+    }
+}
+
+// TestConcurrentIsolatedDatabases проверяет, что параллельные тесты на NewIsolatedTestDatabase
+// не видят данных друг друга
+func TestConcurrentIsolatedDatabases(t *testing.T) {
+    for i := 0; i < concurrentWorkers; i++ {
+        t.Run(fmt.Sprint(i), func(t *testing.T) {
+            t.Parallel()
+            db := NewIsolatedTestDatabase(t)
+            owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Concurrent-Passw0rd!", Role: RoleOwner}
+            if _, err := db.InsertUserReturningID(&owner); err != nil {
+                t.Fatal(err)
+            }
+            restaurant := concurrentRestaurant(i, 0)
+            restaurant.UserID = owner.ID
+            if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+                t.Fatal(err)
+            }
+            restaurants, err := db.SelectRestaurants()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(restaurants) != 1 || restaurants[0].Name != restaurant.Name {
+                t.Errorf("isolated database has %v, want only %q", restaurants, restaurant.Name)
+            }
+            if _, err := db.GetRestaurantByID(restaurant.ID + 1); !errors.Is(err, ErrNotFound) {
+                t.Errorf("missing restaurant: got %v, want ErrNotFound", err)
+            }
+        })
+    }
+}
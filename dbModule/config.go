@@ -0,0 +1,189 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Config задает параметры подключения к базе
+type Config struct {
+    // Driver - имя собранного драйвера; пустое - драйвер по умолчанию
+    Driver string
+    // DSN - путь к файлу SQLite или строка подключения драйвера
+    DSN string
+    // Pragmas применяются к каждому соединению SQLite; для других СУБД игнорируются
+    Pragmas SQLitePragmas
+    // Timeouts ограничивают время запросов на чтение, запись и миграций
+    Timeouts OperationTimeouts
+    // WriteQueue - сколько записей SQLite могут ждать единственного писателя (см. writeQueue);
+    // 0 - без очереди, параллельные записи ждут блокировку не дольше BusyTimeout
+    WriteQueue int
+    // Strict - строгий режим SQLite 3.37+: новые таблицы миграций создаются как STRICT,
+    // а чтение строк проверяет типы значений (см. queryRows.Scan)
+    Strict bool
+    // SlowQuery - запросы, выполнявшиеся дольше, пишутся в лог вместе с планом (см. Explain); 0 - выключено
+    SlowQuery time.Duration
+    // Replicas - DSN реплик для чтения того же драйвера: чтение вне транзакций распределяется
+    // по ним по кругу, запись и транзакции идут в DSN. Пусто - все запросы в DSN
+    Replicas []string
+    // EncryptionKey - ключ зашифрованного файла SQLite; нужен драйвер sqlcipher (сборка с тегом sqlcipher).
+    // Пустой ключ - файл не зашифрован. Тем же ключом открываются реплики
+    EncryptionKey string
+    // TablePrefix ставится перед именами таблиц, индексов, представлений и триггеров всех запросов
+    // и миграций (в файлах запросов - {{prefix}}), например "app_": так несколько экземпляров модуля
+    // делят одну схему. Пустой - без префикса
+    TablePrefix string
+    // IDScheme - схема глобального идентификатора public_id новых пользователей и ресторанов:
+    // IDSchemeUUID или IDSchemeULID. Оба упорядочены по времени и создаются без обращения к базе,
+    // поэтому public_id записей, добавленных на разных узлах или без связи, не совпадают.
+    // Пустая - public_id заполняется, только если передан. Это дополнительный ключ, а не первичный:
+    // первичные и внешние ключи всех сущностей остаются целыми с автоинкрементом, и при слиянии
+    // баз строки сопоставляются по public_id, а их целые ID назначает принимающая база
+    IDScheme string
+    // CircuitBreaker - автомат отключения при недоступности основной базы; нулевой - выключен
+    CircuitBreaker CircuitBreakerOptions
+    // PasswordPolicy - требования к новым паролям пользователей; нулевая - без проверок
+    PasswordPolicy PasswordPolicy
+}
+
+// SQLitePragmas - настройки SQLite, которые выставляются через PRAGMA.
+// Пустые и нулевые значения оставляют значение SQLite по умолчанию
+type SQLitePragmas struct {
+    // JournalMode - режим журнала: WAL позволяет читать параллельно с записью
+    JournalMode string
+    // Synchronous - OFF, NORMAL или FULL; с WAL обычно достаточно NORMAL
+    Synchronous string
+    // BusyTimeout - сколько ждать освобождения блокировки вместо немедленной ошибки SQLITE_BUSY
+    BusyTimeout time.Duration
+    // CacheSize - размер кэша страниц: положительный в страницах, отрицательный в КиБ
+    CacheSize int
+}
+
+// DefaultSQLitePragmas - настройки для одновременной работы читателей и писателя
+var DefaultSQLitePragmas = SQLitePragmas{
+    JournalMode: "WAL",
+    Synchronous: "NORMAL",
+    BusyTimeout: 5 * time.Second,
+}
+
+// DefaultConfig возвращает конфигурацию драйвера по умолчанию для dataSourceName
+func DefaultConfig(dataSourceName string) Config {
+    return Config{DSN: dataSourceName, Pragmas: DefaultSQLitePragmas, Timeouts: DefaultOperationTimeouts, WriteQueue: DefaultWriteQueueSize, CircuitBreaker: DefaultCircuitBreaker, PasswordPolicy: DefaultPasswordPolicy}
+}
+
+// params возвращает заданные прагмы в виде пар имя/значение в порядке применения
+func (p SQLitePragmas) params() [][2]string {
+    var params [][2]string
+    if p.BusyTimeout > 0 {
+        // busy_timeout ставится первым, чтобы смена режима журнала тоже ждала блокировку
+        params = append(params, [2]string{"busy_timeout", strconv.FormatInt(p.BusyTimeout.Milliseconds(), 10)})
+    }
+    if p.JournalMode != "" {
+        params = append(params, [2]string{"journal_mode", p.JournalMode})
+    }
+    if p.Synchronous != "" {
+        params = append(params, [2]string{"synchronous", p.Synchronous})
+    }
+    if p.CacheSize != 0 {
+        params = append(params, [2]string{"cache_size", strconv.Itoa(p.CacheSize)})
+    }
+    return params
+}
+
+// NewDatabaseWithConfig создает соединение по конфигурации. Прагмы SQLite передаются драйверу
+// через DSN: так он выполняет их на каждом новом соединении пула, а не только на первом
+func NewDatabaseWithConfig(config Config, queries *QueryRegistry) (*Database, error) {
+    config, err := config.withDatabaseURL()
+    if err != nil {
+        return nil, err
+    }
+    driver, err := lookupDriver(config.Driver)
+    if err != nil {
+        return nil, err
+    }
+    if !tablePrefixPattern.MatchString(config.TablePrefix) {
+        return nil, fmt.Errorf("table prefix %q must start with a letter or underscore and contain only letters, digits and underscores", config.TablePrefix)
+    }
+    queries = queries.withTablePrefix(config.TablePrefix)
+    if err := validIDScheme(config.IDScheme); err != nil {
+        return nil, err
+    }
+
+    db, err := driver.open(config.DSN, config.EncryptionKey, config.Pragmas)
+    if err != nil {
+        return nil, err
+    }
+    database := &Database{DB: db, queries: queries, driver: driver, maintenance: &maintenanceState{}, statements: &statementCache{}, reloadHooks: &queryReloadHooks{}, entities: &entityCache{}, results: &queryCache{}, usage: &usageCounter{}, jobs: &jobRunner{}, scheduler: &taskScheduler{}, dualWrites: &dualWriteState{}, lifecycle: &lifecycle{}, breaker: &circuitBreaker{options: config.CircuitBreaker}, tablePrefix: config.TablePrefix, idScheme: config.IDScheme, passwordPolicy: config.PasswordPolicy, timeouts: config.Timeouts, strict: config.Strict, slowQuery: config.SlowQuery}
+    if config.WriteQueue > 0 && driver.dialect.Name() == "sqlite" {
+        database.writes = newWriteQueue(config.WriteQueue)
+    }
+
+    // режим журнала хранится в файле базы, поэтому неудачную смену видно сразу после открытия
+    if mode := config.Pragmas.JournalMode; mode != "" && driver.pragmaParam != nil {
+        if err := database.checkJournalMode(mode); err != nil {
+            db.Close()
+            return nil, err
+        }
+    }
+    if config.Strict {
+        if err := database.checkStrict(); err != nil {
+            db.Close()
+            return nil, err
+        }
+    }
+    // реплики подключаются последними, чтобы проверки выше читали основную базу
+    if database.replicas, err = openReplicas(driver, config.Replicas, config.EncryptionKey, config.Pragmas); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return database, nil
+}
+
+// open открывает пул соединений с базой dsn; с ключом key - с зашифрованной
+func (driver sqlDriver) open(dsn, key string, pragmas SQLitePragmas) (*sql.DB, error) {
+    if key == "" {
+        return sql.Open(driver.name, driver.dataSourceName(dsn, pragmas))
+    }
+    if driver.openEncrypted == nil {
+        return nil, fmt.Errorf("database driver %s cannot open encrypted databases, build with -tags sqlcipher,libsqlite3", driver.name)
+    }
+    return driver.openEncrypted(dsn, key, pragmas)
+}
+
+// dataSourceName дополняет DSN параметрами драйвера и прагмами SQLite
+func (driver sqlDriver) dataSourceName(dsn string, pragmas SQLitePragmas) string {
+    dataSourceName := driver.prepareDSN(dsn)
+    if driver.pragmaParam != nil {
+        for _, pragma := range pragmas.params() {
+            dataSourceName = appendDSNParam(dataSourceName, driver.pragmaParam(pragma[0], pragma[1]))
+        }
+    }
+    return dataSourceName
+}
+
+// checkJournalMode проверяет, что SQLite включила запрошенный режим журнала.
+// In-memory базы всегда остаются в режиме memory, это не считается ошибкой
+func (db *Database) checkJournalMode(want string) error {
+    rows, err := db.queryNamed("pragmas.journal_mode")
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var got string
+    if rows.Next() {
+        if err := rows.Scan(&got); err != nil {
+            return err
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    if !strings.EqualFold(got, want) && got != "memory" {
+        return fmt.Errorf("SQLite journal mode is %q, requested %q", got, want)
+    }
+    return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "strings"
+)
+
+// CorrelationIDHeader - заголовок HTTP с идентификатором корреляции вызова API (см. perRequest)
+const CorrelationIDHeader = "X-Request-ID"
+
+// maxCorrelationIDLength - самый длинный идентификатор корреляции из заголовка; длиннее - заменяется новым
+const maxCorrelationIDLength = 128
+
+// correlationKey - ключ контекста с идентификатором корреляции
+type correlationKey struct{}
+
+// WithCorrelationID возвращает контекст с идентификатором корреляции id: запросы Database с этим
+// контекстом (см. WithContext) пишут его в лог запросов и лог медленных запросов, в атрибут correlation_id
+// участков трассировки и в журнал аудита, так что все действия с базой одного вызова API находятся
+// по одному значению. Пустой id оставляет ctx без изменений
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+    if id == "" {
+        return ctx
+    }
+    return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID возвращает идентификатор корреляции из ctx или ""
+func CorrelationID(ctx context.Context) string {
+    id, _ := ctx.Value(correlationKey{}).(string)
+    return id
+}
+
+// correlationID возвращает идентификатор корреляции контекста копии
+func (db *Database) correlationID() string {
+    return CorrelationID(db.baseContext())
+}
+
+// requestCorrelationID берет идентификатор корреляции из заголовка запроса, если он печатный
+// и не слишком длинный, иначе создает новый
+func requestCorrelationID(header string) string {
+    header = strings.TrimSpace(header)
+    valid := header != "" && len(header) <= maxCorrelationIDLength
+    for i := 0; valid && i < len(header); i++ {
+        valid = header[i] > ' ' && header[i] < 0x7f
+    }
+    if valid {
+        return header
+    }
+    var id [16]byte
+    rand.Read(id[:])
+    return hex.EncodeToString(id[:])
+}
+
+// correlationPrefix возвращает префикс строки лога с идентификатором корреляции или ""
+func (db *Database) correlationPrefix() string {
+    if id := db.correlationID(); id != "" {
+        return "[" + id + "] "
+    }
+    return ""
+}
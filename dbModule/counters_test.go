@@ -0,0 +1,47 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "path/filepath"
+    "testing"
+)
+
+// TestIncrementCounterWithReplica проверяет, что IncrementCounter пишет в основную базу, даже когда
+// заданы реплики: запрос с RETURNING - запись, хотя и возвращает строки
+func TestIncrementCounterWithReplica(t *testing.T) {
+    seed := NewIsolatedTestDatabase(t)
+    dir := t.TempDir()
+    primaryPath, replicaPath := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+    for _, path := range []string{primaryPath, replicaPath} {
+        if err := seed.Backup(path); err != nil {
+            t.Fatal(err)
+        }
+    }
+    config := DefaultConfig(primaryPath)
+    config.Replicas = []string{replicaPath}
+    db, err := NewDatabaseWithConfig(config, seed.queries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    const name = "orders:restaurant:1"
+    for want := int64(1); want <= 3; want++ {
+        got, err := db.IncrementCounter(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got != want {
+            t.Errorf("IncrementCounter = %d, want %d", got, want)
+        }
+    }
+    if got, err := db.WithPrimary().Counter(name); err != nil || got != 3 {
+        t.Errorf("counter on the primary = %d, %v, want 3", got, err)
+    }
+    var value int64
+    err = db.replicas.replicas[0].db.QueryRow("SELECT current_value FROM counters WHERE name = ?", name).Scan(&value)
+    if !errors.Is(err, sql.ErrNoRows) {
+        t.Errorf("counter on the replica = %d, %v, want no row", value, err)
+    }
+}
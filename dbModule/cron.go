@@ -0,0 +1,161 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schedule - расписание задачи (см. ParseSchedule)
+type Schedule interface {
+    // Next возвращает ближайший момент запуска строго после after
+    Next(after time.Time) time.Time
+}
+
+// everySchedule запускает задачу через равные промежутки от предыдущего запуска
+type everySchedule time.Duration
+
+func (s everySchedule) Next(after time.Time) time.Time {
+    return after.Add(time.Duration(s))
+}
+
+// cronSchedule - расписание в формате cron: множества подходящих минут, часов, дней месяца, месяцев
+// и дней недели (0 - воскресенье)
+type cronSchedule struct {
+    minutes, hours, days, months, weekdays uint64
+    // anyDay и anyWeekday - поле задано звездочкой; если ограничены оба, подходит день,
+    // совпавший с любым из них, как в cron
+    anyDay, anyWeekday bool
+}
+
+// cronFields - границы полей cron по порядку
+var cronFields = []struct {
+    name     string
+    min, max int
+}{
+    {"minute", 0, 59},
+    {"hour", 0, 23},
+    {"day of month", 1, 31},
+    {"month", 1, 12},
+    {"day of week", 0, 7},
+}
+
+// cronShortcuts - сокращения расписаний cron
+var cronShortcuts = map[string]string{
+    "@hourly":   "0 * * * *",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly":   "0 0 * * 0",
+    "@monthly":  "0 0 1 * *",
+}
+
+// ParseSchedule разбирает расписание: пять полей cron (минута, час, день месяца, месяц, день недели)
+// со списками, диапазонами и шагом, например "30 3 * * 1-5" или "*/15 * * * *", сокращения @hourly,
+// @daily, @weekly, @monthly или "@every 10m". Время cron - UTC
+func ParseSchedule(spec string) (Schedule, error) {
+    spec = strings.TrimSpace(spec)
+    if every, ok := strings.CutPrefix(spec, "@every "); ok {
+        interval, err := time.ParseDuration(strings.TrimSpace(every))
+        if err != nil || interval <= 0 {
+            return nil, fmt.Errorf("schedule %q: expected a positive duration after @every", spec)
+        }
+        return everySchedule(interval), nil
+    }
+    expression := spec
+    if shortcut, ok := cronShortcuts[spec]; ok {
+        expression = shortcut
+    }
+
+    fields := strings.Fields(expression)
+    if len(fields) != len(cronFields) {
+        return nil, fmt.Errorf("schedule %q: expected 5 cron fields (minute hour day month weekday) or @hourly, @daily, @weekly, @monthly, @every D", spec)
+    }
+    var sets [5]uint64
+    for i, field := range fields {
+        set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+        if err != nil {
+            return nil, fmt.Errorf("schedule %q: %s: %v", spec, cronFields[i].name, err)
+        }
+        sets[i] = set
+    }
+    // 7 - тоже воскресенье
+    if sets[4]&(1<<7) != 0 {
+        sets[4] |= 1
+    }
+    return &cronSchedule{
+        minutes: sets[0], hours: sets[1], days: sets[2], months: sets[3], weekdays: sets[4],
+        anyDay: fields[2] == "*", anyWeekday: fields[4] == "*",
+    }, nil
+}
+
+// parseCronField разбирает поле cron в множество значений: *, числа, диапазоны a-b и шаг /n через запятую
+func parseCronField(field string, min, max int) (uint64, error) {
+    var set uint64
+    for _, part := range strings.Split(field, ",") {
+        rangePart, stepPart, hasStep := strings.Cut(part, "/")
+        step := 1
+        if hasStep {
+            n, err := strconv.Atoi(stepPart)
+            if err != nil || n <= 0 {
+                return 0, fmt.Errorf("invalid step %q", stepPart)
+            }
+            step = n
+        }
+        low, high := min, max
+        if rangePart != "*" {
+            from, to, isRange := strings.Cut(rangePart, "-")
+            var err error
+            if low, err = strconv.Atoi(from); err != nil {
+                return 0, fmt.Errorf("invalid value %q", part)
+            }
+            high = low
+            if isRange {
+                if high, err = strconv.Atoi(to); err != nil {
+                    return 0, fmt.Errorf("invalid value %q", part)
+                }
+            } else if hasStep {
+                high = max
+            }
+        }
+        if low < min || high > max || low > high {
+            return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+        }
+        for value := low; value <= high; value += step {
+            set |= 1 << uint(value)
+        }
+    }
+    return set, nil
+}
+
+// Next ищет ближайшую подходящую минуту, перескакивая неподходящие месяцы, дни и часы целиком
+func (s *cronSchedule) Next(after time.Time) time.Time {
+    t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+    // за пять лет подходящая минута находится у любого выражения, кроме дат вроде 31 февраля
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        switch {
+        case s.months&(1<<uint(t.Month())) == 0:
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+        case !s.dayMatches(t):
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+        case s.hours&(1<<uint(t.Hour())) == 0:
+            t = t.Truncate(time.Hour).Add(time.Hour)
+        case s.minutes&(1<<uint(t.Minute())) == 0:
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// dayMatches проверяет день месяца и день недели по правилам cron
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+    day := s.days&(1<<uint(t.Day())) != 0
+    weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+    if !s.anyDay && !s.anyWeekday {
+        return day || weekday
+    }
+    return day && weekday
+}
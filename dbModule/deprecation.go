@@ -0,0 +1,58 @@
+package main
+
+import (
+    "expvar"
+    "fmt"
+    "log"
+    "reflect"
+    "runtime"
+    "strings"
+    "sync"
+)
+
+// deprecatedQueryUses - число выполнений каждого устаревшего запроса в этом процессе,
+// публикуется через expvar (/debug/vars) как dbmodule_deprecated_query_uses
+var deprecatedQueryUses = expvar.NewMap("dbmodule_deprecated_query_uses")
+
+// deprecationWarnings запоминает пары запрос/место вызова, о которых уже предупредили,
+// чтобы запрос в цикле не засорял лог
+var deprecationWarnings sync.Map
+
+// databaseMethodPrefix - префикс имен методов Database в стеке вызовов, например main.(*Database).
+var databaseMethodPrefix = func() string {
+    name := runtime.FuncForPC(reflect.ValueOf((*Database).conn).Pointer()).Name()
+    return name[:strings.LastIndex(name, ".")+1]
+}()
+
+// noteDeprecatedQuery учитывает выполнение устаревшего запроса и один раз для каждого
+// места вызова пишет предупреждение
+func (db *Database) noteDeprecatedQuery(name string) {
+    reason, ok := db.queries.Deprecated(name)
+    if !ok {
+        return
+    }
+    deprecatedQueryUses.Add(name, 1)
+
+    caller := queryCaller()
+    if _, warned := deprecationWarnings.LoadOrStore(name+"\x00"+caller, true); warned {
+        return
+    }
+    log.Printf("Warning: deprecated query %s executed from %s: %s", name, caller, reason)
+}
+
+// queryCaller возвращает первое место в стеке за пределами методов Database,
+// то есть код, который вызвал публичный метод с устаревшим запросом
+func queryCaller() string {
+    pcs := make([]uintptr, 32)
+    n := runtime.Callers(3, pcs)
+    frames := runtime.CallersFrames(pcs[:n])
+    for {
+        frame, more := frames.Next()
+        if !strings.HasPrefix(frame.Function, databaseMethodPrefix) {
+            return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+        }
+        if !more {
+            return "unknown caller"
+        }
+    }
+}
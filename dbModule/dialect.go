@@ -0,0 +1,207 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// sqlDialect описывает различия SQL между СУБД. Запросы в YAML пишутся в синтаксисе SQLite
+// с плейсхолдерами ?, а то, что нельзя переписать автоматически (upsert, DDL),
+// задается вариантом запроса name@<диалект>
+type sqlDialect interface {
+    // Name - имя диалекта, используемое в суффиксе вариантов запросов
+    Name() string
+    // Rebind переписывает плейсхолдеры ? в синтаксис СУБД
+    Rebind(query string) string
+    // LimitOffset возвращает окончание запроса для постраничной выборки;
+    // ordered сообщает, есть ли в запросе ORDER BY
+    LimitOffset(limit, offset int, ordered bool) string
+    // Identity - способ получить id только что вставленной строки
+    Identity() identityStrategy
+}
+
+// dialectNames - имена всех диалектов, в том числе тех, чьи драйверы не собраны в бинарник:
+// файлы запросов общие для всех сборок
+var dialectNames = []string{"sqlite", "postgres", "mysql", "mssql", "oracle"}
+
+// isDialectName проверяет, что name - имя диалекта
+func isDialectName(name string) bool {
+    for _, dialect := range dialectNames {
+        if name == dialect {
+            return true
+        }
+    }
+    return false
+}
+
+// identityStrategy - способ получения сгенерированного id при вставке
+type identityStrategy int
+
+// Способы получения id вставленной строки
+const (
+    // identityLastInsertID - sql.Result.LastInsertId (SQLite, MySQL)
+    identityLastInsertID identityStrategy = iota
+    // identityReturning - INSERT ... RETURNING id (PostgreSQL)
+    identityReturning
+    // identityOutput - INSERT ... OUTPUT INSERTED.id VALUES ... (SQL Server)
+    identityOutput
+    // identityReturningInto - INSERT ... RETURNING id INTO :n с выходным параметром (Oracle)
+    identityReturningInto
+)
+
+// sqliteDialect - SQLite: плейсхолдеры ?, upsert через ON CONFLICT
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) LimitOffset(limit, offset int, ordered bool) string {
+    return limitOffset(limit, offset, " LIMIT -1")
+}
+
+func (sqliteDialect) Identity() identityStrategy { return identityLastInsertID }
+
+// postgresDialect - PostgreSQL: плейсхолдеры $1, $2, ..., upsert через ON CONFLICT
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Rebind(query string) string {
+    return rebindNumbered(query, "$")
+}
+
+func (postgresDialect) LimitOffset(limit, offset int, ordered bool) string {
+    return limitOffset(limit, offset, "")
+}
+
+func (postgresDialect) Identity() identityStrategy { return identityReturning }
+
+// mysqlDialect - MySQL: плейсхолдеры ?, upsert через ON DUPLICATE KEY UPDATE
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Rebind(query string) string { return query }
+
+func (mysqlDialect) LimitOffset(limit, offset int, ordered bool) string {
+    return limitOffset(limit, offset, " LIMIT 18446744073709551615")
+}
+
+func (mysqlDialect) Identity() identityStrategy { return identityLastInsertID }
+
+// mssqlDialect - SQL Server: плейсхолдеры @p1, @p2, ..., OFFSET/FETCH, upsert через MERGE
+type mssqlDialect struct{}
+
+func (mssqlDialect) Name() string { return "mssql" }
+
+func (mssqlDialect) Rebind(query string) string {
+    return rebindNumbered(query, "@p")
+}
+
+// LimitOffset для SQL Server: OFFSET/FETCH допустимы только после ORDER BY
+func (mssqlDialect) LimitOffset(limit, offset int, ordered bool) string {
+    if limit <= 0 && offset <= 0 {
+        return ""
+    }
+    clause := ""
+    if !ordered {
+        clause = " ORDER BY (SELECT NULL)"
+    }
+    return clause + offsetFetch(limit, offset)
+}
+
+func (mssqlDialect) Identity() identityStrategy { return identityOutput }
+
+// oracleDialect - Oracle: плейсхолдеры :1, :2, ..., OFFSET/FETCH, upsert через MERGE
+type oracleDialect struct{}
+
+func (oracleDialect) Name() string { return "oracle" }
+
+// Rebind для Oracle также убирает завершающую точку с запятой: драйвер не принимает ее
+// в SQL-операторах, но она обязательна в конце PL/SQL блоков BEGIN ... END;
+func (oracleDialect) Rebind(query string) string {
+    query = strings.TrimSpace(query)
+    upper := strings.ToUpper(query)
+    if !strings.HasPrefix(upper, "BEGIN") && !strings.HasPrefix(upper, "DECLARE") {
+        query = strings.TrimSuffix(query, ";")
+    }
+    return rebindNumbered(query, ":")
+}
+
+func (oracleDialect) LimitOffset(limit, offset int, ordered bool) string {
+    if limit <= 0 && offset <= 0 {
+        return ""
+    }
+    return offsetFetch(limit, offset)
+}
+
+func (oracleDialect) Identity() identityStrategy { return identityReturningInto }
+
+// limitOffset - окончание LIMIT/OFFSET для SQLite, PostgreSQL и MySQL.
+// SQLite и MySQL не допускают OFFSET без LIMIT, поэтому для них передается unlimited
+func limitOffset(limit, offset int, unlimited string) string {
+    clause := ""
+    if limit > 0 {
+        clause += fmt.Sprintf(" LIMIT %d", limit)
+    } else if offset > 0 {
+        clause += unlimited
+    }
+    if offset > 0 {
+        clause += fmt.Sprintf(" OFFSET %d", offset)
+    }
+    return clause
+}
+
+// offsetFetch - окончание OFFSET ... ROWS FETCH NEXT ... ROWS ONLY (SQL:2008)
+func offsetFetch(limit, offset int) string {
+    clause := fmt.Sprintf(" OFFSET %d ROWS", offset)
+    if limit > 0 {
+        clause += fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit)
+    }
+    return clause
+}
+
+// insertReturningID дописывает к INSERT получение сгенерированного id по стратегии диалекта.
+// Запрос при этом еще содержит плейсхолдеры ?, выходной параметр Oracle получает следующий номер
+func insertReturningID(query string, strategy identityStrategy) string {
+    query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+    switch strategy {
+    case identityReturning:
+        return query + " RETURNING id"
+    case identityOutput:
+        if i := strings.Index(strings.ToUpper(query), " VALUES"); i >= 0 {
+            return query[:i] + " OUTPUT INSERTED.id" + query[i:]
+        }
+    case identityReturningInto:
+        return query + " RETURNING id INTO ?"
+    }
+    return query
+}
+
+// rebindNumbered заменяет ? на пронумерованные плейсхолдеры с префиксом,
+// пропуская вопросительные знаки внутри строковых литералов и идентификаторов в кавычках
+func rebindNumbered(query, prefix string) string {
+    var b strings.Builder
+    n := 0
+    var quote byte
+    for i := 0; i < len(query); i++ {
+        c := query[i]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '\'' || c == '"':
+            quote = c
+        case c == '?':
+            n++
+            b.WriteString(prefix)
+            b.WriteString(strconv.Itoa(n))
+            continue
+        }
+        b.WriteByte(c)
+    }
+    return b.String()
+}
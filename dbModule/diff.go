@@ -0,0 +1,129 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// FieldChange - значение поля до и после изменения
+type FieldChange struct {
+    Old interface{} `json:"old"`
+    New interface{} `json:"new"`
+}
+
+// Diff - изменившиеся поля записи по именам колонок (см. UpdateUser). Пустой Diff - запись
+// не изменилась. Версия строки в Diff не входит, а пароль выводится как [REDACTED]
+type Diff map[string]FieldChange
+
+// Fields возвращает имена изменившихся полей по алфавиту
+func (d Diff) Fields() []string {
+    fields := make([]string, 0, len(d))
+    for field := range d {
+        fields = append(fields, field)
+    }
+    sort.Strings(fields)
+    return fields
+}
+
+// String печатает изменения по алфавиту полей, например `name: "Old" -> "New"`
+func (d Diff) String() string {
+    changes := make([]string, 0, len(d))
+    for _, field := range d.Fields() {
+        changes = append(changes, fmt.Sprintf("%s: %s -> %s", field, diffText(d[field].Old), diffText(d[field].New)))
+    }
+    return strings.Join(changes, ", ")
+}
+
+// diffText печатает значение поля: строки в кавычках, отсутствующее значение - null
+func diffText(value interface{}) string {
+    switch value := value.(type) {
+    case nil:
+        return "null"
+    case string:
+        return fmt.Sprintf("%q", value)
+    }
+    return fmt.Sprint(value)
+}
+
+// diffValue добавляет поле в diff, если значение изменилось
+func diffValue[T comparable](diff Diff, field string, old, new T) {
+    if old != new {
+        diff[field] = FieldChange{Old: old, New: new}
+    }
+}
+
+// diffPointer добавляет в diff необязательное поле (nil - NULL), если значение изменилось
+func diffPointer[T comparable](diff Diff, field string, old, new *T) {
+    if old == nil && new == nil || old != nil && new != nil && *old == *new {
+        return
+    }
+    change := FieldChange{}
+    if old != nil {
+        change.Old = *old
+    }
+    if new != nil {
+        change.New = *new
+    }
+    diff[field] = change
+}
+
+// diffUser сравнивает колонки, которые записывает UpdateUser
+func diffUser(old, new User) Diff {
+    diff := Diff{}
+    diffValue(diff, "name", old.Name, new.Name)
+    diffValue(diff, "lastname", old.Lastname, new.Lastname)
+    diffValue(diff, "password", Secret(old.Password), Secret(new.Password))
+    diffValue(diff, "email", old.Email, new.Email)
+    diffPointer(diff, "phone", old.Phone, new.Phone)
+    diffValue(diff, "role", userRole(old), userRole(new))
+    diffPointer(diff, "avatar_url", old.AvatarURL, new.AvatarURL)
+    diffPointer(diff, "bio", old.Bio, new.Bio)
+    diffPointer(diff, "birthdate", old.Birthdate, new.Birthdate)
+    diffPointer(diff, "locale", old.Locale, new.Locale)
+    return diff
+}
+
+// diffRestaurant сравнивает колонки, которые записывает UpdateRestaurant
+func diffRestaurant(old, new Restaurant) Diff {
+    diff := Diff{}
+    diffValue(diff, "name", old.Name, new.Name)
+    diffValue(diff, "type", old.Type, new.Type)
+    diffPointer(diff, "keys", old.Keys, new.Keys)
+    diffValue(diff, "average_price", old.AveragePrice, new.AveragePrice)
+    diffValue(diff, "user_id", old.UserID, new.UserID)
+    diffPointer(diff, "price_amount", old.PriceAmount, new.PriceAmount)
+    diffPointer(diff, "price_currency", old.PriceCurrency, new.PriceCurrency)
+    return diff
+}
+
+// Changes возвращает поля, изменившиеся в записи журнала, по значениям до и после в JSON. У вставки
+// все поля - новые, у удаления - прежние. Версия строки не учитывается, как в Diff из UpdateUser
+func (e AuditEntry) Changes() (Diff, error) {
+    var before, after map[string]interface{}
+    if e.OldValue != "" {
+        if err := json.Unmarshal([]byte(e.OldValue), &before); err != nil {
+            return nil, fmt.Errorf("audit entry %d: %w", e.ID, err)
+        }
+    }
+    if e.NewValue != "" {
+        if err := json.Unmarshal([]byte(e.NewValue), &after); err != nil {
+            return nil, fmt.Errorf("audit entry %d: %w", e.ID, err)
+        }
+    }
+
+    diff := Diff{}
+    for field, old := range before {
+        if new, ok := after[field]; !ok || fmt.Sprint(old) != fmt.Sprint(new) {
+            diff[field] = FieldChange{Old: old, New: after[field]}
+        }
+    }
+    for field, new := range after {
+        if _, ok := before[field]; !ok {
+            diff[field] = FieldChange{New: new}
+        }
+    }
+    delete(diff, "version")
+    return diff, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "html/template"
+    "io"
+    "io/ioutil"
+    "os"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+)
+
+// TableDescription - описание таблицы и ее колонок для документации схемы
+type TableDescription struct {
+    Description string            `yaml:"description"`
+    Columns     map[string]string `yaml:"columns"`
+}
+
+// SchemaDoc - документация схемы: таблицы, прочитанные из базы, и именованные запросы реестра
+type SchemaDoc struct {
+    Dialect string
+    // TablePrefix - префикс имен таблиц экземпляра (см. Config.TablePrefix)
+    TablePrefix string
+    Tables      []TableDoc
+    Queries     []QueryDoc
+}
+
+// TableDoc описывает одну таблицу
+type TableDoc struct {
+    Name        string
+    Description string
+    Columns     []ColumnDoc
+    ForeignKeys []ForeignKeyDoc
+    Indexes     []IndexDoc
+}
+
+// ColumnDoc описывает колонку таблицы
+type ColumnDoc struct {
+    Name        string
+    Type        string
+    NotNull     bool
+    PrimaryKey  bool
+    Default     string
+    Description string
+}
+
+// ForeignKeyDoc описывает внешний ключ колонки
+type ForeignKeyDoc struct {
+    Column           string
+    ReferencedTable  string
+    ReferencedColumn string
+    OnDelete         string
+}
+
+// IndexDoc описывает индекс таблицы
+type IndexDoc struct {
+    Name    string
+    Unique  bool
+    Columns string
+}
+
+// QueryDoc - именованный запрос реестра
+type QueryDoc struct {
+    Name string
+    SQL  string
+    // Deprecated - причина, если запрос помечен устаревшим
+    Deprecated string
+}
+
+// LoadSchemaDescriptions читает описания таблиц и колонок из YAML файла.
+// Отсутствующий файл не считается ошибкой: документация строится без описаний
+func LoadSchemaDescriptions(path string) (map[string]TableDescription, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    data, err = expandVariables(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    var descriptions map[string]TableDescription
+    if err := yaml.Unmarshal(data, &descriptions); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return descriptions, nil
+}
+
+// DescribeSchema читает структуру таблиц из базы и дополняет ее описаниями из descriptions
+func (db *Database) DescribeSchema(descriptions map[string]TableDescription) (SchemaDoc, error) {
+    doc := SchemaDoc{Dialect: db.driver.dialect.Name(), TablePrefix: db.tablePrefix}
+
+    tables, err := db.introspectStrings("introspection.tables")
+    if err != nil {
+        return SchemaDoc{}, db.opError("describe", "schema", nil, err)
+    }
+    for _, name := range db.ownTables(tables) {
+        // описания в schema_docs.yaml даны для имен без префикса
+        table, err := db.describeTable(name, descriptions[strings.ToLower(name[len(db.tablePrefix):])])
+        if err != nil {
+            return SchemaDoc{}, db.opError("describe", "table", name, err)
+        }
+        doc.Tables = append(doc.Tables, table)
+    }
+
+    for _, name := range db.queries.Names() {
+        query, _ := db.queries.Get(name)
+        reason, _ := db.queries.Deprecated(name)
+        doc.Queries = append(doc.Queries, QueryDoc{Name: name, SQL: query, Deprecated: reason})
+    }
+    return doc, nil
+}
+
+// describeTable читает колонки, внешние ключи и индексы таблицы
+func (db *Database) describeTable(name string, description TableDescription) (TableDoc, error) {
+    table := TableDoc{Name: name, Description: description.Description}
+
+    rows, err := db.queryNamed("introspection.columns", name)
+    if err != nil {
+        return TableDoc{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var column ColumnDoc
+        var notNull, primaryKey int
+        var defaultValue sql.NullString
+        if err := rows.Scan(&column.Name, &column.Type, &notNull, &defaultValue, &primaryKey); err != nil {
+            return TableDoc{}, err
+        }
+        column.NotNull, column.PrimaryKey, column.Default = notNull != 0, primaryKey != 0, defaultValue.String
+        column.Description = description.Columns[column.Name]
+        table.Columns = append(table.Columns, column)
+    }
+    if err := rows.Err(); err != nil {
+        return TableDoc{}, err
+    }
+    rows.Close()
+
+    rows, err = db.queryNamed("introspection.foreign_keys", name)
+    if err != nil {
+        return TableDoc{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var fk ForeignKeyDoc
+        if err := rows.Scan(&fk.Column, &fk.ReferencedTable, &fk.ReferencedColumn, &fk.OnDelete); err != nil {
+            return TableDoc{}, err
+        }
+        table.ForeignKeys = append(table.ForeignKeys, fk)
+    }
+    if err := rows.Err(); err != nil {
+        return TableDoc{}, err
+    }
+    rows.Close()
+
+    rows, err = db.queryNamed("introspection.indexes", name)
+    if err != nil {
+        return TableDoc{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var index IndexDoc
+        var unique int
+        if err := rows.Scan(&index.Name, &unique, &index.Columns); err != nil {
+            return TableDoc{}, err
+        }
+        index.Unique = unique != 0
+        table.Indexes = append(table.Indexes, index)
+    }
+    return table, rows.Err()
+}
+
+// introspectStrings выполняет запрос, возвращающий один столбец строк
+func (db *Database) introspectStrings(name string, args ...interface{}) ([]string, error) {
+    rows, err := db.queryNamed(name, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var values []string
+    for rows.Next() {
+        var value string
+        if err := rows.Scan(&value); err != nil {
+            return nil, err
+        }
+        values = append(values, value)
+    }
+    return values, rows.Err()
+}
+
+// WriteMarkdown выводит документацию схемы в Markdown
+func (doc SchemaDoc) WriteMarkdown(w io.Writer) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "# Database schema (%s)\n", doc.Dialect)
+
+    for _, table := range doc.Tables {
+        fmt.Fprintf(&b, "\n## %s\n\n", table.Name)
+        if table.Description != "" {
+            fmt.Fprintf(&b, "%s\n\n", table.Description)
+        }
+        b.WriteString("| Column | Type | Null | Default | Description |\n|---|---|---|---|---|\n")
+        for _, c := range table.Columns {
+            name := c.Name
+            if c.PrimaryKey {
+                name += " (PK)"
+            }
+            null := "yes"
+            if c.NotNull {
+                null = "no"
+            }
+            fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", markdownCell(name), markdownCell(c.Type), null, markdownCell(c.Default), markdownCell(c.Description))
+        }
+
+        if len(table.ForeignKeys) > 0 {
+            b.WriteString("\nForeign keys:\n\n")
+            for _, fk := range table.ForeignKeys {
+                fmt.Fprintf(&b, "- %s → %s.%s (ON DELETE %s)\n", fk.Column, fk.ReferencedTable, fk.ReferencedColumn, fk.OnDelete)
+            }
+        }
+        if len(table.Indexes) > 0 {
+            b.WriteString("\nIndexes:\n\n")
+            for _, index := range table.Indexes {
+                unique := ""
+                if index.Unique {
+                    unique = "unique "
+                }
+                fmt.Fprintf(&b, "- %s: %s(%s)\n", index.Name, unique, index.Columns)
+            }
+        }
+    }
+
+    b.WriteString("\n## Named queries\n")
+    for _, query := range doc.Queries {
+        fmt.Fprintf(&b, "\n### %s\n\n", query.Name)
+        if query.Deprecated != "" {
+            fmt.Fprintf(&b, "**Deprecated:** %s\n\n", query.Deprecated)
+        }
+        fmt.Fprintf(&b, "```sql\n%s\n```\n", strings.TrimSpace(query.SQL))
+    }
+
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// markdownCell экранирует значение для ячейки таблицы Markdown
+func markdownCell(value string) string {
+    return strings.NewReplacer("|", `\|`, "\n", " ").Replace(value)
+}
+
+// schemaHTML - шаблон HTML документации схемы
+var schemaHTML = template.Must(template.New("schema").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Database schema ({{.Dialect}})</title></head>
+<body>
+<h1>Database schema ({{.Dialect}})</h1>
+{{range .Tables}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<table border="1">
+<tr><th>Column</th><th>Type</th><th>Null</th><th>Default</th><th>Description</th></tr>
+{{range .Columns}}<tr><td>{{.Name}}{{if .PrimaryKey}} (PK){{end}}</td><td>{{.Type}}</td><td>{{if .NotNull}}no{{else}}yes{{end}}</td><td>{{.Default}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{if .ForeignKeys}}<p>Foreign keys:</p>
+<ul>{{range .ForeignKeys}}<li>{{.Column}} → <a href="#{{.ReferencedTable}}">{{.ReferencedTable}}</a>.{{.ReferencedColumn}} (ON DELETE {{.OnDelete}})</li>{{end}}</ul>{{end}}
+{{if .Indexes}}<p>Indexes:</p>
+<ul>{{range .Indexes}}<li>{{.Name}}: {{if .Unique}}unique {{end}}({{.Columns}})</li>{{end}}</ul>{{end}}
+{{end}}
+<h2>Named queries</h2>
+{{range .Queries}}<h3>{{.Name}}</h3>
+{{if .Deprecated}}<p><strong>Deprecated:</strong> {{.Deprecated}}</p>{{end}}
+<pre>{{.SQL}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML выводит документацию схемы в HTML
+func (doc SchemaDoc) WriteHTML(w io.Writer) error {
+    return schemaHTML.Execute(w, doc)
+}
+
+// runDocs выводит документацию схемы и именованных запросов
+func runDocs(db *Database, args []string) error {
+    flags := flag.NewFlagSet("docs", flag.ContinueOnError)
+    format := flags.String("format", "markdown", "output format: markdown or html")
+    output := flags.String("out", "", "output file (default: stdout)")
+    descriptionsPath := flags.String("descriptions", "./config/schema_docs.yaml", "YAML file with table and column descriptions")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    descriptions, err := LoadSchemaDescriptions(*descriptionsPath)
+    if err != nil {
+        return err
+    }
+    doc, err := db.DescribeSchema(descriptions)
+    if err != nil {
+        return err
+    }
+
+    w := io.Writer(os.Stdout)
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        w = file
+    }
+
+    switch *format {
+    case "markdown", "md":
+        return doc.WriteMarkdown(w)
+    case "html":
+        return doc.WriteHTML(w)
+    }
+    return fmt.Errorf("unknown docs format %q, expected markdown or html", *format)
+}
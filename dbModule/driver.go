@@ -0,0 +1,77 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// sqlDriver описывает драйвер БД, собранный в бинарник. Набор драйверов выбирается
+// тегами сборки: sqlite (по умолчанию), modernc, sqlcipher, postgres, mysql, mssql, oracle
+type sqlDriver struct {
+    // name - имя драйвера для sql.Open
+    name string
+    // dialect описывает особенности SQL этой СУБД
+    dialect sqlDialect
+    // prepareDSN дополняет DSN параметрами, нужными модулю (например, включает внешние ключи)
+    prepareDSN func(dataSourceName string) string
+    // pragmaParam возвращает параметр DSN, выполняющий PRAGMA name = value на каждом соединении;
+    // nil у драйверов, не поддерживающих прагмы SQLite
+    pragmaParam func(name, value string) string
+    // openEncrypted открывает пул соединений с зашифрованной базой, выполняя PRAGMA key на каждом
+    // соединении раньше остальных прагм; nil у драйверов без шифрования (см. Config.EncryptionKey)
+    openEncrypted func(dsn, key string, pragmas SQLitePragmas) (*sql.DB, error)
+    // isForeignKeyError распознает нарушение внешнего ключа в ошибке драйвера
+    isForeignKeyError func(err error) bool
+    // isUniqueError распознает нарушение уникального индекса или первичного ключа
+    isUniqueError func(err error) bool
+}
+
+// drivers содержит драйверы, зарегистрированные файлами driver_*.go
+var drivers = map[string]sqlDriver{}
+
+// driverPreference задает порядок выбора драйвера по умолчанию
+var driverPreference = []string{"sqlcipher", "sqlite3", "sqlite", "postgres", "mysql", "sqlserver", "oracle"}
+
+// registerDriver вызывается из init() файла драйвера
+func registerDriver(driver sqlDriver) {
+    drivers[driver.name] = driver
+}
+
+// lookupDriver возвращает драйвер по имени; пустое имя означает драйвер по умолчанию
+func lookupDriver(name string) (sqlDriver, error) {
+    if name == "" {
+        for _, preferred := range driverPreference {
+            if driver, ok := drivers[preferred]; ok {
+                return driver, nil
+            }
+        }
+        return sqlDriver{}, fmt.Errorf("no database drivers compiled in, build with -tags sqlite, modernc, postgres, mysql, mssql or oracle")
+    }
+
+    driver, ok := drivers[name]
+    if !ok {
+        return sqlDriver{}, fmt.Errorf("database driver %q is not compiled in (available: %s)", name, strings.Join(driverNames(), ", "))
+    }
+    return driver, nil
+}
+
+// driverNames возвращает отсортированные имена собранных драйверов
+func driverNames() []string {
+    names := make([]string, 0, len(drivers))
+    for name := range drivers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// appendDSNParam добавляет параметр к строке запроса DSN
+func appendDSNParam(dataSourceName, param string) string {
+    separator := "?"
+    if strings.Contains(dataSourceName, "?") {
+        separator = "&"
+    }
+    return dataSourceName + separator + param
+}
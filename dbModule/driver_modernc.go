@@ -0,0 +1,49 @@
+//go:build modernc
+
+package main
+
+import (
+    "errors"
+    "strings"
+
+    "modernc.org/sqlite"
+    sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Драйвер modernc.org/sqlite на чистом Go собирается с тегом modernc и не требует cgo
+func init() {
+    registerDriver(sqlDriver{
+        name:    "sqlite",
+        dialect: sqliteDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return appendDSNParam(dataSourceName, "_pragma=foreign_keys(1)")
+        },
+        pragmaParam: func(name, value string) string {
+            return "_pragma=" + name + "(" + value + ")"
+        },
+        isForeignKeyError: isModerncForeignKeyError,
+        isUniqueError:     isModerncUniqueError,
+    })
+}
+
+// isModerncForeignKeyError проверяет, что драйвер вернул нарушение внешнего ключа
+func isModerncForeignKeyError(err error) bool {
+    var sqliteErr *sqlite.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+    switch sqliteErr.Code() {
+    case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+        return true
+    case sqlite3.SQLITE_CONSTRAINT_TRIGGER:
+        return strings.Contains(sqliteErr.Error(), "FOREIGN KEY")
+    }
+    return false
+}
+
+// isModerncUniqueError проверяет нарушение UNIQUE или PRIMARY KEY
+func isModerncUniqueError(err error) bool {
+    var sqliteErr *sqlite.Error
+    return errors.As(err, &sqliteErr) &&
+        (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
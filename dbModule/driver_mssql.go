@@ -0,0 +1,34 @@
+//go:build mssql
+
+package main
+
+import (
+    "errors"
+
+    mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Драйвер SQL Server собирается с тегом mssql
+func init() {
+    registerDriver(sqlDriver{
+        name:    "sqlserver",
+        dialect: mssqlDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return dataSourceName
+        },
+        isForeignKeyError: isMSSQLForeignKeyError,
+        isUniqueError:     isMSSQLUniqueError,
+    })
+}
+
+// isMSSQLForeignKeyError проверяет номер ошибки 547 (нарушение ограничения FOREIGN KEY)
+func isMSSQLForeignKeyError(err error) bool {
+    var mssqlErr mssql.Error
+    return errors.As(err, &mssqlErr) && mssqlErr.Number == 547
+}
+
+// isMSSQLUniqueError проверяет номера ошибок 2627 (ограничение UNIQUE/PRIMARY KEY) и 2601 (уникальный индекс)
+func isMSSQLUniqueError(err error) bool {
+    var mssqlErr mssql.Error
+    return errors.As(err, &mssqlErr) && (mssqlErr.Number == 2627 || mssqlErr.Number == 2601)
+}
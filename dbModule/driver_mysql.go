@@ -0,0 +1,34 @@
+//go:build mysql
+
+package main
+
+import (
+    "errors"
+
+    "github.com/go-sql-driver/mysql"
+)
+
+// Драйвер MySQL собирается с тегом mysql
+func init() {
+    registerDriver(sqlDriver{
+        name:    "mysql",
+        dialect: mysqlDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return dataSourceName
+        },
+        isForeignKeyError: isMySQLForeignKeyError,
+        isUniqueError:     isMySQLUniqueError,
+    })
+}
+
+// isMySQLForeignKeyError проверяет коды ER_ROW_IS_REFERENCED_2 (1451) и ER_NO_REFERENCED_ROW_2 (1452)
+func isMySQLForeignKeyError(err error) bool {
+    var mysqlErr *mysql.MySQLError
+    return errors.As(err, &mysqlErr) && (mysqlErr.Number == 1451 || mysqlErr.Number == 1452)
+}
+
+// isMySQLUniqueError проверяет код ER_DUP_ENTRY (1062)
+func isMySQLUniqueError(err error) bool {
+    var mysqlErr *mysql.MySQLError
+    return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
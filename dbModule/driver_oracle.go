@@ -0,0 +1,35 @@
+//go:build oracle
+
+package main
+
+import (
+    "errors"
+
+    _ "github.com/sijms/go-ora/v2"
+    "github.com/sijms/go-ora/v2/network"
+)
+
+// Драйвер Oracle собирается с тегом oracle
+func init() {
+    registerDriver(sqlDriver{
+        name:    "oracle",
+        dialect: oracleDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return dataSourceName
+        },
+        isForeignKeyError: isOracleForeignKeyError,
+        isUniqueError:     isOracleUniqueError,
+    })
+}
+
+// isOracleForeignKeyError проверяет ORA-02291 (нет родительской записи) и ORA-02292 (есть дочерние записи)
+func isOracleForeignKeyError(err error) bool {
+    var oracleErr *network.OracleError
+    return errors.As(err, &oracleErr) && (oracleErr.ErrCode == 2291 || oracleErr.ErrCode == 2292)
+}
+
+// isOracleUniqueError проверяет ORA-00001 (нарушено ограничение уникальности)
+func isOracleUniqueError(err error) bool {
+    var oracleErr *network.OracleError
+    return errors.As(err, &oracleErr) && oracleErr.ErrCode == 1
+}
@@ -0,0 +1,34 @@
+//go:build postgres
+
+package main
+
+import (
+    "errors"
+
+    "github.com/lib/pq"
+)
+
+// Драйвер PostgreSQL собирается с тегом postgres
+func init() {
+    registerDriver(sqlDriver{
+        name:    "postgres",
+        dialect: postgresDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return dataSourceName
+        },
+        isForeignKeyError: isPostgresForeignKeyError,
+        isUniqueError:     isPostgresUniqueError,
+    })
+}
+
+// isPostgresForeignKeyError проверяет код foreign_key_violation (23503)
+func isPostgresForeignKeyError(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// isPostgresUniqueError проверяет код unique_violation (23505)
+func isPostgresUniqueError(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,74 @@
+//go:build sqlcipher
+
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "strings"
+
+    "github.com/mattn/go-sqlite3"
+)
+
+// Драйвер sqlcipher - mattn/go-sqlite3, слинкованный с системной библиотекой SQLCipher вместо
+// встроенной SQLite. Собирается с тегами sqlcipher и libsqlite3:
+//   CGO_CFLAGS="-DSQLITE_HAS_CODEC -I/usr/include/sqlcipher" CGO_LDFLAGS="-lsqlcipher" go build -tags sqlcipher,libsqlite3
+// Без ключа (см. Config.EncryptionKey) работает как обычный драйвер SQLite
+func init() {
+    sql.Register("sqlcipher", &sqlite3.SQLiteDriver{})
+    registerDriver(sqlDriver{
+        name:    "sqlcipher",
+        dialect: sqliteDialect{},
+        prepareDSN: func(dataSourceName string) string {
+            return appendDSNParam(dataSourceName, "_foreign_keys=on")
+        },
+        pragmaParam: func(name, value string) string {
+            return "_" + name + "=" + value
+        },
+        openEncrypted:     openSQLCipher,
+        isForeignKeyError: isSQLite3ForeignKeyError,
+        isUniqueError:     isSQLite3UniqueError,
+    })
+}
+
+// openSQLCipher открывает зашифрованную базу. mattn/go-sqlite3 выполняет прагмы из DSN до ConnectHook,
+// а SQLCipher требует PRAGMA key до первого чтения файла, поэтому DSN передается без прагм,
+// а ключ, внешние ключи и прагмы выполняются в ConnectHook на каждом новом соединении пула
+func openSQLCipher(dsn, key string, pragmas SQLitePragmas) (*sql.DB, error) {
+    statements := []string{
+        "PRAGMA key = '" + strings.ReplaceAll(key, "'", "''") + "';",
+        // первое чтение проверяет ключ: с неверным ключом SQLCipher отвечает "file is not a database"
+        "SELECT count(*) FROM sqlite_master;",
+        "PRAGMA foreign_keys = ON;",
+    }
+    for _, pragma := range pragmas.params() {
+        statements = append(statements, fmt.Sprintf("PRAGMA %s = %s;", pragma[0], pragma[1]))
+    }
+    connector := sqlcipherConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{
+        ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+            for _, statement := range statements {
+                if _, err := conn.Exec(statement, nil); err != nil {
+                    return fmt.Errorf("opening encrypted database: %w", err)
+                }
+            }
+            return nil
+        },
+    }}
+    return sql.OpenDB(connector), nil
+}
+
+// sqlcipherConnector открывает соединения драйвером с ключом шифрования в ConnectHook
+type sqlcipherConnector struct {
+    dsn    string
+    driver *sqlite3.SQLiteDriver
+}
+
+func (c sqlcipherConnector) Connect(ctx context.Context) (driver.Conn, error) {
+    return c.driver.Open(c.dsn)
+}
+
+func (c sqlcipherConnector) Driver() driver.Driver {
+    return c.driver
+}
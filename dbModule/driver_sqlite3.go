@@ -0,0 +1,51 @@
+//go:build sqlite || sqlcipher || !(modernc || postgres || mysql || mssql || oracle)
+
+package main
+
+import (
+    "errors"
+    "strings"
+
+    "github.com/mattn/go-sqlite3"
+)
+
+// Драйвер mattn/go-sqlite3 (cgo) собирается по умолчанию и с тегом sqlite.
+// Вместе с modernc (-tags sqlite,modernc) оба драйвера проверяются тестами совместимости (см. checkDriverCompat)
+func init() {
+    registerDriver(sqlDriver{
+        name:    "sqlite3",
+        dialect: sqliteDialect{},
+        // Проверка внешних ключей в SQLite по умолчанию выключена, поэтому включаем ее для всех соединений пула
+        prepareDSN: func(dataSourceName string) string {
+            return appendDSNParam(dataSourceName, "_foreign_keys=on")
+        },
+        pragmaParam: func(name, value string) string {
+            return "_" + name + "=" + value
+        },
+        isForeignKeyError: isSQLite3ForeignKeyError,
+        isUniqueError:     isSQLite3UniqueError,
+    })
+}
+
+// isSQLite3ForeignKeyError проверяет, что драйвер вернул нарушение внешнего ключа.
+// Для ON DELETE RESTRICT SQLite возвращает код SQLITE_CONSTRAINT_TRIGGER с тем же текстом
+func isSQLite3ForeignKeyError(err error) bool {
+    var sqliteErr sqlite3.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+    switch sqliteErr.ExtendedCode {
+    case sqlite3.ErrConstraintForeignKey:
+        return true
+    case sqlite3.ErrConstraintTrigger:
+        return strings.Contains(sqliteErr.Error(), "FOREIGN KEY")
+    }
+    return false
+}
+
+// isSQLite3UniqueError проверяет нарушение UNIQUE или PRIMARY KEY
+func isSQLite3UniqueError(err error) bool {
+    var sqliteErr sqlite3.Error
+    return errors.As(err, &sqliteErr) &&
+        (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// transactionalDDL - диалекты, где CREATE/DROP откатываются вместе с транзакцией.
+// В MySQL и Oracle DDL фиксирует транзакцию сам, поэтому там план только печатается
+var transactionalDDL = map[string]bool{
+    "sqlite":   true,
+    "postgres": true,
+    "mssql":    true,
+}
+
+// DryRunStep - один шаг плана Initialize или Migrate
+type DryRunStep struct {
+    // Name - запрос реестра или ID миграции
+    Name string
+    // SQL - текст шага; у data-миграций пустой, так как они выполняются Go-кодом
+    SQL string
+    // Checked - шаг выполнен в проверочной транзакции; Error - его ошибка
+    Checked bool
+    Error   error
+}
+
+// DryRunReport - план Initialize или Migrate и результат его проверки
+type DryRunReport struct {
+    Steps []DryRunStep
+    // Validated - план выполнялся в транзакции, которая затем откачена
+    Validated bool
+}
+
+// Failed возвращает первый шаг с ошибкой или nil
+func (r DryRunReport) Failed() *DryRunStep {
+    for i := range r.Steps {
+        if r.Steps[i].Error != nil {
+            return &r.Steps[i]
+        }
+    }
+    return nil
+}
+
+// dryRunAction - шаг плана вместе со способом его выполнить
+type dryRunAction struct {
+    step DryRunStep
+    run  func(ctx context.Context, tx *sql.Tx) error
+}
+
+// DryRunInitialize возвращает план Initialize: удаление всех таблиц и все миграции с нуля
+func (db *Database) DryRunInitialize() (DryRunReport, error) {
+    return db.dryRun(true)
+}
+
+// DryRunMigrate возвращает план Migrate: миграции, которые еще не применены
+func (db *Database) DryRunMigrate() (DryRunReport, error) {
+    return db.dryRun(false)
+}
+
+// dryRun составляет план и, если DDL диалекта транзакционный, проверяет его: выполняет шаги
+// на выделенном соединении в транзакции и всегда откатывает ее, поэтому база не меняется.
+// Так проверяется больше, чем через EXPLAIN: каждый шаг видит таблицы, созданные предыдущими.
+// Проверка останавливается на первой ошибке, последующие шаги остаются непроверенными
+func (db *Database) dryRun(initialize bool) (DryRunReport, error) {
+    report := DryRunReport{Validated: transactionalDDL[db.driver.dialect.Name()]}
+    if !report.Validated {
+        actions, err := db.dryRunPlan(initialize, nil)
+        for _, action := range actions {
+            report.Steps = append(report.Steps, action.step)
+        }
+        return report, err
+    }
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return report, err
+    }
+    defer conn.Close()
+
+    // пересборка таблиц требует выключенных внешних ключей, как в applyMigration
+    sqlite := db.driver.dialect.Name() == "sqlite"
+    if sqlite {
+        if err := db.execOn(ctx, conn, "rebuild.foreign_keys_off"); err != nil {
+            return report, err
+        }
+        defer db.execOn(context.Background(), conn, "rebuild.foreign_keys_on")
+    }
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return report, err
+    }
+    defer tx.Rollback()
+
+    actions, err := db.dryRunPlan(initialize, tx)
+    if err != nil {
+        return report, err
+    }
+    failed := false
+    for _, action := range actions {
+        if !failed {
+            action.step.Checked = true
+            action.step.Error = action.run(ctx, tx)
+            failed = action.step.Error != nil
+        }
+        report.Steps = append(report.Steps, action.step)
+    }
+    return report, nil
+}
+
+// dryRunPlan составляет шаги плана. Для Migrate примененные миграции читаются через tx
+// после создания таблицы migrations; без tx таблицы может не быть, тогда все миграции считаются новыми
+func (db *Database) dryRunPlan(initialize bool, tx *sql.Tx) ([]dryRunAction, error) {
+    var actions []dryRunAction
+    statement := func(name string) error {
+        query, err := db.lookupQuery(name)
+        if err != nil {
+            return err
+        }
+        query = db.driver.dialect.Rebind(query)
+        actions = append(actions, dryRunAction{
+            step: DryRunStep{Name: name, SQL: query},
+            run: func(ctx context.Context, tx *sql.Tx) error {
+                _, err := tx.ExecContext(ctx, query)
+                return err
+            },
+        })
+        return nil
+    }
+
+    if initialize {
+        for _, name := range initializeDrops {
+            if err := statement(name); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if err := statement("migrations.create_table"); err != nil {
+        return nil, err
+    }
+
+    all, err := db.migrations()
+    if err != nil {
+        return actions, err
+    }
+    applied := make(map[string]bool)
+    if !initialize {
+        applied = db.dryRunApplied(tx, actions[len(actions)-1])
+    }
+
+    rebuild := db.driver.dialect.Name() == "sqlite"
+    for _, m := range all {
+        if applied[m.ID] {
+            continue
+        }
+        step := DryRunStep{Name: m.ID, SQL: m.SQL}
+        if rebuild && m.Rebuild != nil {
+            step.SQL = fmt.Sprintf("%s; -- copy rows from %s, replace it and restore its indexes", m.Rebuild.Create, m.Rebuild.Table)
+        }
+        actions = append(actions, dryRunAction{
+            step: step,
+            run: func(ctx context.Context, tx *sql.Tx) error {
+                return db.runMigration(ctx, tx, m, rebuild && m.Rebuild != nil)
+            },
+        })
+    }
+    return actions, nil
+}
+
+// dryRunApplied читает примененные миграции. В проверочной транзакции сначала выполняется
+// создание таблицы migrations, чтобы выборка работала и на пустой базе
+func (db *Database) dryRunApplied(tx *sql.Tx, createTable dryRunAction) map[string]bool {
+    applied := make(map[string]bool)
+    if tx == nil {
+        ids, err := db.appliedMigrations()
+        if err == nil {
+            applied = ids
+        }
+        return applied
+    }
+
+    ctx := context.Background()
+    if createTable.run(ctx, tx) != nil {
+        return applied
+    }
+    query, err := db.lookupQuery("migrations.select_applied")
+    if err != nil {
+        return applied
+    }
+    rows, err := tx.QueryContext(ctx, db.driver.dialect.Rebind(query))
+    if err != nil {
+        return applied
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var id string
+        if rows.Scan(&id) == nil {
+            applied[id] = true
+        }
+    }
+    return applied
+}
+
+// WriteTo печатает план как SQL-скрипт с комментариями о результате проверки
+func (r DryRunReport) WriteTo(w io.Writer) (int64, error) {
+    var b strings.Builder
+    if !r.Validated {
+        b.WriteString("-- not validated: DDL is not transactional in this database\n")
+    }
+    for _, step := range r.Steps {
+        fmt.Fprintf(&b, "-- %s", step.Name)
+        switch {
+        case step.Error != nil:
+            fmt.Fprintf(&b, " FAILED: %v", step.Error)
+        case r.Validated && !step.Checked:
+            b.WriteString(" (not checked: an earlier step failed)")
+        }
+        b.WriteString("\n")
+        if step.SQL == "" {
+            b.WriteString("-- data migration implemented in Go\n")
+            continue
+        }
+        b.WriteString(strings.TrimSuffix(step.SQL, ";") + ";\n")
+    }
+    n, err := io.WriteString(w, b.String())
+    return int64(n), err
+}
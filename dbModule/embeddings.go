@@ -0,0 +1,356 @@
+package main
+
+import (
+    "encoding/binary"
+    "flag"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// Embedder превращает текстовое описание ресторана в вектор фиксированной размерности
+type Embedder interface {
+    Dimensions() int
+    Embed(text string) ([]float32, error)
+}
+
+// HashingEmbedder - встроенный Embedder без внешних зависимостей: слова описания
+// раскладываются по измерениям хешем (feature hashing). Подходит для поиска по общим
+// словам в названии, кухне и ключевых словах; для смыслового поиска подключите модель
+type HashingEmbedder struct {
+    Size int
+}
+
+// Dimensions возвращает размерность векторов
+func (e HashingEmbedder) Dimensions() int { return e.Size }
+
+// Embed строит вектор по словам текста
+func (e HashingEmbedder) Embed(text string) ([]float32, error) {
+    if e.Size <= 0 {
+        return nil, fmt.Errorf("embedding dimensions must be positive, got %d", e.Size)
+    }
+
+    vector := make([]float32, e.Size)
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    for _, word := range words {
+        h := fnv.New32a()
+        h.Write([]byte(word))
+        sum := h.Sum32()
+        // старший бит задает знак, чтобы коллизии разных слов взаимно гасились
+        if sum&(1<<31) != 0 {
+            vector[sum%uint32(e.Size)]--
+        } else {
+            vector[sum%uint32(e.Size)]++
+        }
+    }
+    return vector, nil
+}
+
+// SimilarRestaurant - ресторан из результатов поиска похожих с косинусной близостью от -1 до 1
+type SimilarRestaurant struct {
+    Restaurant Restaurant
+    Similarity float64
+}
+
+// restaurantEmbeddingText - текст ресторана, по которому строится вектор
+func restaurantEmbeddingText(restaurant Restaurant) string {
+    return strings.Join([]string{restaurant.Name, restaurant.Type, stringValue(restaurant.Keys)}, " ")
+}
+
+// IndexRestaurantEmbeddings пересчитывает векторы всех ресторанов и возвращает их количество
+func (db *Database) IndexRestaurantEmbeddings(embedder Embedder) (int, error) {
+    restaurants, err := db.SelectRestaurants()
+    if err != nil {
+        return 0, err
+    }
+
+    err = db.InTx(func(tx *Database) error {
+        for _, restaurant := range restaurants {
+            vector, err := embedder.Embed(restaurantEmbeddingText(restaurant))
+            if err != nil {
+                return fmt.Errorf("embed restaurant %d: %w", restaurant.ID, err)
+            }
+            if err := tx.SetRestaurantEmbedding(restaurant.ID, vector); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, db.opError("index", "restaurant embeddings", nil, err)
+    }
+    return len(restaurants), nil
+}
+
+// SetRestaurantEmbedding сохраняет вектор ресторана, заменяя прежний
+func (db *Database) SetRestaurantEmbedding(restaurantID int, vector []float32) error {
+    _, err := db.execNamed("restaurant_embeddings.upsert", restaurantID, len(vector), db.encodeVector(vector))
+    if db.driver.isForeignKeyError(err) {
+        err = fmt.Errorf("restaurant %d does not exist: %w", restaurantID, ErrForeignKeyViolation)
+    }
+    return db.opError("set", "restaurant embedding", restaurantID, err)
+}
+
+// SimilarRestaurants возвращает до limit ресторанов, самых похожих на указанный
+func (db *Database) SimilarRestaurants(restaurantID, limit int) ([]SimilarRestaurant, error) {
+    rows, err := db.queryNamed("restaurant_embeddings.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("find similar", "restaurant", restaurantID, err)
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        if err := rows.Err(); err != nil {
+            return nil, db.opError("find similar", "restaurant", restaurantID, err)
+        }
+        return nil, fmt.Errorf("embedding of restaurant %d: %w", restaurantID, ErrNotFound)
+    }
+    var raw []byte
+    if err := rows.Scan(&raw); err != nil {
+        return nil, db.opError("find similar", "restaurant", restaurantID, err)
+    }
+    vector, err := db.decodeVector(raw)
+    if err != nil {
+        return nil, db.opError("find similar", "restaurant", restaurantID, err)
+    }
+    rows.Close()
+
+    similar, err := db.nearestRestaurants(vector, restaurantID, limit)
+    return similar, db.opError("find similar", "restaurant", restaurantID, err)
+}
+
+// NearestRestaurants возвращает до limit ресторанов, чьи векторы ближе всего к vector
+func (db *Database) NearestRestaurants(vector []float32, limit int) ([]SimilarRestaurant, error) {
+    return db.nearestRestaurants(vector, 0, limit)
+}
+
+// nearestRestaurants ищет ближайшие векторы, пропуская ресторан exclude. Если для диалекта
+// объявлен запрос restaurant_embeddings.nearest, поиск выполняет СУБД, иначе - перебор в Go
+func (db *Database) nearestRestaurants(vector []float32, exclude, limit int) ([]SimilarRestaurant, error) {
+    var matches []vectorMatch
+    var err error
+    if db.nativeVectors() {
+        matches, err = db.nearestInDatabase(vector, exclude, limit)
+    } else {
+        matches, err = db.nearestBruteForce(vector, exclude, limit)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    results := make([]SimilarRestaurant, 0, len(matches))
+    for _, match := range matches {
+        restaurant, err := db.GetRestaurantByID(match.id)
+        if err != nil {
+            return nil, err
+        }
+        results = append(results, SimilarRestaurant{Restaurant: restaurant, Similarity: match.similarity})
+    }
+    return results, nil
+}
+
+// vectorMatch - id ресторана и близость его вектора к искомому
+type vectorMatch struct {
+    id         int
+    similarity float64
+}
+
+// nativeVectors сообщает, хранит ли СУБД векторы в собственном типе (pgvector)
+func (db *Database) nativeVectors() bool {
+    return db.queries.Has("restaurant_embeddings.nearest@" + db.driver.dialect.Name())
+}
+
+// nearestInDatabase выполняет поиск ближайших соседей средствами СУБД
+func (db *Database) nearestInDatabase(vector []float32, exclude, limit int) ([]vectorMatch, error) {
+    encoded := db.encodeVector(vector)
+    rows, err := db.queryNamed("restaurant_embeddings.nearest", encoded, exclude, len(vector), db.tenant, encoded, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var matches []vectorMatch
+    for rows.Next() {
+        var match vectorMatch
+        if err := rows.Scan(&match.id, &match.similarity); err != nil {
+            return nil, err
+        }
+        matches = append(matches, match)
+    }
+    return matches, rows.Err()
+}
+
+// vectorIndex хранит нормированные векторы одной размерности подряд в одном срезе:
+// такой плоский массив читается последовательно и хорошо векторизуется компилятором
+type vectorIndex struct {
+    dimensions int
+    ids        []int
+    vectors    []float32
+}
+
+// nearestBruteForce загружает все векторы той же размерности и сравнивает их с искомым
+func (db *Database) nearestBruteForce(vector []float32, exclude, limit int) ([]vectorMatch, error) {
+    rows, err := db.queryNamed("restaurant_embeddings.select", len(vector), db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    index := vectorIndex{dimensions: len(vector)}
+    for rows.Next() {
+        var id int
+        var raw []byte
+        if err := rows.Scan(&id, &raw); err != nil {
+            return nil, err
+        }
+        if id == exclude {
+            continue
+        }
+        stored, err := db.decodeVector(raw)
+        if err != nil {
+            return nil, fmt.Errorf("embedding of restaurant %d: %w", id, err)
+        }
+        normalize(stored)
+        index.ids = append(index.ids, id)
+        index.vectors = append(index.vectors, stored...)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    query := append([]float32(nil), vector...)
+    normalize(query)
+    return index.nearest(query, limit), nil
+}
+
+// nearest возвращает limit векторов с наибольшим скалярным произведением с query.
+// Для нормированных векторов оно равно косинусной близости
+func (index vectorIndex) nearest(query []float32, limit int) []vectorMatch {
+    matches := make([]vectorMatch, len(index.ids))
+    for i, id := range index.ids {
+        offset := i * index.dimensions
+        matches[i] = vectorMatch{id: id, similarity: float64(dot(query, index.vectors[offset:offset+index.dimensions]))}
+    }
+    sort.SliceStable(matches, func(i, j int) bool { return matches[i].similarity > matches[j].similarity })
+    if limit > 0 && len(matches) > limit {
+        matches = matches[:limit]
+    }
+    return matches
+}
+
+// dot - скалярное произведение векторов одной длины; цикл развернут на четыре
+// независимых суммы, чтобы не упираться в задержку сложения
+func dot(a, b []float32) float32 {
+    b = b[:len(a)]
+    var s0, s1, s2, s3 float32
+    i := 0
+    for ; i+4 <= len(a); i += 4 {
+        s0 += a[i] * b[i]
+        s1 += a[i+1] * b[i+1]
+        s2 += a[i+2] * b[i+2]
+        s3 += a[i+3] * b[i+3]
+    }
+    for ; i < len(a); i++ {
+        s0 += a[i] * b[i]
+    }
+    return s0 + s1 + s2 + s3
+}
+
+// normalize приводит вектор к единичной длине; нулевой вектор не меняется
+func normalize(vector []float32) {
+    norm := float32(math.Sqrt(float64(dot(vector, vector))))
+    if norm == 0 {
+        return
+    }
+    for i := range vector {
+        vector[i] /= norm
+    }
+}
+
+// encodeVector приводит вектор к виду, в котором он хранится: текст '[1,2,3]' для pgvector
+// или float32 little-endian подряд для BLOB
+func (db *Database) encodeVector(vector []float32) interface{} {
+    if db.nativeVectors() {
+        parts := make([]string, len(vector))
+        for i, v := range vector {
+            parts[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+        }
+        return "[" + strings.Join(parts, ",") + "]"
+    }
+
+    raw := make([]byte, 4*len(vector))
+    for i, v := range vector {
+        binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(v))
+    }
+    return raw
+}
+
+// decodeVector читает вектор, сохраненный encodeVector
+func (db *Database) decodeVector(raw []byte) ([]float32, error) {
+    if db.nativeVectors() {
+        text := strings.Trim(string(raw), "[]")
+        if text == "" {
+            return nil, nil
+        }
+        parts := strings.Split(text, ",")
+        vector := make([]float32, len(parts))
+        for i, part := range parts {
+            v, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+            if err != nil {
+                return nil, fmt.Errorf("invalid vector %q: %w", raw, err)
+            }
+            vector[i] = float32(v)
+        }
+        return vector, nil
+    }
+
+    if len(raw)%4 != 0 {
+        return nil, fmt.Errorf("invalid vector of %d bytes", len(raw))
+    }
+    vector := make([]float32, len(raw)/4)
+    for i := range vector {
+        vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:]))
+    }
+    return vector, nil
+}
+
+// runEmbed пересчитывает векторы ресторанов встроенным HashingEmbedder
+func runEmbed(db *Database, args []string) error {
+    flags := flag.NewFlagSet("embed", flag.ContinueOnError)
+    dimensions := flags.Int("dimensions", 256, "embedding dimensions")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    count, err := db.IndexRestaurantEmbeddings(HashingEmbedder{Size: *dimensions})
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Embedded %d restaurants\n", count)
+    return nil
+}
+
+// runSimilar печатает рестораны, похожие на указанный
+func runSimilar(db *Database, args []string) error {
+    flags := flag.NewFlagSet("similar", flag.ContinueOnError)
+    id := flags.Int("id", 0, "restaurant to find similar ones for")
+    top := flags.Int("top", 5, "number of restaurants to print")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    similar, err := db.SimilarRestaurants(*id, *top)
+    if err != nil {
+        return err
+    }
+    for _, s := range similar {
+        fmt.Printf("%.3f | %d | %s | %s\n", s.Similarity, s.Restaurant.ID, s.Restaurant.Name, s.Restaurant.Type)
+    }
+    return nil
+}
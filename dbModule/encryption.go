@@ -0,0 +1,73 @@
+package main
+
+import (
+    "fmt"
+    "os"
+)
+
+// EncryptionKeyEnv - переменная окружения с ключом зашифрованного файла SQLite (см. Config.EncryptionKey)
+const EncryptionKeyEnv = "DBMODULE_DB_KEY"
+
+// NewEncryptionKeyEnv - переменная окружения с новым ключом для команды reencrypt
+const NewEncryptionKeyEnv = "DBMODULE_DB_NEW_KEY"
+
+// Reencrypt сохраняет копию базы, зашифрованную ключом newKey, в файл path через sqlcipher_export.
+// Пустой newKey сохраняет расшифрованную копию, а копия незашифрованной базы с ключом - способ
+// зашифровать ее. Текущая база не меняется: для смены ключа приложение останавливают, заменяют файл
+// копией и запускают с новым ключом. Нужен драйвер sqlcipher; файл path не должен существовать
+func (db *Database) Reencrypt(path, newKey string) error {
+    if err := db.requireSQLite("reencrypt"); err != nil {
+        return err
+    }
+    if db.driver.openEncrypted == nil {
+        return fmt.Errorf("reencrypt needs the sqlcipher driver, current driver is %s", db.driver.name)
+    }
+    if _, err := os.Stat(path); err == nil {
+        return fmt.Errorf("reencrypt: %s already exists", path)
+    }
+
+    done, err := db.beginOperation()
+    if err != nil {
+        return db.opError("reencrypt", "database", path, err)
+    }
+    defer done()
+
+    // ATTACH действует только на одно соединение, поэтому все шаги выполняются на выделенном
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return db.opError("reencrypt", "database", path, err)
+    }
+    defer conn.Close()
+
+    if err := db.execOn(ctx, conn, "encryption.attach", path, newKey); err != nil {
+        return db.opError("reencrypt", "database", path, err)
+    }
+    defer db.execOn(ctx, conn, "encryption.detach")
+
+    export, err := db.lookupQuery("encryption.export")
+    if err != nil {
+        return db.opError("reencrypt", "database", path, err)
+    }
+    // sqlcipher_export вызывается через SELECT и возвращает одну пустую строку
+    rows, err := conn.QueryContext(ctx, export)
+    if err != nil {
+        return db.opError("reencrypt", "database", path, err)
+    }
+    return db.opError("reencrypt", "database", path, rows.Close())
+}
+
+// runReencrypt сохраняет копию базы с ключом из NewEncryptionKeyEnv: reencrypt <file>.
+// Ключ не передается аргументом, чтобы не попасть в историю команд и список процессов
+func runReencrypt(db *Database, args []string) error {
+    path, err := parsePathArg("reencrypt", args)
+    if err != nil {
+        return err
+    }
+    if err := db.Reencrypt(path, os.Getenv(NewEncryptionKeyEnv)); err != nil {
+        return err
+    }
+    fmt.Printf("Re-encrypted copy saved to %s; replace the database file with it and restart with the new key in %s\n", path, EncryptionKeyEnv)
+    return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+    "errors"
+    "sync"
+    "time"
+)
+
+// EntityCacheOptions настраивает кеш выборок одной записи по ID (GetUserByID, GetRestaurantByID и т.п.)
+type EntityCacheOptions struct {
+    // TTL - сколько хранится найденная запись; 0 выключает кеш
+    TTL time.Duration
+    // NegativeTTL - сколько помнится, что записи с таким ID нет; 0 - отсутствие не кешируется
+    NegativeTTL time.Duration
+    // MaxEntries ограничивает размер кеша; при переполнении он очищается целиком
+    MaxEntries int
+}
+
+// DefaultEntityCacheOptions - кеш на несколько секунд: этого хватает, чтобы снять нагрузку
+// повторных выборок одного ресторана, а изменения из других процессов видны почти сразу
+var DefaultEntityCacheOptions = EntityCacheOptions{
+    TTL:         5 * time.Second,
+    NegativeTTL: time.Second,
+    MaxEntries:  10000,
+}
+
+// entityCascades - какие сущности удаляются внешними ключами вместе с записью
+// и поэтому сбрасываются из кеша целиком: такие удаления не проходят через audit
+var entityCascades = map[string][]string{
+    "user":       {"review"},
+    "restaurant": {"review", "menu_item"},
+}
+
+// entityKey - запись в кеше; id == 0 у ключа сброса всей сущности
+type entityKey struct {
+    entity string
+    tenant int
+    id     int
+}
+
+// entityEntry - закешированная запись или отметка, что ее нет (found == false)
+type entityEntry struct {
+    value   interface{}
+    found   bool
+    expires time.Time
+}
+
+// entityCache - кеш записей по ID, общий для всех копий Database. Записи сбрасываются при каждом
+// изменении через этот процесс (см. audit); изменения из других процессов видны по истечении TTL
+type entityCache struct {
+    mu      sync.Mutex
+    options EntityCacheOptions
+    entries map[entityKey]entityEntry
+    // generation растет при каждом сбросе: выборка, начатая до сброса, не кладет в кеш старое значение
+    generation uint64
+}
+
+// SetEntityCache включает кеш выборок по ID с заданными настройками или выключает его (TTL == 0)
+func (db *Database) SetEntityCache(options EntityCacheOptions) {
+    c := db.entities
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.options = options
+    c.entries = nil
+    c.generation++
+}
+
+// cachedLookup возвращает запись entity по id из кеша или загружает ее через load.
+// Внутри транзакции и в сессии, которая уже писала (см. freshReads), кеш не используется: они должны видеть свои изменения
+func cachedLookup[T any](db *Database, entity string, id int, load func() (T, error)) (T, error) {
+    c := db.entities
+    if c == nil || db.tx != nil || db.freshReads() {
+        return load()
+    }
+    key := entityKey{entity: entity, tenant: db.tenant, id: id}
+
+    c.mu.Lock()
+    if c.options.TTL <= 0 {
+        c.mu.Unlock()
+        return load()
+    }
+    entry, ok := c.entries[key]
+    generation := c.generation
+    c.mu.Unlock()
+
+    now := db.now()
+    if ok && now.Before(entry.expires) {
+        if !entry.found {
+            var zero T
+            return zero, ErrNotFound
+        }
+        return entry.value.(T), nil
+    }
+
+    value, err := load()
+    switch {
+    case err == nil:
+        c.put(key, entityEntry{value: value, found: true}, generation, now)
+    case errors.Is(err, ErrNotFound):
+        c.put(key, entityEntry{}, generation, now)
+    }
+    return value, err
+}
+
+// put кладет запись в кеш, если с начала ее выборки (now) ничего не сбрасывалось
+func (c *entityCache) put(key entityKey, entry entityEntry, generation uint64, now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    ttl := c.options.TTL
+    if !entry.found {
+        ttl = c.options.NegativeTTL
+    }
+    if ttl <= 0 || generation != c.generation {
+        return
+    }
+    if c.entries == nil || c.options.MaxEntries > 0 && len(c.entries) >= c.options.MaxEntries {
+        c.entries = make(map[entityKey]entityEntry)
+    }
+    entry.expires = now.Add(ttl)
+    c.entries[key] = entry
+}
+
+// invalidate сбрасывает записи по ключам; ключ с id == 0 сбрасывает всю сущность
+func (c *entityCache) invalidate(keys ...entityKey) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.generation++
+    for _, key := range keys {
+        if key.id != 0 {
+            delete(c.entries, key)
+            continue
+        }
+        for cached := range c.entries {
+            if cached.entity == key.entity {
+                delete(c.entries, cached)
+            }
+        }
+    }
+}
+
+// clearEntityCache сбрасывает весь кеш записей и результатов списков, например после миграций
+// или восстановления базы
+func (db *Database) clearEntityCache() {
+    db.InvalidateQueryCache()
+    if c := db.entities; c != nil {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        c.entries = nil
+        c.generation++
+    }
+}
+
+// invalidateEntity сбрасывает измененную запись, а при удалении - и записи, удаленные вместе с ней.
+// В транзакции сброс повторяется после фиксации, чтобы параллельная выборка не вернула в кеш
+// значение, прочитанное до нее. Кеш списков сбрасывается, даже если кеш записей выключен
+func (db *Database) invalidateEntity(entity string, id int, action string) {
+    keys := []entityKey{{entity: entity, tenant: db.tenant, id: id}}
+    db.results.invalidateEntities(keys...)
+    if action == AuditDelete {
+        for _, dependent := range entityCascades[entity] {
+            keys = append(keys, entityKey{entity: dependent})
+        }
+    }
+
+    if db.entities != nil {
+        db.entities.invalidate(keys...)
+    }
+    if db.invalidated != nil {
+        *db.invalidated = append(*db.invalidated, keys...)
+    }
+}
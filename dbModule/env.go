@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// variablePattern находит ${VAR}, ${VAR:-default} и экранирование $$
+var variablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandVariables подставляет переменные окружения в YAML файл до его разбора, чтобы один файл
+// запросов подходил для разных окружений (префиксы таблиц, схемы). ${VAR:-default} берет default,
+// если VAR не задана; $$ записывает один знак доллара. Незаданная переменная без значения
+// по умолчанию - ошибка с номером строки: пустая подстановка в SQL ломает запрос незаметно
+func expandVariables(data []byte) ([]byte, error) {
+    var undefined []string
+    for i, line := range bytes.Split(data, []byte("\n")) {
+        for _, match := range variablePattern.FindAllSubmatch(line, -1) {
+            if len(match[1]) == 0 || len(match[2]) > 0 {
+                continue
+            }
+            if _, ok := os.LookupEnv(string(match[1])); !ok {
+                undefined = append(undefined, fmt.Sprintf("%s (line %d)", match[1], i+1))
+            }
+        }
+    }
+    if len(undefined) > 0 {
+        return nil, fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+    }
+
+    return variablePattern.ReplaceAllFunc(data, func(match []byte) []byte {
+        if string(match) == "$$" {
+            return []byte("$")
+        }
+        parts := variablePattern.FindSubmatch(match)
+        if value, ok := os.LookupEnv(string(parts[1])); ok {
+            return []byte(value)
+        }
+        return parts[3]
+    }), nil
+}
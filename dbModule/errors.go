@@ -0,0 +1,153 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+)
+
+// ErrNotFound возвращается, если запрошенная запись не существует
+var ErrNotFound = errors.New("not found")
+
+// ErrForeignKeyViolation возвращается, когда запись ссылается на несуществующую строку
+// или удаляемая строка еще используется
+var ErrForeignKeyViolation = errors.New("foreign key constraint failed")
+
+// ErrConflict возвращается, если запись противоречит существующим данным:
+// нарушен уникальный ключ или строку изменили параллельно
+var ErrConflict = errors.New("conflict")
+
+// ErrMaintenance возвращается при попытке записи, пока база в режиме обслуживания (см. SetMaintenance)
+var ErrMaintenance = errors.New("database is in maintenance mode, writes are disabled")
+
+// ErrValidation возвращается, если данные некорректны и запрос к базе не выполнялся
+var ErrValidation = errors.New("validation failed")
+
+// ErrInvalidSession возвращается ValidateSession для неизвестного, отозванного или просроченного токена
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// ErrClosed возвращается операциями, начатыми после Close или Shutdown
+var ErrClosed = errors.New("database is closed")
+
+// ErrCircuitOpen возвращается без обращения к базе, пока она недоступна и автомат отключения открыт
+// (см. CircuitBreakerOptions)
+var ErrCircuitOpen = errors.New("database unavailable, circuit breaker is open")
+
+// ErrBudgetExceeded возвращается запросами сверх бюджета WithBudget, если в нем задан Abort
+var ErrBudgetExceeded = errors.New("query budget exceeded")
+
+// ErrInvalidResetToken возвращается ResetPassword для неизвестного, использованного или просроченного токена
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ErrPermissionDenied возвращается RequirePermission, если роли пользователя не хватает прав
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrForbidden возвращается изменениями с проверкой владельца (см. UpdateRestaurant), если запись
+// принадлежит не действующему пользователю и он не администратор. Это частный случай отказа в правах:
+// errors.Is(err, ErrPermissionDenied) тоже выполняется
+var ErrForbidden = fmt.Errorf("%w: not the owner", ErrPermissionDenied)
+
+// ErrStaleVersion возвращается, если строку изменили после того, как ее прочитали для обновления.
+// Это частный случай конфликта: errors.Is(err, ErrConflict) тоже выполняется
+var ErrStaleVersion = fmt.Errorf("%w: row was modified concurrently", ErrConflict)
+
+// ErrLocked возвращается WithLock, если блокировку так и не отпустил другой экземпляр.
+// Это частный случай конфликта: errors.Is(err, ErrConflict) тоже выполняется
+var ErrLocked = fmt.Errorf("%w: lock is held by another instance", ErrConflict)
+
+// ErrRowLimit возвращается методами Select, если выборка длиннее предела SetMaxRows (см. RowLimitError)
+var ErrRowLimit = errors.New("row limit exceeded")
+
+// ErrSlotFull возвращается CreateBooking, если в слоте не хватает мест на всех гостей брони.
+// Это частный случай конфликта: errors.Is(err, ErrConflict) тоже выполняется
+var ErrSlotFull = fmt.Errorf("%w: booking slot is full", ErrConflict)
+
+// OpError - ошибка операции над записью с контекстом: операция, сущность и ключ записи.
+// Kind - вид ошибки (ErrNotFound, ErrConflict, ErrForeignKeyViolation), определенный по ошибке драйвера,
+// или nil. errors.Is и errors.As видят и Kind, и исходную ошибку
+type OpError struct {
+    Op     string
+    Entity string
+    Key    interface{}
+    Kind   error
+    Err    error
+}
+
+func (e *OpError) Error() string {
+    message := e.Op + " " + e.Entity
+    if e.Key != nil && e.Key != "" {
+        message += fmt.Sprintf(" %v", e.Key)
+    }
+    if e.Kind != nil && !errors.Is(e.Err, e.Kind) {
+        message += ": " + e.Kind.Error()
+    }
+    return message + ": " + e.Err.Error()
+}
+
+// Unwrap возвращает вид ошибки и исходную ошибку для errors.Is и errors.As
+func (e *OpError) Unwrap() []error {
+    if e.Kind == nil {
+        return []error{e.Err}
+    }
+    return []error{e.Kind, e.Err}
+}
+
+// ValidationError описывает некорректное поле записи
+type ValidationError struct {
+    Field   string
+    Message string
+}
+
+func (e *ValidationError) Error() string {
+    return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrValidation)
+func (e *ValidationError) Unwrap() error {
+    return ErrValidation
+}
+
+// opError добавляет к ошибке операции контекст и определяет ее вид по ошибке драйвера.
+// nil и ошибки, уже обернутые в OpError, возвращаются как есть
+func (db *Database) opError(op, entity string, key interface{}, err error) error {
+    var opErr *OpError
+    if err == nil || errors.As(err, &opErr) {
+        return err
+    }
+
+    e := &OpError{Op: op, Entity: entity, Key: key, Err: err}
+    switch {
+    case errors.Is(err, sql.ErrNoRows):
+        e.Kind = ErrNotFound
+    case db.driver.isUniqueError(err):
+        e.Kind = ErrConflict
+    case db.driver.isForeignKeyError(err):
+        e.Kind = ErrForeignKeyViolation
+    }
+    return e
+}
+
+// DeletePolicy определяет, что делать с зависимыми записями при удалении
+type DeletePolicy int
+
+// Политики удаления, аналогичные ON DELETE RESTRICT и ON DELETE CASCADE
+const (
+    DeleteRestrict DeletePolicy = iota
+    DeleteCascade
+)
+
+// RestrictedDeleteError возвращается, если удаление запрещено из-за ссылающихся записей
+type RestrictedDeleteError struct {
+    Entity       string
+    ID           int
+    ReferencedBy string
+}
+
+func (e *RestrictedDeleteError) Error() string {
+    return fmt.Sprintf("cannot delete %s %d: still referenced by %s", e.Entity, e.ID, e.ReferencedBy)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrForeignKeyViolation)
+func (e *RestrictedDeleteError) Unwrap() error {
+    return ErrForeignKeyViolation
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+    tests := []struct {
+        name  string
+        err   error
+        is    []error
+        isNot []error
+    }{
+        {"forbidden", ErrForbidden, []error{ErrPermissionDenied}, []error{ErrConflict, ErrNotFound}},
+        {"stale version", ErrStaleVersion, []error{ErrConflict}, []error{ErrNotFound, ErrPermissionDenied}},
+        {"locked", ErrLocked, []error{ErrConflict}, []error{ErrStaleVersion}},
+        {"slot full", ErrSlotFull, []error{ErrConflict}, []error{ErrStaleVersion, ErrLocked}},
+        {"validation", &ValidationError{Field: "name", Message: "is required"}, []error{ErrValidation}, []error{ErrConflict}},
+        {"invariants", &InvariantError{Entity: "restaurant", Violations: []*ValidationError{{Field: "name", Message: "is required"}}}, []error{ErrValidation}, []error{ErrNotFound}},
+        {"restricted delete", &RestrictedDeleteError{Entity: "user", ID: 1, ReferencedBy: "restaurants"}, []error{ErrForeignKeyViolation}, []error{ErrConflict}},
+        {"wrapped forbidden", fmt.Errorf("restaurant 7: %w", ErrForbidden), []error{ErrForbidden, ErrPermissionDenied}, []error{ErrConflict}},
+        {"wrapped stale version", fmt.Errorf("version 3: %w", ErrStaleVersion), []error{ErrStaleVersion, ErrConflict}, []error{ErrLocked}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for _, target := range tt.is {
+                if !errors.Is(tt.err, target) {
+                    t.Errorf("errors.Is(%q, %q) = false, want true", tt.err, target)
+                }
+            }
+            for _, target := range tt.isNot {
+                if errors.Is(tt.err, target) {
+                    t.Errorf("errors.Is(%q, %q) = true, want false", tt.err, target)
+                }
+            }
+        })
+    }
+}
+
+func TestOpErrorWrapping(t *testing.T) {
+    db := NewTestDatabase(t)
+    tests := []struct {
+        name   string
+        err    error
+        is     []error
+        op     string
+        entity string
+    }{
+        {"no rows", db.opError("get", "restaurant", 7, sql.ErrNoRows), []error{ErrNotFound, sql.ErrNoRows}, "get", "restaurant"},
+        {"stale version", db.opError("update", "restaurant", 7, fmt.Errorf("version 3: %w", ErrStaleVersion)), []error{ErrStaleVersion, ErrConflict}, "update", "restaurant"},
+        {"forbidden", db.opError("delete", "restaurant", 7, ErrForbidden), []error{ErrForbidden, ErrPermissionDenied}, "delete", "restaurant"},
+        {"validation", db.opError("insert", "user", "a@b", &ValidationError{Field: "email", Message: "must contain @"}), []error{ErrValidation}, "insert", "user"},
+        // уже обернутая ошибка не оборачивается второй раз: остается контекст внутренней операции
+        {"nested", db.opError("import", "listings", nil, db.opError("insert", "restaurant", "Cafe", sql.ErrNoRows)), []error{ErrNotFound}, "insert", "restaurant"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for _, target := range tt.is {
+                if !errors.Is(tt.err, target) {
+                    t.Errorf("errors.Is(%q, %q) = false, want true", tt.err, target)
+                }
+            }
+            var opErr *OpError
+            if !errors.As(tt.err, &opErr) {
+                t.Fatalf("errors.As(%q, *OpError) = false", tt.err)
+            }
+            if opErr.Op != tt.op || opErr.Entity != tt.entity {
+                t.Errorf("OpError is %q %q, want %q %q", opErr.Op, opErr.Entity, tt.op, tt.entity)
+            }
+        })
+    }
+
+    if err := db.opError("get", "restaurant", 7, nil); err != nil {
+        t.Errorf("opError(nil) = %v, want nil", err)
+    }
+}
+
+// TestDatabaseErrors проверяет, что ошибки методов Database доходят до вызывающего
+// с видом ошибки, операцией и именем запроса
+func TestDatabaseErrors(t *testing.T) {
+    db := NewTestDatabase(t)
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Errors-Passw0rd!", Role: RoleOwner}
+    if _, err := db.InsertUserReturningID(&owner); err != nil {
+        t.Fatal(err)
+    }
+    restaurant := Restaurant{Name: "Cafe", Type: "cafe", AveragePrice: PriceTierBudget, UserID: owner.ID}
+    if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+        t.Fatal(err)
+    }
+    restaurant, err := db.GetRestaurantByID(restaurant.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stale := restaurant
+    stale.Name = "Renamed"
+    if _, err := db.UpdateRestaurant(owner.ID, &stale); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name  string
+        run   func() error
+        is    error
+        query string
+    }{
+        {"missing restaurant", func() error { _, err := db.GetRestaurantByID(restaurant.ID + 100); return err }, ErrNotFound, ""},
+        {"duplicate email", func() error { return db.InsertUser(User{Name: "Oleg", Lastname: "Owner", Email: owner.Email, Password: "Errors-Passw0rd!"}) }, ErrConflict, "users.insert"},
+        {"stale version", func() error { _, err := db.UpdateRestaurant(owner.ID, &restaurant); return err }, ErrStaleVersion, ""},
+        {"not the owner", func() error { return db.DeleteRestaurant(owner.ID+100, restaurant.ID) }, ErrPermissionDenied, ""},
+        {"invalid restaurant", func() error { return db.InsertRestaurant(Restaurant{UserID: owner.ID}) }, ErrValidation, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.run()
+            if !errors.Is(err, tt.is) {
+                t.Fatalf("got %v, want %v", err, tt.is)
+            }
+            var opErr *OpError
+            if !errors.As(err, &opErr) {
+                t.Errorf("errors.As(%q, *OpError) = false", err)
+            }
+            if tt.query == "" {
+                return
+            }
+            var queryErr *QueryError
+            if !errors.As(err, &queryErr) {
+                t.Fatalf("errors.As(%q, *QueryError) = false", err)
+            }
+            if queryErr.Query != tt.query {
+                t.Errorf("QueryError.Query = %q, want %q", queryErr.Query, tt.query)
+            }
+        })
+    }
+}
+
+// TestGraphQLErrorMessage проверяет, что GraphQL скрывает текст только внутренних ошибок, а пределы
+// строк, бюджета и времени сообщает клиенту, как REST (см. errorStatus)
+func TestGraphQLErrorMessage(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want string
+    }{
+        {"row limit", &RowLimitError{Query: "restaurants.select", Limit: 100}, "query restaurants.select: more than 100 rows"},
+        {"budget exceeded", fmt.Errorf("users.select: %w", ErrBudgetExceeded), "users.select: query budget exceeded"},
+        {"timeout", fmt.Errorf("restaurants.select exceeded read timeout 5s: %w", context.DeadlineExceeded), "restaurants.select exceeded read timeout 5s: context deadline exceeded"},
+        {"internal", errors.New("no such column: foo"), "Internal Server Error"},
+    }
+    log.SetOutput(io.Discard)
+    t.Cleanup(func() { log.SetOutput(os.Stderr) })
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := graphQLErrorMessage(tt.err); got != tt.want {
+                t.Errorf("graphQLErrorMessage(%q) = %q, want %q", tt.err, got, tt.want)
+            }
+        })
+    }
+}
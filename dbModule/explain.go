@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// explainPrefixes - диалекты, где план возвращает сам запрос с префиксом.
+// В SQL Server и Oracle план получается несколькими операторами на одном соединении (см. explainSession)
+var explainPrefixes = map[string]string{
+    "sqlite":   "EXPLAIN QUERY PLAN ",
+    "postgres": "EXPLAIN ",
+    "mysql":    "EXPLAIN ",
+}
+
+// QueryPlan - план выполнения запроса, как его описывает СУБД
+type QueryPlan struct {
+    // Query - запрос, для которого получен план
+    Query string
+    // Lines - строки плана; вложенные шаги SQLite сдвинуты отступом
+    Lines []string
+}
+
+// String печатает план по строке на шаг
+func (p QueryPlan) String() string {
+    return strings.Join(p.Lines, "\n")
+}
+
+// Explain возвращает план выполнения запроса query с аргументами args, не выполняя его.
+// Запрос пишется, как в файлах запросов: в синтаксисе SQLite с плейсхолдерами ?.
+// План строится на отдельном соединении пула, а не в транзакции InTx
+func (db *Database) Explain(query string, args ...interface{}) (QueryPlan, error) {
+    ctx, cancel := db.operationContext(operationRead)
+    defer cancel()
+    plan, err := db.explain(ctx, query, args)
+    if err != nil {
+        return QueryPlan{}, fmt.Errorf("explaining query: %w", err)
+    }
+    return plan, nil
+}
+
+// ExplainNamed возвращает план именованного запроса в варианте текущего диалекта
+func (db *Database) ExplainNamed(name string, args ...interface{}) (QueryPlan, error) {
+    query, err := db.lookupQuery(name)
+    if err != nil {
+        return QueryPlan{}, db.opError("explain", "query", name, err)
+    }
+    plan, err := db.Explain(query, args...)
+    if err != nil {
+        return QueryPlan{}, fmt.Errorf("%s: %w", name, err)
+    }
+    return plan, nil
+}
+
+// explain получает план запроса способом, который поддерживает диалект
+func (db *Database) explain(ctx context.Context, query string, args []interface{}) (QueryPlan, error) {
+    dialect := db.driver.dialect.Name()
+    query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+    plan := QueryPlan{Query: query}
+    rebound := db.driver.dialect.Rebind(query)
+
+    var err error
+    if prefix, ok := explainPrefixes[dialect]; ok {
+        plan.Lines, err = readPlan(ctx, db.DB, dialect, prefix+rebound, args)
+        return plan, err
+    }
+    switch dialect {
+    case "mssql":
+        plan.Lines, err = db.explainSession(ctx, "SET SHOWPLAN_TEXT ON", rebound, args, "SET SHOWPLAN_TEXT OFF")
+    case "oracle":
+        plan.Lines, err = db.explainSession(ctx, "EXPLAIN PLAN FOR "+rebound, "SELECT plan_table_output FROM TABLE(DBMS_XPLAN.DISPLAY())", args, "")
+    default:
+        err = fmt.Errorf("query plans are not supported for %s", dialect)
+    }
+    return plan, err
+}
+
+// explainSession выполняет setup, читает план запросом planQuery и выполняет teardown на одном
+// соединении: в SQL Server режим SHOWPLAN действует на сессию, а Oracle пишет план в PLAN_TABLE сессии.
+// Аргументы получает оператор, текст которого содержит исходный запрос
+func (db *Database) explainSession(ctx context.Context, setup, planQuery string, args []interface{}, teardown string) ([]string, error) {
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    setupArgs, planArgs := args, []interface{}(nil)
+    if teardown != "" {
+        setupArgs, planArgs = nil, args
+    }
+    if _, err := conn.ExecContext(ctx, setup, setupArgs...); err != nil {
+        return nil, err
+    }
+    lines, err := readPlan(ctx, conn, db.driver.dialect.Name(), planQuery, planArgs)
+    if teardown != "" {
+        if _, teardownErr := conn.ExecContext(ctx, teardown); teardownErr != nil && err == nil {
+            err = teardownErr
+        }
+    }
+    return lines, err
+}
+
+// planQuerier - общий интерфейс sql.DB и sql.Conn для чтения плана
+type planQuerier interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// readPlan читает строки плана из всех наборов результатов запроса
+func readPlan(ctx context.Context, conn planQuerier, dialect, query string, args []interface{}) ([]string, error) {
+    rows, err := conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    // depth - отступы шагов SQLite по id: каждый шаг ссылается на родителя колонкой parent
+    depth := map[string]int{}
+    var lines []string
+    for {
+        columns, err := rows.Columns()
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            values := make([]sql.NullString, len(columns))
+            dest := make([]interface{}, len(columns))
+            for i := range values {
+                dest[i] = &values[i]
+            }
+            if err := rows.Scan(dest...); err != nil {
+                return nil, err
+            }
+            lines = append(lines, planLine(dialect, columns, values, depth))
+        }
+        if !rows.NextResultSet() {
+            break
+        }
+    }
+    return lines, rows.Err()
+}
+
+// planLine превращает строку результата EXPLAIN в строку плана. План SQLite (id, parent, notused, detail)
+// печатается деревом, одна колонка - как есть, остальное (например, EXPLAIN MySQL) - парами колонка=значение
+func planLine(dialect string, columns []string, values []sql.NullString, depth map[string]int) string {
+    if dialect == "sqlite" && len(columns) == 4 {
+        level := 0
+        if parent, ok := depth[values[1].String]; ok {
+            level = parent + 1
+        }
+        depth[values[0].String] = level
+        return strings.Repeat("  ", level) + values[3].String
+    }
+    if len(columns) == 1 {
+        return values[0].String
+    }
+    var parts []string
+    for i, column := range columns {
+        if values[i].Valid {
+            parts = append(parts, column+"="+values[i].String)
+        }
+    }
+    return strings.Join(parts, " ")
+}
+
+// logSlowQuery пишет в лог запрос, выполнявшийся дольше порога Config.SlowQuery, вместе с его планом.
+// Секретные аргументы скрываются, как в логе запросов; ошибка получения плана только попадает в лог
+func (db *Database) logSlowQuery(name, query string, args []interface{}, elapsed time.Duration) {
+    if db.slowQuery <= 0 || elapsed < db.slowQuery {
+        return
+    }
+    ctx, cancel := db.operationContext(operationRead)
+    defer cancel()
+    plan, err := db.explain(ctx, query, args)
+    if err != nil {
+        log.Printf("%sslow query %s %s took %v (threshold %v), plan unavailable: %v", db.correlationPrefix(), name, formatArgs(args), elapsed, db.slowQuery, err)
+        return
+    }
+    log.Printf("%sslow query %s %s took %v (threshold %v), plan:\n%s", db.correlationPrefix(), name, formatArgs(args), elapsed, db.slowQuery, plan)
+}
+
+// runExplain печатает план именованного запроса или SQL-текста; остальные аргументы - его параметры
+func runExplain(db *Database, args []string) error {
+    flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if flags.NArg() == 0 {
+        return fmt.Errorf("usage: explain <query name | SQL> [args...]")
+    }
+
+    query := flags.Arg(0)
+    params := make([]interface{}, 0, flags.NArg()-1)
+    for _, arg := range flags.Args()[1:] {
+        params = append(params, arg)
+    }
+
+    var plan QueryPlan
+    var err error
+    if db.queries.Has(query) {
+        plan, err = db.ExplainNamed(query, params...)
+    } else {
+        plan, err = db.Explain(query, params...)
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Println(plan.Query)
+    fmt.Println(plan)
+    return nil
+}
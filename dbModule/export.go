@@ -0,0 +1,211 @@
+package main
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// exportNamespace - пространство имен запросов, доступных для выгрузки
+const exportNamespace = "export."
+
+// Compression - сжатие потока выгрузки
+type Compression string
+
+const (
+    CompressionNone Compression = "none"
+    CompressionGzip Compression = "gzip"
+)
+
+// parseCompression разбирает значение флага -compress; auto выбирает gzip для файлов .gz
+func parseCompression(value, path string) (Compression, error) {
+    switch value {
+    case "auto":
+        if strings.HasSuffix(path, ".gz") {
+            return CompressionGzip, nil
+        }
+        return CompressionNone, nil
+    case string(CompressionNone), string(CompressionGzip):
+        return Compression(value), nil
+    case "zstd":
+        return "", fmt.Errorf("zstd compression is not supported, use gzip")
+    default:
+        return "", fmt.Errorf("unknown compression %q (expected auto, gzip or none)", value)
+    }
+}
+
+// negotiateCompression выбирает сжатие по заголовку Accept-Encoding: gzip, если клиент
+// его принимает (в том числе через *) и не запретил через q=0
+func negotiateCompression(acceptEncoding string) Compression {
+    for _, part := range strings.Split(acceptEncoding, ",") {
+        coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        coding = strings.ToLower(strings.TrimSpace(coding))
+        if coding != "gzip" && coding != "*" {
+            continue
+        }
+        if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+                continue
+            }
+        }
+        return CompressionGzip
+    }
+    return CompressionNone
+}
+
+// compressWriter оборачивает w сжатием; Close дописывает сжатый поток, но не закрывает w
+func compressWriter(w io.Writer, compression Compression) io.WriteCloser {
+    if compression == CompressionGzip {
+        // для выгрузок в сотни мегабайт скорость важнее последних процентов размера
+        gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+        return gz
+    }
+    return nopWriteCloser{w}
+}
+
+// nopWriteCloser - поток без сжатия
+type nopWriteCloser struct {
+    io.Writer
+}
+
+// Close ничего не делает
+func (nopWriteCloser) Close() error { return nil }
+
+// Export пишет в w все строки выгрузки name текущей площадки (запрос export.<name>) построчно в JSON,
+// по одному объекту на строку, и сжимает поток. Строки не накапливаются в памяти,
+// поэтому выгрузка любого размера идет с постоянным расходом памяти, но целиком должна уложиться
+// в таймаут чтения (Config.Timeouts.Read). Возвращает число строк
+func (db *Database) Export(w io.Writer, name string, compression Compression) (int, error) {
+    return db.export(w, name, compression, nil)
+}
+
+// export выполняет Export, вызывая onRow после каждой записанной строки (например, для прогресса
+// задания); ошибка onRow прерывает выгрузку
+func (db *Database) export(w io.Writer, name string, compression Compression, onRow func() error) (int, error) {
+    rows, err := db.queryNamed(exportNamespace+name, db.tenant)
+    if err != nil {
+        return 0, db.opError("export", name, nil, err)
+    }
+    defer rows.Close()
+
+    columns, err := rows.Columns()
+    if err != nil {
+        return 0, db.opError("export", name, nil, err)
+    }
+
+    compressed := compressWriter(w, compression)
+    buffered := bufio.NewWriter(compressed)
+    encoder := json.NewEncoder(buffered)
+
+    values := make([]interface{}, len(columns))
+    pointers := make([]interface{}, len(columns))
+    for i := range values {
+        pointers[i] = &values[i]
+    }
+    count := 0
+    for rows.Next() {
+        if err := rows.Scan(pointers...); err != nil {
+            return count, db.opError("export", name, nil, err)
+        }
+        record := make(map[string]interface{}, len(columns))
+        for i, column := range columns {
+            // драйверы SQLite и MySQL отдают текст как []byte, который JSON закодировал бы в base64
+            if raw, ok := values[i].([]byte); ok {
+                values[i] = string(raw)
+            }
+            record[column] = values[i]
+        }
+        if err := encoder.Encode(record); err != nil {
+            return count, err
+        }
+        count++
+        if onRow != nil {
+            if err := onRow(); err != nil {
+                return count, err
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return count, db.opError("export", name, nil, err)
+    }
+    if err := buffered.Flush(); err != nil {
+        return count, err
+    }
+    return count, compressed.Close()
+}
+
+// ExportHandler отдает выгрузку name по HTTP в формате JSON Lines, сжимая ее gzip,
+// если клиент указал это в Accept-Encoding
+func (db *Database) ExportHandler(name string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !db.queries.Has(exportNamespace + name) {
+            http.NotFound(w, r)
+            return
+        }
+        compression := negotiateCompression(r.Header.Get("Accept-Encoding"))
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        w.Header().Add("Vary", "Accept-Encoding")
+        if compression == CompressionGzip {
+            w.Header().Set("Content-Encoding", "gzip")
+        }
+        // после первых байт статус уже отправлен, поэтому ошибку остается только записать в лог
+        if _, err := db.Export(w, name, compression); err != nil {
+            log.Printf("export %s: %v", name, err)
+        }
+    })
+}
+
+// exportNames возвращает имена доступных выгрузок
+func (db *Database) exportNames() []string {
+    var names []string
+    for _, name := range db.queries.Names() {
+        if strings.HasPrefix(name, exportNamespace) && !strings.Contains(name, "@") {
+            names = append(names, strings.TrimPrefix(name, exportNamespace))
+        }
+    }
+    return names
+}
+
+// runExport выгружает таблицу текущей площадки в файл или stdout
+func runExport(db *Database, args []string) error {
+    flags := flag.NewFlagSet("export", flag.ContinueOnError)
+    output := flags.String("out", "", "output file (default: stdout)")
+    compress := flags.String("compress", "auto", "compression: gzip, none or auto (gzip for .gz files)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    names := db.exportNames()
+    if flags.NArg() != 1 || !db.queries.Has(exportNamespace+flags.Arg(0)) {
+        return fmt.Errorf("usage: export [-out file] [-compress gzip|none|auto] <%s>", strings.Join(names, "|"))
+    }
+    compression, err := parseCompression(*compress, *output)
+    if err != nil {
+        return err
+    }
+
+    w := io.Writer(os.Stdout)
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        w = file
+    }
+    count, err := db.Export(w, flags.Arg(0), compression)
+    if err != nil {
+        return err
+    }
+    if *output != "" {
+        fmt.Printf("Exported %d rows to %s\n", count, *output)
+    }
+    return nil
+}
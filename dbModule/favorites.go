@@ -0,0 +1,186 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// Favorite - ресторан в избранном пользователя; пишется в журнал аудита при добавлении и удалении
+type Favorite struct {
+    UserID       int `json:"user_id"`
+    RestaurantID int `json:"restaurant_id"`
+}
+
+// String - ключ связи в сообщениях об ошибках
+func (f Favorite) String() string {
+    return fmt.Sprintf("%d/%d", f.UserID, f.RestaurantID)
+}
+
+// AddFavorite добавляет ресторан в избранное пользователя. Повторное добавление ничего не меняет.
+// Пользователь или ресторан, которых нет на площадке, - ErrForeignKeyViolation
+func (db *Database) AddFavorite(userID, restaurantID int) error {
+    favorite := Favorite{UserID: userID, RestaurantID: restaurantID}
+    err := db.InTx(func(tx *Database) error {
+        exists, err := tx.isFavorite(userID, restaurantID)
+        if err != nil || exists {
+            return err
+        }
+        result, err := tx.execNamed("favorites.insert", tx.now().UTC(), userID, restaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if n, err := result.RowsAffected(); err == nil && n == 0 {
+            return fmt.Errorf("user %d or restaurant %d does not exist: %w", userID, restaurantID, ErrForeignKeyViolation)
+        }
+        return tx.audit("favorite", userID, AuditInsert, nil, favorite)
+    })
+    return db.opError("insert", "favorite", favorite, err)
+}
+
+// RemoveFavorite убирает ресторан из избранного пользователя; ErrNotFound, если его там нет
+func (db *Database) RemoveFavorite(userID, restaurantID int) error {
+    favorite := Favorite{UserID: userID, RestaurantID: restaurantID}
+    err := db.InTx(func(tx *Database) error {
+        result, err := tx.execNamed("favorites.delete", userID, restaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if n, err := result.RowsAffected(); err == nil && n == 0 {
+            return ErrNotFound
+        }
+        return tx.audit("favorite", userID, AuditDelete, favorite, nil)
+    })
+    return db.opError("delete", "favorite", favorite, err)
+}
+
+// ListFavorites возвращает избранные рестораны пользователя, недавно добавленные первыми
+func (db *Database) ListFavorites(userID int) ([]Restaurant, error) {
+    rows, err := db.queryNamed("favorites.select_restaurants_by_user", userID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "favorites", userID, err)
+    }
+    defer rows.Close()
+
+    var restaurants []Restaurant
+    for rows.Next() {
+        restaurant, err := scanRestaurant(rows)
+        if err != nil {
+            return nil, db.opError("list", "favorites", userID, err)
+        }
+        restaurants = append(restaurants, restaurant)
+    }
+    return restaurants, db.opError("list", "favorites", userID, rows.Err())
+}
+
+// ListFans возвращает пользователей, добавивших ресторан в избранное, недавних первыми
+func (db *Database) ListFans(restaurantID int) ([]User, error) {
+    rows, err := db.queryNamed("favorites.select_users_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "fans", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var users []User
+    for rows.Next() {
+        user, err := db.scanUser(rows)
+        if err != nil {
+            return nil, db.opError("list", "fans", restaurantID, err)
+        }
+        users = append(users, user)
+    }
+    return users, db.opError("list", "fans", restaurantID, rows.Err())
+}
+
+// isFavorite сообщает, что ресторан уже в избранном пользователя
+func (db *Database) isFavorite(userID, restaurantID int) (bool, error) {
+    rows, err := db.queryNamed("favorites.count", userID, restaurantID, db.tenant)
+    if err != nil {
+        return false, err
+    }
+    defer rows.Close()
+
+    var count int
+    if rows.Next() {
+        if err := rows.Scan(&count); err != nil {
+            return false, err
+        }
+    }
+    return count > 0, rows.Err()
+}
+
+// serveFavorites отдает избранные рестораны пользователя
+func (db *Database) serveFavorites(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if _, err := db.GetUserByID(userID); err != nil {
+        writeError(w, err)
+        return
+    }
+    restaurants, err := db.ListFavorites(userID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if restaurants == nil {
+        restaurants = []Restaurant{}
+    }
+    writeJSON(w, http.StatusOK, restaurants)
+}
+
+// serveFavorite добавляет (PUT) или убирает (DELETE) ресторан из избранного и отвечает связью.
+// Пользователь, вошедший через -auth, меняет только свое избранное, если он не администратор
+func (db *Database) serveFavorite(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeUser(actor, userID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    restaurantID, err := strconv.Atoi(r.PathValue("restaurant_id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "restaurant_id", Message: "must be an integer"})
+        return
+    }
+    if r.Method == http.MethodDelete {
+        err = db.RemoveFavorite(userID, restaurantID)
+    } else {
+        err = db.AddFavorite(userID, restaurantID)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, Favorite{UserID: userID, RestaurantID: restaurantID})
+}
+
+// serveFans отдает пользователей, добавивших ресторан в избранное, без паролей
+func (db *Database) serveFans(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if _, err := db.GetRestaurantByID(restaurantID); err != nil {
+        writeError(w, err)
+        return
+    }
+    users, err := db.ListFans(restaurantID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    fans := make([]PublicUser, len(users))
+    for i, user := range users {
+        fans[i] = user.Public()
+    }
+    writeJSON(w, http.StatusOK, fans)
+}
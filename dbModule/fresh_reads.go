@@ -0,0 +1,46 @@
+package main
+
+import (
+    "context"
+    "sync/atomic"
+)
+
+// freshReadsKey - ключ контекста с отметкой записи сессии (см. WithFreshReads)
+type freshReadsKey struct{}
+
+// writeSession отмечает, что сессия - например, один HTTP-запрос (см. perRequest) - уже что-то записала.
+// Отметка общая для всех копий Database с этим контекстом, включая копии транзакций
+type writeSession struct {
+    wrote atomic.Bool
+}
+
+// WithFreshReads возвращает контекст, чтения Database с которым (см. WithContext) всегда видят последние
+// данные: идут мимо кеша списков и кеша записей и читают из основной базы, а не с реплик. Обычно это
+// не нужно: чтения HTTP-запроса, который уже что-то записал, обходят кеши и реплики сами (см. perRequest)
+func WithFreshReads(ctx context.Context) context.Context {
+    session := &writeSession{}
+    session.wrote.Store(true)
+    return context.WithValue(ctx, freshReadsKey{}, session)
+}
+
+// withWriteSession возвращает контекст, чтения с которым становятся свежими после первой записи с ним
+func withWriteSession(ctx context.Context) context.Context {
+    if _, ok := ctx.Value(freshReadsKey{}).(*writeSession); ok {
+        return ctx
+    }
+    return context.WithValue(ctx, freshReadsKey{}, &writeSession{})
+}
+
+// markWritten отмечает успешную запись в сессии копии, если она есть
+func (db *Database) markWritten() {
+    if session, ok := db.baseContext().Value(freshReadsKey{}).(*writeSession); ok {
+        session.wrote.Store(true)
+    }
+}
+
+// freshReads сообщает, что чтения копии должны видеть свои записи: сессия уже писала
+// или задана WithFreshReads. Такие чтения не используют кеши и реплики
+func (db *Database) freshReads() bool {
+    session, ok := db.baseContext().Value(freshReadsKey{}).(*writeSession)
+    return ok && session.wrote.Load()
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// fsckOrphanChecks - таблицы, строки которых ссылаются на удаленного родителя, в порядке проверки:
+// удаление ресторана каскадом удаляет и его дочерние строки. entity - сущность аудита, удаление
+// которой записывается в audit_log; у остальных таблиц ее нет
+var fsckOrphanChecks = []struct {
+    table   string
+    problem string
+    entity  string
+}{
+    {"restaurants", "owner user does not exist", "restaurant"},
+    {"menu_items", "restaurant does not exist", "menu_item"},
+    {"reviews", "restaurant or author does not exist", "review"},
+    {"restaurant_hours", "restaurant does not exist", ""},
+    {"restaurant_tags", "restaurant does not exist", ""},
+    {"restaurant_embeddings", "restaurant does not exist", ""},
+    {"images", "restaurant does not exist", ""},
+    {"documents", "user does not exist", ""},
+    {"sessions", "user does not exist", ""},
+    {"password_resets", "user does not exist", ""},
+    // содержимое вложения в AttachmentStorage при исправлении не удаляется
+    {"attachments", "attached record does not exist", "attachment"},
+    {"translations", "translated record does not exist", ""},
+}
+
+// FsckProblem - найденное нарушение целостности
+type FsckProblem struct {
+    // Check - вид проверки: orphan, missing blob, ref count, unreferenced blob, missing file, stray file
+    Check string
+    // Tenant - площадка строки; 0 и для таблиц без tenant_id
+    Tenant  int
+    Table   string
+    Key     string
+    Problem string
+    // Action - что сделано при исправлении; пусто, если не исправлялось
+    Action string
+}
+
+// FsckReport - результат Fsck
+type FsckReport struct {
+    Problems []FsckProblem
+}
+
+// Unrepaired возвращает число проблем, оставшихся без исправления
+func (r FsckReport) Unrepaired() int {
+    n := 0
+    for _, p := range r.Problems {
+        if p.Action == "" {
+            n++
+        }
+    }
+    return n
+}
+
+// fsck - состояние одной проверки
+type fsck struct {
+    store  *BlobStore
+    repair bool
+    report FsckReport
+    // remove - файлы хранилища, удаляемые после фиксации транзакции, и индексы их проблем в отчете
+    remove map[string]int
+}
+
+// Fsck проверяет ссылочную целостность всех площадок: строки без родителя (рестораны без владельца,
+// отзывы без ресторана или автора и т.д.), ссылки на отсутствующие blob, счетчики ссылок blobs.ref_count,
+// blob без ссылок, строки blobs без файла и файлы store без строки. Рейтинг ресторанов не хранится,
+// а считается по reviews при чтении, поэтому расходиться может только ref_count.
+// С repair все исправления выполняются в одной транзакции (и в режиме обслуживания), удаления
+// сущностей записываются в аудит, а файлы удаляются после фиксации. Строку blobs без файла исправить
+// нельзя: содержимое потеряно, она только попадает в отчет
+func (db *Database) Fsck(store *BlobStore, repair bool) (FsckReport, error) {
+    check := &fsck{store: store, repair: repair, remove: map[string]int{}}
+    if !repair {
+        err := check.run(db)
+        return check.report, db.opError("check", "database", nil, err)
+    }
+
+    // исправление - действие оператора, поэтому режим обслуживания ему не мешает
+    err := db.IgnoringMaintenance().InTx(func(tx *Database) error {
+        return check.run(tx)
+    })
+    if err != nil {
+        return FsckReport{}, db.opError("repair", "database", nil, err)
+    }
+    for path, i := range check.remove {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            check.report.Problems[i].Action = ""
+            return check.report, db.opError("repair", "database", nil, err)
+        }
+    }
+    return check.report, nil
+}
+
+// run выполняет проверки по порядку
+func (c *fsck) run(db *Database) error {
+    for _, orphan := range fsckOrphanChecks {
+        if err := c.orphans(db, orphan.table, orphan.problem, orphan.entity); err != nil {
+            return err
+        }
+    }
+    for _, table := range []string{"images", "documents"} {
+        if err := c.missingBlobs(db, table); err != nil {
+            return err
+        }
+    }
+    if err := c.refCounts(db); err != nil {
+        return err
+    }
+    return c.blobFiles(db)
+}
+
+// add добавляет проблему в отчет и возвращает ее индекс
+func (c *fsck) add(problem FsckProblem) int {
+    c.report.Problems = append(c.report.Problems, problem)
+    return len(c.report.Problems) - 1
+}
+
+// orphans находит и с repair удаляет строки table без родителя
+func (c *fsck) orphans(db *Database, table, problem, entity string) error {
+    type orphan struct {
+        tenant int
+        key    string
+    }
+    var found []orphan
+    rows, err := db.queryNamed("fsck.orphan_" + table)
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var o orphan
+        if err := rows.Scan(&o.tenant, &o.key); err != nil {
+            rows.Close()
+            return err
+        }
+        o.key = strings.TrimSpace(o.key)
+        found = append(found, o)
+    }
+    // строки удаляются после чтения: транзакция может держать единственное соединение
+    if err := rows.Close(); err != nil {
+        return err
+    }
+
+    for _, o := range found {
+        i := c.add(FsckProblem{Check: "orphan", Tenant: o.tenant, Table: table, Key: o.key, Problem: problem})
+        if !c.repair {
+            continue
+        }
+        deleted, err := db.WithTenant(o.tenant).deleteOrphan(table, entity, o.key)
+        if err != nil {
+            return err
+        }
+        if deleted {
+            c.report.Problems[i].Action = "deleted"
+        }
+    }
+    return nil
+}
+
+// deleteOrphan удаляет строку без родителя и записывает удаление сущности в аудит;
+// false, если родитель успел появиться
+func (db *Database) deleteOrphan(table, entity, key string) (bool, error) {
+    var old interface{}
+    var id int
+    if entity != "" {
+        var err error
+        if id, err = strconv.Atoi(key); err != nil {
+            return false, err
+        }
+        switch entity {
+        case "restaurant":
+            old, err = db.findRestaurant("restaurants.select_by_id", id, db.tenant)
+        case "menu_item":
+            old, err = db.findMenuItem(id)
+        case "review":
+            old, err = db.findReview(id)
+        case "attachment":
+            old, err = db.findAttachment(id)
+        }
+        if err != nil {
+            return false, err
+        }
+    }
+
+    result, err := db.execNamed("fsck.delete_"+table, key)
+    if err != nil {
+        return false, err
+    }
+    if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+        return false, err
+    }
+    if entity == "" {
+        return true, nil
+    }
+    return true, db.audit(entity, id, AuditDelete, old, nil)
+}
+
+// missingBlobs находит строки table, ссылающиеся на отсутствующую строку blobs. С repair строка blobs
+// восстанавливается, если файл есть в хранилище, иначе удаляется сама ссылка
+func (c *fsck) missingBlobs(db *Database, table string) error {
+    type reference struct {
+        id   int
+        hash string
+    }
+    var found []reference
+    rows, err := db.queryNamed("fsck.missing_blob_" + table)
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var r reference
+        if err := rows.Scan(&r.id, &r.hash); err != nil {
+            rows.Close()
+            return err
+        }
+        r.hash = strings.TrimSpace(r.hash)
+        found = append(found, r)
+    }
+    if err := rows.Close(); err != nil {
+        return err
+    }
+
+    restored := map[string]bool{}
+    for _, r := range found {
+        i := c.add(FsckProblem{Check: "missing blob", Table: table, Key: strconv.Itoa(r.id), Problem: "blob " + r.hash + " does not exist"})
+        if !c.repair {
+            continue
+        }
+        if info, ok := c.file(r.hash); ok {
+            if !restored[r.hash] {
+                if _, err := db.execNamed("blobs.insert_missing", r.hash, info.Size()); err != nil {
+                    return err
+                }
+                restored[r.hash] = true
+            }
+            c.report.Problems[i].Action = "blob restored from file"
+            continue
+        }
+        if _, err := db.execNamed("fsck.delete_missing_blob_"+table, r.id); err != nil {
+            return err
+        }
+        c.report.Problems[i].Action = "deleted, file is missing too"
+    }
+    return nil
+}
+
+// refCounts сверяет blobs.ref_count с числом ссылок из images и documents
+func (c *fsck) refCounts(db *Database) error {
+    type drift struct {
+        hash   string
+        stored int
+        actual int
+    }
+    var found []drift
+    rows, err := db.queryNamed("fsck.ref_count_drift")
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var d drift
+        if err := rows.Scan(&d.hash, &d.stored, &d.actual); err != nil {
+            rows.Close()
+            return err
+        }
+        d.hash = strings.TrimSpace(d.hash)
+        found = append(found, d)
+    }
+    if err := rows.Close(); err != nil {
+        return err
+    }
+
+    for _, d := range found {
+        i := c.add(FsckProblem{Check: "ref count", Table: "blobs", Key: d.hash, Problem: fmt.Sprintf("ref_count %d, references %d", d.stored, d.actual)})
+        if !c.repair {
+            continue
+        }
+        if _, err := db.execNamed("fsck.recount_blob", d.hash); err != nil {
+            return err
+        }
+        c.report.Problems[i].Action = fmt.Sprintf("ref_count set to %d", d.actual)
+    }
+    return nil
+}
+
+// blobFiles сверяет строки blobs с файлами хранилища: blob без ссылок с repair удаляется вместе
+// с файлом, строка без файла только попадает в отчет, а файл без строки старше orphanGracePeriod удаляется
+func (c *fsck) blobFiles(db *Database) error {
+    store := NewBlobStore(db, c.store.dir)
+    // известны и хеши, на которые ссылаются images и documents: их строки могли быть восстановлены
+    // из файлов, а без repair файлы таких ссылок не считаются лишними
+    known, err := store.selectHashes("blobs.select_hashes")
+    if err != nil {
+        return err
+    }
+    referenced, err := store.selectHashes("fsck.referenced_hashes")
+    if err != nil {
+        return err
+    }
+    for hash := range referenced {
+        known[hash] = true
+    }
+
+    unreferenced, err := store.selectHashes("fsck.unreferenced_blobs")
+    if err != nil {
+        return err
+    }
+    for hash := range unreferenced {
+        i := c.add(FsckProblem{Check: "unreferenced blob", Table: "blobs", Key: hash, Problem: "no images or documents refer to it"})
+        if !c.repair {
+            continue
+        }
+        result, err := db.execNamed("fsck.delete_unreferenced_blob", hash)
+        if err != nil {
+            return err
+        }
+        if affected, _ := result.RowsAffected(); affected == 0 {
+            continue
+        }
+        c.report.Problems[i].Action = "deleted"
+        if _, ok := c.file(hash); ok {
+            c.remove[c.store.path(hash)] = i
+        }
+    }
+
+    blobs, err := store.selectHashes("blobs.select_hashes")
+    if err != nil {
+        return err
+    }
+    for hash := range blobs {
+        if _, ok := c.file(hash); !ok && !unreferenced[hash] {
+            c.add(FsckProblem{Check: "missing file", Table: "blobs", Key: hash, Problem: "file is missing from the store, content is lost"})
+        }
+    }
+
+    stray, err := c.store.orphanFiles(known)
+    if err != nil {
+        return err
+    }
+    for _, path := range stray {
+        i := c.add(FsckProblem{Check: "stray file", Key: path, Problem: "no blob row refers to the file"})
+        if c.repair {
+            c.report.Problems[i].Action = "removed"
+            c.remove[path] = i
+        }
+    }
+    return nil
+}
+
+// file возвращает сведения о файле blob в хранилище; false, если его нет
+func (c *fsck) file(hash string) (os.FileInfo, bool) {
+    if len(hash) < 2 {
+        return nil, false
+    }
+    info, err := os.Stat(c.store.path(hash))
+    return info, err == nil && !info.IsDir()
+}
+
+// runFsck проверяет целостность данных и с -repair исправляет найденное
+func runFsck(db *Database, args []string) error {
+    flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+    repair := flags.Bool("repair", false, "fix the problems found in one transaction")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    report, err := db.Fsck(NewBlobStore(db, *blobsFlag), *repair)
+    if err != nil {
+        return err
+    }
+    for _, p := range report.Problems {
+        where := strings.TrimSpace(p.Table + " " + p.Key)
+        if p.Tenant != 0 {
+            where += fmt.Sprintf(" (tenant %d)", p.Tenant)
+        }
+        action := p.Action
+        if action == "" {
+            action = "not repaired"
+        }
+        fmt.Printf("%-17s %s: %s: %s\n", p.Check, where, p.Problem, action)
+    }
+    unrepaired := report.Unrepaired()
+    fmt.Printf("%d problems found, %d repaired\n", len(report.Problems), len(report.Problems)-unrepaired)
+    if unrepaired > 0 {
+        return fmt.Errorf("%d problems left unrepaired", unrepaired)
+    }
+    return nil
+}
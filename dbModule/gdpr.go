@@ -0,0 +1,134 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "time"
+)
+
+// UserDataExport - все данные пользователя для ответа на запрос субъекта данных (GDPR, ст. 15 и 20)
+type UserDataExport struct {
+    ExportedAt  time.Time    `json:"exported_at"`
+    User        AdminUser    `json:"user"`
+    Restaurants []Restaurant `json:"restaurants"`
+    Reviews     []Review     `json:"reviews"`
+    Bookings    []Booking    `json:"bookings"`
+    Preferences Preferences  `json:"preferences"`
+    // AuditTrail - история изменений строки пользователя
+    AuditTrail []AuditEntry `json:"audit_trail"`
+}
+
+// ExportUserData собирает данные пользователя текущей площадки: его строку без пароля, рестораны,
+// отзывы, брони, настройки и историю изменений. Все читается в одной транзакции, чтобы выгрузка была согласованной
+func (db *Database) ExportUserData(userID int) (UserDataExport, error) {
+    export := UserDataExport{ExportedAt: db.now().UTC()}
+    err := db.InTx(func(tx *Database) error {
+        aggregate, err := tx.GetUserWithRestaurants(userID)
+        if err != nil {
+            return err
+        }
+        export.User = aggregate.User.Admin()
+        export.Restaurants = aggregate.Restaurants
+
+        rows, err := tx.queryNamed("reviews.select_by_user", userID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            review, err := scanReview(rows)
+            if err != nil {
+                return err
+            }
+            export.Reviews = append(export.Reviews, review)
+        }
+        if err := rows.Err(); err != nil {
+            return err
+        }
+
+        if export.Bookings, err = tx.ListBookingsByUser(userID); err != nil {
+            return err
+        }
+        if export.Preferences, err = tx.GetPreferences(userID); err != nil {
+            return err
+        }
+
+        export.AuditTrail, err = tx.AuditTrail("user", userID)
+        return err
+    })
+    if err != nil {
+        return UserDataExport{}, db.opError("export", "user data", userID, err)
+    }
+    return export, nil
+}
+
+// AnonymizeUser стирает персональные данные пользователя, не удаляя строку: рестораны и отзывы
+// продолжают ссылаться на нее. Имя, email и телефон заменяются обезличенными значениями, поля профиля очищаются,
+// пароль - случайным, сессии, токены сброса пароля, настройки и отметка последнего входа удаляются, а из журнала
+// аудита пользователя стираются значения до и после изменений (сами записи о действиях остаются), а из истории
+// версий (см. SetHistory) - все версии, кроме обезличенной
+func (db *Database) AnonymizeUser(userID int) error {
+    err := db.InTx(func(tx *Database) error {
+        user, err := tx.GetUserByID(userID)
+        if err != nil {
+            return err
+        }
+        password, err := tx.newID()
+        if err != nil {
+            return err
+        }
+        user.Name, user.Lastname, user.Phone, user.Password = "Deleted", "User", nil, password
+        user.Email = fmt.Sprintf("deleted-%d@example.invalid", userID)
+        user.AvatarURL, user.Bio, user.Birthdate, user.Locale = nil, nil, nil, nil
+        // случайный пароль никто не вводит, и политика паролей к нему не относится
+        if _, err := tx.withoutPasswordPolicy().UpdateUser(&user); err != nil {
+            return err
+        }
+
+        cleanups := []string{"sessions.delete_by_user", "password_resets.delete_by_user", "user_preferences.delete_by_user", "users.clear_last_active"}
+        for _, name := range cleanups {
+            if _, err := tx.execNamed(name, userID, tx.tenant); err != nil {
+                return err
+            }
+        }
+        if _, err := tx.execNamed("audit_log.scrub_entity", "user", userID, tx.tenant); err != nil {
+            return err
+        }
+        _, err = tx.execNamed("users_history.scrub", userID, tx.tenant)
+        return err
+    })
+    return db.opError("anonymize", "user", userID, err)
+}
+
+// runUserData выгружает данные пользователя в JSON или обезличивает его:
+// user-data export|anonymize -id N
+func runUserData(db *Database, args []string) error {
+    if len(args) == 0 {
+        return fmt.Errorf("usage: user-data export|anonymize -id N")
+    }
+    flags := flag.NewFlagSet("user-data", flag.ContinueOnError)
+    id := flags.Int("id", 0, "user whose data is exported or anonymized")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "export":
+        export, err := db.ExportUserData(*id)
+        if err != nil {
+            return err
+        }
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(export)
+    case "anonymize":
+        if err := db.AnonymizeUser(*id); err != nil {
+            return err
+        }
+        fmt.Printf("user %d anonymized\n", *id)
+        return nil
+    }
+    return fmt.Errorf("usage: user-data export|anonymize -id N")
+}
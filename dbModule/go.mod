@@ -4,4 +4,28 @@ go 1.23.1
 
 require github.com/mattn/go-sqlite3 v1.14.24
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/microsoft/go-mssqldb v1.7.2
+	github.com/sijms/go-ora/v2 v2.8.22
+	golang.org/x/crypto v0.18.0
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/golang-sql/civil v0.0.0-20220223132316-b832511892a9 // indirect
+	github.com/golang-sql/sqlexp v0.1.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
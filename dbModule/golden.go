@@ -0,0 +1,80 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// goldenSnapshotTime - время, на котором стоят часы при сборке эталонной базы (см. SetClock):
+// времена, которые записывает модуль, не зависят от дня сборки, и тесты могут на них полагаться
+var goldenSnapshotTime = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// BuildGoldenSnapshot собирает эталонную базу SQLite для тестов (см. NewDatabaseFromSnapshot):
+// применяет миграции к пустой временной базе, загружает наборы фикстур sets из каталога fixtures
+// и сохраняет результат в path через VACUUM INTO. Прежний файл заменяется только готовым снимком
+func BuildGoldenSnapshot(queries *QueryRegistry, path, fixtures string, sets ...string) error {
+    dir, err := os.MkdirTemp("", "golden")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+
+    db, err := NewDatabaseWithConfig(DefaultConfig(filepath.Join(dir, "golden.db")), queries)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    db.SetClock(NewManualClock(goldenSnapshotTime))
+    db.SetIDGenerator(&SequentialIDs{Prefix: "golden"})
+
+    if err := db.Migrate(); err != nil {
+        return fmt.Errorf("migrate: %w", err)
+    }
+    seeder := NewSeeder(db, fixtures)
+    for _, set := range sets {
+        if err := seeder.Seed(set); err != nil {
+            return fmt.Errorf("seed %s: %w", set, err)
+        }
+    }
+
+    // VACUUM INTO не пишет в существующий файл, поэтому снимок сначала сохраняется рядом
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    os.Remove(tmp)
+    if err := db.Backup(tmp); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// runGolden пересобирает эталонную базу тестов из наборов фикстур
+func runGolden(db *Database, args []string) error {
+    flags := flag.NewFlagSet("golden", flag.ContinueOnError)
+    out := flags.String("out", "testdata/base.db", "golden database file to write")
+    sets := flags.String("sets", *fixtureSetFlag, "comma-separated fixture sets to load")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    queries, err := LoadQueries(*queriesFlag)
+    if err != nil {
+        return err
+    }
+    var names []string
+    for _, set := range strings.Split(*sets, ",") {
+        if set = strings.TrimSpace(set); set != "" {
+            names = append(names, set)
+        }
+    }
+    if err := BuildGoldenSnapshot(queries, *out, *fixturesFlag, names...); err != nil {
+        return err
+    }
+    fmt.Printf("Saved golden database with fixture sets %s to %s\n", strings.Join(names, ", "), *out)
+    return nil
+}
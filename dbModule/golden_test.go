@@ -0,0 +1,60 @@
+package main
+
+import (
+    "testing"
+)
+
+// goldenDatabase - эталонная база тестов, собранная из набора фикстур test:
+//   dbModule golden -sets test -out testdata/base.db
+const goldenDatabase = "testdata/base.db"
+
+// TestDatabaseFromSnapshot проверяет содержимое эталонной базы и то, что тест пишет в ее копию
+func TestDatabaseFromSnapshot(t *testing.T) {
+    db := NewDatabaseFromSnapshot(t, goldenDatabase)
+
+    users, err := db.SelectUsers()
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantUsers := []string{"owner@example.com", "customer@example.com"}
+    if len(users) != len(wantUsers) {
+        t.Fatalf("golden database has %d users, want %d", len(users), len(wantUsers))
+    }
+    for i, want := range wantUsers {
+        if users[i].ID != i+1 || users[i].Email != want {
+            t.Errorf("user %d is %d %q, want %d %q", i, users[i].ID, users[i].Email, i+1, want)
+        }
+    }
+
+    restaurants, err := db.SelectRestaurantsWhere(RestaurantFilter{UserID: &users[0].ID})
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantRestaurants := []string{"Pasta Bar", "Пельменная"}
+    if len(restaurants) != len(wantRestaurants) {
+        t.Fatalf("owner has %d restaurants, want %d", len(restaurants), len(wantRestaurants))
+    }
+    for i, want := range wantRestaurants {
+        if restaurants[i].Name != want {
+            t.Errorf("restaurant %d is %q, want %q", i, restaurants[i].Name, want)
+        }
+    }
+
+    // BuildGoldenSnapshot собирает базу на остановленных часах
+    trail, err := db.AuditTrail("restaurant", restaurants[0].ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(trail) != 1 || !trail[0].CreatedAt.Equal(goldenSnapshotTime) {
+        t.Errorf("restaurant audit trail is %v, want one insert at %v", trail, goldenSnapshotTime)
+    }
+
+    // тест пишет в копию, эталон не меняется
+    if err := db.DeleteUser(users[1].ID, DeleteRestrict); err != nil {
+        t.Fatal(err)
+    }
+    again := NewDatabaseFromSnapshot(t, goldenDatabase)
+    if _, err := again.GetUserByID(users[1].ID); err != nil {
+        t.Errorf("user deleted in a copy is missing from the golden database: %v", err)
+    }
+}
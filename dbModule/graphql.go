@@ -0,0 +1,380 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+    "sync"
+
+    "dbModule/graphql"
+)
+
+// graphQLMaxDepth ограничивает вложенность запросов GraphQL: user.restaurants.owner.restaurants...
+// иначе позволяют выбрать сколько угодно раз одни и те же строки
+const graphQLMaxDepth = 6
+
+// GraphQLSchema возвращает схему GraphQL над пользователями и ресторанами площадки db:
+//   query: user(id), users(role), restaurant(id), restaurants(type, name_prefix, min_price, max_price,
+//     tier, price_from, price_to, user_id, limit, offset); связи User.restaurants и Restaurant.owner
+//   mutation: createUser, updateUser, deleteUser, createRestaurant, updateRestaurant
+// Поля называются так же, как в JSON остального API. Связи загружаются через Loader пачками,
+// поэтому схему нужно создавать на каждый запрос: Loader запоминает прочитанные строки.
+// Пароли пользователей можно передать в мутации, но нельзя прочитать; телефон, дату рождения и язык
+// видят только сам пользователь и пользователи с PermissionManageUsers (см. userViewer). Мутации проверяют права пользователя,
+// вошедшего через -auth, так же, как маршруты REST (см. contextActor)
+func (db *Database) GraphQLSchema() *graphql.Schema {
+    loader := db.NewLoader()
+    viewer := &userViewer{db: db}
+
+    user := &graphql.Object{Name: "User"}
+    restaurant := &graphql.Object{Name: "Restaurant"}
+    user.Fields = map[string]*graphql.Field{
+        "id":         {},
+        "name":       {},
+        "lastname":   {},
+        "email":      {},
+        "phone":      {Description: "null unless the user is the caller or the caller can manage users"},
+        "version":    {},
+        "tenant_id":  {},
+        "role":       {},
+        "avatar_url": {},
+        "bio":        {},
+        "birthdate":  {Description: "null unless the user is the caller or the caller can manage users"},
+        "locale":     {Description: "null unless the user is the caller or the caller can manage users"},
+        "restaurants": {
+            Type:        restaurant,
+            Description: "restaurants owned by the user",
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                return loader.RestaurantsByUser(viewedUserID(p.Source))
+            },
+        },
+    }
+    restaurant.Fields = map[string]*graphql.Field{
+        "id":             {},
+        "name":           {},
+        "type":           {},
+        "keys":           {},
+        "average_price":  {},
+        "user_id":        {},
+        "version":        {},
+        "tenant_id":      {},
+        "price_amount":   {},
+        "price_currency": {},
+        "owner": {
+            Type:        user,
+            Description: "user who owns the restaurant",
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                owner, err := loader.User(p.Source.(Restaurant).UserID)
+                return viewer.optional(p.Context, owner, err)
+            },
+        },
+    }
+
+    query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+        "user": {
+            Type: user,
+            Args: []graphql.Arg{{Name: "id", Type: "Int!"}},
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                found, err := loader.User(p.Args["id"].(int))
+                return viewer.optional(p.Context, found, err)
+            },
+        },
+        "users": {
+            Type: user,
+            Args: []graphql.Arg{{Name: "role", Type: "String"}},
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                var users []User
+                var err error
+                if role, ok := p.Args["role"].(string); ok {
+                    users, err = db.SelectUsersByRole(role)
+                } else {
+                    users, err = db.SelectUsers()
+                }
+                if err != nil {
+                    return nil, err
+                }
+                safe := make([]interface{}, len(users))
+                for i, u := range users {
+                    if safe[i], err = viewer.view(p.Context, u); err != nil {
+                        return nil, err
+                    }
+                }
+                return safe, nil
+            },
+        },
+        "restaurant": {
+            Type: restaurant,
+            Args: []graphql.Arg{{Name: "id", Type: "Int!"}},
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                found, err := loader.Restaurant(p.Args["id"].(int))
+                if errors.Is(err, ErrNotFound) {
+                    return nil, nil
+                }
+                return found, err
+            },
+        },
+        "restaurants": {
+            Type: restaurant,
+            Args: []graphql.Arg{
+                {Name: "type", Type: "String"},
+                {Name: "name_prefix", Type: "String"},
+                {Name: "min_price", Type: "Int"},
+                {Name: "max_price", Type: "Int"},
+                {Name: "tier", Type: "String"},
+                {Name: "price_from", Type: "String"},
+                {Name: "price_to", Type: "String"},
+                {Name: "user_id", Type: "Int"},
+                {Name: "limit", Type: "Int"},
+                {Name: "offset", Type: "Int"},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                var filter RestaurantFilter
+                filter.Type, _ = p.Args["type"].(string)
+                filter.NamePrefix, _ = p.Args["name_prefix"].(string)
+                filter.MinPrice = tierArg(p.Args, "min_price")
+                filter.MaxPrice = tierArg(p.Args, "max_price")
+                filter.UserID = intArg(p.Args, "user_id")
+                for _, key := range []string{"tier", "price_from", "price_to"} {
+                    if value, ok := p.Args[key].(string); ok {
+                        if err := filter.set(key, value); err != nil {
+                            return nil, &ValidationError{Field: key, Message: err.Error()}
+                        }
+                    }
+                }
+                filter.Limit, _ = p.Args["limit"].(int)
+                filter.Offset, _ = p.Args["offset"].(int)
+                restaurants, err := db.SelectRestaurantsWhere(filter)
+                if restaurants == nil && err == nil {
+                    restaurants = []Restaurant{}
+                }
+                return restaurants, err
+            },
+        },
+    }}
+
+    mutation := &graphql.Object{Name: "Mutation", Fields: map[string]*graphql.Field{
+        "createUser": {
+            Type: user,
+            Args: []graphql.Arg{
+                {Name: "name", Type: "String!"},
+                {Name: "lastname", Type: "String!"},
+                {Name: "email", Type: "String!"},
+                {Name: "password", Type: "String!"},
+                {Name: "phone", Type: "String"},
+                {Name: "role", Type: "String"},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                u := User{Name: p.Args["name"].(string), Lastname: p.Args["lastname"].(string), Email: p.Args["email"].(string), Password: p.Args["password"].(string)}
+                if phone, ok := p.Args["phone"].(string); ok {
+                    u.Phone = &phone
+                }
+                actor, _ := contextActor(p.Context)
+                role, _ := p.Args["role"].(string)
+                role, err := db.grantableRole(actor, role, RoleCustomer)
+                if err != nil {
+                    return nil, err
+                }
+                u.Role = role
+                id, err := db.insertUser(u)
+                if err != nil {
+                    return nil, err
+                }
+                created, err := db.GetUserByID(int(id))
+                if err != nil {
+                    return nil, err
+                }
+                return viewer.view(p.Context, created)
+            },
+        },
+        "updateUser": {
+            Type: user,
+            Args: []graphql.Arg{
+                {Name: "id", Type: "Int!"},
+                {Name: "name", Type: "String"},
+                {Name: "lastname", Type: "String"},
+                {Name: "email", Type: "String"},
+                {Name: "password", Type: "String"},
+                {Name: "phone", Type: "String"},
+                {Name: "role", Type: "String"},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                actor, _ := contextActor(p.Context)
+                fields := patchArgs(p.Args)
+                if err := db.authorizeUserFields(actor, p.Args["id"].(int), fields); err != nil {
+                    return nil, err
+                }
+                updated, err := db.UpdateUserFields(p.Args["id"].(int), fields)
+                if err != nil {
+                    return nil, err
+                }
+                return viewer.view(p.Context, *updated)
+            },
+        },
+        "deleteUser": {
+            Args:        []graphql.Arg{{Name: "id", Type: "Int!"}, {Name: "cascade", Type: "Boolean"}},
+            Description: "true once deleted; without cascade a user who owns restaurants is not deleted",
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                policy := DeleteRestrict
+                if cascade, _ := p.Args["cascade"].(bool); cascade {
+                    policy = DeleteCascade
+                }
+                actor, _ := contextActor(p.Context)
+                if err := db.authorizeUser(actor, p.Args["id"].(int)); err != nil {
+                    return nil, err
+                }
+                if err := db.DeleteUser(p.Args["id"].(int), policy); err != nil {
+                    return nil, err
+                }
+                return true, nil
+            },
+        },
+        "createRestaurant": {
+            Type: restaurant,
+            Args: []graphql.Arg{
+                {Name: "name", Type: "String!"},
+                {Name: "type", Type: "String!"},
+                {Name: "keys", Type: "String"},
+                {Name: "average_price", Type: "Int!"},
+                {Name: "user_id", Type: "Int!"},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                r := Restaurant{Name: p.Args["name"].(string), Type: p.Args["type"].(string), AveragePrice: PriceTier(p.Args["average_price"].(int)), UserID: p.Args["user_id"].(int)}
+                if keys, ok := p.Args["keys"].(string); ok {
+                    r.Keys = &keys
+                }
+                actor, _ := contextActor(p.Context)
+                if err := db.authorizeNewRestaurant(actor, r); err != nil {
+                    return nil, err
+                }
+                id, err := db.insertRestaurant(r)
+                if err != nil {
+                    return nil, err
+                }
+                return db.GetRestaurantByID(int(id))
+            },
+        },
+        "updateRestaurant": {
+            Type: restaurant,
+            Args: []graphql.Arg{
+                {Name: "id", Type: "Int!"},
+                {Name: "name", Type: "String"},
+                {Name: "type", Type: "String"},
+                {Name: "keys", Type: "String"},
+                {Name: "average_price", Type: "Int"},
+                {Name: "user_id", Type: "Int"},
+                {Name: "price_amount", Type: "Int"},
+                {Name: "price_currency", Type: "String"},
+            },
+            Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                actor, _ := contextActor(p.Context)
+                updated, err := db.UpdateRestaurantFields(actor, p.Args["id"].(int), patchArgs(p.Args))
+                if err != nil {
+                    return nil, err
+                }
+                return *updated, nil
+            },
+        },
+    }}
+
+    return &graphql.Schema{Query: query, Mutation: mutation, MaxDepth: graphQLMaxDepth, ErrorMessage: graphQLErrorMessage}
+}
+
+// serveGraphQL выполняет запрос GraphQL по схеме копии Database этого HTTP-запроса
+func (db *Database) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+    graphql.Handler(db.GraphQLSchema()).ServeHTTP(w, r)
+}
+
+// graphQLErrorMessage скрывает подробности внутренних ошибок, как writeError
+func graphQLErrorMessage(err error) string {
+    if errorStatus(err) == http.StatusInternalServerError {
+        log.Printf("graphql: %v", err)
+        return http.StatusText(http.StatusInternalServerError)
+    }
+    return err.Error()
+}
+
+// userViewer выбирает представление пользователя для того, кто выполняет запрос GraphQL: AdminUser -
+// для самого пользователя и для вошедшего с PermissionManageUsers, иначе PublicUser. Анонимный запрос
+// видит PublicUser, хотя contextActor возвращает для него SystemActor: без -auth GraphQL открыт всем.
+// Права вошедшего читаются один раз на схему, то есть на запрос
+type userViewer struct {
+    db     *Database
+    once   sync.Once
+    manage bool
+    err    error
+}
+
+// view возвращает u в представлении для пользователя запроса ctx
+func (v *userViewer) view(ctx context.Context, u User) (interface{}, error) {
+    actor, ok := contextActor(ctx)
+    if !ok {
+        return u.Public(), nil
+    }
+    if actor == u.ID {
+        return u.Admin(), nil
+    }
+    v.once.Do(func() {
+        err := v.db.authorizePermission(actor, PermissionManageUsers)
+        v.manage = err == nil
+        if errors.Is(err, ErrPermissionDenied) {
+            err = nil
+        }
+        v.err = err
+    })
+    if v.err != nil {
+        return nil, v.err
+    }
+    if v.manage {
+        return u.Admin(), nil
+    }
+    return u.Public(), nil
+}
+
+// optional превращает найденного пользователя в значение поля, как view, а отсутствующего - в null
+func (v *userViewer) optional(ctx context.Context, u User, err error) (interface{}, error) {
+    if errors.Is(err, ErrNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return v.view(ctx, u)
+}
+
+// viewedUserID возвращает ID пользователя из значения, которое вернул userViewer
+func viewedUserID(source interface{}) int {
+    if u, ok := source.(AdminUser); ok {
+        return u.ID
+    }
+    return source.(PublicUser).ID
+}
+
+// intArg возвращает аргумент Int или nil, если он не передан
+func intArg(args map[string]interface{}, name string) *int {
+    if n, ok := args[name].(int); ok {
+        return &n
+    }
+    return nil
+}
+
+// tierArg возвращает уровень цен из аргумента Int или nil, если он не передан
+func tierArg(args map[string]interface{}, name string) *PriceTier {
+    if n, ok := args[name].(int); ok {
+        tier := PriceTier(n)
+        return &tier
+    }
+    return nil
+}
+
+// patchArgs возвращает аргументы мутации без id для UpdateUserFields и UpdateRestaurantFields:
+// переданный null очищает поле
+func patchArgs(args map[string]interface{}) map[string]interface{} {
+    fields := make(map[string]interface{}, len(args))
+    for name, value := range args {
+        if name != "id" {
+            fields[name] = value
+        }
+    }
+    return fields
+}
@@ -0,0 +1,738 @@
+// Package graphql выполняет запросы GraphQL по схеме из объектов с функциями-резолверами.
+// Поддерживаются query и mutation с переменными, псевдонимами, фрагментами и директивами
+// @skip и @include. Интроспекции и подписок нет: схему описывает вызывающий код (см. Schema).
+// Поля одного уровня и элементы списков в query разрешаются параллельно, чтобы резолверы
+// могли собирать выборки в пачки; поля mutation верхнего уровня выполняются по порядку
+package graphql
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Schema - корневые типы запросов
+type Schema struct {
+    Query    *Object
+    Mutation *Object
+    // MaxDepth ограничивает вложенность выборки, чтобы циклические связи типов
+    // не раздували ответ; 0 - без ограничения
+    MaxDepth int
+    // ErrorMessage возвращает текст ошибки резолвера для ответа; nil - err.Error()
+    ErrorMessage func(err error) string
+}
+
+// Object - тип объекта: имя и поля
+type Object struct {
+    Name   string
+    Fields map[string]*Field
+}
+
+// Field - поле объекта
+type Field struct {
+    // Type - тип объекта значения, в том числе элементов списка; nil - скаляр, который пишется в ответ
+    // как есть (json.Marshal), и выбирать его поля нельзя
+    Type        *Object
+    Args        []Arg
+    // Resolve возвращает значение поля; nil - поле источника с тем же именем
+    // (ключ map[string]interface{} или поле структуры с таким тегом json)
+    Resolve     func(p ResolveParams) (interface{}, error)
+    // Description - описание поля для документации схемы
+    Description string
+}
+
+// Arg - аргумент поля. Type - тип в записи GraphQL: Int, Float, String, Boolean, ID, их списки,
+// ! - обязательный; значения другого типа передаются резолверу без преобразования
+type Arg struct {
+    Name string
+    Type string
+}
+
+// ResolveParams - данные для резолвера
+type ResolveParams struct {
+    Context context.Context
+    // Source - значение объекта, которому принадлежит поле; для корневых полей nil
+    Source interface{}
+    // Args - переданные аргументы, приведенные к типам Arg: int, float64, string, bool, nil, []interface{};
+    // отсутствующего аргумента нет в map, явный null записан как nil
+    Args map[string]interface{}
+}
+
+// Request - тело запроса GraphQL по HTTP
+type Request struct {
+    Query         string                 `json:"query"`
+    OperationName string                 `json:"operationName,omitempty"`
+    Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response - результат выполнения: данные и ошибки. Если запрос не разобран или не прошел
+// проверку по схеме, Data отсутствует
+type Response struct {
+    Data   interface{} `json:"data,omitempty"`
+    Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error - ошибка запроса или поля
+type Error struct {
+    Message   string     `json:"message"`
+    Locations []Location `json:"locations,omitempty"`
+    // Path - путь до поля в ответе: ключи и номера элементов списков
+    Path []interface{} `json:"path,omitempty"`
+    // Err - исходная ошибка резолвера
+    Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+func (e *Error) Unwrap() error {
+    return e.Err
+}
+
+// Location - строка и колонка в тексте запроса, начиная с 1
+type Location struct {
+    Line   int `json:"line"`
+    Column int `json:"column"`
+}
+
+// Execute разбирает, проверяет по схеме и выполняет запрос
+func Execute(ctx context.Context, schema *Schema, request Request) *Response {
+    doc, err := parse(request.Query)
+    if err != nil {
+        return errorResponse(err)
+    }
+    op, err := doc.operation(request.OperationName)
+    if err != nil {
+        return errorResponse(err)
+    }
+    v := &validator{schema: schema, doc: doc, variables: map[string]string{}}
+    root, err := v.operation(op)
+    if err != nil {
+        return errorResponse(err)
+    }
+    variables, err := coerceVariables(op, request.Variables)
+    if err != nil {
+        return errorResponse(err)
+    }
+
+    e := &executor{ctx: ctx, schema: schema, doc: doc, variables: variables, parallel: op.kind == "query"}
+    data := e.selectionSet(root, nil, op.selections, nil)
+    return &Response{Data: data, Errors: e.errors}
+}
+
+// errorResponse - ответ без данных с одной ошибкой
+func errorResponse(err error) *Response {
+    var graphqlErr *Error
+    if !errors.As(err, &graphqlErr) {
+        graphqlErr = &Error{Message: err.Error(), Err: err}
+    }
+    return &Response{Errors: []*Error{graphqlErr}}
+}
+
+// operation выбирает выполняемую операцию по имени; без имени - единственную
+func (d *document) operation(name string) (*operation, error) {
+    if name == "" {
+        if len(d.operations) > 1 {
+            return nil, &Error{Message: "operationName is required when the document has several operations"}
+        }
+        return d.operations[0], nil
+    }
+    for _, op := range d.operations {
+        if op.name == name {
+            return op, nil
+        }
+    }
+    return nil, &Error{Message: fmt.Sprintf("unknown operation %q", name)}
+}
+
+// validator проверяет операцию по схеме до выполнения: поля, аргументы, выборки и фрагменты
+type validator struct {
+    schema    *Schema
+    doc       *document
+    variables map[string]string
+    // spreads - фрагменты на текущем пути, чтобы найти циклы
+    spreads   []string
+}
+
+// operation проверяет операцию и возвращает ее корневой тип
+func (v *validator) operation(op *operation) (*Object, error) {
+    var root *Object
+    switch op.kind {
+    case "query":
+        root = v.schema.Query
+    case "mutation":
+        root = v.schema.Mutation
+    }
+    if root == nil {
+        return nil, &Error{Message: fmt.Sprintf("%s operations are not supported", op.kind), Locations: []Location{op.loc}}
+    }
+    for _, definition := range op.variables {
+        if _, ok := v.variables[definition.name]; ok {
+            return nil, &Error{Message: fmt.Sprintf("variable $%s is declared more than once", definition.name), Locations: []Location{op.loc}}
+        }
+        v.variables[definition.name] = definition.typ
+    }
+    return root, v.selections(root, op.selections, 1)
+}
+
+// selections проверяет выборку полей объекта на глубине depth
+func (v *validator) selections(object *Object, selections []selection, depth int) error {
+    if v.schema.MaxDepth > 0 && depth > v.schema.MaxDepth {
+        return &Error{Message: fmt.Sprintf("selection is nested deeper than %d levels", v.schema.MaxDepth), Locations: []Location{selections[0].loc}}
+    }
+    for _, s := range selections {
+        if err := v.directives(s.directives); err != nil {
+            return err
+        }
+        switch {
+        case s.field != nil:
+            if err := v.field(object, s, depth); err != nil {
+                return err
+            }
+        case s.spread != "":
+            f, ok := v.doc.fragments[s.spread]
+            if !ok {
+                return &Error{Message: fmt.Sprintf("unknown fragment %s", s.spread), Locations: []Location{s.loc}}
+            }
+            for _, name := range v.spreads {
+                if name == s.spread {
+                    return &Error{Message: fmt.Sprintf("fragment %s spreads itself", s.spread), Locations: []Location{s.loc}}
+                }
+            }
+            if f.on != object.Name {
+                return &Error{Message: fmt.Sprintf("fragment %s on %s cannot be spread in %s", f.name, f.on, object.Name), Locations: []Location{s.loc}}
+            }
+            v.spreads = append(v.spreads, s.spread)
+            err := v.selections(object, f.selections, depth)
+            v.spreads = v.spreads[:len(v.spreads)-1]
+            if err != nil {
+                return err
+            }
+        default:
+            if s.inlineOn != "" && s.inlineOn != object.Name {
+                return &Error{Message: fmt.Sprintf("fragment on %s cannot be spread in %s", s.inlineOn, object.Name), Locations: []Location{s.loc}}
+            }
+            if err := v.selections(object, s.inline, depth); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// field проверяет одно поле: что оно есть в типе, его аргументы и выборку
+func (v *validator) field(object *Object, s selection, depth int) error {
+    node := s.field
+    if node.name == "__typename" {
+        if node.selections != nil || node.arguments != nil {
+            return &Error{Message: "__typename has no arguments or fields", Locations: []Location{s.loc}}
+        }
+        return nil
+    }
+    field, ok := object.Fields[node.name]
+    if !ok {
+        return &Error{Message: fmt.Sprintf("cannot query field %q on type %s", node.name, object.Name), Locations: []Location{s.loc}}
+    }
+    if err := v.arguments(field.Args, node.arguments, fmt.Sprintf("%s.%s", object.Name, node.name), s.loc); err != nil {
+        return err
+    }
+    switch {
+    case field.Type == nil && node.selections != nil:
+        return &Error{Message: fmt.Sprintf("field %s.%s is a scalar and has no fields", object.Name, node.name), Locations: []Location{s.loc}}
+    case field.Type != nil && node.selections == nil:
+        return &Error{Message: fmt.Sprintf("field %s.%s of type %s needs a selection of fields", object.Name, node.name, field.Type.Name), Locations: []Location{s.loc}}
+    case field.Type != nil:
+        return v.selections(field.Type, node.selections, depth+1)
+    }
+    return nil
+}
+
+// arguments проверяет, что аргументы объявлены, обязательные переданы, а литералы и переменные
+// подходят по типу
+func (v *validator) arguments(declared []Arg, arguments []argument, owner string, loc Location) error {
+    types := make(map[string]string, len(declared))
+    for _, arg := range declared {
+        types[arg.Name] = arg.Type
+    }
+    given := make(map[string]bool, len(arguments))
+    for _, arg := range arguments {
+        typ, ok := types[arg.name]
+        if !ok {
+            return &Error{Message: fmt.Sprintf("unknown argument %s of %s", arg.name, owner), Locations: []Location{arg.loc}}
+        }
+        if given[arg.name] {
+            return &Error{Message: fmt.Sprintf("argument %s of %s is given more than once", arg.name, owner), Locations: []Location{arg.loc}}
+        }
+        given[arg.name] = true
+        if err := v.value(arg.value, typ); err != nil {
+            return &Error{Message: fmt.Sprintf("argument %s of %s: %v", arg.name, owner, err), Locations: []Location{arg.loc}}
+        }
+    }
+    for _, arg := range declared {
+        if strings.HasSuffix(arg.Type, "!") && !given[arg.Name] {
+            return &Error{Message: fmt.Sprintf("argument %s of %s is required", arg.Name, owner), Locations: []Location{loc}}
+        }
+    }
+    return nil
+}
+
+// value проверяет значение аргумента: переменная должна быть объявлена, литерал - приводиться к типу
+func (v *validator) value(value inputValue, typ string) error {
+    switch value := value.(type) {
+    case variableRef:
+        declared, ok := v.variables[string(value)]
+        if !ok {
+            return fmt.Errorf("variable $%s is not declared", value)
+        }
+        if strings.TrimSuffix(declared, "!") != strings.TrimSuffix(typ, "!") || strings.HasSuffix(typ, "!") && !strings.HasSuffix(declared, "!") {
+            return fmt.Errorf("variable $%s of type %s cannot be used as %s", value, declared, typ)
+        }
+        return nil
+    case []inputValue:
+        for _, item := range value {
+            if inner, ok := listItemType(typ); ok {
+                if err := v.value(item, inner); err != nil {
+                    return err
+                }
+            }
+        }
+    case objectValue:
+        for _, field := range value {
+            if err := v.value(field.value, ""); err != nil {
+                return err
+            }
+        }
+    }
+    if containsVariable(value) {
+        return nil
+    }
+    _, err := coerce(literal(value, nil), typ)
+    return err
+}
+
+// directives проверяет @skip и @include
+func (v *validator) directives(directives []directive) error {
+    for _, d := range directives {
+        if d.name != "skip" && d.name != "include" {
+            return &Error{Message: fmt.Sprintf("unknown directive @%s", d.name), Locations: []Location{d.loc}}
+        }
+        if err := v.arguments([]Arg{{Name: "if", Type: "Boolean!"}}, d.arguments, "@"+d.name, d.loc); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// containsVariable сообщает, что в значении есть переменные
+func containsVariable(value inputValue) bool {
+    switch value := value.(type) {
+    case variableRef:
+        return true
+    case []inputValue:
+        for _, item := range value {
+            if containsVariable(item) {
+                return true
+            }
+        }
+    case objectValue:
+        for _, field := range value {
+            if containsVariable(field.value) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// coerceVariables приводит переданные переменные к объявленным типам и подставляет значения по умолчанию
+func coerceVariables(op *operation, given map[string]interface{}) (map[string]interface{}, error) {
+    variables := make(map[string]interface{}, len(op.variables))
+    for _, definition := range op.variables {
+        raw, ok := given[definition.name]
+        if !ok {
+            if definition.hasDefault {
+                raw, ok = literal(definition.defaultValue, nil), true
+            } else if strings.HasSuffix(definition.typ, "!") {
+                return nil, &Error{Message: fmt.Sprintf("variable $%s of type %s is required", definition.name, definition.typ), Locations: []Location{op.loc}}
+            }
+        }
+        if !ok {
+            continue
+        }
+        value, err := coerce(raw, definition.typ)
+        if err != nil {
+            return nil, &Error{Message: fmt.Sprintf("variable $%s: %v", definition.name, err), Locations: []Location{op.loc}}
+        }
+        variables[definition.name] = value
+    }
+    return variables, nil
+}
+
+// literal переводит значение из текста запроса в значение Go, подставляя переменные
+func literal(value inputValue, variables map[string]interface{}) interface{} {
+    switch value := value.(type) {
+    case variableRef:
+        return variables[string(value)]
+    case enumValue:
+        return string(value)
+    case []inputValue:
+        list := make([]interface{}, len(value))
+        for i, item := range value {
+            list[i] = literal(item, variables)
+        }
+        return list
+    case objectValue:
+        object := make(map[string]interface{}, len(value))
+        for _, field := range value {
+            object[field.name] = literal(field.value, variables)
+        }
+        return object
+    }
+    return value
+}
+
+// listItemType возвращает тип элементов списочного типа [T]
+func listItemType(typ string) (string, bool) {
+    typ = strings.TrimSuffix(typ, "!")
+    if strings.HasPrefix(typ, "[") && strings.HasSuffix(typ, "]") {
+        return typ[1 : len(typ)-1], true
+    }
+    return "", false
+}
+
+// coerce приводит значение к типу GraphQL typ
+func coerce(value interface{}, typ string) (interface{}, error) {
+    if value == nil {
+        if strings.HasSuffix(typ, "!") {
+            return nil, fmt.Errorf("expected %s, got null", typ)
+        }
+        return nil, nil
+    }
+    if inner, ok := listItemType(typ); ok {
+        items, isList := value.([]interface{})
+        if !isList {
+            items = []interface{}{value}
+        }
+        list := make([]interface{}, len(items))
+        for i, item := range items {
+            coerced, err := coerce(item, inner)
+            if err != nil {
+                return nil, err
+            }
+            list[i] = coerced
+        }
+        return list, nil
+    }
+
+    switch base := strings.TrimSuffix(typ, "!"); base {
+    case "Int":
+        switch n := value.(type) {
+        case int:
+            return n, nil
+        case float64:
+            if n == float64(int(n)) {
+                return int(n), nil
+            }
+        case json.Number:
+            if i, err := strconv.Atoi(n.String()); err == nil {
+                return i, nil
+            }
+        }
+    case "Float":
+        switch n := value.(type) {
+        case int:
+            return float64(n), nil
+        case float64:
+            return n, nil
+        case json.Number:
+            if f, err := n.Float64(); err == nil {
+                return f, nil
+            }
+        }
+    case "String":
+        if s, ok := value.(string); ok {
+            return s, nil
+        }
+    case "ID":
+        switch id := value.(type) {
+        case string:
+            return id, nil
+        case int:
+            return strconv.Itoa(id), nil
+        case json.Number:
+            if _, err := strconv.Atoi(id.String()); err == nil {
+                return id.String(), nil
+            }
+        }
+    case "Boolean":
+        if b, ok := value.(bool); ok {
+            return b, nil
+        }
+    default:
+        return value, nil
+    }
+    return nil, fmt.Errorf("expected %s, got %s", typ, describeValue(value))
+}
+
+// describeValue называет значение для сообщения об ошибке
+func describeValue(value interface{}) string {
+    switch value := value.(type) {
+    case string:
+        return strconv.Quote(value)
+    case []interface{}:
+        return "a list"
+    case map[string]interface{}:
+        return "an object"
+    }
+    return fmt.Sprint(value)
+}
+
+// executor выполняет проверенную операцию
+type executor struct {
+    ctx       context.Context
+    schema    *Schema
+    doc       *document
+    variables map[string]interface{}
+    // parallel - поля и элементы списков разрешаются параллельно (query)
+    parallel  bool
+
+    mu     sync.Mutex
+    errors []*Error
+}
+
+// collectedField - поле ответа со всеми его вхождениями в выборку
+type collectedField struct {
+    key   string
+    nodes []*fieldNode
+    loc   Location
+}
+
+// collect раскрывает фрагменты, применяет @skip/@include и объединяет поля с одним ключом ответа
+func (e *executor) collect(selections []selection, fields []*collectedField, index map[string]*collectedField) []*collectedField {
+    for _, s := range selections {
+        if !e.included(s.directives) {
+            continue
+        }
+        switch {
+        case s.field != nil:
+            key := s.field.key()
+            if field, ok := index[key]; ok {
+                field.nodes = append(field.nodes, s.field)
+                continue
+            }
+            field := &collectedField{key: key, nodes: []*fieldNode{s.field}, loc: s.loc}
+            index[key] = field
+            fields = append(fields, field)
+        case s.spread != "":
+            fields = e.collect(e.doc.fragments[s.spread].selections, fields, index)
+        default:
+            fields = e.collect(s.inline, fields, index)
+        }
+    }
+    return fields
+}
+
+// included вычисляет директивы @skip(if:) и @include(if:)
+func (e *executor) included(directives []directive) bool {
+    for _, d := range directives {
+        condition, _ := literal(d.arguments[0].value, e.variables).(bool)
+        if d.name == "skip" && condition || d.name == "include" && !condition {
+            return false
+        }
+    }
+    return true
+}
+
+// selectionSet разрешает поля объекта source типа object
+func (e *executor) selectionSet(object *Object, source interface{}, selections []selection, path []interface{}) orderedObject {
+    fields := e.collect(selections, nil, map[string]*collectedField{})
+    result := make(orderedObject, len(fields))
+    resolve := func(i int) {
+        field := fields[i]
+        result[i] = orderedField{key: field.key, value: e.field(object, source, field, appendPath(path, field.key))}
+    }
+    if !e.parallel || len(fields) < 2 {
+        for i := range fields {
+            resolve(i)
+        }
+        return result
+    }
+    var wg sync.WaitGroup
+    for i := range fields {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            resolve(i)
+        }()
+    }
+    wg.Wait()
+    return result
+}
+
+// field разрешает одно поле и его выборку; при ошибке поле равно null, а ошибка попадает в ответ
+func (e *executor) field(object *Object, source interface{}, collected *collectedField, path []interface{}) interface{} {
+    node := collected.nodes[0]
+    if node.name == "__typename" {
+        return object.Name
+    }
+    field := object.Fields[node.name]
+    args := make(map[string]interface{}, len(node.arguments))
+    for _, arg := range node.arguments {
+        if ref, ok := arg.value.(variableRef); ok {
+            if _, given := e.variables[string(ref)]; !given {
+                continue
+            }
+        }
+        for _, declared := range field.Args {
+            if declared.Name == arg.name {
+                args[arg.name], _ = coerce(literal(arg.value, e.variables), declared.Type)
+            }
+        }
+    }
+
+    value, err := e.resolve(field, ResolveParams{Context: e.ctx, Source: source, Args: args}, node.name)
+    if err != nil {
+        e.fail(err, collected.loc, path)
+        return nil
+    }
+    if field.Type == nil || isNil(value) {
+        return value
+    }
+
+    var selections []selection
+    for _, n := range collected.nodes {
+        selections = append(selections, n.selections...)
+    }
+    list := reflect.ValueOf(value)
+    if list.Kind() != reflect.Slice {
+        return e.selectionSet(field.Type, value, selections, path)
+    }
+    items := make([]interface{}, list.Len())
+    complete := func(i int) {
+        item := list.Index(i).Interface()
+        if !isNil(item) {
+            items[i] = e.selectionSet(field.Type, item, selections, appendPath(path, i))
+        }
+    }
+    if !e.parallel {
+        for i := range items {
+            complete(i)
+        }
+        return items
+    }
+    var wg sync.WaitGroup
+    for i := range items {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            complete(i)
+        }()
+    }
+    wg.Wait()
+    return items
+}
+
+// resolve вызывает резолвер поля, превращая панику в ошибку поля
+func (e *executor) resolve(field *Field, params ResolveParams, name string) (value interface{}, err error) {
+    if err := params.Context.Err(); err != nil {
+        return nil, err
+    }
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("panic in resolver of %s: %v", name, r)
+        }
+    }()
+    if field.Resolve == nil {
+        return defaultResolve(params.Source, name), nil
+    }
+    return field.Resolve(params)
+}
+
+// fail добавляет ошибку поля в ответ
+func (e *executor) fail(err error, loc Location, path []interface{}) {
+    message := err.Error()
+    if e.schema.ErrorMessage != nil {
+        message = e.schema.ErrorMessage(err)
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.errors = append(e.errors, &Error{Message: message, Locations: []Location{loc}, Path: path, Err: err})
+}
+
+// defaultResolve возвращает ключ name из map или поле структуры с тегом json name
+func defaultResolve(source interface{}, name string) interface{} {
+    if object, ok := source.(map[string]interface{}); ok {
+        return object[name]
+    }
+    value := reflect.ValueOf(source)
+    for value.Kind() == reflect.Pointer && !value.IsNil() {
+        value = value.Elem()
+    }
+    if value.Kind() != reflect.Struct {
+        return nil
+    }
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+        tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        if tag == name || tag == "" && field.Name == name {
+            return value.Field(i).Interface()
+        }
+    }
+    return nil
+}
+
+// isNil сообщает, что значение - nil или nil-указатель, срез или map
+func isNil(value interface{}) bool {
+    if value == nil {
+        return true
+    }
+    switch v := reflect.ValueOf(value); v.Kind() {
+    case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+        return v.IsNil()
+    }
+    return false
+}
+
+// appendPath возвращает путь с добавленным элементом, не меняя исходный
+func appendPath(path []interface{}, item interface{}) []interface{} {
+    extended := make([]interface{}, len(path), len(path)+1)
+    copy(extended, path)
+    return append(extended, item)
+}
+
+// orderedObject - объект ответа с полями в порядке выборки
+type orderedObject []orderedField
+
+type orderedField struct {
+    key   string
+    value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+    var b strings.Builder
+    b.WriteByte('{')
+    for i, field := range o {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        key, err := json.Marshal(field.key)
+        if err != nil {
+            return nil, err
+        }
+        value, err := json.Marshal(field.value)
+        if err != nil {
+            return nil, err
+        }
+        b.Write(key)
+        b.WriteByte(':')
+        b.Write(value)
+    }
+    b.WriteByte('}')
+    return []byte(b.String()), nil
+}
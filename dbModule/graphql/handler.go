@@ -0,0 +1,75 @@
+package graphql
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+)
+
+// MaxRequestBytes ограничивает размер тела запроса Handler
+const MaxRequestBytes = 1 << 20
+
+// Handler обслуживает запросы GraphQL по HTTP: POST с телом Request в JSON или GET
+// с параметрами query, operationName и variables (JSON); mutation принимается только в POST.
+// Ответ - Response в JSON с кодом 200, если операция выполнялась, иначе 400
+func Handler(schema *Schema) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var request Request
+        switch r.Method {
+        case http.MethodPost:
+            decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBytes))
+            decoder.UseNumber()
+            if err := decoder.Decode(&request); err != nil {
+                writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "invalid request body: " + err.Error()}}})
+                return
+            }
+        case http.MethodGet:
+            query := r.URL.Query()
+            request.Query, request.OperationName = query.Get("query"), query.Get("operationName")
+            if variables := query.Get("variables"); variables != "" {
+                decoder := json.NewDecoder(strings.NewReader(variables))
+                decoder.UseNumber()
+                if err := decoder.Decode(&request.Variables); err != nil {
+                    writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "invalid variables: " + err.Error()}}})
+                    return
+                }
+            }
+            if isMutation(request) {
+                w.Header().Set("Allow", http.MethodPost)
+                writeResponse(w, http.StatusMethodNotAllowed, &Response{Errors: []*Error{{Message: "mutations must be sent with POST"}}})
+                return
+            }
+        default:
+            w.Header().Set("Allow", "GET, POST")
+            writeResponse(w, http.StatusMethodNotAllowed, &Response{Errors: []*Error{{Message: "method not allowed"}}})
+            return
+        }
+
+        response := Execute(r.Context(), schema, request)
+        status := http.StatusOK
+        if response.Data == nil {
+            status = http.StatusBadRequest
+        }
+        writeResponse(w, status, response)
+    })
+}
+
+// isMutation сообщает, что выполняемая операция запроса - mutation
+func isMutation(request Request) bool {
+    doc, err := parse(request.Query)
+    if err != nil {
+        return false
+    }
+    op, err := doc.operation(request.OperationName)
+    return err == nil && op.kind == "mutation"
+}
+
+// writeResponse отвечает результатом в JSON
+func writeResponse(w http.ResponseWriter, status int, response *Response) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        log.Printf("graphql: write response: %v", err)
+    }
+}
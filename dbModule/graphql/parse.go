@@ -0,0 +1,745 @@
+package graphql
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// tokenKind - вид лексемы запроса
+type tokenKind int
+
+const (
+    tokenEOF tokenKind = iota
+    tokenPunct
+    tokenName
+    tokenInt
+    tokenFloat
+    tokenString
+)
+
+// token - лексема с позицией начала для сообщений об ошибках
+type token struct {
+    kind  tokenKind
+    value string
+    loc   Location
+}
+
+// lexer разбивает текст запроса на лексемы; запятые и комментарии # пропускаются
+type lexer struct {
+    src  string
+    pos  int
+    line int
+    col  int
+}
+
+// syntaxError - ошибка разбора запроса с позицией
+func syntaxError(loc Location, format string, args ...interface{}) *Error {
+    return &Error{Message: "syntax error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// advance сдвигает позицию на n байт, считая строки и колонки
+func (l *lexer) advance(n int) {
+    for _, r := range l.src[l.pos : l.pos+n] {
+        if r == '\n' {
+            l.line++
+            l.col = 1
+        } else {
+            l.col++
+        }
+    }
+    l.pos += n
+}
+
+// skipIgnored пропускает пробелы, запятые, BOM и комментарии
+func (l *lexer) skipIgnored() {
+    for l.pos < len(l.src) {
+        switch c := l.src[l.pos]; {
+        case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+            l.advance(1)
+        case c == '#':
+            end := strings.IndexByte(l.src[l.pos:], '\n')
+            if end < 0 {
+                end = len(l.src) - l.pos
+            }
+            l.advance(end)
+        case strings.HasPrefix(l.src[l.pos:], "\ufeff"):
+            l.advance(len("\ufeff"))
+        default:
+            return
+        }
+    }
+}
+
+// next возвращает следующую лексему
+func (l *lexer) next() (token, error) {
+    l.skipIgnored()
+    loc := Location{Line: l.line, Column: l.col}
+    if l.pos >= len(l.src) {
+        return token{kind: tokenEOF, loc: loc}, nil
+    }
+    rest := l.src[l.pos:]
+    c := rest[0]
+    switch {
+    case strings.HasPrefix(rest, "..."):
+        l.advance(3)
+        return token{kind: tokenPunct, value: "...", loc: loc}, nil
+    case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+        l.advance(1)
+        return token{kind: tokenPunct, value: string(c), loc: loc}, nil
+    case c == '_' || isLetter(c):
+        n := 1
+        for n < len(rest) && (rest[n] == '_' || isLetter(rest[n]) || isDigit(rest[n])) {
+            n++
+        }
+        l.advance(n)
+        return token{kind: tokenName, value: rest[:n], loc: loc}, nil
+    case c == '-' || isDigit(c):
+        return l.number(loc)
+    case c == '"':
+        return l.string(loc)
+    }
+    r, _ := utf8.DecodeRuneInString(rest)
+    return token{}, syntaxError(loc, "unexpected character %q", r)
+}
+
+// number читает целое или дробное число
+func (l *lexer) number(loc Location) (token, error) {
+    rest := l.src[l.pos:]
+    n := 0
+    if rest[n] == '-' {
+        n++
+    }
+    digits := func() int {
+        start := n
+        for n < len(rest) && isDigit(rest[n]) {
+            n++
+        }
+        return n - start
+    }
+    if digits() == 0 {
+        return token{}, syntaxError(loc, "invalid number")
+    }
+    kind := tokenInt
+    if n < len(rest) && rest[n] == '.' {
+        n++
+        kind = tokenFloat
+        if digits() == 0 {
+            return token{}, syntaxError(loc, "invalid number")
+        }
+    }
+    if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+        n++
+        kind = tokenFloat
+        if n < len(rest) && (rest[n] == '+' || rest[n] == '-') {
+            n++
+        }
+        if digits() == 0 {
+            return token{}, syntaxError(loc, "invalid number")
+        }
+    }
+    if n < len(rest) && (rest[n] == '_' || rest[n] == '.' || isLetter(rest[n])) {
+        return token{}, syntaxError(loc, "invalid number")
+    }
+    l.advance(n)
+    return token{kind: kind, value: rest[:n], loc: loc}, nil
+}
+
+// string читает строку "..." с экранированием или блочную строку """..."""
+func (l *lexer) string(loc Location) (token, error) {
+    rest := l.src[l.pos:]
+    if strings.HasPrefix(rest, `"""`) {
+        end := 0
+        for {
+            i := strings.Index(rest[3+end:], `"""`)
+            if i < 0 {
+                return token{}, syntaxError(loc, "unterminated string")
+            }
+            end += i
+            if rest[3+end-1] != '\\' {
+                break
+            }
+            end += 3
+        }
+        l.advance(end + 6)
+        return token{kind: tokenString, value: blockString(rest[3 : 3+end]), loc: loc}, nil
+    }
+
+    var value strings.Builder
+    for n := 1; n < len(rest); {
+        switch c := rest[n]; c {
+        case '"':
+            l.advance(n + 1)
+            return token{kind: tokenString, value: value.String(), loc: loc}, nil
+        case '\n', '\r':
+            return token{}, syntaxError(loc, "unterminated string")
+        case '\\':
+            if n+1 >= len(rest) {
+                return token{}, syntaxError(loc, "unterminated string")
+            }
+            escape := rest[n+1]
+            if escape == 'u' {
+                if n+6 > len(rest) {
+                    return token{}, syntaxError(loc, "invalid unicode escape")
+                }
+                code, err := strconv.ParseUint(rest[n+2:n+6], 16, 32)
+                if err != nil {
+                    return token{}, syntaxError(loc, "invalid unicode escape")
+                }
+                value.WriteRune(rune(code))
+                n += 6
+                continue
+            }
+            unescaped, ok := map[byte]byte{'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t'}[escape]
+            if !ok {
+                return token{}, syntaxError(loc, "invalid escape \\%c", escape)
+            }
+            value.WriteByte(unescaped)
+            n += 2
+        default:
+            value.WriteByte(c)
+            n++
+        }
+    }
+    return token{}, syntaxError(loc, "unterminated string")
+}
+
+// blockString убирает общий отступ и пустые крайние строки блочной строки
+func blockString(raw string) string {
+    lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+    indent := -1
+    for _, line := range lines[1:] {
+        trimmed := strings.TrimLeft(line, " \t")
+        if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+            indent = len(line) - len(trimmed)
+        }
+    }
+    for i := 1; i < len(lines) && indent > 0; i++ {
+        if len(lines[i]) >= indent {
+            lines[i] = lines[i][indent:]
+        } else {
+            lines[i] = ""
+        }
+    }
+    for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+        lines = lines[1:]
+    }
+    for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+        lines = lines[:len(lines)-1]
+    }
+    return strings.ReplaceAll(strings.Join(lines, "\n"), `\"""`, `"""`)
+}
+
+func isLetter(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+// document - разобранный запрос: операции и именованные фрагменты
+type document struct {
+    operations []*operation
+    fragments  map[string]*fragment
+}
+
+// operation - query или mutation
+type operation struct {
+    kind       string
+    name       string
+    variables  []variableDefinition
+    selections []selection
+    loc        Location
+}
+
+// variableDefinition - объявление переменной операции
+type variableDefinition struct {
+    name         string
+    typ          string
+    defaultValue inputValue
+    hasDefault   bool
+}
+
+// fragment - именованный фрагмент
+type fragment struct {
+    name       string
+    on         string
+    selections []selection
+    loc        Location
+}
+
+// selection - поле, ссылка на фрагмент (...Name) или встроенный фрагмент (... on Type { })
+type selection struct {
+    field      *fieldNode
+    spread     string
+    inlineOn   string
+    inline     []selection
+    directives []directive
+    loc        Location
+}
+
+// fieldNode - запрошенное поле
+type fieldNode struct {
+    alias      string
+    name       string
+    arguments  []argument
+    selections []selection
+}
+
+// key возвращает имя поля в ответе
+func (f *fieldNode) key() string {
+    if f.alias != "" {
+        return f.alias
+    }
+    return f.name
+}
+
+// argument - аргумент поля или директивы
+type argument struct {
+    name  string
+    value inputValue
+    loc   Location
+}
+
+// directive - директива @skip или @include
+type directive struct {
+    name      string
+    arguments []argument
+    loc       Location
+}
+
+// inputValue - значение в тексте запроса: int, float64, string, bool, nil, enumValue,
+// variableRef, []inputValue или objectValue
+type inputValue interface{}
+
+// enumValue - значение перечисления без кавычек
+type enumValue string
+
+// variableRef - ссылка на переменную $name
+type variableRef string
+
+// objectValue - входной объект с полями в порядке записи
+type objectValue []argument
+
+// parser строит document по лексемам
+type parser struct {
+    lexer *lexer
+    tok   token
+}
+
+// parse разбирает текст запроса
+func parse(source string) (*document, error) {
+    p := &parser{lexer: &lexer{src: source, line: 1, col: 1}}
+    if err := p.read(); err != nil {
+        return nil, err
+    }
+    doc := &document{fragments: make(map[string]*fragment)}
+    for p.tok.kind != tokenEOF {
+        switch {
+        case p.peek(tokenPunct, "{"):
+            loc := p.tok.loc
+            selections, err := p.selectionSet()
+            if err != nil {
+                return nil, err
+            }
+            doc.operations = append(doc.operations, &operation{kind: "query", selections: selections, loc: loc})
+        case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+            op, err := p.operation()
+            if err != nil {
+                return nil, err
+            }
+            doc.operations = append(doc.operations, op)
+        case p.peek(tokenName, "fragment"):
+            f, err := p.fragment()
+            if err != nil {
+                return nil, err
+            }
+            if _, ok := doc.fragments[f.name]; ok {
+                return nil, syntaxError(f.loc, "fragment %s is defined more than once", f.name)
+            }
+            doc.fragments[f.name] = f
+        default:
+            return nil, p.unexpected()
+        }
+    }
+    if len(doc.operations) == 0 {
+        return nil, syntaxError(p.tok.loc, "document has no operations")
+    }
+    return doc, nil
+}
+
+// read переходит к следующей лексеме
+func (p *parser) read() error {
+    tok, err := p.lexer.next()
+    if err != nil {
+        return err
+    }
+    p.tok = tok
+    return nil
+}
+
+// peek сообщает, что текущая лексема - kind со значением value
+func (p *parser) peek(kind tokenKind, value string) bool {
+    return p.tok.kind == kind && p.tok.value == value
+}
+
+// skip пропускает лексему, если она совпадает, и сообщает об этом
+func (p *parser) skip(kind tokenKind, value string) (bool, error) {
+    if !p.peek(kind, value) {
+        return false, nil
+    }
+    return true, p.read()
+}
+
+// expect требует знак препинания value
+func (p *parser) expect(value string) error {
+    if !p.peek(tokenPunct, value) {
+        return syntaxError(p.tok.loc, "expected %q, got %s", value, p.describe())
+    }
+    return p.read()
+}
+
+// name читает имя
+func (p *parser) name() (string, error) {
+    if p.tok.kind != tokenName {
+        return "", syntaxError(p.tok.loc, "expected name, got %s", p.describe())
+    }
+    name := p.tok.value
+    return name, p.read()
+}
+
+func (p *parser) unexpected() error {
+    return syntaxError(p.tok.loc, "unexpected %s", p.describe())
+}
+
+// describe описывает текущую лексему для сообщения
+func (p *parser) describe() string {
+    if p.tok.kind == tokenEOF {
+        return "end of document"
+    }
+    return strconv.Quote(p.tok.value)
+}
+
+// operation разбирает query|mutation|subscription Name($var: Type = default) @directives { ... }
+func (p *parser) operation() (*operation, error) {
+    op := &operation{kind: p.tok.value, loc: p.tok.loc}
+    if err := p.read(); err != nil {
+        return nil, err
+    }
+    if p.tok.kind == tokenName {
+        op.name = p.tok.value
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+    }
+    if ok, err := p.skip(tokenPunct, "("); err != nil {
+        return nil, err
+    } else if ok {
+        for !p.peek(tokenPunct, ")") {
+            definition, err := p.variableDefinition()
+            if err != nil {
+                return nil, err
+            }
+            op.variables = append(op.variables, definition)
+        }
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+    }
+    if _, err := p.directives(); err != nil {
+        return nil, err
+    }
+    selections, err := p.selectionSet()
+    if err != nil {
+        return nil, err
+    }
+    op.selections = selections
+    return op, nil
+}
+
+// variableDefinition разбирает $name: Type = default
+func (p *parser) variableDefinition() (variableDefinition, error) {
+    var definition variableDefinition
+    if err := p.expect("$"); err != nil {
+        return definition, err
+    }
+    name, err := p.name()
+    if err != nil {
+        return definition, err
+    }
+    definition.name = name
+    if err := p.expect(":"); err != nil {
+        return definition, err
+    }
+    if definition.typ, err = p.typeReference(); err != nil {
+        return definition, err
+    }
+    if ok, err := p.skip(tokenPunct, "="); err != nil {
+        return definition, err
+    } else if ok {
+        if definition.defaultValue, err = p.value(true); err != nil {
+            return definition, err
+        }
+        definition.hasDefault = true
+    }
+    return definition, nil
+}
+
+// typeReference разбирает тип Name, [Type] с необязательным ! и возвращает его текстом
+func (p *parser) typeReference() (string, error) {
+    var typ string
+    if ok, err := p.skip(tokenPunct, "["); err != nil {
+        return "", err
+    } else if ok {
+        inner, err := p.typeReference()
+        if err != nil {
+            return "", err
+        }
+        if err := p.expect("]"); err != nil {
+            return "", err
+        }
+        typ = "[" + inner + "]"
+    } else {
+        name, err := p.name()
+        if err != nil {
+            return "", err
+        }
+        typ = name
+    }
+    if ok, err := p.skip(tokenPunct, "!"); err != nil {
+        return "", err
+    } else if ok {
+        typ += "!"
+    }
+    return typ, nil
+}
+
+// fragment разбирает fragment Name on Type { ... }
+func (p *parser) fragment() (*fragment, error) {
+    f := &fragment{loc: p.tok.loc}
+    if err := p.read(); err != nil {
+        return nil, err
+    }
+    name, err := p.name()
+    if err != nil {
+        return nil, err
+    }
+    if name == "on" {
+        return nil, syntaxError(f.loc, "fragment cannot be named on")
+    }
+    f.name = name
+    if !p.peek(tokenName, "on") {
+        return nil, syntaxError(p.tok.loc, "expected \"on\", got %s", p.describe())
+    }
+    if err := p.read(); err != nil {
+        return nil, err
+    }
+    if f.on, err = p.name(); err != nil {
+        return nil, err
+    }
+    if _, err := p.directives(); err != nil {
+        return nil, err
+    }
+    if f.selections, err = p.selectionSet(); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// selectionSet разбирает { selection ... }
+func (p *parser) selectionSet() ([]selection, error) {
+    if err := p.expect("{"); err != nil {
+        return nil, err
+    }
+    var selections []selection
+    for !p.peek(tokenPunct, "}") {
+        s, err := p.selection()
+        if err != nil {
+            return nil, err
+        }
+        selections = append(selections, s)
+    }
+    if len(selections) == 0 {
+        return nil, syntaxError(p.tok.loc, "selection set is empty")
+    }
+    return selections, p.read()
+}
+
+// selection разбирает поле или фрагмент
+func (p *parser) selection() (selection, error) {
+    s := selection{loc: p.tok.loc}
+    if ok, err := p.skip(tokenPunct, "..."); err != nil {
+        return s, err
+    } else if ok {
+        if p.tok.kind == tokenName && p.tok.value != "on" {
+            s.spread = p.tok.value
+            if err := p.read(); err != nil {
+                return s, err
+            }
+            s.directives, err = p.directives()
+            return s, err
+        }
+        if ok, err := p.skip(tokenName, "on"); err != nil {
+            return s, err
+        } else if ok {
+            if s.inlineOn, err = p.name(); err != nil {
+                return s, err
+            }
+        }
+        if s.directives, err = p.directives(); err != nil {
+            return s, err
+        }
+        s.inline, err = p.selectionSet()
+        return s, err
+    }
+
+    field := &fieldNode{}
+    name, err := p.name()
+    if err != nil {
+        return s, err
+    }
+    field.name = name
+    if ok, err := p.skip(tokenPunct, ":"); err != nil {
+        return s, err
+    } else if ok {
+        field.alias = name
+        if field.name, err = p.name(); err != nil {
+            return s, err
+        }
+    }
+    if field.arguments, err = p.arguments(false); err != nil {
+        return s, err
+    }
+    if s.directives, err = p.directives(); err != nil {
+        return s, err
+    }
+    if p.peek(tokenPunct, "{") {
+        if field.selections, err = p.selectionSet(); err != nil {
+            return s, err
+        }
+    }
+    s.field = field
+    return s, nil
+}
+
+// arguments разбирает (name: value ...), если он есть
+func (p *parser) arguments(constant bool) ([]argument, error) {
+    if ok, err := p.skip(tokenPunct, "("); err != nil || !ok {
+        return nil, err
+    }
+    var arguments []argument
+    for !p.peek(tokenPunct, ")") {
+        arg := argument{loc: p.tok.loc}
+        name, err := p.name()
+        if err != nil {
+            return nil, err
+        }
+        arg.name = name
+        if err := p.expect(":"); err != nil {
+            return nil, err
+        }
+        if arg.value, err = p.value(constant); err != nil {
+            return nil, err
+        }
+        arguments = append(arguments, arg)
+    }
+    return arguments, p.read()
+}
+
+// directives разбирает @name(args) ...
+func (p *parser) directives() ([]directive, error) {
+    var directives []directive
+    for p.peek(tokenPunct, "@") {
+        d := directive{loc: p.tok.loc}
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+        name, err := p.name()
+        if err != nil {
+            return nil, err
+        }
+        d.name = name
+        if d.arguments, err = p.arguments(false); err != nil {
+            return nil, err
+        }
+        directives = append(directives, d)
+    }
+    return directives, nil
+}
+
+// value разбирает значение; constant запрещает переменные (значения по умолчанию)
+func (p *parser) value(constant bool) (inputValue, error) {
+    tok := p.tok
+    switch {
+    case tok.kind == tokenPunct && tok.value == "$" && !constant:
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+        name, err := p.name()
+        return variableRef(name), err
+    case tok.kind == tokenInt:
+        n, err := strconv.Atoi(tok.value)
+        if err != nil {
+            return nil, syntaxError(tok.loc, "integer %s is out of range", tok.value)
+        }
+        return n, p.read()
+    case tok.kind == tokenFloat:
+        f, err := strconv.ParseFloat(tok.value, 64)
+        if err != nil {
+            return nil, syntaxError(tok.loc, "float %s is out of range", tok.value)
+        }
+        return f, p.read()
+    case tok.kind == tokenString:
+        return tok.value, p.read()
+    case tok.kind == tokenName:
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+        switch tok.value {
+        case "true":
+            return true, nil
+        case "false":
+            return false, nil
+        case "null":
+            return nil, nil
+        }
+        return enumValue(tok.value), nil
+    case tok.kind == tokenPunct && tok.value == "[":
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+        list := []inputValue{}
+        for !p.peek(tokenPunct, "]") {
+            item, err := p.value(constant)
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, item)
+        }
+        return list, p.read()
+    case tok.kind == tokenPunct && tok.value == "{":
+        if err := p.read(); err != nil {
+            return nil, err
+        }
+        object := objectValue{}
+        for !p.peek(tokenPunct, "}") {
+            field := argument{loc: p.tok.loc}
+            name, err := p.name()
+            if err != nil {
+                return nil, err
+            }
+            field.name = name
+            if err := p.expect(":"); err != nil {
+                return nil, err
+            }
+            if field.value, err = p.value(constant); err != nil {
+                return nil, err
+            }
+            object = append(object, field)
+        }
+        return object, p.read()
+    }
+    return nil, p.unexpected()
+}
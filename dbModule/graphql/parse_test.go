@@ -0,0 +1,126 @@
+package graphql
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "strings"
+    "testing"
+)
+
+// testSchema - схема с одним рекурсивным типом Node: node(id) возвращает узел, child - узел глубже
+func testSchema() *Schema {
+    node := &Object{Name: "Node"}
+    node.Fields = map[string]*Field{
+        "id":   {},
+        "name": {},
+        "child": {
+            Type: node,
+            Resolve: func(p ResolveParams) (interface{}, error) {
+                id := p.Source.(map[string]interface{})["id"].(int)
+                return map[string]interface{}{"id": id + 1, "name": "child"}, nil
+            },
+        },
+    }
+    query := &Object{Name: "Query", Fields: map[string]*Field{
+        "node": {
+            Type: node,
+            Args: []Arg{{Name: "id", Type: "Int!"}, {Name: "name", Type: "String"}},
+            Resolve: func(p ResolveParams) (interface{}, error) {
+                name, _ := p.Args["name"].(string)
+                return map[string]interface{}{"id": p.Args["id"].(int), "name": name}, nil
+            },
+        },
+    }}
+    return &Schema{Query: query, MaxDepth: 3}
+}
+
+// execute выполняет запрос по testSchema и возвращает данные в JSON и текст первой ошибки
+func execute(t *testing.T, query string, variables map[string]interface{}) (string, string) {
+    t.Helper()
+
+    response := Execute(context.Background(), testSchema(), Request{Query: query, Variables: variables})
+    data, err := json.Marshal(response.Data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(response.Errors) > 0 {
+        return string(data), response.Errors[0].Message
+    }
+    return string(data), ""
+}
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        name      string
+        query     string
+        variables map[string]interface{}
+        want      string
+        wantErr   string
+    }{
+        {"shorthand query", `{ node(id: 1) { id name } }`, nil, `{"node":{"id":1,"name":""}}`, ""},
+        {"comments and commas", "query Named {\n  # комментарий\n  node(id: 1,) { id, }\n}", nil, `{"node":{"id":1}}`, ""},
+        {"alias", `{ first: node(id: 1) { id } second: node(id: 2) { id } }`, nil, `{"first":{"id":1},"second":{"id":2}}`, ""},
+
+        {"depth within limit", `{ node(id: 1) { child { id } } }`, nil, `{"node":{"child":{"id":2}}}`, ""},
+        {"depth over limit", `{ node(id: 1) { child { child { id } } } }`, nil, "", "selection is nested deeper than 3 levels"},
+        {"depth over limit through fragment", `{ node(id: 1) { ...Deep } } fragment Deep on Node { child { child { id } } }`, nil, "", "selection is nested deeper than 3 levels"},
+
+        {"variable", `query ($id: Int!) { node(id: $id) { id } }`, map[string]interface{}{"id": 7}, `{"node":{"id":7}}`, ""},
+        {"variable default", `query ($id: Int! = 5) { node(id: $id) { id } }`, nil, `{"node":{"id":5}}`, ""},
+        {"string variable", `query ($id: Int!, $name: String) { node(id: $id, name: $name) { name } }`, map[string]interface{}{"id": 1, "name": "Пельменная"}, `{"node":{"name":"Пельменная"}}`, ""},
+        {"missing variable", `query ($id: Int!) { node(id: $id) { id } }`, nil, "", "variable $id of type Int! is required"},
+        {"variable of wrong type", `query ($id: Int!) { node(id: $id) { id } }`, map[string]interface{}{"id": "seven"}, "", "variable $id"},
+        {"undeclared variable", `{ node(id: $id) { id } }`, nil, "", "variable $id is not declared"},
+        {"nullable variable for required argument", `query ($id: Int) { node(id: $id) { id } }`, nil, "", "cannot be used as Int!"},
+
+        {"fragment", `{ node(id: 1) { ...Fields } } fragment Fields on Node { id name }`, nil, `{"node":{"id":1,"name":""}}`, ""},
+        {"inline fragment", `{ node(id: 1) { ... on Node { id } } }`, nil, `{"node":{"id":1}}`, ""},
+        {"skip and include", `{ node(id: 1) { id @skip(if: true) name @include(if: true) } }`, nil, `{"node":{"name":""}}`, ""},
+        {"unknown fragment", `{ node(id: 1) { ...Missing } }`, nil, "", "unknown fragment Missing"},
+        {"fragment spreads itself", `{ node(id: 1) { ...Loop } } fragment Loop on Node { id ...Loop }`, nil, "", "fragment Loop spreads itself"},
+        {"fragment on another type", `{ node(id: 1) { ...Fields } } fragment Fields on Query { node(id: 2) { id } }`, nil, "", "cannot be spread in Node"},
+        {"fragment defined twice", `{ node(id: 1) { ...F } } fragment F on Node { id } fragment F on Node { name }`, nil, "", "fragment F is defined more than once"},
+
+        {"empty document", ``, nil, "", "syntax error: document has no operations"},
+        {"unclosed selection", `{ node(id: 1) { id }`, nil, "", "syntax error"},
+        {"empty selection", `{ node(id: 1) { } }`, nil, "", "syntax error: selection set is empty"},
+        {"unterminated string", `{ node(id: 1, name: "cafe) { id } }`, nil, "", "syntax error"},
+        {"integer out of range", `{ node(id: 99999999999999999999) { id } }`, nil, "", "syntax error: integer 99999999999999999999 is out of range"},
+        {"unexpected token", `{ node(id: 1) { id } } }`, nil, "", "syntax error"},
+        {"missing argument value", `{ node(id:) { id } }`, nil, "", "syntax error"},
+        {"unknown field", `{ node(id: 1) { price } }`, nil, "", `cannot query field "price" on type Node`},
+        {"scalar with selection", `{ node(id: 1) { id { value } } }`, nil, "", "field Node.id is a scalar and has no fields"},
+        {"required argument", `{ node { id } }`, nil, "", "argument id of Query.node is required"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            data, err := execute(t, tt.query, tt.variables)
+            if tt.wantErr != "" {
+                if !strings.Contains(err, tt.wantErr) {
+                    t.Fatalf("got error %q, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != "" {
+                t.Fatalf("unexpected error %q", err)
+            }
+            if data != tt.want {
+                t.Errorf("got %s, want %s", data, tt.want)
+            }
+        })
+    }
+}
+
+// TestSyntaxErrorLocation проверяет, что ошибка разбора указывает строку и колонку лексемы
+func TestSyntaxErrorLocation(t *testing.T) {
+    _, err := parse("{\n  node(id: 1) {\n    id\n  }\n  )\n}")
+    var syntaxErr *Error
+    if !errors.As(err, &syntaxErr) {
+        t.Fatalf("parse error %v is not *Error", err)
+    }
+    if len(syntaxErr.Locations) != 1 || syntaxErr.Locations[0] != (Location{Line: 5, Column: 3}) {
+        t.Errorf("error %q is at %v, want line 5 column 3", syntaxErr.Message, syntaxErr.Locations)
+    }
+}
+
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "strconv"
+    "strings"
+    "testing"
+
+    "dbModule/graphql"
+    "dbModule/httpapi"
+)
+
+// TestGraphQLUserViews проверяет, что телефон пользователя видят только он сам и пользователи
+// с PermissionManageUsers, а анонимный запрос - нет
+func TestGraphQLUserViews(t *testing.T) {
+    db := NewTestDatabase(t)
+    phone := "+7 900 000-00-00"
+    customer := User{Name: "Ivan", Lastname: "Customer", Email: "customer@example.com", Password: "GraphQL-Passw0rd!", Phone: &phone, Role: RoleCustomer}
+    if _, err := db.InsertUserReturningID(&customer); err != nil {
+        t.Fatal(err)
+    }
+    other := User{Name: "Olga", Lastname: "Other", Email: "other@example.com", Password: "GraphQL-Passw0rd!", Role: RoleCustomer}
+    if _, err := db.InsertUserReturningID(&other); err != nil {
+        t.Fatal(err)
+    }
+    admin := User{Name: "Anna", Lastname: "Admin", Email: "admin@example.com", Password: "GraphQL-Passw0rd!", Role: RoleAdmin}
+    if _, err := db.InsertUserReturningID(&admin); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name      string
+        ctx       context.Context
+        wantPhone bool
+    }{
+        {"anonymous", context.Background(), false},
+        {"other user", httpapi.ContextWithUser(context.Background(), strconv.Itoa(other.ID)), false},
+        {"the user", httpapi.ContextWithUser(context.Background(), strconv.Itoa(customer.ID)), true},
+        {"admin", httpapi.ContextWithUser(context.Background(), strconv.Itoa(admin.ID)), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            response := graphql.Execute(tt.ctx, db.GraphQLSchema(), graphql.Request{Query: `{ users { id email phone birthdate locale } }`})
+            if len(response.Errors) > 0 {
+                t.Fatal(response.Errors[0])
+            }
+            body, err := json.Marshal(response.Data)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !strings.Contains(string(body), customer.Email) {
+                t.Fatalf("users = %s, want %s among them", body, customer.Email)
+            }
+            if got := strings.Contains(string(body), phone); got != tt.wantPhone {
+                t.Errorf("users = %s, phone shown: %v, want %v", body, got, tt.wantPhone)
+            }
+        })
+    }
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "time"
+)
+
+// historyTables - таблицы истории сущностей, версии которых хранит SetHistory, по имени сущности в журнале аудита
+var historyTables = map[string]string{
+    "user":       "users_history",
+    "restaurant": "restaurants_history",
+}
+
+// HistoryVersion - одна версия записи в таблице истории
+type HistoryVersion struct {
+    HistoryID int64  `json:"history_id"`
+    Entity    string `json:"entity"`
+    ID        int    `json:"id"`
+    Version   int    `json:"version"`
+    // Record - JSON записи в этой версии, как в журнале аудита: без паролей, с зашифрованными полями PII
+    Record    json.RawMessage `json:"record"`
+    Actor     string          `json:"actor,omitempty"`
+    // ValidFrom - когда версия появилась; nil - версия записана до включения истории, и время неизвестно
+    ValidFrom *time.Time `json:"valid_from"`
+    // ValidTo - когда версию сменила следующая или запись удалили; nil - версия действует
+    ValidTo   *time.Time `json:"valid_to"`
+}
+
+// SetHistory включает историю версий пользователей и ресторанов: каждое изменение и удаление закрывает
+// действующую версию в users_history или restaurants_history и добавляет новую в той же транзакции
+// (см. audit), а AsOf восстанавливает запись на любой момент после включения. Запись, добавленная
+// до включения, получает версию при первом изменении; время ее появления неизвестно (ValidFrom nil).
+// Вызывается до начала работы
+func (db *Database) SetHistory(enabled bool) {
+    db.history = enabled
+}
+
+// recordHistory сохраняет изменение записи в таблице истории ее сущности; sealedOld и sealedNew -
+// значения до и после изменения в виде журнала аудита. Сущности без таблицы истории пропускаются
+func (db *Database) recordHistory(entity string, id int, action string, sealedOld, sealedNew sql.NullString) error {
+    table, ok := historyTables[entity]
+    if !ok || !db.history {
+        return nil
+    }
+    now := db.now().UTC()
+    actor := sql.NullString{String: db.actor, Valid: db.actor != ""}
+    result, err := db.execNamed(table+".close", now, id, db.tenant)
+    if err != nil {
+        return err
+    }
+    closed, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if closed == 0 && action != AuditInsert && sealedOld.Valid {
+        // первая версия записи, добавленной до включения истории
+        if err := db.insertHistory(table, id, sealedOld.String, sql.NullString{}, sql.NullTime{}, sql.NullTime{Time: now, Valid: true}); err != nil {
+            return err
+        }
+    }
+    if action == AuditDelete || !sealedNew.Valid {
+        return nil
+    }
+    return db.insertHistory(table, id, sealedNew.String, actor, sql.NullTime{Time: now, Valid: true}, sql.NullTime{})
+}
+
+// insertHistory добавляет версию record записи id в таблицу истории table
+func (db *Database) insertHistory(table string, id int, record string, actor sql.NullString, validFrom, validTo sql.NullTime) error {
+    var versioned struct {
+        Version int `json:"version"`
+    }
+    if err := json.Unmarshal([]byte(record), &versioned); err != nil {
+        return err
+    }
+    _, err := db.execNamed(table+".insert", db.tenant, id, versioned.Version, record, actor, validFrom, validTo)
+    return err
+}
+
+// AsOf возвращает версию записи entity (user или restaurant) с ID id, действовавшую в момент at, например
+// чтобы разобрать спор с владельцем ресторана о том, что было указано в карточке. Нужна история (см. SetHistory);
+// ErrNotFound - записи в этот момент не было, она была удалена или ее история не велась
+func (db *Database) AsOf(entity string, id int, at time.Time) (HistoryVersion, error) {
+    table, ok := historyTables[entity]
+    if !ok {
+        return HistoryVersion{}, db.opError("as of", entity, id, &ValidationError{Field: "entity", Message: fmt.Sprintf("%q has no history; use user or restaurant", entity)})
+    }
+    at = at.UTC()
+    versions, err := db.historyVersions(entity, table+".select_as_of", id, db.tenant, at, at)
+    if err != nil {
+        return HistoryVersion{}, db.opError("as of", entity, id, err)
+    }
+    if len(versions) == 0 {
+        return HistoryVersion{}, db.opError("as of", entity, id, fmt.Errorf("no version at %s: %w", at.Format(time.RFC3339), ErrNotFound))
+    }
+    return versions[0], nil
+}
+
+// History возвращает все сохраненные версии записи entity с ID id по порядку (см. SetHistory)
+func (db *Database) History(entity string, id int) ([]HistoryVersion, error) {
+    table, ok := historyTables[entity]
+    if !ok {
+        return nil, db.opError("history", entity, id, &ValidationError{Field: "entity", Message: fmt.Sprintf("%q has no history; use user or restaurant", entity)})
+    }
+    versions, err := db.historyVersions(entity, table+".select_by_id", id, db.tenant)
+    return versions, db.opError("history", entity, id, err)
+}
+
+// UserAsOf возвращает пользователя в момент at (см. AsOf) с расшифрованными email и телефоном
+func (db *Database) UserAsOf(id int, at time.Time) (User, error) {
+    version, err := db.AsOf("user", id, at)
+    if err != nil {
+        return User{}, err
+    }
+    var user User
+    if err := json.Unmarshal(version.Record, &user); err != nil {
+        return User{}, db.opError("as of", "user", id, err)
+    }
+    if err := db.openUser(&user); err != nil {
+        return User{}, db.opError("as of", "user", id, err)
+    }
+    return user, nil
+}
+
+// RestaurantAsOf возвращает ресторан в момент at (см. AsOf)
+func (db *Database) RestaurantAsOf(id int, at time.Time) (Restaurant, error) {
+    version, err := db.AsOf("restaurant", id, at)
+    if err != nil {
+        return Restaurant{}, err
+    }
+    var restaurant Restaurant
+    if err := json.Unmarshal(version.Record, &restaurant); err != nil {
+        return Restaurant{}, db.opError("as of", "restaurant", id, err)
+    }
+    return restaurant, nil
+}
+
+// historyVersions читает версии запросом name таблицы истории
+func (db *Database) historyVersions(entity, name string, args ...interface{}) ([]HistoryVersion, error) {
+    rows, err := db.queryNamed(name, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var versions []HistoryVersion
+    for rows.Next() {
+        version := HistoryVersion{Entity: entity}
+        var record string
+        var actor sql.NullString
+        var validFrom, validTo sql.NullTime
+        if err := rows.Scan(&version.HistoryID, &version.ID, &version.Version, &record, &actor, &validFrom, &validTo); err != nil {
+            return nil, err
+        }
+        version.Record, version.Actor = json.RawMessage(record), actor.String
+        if validFrom.Valid {
+            version.ValidFrom = &validFrom.Time
+        }
+        if validTo.Valid {
+            version.ValidTo = &validTo.Time
+        }
+        versions = append(versions, version)
+    }
+    return versions, rows.Err()
+}
+
+// runHistory выводит версии записи или ее версию на момент времени в JSON:
+// history -entity user|restaurant -id N [-at TIME]
+func runHistory(db *Database, args []string) error {
+    flags := flag.NewFlagSet("history", flag.ContinueOnError)
+    entity := flags.String("entity", "restaurant", "user or restaurant")
+    id := flags.Int("id", 0, "ID of the record")
+    at := flags.String("at", "", "print only the version in effect at this RFC 3339 time, e.g. 2026-01-02T19:30:00Z")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    if *at == "" {
+        versions, err := db.History(*entity, *id)
+        if err != nil {
+            return err
+        }
+        return encoder.Encode(versions)
+    }
+    moment, err := time.Parse(time.RFC3339, *at)
+    if err != nil {
+        return fmt.Errorf("-at: %w", err)
+    }
+    version, err := db.AsOf(*entity, *id, moment)
+    if err != nil {
+        return err
+    }
+    return encoder.Encode(version)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "fmt"
+    "reflect"
+)
+
+// EntityHooks - обработчики жизненного цикла записей одной сущности; пустые поля не вызываются.
+// Все обработчики выполняются в транзакции изменения и получают ее как tx: их запросы
+// фиксируются вместе с изменением, а ошибка откатывает его и возвращается вызывающему
+type EntityHooks[T any] struct {
+    // BeforeInsert вызывается перед вставкой и может дополнить запись; после него запись
+    // проверяется заново. Upsert его не вызывает
+    BeforeInsert func(tx *Database, record *T) error
+    AfterInsert  func(tx *Database, record T) error
+    AfterUpdate  func(tx *Database, old, current T) error
+    // AfterDelete вызывается и для строк, удаленных каскадно (рестораны при DeleteUser с DeleteCascade)
+    AfterDelete  func(tx *Database, old T) error
+}
+
+// entityHooks - зарегистрированные обработчики без типа записи
+type entityHooks struct {
+    beforeInsert func(tx *Database, record interface{}) error
+    after        func(tx *Database, action string, oldValue, newValue interface{}) error
+}
+
+// hooks - обработчики по сущностям в порядке регистрации
+var hooks = map[string][]entityHooks{}
+
+// hookEntityTypes - тип записи каждой сущности; имена те же, что в журнале аудита
+var hookEntityTypes = map[string]reflect.Type{
+    "user":       reflect.TypeFor[User](),
+    "restaurant": reflect.TypeFor[Restaurant](),
+    "menu_item":  reflect.TypeFor[MenuItem](),
+    "review":     reflect.TypeFor[Review](),
+}
+
+// RegisterHooks регистрирует обработчики для записей сущности entity ("user", "restaurant",
+// "menu_item", "review"), например для сброса внешних кешей или денормализации. Обработчики
+// нескольких регистраций вызываются в порядке регистрации. Регистрировать их нужно до начала работы с базой
+func RegisterHooks[T any](entity string, h EntityHooks[T]) {
+    recordType, ok := hookEntityTypes[entity]
+    if !ok {
+        panic(fmt.Sprintf("hooks: unknown entity %s", entity))
+    }
+    if recordType != reflect.TypeFor[T]() {
+        panic(fmt.Sprintf("hooks: entity %s records are %v, not %v", entity, recordType, reflect.TypeFor[T]()))
+    }
+
+    hooks[entity] = append(hooks[entity], entityHooks{
+        beforeInsert: func(tx *Database, record interface{}) error {
+            if h.BeforeInsert == nil {
+                return nil
+            }
+            return h.BeforeInsert(tx, record.(*T))
+        },
+        after: func(tx *Database, action string, oldValue, newValue interface{}) error {
+            switch {
+            case action == AuditInsert && h.AfterInsert != nil:
+                return h.AfterInsert(tx, *newValue.(*T))
+            case action == AuditUpdate && h.AfterUpdate != nil:
+                return h.AfterUpdate(tx, *oldValue.(*T), *newValue.(*T))
+            case action == AuditDelete && h.AfterDelete != nil:
+                return h.AfterDelete(tx, *oldValue.(*T))
+            }
+            return nil
+        },
+    })
+}
+
+// beforeInsert вызывает обработчики BeforeInsert сущности и заново проверяет запись validate,
+// если обработчики есть
+func beforeInsert[T any](tx *Database, entity string, record *T, validate func(T) error) error {
+    registered := hooks[entity]
+    if len(registered) == 0 {
+        return nil
+    }
+    for _, h := range registered {
+        if err := h.beforeInsert(tx, record); err != nil {
+            return err
+        }
+    }
+    return validate(*record)
+}
+
+// afterChange вызывает обработчики After* сущности для изменения, записанного в журнал аудита
+func (db *Database) afterChange(entity, action string, oldValue, newValue interface{}) error {
+    for _, h := range hooks[entity] {
+        if err := h.after(db, action, oldValue, newValue); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "slices"
+    "strconv"
+    "time"
+)
+
+// hoursTimePattern - время HH:MM в часах работы, как в формате обмена
+var hoursTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// validateRestaurantHours проверяет периоды работы: день недели из listingWeekdays, время HH:MM
+// и не больше одного периода, открывающегося в один день в одно время
+func validateRestaurantHours(hours []ListingHours) error {
+    type start struct{ weekday, opens string }
+    var seen []start
+    for i, period := range hours {
+        field := fmt.Sprintf("hours[%d]", i)
+        if !slices.Contains(listingWeekdays, period.Weekday) {
+            return &ValidationError{Field: field + ".weekday", Message: fmt.Sprintf("%q is not one of mon, tue, wed, thu, fri, sat, sun", period.Weekday)}
+        }
+        if !hoursTimePattern.MatchString(period.Opens) {
+            return &ValidationError{Field: field + ".opens", Message: fmt.Sprintf("%q is not a time like 09:30", period.Opens)}
+        }
+        if !hoursTimePattern.MatchString(period.Closes) {
+            return &ValidationError{Field: field + ".closes", Message: fmt.Sprintf("%q is not a time like 22:00", period.Closes)}
+        }
+        if slices.Contains(seen, start{period.Weekday, period.Opens}) {
+            return &ValidationError{Field: field, Message: "duplicates another period opening at the same time"}
+        }
+        seen = append(seen, start{period.Weekday, period.Opens})
+    }
+    return nil
+}
+
+// RestaurantHours возвращает часы работы ресторана по дням недели или ErrNotFound, если ресторана нет
+func (db *Database) RestaurantHours(restaurantID int) ([]ListingHours, error) {
+    restaurant, err := db.findRestaurant("restaurants.select_by_id", restaurantID, db.tenant)
+    if err == nil && restaurant == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return nil, db.opError("list", "restaurant hours", restaurantID, err)
+    }
+    return db.restaurantHours(restaurantID)
+}
+
+// SetRestaurantHours заменяет часы работы ресторана на hours одной транзакцией и возвращает их.
+// Closes раньше Opens или равное ему - работа после полуночи до Closes следующего дня (00:00-00:00 -
+// круглые сутки). Пустой hours удаляет часы работы. Изменение пишется в журнал аудита
+func (db *Database) SetRestaurantHours(restaurantID int, hours []ListingHours) ([]ListingHours, error) {
+    if err := validateRestaurantHours(hours); err != nil {
+        return nil, db.opError("update", "restaurant hours", restaurantID, err)
+    }
+    var saved []ListingHours
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.RestaurantHours(restaurantID)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.execNamed("restaurant_hours.delete_by_restaurant", restaurantID, tx.tenant); err != nil {
+            return err
+        }
+        for _, period := range hours {
+            if _, err := tx.execNamed("restaurant_hours.insert", restaurantID, listingWeekday(period.Weekday), period.Opens, period.Closes, tx.tenant); err != nil {
+                return err
+            }
+        }
+        if saved, err = tx.restaurantHours(restaurantID); err != nil {
+            return err
+        }
+        return tx.audit("restaurant_hours", restaurantID, AuditUpdate, old, saved)
+    })
+    return saved, db.opError("update", "restaurant hours", restaurantID, err)
+}
+
+// SelectRestaurantsOpenAt выбирает рестораны площадки, открытые в момент at (см. RestaurantFilter.OpenAt)
+func (db *Database) SelectRestaurantsOpenAt(at time.Time, sorts ...RestaurantSort) ([]Restaurant, error) {
+    return db.SelectRestaurantsWhere(RestaurantFilter{OpenAt: &at}, sorts...)
+}
+
+// applyOpenAt добавляет условие "открыт в момент at": день недели и время берутся по часам at,
+// поэтому at передается в часовом поясе ресторанов. Подходит период, открывшийся в этот день
+// не позже at и еще не закрывшийся, или период предыдущего дня, работающий после полуночи
+func applyOpenAt(query *SelectBuilder, at time.Time) {
+    weekday := (int(at.Weekday()) + 6) % 7
+    previous := (weekday + 6) % 7
+    clock := at.Format("15:04")
+    query.Where("id IN (SELECT restaurant_id FROM {{prefix}}restaurant_hours WHERE "+
+        "(weekday = ? AND opens <= ? AND (closes > ? OR closes <= opens)) OR "+
+        "(weekday = ? AND closes <= opens AND closes > ?))",
+        weekday, clock, clock, previous, clock)
+}
+
+// restaurantHoursBody - тело PUT /restaurants/{id}/hours
+type restaurantHoursBody struct {
+    Hours []ListingHours `json:"hours"`
+}
+
+// serveRestaurantHours отдает часы работы ресторана (GET), заменяет их (PUT) или удаляет (DELETE)
+// и отвечает часами работы после изменения. Пользователь, вошедший через -auth, меняет часы только
+// своего ресторана, если он не администратор
+func (db *Database) serveRestaurantHours(w http.ResponseWriter, r *http.Request) {
+    restaurantID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok && (r.Method == http.MethodPut || r.Method == http.MethodDelete) {
+        if err := db.authorizeRestaurantID(actor, restaurantID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    var hours []ListingHours
+    switch r.Method {
+    case http.MethodPut:
+        var body restaurantHoursBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        hours, err = db.SetRestaurantHours(restaurantID, body.Hours)
+    case http.MethodDelete:
+        hours, err = db.SetRestaurantHours(restaurantID, nil)
+    default:
+        hours, err = db.RestaurantHours(restaurantID)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if hours == nil {
+        hours = []ListingHours{}
+    }
+    writeJSON(w, http.StatusOK, hours)
+}
@@ -0,0 +1,237 @@
+package httpapi
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// MinTokenSecret - минимальная длина ключа подписи токенов в байтах: для HS256 не меньше размера хеша
+const MinTokenSecret = 32
+
+// ErrInvalidToken возвращается ParseToken для токена с неверной подписью, форматом или сроком
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// AuthOptions настраивают Authenticator
+type AuthOptions struct {
+    // Secret - ключ подписи токенов HS256, не короче MinTokenSecret
+    Secret []byte
+    // TTL - срок действия выданного токена; 0 - час
+    TTL time.Duration
+    // LoginPath - путь, на который POST с {"email": ..., "password": ...} получает токен; "" - /login
+    LoginPath string
+    // Login проверяет email и пароль и возвращает ID пользователя; "" без ошибки - учетные данные
+    // не подошли, ошибка - проверка не удалась (ответ 500)
+    Login func(r *http.Request, email, password string) (string, error)
+    // Token проверяет токен Bearer, который не является JWT этого слоя (например, токен сессии),
+    // и возвращает ID пользователя так же, как Login; nil - такие токены не принимаются
+    Token func(r *http.Request, token string) (string, error)
+    // Public сообщает, что запрос обслуживается и без аутентификации; nil - она нужна всем запросам
+    Public func(r *http.Request) bool
+}
+
+// Claims - утверждения токена: пользователь и срок действия в секундах Unix
+type Claims struct {
+    Subject   string `json:"sub"`
+    IssuedAt  int64  `json:"iat"`
+    ExpiresAt int64  `json:"exp"`
+}
+
+// tokenHeader - заголовок JWT; других алгоритмов, кроме HS256, токены не принимают
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// IssueToken выдает JWT (HS256) пользователю subject, действующий ttl с момента now
+func IssueToken(secret []byte, subject string, ttl time.Duration, now time.Time) (string, error) {
+    if len(secret) < MinTokenSecret {
+        return "", fmt.Errorf("token secret must be at least %d bytes", MinTokenSecret)
+    }
+    claims, err := json.Marshal(Claims{Subject: subject, IssuedAt: now.Unix(), ExpiresAt: now.Add(ttl).Unix()})
+    if err != nil {
+        return "", err
+    }
+    unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+    return unsigned + "." + signToken(secret, unsigned), nil
+}
+
+// ParseToken проверяет подпись и срок действия токена, выданного IssueToken, и возвращает его утверждения
+func ParseToken(secret []byte, token string, now time.Time) (Claims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 || parts[0] != tokenHeader {
+        return Claims{}, ErrInvalidToken
+    }
+    if !hmac.Equal([]byte(parts[2]), []byte(signToken(secret, parts[0]+"."+parts[1]))) {
+        return Claims{}, ErrInvalidToken
+    }
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil {
+        return Claims{}, ErrInvalidToken
+    }
+    var claims Claims
+    if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" || now.Unix() >= claims.ExpiresAt {
+        return Claims{}, ErrInvalidToken
+    }
+    return claims, nil
+}
+
+// signToken возвращает подпись HS256 заголовка и утверждений токена
+func signToken(secret []byte, unsigned string) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(unsigned))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// userKey - ключ пользователя запроса в контексте
+type userKey struct{}
+
+// ContextWithUser возвращает контекст с аутентифицированным пользователем запроса
+func ContextWithUser(ctx context.Context, user string) context.Context {
+    return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext возвращает пользователя, которого аутентифицировал Authenticator, для проверок
+// владельца в обработчиках; ok == false для анонимного запроса
+func UserFromContext(ctx context.Context) (user string, ok bool) {
+    user, ok = ctx.Value(userKey{}).(string)
+    return user, ok && user != ""
+}
+
+// BearerUser возвращает функцию, которая достает пользователя из действующего токена в заголовке
+// Authorization: Bearer, или "" (см. RateLimitOptions.User: ограничитель стоит перед Authenticator)
+func BearerUser(secret []byte) func(r *http.Request) string {
+    return func(r *http.Request) string {
+        token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok {
+            return ""
+        }
+        claims, err := ParseToken(secret, strings.TrimSpace(token), time.Now())
+        if err != nil {
+            return ""
+        }
+        return claims.Subject
+    }
+}
+
+// loginRequest - тело POST /login
+type loginRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+}
+
+// loginResponse - выданный токен
+type loginResponse struct {
+    Token     string    `json:"token"`
+    TokenType string    `json:"token_type"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Authenticator возвращает промежуточный слой аутентификации. POST на LoginPath проверяет email и пароль
+// через Login и отвечает JWT; остальные запросы передают его в заголовке Authorization: Bearer <токен>
+// (или другой токен, который принимает Token), или email и пароль в Authorization: Basic (проверяются через Login на каждом запросе, это медленнее).
+// Пользователь запроса попадает в контекст (см. UserFromContext). Запрос без действующих учетных
+// данных получает 401 с WWW-Authenticate и до обработчика не доходит, если только он не Public:
+// тогда он обслуживается анонимно. Неверный токен отвергается и у Public запросов
+func Authenticator(options AuthOptions) func(http.Handler) http.Handler {
+    if len(options.Secret) < MinTokenSecret {
+        panic(fmt.Sprintf("httpapi: token secret must be at least %d bytes", MinTokenSecret))
+    }
+    ttl := options.TTL
+    if ttl <= 0 {
+        ttl = time.Hour
+    }
+    loginPath := options.LoginPath
+    if loginPath == "" {
+        loginPath = "/login"
+    }
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.URL.Path == loginPath {
+                if r.Method != http.MethodPost {
+                    w.Header().Set("Allow", http.MethodPost)
+                    writeErrorBody(w, http.StatusMethodNotAllowed, "method not allowed")
+                    return
+                }
+                serveLogin(w, r, options, ttl)
+                return
+            }
+
+            user, err := requestUser(r, options)
+            switch {
+            case err != nil:
+                log.Printf("http: authentication: %v", err)
+                writeErrorBody(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+                return
+            case user == "" && (r.Header.Get("Authorization") != "" || options.Public == nil || !options.Public(r)):
+                w.Header().Set("WWW-Authenticate", `Bearer realm="api", Basic realm="api"`)
+                writeErrorBody(w, http.StatusUnauthorized, "authentication required")
+                return
+            case user != "":
+                r = r.WithContext(ContextWithUser(r.Context(), user))
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// requestUser возвращает пользователя из заголовка Authorization или "", если заголовка нет
+// или учетные данные не подошли
+func requestUser(r *http.Request, options AuthOptions) (string, error) {
+    header := r.Header.Get("Authorization")
+    if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+        token = strings.TrimSpace(token)
+        claims, err := ParseToken(options.Secret, token, time.Now())
+        if err == nil {
+            return claims.Subject, nil
+        }
+        if options.Token != nil && token != "" {
+            return options.Token(r, token)
+        }
+        return "", nil
+    }
+    if email, password, ok := r.BasicAuth(); ok && options.Login != nil {
+        return options.Login(r, email, password)
+    }
+    return "", nil
+}
+
+// serveLogin проверяет email и пароль из тела и выдает токен
+func serveLogin(w http.ResponseWriter, r *http.Request, options AuthOptions, ttl time.Duration) {
+    var body loginRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil || body.Email == "" || body.Password == "" {
+        writeErrorBody(w, http.StatusBadRequest, "body must be a JSON object with email and password")
+        return
+    }
+    if options.Login == nil {
+        writeErrorBody(w, http.StatusNotFound, "login is not configured")
+        return
+    }
+    user, err := options.Login(r, body.Email, body.Password)
+    if err != nil {
+        log.Printf("http: login: %v", err)
+        writeErrorBody(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+        return
+    }
+    if user == "" {
+        writeErrorBody(w, http.StatusUnauthorized, "invalid email or password")
+        return
+    }
+
+    now := time.Now()
+    token, err := IssueToken(options.Secret, user, ttl, now)
+    if err != nil {
+        log.Printf("http: login: %v", err)
+        writeErrorBody(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(loginResponse{Token: token, TokenType: "Bearer", ExpiresAt: now.Add(ttl).UTC().Truncate(time.Second)})
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+    "encoding/base64"
+    "errors"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestParseToken(t *testing.T) {
+    now := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+    token, err := IssueToken(testSecret, "42", time.Hour, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    parts := strings.Split(token, ".")
+
+    // resign подписывает заголовок и утверждения ключом теста, как IssueToken
+    resign := func(header, claims string) string {
+        unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+        return unsigned + "." + signToken(testSecret, unsigned)
+    }
+
+    tests := []struct {
+        name  string
+        token string
+        at    time.Time
+        // valid - токен принимается с утверждениями sub=42
+        valid bool
+    }{
+        {"valid", token, now, true},
+        {"valid until expiry", token, now.Add(time.Hour - time.Second), true},
+        {"expired", token, now.Add(time.Hour), false},
+        {"bad signature", parts[0] + "." + parts[1] + "." + signToken([]byte("another-secret-0123456789abcdefg"), parts[0]+"."+parts[1]), now, false},
+        {"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":0,"exp":9999999999}`)) + "." + parts[2], now, false},
+        {"truncated signature", parts[0] + "." + parts[1] + "." + parts[2][:10], now, false},
+        {"alg none", resign(`{"alg":"none","typ":"JWT"}`, `{"sub":"42","iat":0,"exp":9999999999}`), now, false},
+        {"other algorithm", resign(`{"alg":"HS512","typ":"JWT"}`, `{"sub":"42","iat":0,"exp":9999999999}`), now, false},
+        {"same header with spaces", resign(`{"alg": "HS256", "typ": "JWT"}`, `{"sub":"42","iat":0,"exp":9999999999}`), now, false},
+        {"no subject", resign(`{"alg":"HS256","typ":"JWT"}`, `{"iat":0,"exp":9999999999}`), now, false},
+        {"claims are not JSON", resign(`{"alg":"HS256","typ":"JWT"}`, `sub=42`), now, false},
+        {"two parts", parts[0] + "." + parts[1], now, false},
+        {"empty", "", now, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            claims, err := ParseToken(testSecret, tt.token, tt.at)
+            if !tt.valid {
+                if !errors.Is(err, ErrInvalidToken) {
+                    t.Errorf("ParseToken = %+v, %v, want ErrInvalidToken", claims, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if claims.Subject != "42" || claims.IssuedAt != now.Unix() || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+                t.Errorf("ParseToken = %+v, want sub 42 issued at %d for an hour", claims, now.Unix())
+            }
+        })
+    }
+}
+
+// TestBearerUser проверяет, что пользователь берется только из действующего токена в заголовке
+// Authorization: Bearer
+func TestBearerUser(t *testing.T) {
+    token, err := IssueToken(testSecret, "42", time.Hour, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    expired, err := IssueToken(testSecret, "42", time.Hour, time.Now().Add(-2*time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name   string
+        header string
+        want   string
+    }{
+        {"bearer", "Bearer " + token, "42"},
+        {"extra spaces", "Bearer  " + token + " ", "42"},
+        {"expired", "Bearer " + expired, ""},
+        {"lowercase scheme", "bearer " + token, ""},
+        {"basic", "Basic " + base64.StdEncoding.EncodeToString([]byte("owner@example.com:secret")), ""},
+        {"token without scheme", token, ""},
+        {"no header", "", ""},
+    }
+    user := BearerUser(testSecret)
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest("GET", "/restaurants", nil)
+            if tt.header != "" {
+                r.Header.Set("Authorization", tt.header)
+            }
+            if got := user(r); got != tt.want {
+                t.Errorf("BearerUser = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIssueTokenShortSecret(t *testing.T) {
+    if _, err := IssueToken([]byte("short"), "42", time.Hour, time.Now()); err == nil {
+        t.Errorf("IssueToken accepted a %d-byte secret, want at least %d", len("short"), MinTokenSecret)
+    }
+}
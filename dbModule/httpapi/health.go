@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// DefaultProbeTimeout - сколько проба ждет свои проверки, если Probe получил timeout <= 0;
+// меньше timeoutSeconds пробы Kubernetes по умолчанию, чтобы ответ успел дойти до kubelet
+const DefaultProbeTimeout = 800 * time.Millisecond
+
+// Check - проверка одной зависимости сервера для проб Kubernetes (см. Probe)
+type Check struct {
+    Name string
+    // Run возвращает подробности о состоянии зависимости, например глубину очереди, и ошибку,
+    // если зависимость не в порядке
+    Run func(ctx context.Context) (string, error)
+}
+
+// CheckResult - результат одной проверки в ответе пробы
+type CheckResult struct {
+    Name       string  `json:"name"`
+    // Status - ok или fail
+    Status     string  `json:"status"`
+    Detail     string  `json:"detail,omitempty"`
+    Error      string  `json:"error,omitempty"`
+    DurationMS float64 `json:"duration_ms"`
+}
+
+// ProbeResult - тело ответа пробы: ok, только если прошли все проверки
+type ProbeResult struct {
+    Status string        `json:"status"`
+    Checks []CheckResult `json:"checks"`
+}
+
+// Probe возвращает обработчик пробы, например /healthz или /readyz: проверки выполняются параллельно,
+// каждая не дольше timeout, и ответ - ProbeResult с результатами в порядке checks, 200, если все
+// прошли, иначе 503. Проверка, не успевшая за timeout, считается неудачной; ее контекст отменяется
+func Probe(timeout time.Duration, checks ...Check) http.Handler {
+    if timeout <= 0 {
+        timeout = DefaultProbeTimeout
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), timeout)
+        defer cancel()
+
+        type outcome struct {
+            detail string
+            err    error
+            took   time.Duration
+        }
+        outcomes := make([]chan outcome, len(checks))
+        for i, check := range checks {
+            outcomes[i] = make(chan outcome, 1)
+            go func(done chan<- outcome) {
+                start := time.Now()
+                detail, err := check.Run(ctx)
+                done <- outcome{detail: detail, err: err, took: time.Since(start)}
+            }(outcomes[i])
+        }
+
+        result := ProbeResult{Status: "ok", Checks: make([]CheckResult, len(checks))}
+        for i, check := range checks {
+            var o outcome
+            select {
+            case o = <-outcomes[i]:
+            case <-ctx.Done():
+                // проверка могла ответить одновременно с таймаутом
+                select {
+                case o = <-outcomes[i]:
+                default:
+                    o = outcome{err: ctx.Err(), took: timeout}
+                }
+            }
+            checked := CheckResult{Name: check.Name, Status: "ok", Detail: o.detail, DurationMS: float64(o.took.Microseconds()) / 1000}
+            if o.err != nil {
+                checked.Status, checked.Error = "fail", o.err.Error()
+                result.Status = "fail"
+            }
+            result.Checks[i] = checked
+        }
+
+        status := http.StatusOK
+        if result.Status != "ok" {
+            status = http.StatusServiceUnavailable
+        }
+        header := w.Header()
+        header.Set("Content-Type", "application/json")
+        // пробы должны видеть текущее состояние, а не ответ прокси
+        header.Set("Cache-Control", "no-store")
+        w.WriteHeader(status)
+        json.NewEncoder(w).Encode(result)
+    })
+}
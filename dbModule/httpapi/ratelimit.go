@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+    "encoding/json"
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// RateLimit - корзина токенов: запрос забирает токен, токены прибывают со скоростью Rate в секунду,
+// в корзине помещается Burst токенов. Rate <= 0 - без ограничения
+type RateLimit struct {
+    Rate  float64
+    Burst int
+}
+
+// RateLimitOptions настраивают RateLimiter
+type RateLimitOptions struct {
+    // PerIP ограничивает анонимные запросы с одного адреса клиента
+    PerIP RateLimit
+    // PerUser ограничивает запросы одного пользователя, с какого бы адреса они ни шли
+    PerUser RateLimit
+    // User возвращает пользователя запроса или "" для анонимного; nil - все запросы анонимные
+    User func(r *http.Request) string
+    // TrustForwardedFor - брать адрес клиента из последнего адреса X-Forwarded-For, а не из
+    // соединения; включается только за своим обратным прокси, иначе клиент подделает адрес
+    TrustForwardedFor bool
+    // IdleTTL - через сколько без запросов забывается корзина ключа; 0 - 10 минут
+    IdleTTL time.Duration
+}
+
+// errorBody - тело ответа с ошибкой, как у ошибок HTTP API модуля
+type errorBody struct {
+    Error string `json:"error"`
+}
+
+// writeErrorBody отвечает кодом status и телом errorBody
+func writeErrorBody(w http.ResponseWriter, status int, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(errorBody{Error: message})
+}
+
+// bucket - корзина токенов одного пользователя или адреса
+type bucket struct {
+    tokens float64
+    seen   time.Time
+}
+
+// limiter - корзины одного вида ключей (адреса или пользователи)
+type limiter struct {
+    limit   RateLimit
+    mu      sync.Mutex
+    buckets map[string]*bucket
+    swept   time.Time
+}
+
+// take забирает токен из корзины key и возвращает, удалось ли, сколько токенов осталось
+// и через сколько корзина снова будет полной (или появится токен, если его не было)
+func (l *limiter) take(key string, now time.Time, idle time.Duration) (bool, int, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if now.Sub(l.swept) > idle {
+        // корзины без запросов дольше idle уже полные, забыть их - то же, что оставить
+        for k, b := range l.buckets {
+            if now.Sub(b.seen) > idle {
+                delete(l.buckets, k)
+            }
+        }
+        l.swept = now
+    }
+
+    burst := float64(l.limit.Burst)
+    if burst < 1 {
+        burst = 1
+    }
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: burst, seen: now}
+        l.buckets[key] = b
+    }
+    b.tokens = math.Min(burst, b.tokens+now.Sub(b.seen).Seconds()*l.limit.Rate)
+    b.seen = now
+    if b.tokens < 1 {
+        return false, 0, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+    }
+    b.tokens--
+    return true, int(b.tokens), time.Duration((burst - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// RateLimiter возвращает промежуточный слой, ограничивающий частоту запросов корзиной токенов:
+// запросы пользователя (см. RateLimitOptions.User) - по PerUser, анонимные - по PerIP адреса клиента.
+// Каждый ответ несет заголовки X-RateLimit-Limit (размер корзины), X-RateLimit-Remaining
+// и X-RateLimit-Reset (секунд до полной корзины); запрос сверх ограничения получает 429
+// с Retry-After и до обработчика не доходит
+func RateLimiter(options RateLimitOptions) func(http.Handler) http.Handler {
+    idle := options.IdleTTL
+    if idle <= 0 {
+        idle = 10 * time.Minute
+    }
+    perIP := &limiter{limit: options.PerIP, buckets: make(map[string]*bucket)}
+    perUser := &limiter{limit: options.PerUser, buckets: make(map[string]*bucket)}
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            l, key := perIP, "ip:"+clientIP(r, options.TrustForwardedFor)
+            if options.User != nil {
+                if user := options.User(r); user != "" {
+                    l, key = perUser, "user:"+user
+                }
+            }
+            if l.limit.Rate <= 0 {
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            allowed, remaining, reset := l.take(key, time.Now(), idle)
+            header := w.Header()
+            header.Set("X-RateLimit-Limit", strconv.Itoa(max(l.limit.Burst, 1)))
+            header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+            header.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+            if !allowed {
+                header.Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+                writeErrorBody(w, http.StatusTooManyRequests, "rate limit exceeded")
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// clientIP возвращает адрес клиента без порта: из соединения или, с trustForwarded, последний
+// адрес X-Forwarded-For - его добавил ближайший к серверу прокси
+func clientIP(r *http.Request, trustForwarded bool) string {
+    if forwarded := r.Header.Get("X-Forwarded-For"); trustForwarded && forwarded != "" {
+        hops := strings.Split(forwarded, ",")
+        return strings.TrimSpace(hops[len(hops)-1])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
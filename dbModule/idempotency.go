@@ -0,0 +1,91 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// IdempotencyKeyTTL - сколько хранится ключ идемпотентности; повтор после этого срока создаст новую запись
+var IdempotencyKeyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength - длина колонки idempotency_key
+const maxIdempotencyKeyLength = 255
+
+// WithIdempotencyKey возвращает копию Database, вставки пользователей и ресторанов которой
+// (InsertUser, InsertRestaurant) выполняются не больше одного раза на ключ key: повтор с тем же
+// ключом и теми же данными возвращает уже созданную запись, с другими данными - ErrConflict.
+// Так клиент может безопасно повторить запрос, ответ на который потерялся. Ключ действует только
+// на вставки вне транзакции: внутри InTx повтор откатывается вместе со всей транзакцией
+func (db *Database) WithIdempotencyKey(key string) *Database {
+    scoped := *db
+    scoped.idempotencyKey = key
+    return &scoped
+}
+
+// idempotentInsert выполняет вставку insert операции op не больше одного раза на ключ копии.
+// request - данные вставки: их хеш сравнивается при повторе. Ключ записывается в той же
+// транзакции, что и вставка, поэтому неудачная вставка его не занимает
+func (db *Database) idempotentInsert(op string, request interface{}, insert func(tx *Database) (int64, error)) (int64, error) {
+    key := db.idempotencyKey
+    if len(key) > maxIdempotencyKeyLength {
+        return 0, &ValidationError{Field: "idempotency key", Message: fmt.Sprintf("must be at most %d characters", maxIdempotencyKeyLength)}
+    }
+    data, err := json.Marshal(request)
+    if err != nil {
+        return 0, err
+    }
+    sum := sha256.Sum256(data)
+    hash := hex.EncodeToString(sum[:])
+
+    var id int64
+    err = db.InTx(func(tx *Database) error {
+        tx.idempotencyKey = ""
+        now := tx.now().UTC()
+        rows, err := tx.queryNamed("idempotency_keys.select_valid", tx.tenant, key, now)
+        if err != nil {
+            return err
+        }
+        var storedOp, storedHash string
+        found := rows.Next()
+        if found {
+            err = rows.Scan(&storedOp, &storedHash, &id)
+        }
+        if closeErr := rows.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            return err
+        }
+        if found {
+            if storedOp != op || storedHash != hash {
+                return fmt.Errorf("idempotency key %q was used for a different request: %w", key, ErrConflict)
+            }
+            return nil
+        }
+
+        if _, err := tx.execNamed("idempotency_keys.delete_expired_key", tx.tenant, key, now); err != nil {
+            return err
+        }
+        if id, err = insert(tx); err != nil {
+            return err
+        }
+        _, err = tx.execNamed("idempotency_keys.insert", tx.tenant, key, op, hash, id, now, now.Add(IdempotencyKeyTTL))
+        if tx.driver.isUniqueError(err) {
+            return fmt.Errorf("a request with idempotency key %q is already in progress: %w", key, ErrConflict)
+        }
+        return err
+    })
+    return id, err
+}
+
+// DeleteExpiredIdempotencyKeys удаляет просроченные ключи идемпотентности и возвращает их число
+func (db *Database) DeleteExpiredIdempotencyKeys() (int64, error) {
+    result, err := db.execNamed("idempotency_keys.delete_expired", db.now().UTC())
+    if err != nil {
+        return 0, db.opError("delete expired", "idempotency keys", nil, err)
+    }
+    return result.RowsAffected()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "regexp"
+    "time"
+)
+
+// Схемы глобальных идентификаторов public_id (см. Config.IDScheme)
+const (
+    IDSchemeUUID = "uuid"
+    IDSchemeULID = "ulid"
+)
+
+// crockfordAlphabet - алфавит base32 Крокфорда, которым записывается ULID
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+    uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+    ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+// newPublicID создает идентификатор схемы scheme, упорядоченный по времени создания: UUID версии 7
+// (RFC 9562) в нижнем регистре или ULID. Старшие 48 бит - миллисекунды now, остальные случайны,
+// поэтому идентификаторы, созданные независимо на разных узлах, не совпадают
+func newPublicID(scheme string, now time.Time) (string, error) {
+    var id [16]byte
+    if _, err := rand.Read(id[6:]); err != nil {
+        return "", err
+    }
+    ms := uint64(now.UnixMilli())
+    id[0], id[1], id[2], id[3], id[4], id[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+
+    switch scheme {
+    case IDSchemeUUID:
+        id[6] = 0x70 | id[6]&0x0f
+        id[8] = 0x80 | id[8]&0x3f
+        text := hex.EncodeToString(id[:])
+        return text[:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:], nil
+    case IDSchemeULID:
+        hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+        var text [26]byte
+        for i := len(text) - 1; i >= 0; i-- {
+            text[i] = crockfordAlphabet[lo&31]
+            lo = lo>>5 | hi<<59
+            hi >>= 5
+        }
+        return string(text[:]), nil
+    }
+    return "", fmt.Errorf("unknown ID scheme %q (expected uuid or ulid)", scheme)
+}
+
+// validPublicID сообщает, что id пуст или записан как UUID в нижнем регистре либо как ULID
+func validPublicID(id string) bool {
+    return id == "" || uuidPattern.MatchString(id) || ulidPattern.MatchString(id)
+}
+
+// validIDScheme проверяет значение Config.IDScheme
+func validIDScheme(scheme string) error {
+    switch scheme {
+    case "", IDSchemeUUID, IDSchemeULID:
+        return nil
+    }
+    return fmt.Errorf("unknown ID scheme %q (expected uuid or ulid)", scheme)
+}
+
+// publicIDValue возвращает значение колонки public_id новой записи: переданный идентификатор
+// (созданный, например, клиентом без связи с базой), новый по Config.IDScheme или NULL без схемы
+func (db *Database) publicIDValue(id string) (interface{}, error) {
+    if id != "" {
+        return id, nil
+    }
+    if db.idScheme == "" {
+        return nil, nil
+    }
+    return newPublicID(db.idScheme, db.now())
+}
+
+// GetUserByPublicID возвращает пользователя площадки по public_id или ErrNotFound
+func (db *Database) GetUserByPublicID(publicID string) (User, error) {
+    user, err := db.findUser("users.select_by_public_id", publicID, db.tenant)
+    if err == nil && user == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return User{}, db.opError("get", "user", publicID, err)
+    }
+    return *user, nil
+}
+
+// GetRestaurantByPublicID возвращает ресторан площадки по public_id или ErrNotFound
+func (db *Database) GetRestaurantByPublicID(publicID string) (Restaurant, error) {
+    restaurant, err := db.findRestaurant("restaurants.select_by_public_id", publicID, db.tenant)
+    if err == nil && restaurant == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return Restaurant{}, db.opError("get", "restaurant", publicID, err)
+    }
+    return *restaurant, nil
+}
+
+// AssignPublicIDs заполняет public_id пользователей и ресторанов всех площадок, добавленных до
+// включения Config.IDScheme. Версии строк и журнал аудита не меняются. Возвращает число строк
+func (db *Database) AssignPublicIDs() (int, error) {
+    if db.idScheme == "" {
+        return 0, fmt.Errorf("%w: no ID scheme is configured (see -id-scheme)", ErrValidation)
+    }
+    assigned := 0
+    err := db.InTx(func(tx *Database) error {
+        for _, table := range []string{"users", "restaurants"} {
+            rows, err := tx.queryNamed(table + ".select_without_public_id")
+            if err != nil {
+                return err
+            }
+            var ids []int
+            for rows.Next() {
+                var id int
+                if err := rows.Scan(&id); err != nil {
+                    rows.Close()
+                    return err
+                }
+                ids = append(ids, id)
+            }
+            if err := rows.Close(); err != nil {
+                return err
+            }
+
+            for _, id := range ids {
+                publicID, err := newPublicID(tx.idScheme, tx.now())
+                if err != nil {
+                    return err
+                }
+                if _, err := tx.execNamed(table+".set_public_id", publicID, id); err != nil {
+                    return fmt.Errorf("%s %d: %w", table, id, err)
+                }
+                assigned++
+            }
+        }
+        return nil
+    })
+    return assigned, db.opError("assign public ids", "users and restaurants", nil, err)
+}
+
+// runPublicIDs выполняет команду public-ids
+func runPublicIDs(db *Database, args []string) error {
+    flags := flag.NewFlagSet("public-ids", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    assigned, err := db.AssignPublicIDs()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Assigned public IDs to %d rows\n", assigned)
+    return nil
+}
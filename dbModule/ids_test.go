@@ -0,0 +1,72 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+// openWithIDScheme открывает копию мигрированной тестовой базы с Config.IDScheme scheme
+func openWithIDScheme(t *testing.T, scheme string) *Database {
+    t.Helper()
+
+    seed := NewIsolatedTestDatabase(t)
+    path := filepath.Join(t.TempDir(), "node.db")
+    if err := seed.Backup(path); err != nil {
+        t.Fatal(err)
+    }
+    config := DefaultConfig(path)
+    config.IDScheme = scheme
+    db, err := NewDatabaseWithConfig(config, seed.queries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+// TestPublicIDsDoNotConflict проверяет, что строки, созданные независимо в двух базах, получают разные
+// public_id, хотя их целые ID совпадают, и что строку одной базы можно перенести в другую с ее public_id
+func TestPublicIDsDoNotConflict(t *testing.T) {
+    for _, scheme := range []string{IDSchemeUUID, IDSchemeULID} {
+        t.Run(scheme, func(t *testing.T) {
+            first, second := openWithIDScheme(t, scheme), openWithIDScheme(t, scheme)
+            var created [2]User
+            for i, db := range []*Database{first, second} {
+                user := User{Name: "Ivan", Lastname: "Offline", Email: "ivan@example.com", Password: "Public-Passw0rd!"}
+                id, err := db.InsertUserReturningID(&user)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if created[i], err = db.GetUserByID(id); err != nil {
+                    t.Fatal(err)
+                }
+                if !validPublicID(created[i].PublicID) || created[i].PublicID == "" {
+                    t.Fatalf("user %d has public_id %q, want a %s", id, created[i].PublicID, scheme)
+                }
+            }
+            if created[0].ID != created[1].ID {
+                t.Fatalf("independent databases gave IDs %d and %d, want the same autoincrement ID", created[0].ID, created[1].ID)
+            }
+            if created[0].PublicID == created[1].PublicID {
+                t.Fatalf("independent databases gave the same public_id %q", created[0].PublicID)
+            }
+
+            // перенос строки второй базы в первую: целый ID назначает первая, public_id сохраняется
+            moved := User{Name: created[1].Name, Lastname: created[1].Lastname, Email: "moved@example.com", Password: "Public-Passw0rd!", PublicID: created[1].PublicID}
+            if _, err := first.InsertUserReturningID(&moved); err != nil {
+                t.Fatal(err)
+            }
+            found, err := first.GetUserByPublicID(created[1].PublicID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if found.ID == created[0].ID {
+                t.Errorf("moved user got ID %d of the local user", found.ID)
+            }
+            local, err := first.GetUserByPublicID(created[0].PublicID)
+            if err != nil || local.ID != created[0].ID {
+                t.Errorf("local user by public_id = %d, %v, want %d", local.ID, err, created[0].ID)
+            }
+        })
+    }
+}
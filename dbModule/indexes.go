@@ -0,0 +1,225 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "strings"
+)
+
+// IndexDefinition - индекс таблицы: объявленный в indexMigrations или прочитанный из базы (см. ListIndexes)
+type IndexDefinition struct {
+    // Table и Name - имена без префикса Config.TablePrefix в объявлениях и с ним в ListIndexes
+    Table   string   `json:"table"`
+    Name    string   `json:"name"`
+    Columns []string `json:"columns"`
+    Unique  bool     `json:"unique"`
+}
+
+// indexMigration - миграция схемы, создающая объявленные индексы, которых еще нет в базе
+type indexMigration struct {
+    ID      string
+    Indexes []IndexDefinition
+}
+
+// indexMigrations - миграции индексов, упорядоченные вместе с миграциями из schema.yaml по ID.
+// Индекс, уже созданный под тем же именем (прежней миграцией или вручную), не пересоздается
+var indexMigrations = []indexMigration{
+    {
+        // выборки по фильтрам и соединения с пользователями на больших площадках
+        ID: "0031_filter_indexes",
+        Indexes: []IndexDefinition{
+            // создан миграциями 0005 и 0010 (в MSSQL - только для заданных email)
+            {Table: "users", Name: "users_email_key", Columns: []string{"tenant_id", "email"}, Unique: true},
+            {Table: "restaurants", Name: "restaurants_user", Columns: []string{"tenant_id", "user_id"}},
+            {Table: "restaurants", Name: "restaurants_type", Columns: []string{"tenant_id", "type"}},
+        },
+    },
+}
+
+// filterColumns - колонки, по которым фильтруют и соединяют выборки; без индекса по ним запросы
+// заметно замедляются уже на ~100 тыс. строк (см. UnindexedColumns)
+var filterColumns = map[string][]string{
+    "users":            {"email", "role"},
+    "restaurants":      {"user_id", "type", "name"},
+    "reviews":          {"restaurant_id"},
+    "menu_items":       {"restaurant_id"},
+    "restaurant_hours": {"restaurant_id"},
+    "restaurant_tags":  {"tag"},
+}
+
+// createIndexSQL составляет CREATE INDEX для текущего диалекта с префиксом таблиц
+func (db *Database) createIndexSQL(index IndexDefinition) string {
+    create := "CREATE INDEX"
+    if index.Unique {
+        create = "CREATE UNIQUE INDEX"
+    }
+    return fmt.Sprintf("%s %s ON %s (%s)", create, db.table(index.Name), db.table(index.Table), strings.Join(index.Columns, ", "))
+}
+
+// indexSchemaMigrations превращает объявления indexMigrations в миграции схемы. Done сообщает, что все
+// индексы уже есть, Apply создает недостающие; SQL показывает все CREATE INDEX (см. DryRunMigrate)
+func (db *Database) indexSchemaMigrations() []Migration {
+    var all []Migration
+    for _, im := range indexMigrations {
+        indexes := im.Indexes
+        var statements []string
+        for _, index := range indexes {
+            statements = append(statements, db.createIndexSQL(index))
+        }
+        missing := func(tx *sql.Tx) ([]IndexDefinition, error) {
+            var result []IndexDefinition
+            for _, index := range indexes {
+                existing, err := db.tableIndexes(context.Background(), tx, db.table(index.Table))
+                if err != nil {
+                    return nil, err
+                }
+                found := false
+                for _, e := range existing {
+                    found = found || strings.EqualFold(e.Name, db.table(index.Name))
+                }
+                if !found {
+                    result = append(result, index)
+                }
+            }
+            return result, nil
+        }
+        all = append(all, Migration{
+            ID:   im.ID,
+            Kind: migrationKindSchema,
+            SQL:  strings.Join(statements, "; "),
+            Done: func(tx *sql.Tx) (bool, error) {
+                indexes, err := missing(tx)
+                return len(indexes) == 0, err
+            },
+            Apply: func(tx *sql.Tx) error {
+                indexes, err := missing(tx)
+                if err != nil {
+                    return err
+                }
+                for _, index := range indexes {
+                    if _, err := tx.Exec(db.createIndexSQL(index)); err != nil {
+                        return fmt.Errorf("creating index %s: %w", db.table(index.Name), err)
+                    }
+                }
+                return nil
+            },
+        })
+    }
+    return all
+}
+
+// tableIndexes читает индексы таблицы table (с префиксом) через introspection.indexes
+func (db *Database) tableIndexes(ctx context.Context, q sqlExecer, table string) ([]IndexDefinition, error) {
+    query, err := db.lookupQuery("introspection.indexes")
+    if err != nil {
+        return nil, err
+    }
+    rows, err := q.QueryContext(ctx, db.driver.dialect.Rebind(query), table)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var indexes []IndexDefinition
+    for rows.Next() {
+        index := IndexDefinition{Table: table}
+        var unique int
+        var columns string
+        if err := rows.Scan(&index.Name, &unique, &columns); err != nil {
+            return nil, err
+        }
+        index.Unique = unique != 0
+        for _, column := range strings.Split(columns, ",") {
+            index.Columns = append(index.Columns, strings.ToLower(strings.TrimSpace(column)))
+        }
+        indexes = append(indexes, index)
+    }
+    return indexes, rows.Err()
+}
+
+// ListIndexes возвращает индексы всех таблиц этого экземпляра, включая индексы первичных ключей
+// и ограничений UNIQUE, по таблицам и именам индексов
+func (db *Database) ListIndexes() ([]IndexDefinition, error) {
+    tables, err := db.introspectStrings("introspection.tables")
+    if err != nil {
+        return nil, db.opError("list", "indexes", nil, err)
+    }
+    ctx, cancel := db.operationContext(operationRead)
+    defer cancel()
+    var indexes []IndexDefinition
+    for _, table := range db.ownTables(tables) {
+        tableIndexes, err := db.tableIndexes(ctx, db.DB, table)
+        if err != nil {
+            return nil, db.opError("list", "indexes", table, err)
+        }
+        indexes = append(indexes, tableIndexes...)
+    }
+    return indexes, nil
+}
+
+// UnindexedColumn - колонка из filterColumns, которой не начинается ни один индекс ее таблицы
+type UnindexedColumn struct {
+    Table  string
+    Column string
+}
+
+func (c UnindexedColumn) String() string {
+    return c.Table + "." + c.Column
+}
+
+// UnindexedColumns возвращает колонки, по которым фильтруют выборки, но которые не покрыты индексом
+// основной базы. Колонка покрыта, если индекс начинается с нее или с tenant_id и нее: все выборки
+// ограничены площадкой. Таблицы, которых нет в базе, пропускаются
+func (db *Database) UnindexedColumns() ([]UnindexedColumn, error) {
+    primary := db.WithPrimary()
+    names, err := primary.introspectStrings("introspection.tables")
+    if err != nil {
+        return nil, db.opError("check", "indexes", nil, err)
+    }
+    tables := make(map[string]bool)
+    for _, name := range names {
+        tables[strings.ToLower(name)] = true
+    }
+    indexes, err := primary.ListIndexes()
+    if err != nil {
+        return nil, err
+    }
+    covered := make(map[string]bool)
+    for _, index := range indexes {
+        table := strings.ToLower(index.Table)
+        for i, column := range index.Columns {
+            if i > 1 || (i == 1 && index.Columns[0] != "tenant_id") {
+                break
+            }
+            covered[table+"."+column] = true
+        }
+    }
+
+    var unindexed []UnindexedColumn
+    for _, table := range sortedKeys(filterColumns) {
+        name := strings.ToLower(db.table(table))
+        if !tables[name] {
+            continue
+        }
+        for _, column := range filterColumns[table] {
+            if !covered[name+"."+column] {
+                unindexed = append(unindexed, UnindexedColumn{Table: db.table(table), Column: column})
+            }
+        }
+    }
+    return unindexed, nil
+}
+
+// warnUnindexedColumns пишет в лог колонки без индекса (см. UnindexedColumns)
+func (db *Database) warnUnindexedColumns() {
+    unindexed, err := db.UnindexedColumns()
+    if err != nil {
+        log.Printf("Warning: cannot check indexes: %v", err)
+        return
+    }
+    for _, column := range unindexed {
+        log.Printf("Warning: filter column %s has no index, filtered queries slow down on large tables", column)
+    }
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// ListingVersion - версия формата обмена ресторанами; документ другой версии не импортируется
+const ListingVersion = 1
+
+// listingSchemaJSON - JSON Schema формата обмена; ее же получают партнеры (GET /listings/schema, export-listings -schema)
+//
+//go:embed interchange/restaurants.v1.schema.json
+var listingSchemaJSON []byte
+
+// listingSchema - скомпилированная listingSchemaJSON
+var listingSchema = mustCompileJSONSchema(listingSchemaJSON)
+
+// listingWeekdays - дни недели формата обмена; в restaurant_hours.weekday хранится индекс
+var listingWeekdays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// ListingDocument - документ формата обмена: рестораны с меню, часами работы и тегами
+type ListingDocument struct {
+    Version     int                 `json:"version"`
+    Restaurants []RestaurantListing `json:"restaurants"`
+}
+
+// RestaurantListing - ресторан в формате обмена
+type RestaurantListing struct {
+    Name         string            `json:"name"`
+    Type         string            `json:"type"`
+    Keys         *string           `json:"keys,omitempty"`
+    AveragePrice PriceTier         `json:"average_price"`
+    // OwnerID - ID пользователя-владельца на площадке, куда импортируется документ
+    OwnerID      int               `json:"owner_id"`
+    Menu         []ListingMenuItem `json:"menu,omitempty"`
+    Hours        []ListingHours    `json:"hours,omitempty"`
+    Tags         []string          `json:"tags,omitempty"`
+}
+
+// ListingMenuItem - блюдо ресторана в формате обмена
+type ListingMenuItem struct {
+    Name     string `json:"name"`
+    Price    int    `json:"price"`
+    Category string `json:"category,omitempty"`
+}
+
+// ListingHours - период работы ресторана в один день недели. Closes раньше Opens - работа после полуночи
+type ListingHours struct {
+    // Weekday - mon, tue, wed, thu, fri, sat или sun
+    Weekday string `json:"weekday"`
+    // Opens и Closes - время HH:MM
+    Opens  string `json:"opens"`
+    Closes string `json:"closes"`
+}
+
+// ListingError перечисляет все найденные в документе нарушения с путями до значений,
+// чтобы партнер мог исправить документ за один раз. errors.Is(err, ErrValidation) выполняется
+type ListingError struct {
+    Violations []SchemaViolation
+}
+
+func (e *ListingError) Error() string {
+    messages := make([]string, len(e.Violations))
+    for i, violation := range e.Violations {
+        messages[i] = violation.String()
+    }
+    return fmt.Sprintf("invalid listing document: %s", strings.Join(messages, "; "))
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrValidation)
+func (e *ListingError) Unwrap() error {
+    return ErrValidation
+}
+
+// mustCompileJSONSchema компилирует встроенную схему; ошибка в ней - ошибка сборки модуля
+func mustCompileJSONSchema(data []byte) *jsonSchema {
+    schema, err := compileJSONSchema(data)
+    if err != nil {
+        panic(fmt.Sprintf("embedded JSON Schema: %v", err))
+    }
+    return schema
+}
+
+// ImportListings проверяет документ формата обмена по встроенной JSON Schema и правилам
+// сущностей (см. RegisterInvariant) и добавляет рестораны текущей площадки с меню, часами
+// и тегами одной транзакцией: либо все, либо ни одного. Нарушения возвращаются все сразу
+// как *ListingError; возвращает ID добавленных ресторанов в порядке документа. Рестораны добавляются
+// от имени пользователя actorID: владелец импортирует только свои (см. authorizeNewRestaurant)
+func (db *Database) ImportListings(actorID int, data []byte) ([]int, error) {
+    violations, err := listingSchema.Validate(data)
+    if err != nil {
+        return nil, &ListingError{Violations: []SchemaViolation{{Message: "invalid JSON: " + err.Error()}}}
+    }
+    if len(violations) > 0 {
+        return nil, &ListingError{Violations: violations}
+    }
+    var doc ListingDocument
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, db.opError("import", "listings", nil, err)
+    }
+    // правила сущностей проверяются до транзакции, чтобы сообщить обо всех нарушениях, а не о первом
+    for i, listing := range doc.Restaurants {
+        violations = append(violations, listingInvariants(fmt.Sprintf("/restaurants/%d", i), listing)...)
+    }
+    if len(violations) > 0 {
+        return nil, &ListingError{Violations: violations}
+    }
+
+    ids := make([]int, len(doc.Restaurants))
+    err = db.InTx(func(tx *Database) error {
+        for i, listing := range doc.Restaurants {
+            if err := tx.authorizeNewRestaurant(actorID, listing.restaurant()); err != nil {
+                return err
+            }
+            path := fmt.Sprintf("/restaurants/%d", i)
+            id, err := tx.importListing(path, listing)
+            if err != nil {
+                return err
+            }
+            ids[i] = id
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, db.opError("import", "listings", nil, err)
+    }
+    return ids, nil
+}
+
+// listingInvariants проверяет ресторан и его блюда по правилам сущностей
+func listingInvariants(path string, listing RestaurantListing) []SchemaViolation {
+    var violations []SchemaViolation
+    collect := func(path string, err error) {
+        var invariantErr *InvariantError
+        if errors.As(err, &invariantErr) {
+            for _, violation := range invariantErr.Violations {
+                violations = append(violations, SchemaViolation{Path: path + "/" + violation.Field, Message: violation.Message})
+            }
+        }
+    }
+    collect(path, validateRestaurant(listing.restaurant()))
+    for j, item := range listing.Menu {
+        collect(fmt.Sprintf("%s/menu/%d", path, j), validateMenuItem(MenuItem{Name: item.Name, Price: item.Price, Category: item.Category}))
+    }
+    return violations
+}
+
+// restaurant возвращает запись ресторана без ID
+func (l RestaurantListing) restaurant() Restaurant {
+    return Restaurant{Name: l.Name, Type: l.Type, Keys: l.Keys, AveragePrice: l.AveragePrice, UserID: l.OwnerID}
+}
+
+// importListing добавляет один ресторан документа в транзакции tx. Ошибки, вызванные
+// содержимым документа (нет владельца, ресторан уже есть), возвращаются как *ListingError с путем
+func (db *Database) importListing(path string, listing RestaurantListing) (int, error) {
+    id, err := db.insertRestaurant(listing.restaurant())
+    switch {
+    case errors.Is(err, ErrForeignKeyViolation):
+        return 0, &ListingError{Violations: []SchemaViolation{{Path: path + "/owner_id", Message: fmt.Sprintf("user %d does not exist", listing.OwnerID)}}}
+    case errors.Is(err, ErrConflict):
+        return 0, &ListingError{Violations: []SchemaViolation{{Path: path + "/name", Message: "the owner already has a restaurant with this name"}}}
+    case err != nil:
+        return 0, err
+    }
+
+    for _, item := range listing.Menu {
+        menuItem := MenuItem{RestaurantID: int(id), Name: item.Name, Price: item.Price, Category: item.Category}
+        if err := db.insertMenuItem(&menuItem); err != nil {
+            return 0, db.opError("insert", "menu item", item.Name, err)
+        }
+    }
+    for j, hours := range listing.Hours {
+        _, err := db.execNamed("restaurant_hours.insert", id, listingWeekday(hours.Weekday), hours.Opens, hours.Closes, db.tenant)
+        if db.driver.isUniqueError(err) {
+            return 0, &ListingError{Violations: []SchemaViolation{{Path: fmt.Sprintf("%s/hours/%d", path, j), Message: "duplicates another period opening at the same time"}}}
+        }
+        if err != nil {
+            return 0, db.opError("insert", "restaurant hours", id, err)
+        }
+    }
+    for _, tag := range listing.Tags {
+        if _, err := db.execNamed("restaurant_tags.insert", id, tag, db.tenant); err != nil {
+            return 0, db.opError("insert", "restaurant tag", tag, err)
+        }
+    }
+    return int(id), nil
+}
+
+// listingWeekday возвращает индекс дня недели; день уже проверен схемой
+func listingWeekday(weekday string) int {
+    for i, name := range listingWeekdays {
+        if name == weekday {
+            return i
+        }
+    }
+    return -1
+}
+
+// ExportListings возвращает рестораны текущей площадки с меню, часами работы и тегами
+// в формате обмена; документ проходит проверку ImportListings
+func (db *Database) ExportListings() (*ListingDocument, error) {
+    restaurants, err := db.SelectRestaurants()
+    if err != nil {
+        return nil, db.opError("export", "listings", nil, err)
+    }
+    doc := &ListingDocument{Version: ListingVersion, Restaurants: make([]RestaurantListing, 0, len(restaurants))}
+    for _, restaurant := range restaurants {
+        listing := RestaurantListing{
+            Name:         restaurant.Name,
+            Type:         restaurant.Type,
+            Keys:         restaurant.Keys,
+            AveragePrice: restaurant.AveragePrice,
+            OwnerID:      restaurant.UserID,
+        }
+        items, err := db.MenuItemsByRestaurant(restaurant.ID)
+        if err != nil {
+            return nil, db.opError("export", "listings", nil, err)
+        }
+        for _, item := range items {
+            listing.Menu = append(listing.Menu, ListingMenuItem{Name: item.Name, Price: item.Price, Category: item.Category})
+        }
+        if listing.Hours, err = db.restaurantHours(restaurant.ID); err != nil {
+            return nil, db.opError("export", "listings", nil, err)
+        }
+        if listing.Tags, err = db.restaurantTags(restaurant.ID); err != nil {
+            return nil, db.opError("export", "listings", nil, err)
+        }
+        doc.Restaurants = append(doc.Restaurants, listing)
+    }
+    return doc, nil
+}
+
+// restaurantHours возвращает часы работы ресторана по дням недели
+func (db *Database) restaurantHours(restaurantID int) ([]ListingHours, error) {
+    rows, err := db.queryNamed("restaurant_hours.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "restaurant hours", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var hours []ListingHours
+    for rows.Next() {
+        var weekday int
+        var period ListingHours
+        if err := rows.Scan(&weekday, &period.Opens, &period.Closes); err != nil {
+            return nil, db.opError("list", "restaurant hours", restaurantID, err)
+        }
+        if weekday < 0 || weekday >= len(listingWeekdays) {
+            return nil, fmt.Errorf("restaurant %d: invalid weekday %d", restaurantID, weekday)
+        }
+        period.Weekday = listingWeekdays[weekday]
+        hours = append(hours, period)
+    }
+    return hours, db.opError("list", "restaurant hours", restaurantID, rows.Err())
+}
+
+// restaurantTags возвращает теги ресторана по алфавиту
+func (db *Database) restaurantTags(restaurantID int) ([]string, error) {
+    rows, err := db.queryNamed("restaurant_tags.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "restaurant tags", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var tags []string
+    for rows.Next() {
+        var tag string
+        if err := rows.Scan(&tag); err != nil {
+            return nil, db.opError("list", "restaurant tags", restaurantID, err)
+        }
+        tags = append(tags, tag)
+    }
+    return tags, db.opError("list", "restaurant tags", restaurantID, rows.Err())
+}
+
+// listingImportResponse - ответ на успешный импорт
+type listingImportResponse struct {
+    IDs []int `json:"ids"`
+}
+
+// listingMaxBody - наибольший размер документа, принимаемого по HTTP
+const listingMaxBody = 10 << 20
+
+// serveListingImport импортирует документ из тела запроса; нарушения возвращаются списком в violations
+func (db *Database) serveListingImport(w http.ResponseWriter, r *http.Request) {
+    data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, listingMaxBody))
+    if err != nil {
+        writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: err.Error()})
+        return
+    }
+    actor, _ := requestActor(r)
+    ids, err := db.ImportListings(actor, data)
+    var listingErr *ListingError
+    if errors.As(err, &listingErr) {
+        writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid listing document", Violations: listingErr.Violations})
+        return
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, listingImportResponse{IDs: ids})
+}
+
+// serveListingExport отдает рестораны площадки в формате обмена
+func (db *Database) serveListingExport(w http.ResponseWriter, r *http.Request) {
+    doc, err := db.ExportListings()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, doc)
+}
+
+// serveListingSchema отдает JSON Schema формата обмена
+func serveListingSchema(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/schema+json")
+    w.Write(listingSchemaJSON)
+}
+
+// runImportListings импортирует документ формата обмена из файла или stdin
+func runImportListings(db *Database, args []string) error {
+    flags := flag.NewFlagSet("import-listings", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if flags.NArg() > 1 {
+        return fmt.Errorf("usage: import-listings [file]")
+    }
+    r := io.Reader(os.Stdin)
+    if flags.NArg() == 1 {
+        file, err := os.Open(flags.Arg(0))
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        r = file
+    }
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return err
+    }
+
+    ids, err := db.ImportListings(SystemActor, data)
+    var listingErr *ListingError
+    if errors.As(err, &listingErr) {
+        for _, violation := range listingErr.Violations {
+            fmt.Println(violation)
+        }
+        return fmt.Errorf("invalid listing document: %d problems", len(listingErr.Violations))
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Imported %d restaurants\n", len(ids))
+    return nil
+}
+
+// runExportListings выгружает рестораны площадки в формате обмена в файл или stdout;
+// с -schema печатает JSON Schema формата
+func runExportListings(db *Database, args []string) error {
+    flags := flag.NewFlagSet("export-listings", flag.ContinueOnError)
+    output := flags.String("out", "", "output file (default: stdout)")
+    schema := flags.Bool("schema", false, "print the JSON Schema of the format instead of the data")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if flags.NArg() > 0 {
+        return fmt.Errorf("usage: export-listings [-out file] [-schema]")
+    }
+
+    data := listingSchemaJSON
+    if !*schema {
+        doc, err := db.ExportListings()
+        if err != nil {
+            return err
+        }
+        if data, err = json.MarshalIndent(doc, "", "  "); err != nil {
+            return err
+        }
+        data = append(data, '\n')
+    }
+    if *output == "" {
+        _, err := os.Stdout.Write(data)
+        return err
+    }
+    return os.WriteFile(*output, data, 0o644)
+}
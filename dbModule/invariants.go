@@ -0,0 +1,165 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "reflect"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+// maxBioLength - наибольшая длина текста "о себе" в профиле пользователя, в символах
+const maxBioLength = 1000
+
+// invariant - правило записи сущности, которое проверяется в коде перед записью в базу
+// в дополнение к ограничениям CHECK схемы
+type invariant struct {
+    field   string
+    message string
+    check   func(record interface{}) bool
+}
+
+// invariants - правила по сущностям в порядке регистрации; invariantTypes - тип записи каждой сущности
+var (
+    invariants     = map[string][]invariant{}
+    invariantTypes = map[string]reflect.Type{}
+)
+
+// Встроенные правила сущностей модуля; приложение добавляет свои через RegisterInvariant
+func init() {
+    RegisterInvariant("user", "email", "must contain @", func(user User) bool {
+        return user.Email == "" || strings.Contains(user.Email, "@")
+    })
+    RegisterInvariant("user", "role", "must be admin, owner or customer", func(user User) bool {
+        return user.Role == "" || rolePermissions[user.Role] != nil
+    })
+    RegisterInvariant("user", "public_id", "must be a UUID or ULID", func(user User) bool {
+        return validPublicID(user.PublicID)
+    })
+    RegisterInvariant("user", "avatar_url", "must be an absolute http or https URL", func(user User) bool {
+        return user.AvatarURL == nil || validAvatarURL(*user.AvatarURL)
+    })
+    RegisterInvariant("user", "bio", fmt.Sprintf("must be at most %d characters", maxBioLength), func(user User) bool {
+        return user.Bio == nil || utf8.RuneCountInString(*user.Bio) <= maxBioLength
+    })
+    RegisterInvariant("user", "birthdate", "must be a past date like 1990-12-31", func(user User) bool {
+        return user.Birthdate == nil || validBirthdate(*user.Birthdate)
+    })
+    RegisterInvariant("user", "locale", "must be a language like en or en-US", func(user User) bool {
+        if user.Locale == nil {
+            return true
+        }
+        locale, err := NormalizeLocale(*user.Locale)
+        return err == nil && locale == *user.Locale
+    })
+    RegisterInvariant("restaurant", "public_id", "must be a UUID or ULID", func(restaurant Restaurant) bool {
+        return validPublicID(restaurant.PublicID)
+    })
+    RegisterInvariant("restaurant", "name", "is required", func(restaurant Restaurant) bool {
+        return restaurant.Name != ""
+    })
+    RegisterInvariant("restaurant", "average_price", "must be a price tier from 1 (budget) to 5 (luxury)", func(restaurant Restaurant) bool {
+        return restaurant.AveragePrice.Valid()
+    })
+    RegisterInvariant("restaurant", "price_currency", "must be a known ISO 4217 code, given together with price_amount", func(restaurant Restaurant) bool {
+        if restaurant.PriceAmount == nil || restaurant.PriceCurrency == nil {
+            return restaurant.PriceAmount == nil && restaurant.PriceCurrency == nil
+        }
+        _, ok := CurrencyExponent(*restaurant.PriceCurrency)
+        return ok
+    })
+    RegisterInvariant("restaurant", "price_amount", "must not be negative", func(restaurant Restaurant) bool {
+        return restaurant.PriceAmount == nil || *restaurant.PriceAmount >= 0
+    })
+    RegisterInvariant("menu item", "name", "is required", func(item MenuItem) bool {
+        return item.Name != ""
+    })
+    RegisterInvariant("menu item", "price", "must not be negative", func(item MenuItem) bool {
+        return item.Price >= 0
+    })
+    RegisterInvariant("review", "rating", "must be from 1 to 5", func(review Review) bool {
+        return review.Rating >= 1 && review.Rating <= 5
+    })
+    RegisterInvariant("category", "name", "is required", func(category Category) bool {
+        return category.Name != ""
+    })
+    RegisterInvariant("booking slot", "ends_at", "must be after starts_at", func(slot BookingSlot) bool {
+        return slot.EndsAt.After(slot.StartsAt)
+    })
+    RegisterInvariant("booking slot", "capacity", "must be positive", func(slot BookingSlot) bool {
+        return slot.Capacity > 0
+    })
+    RegisterInvariant("booking", "party_size", "must be positive", func(booking Booking) bool {
+        return booking.PartySize > 0
+    })
+}
+
+// RegisterInvariant регистрирует правило для записей сущности entity ("user", "restaurant",
+// "menu item", "review", "category", "booking slot", "booking"): check возвращает false, если запись нарушает правило, и тогда
+// поле field получает сообщение message. Правила проверяются при каждой вставке и обновлении,
+// запись с нарушениями не доходит до базы. Регистрировать правила нужно до начала работы с базой
+func RegisterInvariant[T any](entity, field, message string, check func(T) bool) {
+    recordType := reflect.TypeFor[T]()
+    if registered, ok := invariantTypes[entity]; ok && registered != recordType {
+        panic(fmt.Sprintf("invariant %s.%s: entity %s records are %v, not %v", entity, field, entity, registered, recordType))
+    }
+    invariantTypes[entity] = recordType
+    invariants[entity] = append(invariants[entity], invariant{
+        field:   field,
+        message: message,
+        check:   func(record interface{}) bool { return check(record.(T)) },
+    })
+}
+
+// InvariantError перечисляет все правила, нарушенные записью сущности Entity.
+// errors.Is(err, ErrValidation) выполняется, errors.As находит первое нарушение как *ValidationError
+type InvariantError struct {
+    Entity     string
+    Violations []*ValidationError
+}
+
+func (e *InvariantError) Error() string {
+    messages := make([]string, len(e.Violations))
+    for i, violation := range e.Violations {
+        messages[i] = violation.Error()
+    }
+    return strings.Join(messages, "; ")
+}
+
+// Unwrap возвращает нарушения для errors.Is и errors.As
+func (e *InvariantError) Unwrap() []error {
+    errs := make([]error, len(e.Violations))
+    for i, violation := range e.Violations {
+        errs[i] = violation
+    }
+    return errs
+}
+
+// checkInvariants проверяет запись по всем правилам сущности и возвращает *InvariantError
+// со всеми нарушениями или nil
+func checkInvariants[T any](entity string, record T) error {
+    var violations []*ValidationError
+    for _, rule := range invariants[entity] {
+        if !rule.check(record) {
+            violations = append(violations, &ValidationError{Field: rule.field, Message: rule.message})
+        }
+    }
+    if len(violations) == 0 {
+        return nil
+    }
+    return &InvariantError{Entity: entity, Violations: violations}
+}
+
+// validAvatarURL проверяет, что адрес аватара - абсолютный URL http или https: он выводится
+// в профиле как есть, и javascript: или относительный путь туда попасть не должны
+func validAvatarURL(raw string) bool {
+    u, err := url.Parse(raw)
+    return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validBirthdate проверяет, что дата рождения записана как YYYY-MM-DD и уже наступила
+func validBirthdate(value string) bool {
+    date, err := time.Parse(time.DateOnly, value)
+    return err == nil && date.Before(time.Now())
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// Встроенные виды заданий
+const (
+    JobImportRestaurants = "import-restaurants"
+    JobExport            = "export"
+    JobAnalyticsExport   = "analytics-export"
+)
+
+func init() {
+    RegisterJob(JobImportRestaurants, runImportRestaurantsJob)
+    RegisterJob(JobExport, runExportJob)
+    RegisterJob(JobAnalyticsExport, runAnalyticsExportJob)
+}
+
+// JobImportBatchSize - сколько строк импорта добавляется одной транзакцией
+var JobImportBatchSize = 500
+
+// ImportRestaurantsParams - параметры задания import-restaurants
+type ImportRestaurantsParams struct {
+    // File - CSV с заголовком name,type,keys,average_price,user_id или JSON Lines с теми же полями
+    File string `json:"file"`
+    // Format - csv или jsonl; пустой выбирается по расширению файла
+    Format string `json:"format,omitempty"`
+    // RemoveFile удаляет файл после импорта, например временный файл загрузки по HTTP
+    RemoveFile bool `json:"remove_file,omitempty"`
+    // ActorID - пользователь, от имени которого добавляются рестораны: строки, которые он добавить
+    // не может (см. authorizeNewRestaurant), попадают в ошибки задания; SystemActor - без проверки
+    ActorID int `json:"actor_id,omitempty"`
+}
+
+// ExportParams - параметры задания export
+type ExportParams struct {
+    // Name - выгрузка (запрос export.<name>), File - файл результата
+    Name string `json:"name"`
+    File string `json:"file"`
+    // Compression - gzip, none или auto (gzip для файлов .gz, по умолчанию)
+    Compression string `json:"compression,omitempty"`
+}
+
+// AnalyticsExportParams - параметры задания analytics-export
+type AnalyticsExportParams struct {
+    // Dir - каталог, в котором создается каталог запуска (см. ExportAnalytics)
+    Dir string `json:"dir"`
+    // Datasets - выгрузки; пусто - все
+    Datasets []string `json:"datasets,omitempty"`
+    // Since - начало инкрементальной выгрузки: время RFC 3339 или last (см. analyticsSince); пусто - полная
+    Since string `json:"since,omitempty"`
+}
+
+// importRow - строка импорта с номером для сообщения об ошибке
+type importRow struct {
+    row        int64
+    restaurant Restaurant
+}
+
+// runImportRestaurantsJob добавляет рестораны из файла пачками по JobImportBatchSize в транзакции.
+// Если пачка не добавилась из-за данных одной из строк, ее строки добавляются по одной:
+// ошибочные попадают в ошибки задания, остальные импортируются
+func runImportRestaurantsJob(ctx context.Context, db *Database, data json.RawMessage, progress *JobProgress) error {
+    var params ImportRestaurantsParams
+    if err := json.Unmarshal(data, &params); err != nil {
+        return err
+    }
+    file, err := os.Open(params.File)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if params.RemoveFile {
+        defer os.Remove(params.File)
+    }
+    next, err := restaurantRows(file, params.Format, params.File)
+    if err != nil {
+        return err
+    }
+
+    batch := make([]importRow, 0, JobImportBatchSize)
+    for row := int64(1); ; row++ {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        restaurant, rowErr, err := next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if rowErr != nil {
+            progress.RowError(row, rowErr)
+            continue
+        }
+        if err := db.authorizeNewRestaurant(params.ActorID, restaurant); errors.Is(err, ErrPermissionDenied) {
+            progress.RowError(row, err)
+            continue
+        } else if err != nil {
+            return err
+        }
+        if batch = append(batch, importRow{row: row, restaurant: restaurant}); len(batch) == JobImportBatchSize {
+            if err := db.importRestaurantBatch(batch, progress); err != nil {
+                return err
+            }
+            batch = batch[:0]
+        }
+    }
+    return db.importRestaurantBatch(batch, progress)
+}
+
+// importRestaurantBatch добавляет пачку строк одной транзакцией, а если она откатилась
+// из-за данных строки, - по одной. Ошибки, не связанные с данными, останавливают импорт
+func (db *Database) importRestaurantBatch(batch []importRow, progress *JobProgress) error {
+    if len(batch) == 0 {
+        return nil
+    }
+    err := db.InTx(func(tx *Database) error {
+        for _, r := range batch {
+            if _, err := tx.insertRestaurant(r.restaurant); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err == nil {
+        progress.Add(int64(len(batch)))
+        return nil
+    }
+    if !isRowError(err) {
+        return err
+    }
+    for _, r := range batch {
+        _, err := db.insertRestaurant(r.restaurant)
+        switch {
+        case err == nil:
+            progress.Add(1)
+        case isRowError(err):
+            progress.RowError(r.row, err)
+        default:
+            return err
+        }
+    }
+    return nil
+}
+
+// isRowError сообщает, что ошибка вызвана данными строки, а не базой
+func isRowError(err error) bool {
+    return errors.Is(err, ErrValidation) || errors.Is(err, ErrConflict) || errors.Is(err, ErrForeignKeyViolation)
+}
+
+// restaurantRows возвращает чтение ресторанов из r в формате format (csv или jsonl; пустой - по
+// расширению path). Чтение возвращает ошибку строки rowErr, после которой можно читать дальше,
+// или err: io.EOF в конце файла
+func restaurantRows(r io.Reader, format, path string) (func() (restaurant Restaurant, rowErr, err error), error) {
+    if format == "" {
+        format = "csv"
+        if ext := strings.ToLower(filepath.Ext(path)); ext == ".jsonl" || ext == ".ndjson" || ext == ".json" {
+            format = "jsonl"
+        }
+    }
+
+    switch format {
+    case "jsonl":
+        scanner := bufio.NewScanner(r)
+        scanner.Buffer(make([]byte, 64*1024), 1<<20)
+        return func() (Restaurant, error, error) {
+            for scanner.Scan() {
+                line := strings.TrimSpace(scanner.Text())
+                if line == "" {
+                    continue
+                }
+                var restaurant Restaurant
+                if err := json.Unmarshal([]byte(line), &restaurant); err != nil {
+                    return Restaurant{}, &ValidationError{Field: "row", Message: "is not a restaurant object: " + err.Error()}, nil
+                }
+                return restaurant, nil, nil
+            }
+            if err := scanner.Err(); err != nil {
+                return Restaurant{}, nil, err
+            }
+            return Restaurant{}, nil, io.EOF
+        }, nil
+    case "csv":
+        reader := csv.NewReader(r)
+        reader.FieldsPerRecord = -1
+        header, err := reader.Read()
+        if err != nil {
+            return nil, fmt.Errorf("reading CSV header: %w", err)
+        }
+        columns := map[string]int{}
+        for i, name := range header {
+            columns[strings.ToLower(strings.TrimSpace(name))] = i
+        }
+        for _, name := range []string{"name", "type", "average_price", "user_id"} {
+            if _, ok := columns[name]; !ok {
+                return nil, fmt.Errorf("CSV header has no %s column", name)
+            }
+        }
+        return func() (Restaurant, error, error) {
+            record, err := reader.Read()
+            var parseErr *csv.ParseError
+            if errors.As(err, &parseErr) {
+                return Restaurant{}, &ValidationError{Field: "row", Message: parseErr.Err.Error()}, nil
+            }
+            if err != nil {
+                return Restaurant{}, nil, err
+            }
+            restaurant, rowErr := csvRestaurant(record, columns)
+            return restaurant, rowErr, nil
+        }, nil
+    }
+    return nil, fmt.Errorf("unknown import format %q (expected csv or jsonl)", format)
+}
+
+// csvRestaurant собирает ресторан из записи CSV по номерам колонок заголовка
+func csvRestaurant(record []string, columns map[string]int) (Restaurant, error) {
+    field := func(name string) string {
+        if i, ok := columns[name]; ok && i < len(record) {
+            return strings.TrimSpace(record[i])
+        }
+        return ""
+    }
+    restaurant := Restaurant{Name: field("name"), Type: field("type")}
+    if keys := field("keys"); keys != "" {
+        restaurant.Keys = &keys
+    }
+    var err error
+    if restaurant.AveragePrice, err = ParsePriceTier(field("average_price")); err != nil {
+        return Restaurant{}, &ValidationError{Field: "average_price", Message: "must be a price tier from 1 (budget) to 5 (luxury)"}
+    }
+    if restaurant.UserID, err = strconv.Atoi(field("user_id")); err != nil {
+        return Restaurant{}, &ValidationError{Field: "user_id", Message: "must be an integer"}
+    }
+    return restaurant, nil
+}
+
+// runExportJob выгружает export.<name> в файл (см. Export), отмечая каждую строку в прогрессе.
+// Недописанный файл отмененной или неудачной выгрузки удаляется
+func runExportJob(ctx context.Context, db *Database, data json.RawMessage, progress *JobProgress) error {
+    var params ExportParams
+    if err := json.Unmarshal(data, &params); err != nil {
+        return err
+    }
+    if !db.queries.Has(exportNamespace + params.Name) {
+        return fmt.Errorf("%w: unknown export %q", ErrValidation, params.Name)
+    }
+    if params.Compression == "" {
+        params.Compression = "auto"
+    }
+    compression, err := parseCompression(params.Compression, params.File)
+    if err != nil {
+        return err
+    }
+
+    file, err := os.Create(params.File)
+    if err != nil {
+        return err
+    }
+    _, err = db.export(file, params.Name, compression, func() error {
+        progress.Add(1)
+        return ctx.Err()
+    })
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(params.File)
+    }
+    return err
+}
+
+// runAnalyticsExportJob выгружает наборы данных для аналитики (см. ExportAnalytics), отмечая каждую строку
+// в прогрессе. Отмененная или неудачная выгрузка не оставляет каталога запуска
+func runAnalyticsExportJob(ctx context.Context, db *Database, data json.RawMessage, progress *JobProgress) error {
+    var params AnalyticsExportParams
+    if err := json.Unmarshal(data, &params); err != nil {
+        return err
+    }
+    if params.Dir == "" {
+        return fmt.Errorf("%w: dir is required", ErrValidation)
+    }
+    since, err := analyticsSince(params.Dir, params.Since)
+    if err != nil {
+        return err
+    }
+    _, err = db.exportAnalytics(params.Dir, AnalyticsOptions{Datasets: params.Datasets, Since: since}, func() error {
+        progress.Add(1)
+        return ctx.Err()
+    })
+    return err
+}
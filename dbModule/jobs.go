@@ -0,0 +1,608 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Состояния задания
+const (
+    JobQueued    = "queued"
+    JobRunning   = "running"
+    JobSucceeded = "succeeded"
+    JobFailed    = "failed"
+    JobCanceled  = "canceled"
+)
+
+// JobProgressInterval - как часто выполняющееся задание записывает прогресс и проверяет, не отменили ли его
+var JobProgressInterval = time.Second
+
+// maxJobRowErrors - сколько ошибок строк хранится в задании; остальные только считаются в Failed
+const maxJobRowErrors = 100
+
+// Job - длительное задание импорта или выгрузки. Задание выполняется в фоне процессом,
+// который его создал, а состояние и прогресс пишутся в таблицу jobs, поэтому их видно из любого
+// процесса. Задание в состоянии running, чей UpdatedAt давно не менялся, осталось от упавшего процесса
+type Job struct {
+    ID       int    `json:"id"`
+    TenantID int    `json:"tenant_id"`
+    Kind     string `json:"kind"`
+    State    string `json:"state"`
+    // Params - параметры вида задания в JSON (см. RegisterJob)
+    Params json.RawMessage `json:"params,omitempty"`
+    // Processed - обработанные строки, включая ошибочные; Failed - строки с ошибками
+    Processed int64 `json:"processed"`
+    Failed    int64 `json:"failed"`
+    // Errors - первые ошибки строк; Error - ошибка, остановившая задание
+    Errors          []JobRowError `json:"errors,omitempty"`
+    Error           string        `json:"error,omitempty"`
+    CancelRequested bool          `json:"cancel_requested"`
+    CreatedAt       time.Time     `json:"created_at"`
+    StartedAt       *time.Time    `json:"started_at,omitempty"`
+    UpdatedAt       time.Time     `json:"updated_at"`
+    FinishedAt      *time.Time    `json:"finished_at,omitempty"`
+    // OwnerID - пользователь, запустивший задание через API; 0 - команда или задача модуля (SystemActor)
+    OwnerID int `json:"owner_id,omitempty"`
+}
+
+// JobRowError - ошибка одной строки импорта; строки нумеруются с 1 без учета заголовка
+type JobRowError struct {
+    Row     int64  `json:"row"`
+    Message string `json:"message"`
+}
+
+// Done сообщает, что задание завершилось и больше не изменится
+func (j Job) Done() bool {
+    return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCanceled
+}
+
+// JobFunc выполняет задание с параметрами params от имени площадки db и сообщает прогресс в progress.
+// ctx отменяется, когда задание отменяют (CancelJob) или база закрывается; функция должна
+// проверять его между строками и возвращать ctx.Err()
+type JobFunc func(ctx context.Context, db *Database, params json.RawMessage, progress *JobProgress) error
+
+// jobKinds - зарегистрированные виды заданий
+var jobKinds = map[string]JobFunc{}
+
+// RegisterJob регистрирует вид задания kind; вызывается из init()
+func RegisterJob(kind string, run JobFunc) {
+    if _, ok := jobKinds[kind]; ok {
+        panic(fmt.Sprintf("job kind %s is already registered", kind))
+    }
+    jobKinds[kind] = run
+}
+
+// JobKinds возвращает отсортированные имена зарегистрированных видов заданий
+func JobKinds() []string {
+    kinds := make([]string, 0, len(jobKinds))
+    for kind := range jobKinds {
+        kinds = append(kinds, kind)
+    }
+    sort.Strings(kinds)
+    return kinds
+}
+
+// JobProgress копит прогресс задания до очередной записи в jobs; безопасен для нескольких горутин
+type JobProgress struct {
+    mu        sync.Mutex
+    processed int64
+    failed    int64
+    errors    []JobRowError
+}
+
+// Add отмечает n обработанных строк
+func (p *JobProgress) Add(n int64) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.processed += n
+}
+
+// RowError отмечает обработанную строку row, которая не импортирована из-за err
+func (p *JobProgress) RowError(row int64, err error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.processed++
+    p.failed++
+    if len(p.errors) < maxJobRowErrors {
+        p.errors = append(p.errors, JobRowError{Row: row, Message: err.Error()})
+    }
+}
+
+// snapshot возвращает прогресс для записи
+func (p *JobProgress) snapshot() (processed, failed int64, errors []byte) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if len(p.errors) > 0 {
+        errors, _ = json.Marshal(p.errors)
+    }
+    return p.processed, p.failed, errors
+}
+
+// jobRunner - задания, выполняющиеся в этом процессе, общие для всех копий Database
+type jobRunner struct {
+    mu      sync.Mutex
+    running map[int]*runningJob
+    wg      sync.WaitGroup
+}
+
+// runningJob - отмена и окончание выполняющегося задания
+type runningJob struct {
+    cancel context.CancelFunc
+    done   chan struct{}
+}
+
+// SubmitJob создает задание вида kind с параметрами params (значение для JSON) на площадке копии
+// от имени пользователя actorID (см. CancelJob) и запускает его в фоне. Возвращает задание в состоянии queued
+func (db *Database) SubmitJob(actorID int, kind string, params interface{}) (Job, error) {
+    run, ok := jobKinds[kind]
+    if !ok {
+        return Job{}, db.opError("submit", "job", kind, fmt.Errorf("%w: unknown job kind %q", ErrValidation, kind))
+    }
+    data, err := json.Marshal(params)
+    if err != nil {
+        return Job{}, db.opError("submit", "job", kind, err)
+    }
+
+    now := db.now().UTC()
+    owner := sql.NullInt64{Int64: int64(actorID), Valid: actorID != SystemActor}
+    id, err := db.insertNamed("jobs.insert", db.tenant, kind, JobQueued, string(data), now, now, owner)
+    if err != nil {
+        return Job{}, db.opError("submit", "job", kind, err)
+    }
+    job := Job{ID: int(id), TenantID: db.tenant, Kind: kind, State: JobQueued, Params: data, CreatedAt: now, UpdatedAt: now, OwnerID: actorID}
+
+    ctx, cancel := context.WithCancel(context.Background())
+    running := &runningJob{cancel: cancel, done: make(chan struct{})}
+    db.jobs.mu.Lock()
+    if db.jobs.running == nil {
+        db.jobs.running = map[int]*runningJob{}
+    }
+    db.jobs.running[job.ID] = running
+    db.jobs.wg.Add(1)
+    db.jobs.mu.Unlock()
+
+    go db.runJob(ctx, job, run, running)
+    return job, nil
+}
+
+// runJob выполняет задание и записывает его прогресс и итог. Отмена из другого процесса
+// замечается при записи прогресса. Запросы задания ограничены таймаутом миграций, а не чтения:
+// выгрузка большой таблицы читается одним запросом
+func (db *Database) runJob(ctx context.Context, job Job, run JobFunc, running *runningJob) {
+    defer func() {
+        db.jobs.mu.Lock()
+        delete(db.jobs.running, job.ID)
+        db.jobs.mu.Unlock()
+        running.cancel()
+        close(running.done)
+        db.jobs.wg.Done()
+    }()
+
+    // задание переживает HTTP-запрос, который его создал: бюджет, ключ идемпотентности,
+    // отметка устаревших чтений и отмена чтений этого запроса к заданию не относятся
+    worker := db.WithTenant(job.TenantID)
+    worker.budget, worker.staleReads, worker.idempotencyKey, worker.requestCtx = nil, nil, "", nil
+    worker.timeouts.Read = worker.timeouts.Migration
+    started := worker.now().UTC()
+    if _, err := worker.execNamed("jobs.start", JobRunning, started, started, job.ID); err != nil {
+        log.Printf("job %d: %v", job.ID, err)
+        return
+    }
+
+    progress := &JobProgress{}
+    stopped := make(chan struct{})
+    go func() {
+        ticker := time.NewTicker(JobProgressInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stopped:
+                return
+            case <-ticker.C:
+                if worker.saveJobProgress(job.ID, progress) {
+                    running.cancel()
+                }
+            }
+        }
+    }()
+
+    err := run(ctx, worker, job.Params, progress)
+    close(stopped)
+
+    state, message := JobSucceeded, ""
+    switch {
+    case err != nil && ctx.Err() != nil && errors.Is(err, context.Canceled):
+        state, message = JobCanceled, "canceled"
+    case err != nil:
+        state, message = JobFailed, err.Error()
+    }
+    processed, failed, rowErrors := progress.snapshot()
+    finished := worker.now().UTC()
+    if _, err := worker.execNamed("jobs.finish", state, processed, failed, nullableText(rowErrors), nullableText([]byte(message)), finished, finished, job.ID); err != nil {
+        log.Printf("job %d: recording %s: %v", job.ID, state, err)
+    }
+}
+
+// saveJobProgress записывает прогресс задания и сообщает, запрошена ли его отмена.
+// Ошибки только логируются: задание продолжается, а прогресс запишется в следующий раз
+func (db *Database) saveJobProgress(id int, progress *JobProgress) (canceled bool) {
+    processed, failed, rowErrors := progress.snapshot()
+    if _, err := db.execNamed("jobs.progress", processed, failed, nullableText(rowErrors), db.now().UTC(), id); err != nil {
+        log.Printf("job %d: saving progress: %v", id, err)
+        return false
+    }
+    rows, err := db.WithPrimary().queryNamed("jobs.select_cancel_requested", id)
+    if err != nil {
+        log.Printf("job %d: checking cancellation: %v", id, err)
+        return false
+    }
+    defer rows.Close()
+    var requested int
+    if rows.Next() && rows.Scan(&requested) == nil {
+        return requested != 0
+    }
+    return false
+}
+
+// nullableText возвращает NULL для пустого значения
+func nullableText(data []byte) interface{} {
+    if len(data) == 0 {
+        return nil
+    }
+    return string(data)
+}
+
+// GetJob возвращает задание площадки или ErrNotFound
+func (db *Database) GetJob(id int) (Job, error) {
+    job, err := db.WithPrimary().findJob(id)
+    if err == nil && job == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return Job{}, db.opError("get", "job", id, err)
+    }
+    return *job, nil
+}
+
+// ListJobs возвращает задания площадки, начиная с последних
+func (db *Database) ListJobs() ([]Job, error) {
+    rows, err := db.queryNamed("jobs.select", db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "jobs", nil, err)
+    }
+    defer rows.Close()
+
+    var jobs []Job
+    for rows.Next() {
+        job, err := scanJob(rows)
+        if err != nil {
+            return nil, db.opError("list", "jobs", nil, err)
+        }
+        jobs = append(jobs, job)
+    }
+    return jobs, db.opError("list", "jobs", nil, rows.Err())
+}
+
+// CancelJob отменяет задание от имени пользователя actorID: еще не начатое сразу получает состояние
+// canceled, выполняющееся останавливается на следующей строке. Задание другого процесса узнает об отмене
+// при записи прогресса. Чужое задание может отменить только пользователь с PermissionManageJobs
+func (db *Database) CancelJob(actorID, id int) error {
+    if actorID != SystemActor {
+        job, err := db.findJob(id)
+        if err == nil && job == nil {
+            err = ErrNotFound
+        }
+        if err == nil && job.OwnerID != actorID {
+            err = db.authorizePermission(actorID, PermissionManageJobs)
+        }
+        if err != nil {
+            return db.opError("cancel", "job", id, err)
+        }
+    }
+    now := db.now().UTC()
+    result, err := db.execNamed("jobs.cancel_queued", now, now, id, db.tenant)
+    if err == nil {
+        var canceled int64
+        if canceled, err = result.RowsAffected(); err == nil && canceled == 0 {
+            result, err = db.execNamed("jobs.request_cancel", now, id, db.tenant)
+            if err == nil {
+                if found, _ := result.RowsAffected(); found == 0 {
+                    err = ErrNotFound
+                }
+            }
+        }
+    }
+    if err != nil {
+        return db.opError("cancel", "job", id, err)
+    }
+
+    db.jobs.mu.Lock()
+    running := db.jobs.running[id]
+    db.jobs.mu.Unlock()
+    if running != nil {
+        running.cancel()
+    }
+    return nil
+}
+
+// WaitJob дожидается окончания задания, выполняющегося в этом процессе, или отмены ctx
+func (db *Database) WaitJob(ctx context.Context, id int) error {
+    db.jobs.mu.Lock()
+    running := db.jobs.running[id]
+    db.jobs.mu.Unlock()
+    if running == nil {
+        return nil
+    }
+    select {
+    case <-running.done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// stop отменяет выполняющиеся задания и дожидается, пока они запишут свое состояние, не дольше ctx
+func (r *jobRunner) stop(ctx context.Context) {
+    r.mu.Lock()
+    for _, running := range r.running {
+        running.cancel()
+    }
+    r.mu.Unlock()
+
+    stopped := make(chan struct{})
+    go func() {
+        r.wg.Wait()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-ctx.Done():
+    }
+}
+
+// findJob читает задание текущей площадки; nil, если его нет
+func (db *Database) findJob(id int) (*Job, error) {
+    rows, err := db.queryNamed("jobs.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    job, err := scanJob(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &job, rows.Close()
+}
+
+// scanJob читает задание из текущей строки
+func scanJob(row *queryRows) (Job, error) {
+    var job Job
+    var params, rowErrors, message sql.NullString
+    var cancelRequested int
+    var started, finished sql.NullTime
+    var owner sql.NullInt64
+    err := row.Scan(&job.ID, &job.TenantID, &job.Kind, &job.State, &params, &job.Processed, &job.Failed,
+        &rowErrors, &message, &cancelRequested, &job.CreatedAt, &started, &job.UpdatedAt, &finished, &owner)
+    if err != nil {
+        return job, err
+    }
+    job.OwnerID = int(owner.Int64)
+    if params.Valid {
+        job.Params = json.RawMessage(params.String)
+    }
+    if rowErrors.Valid {
+        if err := json.Unmarshal([]byte(rowErrors.String), &job.Errors); err != nil {
+            return job, fmt.Errorf("job %d errors: %w", job.ID, err)
+        }
+    }
+    job.Error, job.CancelRequested = message.String, cancelRequested != 0
+    if started.Valid {
+        job.StartedAt = &started.Time
+    }
+    if finished.Valid {
+        job.FinishedAt = &finished.Time
+    }
+    return job, nil
+}
+
+// runJobs - команда jobs: запуск задания с выводом прогресса до его окончания, список заданий,
+// состояние и отмена. Задание выполняется процессом, который его запустил, поэтому submit
+// ждет окончания, а прерывание (Ctrl+C) отменяет задание
+func runJobs(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: jobs submit -kind %s -file F [-format csv|jsonl] | submit -kind %s -name N -file F [-compress gzip|none|auto] | submit -kind %s -file DIR [-since T|last] | list | status -id N | cancel -id N",
+        JobImportRestaurants, JobExport, JobAnalyticsExport)
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("jobs", flag.ContinueOnError)
+    kind := flags.String("kind", "", "kind of the job to submit: "+strings.Join(JobKinds(), ", "))
+    file := flags.String("file", "", "file to import from or export to, directory for "+JobAnalyticsExport)
+    format := flags.String("format", "", "import format, csv or jsonl (default: guessed from the file extension)")
+    name := flags.String("name", "", "export name, e.g. restaurants")
+    compress := flags.String("compress", "auto", "export compression: gzip, none or auto (gzip for .gz files)")
+    since := flags.String("since", "", "analytics export of rows changed since this time (RFC 3339) or since the previous run with last")
+    id := flags.Int("id", 0, "job ID for status and cancel")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "submit":
+        var params interface{}
+        switch *kind {
+        case JobImportRestaurants:
+            params = ImportRestaurantsParams{File: *file, Format: *format}
+        case JobExport:
+            params = ExportParams{Name: *name, File: *file, Compression: *compress}
+        case JobAnalyticsExport:
+            params = AnalyticsExportParams{Dir: *file, Since: *since}
+        default:
+            return fmt.Errorf("unknown job kind %q (expected one of %s)", *kind, strings.Join(JobKinds(), ", "))
+        }
+        job, err := db.SubmitJob(SystemActor, *kind, params)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("job %d submitted\n", job.ID)
+        return db.followJob(job.ID)
+    case "list":
+        jobs, err := db.ListJobs()
+        if err != nil {
+            return err
+        }
+        for _, job := range jobs {
+            fmt.Printf("%d | %s | %s | %d processed | %d failed | %s\n", job.ID, job.Kind, job.State, job.Processed, job.Failed, job.CreatedAt.Format(time.RFC3339))
+        }
+        return nil
+    case "status":
+        job, err := db.GetJob(*id)
+        if err != nil {
+            return err
+        }
+        printJob(job)
+        return nil
+    case "cancel":
+        return db.CancelJob(SystemActor, *id)
+    }
+    return usage
+}
+
+// followJob печатает прогресс задания раз в JobProgressInterval до его окончания.
+// Прерывание отменяет задание, и прогресс печатается, пока оно не запишет состояние
+func (db *Database) followJob(id int) error {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    ticker := time.NewTicker(JobProgressInterval)
+    defer ticker.Stop()
+    finished := make(chan struct{})
+    go func() {
+        db.WaitJob(context.Background(), id)
+        close(finished)
+    }()
+
+    for {
+        select {
+        case <-ticker.C:
+            job, err := db.GetJob(id)
+            if err != nil {
+                return err
+            }
+            fmt.Printf("job %d: %s, %d processed, %d failed\n", id, job.State, job.Processed, job.Failed)
+        case <-ctx.Done():
+            stop()
+            if err := db.CancelJob(SystemActor, id); err != nil {
+                return err
+            }
+            ctx = context.Background()
+        case <-finished:
+            job, err := db.GetJob(id)
+            if err != nil {
+                return err
+            }
+            printJob(job)
+            if job.State != JobSucceeded {
+                return fmt.Errorf("job %d %s", id, job.State)
+            }
+            return nil
+        }
+    }
+}
+
+// printJob печатает состояние задания и ошибки его строк
+func printJob(job Job) {
+    fmt.Printf("job %d (%s): %s, %d processed, %d failed\n", job.ID, job.Kind, job.State, job.Processed, job.Failed)
+    for _, rowError := range job.Errors {
+        fmt.Printf("  row %d: %s\n", rowError.Row, rowError.Message)
+    }
+    if job.Error != "" {
+        fmt.Printf("  error: %s\n", job.Error)
+    }
+}
+
+// jobUploadMaxBody - наибольший размер файла импорта, принимаемого по HTTP
+const jobUploadMaxBody = 1 << 30
+
+// serveImportJob сохраняет тело запроса во временный файл и запускает по нему задание
+// import-restaurants, которое удалит файл. Отвечает заданием, не дожидаясь импорта
+func (db *Database) serveImportJob(w http.ResponseWriter, r *http.Request) {
+    format := r.URL.Query().Get("format")
+    if format != "csv" && format != "jsonl" {
+        writeError(w, &ValidationError{Field: "format", Message: "must be csv or jsonl"})
+        return
+    }
+    file, err := os.CreateTemp("", "dbmodule-import-*."+format)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    _, err = io.Copy(file, http.MaxBytesReader(w, r.Body, jobUploadMaxBody))
+    file.Close()
+    if err != nil {
+        os.Remove(file.Name())
+        writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: err.Error()})
+        return
+    }
+    actor, _ := requestActor(r)
+    job, err := db.SubmitJob(actor, JobImportRestaurants, ImportRestaurantsParams{File: file.Name(), Format: format, RemoveFile: true, ActorID: actor})
+    if err != nil {
+        os.Remove(file.Name())
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusAccepted, job)
+}
+
+// serveJobs отдает задания площадки, новые первыми
+func (db *Database) serveJobs(w http.ResponseWriter, r *http.Request) {
+    jobs, err := db.ListJobs()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if jobs == nil {
+        jobs = []Job{}
+    }
+    writeJSON(w, http.StatusOK, jobs)
+}
+
+// serveJob отдает состояние и прогресс задания; DELETE запрашивает его отмену. Пользователь, вошедший
+// через -auth, отменяет только свои задания, если он не администратор
+func (db *Database) serveJob(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be a number"})
+        return
+    }
+    if r.Method == http.MethodDelete {
+        actor, _ := requestActor(r)
+        if err := db.CancelJob(actor, id); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    job, err := db.GetJob(id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+    "unicode/utf8"
+)
+
+// jsonSchema - схема JSON Schema в объеме, нужном форматам обмена модуля: type, const, enum,
+// properties, required, additionalProperties, items, minItems, maxItems, uniqueItems,
+// minimum, maximum, minLength, maxLength, pattern и $ref на #/$defs/<имя>. Остальные ключевые
+// слова не поддерживаются: compileJSONSchema отвергает схему с ними, чтобы проверка не была слабее описанной
+type jsonSchema struct {
+    Ref                  string                 `json:"$ref"`
+    Type                 schemaTypes            `json:"type"`
+    Const                json.RawMessage        `json:"const"`
+    Enum                 []json.RawMessage      `json:"enum"`
+    Properties           map[string]*jsonSchema `json:"properties"`
+    Required             []string               `json:"required"`
+    AdditionalProperties *bool                  `json:"additionalProperties"`
+    Items                *jsonSchema            `json:"items"`
+    MinItems             *int                   `json:"minItems"`
+    MaxItems             *int                   `json:"maxItems"`
+    UniqueItems          bool                   `json:"uniqueItems"`
+    Minimum              *json.Number           `json:"minimum"`
+    Maximum              *json.Number           `json:"maximum"`
+    MinLength            *int                   `json:"minLength"`
+    MaxLength            *int                   `json:"maxLength"`
+    Pattern              string                 `json:"pattern"`
+    Defs                 map[string]*jsonSchema `json:"$defs"`
+
+    pattern *regexp.Regexp
+    root    *jsonSchema
+}
+
+// schemaTypes - значение type: одно имя типа или список
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+    var single string
+    if err := json.Unmarshal(data, &single); err == nil {
+        *t = schemaTypes{single}
+        return nil
+    }
+    var list []string
+    if err := json.Unmarshal(data, &list); err != nil {
+        return fmt.Errorf("type must be a string or an array of strings")
+    }
+    *t = list
+    return nil
+}
+
+// schemaKeywords - ключевые слова, которые понимает jsonSchema; аннотации ни на что не влияют
+var schemaKeywords = map[string]bool{
+    "$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "examples": true,
+    "$ref": true, "type": true, "const": true, "enum": true, "properties": true, "required": true,
+    "additionalProperties": true, "items": true, "minItems": true, "maxItems": true, "uniqueItems": true,
+    "minimum": true, "maximum": true, "minLength": true, "maxLength": true, "pattern": true, "$defs": true,
+}
+
+// SchemaViolation - одно нарушение схемы: Path - JSON Pointer на значение документа
+// (например, /restaurants/0/hours/1/opens), пустой для документа целиком
+type SchemaViolation struct {
+    Path    string `json:"path"`
+    Message string `json:"message"`
+}
+
+func (v SchemaViolation) String() string {
+    if v.Path == "" {
+        return "/: " + v.Message
+    }
+    return v.Path + ": " + v.Message
+}
+
+// compileJSONSchema разбирает схему и компилирует ее шаблоны
+func compileJSONSchema(data []byte) (*jsonSchema, error) {
+    var raw interface{}
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, err
+    }
+    if err := checkSchemaKeywords(raw, ""); err != nil {
+        return nil, err
+    }
+    var schema jsonSchema
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if err := decoder.Decode(&schema); err != nil {
+        return nil, err
+    }
+    if err := schema.compile(&schema, ""); err != nil {
+        return nil, err
+    }
+    return &schema, nil
+}
+
+// checkSchemaKeywords отвергает ключевые слова, которые jsonSchema не проверяет
+func checkSchemaKeywords(raw interface{}, path string) error {
+    object, ok := raw.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    for keyword, value := range object {
+        if !schemaKeywords[keyword] {
+            return fmt.Errorf("schema %s: unsupported keyword %q", path+"/", keyword)
+        }
+        switch keyword {
+        case "properties", "$defs":
+            members, _ := value.(map[string]interface{})
+            for name, member := range members {
+                if err := checkSchemaKeywords(member, path+"/"+keyword+"/"+name); err != nil {
+                    return err
+                }
+            }
+        case "items":
+            if err := checkSchemaKeywords(value, path+"/items"); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// compile компилирует шаблоны и проверяет ссылки схемы и ее вложенных схем
+func (s *jsonSchema) compile(root *jsonSchema, path string) error {
+    s.root = root
+    if s.Pattern != "" {
+        pattern, err := regexp.Compile(s.Pattern)
+        if err != nil {
+            return fmt.Errorf("schema %s/pattern: %w", path, err)
+        }
+        s.pattern = pattern
+    }
+    if s.Ref != "" {
+        if _, err := s.resolve(); err != nil {
+            return fmt.Errorf("schema %s: %w", path, err)
+        }
+    }
+    for name, property := range s.Properties {
+        if err := property.compile(root, path+"/properties/"+name); err != nil {
+            return err
+        }
+    }
+    for name, def := range s.Defs {
+        if err := def.compile(root, path+"/$defs/"+name); err != nil {
+            return err
+        }
+    }
+    if s.Items != nil {
+        return s.Items.compile(root, path+"/items")
+    }
+    return nil
+}
+
+// resolve возвращает схему, на которую указывает $ref
+func (s *jsonSchema) resolve() (*jsonSchema, error) {
+    name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+    if !ok {
+        return nil, fmt.Errorf("unsupported $ref %q, only #/$defs/<name> is supported", s.Ref)
+    }
+    def, ok := s.root.Defs[name]
+    if !ok {
+        return nil, fmt.Errorf("$ref %q: no such definition", s.Ref)
+    }
+    return def, nil
+}
+
+// Validate проверяет документ data и возвращает все нарушения по порядку их мест в документе;
+// nil - документ соответствует схеме. Ошибка - только для некорректного JSON
+func (s *jsonSchema) Validate(data []byte) ([]SchemaViolation, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    // числа остаются текстом: так integer отличается от number и не теряет точность
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        return nil, err
+    }
+    if decoder.More() {
+        return nil, fmt.Errorf("unexpected data after the JSON document")
+    }
+    var violations []SchemaViolation
+    s.validate(value, "", &violations)
+    return violations, nil
+}
+
+// validate проверяет значение value по пути path и дописывает нарушения в violations
+func (s *jsonSchema) validate(value interface{}, path string, violations *[]SchemaViolation) {
+    if s.Ref != "" {
+        // ссылки проверены при компиляции
+        def, _ := s.resolve()
+        def.validate(value, path, violations)
+        return
+    }
+    fail := func(format string, args ...interface{}) {
+        *violations = append(*violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+    }
+
+    kind := jsonKind(value)
+    if len(s.Type) > 0 && !s.allowsKind(kind) {
+        fail("must be %s, got %s", strings.Join(s.Type, " or "), kind)
+        return
+    }
+    if len(s.Const) > 0 && !jsonEqual(value, s.Const) {
+        fail("must be %s", s.Const)
+        return
+    }
+    if len(s.Enum) > 0 {
+        allowed := make([]string, len(s.Enum))
+        found := false
+        for i, option := range s.Enum {
+            allowed[i] = string(option)
+            found = found || jsonEqual(value, option)
+        }
+        if !found {
+            fail("must be one of %s", strings.Join(allowed, ", "))
+            return
+        }
+    }
+
+    switch value := value.(type) {
+    case string:
+        length := utf8.RuneCountInString(value)
+        if s.MinLength != nil && length < *s.MinLength {
+            fail("must be at least %d characters", *s.MinLength)
+        }
+        if s.MaxLength != nil && length > *s.MaxLength {
+            fail("must be at most %d characters", *s.MaxLength)
+        }
+        if s.pattern != nil && !s.pattern.MatchString(value) {
+            fail("must match %s", s.Pattern)
+        }
+    case json.Number:
+        number, _ := value.Float64()
+        if s.Minimum != nil {
+            if minimum, _ := s.Minimum.Float64(); number < minimum {
+                fail("must be >= %s", *s.Minimum)
+            }
+        }
+        if s.Maximum != nil {
+            if maximum, _ := s.Maximum.Float64(); number > maximum {
+                fail("must be <= %s", *s.Maximum)
+            }
+        }
+    case []interface{}:
+        if s.MinItems != nil && len(value) < *s.MinItems {
+            fail("must have at least %d items", *s.MinItems)
+        }
+        if s.MaxItems != nil && len(value) > *s.MaxItems {
+            fail("must have at most %d items", *s.MaxItems)
+        }
+        seen := make(map[string]int)
+        for i, item := range value {
+            itemPath := fmt.Sprintf("%s/%d", path, i)
+            if s.UniqueItems {
+                encoded, _ := json.Marshal(item)
+                if first, ok := seen[string(encoded)]; ok {
+                    *violations = append(*violations, SchemaViolation{Path: itemPath, Message: fmt.Sprintf("duplicates item %d", first)})
+                } else {
+                    seen[string(encoded)] = i
+                }
+            }
+            if s.Items != nil {
+                s.Items.validate(item, itemPath, violations)
+            }
+        }
+    case map[string]interface{}:
+        for _, name := range s.Required {
+            if _, ok := value[name]; !ok {
+                *violations = append(*violations, SchemaViolation{Path: path + "/" + jsonPointerEscape(name), Message: "is required"})
+            }
+        }
+        names := make([]string, 0, len(value))
+        for name := range value {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            memberPath := path + "/" + jsonPointerEscape(name)
+            property, ok := s.Properties[name]
+            switch {
+            case ok:
+                property.validate(value[name], memberPath, violations)
+            case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+                *violations = append(*violations, SchemaViolation{Path: memberPath, Message: "is not a known property"})
+            }
+        }
+    }
+}
+
+// allowsKind проверяет вид значения по type; integer подходит и под number
+func (s *jsonSchema) allowsKind(kind string) bool {
+    for _, allowed := range s.Type {
+        if allowed == kind || allowed == "number" && kind == "integer" {
+            return true
+        }
+    }
+    return false
+}
+
+// jsonKind возвращает тип JSON Schema значения, разобранного с UseNumber
+func jsonKind(value interface{}) string {
+    switch value := value.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return "boolean"
+    case string:
+        return "string"
+    case json.Number:
+        if _, err := value.Int64(); err == nil {
+            return "integer"
+        }
+        return "number"
+    case []interface{}:
+        return "array"
+    }
+    return "object"
+}
+
+// jsonEqual сравнивает значение документа со значением из схемы по их JSON
+func jsonEqual(value interface{}, expected json.RawMessage) bool {
+    encoded, err := json.Marshal(value)
+    if err != nil {
+        return false
+    }
+    var compact bytes.Buffer
+    if err := json.Compact(&compact, expected); err != nil {
+        return false
+    }
+    return bytes.Equal(encoded, compact.Bytes())
+}
+
+// jsonPointerEscape экранирует имя свойства для JSON Pointer (RFC 6901)
+func jsonPointerEscape(name string) string {
+    return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
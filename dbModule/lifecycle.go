@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+// CloseTimeout - сколько Close ждет завершения начатых операций
+var CloseTimeout = 10 * time.Second
+
+// lifecycle считает выполняющиеся операции с базой, общий для всех копий Database
+type lifecycle struct {
+    mu      sync.Mutex
+    closing bool
+    active  int
+    // idle закрывается, когда после начала закрытия не осталось операций
+    idle chan struct{}
+}
+
+// beginOperation отмечает начало операции с базой; возвращаемую функцию нужно вызвать по ее окончании.
+// После начала закрытия возвращает ErrClosed. Внутри транзакции операции не учитываются:
+// транзакция уже учтена целиком и должна дойти до конца
+func (db *Database) beginOperation() (func(), error) {
+    l := db.lifecycle
+    if l == nil || db.tx != nil {
+        return func() {}, nil
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.closing {
+        return nil, ErrClosed
+    }
+    l.active++
+
+    var once sync.Once
+    return func() { once.Do(l.end) }, nil
+}
+
+// end отмечает окончание операции
+func (l *lifecycle) end() {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.active--
+    if l.closing && l.active == 0 {
+        close(l.idle)
+    }
+}
+
+// Close закрывает базу, дождавшись начатых операций не дольше CloseTimeout (см. Shutdown)
+func (db *Database) Close() error {
+    ctx, cancel := context.WithTimeout(context.Background(), CloseTimeout)
+    defer cancel()
+    return db.Shutdown(ctx)
+}
+
+// Shutdown закрывает базу: выполняющиеся задания отменяются (см. SubmitJob), неотправленные события
+// вебхуков записываются в webhook_dead_letters (см. SetWebhooks), новые операции
+// сразу получают ErrClosed, а начатые запросы и транзакции (в том числе незакрытые курсоры)
+// дожидаются до отмены ctx. Затем закрываются подготовленные запросы WarmUp и пулы соединений основной базы и реплик. Если ctx отменен раньше, база все равно закрывается,
+// а возвращается ошибка с числом прерванных операций. Повторный вызов ничего не делает
+func (db *Database) Shutdown(ctx context.Context) error {
+    l := db.lifecycle
+    if l == nil {
+        return db.DB.Close()
+    }
+
+    l.mu.Lock()
+    if l.closing {
+        l.mu.Unlock()
+        return nil
+    }
+    l.mu.Unlock()
+    // задания останавливаются до закрытия, чтобы успеть записать, что они отменены
+    if db.jobs != nil {
+        db.jobs.stop(ctx)
+    }
+    // а вебхуки - чтобы успеть записать недоставленные события
+    if db.webhooks != nil {
+        db.webhooks.stop(ctx)
+    }
+
+    l.mu.Lock()
+    if l.closing {
+        l.mu.Unlock()
+        return nil
+    }
+    l.closing = true
+    l.idle = make(chan struct{})
+    if l.active == 0 {
+        close(l.idle)
+    }
+    l.mu.Unlock()
+
+    var waitErr error
+    select {
+    case <-l.idle:
+    case <-ctx.Done():
+        l.mu.Lock()
+        waitErr = fmt.Errorf("closing with %d operations still running: %w", l.active, ctx.Err())
+        l.mu.Unlock()
+    }
+
+    db.writes.close()
+    if db.statements != nil {
+        db.statements.closeAll()
+    }
+    if err := errors.Join(db.DB.Close(), db.replicas.close()); err != nil {
+        return err
+    }
+    return waitErr
+}
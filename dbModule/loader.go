@@ -0,0 +1,198 @@
+package main
+
+import (
+    "errors"
+    "strings"
+    "sync"
+    "time"
+)
+
+// LoaderWait - сколько Loader ждет другие запросы, прежде чем выполнить накопленную пачку
+var LoaderWait = time.Millisecond
+
+// loaderMaxBatch ограничивает число ID в одном IN: у Oracle предел 1000 элементов, у SQL Server - 2100 параметров
+const loaderMaxBatch = 500
+
+// Loader объединяет выборки пользователей и ресторанов по ID в рамках одного запроса к API:
+// вызовы из параллельных горутин в течение LoaderWait выполняются одним SELECT ... WHERE id IN (...),
+// а результаты (в том числе ErrNotFound) запоминаются до конца жизни Loader.
+// Loader создается на каждый входящий запрос и не видит изменений, сделанных после первой выборки
+type Loader struct {
+    db          *Database
+    users       *batchLoader[User]
+    restaurants *batchLoader[Restaurant]
+    // owned - рестораны по ID владельца
+    owned       *batchLoader[[]Restaurant]
+}
+
+// NewLoader создает Loader для площадки db
+func (db *Database) NewLoader() *Loader {
+    return &Loader{
+        db: db,
+        users: newBatchLoader(func(ids []int) (map[int]User, error) {
+            return loadByIDs(db, "users.select_by_ids", ids, db.scanUser, func(user User) int { return user.ID })
+        }),
+        restaurants: newBatchLoader(func(ids []int) (map[int]Restaurant, error) {
+            return loadByIDs(db, "restaurants.select_by_ids", ids, scanRestaurant, func(restaurant Restaurant) int { return restaurant.ID })
+        }),
+        owned: newBatchLoader(loadOwnedRestaurants(db)),
+    }
+}
+
+// User возвращает пользователя по ID или ErrNotFound, как GetUserByID
+func (l *Loader) User(id int) (User, error) {
+    user, err := l.users.load(id)
+    return user, l.db.opError("get", "user", id, err)
+}
+
+// Restaurant возвращает ресторан по ID или ErrNotFound, как GetRestaurantByID
+func (l *Loader) Restaurant(id int) (Restaurant, error) {
+    restaurant, err := l.restaurants.load(id)
+    return restaurant, l.db.opError("get", "restaurant", id, err)
+}
+
+// RestaurantsByUser возвращает рестораны владельца userID в порядке ID; у пользователя без ресторанов
+// и у несуществующего пользователя список пуст
+func (l *Loader) RestaurantsByUser(userID int) ([]Restaurant, error) {
+    restaurants, err := l.owned.load(userID)
+    if errors.Is(err, ErrNotFound) {
+        return []Restaurant{}, nil
+    }
+    return restaurants, l.db.opError("select", "restaurants of user", userID, err)
+}
+
+// loadOwnedRestaurants возвращает выборку ресторанов нескольких владельцев одним запросом
+func loadOwnedRestaurants(db *Database) func(userIDs []int) (map[int][]Restaurant, error) {
+    return func(userIDs []int) (map[int][]Restaurant, error) {
+        query, err := db.selectNamed("restaurants.select_by_ids")
+        if err != nil {
+            return nil, err
+        }
+        args := make([]interface{}, len(userIDs))
+        for i, id := range userIDs {
+            args[i] = id
+        }
+        query.Where("tenant_id = ?", db.tenant)
+        query.Where("user_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ")+")", args...)
+        query.OrderBy("id", false)
+
+        rows, err := db.queryBuilt("restaurants.select_by_ids", query)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+
+        found := make(map[int][]Restaurant, len(userIDs))
+        for rows.Next() {
+            restaurant, err := scanRestaurant(rows)
+            if err != nil {
+                return nil, err
+            }
+            found[restaurant.UserID] = append(found[restaurant.UserID], restaurant)
+        }
+        return found, rows.Err()
+    }
+}
+
+// loadByIDs выбирает строки текущей площадки с заданными ID запросом реестра без WHERE
+func loadByIDs[T any](db *Database, name string, ids []int, scan func(*queryRows) (T, error), id func(T) int) (map[int]T, error) {
+    query, err := db.selectNamed(name)
+    if err != nil {
+        return nil, err
+    }
+    args := make([]interface{}, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    query.Where("tenant_id = ?", db.tenant)
+    query.Where("id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+")", args...)
+
+    rows, err := db.queryBuilt(name, query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    found := make(map[int]T, len(ids))
+    for rows.Next() {
+        value, err := scan(rows)
+        if err != nil {
+            return nil, err
+        }
+        found[id(value)] = value
+    }
+    return found, rows.Err()
+}
+
+// loaderCall - ожидание результата по одному ID
+type loaderCall[T any] struct {
+    done  chan struct{}
+    value T
+    err   error
+}
+
+// batchLoader копит ID в пачку и выполняет fetch для нее целиком
+type batchLoader[T any] struct {
+    fetch func(ids []int) (map[int]T, error)
+
+    mu      sync.Mutex
+    calls   map[int]*loaderCall[T]
+    pending []int
+}
+
+// newBatchLoader создает пустой batchLoader
+func newBatchLoader[T any](fetch func(ids []int) (map[int]T, error)) *batchLoader[T] {
+    return &batchLoader[T]{fetch: fetch, calls: make(map[int]*loaderCall[T])}
+}
+
+// load возвращает значение по id, дожидаясь выполнения пачки, в которую он попал
+func (b *batchLoader[T]) load(id int) (T, error) {
+    b.mu.Lock()
+    call, ok := b.calls[id]
+    if !ok {
+        call = &loaderCall[T]{done: make(chan struct{})}
+        b.calls[id] = call
+        b.pending = append(b.pending, id)
+        switch len(b.pending) {
+        case 1:
+            time.AfterFunc(LoaderWait, b.dispatch)
+        case loaderMaxBatch:
+            go b.dispatch()
+        }
+    }
+    b.mu.Unlock()
+
+    <-call.done
+    return call.value, call.err
+}
+
+// dispatch выполняет накопленную пачку. Ошибка выборки не запоминается:
+// следующий вызов с теми же ID повторит запрос
+func (b *batchLoader[T]) dispatch() {
+    b.mu.Lock()
+    ids := b.pending
+    b.pending = nil
+    b.mu.Unlock()
+    if len(ids) == 0 {
+        return
+    }
+
+    values, err := b.fetch(ids)
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for _, id := range ids {
+        call := b.calls[id]
+        value, ok := values[id]
+        switch {
+        case err != nil:
+            call.err = err
+            delete(b.calls, id)
+        case !ok:
+            call.err = ErrNotFound
+        default:
+            call.value = value
+        }
+        close(call.done)
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+// UserWithRestaurants - пользователь вместе со всеми его ресторанами
+type UserWithRestaurants struct {
+    User        User
+    Restaurants []Restaurant
+}
+
+// GetUserByID возвращает пользователя по ID или ErrNotFound; результат кешируется (см. SetEntityCache)
+func (db *Database) GetUserByID(id int) (User, error) {
+    user, err := cachedLookup(db, "user", id, func() (User, error) {
+        user, err := db.findUser("users.select_by_id", id, db.tenant)
+        if err != nil || user == nil {
+            return User{}, notFoundIfNil(err)
+        }
+        return *user, nil
+    })
+    return user, db.opError("get", "user", id, err)
+}
+
+// GetRestaurantByID возвращает ресторан по ID или ErrNotFound; результат кешируется (см. SetEntityCache)
+func (db *Database) GetRestaurantByID(id int) (Restaurant, error) {
+    restaurant, err := cachedLookup(db, "restaurant", id, func() (Restaurant, error) {
+        restaurant, err := db.findRestaurant("restaurants.select_by_id", id, db.tenant)
+        if err != nil || restaurant == nil {
+            return Restaurant{}, notFoundIfNil(err)
+        }
+        return *restaurant, nil
+    })
+    return restaurant, db.opError("get", "restaurant", id, err)
+}
+
+// notFoundIfNil возвращает err или ErrNotFound, если выборка прошла без ошибки, но строки нет
+func notFoundIfNil(err error) error {
+    if err == nil {
+        return ErrNotFound
+    }
+    return err
+}
+
+// GetUserWithRestaurants загружает пользователя и его рестораны двумя запросами в одной транзакции,
+// чтобы оба результата соответствовали одному состоянию базы
+func (db *Database) GetUserWithRestaurants(userID int) (UserWithRestaurants, error) {
+    var result UserWithRestaurants
+    err := db.InTx(func(tx *Database) error {
+        user, err := tx.GetUserByID(userID)
+        if err != nil {
+            return err
+        }
+        result.User = user
+
+        rows, err := tx.queryNamed("restaurants.select_by_user", userID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+
+        for rows.Next() {
+            restaurant, err := scanRestaurant(rows)
+            if err != nil {
+                return err
+            }
+            result.Restaurants = append(result.Restaurants, restaurant)
+        }
+        return rows.Err()
+    })
+    return result, db.opError("get", "user with restaurants", userID, err)
+}
+
+// RestaurantWithMenu - ресторан вместе с его меню
+type RestaurantWithMenu struct {
+    Restaurant Restaurant
+    Menu       []MenuItem
+}
+
+// SelectRestaurantWithMenu загружает ресторан и его меню в одной транзакции, как GetUserWithRestaurants
+func (db *Database) SelectRestaurantWithMenu(restaurantID int) (RestaurantWithMenu, error) {
+    var result RestaurantWithMenu
+    err := db.InTx(func(tx *Database) error {
+        restaurant, err := tx.GetRestaurantByID(restaurantID)
+        if err != nil {
+            return err
+        }
+        result.Restaurant = restaurant
+
+        result.Menu, err = tx.MenuItemsByRestaurant(restaurantID)
+        return err
+    })
+    return result, db.opError("get", "restaurant with menu", restaurantID, err)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "log"
+    "os"
+    "time"
+)
+
+// migrationLock - блокировка, под которой Migrate применяет миграции (см. LockMigration)
+const migrationLock = "migrate"
+
+// LockOptions настраивают WithLockOptions
+type LockOptions struct {
+    // Wait - сколько ждать блокировку, занятую другим экземпляром; 0 - сразу вернуть ErrLocked
+    Wait time.Duration
+    // TTL - срок блокировки в таблице locks: владелец продлевает ее каждую треть TTL, а блокировку
+    // упавшего экземпляра другие забирают, когда срок истек. В PostgreSQL не используется
+    TTL time.Duration
+}
+
+// DefaultLockOptions - ожидания хватает на миграции больших таблиц другим экземпляром
+var DefaultLockOptions = LockOptions{Wait: 10 * time.Minute, TTL: time.Minute}
+
+// lockPollInterval - как часто проверяется, освободилась ли занятая блокировка
+var lockPollInterval = 250 * time.Millisecond
+
+// Lock - блокировка в таблице locks (см. Locks)
+type Lock struct {
+    Name       string
+    Owner      string
+    AcquiredAt time.Time
+    ExpiresAt  time.Time
+}
+
+// WithLock выполняет fn под блокировкой name, общей для всех экземпляров, работающих с этой базой
+// (с тем же префиксом таблиц), с DefaultLockOptions
+func (db *Database) WithLock(name string, fn func() error) error {
+    return db.WithLockOptions(name, DefaultLockOptions, fn)
+}
+
+// WithLockOptions выполняет fn под блокировкой name: ждет ее не дольше options.Wait и возвращает
+// ErrLocked, если она так и не освободилась. В PostgreSQL это рекомендательная блокировка сеанса
+// (pg_try_advisory_lock) на отдельном соединении, в остальных СУБД - строка таблицы locks со сроком
+// options.TTL. Внутри транзакции блокировку брать нельзя: ее строка зафиксировалась бы только с транзакцией
+func (db *Database) WithLockOptions(name string, options LockOptions, fn func() error) error {
+    release, err := db.acquireLock(name, options)
+    if err != nil {
+        return db.opError("acquire", "lock", name, err)
+    }
+    err = fn()
+    if releaseErr := release(); err == nil {
+        err = releaseErr
+    }
+    return err
+}
+
+// LockMigration берет блокировку, под которой Migrate применяет миграции, и возвращает функцию,
+// которая ее снимает. Так второй экземпляр, запущенный одновременно, дожидается миграций первого
+// и видит их примененными, а не применяет те же миграции повторно
+func (db *Database) LockMigration() (func() error, error) {
+    return db.acquireLock(migrationLock, DefaultLockOptions)
+}
+
+// acquireLock ждет блокировку name и возвращает функцию, которая ее снимает
+func (db *Database) acquireLock(name string, options LockOptions) (func() error, error) {
+    if db.tx != nil {
+        return nil, fmt.Errorf("lock %s: cannot be acquired inside a transaction", name)
+    }
+    deadline := time.Now().Add(options.Wait)
+    for {
+        release, err := db.tryLock(name, options.TTL)
+        if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+            return release, err
+        }
+        time.Sleep(lockPollInterval)
+    }
+}
+
+// tryLock берет блокировку name, если она свободна, иначе возвращает ErrLocked
+func (db *Database) tryLock(name string, ttl time.Duration) (func() error, error) {
+    if db.driver.dialect.Name() == "postgres" {
+        return db.tryAdvisoryLock(name)
+    }
+    owner, err := lockOwner()
+    if err != nil {
+        return nil, err
+    }
+    now := db.now().UTC()
+    if _, err := db.execNamed("locks.insert", name, owner, now, now.Add(ttl)); err != nil {
+        if !db.driver.isUniqueError(err) {
+            return nil, fmt.Errorf("lock %s: %w", name, err)
+        }
+        result, err := db.execNamed("locks.take_expired", owner, now, now.Add(ttl), name, now)
+        if err != nil {
+            return nil, fmt.Errorf("lock %s: %w", name, err)
+        }
+        if taken, err := result.RowsAffected(); err != nil || taken == 0 {
+            return nil, fmt.Errorf("lock %s: %w", name, ErrLocked)
+        }
+        log.Printf("lock %s: took over an expired lock", name)
+    }
+
+    stop, stopped := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(ttl / 3)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                result, err := db.execNamed("locks.renew", db.now().UTC().Add(ttl), name, owner)
+                if err == nil {
+                    if renewed, _ := result.RowsAffected(); renewed == 0 {
+                        err = errors.New("the lock was taken over by another instance")
+                    }
+                }
+                if err != nil {
+                    log.Printf("lock %s: cannot renew: %v", name, err)
+                }
+            }
+        }
+    }()
+    return func() error {
+        close(stop)
+        <-stopped
+        _, err := db.execNamed("locks.release", name, owner)
+        return err
+    }, nil
+}
+
+// tryAdvisoryLock берет рекомендательную блокировку PostgreSQL на отдельном соединении: она держится,
+// пока соединение открыто, и снимается сервером, если процесс упал
+func (db *Database) tryAdvisoryLock(name string) (func() error, error) {
+    lock, err := db.lookupQuery("locks.try_advisory")
+    if err != nil {
+        return nil, err
+    }
+    unlock, err := db.lookupQuery("locks.unlock_advisory")
+    if err != nil {
+        return nil, err
+    }
+    // ключ зависит от префикса таблиц: экземпляры с разными префиксами не мешают друг другу
+    hash := fnv.New64a()
+    hash.Write([]byte(db.table(name)))
+    key := int64(hash.Sum64())
+
+    ctx := db.baseContext()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("lock %s: %w", name, err)
+    }
+    var locked bool
+    if err := conn.QueryRowContext(ctx, db.driver.dialect.Rebind(lock), key).Scan(&locked); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("lock %s: %w", name, err)
+    }
+    if !locked {
+        conn.Close()
+        return nil, fmt.Errorf("lock %s: %w", name, ErrLocked)
+    }
+    return func() error {
+        defer conn.Close()
+        _, err := conn.ExecContext(ctx, db.driver.dialect.Rebind(unlock), key)
+        return err
+    }, nil
+}
+
+// lockOwner возвращает имя владельца блокировки: хост, процесс и случайный суффикс, чтобы
+// блокировки одного процесса в разных горутинах не считались одной
+func lockOwner() (string, error) {
+    host, err := os.Hostname()
+    if err != nil {
+        host = "unknown"
+    }
+    suffix := make([]byte, 4)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix)), nil
+}
+
+// Locks возвращает блокировки из таблицы locks; рекомендательные блокировки PostgreSQL в ней не видны
+func (db *Database) Locks() ([]Lock, error) {
+    rows, err := db.WithPrimary().queryNamed("locks.select")
+    if err != nil {
+        return nil, db.opError("list", "locks", nil, err)
+    }
+    defer rows.Close()
+
+    var locks []Lock
+    for rows.Next() {
+        var lock Lock
+        if err := rows.Scan(&lock.Name, &lock.Owner, &lock.AcquiredAt, &lock.ExpiresAt); err != nil {
+            return nil, db.opError("list", "locks", nil, err)
+        }
+        locks = append(locks, lock)
+    }
+    return locks, db.opError("list", "locks", nil, rows.Err())
+}
+
+// runLocks выводит блокировки экземпляров и их владельцев
+func runLocks(db *Database, args []string) error {
+    locks, err := db.Locks()
+    if err != nil {
+        return err
+    }
+    now := db.now().UTC()
+    for _, lock := range locks {
+        status := "held"
+        if lock.ExpiresAt.Before(now) {
+            status = "expired"
+        }
+        fmt.Printf("%-24s %-40s since %s, %s\n", lock.Name, lock.Owner, lock.AcquiredAt.Format(time.RFC3339), status)
+    }
+    fmt.Printf("%d locks\n", len(locks))
+    return nil
+}
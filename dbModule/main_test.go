@@ -0,0 +1,265 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "os"
+    "reflect"
+    "slices"
+    "testing"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+    // пароли тестовых пользователей никто не подбирает, а bcrypt обычной стоимости под -race занял бы почти все время тестов
+    PasswordHashCost = bcrypt.MinCost
+    os.Exit(m.Run())
+}
+
+// TestInvariantViolations проверяет, что запись с нарушениями правил сущности не доходит до базы,
+// а ошибка перечисляет все нарушенные поля сразу
+func TestInvariantViolations(t *testing.T) {
+    db := NewTestDatabase(t)
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Invariant-Passw0rd!", Role: RoleOwner}
+    if _, err := db.InsertUserReturningID(&owner); err != nil {
+        t.Fatal(err)
+    }
+    restaurant := Restaurant{Name: "Cafe", Type: "cafe", AveragePrice: PriceTierBudget, UserID: owner.ID}
+    if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+        t.Fatal(err)
+    }
+    restaurant, err := db.GetRestaurantByID(restaurant.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    review := Review{UserID: owner.ID, RestaurantID: restaurant.ID, Rating: 4, Comment: "fine"}
+    if err := db.InsertReview(&review); err != nil {
+        t.Fatal(err)
+    }
+
+    negative, currency := int64(-100), "EUR"
+    starts := time.Date(2026, 3, 1, 19, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name   string
+        run    func() error
+        entity string
+        fields []string
+    }{
+        {"price tier and amount", func() error {
+            return db.InsertRestaurant(Restaurant{Name: "Cheap", AveragePrice: 0, UserID: owner.ID, PriceAmount: &negative, PriceCurrency: &currency})
+        }, "restaurant", []string{"average_price", "price_amount"}},
+        {"name and price tier", func() error {
+            return db.InsertRestaurant(Restaurant{AveragePrice: 6, UserID: owner.ID})
+        }, "restaurant", []string{"name", "average_price"}},
+        {"update price tier", func() error {
+            changed := restaurant
+            changed.AveragePrice = 0
+            _, err := db.UpdateRestaurant(owner.ID, &changed)
+            return err
+        }, "restaurant", []string{"average_price"}},
+        {"reservation end and capacity", func() error {
+            return db.CreateBookingSlot(&BookingSlot{RestaurantID: restaurant.ID, StartsAt: starts, EndsAt: starts.Add(-time.Hour)})
+        }, "booking slot", []string{"ends_at", "capacity"}},
+        {"reservation ends when it starts", func() error {
+            return db.CreateBookingSlot(&BookingSlot{RestaurantID: restaurant.ID, StartsAt: starts, EndsAt: starts, Capacity: 4})
+        }, "booking slot", []string{"ends_at"}},
+        {"rating below 1", func() error {
+            return db.InsertReview(&Review{UserID: owner.ID, RestaurantID: restaurant.ID, Rating: 0})
+        }, "review", []string{"rating"}},
+        {"rating above 5", func() error {
+            changed := review
+            changed.Rating = 6
+            return db.UpdateReview(&changed)
+        }, "review", []string{"rating"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.run()
+            if !errors.Is(err, ErrValidation) {
+                t.Fatalf("got %v, want ErrValidation", err)
+            }
+            var invariantErr *InvariantError
+            if !errors.As(err, &invariantErr) {
+                t.Fatalf("errors.As(%q, *InvariantError) = false", err)
+            }
+            if invariantErr.Entity != tt.entity {
+                t.Errorf("violations of %q, want %q", invariantErr.Entity, tt.entity)
+            }
+            var fields []string
+            for _, violation := range invariantErr.Violations {
+                fields = append(fields, violation.Field)
+            }
+            if !slices.Equal(fields, tt.fields) {
+                t.Errorf("violated fields %v, want %v", fields, tt.fields)
+            }
+        })
+    }
+
+    restaurants, err := db.SelectRestaurants()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(restaurants) != 1 || restaurants[0].AveragePrice != PriceTierBudget {
+        t.Errorf("restaurants after rejected writes: %+v", restaurants)
+    }
+    slots, err := db.BookingSlots(restaurant.ID, starts.Add(-24*time.Hour), starts.Add(24*time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(slots) != 0 {
+        t.Errorf("rejected booking slots were saved: %+v", slots)
+    }
+    reviews, err := db.ReviewsByRestaurant(restaurant.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(reviews) != 1 || reviews[0].Rating != 4 {
+        t.Errorf("reviews after rejected writes: %+v", reviews)
+    }
+}
+
+// TestNullableFieldsRoundTrip проверяет, что необязательные Phone и Keys вперемешку с заполненными
+// записываются как NULL и значения и читаются обратно всеми выборками без ошибок сканирования
+func TestNullableFieldsRoundTrip(t *testing.T) {
+    db := NewTestDatabase(t)
+    phone, keys := "+7 900 000-00-00", "wifi,terrace"
+    users := []User{
+        {Name: "Olga", Lastname: "Owner", Email: "olga@example.com", Password: "Nullable-Passw0rd!", Role: RoleOwner, Phone: &phone},
+        {Name: "Oleg", Lastname: "Owner", Email: "oleg@example.com", Password: "Nullable-Passw0rd!", Role: RoleOwner},
+    }
+    for i := range users {
+        if _, err := db.InsertUserReturningID(&users[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    restaurants := []Restaurant{
+        {Name: "Keys", Type: "cafe", AveragePrice: PriceTierBudget, UserID: users[0].ID, Keys: &keys},
+        {Name: "No keys", Type: "cafe", AveragePrice: PriceTierBudget, UserID: users[1].ID},
+    }
+    for i := range restaurants {
+        if _, err := db.InsertRestaurantReturningID(&restaurants[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for _, user := range users {
+        var stored sql.NullString
+        if err := db.DB.QueryRow("SELECT phone FROM users WHERE id = ?", user.ID).Scan(&stored); err != nil {
+            t.Fatal(err)
+        }
+        if stored.Valid != (user.Phone != nil) {
+            t.Errorf("user %d phone stored as %+v, want NULL only for nil", user.ID, stored)
+        }
+    }
+
+    checkUser := func(t *testing.T, got User) {
+        t.Helper()
+        for _, want := range users {
+            if got.ID == want.ID && !reflect.DeepEqual(got.Phone, want.Phone) {
+                t.Errorf("user %d phone %q, want %q", got.ID, stringValue(got.Phone), stringValue(want.Phone))
+            }
+        }
+    }
+    checkRestaurant := func(t *testing.T, got Restaurant) {
+        t.Helper()
+        for _, want := range restaurants {
+            if got.ID == want.ID && !reflect.DeepEqual(got.Keys, want.Keys) {
+                t.Errorf("restaurant %d keys %q, want %q", got.ID, stringValue(got.Keys), stringValue(want.Keys))
+            }
+        }
+    }
+
+    t.Run("select", func(t *testing.T) {
+        selectedUsers, err := db.SelectUsers()
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, user := range selectedUsers {
+            checkUser(t, user)
+        }
+        selectedRestaurants, err := db.SelectRestaurants()
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, restaurant := range selectedRestaurants {
+            checkRestaurant(t, restaurant)
+        }
+    })
+    t.Run("iterate", func(t *testing.T) {
+        for user, err := range db.SelectUsersIter() {
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkUser(t, user)
+        }
+        for restaurant, err := range db.SelectRestaurantsIter() {
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkRestaurant(t, restaurant)
+        }
+    })
+    t.Run("by id", func(t *testing.T) {
+        for _, want := range users {
+            user, err := db.GetUserByID(want.ID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkUser(t, user)
+        }
+        for _, want := range restaurants {
+            restaurant, err := db.GetRestaurantByID(want.ID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkRestaurant(t, restaurant)
+        }
+    })
+    t.Run("join", func(t *testing.T) {
+        listings, err := db.SelectJoin()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(listings) != len(restaurants) {
+            t.Fatalf("got %d listings, want %d", len(listings), len(restaurants))
+        }
+        for _, listing := range listings {
+            checkUser(t, listing.User)
+            checkRestaurant(t, listing.Restaurant)
+        }
+    })
+
+    // значение можно стереть обратно в NULL и снова заполнить
+    t.Run("update", func(t *testing.T) {
+        user, err := db.GetUserByID(users[0].ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        user.Phone = nil
+        if _, err := db.UpdateUser(&user); err != nil {
+            t.Fatal(err)
+        }
+        restaurant, err := db.GetRestaurantByID(restaurants[1].ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        restaurant.Keys = &keys
+        if _, err := db.UpdateRestaurant(users[1].ID, &restaurant); err != nil {
+            t.Fatal(err)
+        }
+        users[0].Phone, restaurants[1].Keys = nil, &keys
+
+        updatedUser, err := db.GetUserByID(users[0].ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        checkUser(t, updatedUser)
+        updatedRestaurant, err := db.GetRestaurantByID(restaurants[1].ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        checkRestaurant(t, updatedRestaurant)
+    })
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+// maintenanceSetting - имя настройки в таблице settings, включающей режим обслуживания
+const maintenanceSetting = "maintenance"
+
+// maintenanceRefresh - как долго процесс доверяет закешированному флагу обслуживания,
+// прежде чем перечитать его из settings: так флаг, включенный в другом процессе, доходит до всех
+const maintenanceRefresh = 5 * time.Second
+
+// maintenanceExempt - пространства имен запросов, которые выполняются и в режиме обслуживания:
+// сама настройка, учет миграций, блокировки экземпляров, учет потребления площадок, события,
+// которые не удалось доставить на вебхуки, и VACUUM с ANALYZE задач обслуживания
+var maintenanceExempt = map[string]bool{
+    "settings":             true,
+    "migrations":           true,
+    "locks":                true,
+    "tenant_usage":         true,
+    "webhook_dead_letters": true,
+    "scheduler":            true,
+}
+
+// maintenanceState - закешированный флаг режима обслуживания
+type maintenanceState struct {
+    mu      sync.Mutex
+    enabled bool
+    checked time.Time
+}
+
+// GetSetting возвращает значение глобальной настройки; ok == false, если она не задана.
+// Настройки читаются из основной базы, чтобы режим обслуживания включался без задержки репликации
+func (db *Database) GetSetting(name string) (value string, ok bool, err error) {
+    rows, err := db.WithPrimary().queryNamed("settings.select", name)
+    if err != nil {
+        return "", false, db.opError("get", "setting", name, err)
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return "", false, db.opError("get", "setting", name, rows.Err())
+    }
+    if err := rows.Scan(&value); err != nil {
+        return "", false, db.opError("get", "setting", name, err)
+    }
+    return value, true, db.opError("get", "setting", name, rows.Err())
+}
+
+// SetSetting сохраняет глобальную настройку, видимую всем процессам с этой базой
+func (db *Database) SetSetting(name, value string) error {
+    _, err := db.execNamed("settings.upsert", name, value)
+    return db.opError("set", "setting", name, err)
+}
+
+// SetMaintenance включает или выключает режим обслуживания. В нем запись через любую Database
+// с этой базой завершается ErrMaintenance, а чтение работает как обычно. Другие процессы
+// замечают переключение в течение maintenanceRefresh
+func (db *Database) SetMaintenance(enabled bool) error {
+    value := "off"
+    if enabled {
+        value = "on"
+    }
+    if err := db.SetSetting(maintenanceSetting, value); err != nil {
+        return err
+    }
+
+    db.maintenance.mu.Lock()
+    db.maintenance.enabled, db.maintenance.checked = enabled, db.now()
+    db.maintenance.mu.Unlock()
+    return nil
+}
+
+// InMaintenance сообщает, включен ли режим обслуживания
+func (db *Database) InMaintenance() (bool, error) {
+    state := db.maintenance
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    if !state.checked.IsZero() && db.now().Sub(state.checked) < maintenanceRefresh {
+        return state.enabled, nil
+    }
+
+    value, _, err := db.GetSetting(maintenanceSetting)
+    if err != nil {
+        return false, err
+    }
+    state.enabled, state.checked = value == "on", db.now()
+    return state.enabled, nil
+}
+
+// IgnoringMaintenance возвращает копию Database, которой разрешена запись в режиме обслуживания:
+// через нее оператор выполняет миграции и исправления, пока остальные клиенты только читают
+func (db *Database) IgnoringMaintenance() *Database {
+    admin := *db
+    admin.ignoreMaintenance = true
+    return &admin
+}
+
+// checkWritable возвращает ErrMaintenance с именем запроса, если запрос name изменяет данные во время обслуживания
+func (db *Database) checkWritable(name string) error {
+    namespace := strings.SplitN(name, ".", 2)[0]
+    if db.ignoreMaintenance || maintenanceExempt[namespace] {
+        return nil
+    }
+    enabled, err := db.InMaintenance()
+    if err != nil {
+        return fmt.Errorf("%s: %w", name, err)
+    }
+    if enabled {
+        return fmt.Errorf("%s: %w", name, ErrMaintenance)
+    }
+    return nil
+}
+
+// runMaintenance переключает режим обслуживания или выводит его состояние
+func runMaintenance(db *Database, args []string) error {
+    action := "status"
+    if len(args) > 0 {
+        action = args[0]
+    }
+
+    switch action {
+    case "on", "off":
+        if err := db.SetMaintenance(action == "on"); err != nil {
+            return err
+        }
+    case "status":
+    default:
+        return fmt.Errorf("usage: maintenance on|off|status")
+    }
+
+    enabled, err := db.InMaintenance()
+    if err != nil {
+        return err
+    }
+    if enabled {
+        fmt.Println("maintenance mode is on: writes are rejected")
+    } else {
+        fmt.Println("maintenance mode is off")
+    }
+    return nil
+}
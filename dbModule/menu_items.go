@@ -0,0 +1,179 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+)
+
+// MenuItem - блюдо в меню ресторана
+type MenuItem struct {
+    ID           int    `json:"id"`
+    RestaurantID int    `json:"restaurant_id"`
+    Name         string `json:"name"`
+    Price        int    `json:"price"`
+    // Category - раздел меню, например "десерты"; может быть пустым
+    Category     string `json:"category"`
+    // TenantID - площадка блюда; при записи берется из WithTenant
+    TenantID     int    `json:"tenant_id"`
+}
+
+// InsertMenuItem добавляет блюдо и заполняет его ID и площадку.
+// Блюда удаляются вместе с рестораном
+func (db *Database) InsertMenuItem(item *MenuItem) error {
+    items := []MenuItem{*item}
+    if err := db.InsertMenuItems(items); err != nil {
+        return err
+    }
+    *item = items[0]
+    return nil
+}
+
+// InsertMenuItems добавляет блюда одной транзакцией: либо все, либо ни одного.
+// ID и площадка записываются в элементы items; после ошибки они недействительны
+func (db *Database) InsertMenuItems(items []MenuItem) error {
+    for _, item := range items {
+        if err := validateMenuItem(item); err != nil {
+            return db.opError("insert", "menu item", item.Name, err)
+        }
+    }
+
+    return db.InTx(func(tx *Database) error {
+        for i := range items {
+            if err := tx.insertMenuItem(&items[i]); err != nil {
+                return tx.opError("insert", "menu item", items[i].Name, err)
+            }
+        }
+        return nil
+    })
+}
+
+// insertMenuItem добавляет одно блюдо в транзакции tx и записывает это в журнал аудита
+func (db *Database) insertMenuItem(item *MenuItem) error {
+    if err := beforeInsert(db, "menu_item", item, validateMenuItem); err != nil {
+        return err
+    }
+    id, err := db.insertNamed("menu_items.insert", item.RestaurantID, item.Name, item.Price, item.Category, db.tenant)
+    if db.driver.isForeignKeyError(err) {
+        return fmt.Errorf("restaurant %d does not exist: %w", item.RestaurantID, ErrForeignKeyViolation)
+    }
+    if err != nil {
+        return err
+    }
+    created, err := db.findMenuItem(int(id))
+    if err != nil {
+        return err
+    }
+    if created == nil {
+        return ErrNotFound
+    }
+    *item = *created
+    return db.audit("menu_item", item.ID, AuditInsert, nil, created)
+}
+
+// GetMenuItemByID возвращает блюдо по ID или ErrNotFound; результат кешируется (см. SetEntityCache)
+func (db *Database) GetMenuItemByID(id int) (MenuItem, error) {
+    item, err := cachedLookup(db, "menu_item", id, func() (MenuItem, error) {
+        item, err := db.findMenuItem(id)
+        if err != nil || item == nil {
+            return MenuItem{}, notFoundIfNil(err)
+        }
+        return *item, nil
+    })
+    return item, db.opError("get", "menu item", id, err)
+}
+
+// MenuItemsByRestaurant возвращает меню ресторана по разделам и названиям
+func (db *Database) MenuItemsByRestaurant(restaurantID int) ([]MenuItem, error) {
+    rows, err := db.queryNamed("menu_items.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "menu items", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var items []MenuItem
+    for rows.Next() {
+        item, err := scanMenuItem(rows)
+        if err != nil {
+            return nil, db.opError("list", "menu items", restaurantID, err)
+        }
+        items = append(items, item)
+    }
+    return items, db.opError("list", "menu items", restaurantID, rows.Err())
+}
+
+// UpdateMenuItem сохраняет название, цену и раздел блюда; ресторан не меняется
+func (db *Database) UpdateMenuItem(item *MenuItem) error {
+    if err := validateMenuItem(*item); err != nil {
+        return db.opError("update", "menu item", item.ID, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findMenuItem(item.ID)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("menu_items.update", item.Name, item.Price, item.Category, item.ID, tx.tenant); err != nil {
+            return err
+        }
+        current, err := tx.findMenuItem(item.ID)
+        if err != nil {
+            return err
+        }
+        *item = *current
+        return tx.audit("menu_item", item.ID, AuditUpdate, old, current)
+    })
+    return db.opError("update", "menu item", item.ID, err)
+}
+
+// DeleteMenuItem удаляет блюдо; ErrNotFound, если его нет
+func (db *Database) DeleteMenuItem(id int) error {
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findMenuItem(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("menu_items.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        return tx.audit("menu_item", id, AuditDelete, old, nil)
+    })
+    return db.opError("delete", "menu item", id, err)
+}
+
+// findMenuItem читает блюдо текущей площадки; nil, если его нет
+func (db *Database) findMenuItem(id int) (*MenuItem, error) {
+    rows, err := db.queryNamed("menu_items.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    item, err := scanMenuItem(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &item, rows.Close()
+}
+
+// scanMenuItem читает блюдо из текущей строки
+func scanMenuItem(row *queryRows) (MenuItem, error) {
+    var item MenuItem
+    var category sql.NullString
+    err := row.Scan(&item.ID, &item.RestaurantID, &item.Name, &item.Price, &category, &item.TenantID)
+    item.Category = category.String
+    return item, err
+}
+
+// validateMenuItem проверяет блюдо перед записью по правилам сущности "menu item"
+func validateMenuItem(item MenuItem) error {
+    return checkInvariants("menu item", item)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "regexp"
+    "time"
+)
+
+// Middleware - промежуточный слой выполнения запросов: получает следующий Executor цепочки и
+// возвращает свой, который вызывает его. Так логирование, метрики, повторы и трассировка
+// собираются снаружи, а не встраиваются в Database. Слою, которому нужны не все методы,
+// достаточно встроить next и переопределить нужные, как это делает auditedConn
+type Middleware func(next Executor) Executor
+
+// Use добавляет промежуточные слои ко всем запросам базы: именованным, собранным в коде и
+// транзакций InTx, на основной базе и на репликах. Первый добавленный слой - внешний: после
+// Use(a, b) запрос проходит a, затем b. Вызывается до начала работы, копии WithTenant и другие
+// получают слои, добавленные до их создания
+func (db *Database) Use(middleware ...Middleware) {
+    db.middleware = append(db.middleware, middleware...)
+}
+
+// intercept оборачивает conn промежуточными слоями Use
+func (db *Database) intercept(conn Executor) Executor {
+    for i := len(db.middleware) - 1; i >= 0; i-- {
+        conn = db.middleware[i](conn)
+    }
+    return conn
+}
+
+// QueryInfo - сведения о выполняемом запросе для промежуточных слоев
+type QueryInfo struct {
+    // Name - имя запроса, например users.insert; по нему запрос виден в статистике и логах
+    Name string
+    // Operation - вид операции: read, write или migration (см. OperationTimeouts)
+    Operation string
+}
+
+// queryInfoKey - ключ QueryInfo в контексте запроса
+type queryInfoKey struct{}
+
+// withQueryInfo добавляет к контексту запроса его имя и вид операции
+func withQueryInfo(ctx context.Context, name string, op operation) context.Context {
+    return context.WithValue(ctx, queryInfoKey{}, QueryInfo{Name: name, Operation: op.String()})
+}
+
+// QueryInfoFromContext возвращает сведения о запросе из контекста, переданного Executor.
+// У служебных запросов без имени (миграции, резервные копии) сведений нет
+func QueryInfoFromContext(ctx context.Context) (QueryInfo, bool) {
+    info, ok := ctx.Value(queryInfoKey{}).(QueryInfo)
+    return info, ok
+}
+
+// transientErrorPattern - ошибки, после которых тот же запрос может выполниться: занятая блокировка
+// SQLite и оборванное соединение
+var transientErrorPattern = regexp.MustCompile(`(?i)database is locked|database table is locked|SQLITE_BUSY|connection reset|broken pipe|connection refused|bad connection`)
+
+// TransientError сообщает, что запрос стоит повторить: ошибка временная, а не в запросе или данных
+func TransientError(err error) bool {
+    return err != nil && (errors.Is(err, driver.ErrBadConn) || transientErrorPattern.MatchString(err.Error()))
+}
+
+// RetryReads возвращает слой, который повторяет чтения (QueryContext), завершившиеся временной
+// ошибкой (см. TransientError), до attempts раз с паузой delay, удваивая ее после каждой попытки.
+// Записи не повторяются: неизвестно, применилась ли неудавшаяся запись. Повторы не выходят
+// за таймаут операции
+func RetryReads(attempts int, delay time.Duration) Middleware {
+    return func(next Executor) Executor {
+        return retryingConn{Executor: next, attempts: attempts, delay: delay}
+    }
+}
+
+// retryingConn - слой RetryReads
+type retryingConn struct {
+    Executor
+    attempts int
+    delay    time.Duration
+}
+
+// QueryContext выполняет запрос, возвращающий строки, повторяя его после временной ошибки
+func (c retryingConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+    rows, err := c.Executor.QueryContext(ctx, query, args...)
+    delay := c.delay
+    for attempt := 0; attempt < c.attempts && TransientError(err); attempt++ {
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return nil, err
+        case <-timer.C:
+        }
+        delay *= 2
+        rows, err = c.Executor.QueryContext(ctx, query, args...)
+    }
+    return rows, err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// schemaNamespace - пространство имен реестра, в котором лежат миграции схемы
+const schemaNamespace = "schema."
+
+// schemaObjectsMigration создает таблицу schema_objects; до нее представления и триггеры не учитываются
+const schemaObjectsMigration = "0018_create_schema_objects"
+
+// Виды миграций, записываемые в таблицу migrations
+const (
+    migrationKindSchema = "schema"
+    migrationKindData   = "data"
+)
+
+// Migration описывает одну миграцию схемы или данных
+type Migration struct {
+    ID   string
+    Kind string
+    // SQL - текст миграции схемы из реестра; у data-миграций пустой
+    SQL string
+    // Done проверяет, что исправление уже не требуется (например, его накатили вручную).
+    // Если Done вернул true, Apply не вызывается, а миграция просто отмечается примененной
+    Done  func(tx *sql.Tx) (bool, error)
+    Apply func(tx *sql.Tx) error
+    // Rebuild задает пересборку таблицы для SQLite, где ALTER TABLE не меняет тип колонки:
+    // такая миграция выполняется с выключенными внешними ключами (см. RebuildTable).
+    // На остальных СУБД вместо нее выполняется Apply, а без Apply миграция только отмечается
+    Rebuild *TableRebuild
+}
+
+// dataMigrations содержит зарегистрированные data-миграции
+var dataMigrations []Migration
+
+// RegisterDataMigration регистрирует data-миграцию, выполняемую Go-функцией.
+// ID задает порядок относительно миграций схемы, например "0003_fill_empty_phones".
+// SQL в Apply не проходит через реестр, и префикс Config.TablePrefix к его таблицам не добавляется
+func RegisterDataMigration(m Migration) {
+    m.Kind = migrationKindData
+    dataMigrations = append(dataMigrations, m)
+}
+
+// migrations собирает миграции схемы из реестра, миграции индексов (см. indexMigrations)
+// и data-миграции в один список по порядку ID
+func (db *Database) migrations() ([]Migration, error) {
+    var all []Migration
+    for _, name := range db.queries.Names() {
+        // варианты для диалектов (schema.0001_x@postgres) подставляет lookupQuery
+        if !strings.HasPrefix(name, schemaNamespace) || strings.Contains(name, "@") {
+            continue
+        }
+        query, err := db.lookupQuery(name)
+        if err != nil {
+            return nil, err
+        }
+        if db.strict {
+            if query, err = strictSchema(query); err != nil {
+                return nil, fmt.Errorf("migration %s: %w", name, err)
+            }
+        }
+        all = append(all, Migration{
+            ID:   strings.TrimPrefix(name, schemaNamespace),
+            Kind: migrationKindSchema,
+            SQL:  query,
+            Apply: func(tx *sql.Tx) error {
+                _, err := tx.Exec(query)
+                return err
+            },
+        })
+    }
+    all = append(all, db.indexSchemaMigrations()...)
+    all = append(all, dataMigrations...)
+
+    sort.SliceStable(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+    for i := 1; i < len(all); i++ {
+        if all[i].ID == all[i-1].ID {
+            return nil, fmt.Errorf("duplicate migration %q", all[i].ID)
+        }
+    }
+    return all, nil
+}
+
+// appliedMigrations возвращает ID уже примененных миграций
+func (db *Database) appliedMigrations() (map[string]bool, error) {
+    rows, err := db.queryNamed("migrations.select_applied")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := make(map[string]bool)
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        applied[id] = true
+    }
+    return applied, rows.Err()
+}
+
+// PendingMigrations возвращает ID миграций, которые Migrate еще не применил, по порядку
+func (db *Database) PendingMigrations() ([]string, error) {
+    all, err := db.migrations()
+    if err != nil {
+        return nil, db.opError("list", "pending migrations", nil, err)
+    }
+    applied, err := db.appliedMigrations()
+    if err != nil {
+        return nil, db.opError("list", "pending migrations", nil, err)
+    }
+    var pending []string
+    for _, m := range all {
+        if !applied[m.ID] {
+            pending = append(pending, m.ID)
+        }
+    }
+    return pending, nil
+}
+
+// Migrate применяет все еще не примененные миграции. Каждая миграция выполняется
+// в своей транзакции вместе с записью в таблицу migrations, поэтому упавшую
+// миграцию можно безопасно перезапустить. Представления и триггеры конфигурации
+// (см. SchemaObjects) на время миграций удаляются, а затем создаются по текущим определениям
+func (db *Database) Migrate() error {
+    if _, err := db.execNamed("migrations.create_table"); err != nil {
+        return db.opError("migrate", "database", nil, err)
+    }
+    if _, err := db.execNamed("locks.create_table"); err != nil {
+        return db.opError("migrate", "database", nil, err)
+    }
+    // второй экземпляр ждет, пока первый применит миграции, и затем видит их примененными
+    release, err := db.LockMigration()
+    if err != nil {
+        return db.opError("migrate", "database", nil, err)
+    }
+    defer release()
+
+    all, err := db.migrations()
+    if err != nil {
+        return db.opError("migrate", "database", nil, err)
+    }
+
+    applied, err := db.appliedMigrations()
+    if err != nil {
+        return db.opError("migrate", "database", nil, err)
+    }
+
+    pending := false
+    for _, m := range all {
+        pending = pending || !applied[m.ID]
+    }
+    if pending && applied[schemaObjectsMigration] {
+        if err := db.clearSchemaObjects(true); err != nil {
+            return fmt.Errorf("dropping views and triggers: %w", err)
+        }
+    }
+
+    for _, m := range all {
+        if applied[m.ID] {
+            continue
+        }
+        if err := db.applyMigration(m); err != nil {
+            return fmt.Errorf("migration %s: %w", m.ID, err)
+        }
+        // миграция могла изменить любые строки
+        db.clearEntityCache()
+    }
+    if err := db.syncSchemaObjects(); err != nil {
+        return fmt.Errorf("views and triggers: %w", err)
+    }
+    return nil
+}
+
+// applyMigration выполняет одну миграцию и записывает ее в таблицу migrations
+func (db *Database) applyMigration(m Migration) error {
+    insert, err := db.lookupQuery("migrations.insert")
+    if err != nil {
+        return err
+    }
+
+    finished, err := db.beginOperation()
+    if err != nil {
+        return err
+    }
+    defer finished()
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    // PRAGMA foreign_keys нельзя переключить внутри транзакции, поэтому до BEGIN
+    rebuild := m.Rebuild != nil && db.driver.dialect.Name() == "sqlite"
+    if rebuild {
+        if err := db.execOn(ctx, conn, "rebuild.foreign_keys_off"); err != nil {
+            return err
+        }
+        // без таймаута: после истекшего ctx соединение вернулось бы в пул с выключенными внешними ключами
+        defer db.execOn(context.Background(), conn, "rebuild.foreign_keys_on")
+    }
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := db.runMigration(ctx, tx, m, rebuild); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(db.driver.dialect.Rebind(insert), m.ID, m.Kind); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// runMigration выполняет изменения миграции в транзакции tx, не отмечая ее примененной.
+// rebuild - пересобирать таблицу m.Rebuild; внешние ключи соединения уже должны быть выключены
+func (db *Database) runMigration(ctx context.Context, tx *sql.Tx, m Migration, rebuild bool) error {
+    if m.Done != nil {
+        done, err := m.Done(tx)
+        if err != nil || done {
+            return err
+        }
+    }
+    switch {
+    case rebuild:
+        return db.rebuildTable(ctx, tx, *m.Rebuild)
+    case m.Apply != nil:
+        return m.Apply(tx)
+    }
+    return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "bytes"
+    "os"
+    "testing"
+)
+
+// TestStoreMockUpToDate проверяет, что store_mock.go перегенерирован после изменения Store
+func TestStoreMockUpToDate(t *testing.T) {
+    source, err := os.ReadFile("store.go")
+    if err != nil {
+        t.Fatal(err)
+    }
+    mock, err := WriteMock("store.go", source, "Store")
+    if err != nil {
+        t.Fatal(err)
+    }
+    existing, err := os.ReadFile("store_mock.go")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(existing, mock) {
+        t.Error("store_mock.go is out of date with store.go, regenerate it with mockgen -out store_mock.go")
+    }
+    if bytes.Contains(mock, []byte("\n\t")) {
+        t.Error("generated mock is indented with tabs, want four spaces")
+    }
+}
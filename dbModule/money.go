@@ -0,0 +1,145 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Money - денежная сумма: Amount в минимальных единицах валюты (центах, копейках, иенах),
+// Currency - код ISO 4217. Целые минимальные единицы складываются и сравниваются без потерь
+// округления, поэтому цены хранятся так, а не в float64. Суммы в разных валютах не сравниваются
+type Money struct {
+    Amount   int64  `json:"amount"`
+    Currency string `json:"currency"`
+}
+
+// currencyExponents - знаков после запятой в валютах, которые понимает Money; остальные добавляет RegisterCurrency
+var currencyExponents = map[string]int{
+    "AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "BYN": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2, "CZK": 2,
+    "DKK": 2, "EUR": 2, "GBP": 2, "GEL": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0,
+    "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "KZT": 2, "MXN": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PLN": 2,
+    "RSD": 2, "RUB": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "UAH": 2, "USD": 2, "UZS": 2,
+    "VND": 0, "ZAR": 2,
+}
+
+// currenciesMu защищает currencyExponents от RegisterCurrency
+var currenciesMu sync.RWMutex
+
+// RegisterCurrency добавляет валюту code (три заглавные латинские буквы) с exponent знаками после запятой
+// или меняет число знаков известной валюты. Вызывается до начала работы
+func RegisterCurrency(code string, exponent int) error {
+    if !validCurrencyCode(code) {
+        return &ValidationError{Field: "currency", Message: fmt.Sprintf("%q is not a three-letter ISO 4217 code", code)}
+    }
+    if exponent < 0 || exponent > 4 {
+        return &ValidationError{Field: "currency", Message: fmt.Sprintf("%s: exponent %d is not from 0 to 4", code, exponent)}
+    }
+    currenciesMu.Lock()
+    defer currenciesMu.Unlock()
+    currencyExponents[code] = exponent
+    return nil
+}
+
+// CurrencyExponent возвращает число знаков после запятой валюты code; false - валюта неизвестна
+func CurrencyExponent(code string) (int, bool) {
+    currenciesMu.RLock()
+    defer currenciesMu.RUnlock()
+    exponent, ok := currencyExponents[code]
+    return exponent, ok
+}
+
+// validCurrencyCode проверяет, что code записан как код ISO 4217: три заглавные латинские буквы
+func validCurrencyCode(code string) bool {
+    if len(code) != 3 {
+        return false
+    }
+    for _, c := range code {
+        if c < 'A' || c > 'Z' {
+            return false
+        }
+    }
+    return true
+}
+
+// ParseAmount переводит сумму major в основных единицах валюты currency ("12.50", "-3", "1000.5")
+// в Money: "12.50" USD - 1250 центов. Знаков после точки не больше, чем у валюты: сумма не округляется
+func ParseAmount(major, currency string) (Money, error) {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    exponent, ok := CurrencyExponent(currency)
+    if !ok {
+        return Money{}, &ValidationError{Field: "currency", Message: fmt.Sprintf("%q is unknown", currency)}
+    }
+    invalid := &ValidationError{Field: "amount", Message: fmt.Sprintf("%q is not an amount in %s", major, currency)}
+
+    text, negative := strings.TrimSpace(major), false
+    if rest, ok := strings.CutPrefix(text, "-"); ok {
+        text, negative = rest, true
+    }
+    whole, fraction, hasPoint := strings.Cut(text, ".")
+    if whole == "" || (hasPoint && fraction == "") || len(fraction) > exponent || !isDigits(whole) || !isDigits(fraction) {
+        return Money{}, invalid
+    }
+    amount, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exponent-len(fraction)), 10, 64)
+    if err != nil {
+        return Money{}, invalid
+    }
+    if negative {
+        amount = -amount
+    }
+    return Money{Amount: amount, Currency: currency}, nil
+}
+
+// ParseMoney читает сумму в виде, в котором ее печатает String: "12.50 USD"
+func ParseMoney(text string) (Money, error) {
+    major, currency, ok := strings.Cut(strings.TrimSpace(text), " ")
+    if !ok {
+        return Money{}, &ValidationError{Field: "amount", Message: fmt.Sprintf("%q must be written as AMOUNT CURRENCY, e.g. 12.50 USD", text)}
+    }
+    return ParseAmount(major, currency)
+}
+
+// isDigits проверяет, что text состоит только из десятичных цифр; пустая строка подходит
+func isDigits(text string) bool {
+    for _, c := range text {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// Major возвращает сумму в основных единицах со всеми знаками валюты: 1250 USD - "12.50"
+func (m Money) Major() string {
+    exponent, ok := CurrencyExponent(m.Currency)
+    if !ok || exponent == 0 {
+        return strconv.FormatInt(m.Amount, 10)
+    }
+    sign, amount := "", m.Amount
+    if amount < 0 {
+        // для math.MinInt64 -amount переполняется, но uint64 все равно дает верный модуль
+        sign, amount = "-", -amount
+    }
+    digits := strconv.FormatUint(uint64(amount), 10)
+    if len(digits) <= exponent {
+        digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+    }
+    return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// String печатает сумму как "12.50 USD"; ParseMoney читает ее обратно
+func (m Money) String() string {
+    return m.Major() + " " + m.Currency
+}
+
+// Validate проверяет, что валюта известна, а сумма не отрицательна
+func (m Money) Validate() error {
+    if _, ok := CurrencyExponent(m.Currency); !ok {
+        return &ValidationError{Field: "currency", Message: fmt.Sprintf("%q is unknown", m.Currency)}
+    }
+    if m.Amount < 0 {
+        return &ValidationError{Field: "amount", Message: "must not be negative"}
+    }
+    return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Изменения схемы больших таблиц без долгих блокировок идут в несколько шагов (expand/contract):
+// новая колонка добавляется без перезаписи таблицы (AddColumnWithDefault), код пишет и в старую,
+// и в новую колонку (RegisterDualWrite, SetDualWrite), старые строки заполняются небольшими
+// пачками (BackfillInBatches), затем чтение переходит на новую колонку, двойная запись выключается,
+// а старая колонка удаляется обычной миграцией
+
+// defaultBackfillBatchSize - диапазон ID, который BackfillInBatches обновляет одним запросом
+const defaultBackfillBatchSize = 1000
+
+// dualWriteSetting - префикс настроек в таблице settings, включающих двойную запись
+const dualWriteSetting = "dual_write:"
+
+// NewColumn описывает колонку, которую добавляет AddColumnWithDefault
+type NewColumn struct {
+    // Table - имя таблицы без префикса таблиц (см. Config.TablePrefix)
+    Table string
+    Name  string
+    // Type - тип колонки в СУБД базы, например VARCHAR(32) или INTEGER
+    Type string
+    // Default - значение по умолчанию в виде литерала SQL, например 0 или 'pending'. Выражения вроде
+    // CURRENT_TIMESTAMP PostgreSQL и MySQL вычисляют для каждой строки, перезаписывая таблицу
+    Default string
+    NotNull bool
+}
+
+// addColumnSQL возвращает ALTER TABLE, который добавляет колонку, не переписывая строки таблицы:
+// SQLite, PostgreSQL 11+ и Oracle 11g+ хранят постоянное значение по умолчанию в каталоге,
+// MySQL 8 с ALGORITHM=INSTANT отказывается от изменения вместо копирования таблицы
+func (db *Database) addColumnSQL(column NewColumn) string {
+    table := db.tableIdentifier(db.table(column.Table))
+    name := db.tableIdentifier(column.Name)
+    notNull := ""
+    if column.NotNull {
+        notNull = " NOT NULL"
+    }
+    switch db.driver.dialect.Name() {
+    case "mysql":
+        return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s%s DEFAULT %s, ALGORITHM=INSTANT", table, name, column.Type, notNull, column.Default)
+    case "mssql":
+        // WITH VALUES заполняет значением по умолчанию и существующие строки колонки, допускающей NULL
+        constraint := quoteIdentifier("df_" + db.table(column.Table) + "_" + column.Name)
+        return fmt.Sprintf("ALTER TABLE %s ADD %s %s%s CONSTRAINT %s DEFAULT %s WITH VALUES", table, name, column.Type, notNull, constraint, column.Default)
+    case "oracle":
+        return fmt.Sprintf("ALTER TABLE %s ADD (%s %s DEFAULT %s%s)", table, name, column.Type, column.Default, notNull)
+    }
+    return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s%s DEFAULT %s", table, name, column.Type, notNull, column.Default)
+}
+
+// AddColumnWithDefault добавляет в таблицу колонку со значением по умолчанию, которое сразу видно
+// во всех строках, без перезаписи таблицы (см. addColumnSQL). Если колонка уже есть, ничего не делает,
+// поэтому шаг можно повторять, в том числе из миграции
+func (db *Database) AddColumnWithDefault(column NewColumn) error {
+    if column.Table == "" || column.Name == "" || column.Type == "" || column.Default == "" {
+        return db.opError("add column", "schema", column.Table, fmt.Errorf("%w: table, column name, type and default are required", ErrValidation))
+    }
+    table, err := db.WithPrimary().describeTable(db.table(column.Table), TableDescription{})
+    if err != nil {
+        return db.opError("add column", "schema", column.Table, err)
+    }
+    if len(table.Columns) == 0 {
+        return db.opError("add column", "schema", column.Table, ErrNotFound)
+    }
+    for _, existing := range table.Columns {
+        if strings.EqualFold(existing.Name, column.Name) {
+            return nil
+        }
+    }
+    _, err = db.execText("online_schema.add_column", db.addColumnSQL(column))
+    return db.opError("add column", "schema", column.Table+"."+column.Name, err)
+}
+
+// Backfill описывает заполнение строк таблицы пачками (см. BackfillInBatches)
+type Backfill struct {
+    // Table - имя таблицы без префикса таблиц; ее строки перебираются по числовой колонке id
+    Table string
+    // Query - имя запроса из реестра, который обновляет строки одной пачки. Два последних параметра
+    // запроса - границы пачки по id: "... WHERE new_col IS NULL AND id > ? AND id <= ?". Запрос должен
+    // пропускать уже заполненные строки, тогда прерванное заполнение можно запустить заново
+    Query string
+    // Args - параметры запроса перед границами пачки
+    Args []interface{}
+    // BatchSize - диапазон id одной пачки; 0 - defaultBackfillBatchSize
+    BatchSize int
+    // Pause - пауза между пачками, чтобы заполнение не отнимало у приложения запись и репликацию
+    Pause time.Duration
+    // After - id, после которого начинать, например последний из Progress прерванного заполнения
+    After int64
+    // Progress, если задан, вызывается после каждой пачки с числом обновленных строк и ее верхней границей
+    Progress func(updated, lastID int64)
+}
+
+// BackfillInBatches выполняет запрос backfill.Query по диапазонам id таблицы, каждый - отдельным
+// коротким запросом вне транзакции: блокировки держатся на строках одной пачки, а не на всей таблице.
+// Перебираются id до наибольшего на момент запуска; строки, вставленные позже, заполняет значение
+// по умолчанию или двойная запись. Возвращает число обновленных строк
+func (db *Database) BackfillInBatches(backfill Backfill) (int64, error) {
+    if backfill.BatchSize <= 0 {
+        backfill.BatchSize = defaultBackfillBatchSize
+    }
+    query, err := db.lookupQuery(backfill.Query)
+    if err != nil {
+        return 0, db.opError("backfill", backfill.Table, backfill.Query, err)
+    }
+    first, last, err := db.WithPrimary().idRange(backfill.Table)
+    if err != nil {
+        return 0, db.opError("backfill", backfill.Table, backfill.Query, err)
+    }
+    if !first.Valid {
+        return 0, nil
+    }
+
+    var updated int64
+    after := max(backfill.After, first.Int64-1)
+    for after < last.Int64 {
+        upTo := min(after+int64(backfill.BatchSize), last.Int64)
+        result, err := db.execText(backfill.Query, query, append(append([]interface{}{}, backfill.Args...), after, upTo)...)
+        if err != nil {
+            return updated, db.opError("backfill", backfill.Table, fmt.Sprintf("%s after id %d", backfill.Query, after), err)
+        }
+        count, err := result.RowsAffected()
+        if err != nil {
+            return updated, db.opError("backfill", backfill.Table, backfill.Query, err)
+        }
+        updated += count
+        after = upTo
+        if backfill.Progress != nil {
+            backfill.Progress(count, upTo)
+        }
+        if backfill.Pause > 0 && after < last.Int64 {
+            time.Sleep(backfill.Pause)
+        }
+    }
+    db.clearEntityCache()
+    return updated, nil
+}
+
+// idRange возвращает наименьший и наибольший id таблицы; оба не Valid, если она пуста
+func (db *Database) idRange(table string) (first, last sql.NullInt64, err error) {
+    rows, err := db.queryText("online_schema.id_range", fmt.Sprintf("SELECT MIN(id), MAX(id) FROM %s", db.tableIdentifier(db.table(table))))
+    if err != nil {
+        return first, last, err
+    }
+    defer rows.Close()
+    if rows.Next() {
+        if err := rows.Scan(&first, &last); err != nil {
+            return first, last, err
+        }
+    }
+    return first, last, rows.Err()
+}
+
+// dualWrites - зарегистрированные двойные записи
+var dualWrites = map[string]bool{}
+
+// RegisterDualWrite регистрирует двойную запись name: обработчики h записей сущности entity
+// (см. RegisterHooks) выполняются, только пока она включена (см. SetDualWrite), например дописывают
+// значение в новую колонку, пока старая еще основная. Регистрировать нужно до начала работы с базой
+func RegisterDualWrite[T any](name, entity string, h EntityHooks[T]) {
+    if dualWrites[name] {
+        panic(fmt.Sprintf("dual write %s is already registered", name))
+    }
+    dualWrites[name] = true
+
+    enabled := func(tx *Database) (bool, error) { return tx.DualWriteEnabled(name) }
+    wrapped := EntityHooks[T]{}
+    if h.BeforeInsert != nil {
+        wrapped.BeforeInsert = func(tx *Database, record *T) error {
+            if on, err := enabled(tx); !on || err != nil {
+                return err
+            }
+            return h.BeforeInsert(tx, record)
+        }
+    }
+    if h.AfterInsert != nil {
+        wrapped.AfterInsert = func(tx *Database, record T) error {
+            if on, err := enabled(tx); !on || err != nil {
+                return err
+            }
+            return h.AfterInsert(tx, record)
+        }
+    }
+    if h.AfterUpdate != nil {
+        wrapped.AfterUpdate = func(tx *Database, old, current T) error {
+            if on, err := enabled(tx); !on || err != nil {
+                return err
+            }
+            return h.AfterUpdate(tx, old, current)
+        }
+    }
+    if h.AfterDelete != nil {
+        wrapped.AfterDelete = func(tx *Database, old T) error {
+            if on, err := enabled(tx); !on || err != nil {
+                return err
+            }
+            return h.AfterDelete(tx, old)
+        }
+    }
+    RegisterHooks(entity, wrapped)
+}
+
+// DualWriteNames возвращает отсортированные имена зарегистрированных двойных записей
+func DualWriteNames() []string {
+    names := make([]string, 0, len(dualWrites))
+    for name := range dualWrites {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// dualWriteState - закешированные переключатели двойной записи, как флаг режима обслуживания
+type dualWriteState struct {
+    mu      sync.Mutex
+    enabled map[string]bool
+    checked map[string]time.Time
+}
+
+// SetDualWrite включает или выключает двойную запись name во всех процессах с этой базой; другие
+// процессы замечают переключение в течение maintenanceRefresh. Поэтому после включения заполнять
+// старые строки (BackfillInBatches) стоит не раньше, чем через этот срок
+func (db *Database) SetDualWrite(name string, enabled bool) error {
+    if !dualWrites[name] {
+        registered := strings.Join(DualWriteNames(), ", ")
+        if registered == "" {
+            registered = "none"
+        }
+        return db.opError("set", "dual write", name, fmt.Errorf("%w: unknown dual write %q (registered: %s)", ErrValidation, name, registered))
+    }
+    value := "off"
+    if enabled {
+        value = "on"
+    }
+    if err := db.SetSetting(dualWriteSetting+name, value); err != nil {
+        return db.opError("set", "dual write", name, err)
+    }
+
+    state := db.dualWrites
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    if state.enabled == nil {
+        state.enabled, state.checked = make(map[string]bool), make(map[string]time.Time)
+    }
+    state.enabled[name], state.checked[name] = enabled, db.now()
+    return nil
+}
+
+// DualWriteEnabled сообщает, включена ли двойная запись name; не включенная ни разу выключена
+func (db *Database) DualWriteEnabled(name string) (bool, error) {
+    state := db.dualWrites
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    if checked, ok := state.checked[name]; ok && db.now().Sub(checked) < maintenanceRefresh {
+        return state.enabled[name], nil
+    }
+
+    value, _, err := db.GetSetting(dualWriteSetting + name)
+    if err != nil {
+        return false, err
+    }
+    if state.enabled == nil {
+        state.enabled, state.checked = make(map[string]bool), make(map[string]time.Time)
+    }
+    state.enabled[name], state.checked[name] = value == "on", db.now()
+    return value == "on", nil
+}
+
+// runSchemaChange выполняет шаги изменения схемы без долгих блокировок:
+// schema-change add-column -table T -column C -type TYPE -default VALUE [-not-null],
+// schema-change backfill -table T -query ns.name [-batch N] [-pause D] [-after ID],
+// schema-change dual-write list | on -name N | off -name N
+func runSchemaChange(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: schema-change add-column|backfill|dual-write [flags]")
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("schema-change "+args[0], flag.ContinueOnError)
+    switch args[0] {
+    case "add-column":
+        column := NewColumn{}
+        flags.StringVar(&column.Table, "table", "", "table to add the column to, without the table prefix")
+        flags.StringVar(&column.Name, "column", "", "name of the new column")
+        flags.StringVar(&column.Type, "type", "", "column type in the database dialect, e.g. VARCHAR(32)")
+        flags.StringVar(&column.Default, "default", "", "default value as an SQL literal, e.g. 0 or 'pending'")
+        flags.BoolVar(&column.NotNull, "not-null", false, "declare the column NOT NULL")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        if err := db.AddColumnWithDefault(column); err != nil {
+            return err
+        }
+        fmt.Printf("Column %s.%s is in place\n", column.Table, column.Name)
+    case "backfill":
+        backfill := Backfill{}
+        flags.StringVar(&backfill.Table, "table", "", "table whose rows are walked by id, without the table prefix")
+        flags.StringVar(&backfill.Query, "query", "", "named query updating one batch; its last two parameters are the id range (after, up to]")
+        flags.IntVar(&backfill.BatchSize, "batch", defaultBackfillBatchSize, "range of ids updated by one statement")
+        flags.DurationVar(&backfill.Pause, "pause", 0, "pause between batches")
+        flags.Int64Var(&backfill.After, "after", 0, "resume after this id")
+        if err := flags.Parse(args[1:]); err != nil {
+            return err
+        }
+        if backfill.Table == "" || backfill.Query == "" {
+            return fmt.Errorf("schema-change backfill needs -table and -query")
+        }
+        backfill.Progress = func(updated, lastID int64) {
+            fmt.Printf("up to id %d: %d rows updated\n", lastID, updated)
+        }
+        updated, err := db.BackfillInBatches(backfill)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Backfilled %d rows of %s\n", updated, backfill.Table)
+    case "dual-write":
+        if len(args) < 2 {
+            return fmt.Errorf("usage: schema-change dual-write list | on -name N | off -name N")
+        }
+        name := flags.String("name", "", "dual write to switch: "+strings.Join(DualWriteNames(), ", "))
+        if err := flags.Parse(args[2:]); err != nil {
+            return err
+        }
+        switch args[1] {
+        case "on", "off":
+            if err := db.SetDualWrite(*name, args[1] == "on"); err != nil {
+                return err
+            }
+            fmt.Printf("%s: %s\n", *name, args[1])
+        case "list":
+            for _, name := range DualWriteNames() {
+                enabled, err := db.DualWriteEnabled(name)
+                if err != nil {
+                    return err
+                }
+                state := "off"
+                if enabled {
+                    state = "on"
+                }
+                fmt.Printf("%s | %s\n", name, state)
+            }
+        default:
+            return fmt.Errorf("usage: schema-change dual-write list | on -name N | off -name N")
+        }
+    default:
+        return usage
+    }
+    return nil
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "dbModule/graphql"
+)
+
+// apiRoute - маршрут HTTP API вместе с описанием для OpenAPI. Handler и OpenAPI строятся
+// по одному списку apiRoutes, поэтому документ не расходится с реальными маршрутами
+type apiRoute struct {
+    method      string
+    pattern     string
+    summary     string
+    params      []apiParam
+    // request - значение типа тела запроса в JSON; nil - запрос без тела
+    request     interface{}
+    // status - код успешного ответа; 0 - 200
+    status      int
+    // response - значение типа тела успешного ответа в JSON; nil - ответ не JSON (см. contentType)
+    response    interface{}
+    contentType string
+    // tx - serve выполняется в одной транзакции на запрос и откатывается при ответе с ошибкой (см. Transactional)
+    tx          bool
+    serve       func(db *Database, w http.ResponseWriter, r *http.Request)
+}
+
+// apiParam - параметр пути или строки запроса
+type apiParam struct {
+    name        string
+    in          string
+    schema      string
+    description string
+    enum        []string
+}
+
+// pageParams - параметры постраничных списков (см. parsePageRequest)
+var pageParams = []apiParam{
+    {name: "cursor", in: "query", schema: "string", description: "cursor from next or prev link of the previous page"},
+    {name: "size", in: "query", schema: "integer", description: "page size"},
+    {name: "total", in: "query", schema: "string", description: "count rows matching the filter", enum: []string{"exact", "estimate"}},
+}
+
+// idempotencyKeyParam - заголовок ключа идемпотентности запросов на создание (см. WithIdempotencyKey)
+var idempotencyKeyParam = apiParam{name: "Idempotency-Key", in: "header", schema: "string", description: "unique key of the request, e.g. a UUID; retries with the same key and body do not create duplicates"}
+
+// apiRoutes возвращает маршруты HTTP API
+func (db *Database) apiRoutes() []apiRoute {
+    return []apiRoute{
+        {
+            method:  "GET",
+            pattern: "/export/{name}",
+            summary: "Export a table of the tenant as JSON Lines, gzip-compressed if the client accepts it",
+            params: []apiParam{
+                {name: "name", in: "path", schema: "string", description: "export name", enum: db.exportNames()},
+            },
+            contentType: "application/x-ndjson",
+            serve: func(db *Database, w http.ResponseWriter, r *http.Request) {
+                db.ExportHandler(r.PathValue("name")).ServeHTTP(w, r)
+            },
+        },
+        {
+            method:  "GET",
+            pattern: "/restaurants",
+            summary: "Page of restaurants",
+            params: append([]apiParam{
+                {name: "type", in: "query", schema: "string", description: "restaurant type"},
+                {name: "name_prefix", in: "query", schema: "string", description: "name starts with"},
+                {name: "min_price", in: "query", schema: "string", description: "minimum price tier: 1 to 5 or budget, moderate, upscale, premium, luxury"},
+                {name: "max_price", in: "query", schema: "string", description: "maximum price tier: 1 to 5 or budget, moderate, upscale, premium, luxury"},
+                {name: "tier", in: "query", schema: "string", description: "comma-separated price tiers, e.g. moderate,upscale or 2,3"},
+                {name: "price_from", in: "query", schema: "string", description: "minimum average bill with its currency, e.g. 10.00 EUR"},
+                {name: "price_to", in: "query", schema: "string", description: "maximum average bill in the same currency, e.g. 25.50 EUR"},
+                {name: "user_id", in: "query", schema: "integer", description: "owner ID"},
+                {name: "category_id", in: "query", schema: "integer", description: "category ID"},
+                {name: "open_at", in: "query", schema: "string", description: "open at this moment by opening hours: now or a time like 2026-01-02T19:30:00+03:00 in the restaurants' time zone"},
+                {name: "sort", in: "query", schema: "string", description: "comma-separated sort fields name and price, - for descending, e.g. name,-price"},
+            }, pageParams...),
+            response: pageResponse[Restaurant]{},
+            serve:    (*Database).serveRestaurants,
+        },
+        {
+            method:  "GET",
+            pattern: "/restaurants/{id}/reviews",
+            summary: "Page of reviews of a restaurant",
+            params: append([]apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+            }, pageParams...),
+            response: pageResponse[Review]{},
+            serve:    (*Database).serveReviews,
+        },
+        {
+            method:   "GET",
+            pattern:  "/owners",
+            summary:  "Page of users with all of their restaurants; pages count users, so one user's restaurants are never split across pages",
+            params:   pageParams,
+            response: pageResponse[ownerListing]{},
+            serve:    (*Database).serveOwners,
+        },
+        {
+            method:   "POST",
+            pattern:  "/restaurants",
+            summary:  "Create a restaurant; a retry with the same Idempotency-Key returns the restaurant created by the first request",
+            params:   []apiParam{idempotencyKeyParam},
+            request:  Restaurant{},
+            status:   http.StatusCreated,
+            response: Restaurant{},
+            serve:    (*Database).serveCreateRestaurant,
+        },
+        {
+            method:   "POST",
+            pattern:  "/users",
+            summary:  "Create a user; a retry with the same Idempotency-Key returns the user created by the first request",
+            params:   []apiParam{idempotencyKeyParam},
+            request:  userCreateBody{},
+            status:   http.StatusCreated,
+            response: AdminUser{},
+            serve:    (*Database).serveCreateUser,
+        },
+        {
+            method:   "POST",
+            pattern:  "/owners",
+            summary:  "Create a restaurant owner together with their first restaurant; if either is invalid, neither is created",
+            request:  ownerCreateBody{},
+            status:   http.StatusCreated,
+            response: ownerResponse{},
+            tx:       true,
+            serve:    (*Database).serveCreateOwner,
+        },
+        {
+            method:   "PATCH",
+            pattern:  "/restaurants/{id}",
+            summary:  "Change only the restaurant fields present in the body; null clears keys",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  restaurantPatchBody{},
+            response: Restaurant{},
+            serve:    (*Database).servePatchRestaurant,
+        },
+        {
+            method:   "POST",
+            pattern:  "/restaurants/{id}/transfer",
+            summary:  "Transfer a restaurant to a new owner with its menu, hours, slots, bookings and reviews; 409 if from_user_id no longer owns it",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  restaurantTransferBody{},
+            response: Restaurant{},
+            serve:    (*Database).serveTransferRestaurant,
+        },
+        {
+            method:   "PATCH",
+            pattern:  "/users/{id}",
+            summary:  "Change only the user fields present in the body; null clears phone and profile fields",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "user ID"}},
+            request:  userPatchBody{},
+            response: AdminUser{},
+            serve:    (*Database).servePatchUser,
+        },
+        {
+            method:   "GET",
+            pattern:  "/categories",
+            summary:  "Restaurant categories of the tenant by name",
+            response: []Category{},
+            serve:    (*Database).serveCategories,
+        },
+        {
+            method:   "POST",
+            pattern:  "/categories",
+            summary:  "Create a category; the name is unique within the tenant",
+            request:  categoryBody{},
+            status:   http.StatusCreated,
+            response: Category{},
+            serve:    (*Database).serveCreateCategory,
+        },
+        {
+            method:   "PATCH",
+            pattern:  "/categories/{id}",
+            summary:  "Rename a category",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "category ID"}},
+            request:  categoryBody{},
+            response: Category{},
+            serve:    (*Database).serveCategory,
+        },
+        {
+            method:   "DELETE",
+            pattern:  "/categories/{id}",
+            summary:  "Delete a category; its restaurants are kept",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "category ID"}},
+            response: Category{},
+            serve:    (*Database).serveCategory,
+        },
+        {
+            method:   "GET",
+            pattern:  "/restaurants/{id}/categories",
+            summary:  "Categories of a restaurant",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []Category{},
+            serve:    (*Database).serveRestaurantCategories,
+        },
+        {
+            method:   "PUT",
+            pattern:  "/restaurants/{id}/categories",
+            summary:  "Replace the categories of a restaurant",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  restaurantCategoriesBody{},
+            response: []Category{},
+            serve:    (*Database).serveRestaurantCategories,
+        },
+        {
+            method:   "GET",
+            pattern:  "/restaurants/{id}/hours",
+            summary:  "Opening hours of a restaurant by weekday",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []ListingHours{},
+            serve:    (*Database).serveRestaurantHours,
+        },
+        {
+            method:   "PUT",
+            pattern:  "/restaurants/{id}/hours",
+            summary:  "Replace the opening hours of a restaurant; closes not after opens means open past midnight",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  restaurantHoursBody{},
+            response: []ListingHours{},
+            serve:    (*Database).serveRestaurantHours,
+        },
+        {
+            method:   "DELETE",
+            pattern:  "/restaurants/{id}/hours",
+            summary:  "Remove the opening hours of a restaurant",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []ListingHours{},
+            serve:    (*Database).serveRestaurantHours,
+        },
+        {
+            method:  "GET",
+            pattern: "/restaurants/{id}/localized",
+            summary: "Restaurant with its name and description in the requested language, falling back to the language without region and the default language",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+                {name: "locale", in: "query", schema: "string", description: "language like en or en-US; default: the first language of Accept-Language"},
+            },
+            response: LocalizedRestaurant{},
+            serve:    (*Database).serveLocalizedRestaurant,
+        },
+        {
+            method:   "GET",
+            pattern:  "/restaurants/{id}/translations",
+            summary:  "Translations of the name and description of a restaurant",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []Translation{},
+            serve:    (*Database).serveRestaurantTranslations,
+        },
+        {
+            method:  "PUT",
+            pattern: "/restaurants/{id}/translations/{locale}",
+            summary: "Set the name and description of a restaurant in a language; an empty string removes the translation of a field",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+                {name: "locale", in: "path", schema: "string", description: "language like en or en-US"},
+            },
+            request:  RestaurantTranslation{},
+            response: []Translation{},
+            serve:    (*Database).serveRestaurantTranslations,
+        },
+        {
+            method:  "DELETE",
+            pattern: "/restaurants/{id}/translations/{locale}",
+            summary: "Remove the translations of a restaurant into a language",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+                {name: "locale", in: "path", schema: "string", description: "language like en or en-US"},
+            },
+            response: []Translation{},
+            serve:    (*Database).serveRestaurantTranslations,
+        },
+        {
+            method:   "GET",
+            pattern:  "/users/{id}/favorites",
+            summary:  "Favorite restaurants of a user, recently added first",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "user ID"}},
+            response: []Restaurant{},
+            serve:    (*Database).serveFavorites,
+        },
+        {
+            method:  "PUT",
+            pattern: "/users/{id}/favorites/{restaurant_id}",
+            summary: "Add a restaurant to the favorites of a user; adding it again changes nothing",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "user ID"},
+                {name: "restaurant_id", in: "path", schema: "integer", description: "restaurant ID"},
+            },
+            response: Favorite{},
+            serve:    (*Database).serveFavorite,
+        },
+        {
+            method:  "DELETE",
+            pattern: "/users/{id}/favorites/{restaurant_id}",
+            summary: "Remove a restaurant from the favorites of a user",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "user ID"},
+                {name: "restaurant_id", in: "path", schema: "integer", description: "restaurant ID"},
+            },
+            response: Favorite{},
+            serve:    (*Database).serveFavorite,
+        },
+        {
+            method:  "GET",
+            pattern: "/restaurants/{id}/slots",
+            summary: "Booking slots of a restaurant overlapping a period, by start time",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+                {name: "from", in: "query", schema: "string", description: "period start like 2006-01-02T15:04:05+03:00; default: now"},
+                {name: "to", in: "query", schema: "string", description: "period end; default: a week after from"},
+            },
+            response: []BookingSlot{},
+            serve:    (*Database).serveBookingSlots,
+        },
+        {
+            method:   "POST",
+            pattern:  "/restaurants/{id}/slots",
+            summary:  "Add a booking slot to a restaurant; slots of a restaurant must not overlap",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  bookingSlotBody{},
+            response: BookingSlot{},
+            serve:    (*Database).serveBookingSlots,
+        },
+        {
+            method:  "DELETE",
+            pattern: "/restaurants/{id}/slots/{slot_id}",
+            summary: "Remove a booking slot of a restaurant together with its bookings",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "restaurant ID"},
+                {name: "slot_id", in: "path", schema: "integer", description: "booking slot ID"},
+            },
+            response: BookingSlot{},
+            serve:    (*Database).serveDeleteBookingSlot,
+        },
+        {
+            method:   "GET",
+            pattern:  "/restaurants/{id}/bookings",
+            summary:  "Bookings in all slots of a restaurant, by start time",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []Booking{},
+            serve:    (*Database).serveRestaurantBookings,
+        },
+        {
+            method:   "POST",
+            pattern:  "/restaurants/{id}/bookings",
+            summary:  "Book seats in a slot of a restaurant; 409 if the slot is full or the user has a booking at that time",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            request:  bookingBody{},
+            response: Booking{},
+            serve:    (*Database).serveRestaurantBookings,
+        },
+        {
+            method:   "GET",
+            pattern:  "/users/{id}/bookings",
+            summary:  "Bookings of a user, by start time",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "user ID"}},
+            response: []Booking{},
+            serve:    (*Database).serveUserBookings,
+        },
+        {
+            method:   "DELETE",
+            pattern:  "/bookings/{id}",
+            summary:  "Cancel a booking, freeing its seats",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "booking ID"}},
+            response: Booking{},
+            serve:    (*Database).serveCancelBooking,
+        },
+        {
+            method:   "GET",
+            pattern:  "/users/{id}/preferences",
+            summary:  "Preferences of a user by name",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "user ID"}},
+            response: Preferences{},
+            serve:    (*Database).servePreferences,
+        },
+        {
+            method:  "PUT",
+            pattern: "/users/{id}/preferences/{name}",
+            summary: "Set a preference of a user; default_city, price_tier and saved_searches are checked",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "user ID"},
+                {name: "name", in: "path", schema: "string", description: "preference name like default_city"},
+            },
+            request:  preferenceBody{},
+            response: Preferences{},
+            serve:    (*Database).servePreferences,
+        },
+        {
+            method:  "DELETE",
+            pattern: "/users/{id}/preferences/{name}",
+            summary: "Remove a preference of a user",
+            params: []apiParam{
+                {name: "id", in: "path", schema: "integer", description: "user ID"},
+                {name: "name", in: "path", schema: "string", description: "preference name like default_city"},
+            },
+            response: Preferences{},
+            serve:    (*Database).servePreferences,
+        },
+        {
+            method:   "GET",
+            pattern:  "/restaurants/{id}/fans",
+            summary:  "Users who added a restaurant to their favorites, recent first",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "restaurant ID"}},
+            response: []PublicUser{},
+            serve:    (*Database).serveFans,
+        },
+        {
+            method:   "POST",
+            pattern:  "/listings",
+            summary:  "Import restaurants with menus, hours and tags in the interchange format (see GET /listings/schema); all or nothing",
+            request:  ListingDocument{},
+            status:   http.StatusCreated,
+            response: listingImportResponse{},
+            serve:    (*Database).serveListingImport,
+        },
+        {
+            method:   "GET",
+            pattern:  "/listings",
+            summary:  "Export restaurants of the tenant in the interchange format",
+            response: ListingDocument{},
+            serve:    (*Database).serveListingExport,
+        },
+        {
+            method:   "POST",
+            pattern:  "/graphql",
+            summary:  "Run a GraphQL query or mutation over users and restaurants, e.g. {\"query\": \"{ user(id: 1) { name restaurants { name } } }\"}",
+            request:  graphql.Request{},
+            response: graphql.Response{},
+            serve:    (*Database).serveGraphQL,
+        },
+        {
+            method:  "GET",
+            pattern: "/graphql",
+            summary: "Run a GraphQL query passed in the query string; mutations need POST",
+            params: []apiParam{
+                {name: "query", in: "query", schema: "string", description: "GraphQL document"},
+                {name: "operationName", in: "query", schema: "string", description: "operation to run if the document has several"},
+                {name: "variables", in: "query", schema: "string", description: "variables as a JSON object"},
+            },
+            response: graphql.Response{},
+            serve:    (*Database).serveGraphQL,
+        },
+        {
+            method:  "POST",
+            pattern: "/jobs/import-restaurants",
+            summary: "Start a background import of restaurants from the body, CSV with the header name,type,keys,average_price,user_id or JSON Lines; poll GET /jobs/{id} for progress",
+            params: []apiParam{
+                {name: "format", in: "query", schema: "string", description: "body format", enum: []string{"csv", "jsonl"}},
+            },
+            status:   http.StatusAccepted,
+            response: Job{},
+            serve:    (*Database).serveImportJob,
+        },
+        {
+            method:   "POST",
+            pattern:  "/jobs/analytics-export",
+            summary:  "Start a background export of tables as gzipped CSV with a schema manifest into the -analytics-dir of the tenant; since=last exports only rows changed after the previous run",
+            request:  analyticsExportRequest{},
+            status:   http.StatusAccepted,
+            response: Job{},
+            serve:    (*Database).serveAnalyticsExportJob,
+        },
+        {
+            method:   "GET",
+            pattern:  "/jobs",
+            summary:  "Jobs of the tenant, newest first",
+            response: []Job{},
+            serve:    (*Database).serveJobs,
+        },
+        {
+            method:   "GET",
+            pattern:  "/jobs/{id}",
+            summary:  "State and progress of a job: rows processed, failed rows and their errors",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "job ID"}},
+            response: Job{},
+            serve:    (*Database).serveJob,
+        },
+        {
+            method:   "DELETE",
+            pattern:  "/jobs/{id}",
+            summary:  "Request cancellation of a job; rows imported before it stops are kept",
+            params:   []apiParam{{name: "id", in: "path", schema: "integer", description: "job ID"}},
+            response: Job{},
+            serve:    (*Database).serveJob,
+        },
+        {
+            method:      "GET",
+            pattern:     "/listings/schema",
+            summary:     "JSON Schema of the interchange format",
+            contentType: "application/schema+json",
+            serve: func(db *Database, w http.ResponseWriter, r *http.Request) {
+                serveListingSchema(w, r)
+            },
+        },
+        {
+            method:   "GET",
+            pattern:  "/reports",
+            summary:  "Reports defined in reports.yaml with their parameters and columns",
+            response: []Report{},
+            serve:    (*Database).serveReports,
+        },
+        {
+            method:  "GET",
+            pattern: "/reports/{name}",
+            summary: "Run a report; its parameters are passed in the query string, e.g. ?from=2024-01-01; invalid ones are all listed in violations",
+            params: []apiParam{
+                {name: "name", in: "path", schema: "string", description: "report name", enum: db.reportNames()},
+            },
+            response: reportResult{},
+            serve:    (*Database).serveReport,
+        },
+        {
+            method:   "GET",
+            pattern:  "/health",
+            summary:  "State of the database, its circuit breaker and read replicas; 503 while the database is unavailable",
+            response: Health{},
+            serve:    (*Database).serveHealth,
+        },
+    }
+}
+
+// OpenAPI возвращает описание HTTP API в формате OpenAPI 3, готовое для сериализации в JSON
+func (db *Database) OpenAPI() map[string]interface{} {
+    schemas := make(map[string]interface{})
+    paths := make(map[string]interface{})
+    errorResponse := map[string]interface{}{
+        "description": "error",
+        "content": map[string]interface{}{
+            "application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(apiError{}), schemas)},
+        },
+    }
+
+    for _, route := range db.apiRoutes() {
+        var parameters []interface{}
+        for _, param := range route.params {
+            schema := map[string]interface{}{"type": param.schema}
+            if len(param.enum) > 0 {
+                schema["enum"] = param.enum
+            }
+            parameters = append(parameters, map[string]interface{}{
+                "name":        param.name,
+                "in":          param.in,
+                "required":    param.in == "path",
+                "description": param.description,
+                "schema":      schema,
+            })
+        }
+
+        content := map[string]interface{}{}
+        if route.response != nil {
+            content["application/json"] = map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(route.response), schemas)}
+        } else {
+            content[route.contentType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+        }
+
+        operations, _ := paths[route.pattern].(map[string]interface{})
+        if operations == nil {
+            operations = make(map[string]interface{})
+            paths[route.pattern] = operations
+        }
+        status := route.status
+        if status == 0 {
+            status = http.StatusOK
+        }
+        operation := map[string]interface{}{
+            "summary": route.summary,
+            "responses": map[string]interface{}{
+                strconv.Itoa(status): map[string]interface{}{"description": http.StatusText(status), "content": content},
+                "400":                errorResponse,
+                "404":                errorResponse,
+                "default":            errorResponse,
+            },
+        }
+        if len(parameters) > 0 {
+            operation["parameters"] = parameters
+        }
+        if route.request != nil {
+            operation["requestBody"] = map[string]interface{}{
+                "required": true,
+                "content": map[string]interface{}{
+                    "application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(route.request), schemas)},
+                },
+            }
+        }
+        operations[strings.ToLower(route.method)] = operation
+    }
+
+    return map[string]interface{}{
+        "openapi":    "3.0.3",
+        "info":       map[string]interface{}{"title": "dbModule API", "version": "1.0"},
+        "paths":      paths,
+        "components": map[string]interface{}{"schemas": schemas},
+    }
+}
+
+// timeType - time.Time сериализуется строкой RFC 3339
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPISchema описывает тип t так, как его сериализует encoding/json. Именованные структуры
+// попадают в schemas и подставляются ссылкой; обобщенные (Page[T]) описываются на месте
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+    if t.Kind() == reflect.Pointer {
+        schema := openAPISchema(t.Elem(), schemas)
+        if _, ref := schema["$ref"]; ref {
+            return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+        }
+        schema["nullable"] = true
+        return schema
+    }
+
+    switch t.Kind() {
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Slice, reflect.Array:
+        return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+    case reflect.Struct:
+        if t == timeType {
+            return map[string]interface{}{"type": "string", "format": "date-time"}
+        }
+        if strings.Contains(t.Name(), "[") {
+            return openAPIObject(t, schemas)
+        }
+        if _, ok := schemas[t.Name()]; !ok {
+            // заглушка до описания полей защищает от бесконечной рекурсии на ссылающихся друг на друга типах
+            schemas[t.Name()] = nil
+            schemas[t.Name()] = openAPIObject(t, schemas)
+        }
+        return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+    }
+    return map[string]interface{}{}
+}
+
+// openAPIObject описывает поля структуры по тегам json; поля встроенных структур поднимаются
+// на уровень внешней, как в encoding/json. Обязательны поля без omitempty
+func openAPIObject(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+    properties := make(map[string]interface{})
+    var required []string
+    var collect func(t reflect.Type)
+    collect = func(t reflect.Type) {
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            tag := field.Tag.Get("json")
+            if tag == "-" || !field.IsExported() && !field.Anonymous {
+                continue
+            }
+            name, options, _ := strings.Cut(tag, ",")
+            if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+                collect(field.Type)
+                continue
+            }
+            if name == "" {
+                name = field.Name
+            }
+            properties[name] = openAPISchema(field.Type, schemas)
+            if !strings.Contains(options, "omitempty") {
+                required = append(required, name)
+            }
+        }
+    }
+    collect(t)
+
+    schema := map[string]interface{}{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        schema["required"] = required
+    }
+    return schema
+}
+
+// serveOpenAPI отдает описание API
+func (db *Database) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, db.OpenAPI())
+}
+
+// serveAPIDocs отдает страницу Swagger UI для /openapi.json. Сама страница встроена в бинарник,
+// а скрипты и стили Swagger UI браузер загружает с CDN
+func serveAPIDocs(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write([]byte(swaggerUIPage))
+}
+
+// swaggerUIPage - страница Swagger UI
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>dbModule API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+)
+
+// OutboxBatchSize - сколько событий outbox отправляется одним вызовом Publisher.Publish
+var OutboxBatchSize = 100
+
+// OutboxPollInterval - как часто RelayOutbox проверяет outbox, когда все события отправлены
+var OutboxPollInterval = time.Second
+
+// OutboxRetention - сколько хранятся отправленные события, прежде чем RelayOutbox их удалит
+var OutboxRetention = 7 * 24 * time.Hour
+
+// SetOutbox включает transactional outbox: события изменений (см. ChangeEvent) записываются в таблицу
+// outbox той же транзакцией, что и само изменение, а отправляет их RelayOutbox. Событие не теряется
+// при падении процесса между фиксацией и отправкой, но может быть отправлено повторно (см. ChangeEvent.EventID).
+// Без outbox события отправляются из памяти после фиксации. Вызывается до начала работы
+func (db *Database) SetOutbox(enabled bool) {
+    db.outbox = enabled
+}
+
+// insertOutboxEvent записывает событие в outbox в текущей транзакции
+func (db *Database) insertOutboxEvent(event ChangeEvent) error {
+    _, err := db.execNamed("outbox.insert", event.Tenant, event.Entity, event.Op, event.ID,
+        sql.NullString{String: event.Actor, Valid: event.Actor != ""}, string(event.Payload), event.Time)
+    return err
+}
+
+// RelayOutbox отправляет события outbox в Publisher (см. SetPublisher) по порядку, пока не отменен ctx:
+// пачками по OutboxBatchSize, а когда outbox пуст - раз в interval. Неудачная пачка остается в outbox
+// с увеличенным счетчиком попыток и отправляется снова на следующем шаге. Отправленные события
+// старше OutboxRetention удаляются. Несколько процессов с RelayOutbox отправят события дважды
+func (db *Database) RelayOutbox(ctx context.Context, interval time.Duration) {
+    primary := db.WithPrimary()
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        for ctx.Err() == nil {
+            sent, err := primary.relayOutboxBatch(ctx)
+            if err != nil {
+                log.Printf("outbox: %v", err)
+                break
+            }
+            if sent < OutboxBatchSize {
+                break
+            }
+        }
+        if _, err := primary.execNamed("outbox.delete_sent", primary.now().UTC().Add(-OutboxRetention)); err != nil && !errors.Is(err, ErrClosed) {
+            log.Printf("outbox: removing sent events: %v", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// relayOutboxBatch отправляет самые старые неотправленные события и отмечает их отправленными.
+// Возвращает число отправленных событий
+func (db *Database) relayOutboxBatch(ctx context.Context) (int, error) {
+    if db.publisher == nil {
+        return 0, errors.New("no change publisher is configured (see -cdc)")
+    }
+    query, err := db.selectNamed("outbox.select_pending")
+    if err != nil {
+        return 0, err
+    }
+    query.Where("sent_at IS NULL").OrderBy("id", false).Limit(OutboxBatchSize, 0)
+    rows, err := db.queryBuilt("outbox.select_pending", query)
+    if err != nil {
+        return 0, err
+    }
+    var events []ChangeEvent
+    for rows.Next() {
+        var event ChangeEvent
+        var actor, payload sql.NullString
+        if err := rows.Scan(&event.EventID, &event.Tenant, &event.Entity, &event.Op, &event.ID, &actor, &payload, &event.Time); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        event.Actor, event.Payload = actor.String, []byte(payload.String)
+        if !payload.Valid {
+            event.Payload = []byte("null")
+        }
+        events = append(events, event)
+    }
+    if err := rows.Close(); err != nil {
+        return 0, err
+    }
+    if len(events) == 0 {
+        return 0, nil
+    }
+
+    publishCtx, cancel := context.WithTimeout(ctx, PublishTimeout)
+    publishErr := db.publisher.Publish(publishCtx, events)
+    cancel()
+
+    err = db.InTx(func(tx *Database) error {
+        now := tx.now().UTC()
+        for _, event := range events {
+            var err error
+            if publishErr != nil {
+                _, err = tx.execNamed("outbox.mark_failed", publishErr.Error(), event.EventID)
+            } else {
+                _, err = tx.execNamed("outbox.mark_sent", now, event.EventID)
+            }
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if publishErr != nil {
+        return 0, fmt.Errorf("publishing %d events: %w", len(events), publishErr)
+    }
+    return len(events), err
+}
+
+// OutboxStatus возвращает число неотправленных событий outbox и из них - с неудачными попытками отправки
+func (db *Database) OutboxStatus() (pending, failed int64, err error) {
+    rows, err := db.WithPrimary().queryNamed("outbox.status")
+    if err != nil {
+        return 0, 0, db.opError("status", "outbox", nil, err)
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        err = rows.Scan(&pending, &failed)
+    }
+    if err == nil {
+        err = rows.Err()
+    }
+    return pending, failed, db.opError("status", "outbox", nil, err)
+}
+
+// runOutbox выполняет команду outbox: relay отправляет события до прерывания, status печатает очередь
+func runOutbox(db *Database, args []string) error {
+    if len(args) == 0 || args[0] != "relay" && args[0] != "status" {
+        return fmt.Errorf("usage: outbox relay [-interval D] | status")
+    }
+    flags := flag.NewFlagSet("outbox", flag.ContinueOnError)
+    interval := flags.Duration("interval", OutboxPollInterval, "how often to check the outbox once all events are sent")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    if args[0] == "relay" {
+        if db.publisher == nil {
+            return fmt.Errorf("outbox relay needs a change publisher, e.g. -cdc nats")
+        }
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+        db.RelayOutbox(ctx, *interval)
+        return nil
+    }
+
+    pending, failed, err := db.OutboxStatus()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("%d events pending, %d of them failed to publish at least once\n", pending, failed)
+    return nil
+}
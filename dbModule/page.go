@@ -0,0 +1,305 @@
+package main
+
+import (
+    "encoding/base64"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// DefaultPageSize и MaxPageSize ограничивают размер страницы PageRequest
+const (
+    DefaultPageSize = 50
+    MaxPageSize     = 500
+)
+
+// estimateTotalLimit - до скольких строк TotalEstimate считает точно; дальше итог помечается оценкой
+const estimateTotalLimit = 10000
+
+// TotalMode - нужно ли считать общее число строк для страницы
+type TotalMode int
+
+const (
+    // TotalNone - без общего числа: Total заполняется, только если страница последняя
+    TotalNone TotalMode = iota
+    // TotalExact - точное число через COUNT(*)
+    TotalExact
+    // TotalEstimate - точное число до estimateTotalLimit строк, дальше оценка "не меньше"
+    TotalEstimate
+)
+
+// PageRequest - запрос одной страницы списка
+type PageRequest struct {
+    // Cursor - NextCursor или PrevCursor предыдущей страницы; пустой - первая страница
+    Cursor string
+    // Size - число элементов на странице; 0 - DefaultPageSize, больше MaxPageSize не бывает
+    Size  int
+    Total TotalMode
+}
+
+// Page - страница списка в одном виде для всех выборок модуля и HTTP API
+type Page[T any] struct {
+    Items []T `json:"items"`
+    // NextCursor и PrevCursor передаются в PageRequest.Cursor; пустые на последней и первой странице
+    NextCursor string `json:"next_cursor,omitempty"`
+    PrevCursor string `json:"prev_cursor,omitempty"`
+    // Total - число строк по фильтру, если оно известно (см. TotalMode)
+    Total *int `json:"total,omitempty"`
+    // TotalEstimated - Total не точное, а нижняя граница
+    TotalEstimated bool `json:"total_estimated,omitempty"`
+    // Filters - примененные условия выборки, чтобы клиент видел, что именно листает
+    Filters map[string]string `json:"filters,omitempty"`
+}
+
+// pageQuery описывает постраничную выборку: базовые запросы без WHERE и условия к ним
+type pageQuery[T any] struct {
+    selectName string
+    countName  string
+    where      func(*SelectBuilder)
+    order      func(*SelectBuilder) error
+    scan       func(*queryRows) (T, error)
+    filters    map[string]string
+}
+
+// encodeCursor и decodeCursor переводят смещение страницы в непрозрачный курсор.
+// Клиенты не должны разбирать курсор: формат может смениться на keyset
+func encodeCursor(offset int) string {
+    return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+    if cursor == "" {
+        return 0, nil
+    }
+    invalid := &ValidationError{Field: "cursor", Message: "is invalid"}
+    raw, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return 0, invalid
+    }
+    value, ok := strings.CutPrefix(string(raw), "offset:")
+    if !ok {
+        return 0, invalid
+    }
+    offset, err := strconv.Atoi(value)
+    if err != nil || offset < 0 {
+        return 0, invalid
+    }
+    return offset, nil
+}
+
+// pageSize возвращает размер страницы в допустимых пределах
+func (request PageRequest) pageSize() int {
+    switch {
+    case request.Size <= 0:
+        return DefaultPageSize
+    case request.Size > MaxPageSize:
+        return MaxPageSize
+    }
+    return request.Size
+}
+
+// selectPage выбирает одну страницу текущей площадки. Берется на строку больше размера страницы,
+// чтобы без COUNT(*) знать, есть ли следующая; id в конце сортировки делает порядок однозначным
+func selectPage[T any](db *Database, q pageQuery[T], request PageRequest) (Page[T], error) {
+    page := Page[T]{Items: []T{}, Filters: q.filters}
+    offset, err := decodeCursor(request.Cursor)
+    if err != nil {
+        return page, err
+    }
+    size := request.pageSize()
+
+    query, err := db.selectNamed(q.selectName)
+    if err != nil {
+        return page, err
+    }
+    query.Where("tenant_id = ?", db.tenant)
+    if q.where != nil {
+        q.where(query)
+    }
+    if q.order != nil {
+        if err := q.order(query); err != nil {
+            return page, err
+        }
+    }
+    query.OrderBy("id", false)
+    query.Limit(size+1, offset)
+
+    rows, err := db.queryBuilt(q.selectName, query)
+    if err != nil {
+        return page, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        item, err := q.scan(rows)
+        if err != nil {
+            return page, err
+        }
+        page.Items = append(page.Items, item)
+    }
+    if err := rows.Err(); err != nil {
+        return page, err
+    }
+
+    last := len(page.Items) <= size
+    if !last {
+        page.Items = page.Items[:size]
+        page.NextCursor = encodeCursor(offset + size)
+    }
+    if offset > 0 {
+        page.PrevCursor = encodeCursor(max(offset-size, 0))
+    }
+
+    // на последней странице общее число известно без отдельного запроса,
+    // если только курсор не указывает за конец списка
+    if last && (len(page.Items) > 0 || offset == 0) {
+        total := offset + len(page.Items)
+        page.Total = &total
+        return page, nil
+    }
+    switch request.Total {
+    case TotalExact:
+        var total int
+        if err := db.queryScalar(q.countName, q.where, &total); err != nil {
+            return page, err
+        }
+        page.Total = &total
+    case TotalEstimate:
+        total, err := countUpTo(db, q, estimateTotalLimit)
+        if err != nil {
+            return page, err
+        }
+        page.Total = &total
+        page.TotalEstimated = total >= estimateTotalLimit
+    }
+    return page, nil
+}
+
+// countUpTo считает строки выборки, но не больше limit: на больших таблицах это дешевле COUNT(*)
+func countUpTo[T any](db *Database, q pageQuery[T], limit int) (int, error) {
+    query, err := db.selectNamed(q.selectName)
+    if err != nil {
+        return 0, err
+    }
+    query.Where("tenant_id = ?", db.tenant)
+    if q.where != nil {
+        q.where(query)
+    }
+    query.Limit(limit, 0)
+    text, args, err := query.Build(db.driver.dialect)
+    if err != nil {
+        return 0, err
+    }
+
+    rows, err := db.queryText(q.selectName, fmt.Sprintf("SELECT COUNT(*) FROM (%s) capped", text), args...)
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+    var count int
+    if rows.Next() {
+        if err := rows.Scan(&count); err != nil {
+            return 0, err
+        }
+    }
+    return count, rows.Err()
+}
+
+// RestaurantsPage возвращает страницу ресторанов по фильтру; Limit и Offset фильтра не учитываются.
+// Страницы кешируются (см. SetQueryCache)
+func (db *Database) RestaurantsPage(filter RestaurantFilter, request PageRequest, sorts ...RestaurantSort) (Page[Restaurant], error) {
+    args := fmt.Sprint(filter.values(), request, sorts)
+    page, err := cachedPage(db, "restaurants.select_filtered", args, func() (Page[Restaurant], error) {
+        return selectPage(db, pageQuery[Restaurant]{
+            selectName: "restaurants.select_filtered",
+            countName:  "restaurants.count_filtered",
+            where:      filter.applyWhere,
+            order: func(query *SelectBuilder) error {
+                return applyRestaurantSorts(query, sorts)
+            },
+            scan:    scanRestaurant,
+            filters: filter.values(),
+        }, request)
+    })
+    return page, db.opError("list", "restaurants", nil, err)
+}
+
+// UsersPage возвращает страницу пользователей в порядке ID; пустая role - пользователи всех ролей.
+// Страницы кешируются (см. SetQueryCache)
+func (db *Database) UsersPage(role string, request PageRequest) (Page[User], error) {
+    q := pageQuery[User]{
+        selectName: "users.select_filtered",
+        countName:  "users.count",
+        scan:       db.scanUser,
+    }
+    if role != "" {
+        q.where = func(query *SelectBuilder) {
+            query.Where("role = ?", role)
+        }
+        q.filters = map[string]string{"role": role}
+    }
+    page, err := cachedPage(db, "users.select_filtered", fmt.Sprint(role, request), func() (Page[User], error) {
+        return selectPage(db, q, request)
+    })
+    return page, db.opError("list", "users", nil, err)
+}
+
+// ReviewsPage возвращает страницу отзывов о ресторане в порядке добавления; страницы кешируются (см. SetQueryCache)
+func (db *Database) ReviewsPage(restaurantID int, request PageRequest) (Page[Review], error) {
+    page, err := cachedPage(db, "reviews.select_filtered", fmt.Sprint(restaurantID, request), func() (Page[Review], error) {
+        return selectPage(db, pageQuery[Review]{
+            selectName: "reviews.select_filtered",
+            countName:  "reviews.count_filtered",
+            where: func(query *SelectBuilder) {
+                query.Where("restaurant_id = ?", restaurantID)
+            },
+            scan:    scanReview,
+            filters: map[string]string{"restaurant_id": strconv.Itoa(restaurantID)},
+        }, request)
+    })
+    return page, db.opError("list", "reviews", restaurantID, err)
+}
+
+// UserListing - пользователь со всеми его ресторанами: элемент страницы ListingsPage
+type UserListing struct {
+    User        User         `json:"user"`
+    Restaurants []Restaurant `json:"restaurants"`
+}
+
+// ListingsPage - постраничный вариант SelectJoin: страница пользователей с ресторанами в порядке ID,
+// у каждого полный список его ресторанов в порядке ID. Страница отсчитывается по пользователям,
+// а не по строкам объединения, поэтому рестораны одного пользователя не делятся между страницами,
+// а Total - число таких пользователей. Страницы кешируются вместе с SelectJoin (см. SetQueryCache)
+func (db *Database) ListingsPage(request PageRequest) (Page[UserListing], error) {
+    page, err := cachedPage(db, "restaurants.select_join", fmt.Sprint(request), func() (Page[UserListing], error) {
+        users, err := selectPage(db, pageQuery[User]{
+            selectName: "users.select_filtered",
+            countName:  "users.count",
+            where: func(query *SelectBuilder) {
+                query.Where("id IN (SELECT user_id FROM {{prefix}}restaurants WHERE tenant_id = ?)", db.tenant)
+            },
+            scan: db.scanUser,
+        }, request)
+        page := Page[UserListing]{Items: make([]UserListing, 0, len(users.Items)), NextCursor: users.NextCursor,
+            PrevCursor: users.PrevCursor, Total: users.Total, TotalEstimated: users.TotalEstimated}
+        if err != nil || len(users.Items) == 0 {
+            return page, err
+        }
+        // страница не больше MaxPageSize, поэтому ID владельцев помещаются в один IN (см. loaderMaxBatch)
+        ids := make([]int, len(users.Items))
+        for i, user := range users.Items {
+            ids[i] = user.ID
+        }
+        owned, err := loadOwnedRestaurants(db)(ids)
+        if err != nil {
+            return page, err
+        }
+        for _, user := range users.Items {
+            // ресторан, удаленный между двумя запросами, может оставить пользователя без ресторанов
+            if restaurants := owned[user.ID]; len(restaurants) > 0 {
+                page.Items = append(page.Items, UserListing{User: user, Restaurants: restaurants})
+            }
+        }
+        return page, nil
+    })
+    return page, db.opError("list", "users with restaurants", nil, err)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// PartialResultError - выборка прочитала не все строки: часть строк не разобралась или курсор
+// оборвался посреди чтения. С SetPartialResults прочитанные строки возвращаются вместе с этой ошибкой;
+// errors.Is и errors.As видят каждую из ошибок Errs
+type PartialResultError struct {
+    Query string
+    // Rows - сколько строк прочитано и возвращено
+    Rows int
+    // Errs - ошибки разбора отдельных строк и ошибка курсора, в порядке появления
+    Errs []error
+}
+
+func (e *PartialResultError) Error() string {
+    return fmt.Sprintf("query %s: partial result of %d rows: %v", e.Query, e.Rows, errors.Join(e.Errs...))
+}
+
+// Unwrap возвращает ошибки чтения
+func (e *PartialResultError) Unwrap() []error {
+    return e.Errs
+}
+
+// SetPartialResults задает, что делают методы Select при ошибке посреди чтения. По умолчанию они
+// возвращают только ошибку, а не обрезанный список. С enabled строки, которые не удалось разобрать,
+// пропускаются, и методы возвращают все прочитанные строки вместе с *PartialResultError.
+// Неполные списки не кешируются. Вызывается до начала работы
+func (db *Database) SetPartialResults(enabled bool) {
+    db.partialResults = enabled
+}
+
+// collectRows читает все строки rows через scan и закрывает курсор. Ошибку разбора строки или курсора
+// (rows.Err) возвращает вместо списка, а с SetPartialResults - вместе с прочитанными строками.
+// Строку сверх предела SetMaxRows не разбирает и возвращает *RowLimitError
+func collectRows[T any](db *Database, rows *queryRows, scan func(*queryRows) (T, error)) ([]T, error) {
+    defer rows.Close()
+
+    var items []T
+    var errs []error
+    read := 0
+    for rows.Next() {
+        if db.maxRows > 0 && read == db.maxRows {
+            limit := &RowLimitError{Query: rows.name, Limit: db.maxRows, Truncated: db.truncateRows}
+            if !db.truncateRows {
+                return nil, limit
+            }
+            if len(errs) == 0 {
+                return items, limit
+            }
+            errs = append(errs, limit)
+            break
+        }
+        read++
+        item, err := scan(rows)
+        if err != nil {
+            if !db.partialResults {
+                return nil, err
+            }
+            errs = append(errs, err)
+            continue
+        }
+        items = append(items, item)
+    }
+    if err := rows.Err(); err != nil {
+        if !db.partialResults {
+            return nil, err
+        }
+        errs = append(errs, err)
+    }
+    if len(errs) > 0 {
+        return items, &PartialResultError{Query: rows.name, Rows: len(items), Errs: errs}
+    }
+    return items, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "unicode"
+)
+
+// PasswordPolicy - требования к паролям, которые записываются в базу: при вставке, upsert, изменении
+// пароля и сбросе (см. Config.PasswordPolicy). Нулевая политика ничего не проверяет
+type PasswordPolicy struct {
+    // MinLength - минимальная длина в символах (рунах); 0 - любая, кроме пустой
+    MinLength int
+    // RequireUpper, RequireLower, RequireDigit и RequireSymbol требуют хотя бы один символ класса:
+    // заглавную и строчную букву, цифру и символ, который не буква и не цифра
+    RequireUpper  bool
+    RequireLower  bool
+    RequireDigit  bool
+    RequireSymbol bool
+    // DenyCommon запрещает пароли из списка самых распространенных (commonPasswords) без учета регистра
+    DenyCommon bool
+}
+
+// DefaultPasswordPolicy - требования по умолчанию: длина, как рекомендует NIST SP 800-63B, и запрет
+// распространенных паролей, без обязательных классов символов
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8, DenyCommon: true}
+
+// commonPasswords - пароли, которые первыми перебирают при подборе
+var commonPasswords = map[string]bool{
+    "password": true, "password1": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+    "12345678": true, "123456789": true, "1234567890": true, "87654321": true, "11111111": true,
+    "00000000": true, "12341234": true, "11223344": true, "qwertyui": true, "qwerty123": true,
+    "qwertyuiop": true, "1q2w3e4r": true, "1qaz2wsx": true, "zaq12wsx": true, "asdfghjk": true,
+    "iloveyou": true, "princess": true, "sunshine": true, "football": true, "baseball": true,
+    "superman": true, "welcome1": true, "letmein1": true, "trustno1": true, "starwars": true,
+    "whatever": true, "dragon12": true, "computer": true, "internet": true, "michelle": true,
+    "jennifer": true, "changeme": true, "abcd1234": true, "abc12345": true, "admin123": true,
+    "administrator": true, "secret123": true, "welcome123": true, "monkey123": true, "master123": true,
+    "secret": true, "123456": true, "qwerty": true, "letmein": true, "welcome": true, "admin": true,
+}
+
+// Check проверяет пароль и возвращает *InvariantError сущности "user" со всеми нарушениями в поле
+// password или nil: клиент сразу видит все требования, которым пароль не отвечает
+func (p PasswordPolicy) Check(password string) error {
+    if p == (PasswordPolicy{}) {
+        return nil
+    }
+    if password == "" {
+        return &InvariantError{Entity: "user", Violations: []*ValidationError{{Field: "password", Message: "is required"}}}
+    }
+
+    var upper, lower, digit, symbol bool
+    for _, r := range password {
+        switch {
+        case unicode.IsUpper(r):
+            upper = true
+        case unicode.IsLower(r):
+            lower = true
+        case unicode.IsDigit(r):
+            digit = true
+        case !unicode.IsLetter(r):
+            symbol = true
+        }
+    }
+
+    var messages []string
+    if length := len([]rune(password)); length < p.MinLength {
+        messages = append(messages, fmt.Sprintf("must be at least %d characters long (got %d)", p.MinLength, length))
+    }
+    for _, class := range []struct {
+        required, present bool
+        name              string
+    }{
+        {p.RequireUpper, upper, "an uppercase letter"},
+        {p.RequireLower, lower, "a lowercase letter"},
+        {p.RequireDigit, digit, "a digit"},
+        {p.RequireSymbol, symbol, "a symbol"},
+    } {
+        if class.required && !class.present {
+            messages = append(messages, "must contain "+class.name)
+        }
+    }
+    if p.DenyCommon && commonPasswords[strings.ToLower(password)] {
+        messages = append(messages, "is too common")
+    }
+    if len(messages) == 0 {
+        return nil
+    }
+    violations := make([]*ValidationError, len(messages))
+    for i, message := range messages {
+        violations[i] = &ValidationError{Field: "password", Message: message}
+    }
+    return &InvariantError{Entity: "user", Violations: violations}
+}
+
+// parsePasswordClasses отмечает в политике обязательные классы символов из списка через запятую
+// (upper, lower, digit, symbol), как их задает флаг -password-classes
+func (p *PasswordPolicy) parsePasswordClasses(classes string) error {
+    for _, class := range strings.Split(classes, ",") {
+        switch strings.TrimSpace(class) {
+        case "":
+        case "upper":
+            p.RequireUpper = true
+        case "lower":
+            p.RequireLower = true
+        case "digit":
+            p.RequireDigit = true
+        case "symbol":
+            p.RequireSymbol = true
+        default:
+            return fmt.Errorf("unknown password character class %q: use upper, lower, digit or symbol", class)
+        }
+    }
+    return nil
+}
+
+// checkPassword проверяет новый пароль по политике этой Database
+func (db *Database) checkPassword(password string) error {
+    return db.passwordPolicy.Check(password)
+}
+
+// withoutPasswordPolicy возвращает копию Database, которая записывает пароли без проверки политики:
+// для паролей, которые модуль создает сам и которые никто не вводит (см. AnonymizeUser), и для RunSQLFuzz
+func (db *Database) withoutPasswordPolicy() *Database {
+    scoped := *db
+    scoped.passwordPolicy = PasswordPolicy{}
+    return &scoped
+}
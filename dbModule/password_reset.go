@@ -0,0 +1,96 @@
+package main
+
+import "time"
+
+// PasswordResetTTL - сколько действует токен сброса пароля
+var PasswordResetTTL = time.Hour
+
+// GeneratePasswordResetToken выдает одноразовый токен сброса пароля пользователю с адресом email
+// и отменяет выданные ему ранее. Токен нужно отправить на этот адрес: в базе хранится только его хеш.
+// Для неизвестного адреса возвращается ErrNotFound; показывать это клиенту не стоит,
+// чтобы по ответу нельзя было проверять, зарегистрирован ли адрес
+func (db *Database) GeneratePasswordResetToken(email string) (string, error) {
+    token, err := db.newID()
+    if err != nil {
+        return "", db.opError("reset password", "user", email, err)
+    }
+
+    err = db.InTx(func(tx *Database) error {
+        user, _, err := tx.findUserByEmail(email)
+        if err != nil {
+            return err
+        }
+        if user == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("password_resets.delete_by_user", user.ID, tx.tenant); err != nil {
+            return err
+        }
+        now := tx.now().UTC()
+        _, err = tx.execNamed("password_resets.insert", hashToken(token), user.ID, now, now.Add(PasswordResetTTL), tx.tenant)
+        return err
+    })
+    if err != nil {
+        return "", db.opError("reset password", "user", email, err)
+    }
+    return token, nil
+}
+
+// ResetPassword проверяет токен сброса и заменяет им пароль пользователя. Токен одноразовый:
+// после сброса он и остальные токены пользователя удаляются, а все его сессии отзываются.
+// Новый пароль проверяется по политике (см. Config.PasswordPolicy) до того, как токен будет использован
+func (db *Database) ResetPassword(token, newPassword string) error {
+    if newPassword == "" {
+        return db.opError("reset password", "user", nil, &ValidationError{Field: "password", Message: "is required"})
+    }
+    if err := db.checkPassword(newPassword); err != nil {
+        return db.opError("reset password", "user", nil, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        rows, err := tx.queryNamed("password_resets.select_valid", hashToken(token), tx.tenant, tx.now().UTC())
+        if err != nil {
+            return err
+        }
+        var userID int
+        found := rows.Next()
+        if found {
+            err = rows.Scan(&userID)
+        }
+        if err := rows.Close(); err != nil {
+            return err
+        }
+        if err != nil {
+            return err
+        }
+        if !found {
+            return ErrInvalidResetToken
+        }
+
+        user, err := tx.findUser("users.select_by_id", userID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if user == nil {
+            return ErrInvalidResetToken
+        }
+        user.Password = newPassword
+        if _, err := tx.UpdateUser(user); err != nil {
+            return err
+        }
+        if _, err := tx.execNamed("password_resets.delete_by_user", userID, tx.tenant); err != nil {
+            return err
+        }
+        return tx.RevokeUserSessions(userID)
+    })
+    return db.opError("reset password", "user", nil, err)
+}
+
+// DeleteExpiredPasswordResets удаляет просроченные токены сброса всех площадок и возвращает их число
+func (db *Database) DeleteExpiredPasswordResets() (int64, error) {
+    result, err := db.execNamed("password_resets.delete_expired", db.now().UTC())
+    if err != nil {
+        return 0, db.opError("delete expired", "password resets", nil, err)
+    }
+    return result.RowsAffected()
+}
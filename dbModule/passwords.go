@@ -0,0 +1,125 @@
+package main
+
+import (
+    "crypto/subtle"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+
+    "dbModule/httpapi"
+)
+
+// JWTSecretEnv - переменная окружения с ключом, которым с -auth подписываются токены POST /login
+const JWTSecretEnv = "DBMODULE_JWT_SECRET"
+
+// PasswordHashCost - стоимость bcrypt для новых паролей. Проверка одного пароля занимает порядка
+// 50 мс, поэтому массовые вставки (см. runBench) ее снижают
+var PasswordHashCost = bcrypt.DefaultCost
+
+// ErrInvalidCredentials возвращается Authenticate, если пользователя с таким email нет или пароль не подходит
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// hashPassword возвращает хеш bcrypt пароля для записи в users.password. Уже хешированный пароль
+// (например, User, прочитанный из базы и переданный в UpdateUser) и пустой возвращаются как есть
+func hashPassword(password string) (string, error) {
+    if password == "" || isPasswordHash(password) {
+        return password, nil
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+    if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+        return "", &ValidationError{Field: "password", Message: "must be at most 72 bytes"}
+    }
+    return string(hash), err
+}
+
+// isPasswordHash проверяет, что значение - хеш bcrypt
+func isPasswordHash(value string) bool {
+    return len(value) == 60 && (strings.HasPrefix(value, "$2a$") || strings.HasPrefix(value, "$2b$") || strings.HasPrefix(value, "$2y$"))
+}
+
+// passwordMatches сверяет пароль с записанным значением. Пароли, записанные до хеширования,
+// хранятся как есть и сравниваются за постоянное время
+func passwordMatches(stored, password string) bool {
+    if isPasswordHash(stored) {
+        return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+    }
+    return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// dummyPasswordHash сверяется с паролем, когда пользователя нет: так ответ Authenticate по времени
+// не выдает, зарегистрирован ли email
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Authenticate проверяет email и пароль пользователя площадки и возвращает его или ErrInvalidCredentials.
+// Пароль, записанный до хеширования, при успешном входе заменяется хешем, а вход отмечается
+// в last_active_at (см. RetentionAnonymize)
+func (db *Database) Authenticate(email, password string) (User, error) {
+    user, _, err := db.WithPrimary().findUserByEmail(email)
+    if err != nil {
+        return User{}, db.opError("authenticate", "user", email, err)
+    }
+    if user == nil {
+        bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+        return User{}, ErrInvalidCredentials
+    }
+    if !passwordMatches(user.Password, password) {
+        return User{}, ErrInvalidCredentials
+    }
+    if !isPasswordHash(user.Password) {
+        if user.Password, err = hashPassword(password); err != nil {
+            return User{}, db.opError("authenticate", "user", email, err)
+        }
+        // прежний пароль мог быть записан до ужесточения политики, и вход из-за нее не ломается
+        if _, err := db.withoutPasswordPolicy().UpdateUser(user); err != nil {
+            return User{}, db.opError("authenticate", "user", email, err)
+        }
+    }
+    if err := db.touchUserActivity(user.ID); err != nil {
+        return User{}, db.opError("authenticate", "user", email, err)
+    }
+    return *user, nil
+}
+
+// authOptions настраивает httpapi.Authenticator для HTTP API площадки: токены выдаются по email и паролю
+// (см. Authenticate) и подписываются secret, токены сессий тоже принимаются (см. ValidateSession),
+// а состояние базы и описание API доступны без входа
+func (db *Database) authOptions(secret []byte, ttl time.Duration) httpapi.AuthOptions {
+    return httpapi.AuthOptions{
+        Secret: secret,
+        TTL:    ttl,
+        Login: func(r *http.Request, email, password string) (string, error) {
+            user, err := db.Authenticate(email, password)
+            if errors.Is(err, ErrInvalidCredentials) {
+                return "", nil
+            }
+            if err != nil {
+                return "", err
+            }
+            return strconv.Itoa(user.ID), nil
+        },
+        Token: func(r *http.Request, token string) (string, error) {
+            session, err := db.ValidateSession(token)
+            if errors.Is(err, ErrInvalidSession) {
+                return "", nil
+            }
+            if err != nil {
+                return "", err
+            }
+            return strconv.Itoa(session.UserID), nil
+        },
+        Public: func(r *http.Request) bool {
+            if r.Method != http.MethodGet && r.Method != http.MethodHead {
+                return false
+            }
+            switch r.URL.Path {
+            case "/health", "/openapi.json", "/docs":
+                return true
+            }
+            return false
+        },
+    }
+}
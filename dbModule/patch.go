@@ -0,0 +1,433 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// patchKind - тип значения изменяемого поля
+type patchKind int
+
+const (
+    patchString patchKind = iota
+    patchInt
+)
+
+// patchField - поле сущности T, которое можно менять частично: колонка, тип значения
+// и запись значения в прочитанную запись
+type patchField[T any] struct {
+    column   string
+    kind     patchKind
+    // nullable - поле принимает nil (NULL в базе)
+    nullable bool
+    // secret - пароль: хешируется (см. hashPassword), передается драйверу как Secret и скрывается в логах
+    secret   bool
+    // pii - значение шифруется, если колонку шифрует SetPIIEncryption
+    pii      bool
+    set      func(record *T, value interface{})
+}
+
+// userPatchFields - поля пользователя для UpdateUserFields; ID, версия и площадка не меняются
+var userPatchFields = map[string]patchField[User]{
+    "name":       {column: "name", set: func(u *User, v interface{}) { u.Name = v.(string) }},
+    "lastname":   {column: "lastname", set: func(u *User, v interface{}) { u.Lastname = v.(string) }},
+    "password":   {column: "password", secret: true, set: func(u *User, v interface{}) { u.Password = v.(string) }},
+    "email":      {column: "email", pii: true, set: func(u *User, v interface{}) { u.Email = v.(string) }},
+    "phone":      {column: "phone", nullable: true, pii: true, set: func(u *User, v interface{}) { u.Phone = patchStringPtr(v) }},
+    "role":       {column: "role", set: func(u *User, v interface{}) { u.Role = v.(string) }},
+    "avatar_url": {column: "avatar_url", nullable: true, set: func(u *User, v interface{}) { u.AvatarURL = patchStringPtr(v) }},
+    "bio":        {column: "bio", nullable: true, set: func(u *User, v interface{}) { u.Bio = patchStringPtr(v) }},
+    "birthdate":  {column: "birthdate", nullable: true, set: func(u *User, v interface{}) { u.Birthdate = patchStringPtr(v) }},
+    "locale":     {column: "locale", nullable: true, set: func(u *User, v interface{}) { u.Locale = patchStringPtr(v) }},
+}
+
+// restaurantPatchFields - поля ресторана для UpdateRestaurantFields
+var restaurantPatchFields = map[string]patchField[Restaurant]{
+    "name":           {column: "name", set: func(r *Restaurant, v interface{}) { r.Name = v.(string) }},
+    "type":           {column: "type", set: func(r *Restaurant, v interface{}) { r.Type = v.(string) }},
+    "keys":           {column: "keys", nullable: true, set: func(r *Restaurant, v interface{}) { r.Keys = patchStringPtr(v) }},
+    "average_price":  {column: "average_price", kind: patchInt, set: func(r *Restaurant, v interface{}) { r.AveragePrice = PriceTier(v.(int)) }},
+    "user_id":        {column: "user_id", kind: patchInt, set: func(r *Restaurant, v interface{}) { r.UserID = v.(int) }},
+    // price_amount и price_currency меняются вместе, иначе запись не пройдет проверку правил ресторана
+    "price_amount":   {column: "price_amount", kind: patchInt, nullable: true, set: func(r *Restaurant, v interface{}) { r.PriceAmount = patchInt64Ptr(v) }},
+    "price_currency": {column: "price_currency", nullable: true, set: func(r *Restaurant, v interface{}) { r.PriceCurrency = patchStringPtr(v) }},
+}
+
+// UserPatch - частичное изменение пользователя для PatchUser: поля nil не меняются
+type UserPatch struct {
+    Name           *string
+    Lastname       *string
+    Password       *string
+    Email          *string
+    // Phone задает телефон; ClearPhone записывает NULL
+    Phone          *string
+    ClearPhone     bool
+    Role           *string
+    // AvatarURL, Bio, Birthdate и Locale задают поля профиля, а Clear* записывают в них NULL
+    AvatarURL      *string
+    ClearAvatarURL bool
+    Bio            *string
+    ClearBio       bool
+    Birthdate      *string
+    ClearBirthdate bool
+    Locale         *string
+    ClearLocale    bool
+}
+
+// Fields возвращает изменение в виде для UpdateUserFields
+func (p UserPatch) Fields() map[string]interface{} {
+    fields := make(map[string]interface{})
+    for name, value := range map[string]*string{"name": p.Name, "lastname": p.Lastname, "password": p.Password, "email": p.Email, "phone": p.Phone, "role": p.Role,
+        "avatar_url": p.AvatarURL, "bio": p.Bio, "birthdate": p.Birthdate, "locale": p.Locale} {
+        if value != nil {
+            fields[name] = *value
+        }
+    }
+    for name, clear := range map[string]bool{"phone": p.ClearPhone, "avatar_url": p.ClearAvatarURL, "bio": p.ClearBio, "birthdate": p.ClearBirthdate, "locale": p.ClearLocale} {
+        if clear {
+            fields[name] = nil
+        }
+    }
+    return fields
+}
+
+// RestaurantPatch - частичное изменение ресторана для PatchRestaurant: поля nil не меняются
+type RestaurantPatch struct {
+    Name         *string
+    Type         *string
+    // Keys задает ключи; ClearKeys записывает NULL
+    Keys         *string
+    ClearKeys    bool
+    AveragePrice *PriceTier
+    UserID       *int
+    // Price задает средний чек; ClearPrice убирает его
+    Price        *Money
+    ClearPrice   bool
+}
+
+// Fields возвращает изменение в виде для UpdateRestaurantFields
+func (p RestaurantPatch) Fields() map[string]interface{} {
+    fields := make(map[string]interface{})
+    for name, value := range map[string]*string{"name": p.Name, "type": p.Type, "keys": p.Keys} {
+        if value != nil {
+            fields[name] = *value
+        }
+    }
+    if p.ClearKeys {
+        fields["keys"] = nil
+    }
+    if p.AveragePrice != nil {
+        fields["average_price"] = int(*p.AveragePrice)
+    }
+    if p.UserID != nil {
+        fields["user_id"] = *p.UserID
+    }
+    if p.Price != nil {
+        fields["price_amount"], fields["price_currency"] = p.Price.Amount, p.Price.Currency
+    }
+    if p.ClearPrice {
+        fields["price_amount"], fields["price_currency"] = nil, nil
+    }
+    return fields
+}
+
+// PatchUser меняет только заданные в patch поля пользователя (см. UpdateUserFields)
+func (db *Database) PatchUser(id int, patch UserPatch) (*User, error) {
+    return db.UpdateUserFields(id, patch.Fields())
+}
+
+// PatchRestaurant меняет только заданные в patch поля ресторана от имени пользователя actorID (см. UpdateRestaurantFields)
+func (db *Database) PatchRestaurant(actorID, id int, patch RestaurantPatch) (*Restaurant, error) {
+    return db.UpdateRestaurantFields(actorID, id, patch.Fields())
+}
+
+// UpdateUserFields меняет только перечисленные в fields колонки пользователя id и возвращает
+// его новое состояние. Ключи - имена полей (name, lastname, password, email, phone, role, avatar_url,
+// bio, birthdate, locale), значения - строки; phone и поля профиля принимают nil для NULL. Правила сущности проверяются для записи
+// с примененными изменениями. Версия строки увеличивается, но не сверяется: изменения
+// незатронутых полей, сделанные параллельно, сохраняются. Пустой fields ничего не пишет
+func (db *Database) UpdateUserFields(id int, fields map[string]interface{}) (*User, error) {
+    var updated *User
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findUser("users.select_by_id", id, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        record := *old
+        query, args, err := buildPatch(tx, "users.update_fields", userPatchFields, fields, &record)
+        if err != nil || query == "" {
+            updated = old
+            return err
+        }
+        if err := validateUser(record); err != nil {
+            return err
+        }
+        if _, ok := fields["password"]; ok && record.Password != old.Password {
+            if err := tx.checkPassword(record.Password); err != nil {
+                return err
+            }
+        }
+        if _, err := tx.execText("users.update_fields", query, append(args, id, tx.tenant)...); err != nil {
+            return err
+        }
+        if updated, err = tx.findUser("users.select_by_id", id, tx.tenant); err != nil {
+            return err
+        }
+        return tx.audit("user", id, AuditUpdate, old, updated)
+    })
+    if err != nil {
+        return nil, db.opError("update", "user", id, err)
+    }
+    return updated, nil
+}
+
+// UpdateRestaurantFields меняет только перечисленные в fields колонки ресторана id, как UpdateUserFields,
+// от имени пользователя actorID: права проверяются в той же транзакции, как в UpdateRestaurant, и
+// передать ресторан другому владельцу через user_id может только администратор. Поля: name, type,
+// keys (строка или nil), average_price и user_id (целые), price_amount (целое или nil) и price_currency (строка или nil)
+func (db *Database) UpdateRestaurantFields(actorID, id int, fields map[string]interface{}) (*Restaurant, error) {
+    var updated *Restaurant
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findRestaurant("restaurants.select_by_id", id, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if err := tx.authorizeRestaurant(actorID, *old); err != nil {
+            return err
+        }
+        record := *old
+        query, args, err := buildPatch(tx, "restaurants.update_fields", restaurantPatchFields, fields, &record)
+        if err != nil || query == "" {
+            updated = old
+            return err
+        }
+        if err := tx.authorizeRestaurant(actorID, record); err != nil {
+            return err
+        }
+        if err := validateRestaurant(record); err != nil {
+            return err
+        }
+        _, err = tx.execText("restaurants.update_fields", query, append(args, id, tx.tenant)...)
+        if tx.driver.isForeignKeyError(err) {
+            return fmt.Errorf("restaurant owner %d does not exist: %w", record.UserID, ErrForeignKeyViolation)
+        }
+        if err != nil {
+            return err
+        }
+        if updated, err = tx.findRestaurant("restaurants.select_by_id", id, tx.tenant); err != nil {
+            return err
+        }
+        return tx.audit("restaurant", id, AuditUpdate, old, updated)
+    })
+    if err != nil {
+        return nil, db.opError("update", "restaurant", id, err)
+    }
+    return updated, nil
+}
+
+// buildPatch проверяет изменения fields по описанию полей, применяет их к record и собирает
+// UPDATE из базового запроса name: SET только для переданных полей (по алфавиту) и
+// WHERE id = ? AND tenant_id = ?, аргументы которого дописывает вызывающий. В текст запроса
+// попадают только колонки из описания, значения передаются аргументами. Пустой fields - пустой запрос
+func buildPatch[T any](db *Database, name string, spec map[string]patchField[T], fields map[string]interface{}, record *T) (string, []interface{}, error) {
+    if len(fields) == 0 {
+        return "", nil, nil
+    }
+    names := make([]string, 0, len(fields))
+    for field := range fields {
+        names = append(names, field)
+    }
+    sort.Strings(names)
+
+    var assignments []string
+    var args []interface{}
+    for _, field := range names {
+        column, ok := spec[field]
+        if !ok {
+            return "", nil, &ValidationError{Field: field, Message: "cannot be changed"}
+        }
+        value, err := patchValue(column, fields[field])
+        if err != nil {
+            return "", nil, &ValidationError{Field: field, Message: err.Error()}
+        }
+        column.set(record, value)
+        if column.secret {
+            hash, err := hashPassword(value.(string))
+            if err != nil {
+                return "", nil, err
+            }
+            value = Secret(hash)
+        }
+        if plain, ok := value.(string); ok && column.pii {
+            if value, err = db.pii.seal(column.column, plain); err != nil {
+                return "", nil, err
+            }
+        }
+        assignments = append(assignments, db.patchColumn(column.column)+" = ?")
+        args = append(args, value)
+    }
+
+    base, err := db.lookupQuery(name)
+    if err != nil {
+        return "", nil, err
+    }
+    query := fmt.Sprintf("%s SET %s, version = version + 1 WHERE id = ? AND tenant_id = ?",
+        strings.TrimSuffix(strings.TrimSpace(base), ";"), strings.Join(assignments, ", "))
+    return query, args, nil
+}
+
+// patchColumn возвращает имя колонки для SET; в MySQL имена берутся в обратные кавычки,
+// потому что среди колонок есть зарезервированные слова (keys)
+func (db *Database) patchColumn(column string) string {
+    if db.driver.dialect.Name() == "mysql" {
+        return "`" + column + "`"
+    }
+    return column
+}
+
+// patchValue приводит значение к типу поля. Числа принимаются и в виде float64 и json.Number,
+// в которых их дает encoding/json, если они целые
+func patchValue[T any](field patchField[T], value interface{}) (interface{}, error) {
+    if value == nil {
+        if !field.nullable {
+            return nil, errors.New("cannot be null")
+        }
+        return nil, nil
+    }
+    switch field.kind {
+    case patchString:
+        if text, ok := value.(string); ok {
+            return text, nil
+        }
+        return nil, errors.New("must be a string")
+    case patchInt:
+        switch number := value.(type) {
+        case int:
+            return number, nil
+        case int64:
+            return int(number), nil
+        case float64:
+            if number == math.Trunc(number) && math.Abs(number) <= math.MaxInt32 {
+                return int(number), nil
+            }
+        case json.Number:
+            if n, err := strconv.Atoi(number.String()); err == nil {
+                return n, nil
+            }
+        }
+        return nil, errors.New("must be an integer")
+    }
+    return nil, fmt.Errorf("unsupported field kind %d", field.kind)
+}
+
+// patchStringPtr возвращает значение необязательного строкового поля: nil - NULL
+func patchStringPtr(value interface{}) *string {
+    if value == nil {
+        return nil
+    }
+    return stringPtr(value.(string))
+}
+
+// patchInt64Ptr возвращает значение необязательного целого поля: nil - NULL
+func patchInt64Ptr(value interface{}) *int64 {
+    if value == nil {
+        return nil
+    }
+    n := int64(value.(int))
+    return &n
+}
+
+// restaurantPatchBody и userPatchBody описывают тело PATCH для OpenAPI: все поля необязательны
+type restaurantPatchBody struct {
+    Name          string    `json:"name,omitempty"`
+    Type          string    `json:"type,omitempty"`
+    Keys          *string   `json:"keys,omitempty"`
+    AveragePrice  PriceTier `json:"average_price,omitempty"`
+    UserID        int       `json:"user_id,omitempty"`
+    PriceAmount   *int64    `json:"price_amount,omitempty"`
+    PriceCurrency *string   `json:"price_currency,omitempty"`
+}
+
+type userPatchBody struct {
+    Name      string  `json:"name,omitempty"`
+    Lastname  string  `json:"lastname,omitempty"`
+    Password  string  `json:"password,omitempty"`
+    Email     string  `json:"email,omitempty"`
+    Phone     *string `json:"phone,omitempty"`
+    Role      string  `json:"role,omitempty"`
+    AvatarURL *string `json:"avatar_url,omitempty"`
+    Bio       *string `json:"bio,omitempty"`
+    Birthdate *string `json:"birthdate,omitempty"`
+    Locale    *string `json:"locale,omitempty"`
+}
+
+// decodePatch читает тело PATCH - объект JSON с изменяемыми полями (null - записать NULL)
+func decodePatch(r *http.Request) (map[string]interface{}, error) {
+    decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+    decoder.UseNumber()
+    var fields map[string]interface{}
+    if err := decoder.Decode(&fields); err != nil {
+        return nil, &ValidationError{Field: "body", Message: "must be a JSON object: " + err.Error()}
+    }
+    return fields, nil
+}
+
+// servePatchRestaurant меняет только поля ресторана, переданные в теле; пользователь, вошедший
+// через -auth, - только своего ресторана, если он не администратор (см. CanManageRestaurant)
+func (db *Database) servePatchRestaurant(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    fields, err := decodePatch(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    actor, _ := requestActor(r)
+    restaurant, err := db.UpdateRestaurantFields(actor, id, fields)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, restaurant)
+}
+
+// servePatchUser меняет только поля пользователя, переданные в теле; пароль в ответ не попадает.
+// Пользователь, вошедший через -auth, меняет только себя и не меняет роль, если он не администратор
+func (db *Database) servePatchUser(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    fields, err := decodePatch(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    actor, _ := requestActor(r)
+    if err := db.authorizeUserFields(actor, id, fields); err != nil {
+        writeError(w, err)
+        return
+    }
+    user, err := db.UpdateUserFields(id, fields)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, user.Admin())
+}
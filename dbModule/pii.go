@@ -0,0 +1,402 @@
+package main
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "flag"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// PIIKeysEnv - переменная окружения с ключами шифрования персональных данных для командной строки
+// (см. ParseStaticKeys); ключи не передаются флагами, чтобы не попадать в список процессов
+const PIIKeysEnv = "DBMODULE_PII_KEYS"
+
+// piiPrefix начинает зашифрованное значение колонки: enc1:<id ключа>:<base64 nonce и шифротекста>.
+// Значения без префикса читаются как есть: это строки, записанные до включения шифрования
+const piiPrefix = "enc1:"
+
+// KeyProvider - ключи AES (16, 24 или 32 байта) для шифрования персональных данных.
+// Новые значения шифруются текущим ключом, прочитанные - ключом из их префикса, поэтому
+// при смене ключа старые ключи остаются в провайдере, пока RotatePIIKeys не перешифрует строки
+// (записи журнала аудита не перешифровываются, и для их чтения старые ключи нужны и дальше)
+type KeyProvider interface {
+    // CurrentKey возвращает текущий ключ и его идентификатор
+    CurrentKey() (id string, key []byte, err error)
+    // Key возвращает ключ по идентификатору
+    Key(id string) ([]byte, error)
+    // KeyIDs возвращает идентификаторы всех ключей, текущий первым
+    KeyIDs() []string
+}
+
+// StaticKeys - ключи, заданные при запуске
+type StaticKeys struct {
+    current string
+    keys    map[string][]byte
+}
+
+// NewStaticKeys создает провайдер с ключами keys и текущим ключом current
+func NewStaticKeys(current string, keys map[string][]byte) (*StaticKeys, error) {
+    if _, ok := keys[current]; !ok {
+        return nil, fmt.Errorf("pii keys: current key %q is not among the keys", current)
+    }
+    for id, key := range keys {
+        if id == "" || strings.Contains(id, ":") {
+            return nil, fmt.Errorf("pii keys: key id %q must be non-empty and contain no colon", id)
+        }
+        if n := len(key); n != 16 && n != 24 && n != 32 {
+            return nil, fmt.Errorf("pii keys: key %q is %d bytes, want 16, 24 or 32", id, n)
+        }
+    }
+    return &StaticKeys{current: current, keys: keys}, nil
+}
+
+// ParseStaticKeys разбирает ключи вида "id:base64,id:base64"; первый ключ - текущий,
+// например "k2:<новый ключ>,k1:<старый ключ>" на время ротации
+func ParseStaticKeys(spec string) (*StaticKeys, error) {
+    keys := make(map[string][]byte)
+    var current string
+    for _, part := range strings.Split(spec, ",") {
+        if part = strings.TrimSpace(part); part == "" {
+            continue
+        }
+        id, encoded, ok := strings.Cut(part, ":")
+        if !ok {
+            return nil, fmt.Errorf("pii keys: %q: want id:base64", part)
+        }
+        key, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil {
+            return nil, fmt.Errorf("pii keys: key %q: %v", id, err)
+        }
+        if current == "" {
+            current = id
+        }
+        keys[id] = key
+    }
+    if current == "" {
+        return nil, fmt.Errorf("pii keys: no keys given")
+    }
+    return NewStaticKeys(current, keys)
+}
+
+// CurrentKey возвращает текущий ключ
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+    return k.current, k.keys[k.current], nil
+}
+
+// Key возвращает ключ по идентификатору
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+    key, ok := k.keys[id]
+    if !ok {
+        return nil, fmt.Errorf("pii key %q is unknown", id)
+    }
+    return key, nil
+}
+
+// KeyIDs возвращает идентификаторы ключей, текущий первым
+func (k *StaticKeys) KeyIDs() []string {
+    ids := []string{k.current}
+    var others []string
+    for id := range k.keys {
+        if id != k.current {
+            others = append(others, id)
+        }
+    }
+    sort.Strings(others)
+    return append(ids, others...)
+}
+
+// PIIEncryption - шифрование персональных данных пользователей (AES-GCM) в базе
+type PIIEncryption struct {
+    Keys KeyProvider
+    // Email шифрует и email. Шифрование email детерминированное (nonce выводится из значения), чтобы
+    // работали поиск по адресу и уникальный индекс; одинаковые адреса дают одинаковый шифротекст.
+    // Телефон шифруется всегда и со случайным nonce
+    Email bool
+}
+
+// SetPIIEncryption включает шифрование персональных данных или выключает его (Keys == nil).
+// Шифруются новые и измененные строки; уже записанные читаются как есть, пока их не перешифрует
+// RotatePIIKeys. Выключать шифрование можно только после перешифровки без ключей:
+// зашифрованные значения без ключа не читаются. Вызывается до начала работы с базой
+func (db *Database) SetPIIEncryption(options PIIEncryption) error {
+    if options.Keys == nil {
+        db.pii = nil
+        return nil
+    }
+    if _, _, err := options.Keys.CurrentKey(); err != nil {
+        return err
+    }
+    db.pii = &piiCipher{options: options, aeads: make(map[string]cipher.AEAD)}
+    return nil
+}
+
+// piiCipher шифрует и расшифровывает колонки пользователей; nil - шифрование выключено
+type piiCipher struct {
+    options PIIEncryption
+
+    mu    sync.Mutex
+    aeads map[string]cipher.AEAD
+}
+
+// encrypts сообщает, шифруется ли колонка column таблицы users
+func (c *piiCipher) encrypts(column string) bool {
+    return c != nil && (column == "phone" || column == "email" && c.options.Email)
+}
+
+// aead возвращает шифр для ключа id, создавая его при первом обращении
+func (c *piiCipher) aead(id string, key []byte) (cipher.AEAD, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if aead, ok := c.aeads[id]; ok {
+        return aead, nil
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("pii key %q: %w", id, err)
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    c.aeads[id] = aead
+    return aead, nil
+}
+
+// seal шифрует значение колонки column текущим ключом, если колонка шифруется
+func (c *piiCipher) seal(column, value string) (string, error) {
+    if !c.encrypts(column) {
+        return value, nil
+    }
+    id, key, err := c.options.Keys.CurrentKey()
+    if err != nil {
+        return "", err
+    }
+    return c.sealWith(column, value, id, key)
+}
+
+// sealWith шифрует значение ключом id. Имя колонки входит в проверяемые данные шифра,
+// чтобы шифротекст одной колонки нельзя было подставить в другую
+func (c *piiCipher) sealWith(column, value, id string, key []byte) (string, error) {
+    aead, err := c.aead(id, key)
+    if err != nil {
+        return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if column == "email" {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte("dbModule pii nonce\x00" + column + "\x00" + value))
+        copy(nonce, mac.Sum(nil))
+    } else if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    sealed := aead.Seal(nonce, nonce, []byte(value), []byte(column))
+    return piiPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open расшифровывает значение колонки; значения без префикса возвращаются как есть
+func (c *piiCipher) open(column, value string) (string, error) {
+    rest, ok := strings.CutPrefix(value, piiPrefix)
+    if !ok {
+        return value, nil
+    }
+    if c == nil {
+        return "", fmt.Errorf("users.%s is encrypted, but PII encryption is not configured", column)
+    }
+    id, encoded, _ := strings.Cut(rest, ":")
+    key, err := c.options.Keys.Key(id)
+    if err != nil {
+        return "", err
+    }
+    aead, err := c.aead(id, key)
+    if err != nil {
+        return "", err
+    }
+    sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+    if err != nil || len(sealed) < aead.NonceSize() {
+        return "", fmt.Errorf("users.%s: malformed encrypted value", column)
+    }
+    plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column))
+    if err != nil {
+        return "", fmt.Errorf("users.%s: cannot decrypt with key %q: %w", column, id, err)
+    }
+    return string(plain), nil
+}
+
+// candidates возвращает все значения, которыми email может быть записан в базе: шифротексты
+// под каждым ключом и исходное значение для строк до включения шифрования
+func (c *piiCipher) candidates(column, value string) ([]string, error) {
+    if !c.encrypts(column) {
+        return []string{value}, nil
+    }
+    var values []string
+    for _, id := range c.options.Keys.KeyIDs() {
+        key, err := c.options.Keys.Key(id)
+        if err != nil {
+            return nil, err
+        }
+        sealed, err := c.sealWith(column, value, id, key)
+        if err != nil {
+            return nil, err
+        }
+        values = append(values, sealed)
+    }
+    return append(values, value), nil
+}
+
+// sealUser возвращает email и телефон пользователя в том виде, в котором они записываются в базу
+func (db *Database) sealUser(user User) (string, *string, error) {
+    email, err := db.pii.seal("email", user.Email)
+    if err != nil || user.Phone == nil {
+        return email, nil, err
+    }
+    phone, err := db.pii.seal("phone", *user.Phone)
+    return email, &phone, err
+}
+
+// openUser расшифровывает прочитанные email и телефон пользователя
+func (db *Database) openUser(user *User) error {
+    email, err := db.pii.open("email", user.Email)
+    if err != nil {
+        return err
+    }
+    user.Email = email
+    if user.Phone != nil {
+        phone, err := db.pii.open("phone", *user.Phone)
+        if err != nil {
+            return err
+        }
+        user.Phone = &phone
+    }
+    return nil
+}
+
+// findUserByEmail ищет пользователя по адресу под всеми ключами и возвращает его вместе с тем,
+// как адрес записан в базе; nil, если пользователя нет
+func (db *Database) findUserByEmail(email string) (*User, string, error) {
+    candidates, err := db.pii.candidates("email", email)
+    if err != nil {
+        return nil, "", err
+    }
+    for _, stored := range candidates {
+        user, err := db.findUser("users.select_by_email", stored, db.tenant)
+        if err != nil || user != nil {
+            return user, stored, err
+        }
+    }
+    return nil, "", nil
+}
+
+// sealedAuditValue шифрует персональные данные пользователя перед записью в журнал аудита
+func (db *Database) sealedAuditValue(value interface{}) (interface{}, error) {
+    user, ok := value.(*User)
+    if !ok || user == nil || db.pii == nil {
+        return value, nil
+    }
+    sealed := *user
+    var err error
+    sealed.Email, sealed.Phone, err = db.sealUser(*user)
+    return &sealed, err
+}
+
+// RotatePIIKeys перешифровывает email и телефоны всех пользователей всех площадок текущим ключом
+// и текущими настройками SetPIIEncryption: значения под старыми ключами и записанные до включения
+// шифрования. Версии строк и журнал аудита не меняются. Возвращает число перезаписанных строк
+func (db *Database) RotatePIIKeys() (int, error) {
+    rotated := 0
+    err := db.InTx(func(tx *Database) error {
+        type storedPII struct {
+            id           int
+            email, phone *string
+        }
+        rows, err := tx.queryNamed("users.select_pii")
+        if err != nil {
+            return err
+        }
+        var stored []storedPII
+        for rows.Next() {
+            var row storedPII
+            if err := rows.Scan(&row.id, &row.email, &row.phone); err != nil {
+                rows.Close()
+                return err
+            }
+            stored = append(stored, row)
+        }
+        if err := rows.Close(); err != nil {
+            return err
+        }
+
+        for _, row := range stored {
+            email, err := tx.resealPII("email", row.email)
+            if err != nil {
+                return fmt.Errorf("user %d: %w", row.id, err)
+            }
+            phone, err := tx.resealPII("phone", row.phone)
+            if err != nil {
+                return fmt.Errorf("user %d: %w", row.id, err)
+            }
+            if equalStringPtr(email, row.email) && equalStringPtr(phone, row.phone) {
+                continue
+            }
+            if _, err := tx.execNamed("users.update_pii", email, phone, row.id); err != nil {
+                return fmt.Errorf("user %d: %w", row.id, err)
+            }
+            rotated++
+        }
+        return nil
+    })
+    return rotated, db.opError("rotate pii keys", "users", nil, err)
+}
+
+// resealPII расшифровывает записанное значение и шифрует его заново, если оно записано
+// не текущим ключом или не в соответствии с настройками; иначе возвращает его без изменений
+func (db *Database) resealPII(column string, stored *string) (*string, error) {
+    if stored == nil {
+        return nil, nil
+    }
+    if db.pii.encrypts(column) {
+        id, _, err := db.pii.options.Keys.CurrentKey()
+        if err != nil {
+            return nil, err
+        }
+        // значение под текущим ключом уже в нужном виде
+        if strings.HasPrefix(*stored, piiPrefix+id+":") {
+            return stored, nil
+        }
+    }
+    plain, err := db.pii.open(column, *stored)
+    if err != nil {
+        return nil, err
+    }
+    sealed, err := db.pii.seal(column, plain)
+    return &sealed, err
+}
+
+// equalStringPtr сравнивает необязательные строки
+func equalStringPtr(a, b *string) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    return *a == *b
+}
+
+// runRotatePII перешифровывает персональные данные пользователей ключами из PIIKeysEnv
+func runRotatePII(db *Database, args []string) error {
+    flags := flag.NewFlagSet("rotate-pii", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    rotated, err := db.RotatePIIKeys()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Re-encrypted %d users\n", rotated)
+    return nil
+}
@@ -0,0 +1,62 @@
+//go:build postgres
+
+package main
+
+import (
+    "fmt"
+    "testing"
+)
+
+// Интеграционные тесты PostgreSQL собираются с тегом postgres и выполняются, только если задана база:
+//   DBMODULE_TEST_POSTGRES_DSN=postgres://... go test -tags postgres -run Postgres
+//   DBMODULE_TEST_POSTGRES=docker go test -tags postgres -run Postgres
+
+func TestPostgresTestDatabase(t *testing.T) {
+    db := NewPostgresTestDatabase(t)
+    if db.driver.name != "postgres" {
+        t.Fatalf("test database uses driver %s, want postgres", db.driver.name)
+    }
+    owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Postgres-Passw0rd!", Role: RoleOwner}
+    if _, err := db.InsertUserReturningID(&owner); err != nil {
+        t.Fatal(err)
+    }
+    restaurant := Restaurant{Name: "Cafe", Type: "cafe", AveragePrice: PriceTierBudget, UserID: owner.ID}
+    if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+        t.Fatal(err)
+    }
+    restaurants, err := db.SelectRestaurants()
+    if err != nil {
+        t.Fatal(err)
+    }
+    // NewPostgresTestDatabase очищает общую базу, поэтому в ней только строки этого теста
+    if len(restaurants) != 1 || restaurants[0].Name != restaurant.Name {
+        t.Errorf("test database has %v, want only %q", restaurants, restaurant.Name)
+    }
+}
+
+// TestIsolatedPostgresTestDatabases проверяет, что параллельные тесты на NewIsolatedPostgresTestDatabase
+// не видят данных друг друга
+func TestIsolatedPostgresTestDatabases(t *testing.T) {
+    for i := 0; i < 4; i++ {
+        t.Run(fmt.Sprint(i), func(t *testing.T) {
+            t.Parallel()
+            db := NewIsolatedPostgresTestDatabase(t)
+            owner := User{Name: "Olga", Lastname: "Owner", Email: "owner@example.com", Password: "Postgres-Passw0rd!", Role: RoleOwner}
+            if _, err := db.InsertUserReturningID(&owner); err != nil {
+                t.Fatal(err)
+            }
+            restaurant := concurrentRestaurant(i, 0)
+            restaurant.UserID = owner.ID
+            if _, err := db.InsertRestaurantReturningID(&restaurant); err != nil {
+                t.Fatal(err)
+            }
+            restaurants, err := db.SelectRestaurants()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(restaurants) != 1 || restaurants[0].Name != restaurant.Name {
+                t.Errorf("isolated database has %v, want only %q", restaurants, restaurant.Name)
+            }
+        })
+    }
+}
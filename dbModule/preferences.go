@@ -0,0 +1,273 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// Известные настройки пользователя: их значения проверяются при записи, и у Preferences есть методы для них
+const (
+    // PreferenceDefaultCity - город, в котором по умолчанию ищутся рестораны
+    PreferenceDefaultCity = "default_city"
+    // PreferencePriceTier - предпочитаемый уровень цен (см. ParsePriceTier)
+    PreferencePriceTier = "price_tier"
+    // PreferenceSavedSearches - сохраненные поиски ресторанов, массив SavedSearch в JSON
+    PreferenceSavedSearches = "saved_searches"
+)
+
+// maxPreferenceLength - наибольшая длина значения настройки в символах
+const maxPreferenceLength = 4000
+
+// preferenceNamePattern - допустимые имена настроек
+var preferenceNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+
+// Preferences - настройки пользователя по имени. Имена, кроме известных (PreferenceDefaultCity и т. д.),
+// выбирает клиент, значения - строки
+type Preferences map[string]string
+
+// SavedSearch - сохраненный поиск ресторанов
+type SavedSearch struct {
+    Name string `json:"name"`
+    // Query - параметры GET /restaurants, например tier=2,3&sort=-price
+    Query string `json:"query"`
+}
+
+// DefaultCity возвращает город по умолчанию; пустая строка - не задан
+func (p Preferences) DefaultCity() string {
+    return p[PreferenceDefaultCity]
+}
+
+// PriceTier возвращает предпочитаемый уровень цен; false - не задан
+func (p Preferences) PriceTier() (PriceTier, bool) {
+    value, ok := p[PreferencePriceTier]
+    if !ok {
+        return 0, false
+    }
+    tier, err := ParsePriceTier(value)
+    return tier, err == nil
+}
+
+// SavedSearches возвращает сохраненные поиски в порядке, в котором их записали
+func (p Preferences) SavedSearches() []SavedSearch {
+    searches, _ := parseSavedSearches(p[PreferenceSavedSearches])
+    return searches
+}
+
+// parseSavedSearches разбирает значение PreferenceSavedSearches и проверяет параметры каждого поиска
+func parseSavedSearches(value string) ([]SavedSearch, error) {
+    if value == "" {
+        return nil, nil
+    }
+    var searches []SavedSearch
+    if err := json.Unmarshal([]byte(value), &searches); err != nil {
+        return nil, fmt.Errorf("must be a JSON array of {\"name\", \"query\"}: %v", err)
+    }
+    for i, search := range searches {
+        if strings.TrimSpace(search.Name) == "" {
+            return nil, fmt.Errorf("search %d: name is required", i)
+        }
+        if err := checkRestaurantQuery(search.Query); err != nil {
+            return nil, fmt.Errorf("search %q: %v", search.Name, err)
+        }
+    }
+    return searches, nil
+}
+
+// checkRestaurantQuery проверяет параметры GET /restaurants так же, как serveRestaurants
+func checkRestaurantQuery(query string) error {
+    values, err := url.ParseQuery(query)
+    if err != nil {
+        return err
+    }
+    var filter RestaurantFilter
+    for key, value := range values {
+        switch key {
+        case "cursor", "size", "total":
+        case "sort":
+            for _, field := range strings.Split(value[0], ",") {
+                name := strings.TrimPrefix(field, "-")
+                if _, ok := restaurantSortColumns[RestaurantSortField(name)]; !ok {
+                    return fmt.Errorf("sort field %q is unknown", name)
+                }
+            }
+        default:
+            if err := filter.set(key, value[0]); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// preferenceCheckers проверяют значения известных настроек
+var preferenceCheckers = map[string]func(value string) error{
+    PreferenceDefaultCity: func(value string) error {
+        if strings.TrimSpace(value) == "" {
+            return fmt.Errorf("must not be empty")
+        }
+        return nil
+    },
+    PreferencePriceTier: func(value string) error {
+        _, err := ParsePriceTier(value)
+        return err
+    },
+    PreferenceSavedSearches: func(value string) error {
+        _, err := parseSavedSearches(value)
+        return err
+    },
+}
+
+// checkPreference проверяет имя настройки, длину значения и значение известной настройки
+func checkPreference(name, value string) error {
+    if !preferenceNamePattern.MatchString(name) {
+        return &ValidationError{Field: "name", Message: "must start with a lowercase letter and contain only lowercase letters, digits, _, . and -, at most 64 characters"}
+    }
+    if utf8.RuneCountInString(value) > maxPreferenceLength {
+        return &ValidationError{Field: name, Message: fmt.Sprintf("must be at most %d characters", maxPreferenceLength)}
+    }
+    if check, ok := preferenceCheckers[name]; ok {
+        if err := check(value); err != nil {
+            return &ValidationError{Field: name, Message: err.Error()}
+        }
+    }
+    return nil
+}
+
+// SetPreference записывает настройку пользователя, заменяя прежнее значение. Значения известных настроек
+// проверяются (см. checkPreference); пользователь, которого нет на площадке, - ErrForeignKeyViolation
+func (db *Database) SetPreference(userID int, name, value string) error {
+    key := fmt.Sprintf("%d/%s", userID, name)
+    if err := checkPreference(name, value); err != nil {
+        return db.opError("set", "preference", key, err)
+    }
+    err := db.InTx(func(tx *Database) error {
+        user, err := tx.findUser("users.select_by_id", userID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if user == nil {
+            return fmt.Errorf("user %d does not exist: %w", userID, ErrForeignKeyViolation)
+        }
+        now := tx.now().UTC()
+        result, err := tx.execNamed("user_preferences.update", value, now, userID, name, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if n, err := result.RowsAffected(); err == nil && n > 0 {
+            return nil
+        }
+        _, err = tx.execNamed("user_preferences.insert", tx.tenant, userID, name, value, now)
+        return err
+    })
+    return db.opError("set", "preference", key, err)
+}
+
+// SetDefaultCity записывает город по умолчанию
+func (db *Database) SetDefaultCity(userID int, city string) error {
+    return db.SetPreference(userID, PreferenceDefaultCity, strings.TrimSpace(city))
+}
+
+// SetPreferredPriceTier записывает предпочитаемый уровень цен числом
+func (db *Database) SetPreferredPriceTier(userID int, tier PriceTier) error {
+    return db.SetPreference(userID, PreferencePriceTier, strconv.Itoa(int(tier)))
+}
+
+// SetSavedSearches заменяет сохраненные поиски пользователя; пустой список удаляет настройку
+func (db *Database) SetSavedSearches(userID int, searches []SavedSearch) error {
+    if len(searches) == 0 {
+        err := db.DeletePreference(userID, PreferenceSavedSearches)
+        if errors.Is(err, ErrNotFound) {
+            return nil
+        }
+        return err
+    }
+    data, err := json.Marshal(searches)
+    if err != nil {
+        return db.opError("set", "preference", fmt.Sprintf("%d/%s", userID, PreferenceSavedSearches), err)
+    }
+    return db.SetPreference(userID, PreferenceSavedSearches, string(data))
+}
+
+// DeletePreference удаляет настройку пользователя; ErrNotFound, если ее нет
+func (db *Database) DeletePreference(userID int, name string) error {
+    result, err := db.execNamed("user_preferences.delete", userID, name, db.tenant)
+    if err == nil {
+        if n, rowsErr := result.RowsAffected(); rowsErr == nil && n == 0 {
+            err = ErrNotFound
+        }
+    }
+    return db.opError("delete", "preference", fmt.Sprintf("%d/%s", userID, name), err)
+}
+
+// GetPreferences возвращает все настройки пользователя; у пользователя без настроек - пустые Preferences
+func (db *Database) GetPreferences(userID int) (Preferences, error) {
+    rows, err := db.queryNamed("user_preferences.select_by_user", userID, db.tenant)
+    if err != nil {
+        return nil, db.opError("get", "preferences", userID, err)
+    }
+    defer rows.Close()
+
+    preferences := make(Preferences)
+    for rows.Next() {
+        var name, value string
+        if err := rows.Scan(&name, &value); err != nil {
+            return nil, db.opError("get", "preferences", userID, err)
+        }
+        preferences[name] = value
+    }
+    return preferences, db.opError("get", "preferences", userID, rows.Err())
+}
+
+// preferenceBody - тело PUT /users/{id}/preferences/{name}
+type preferenceBody struct {
+    Value string `json:"value"`
+}
+
+// servePreferences отдает настройки пользователя (GET), записывает (PUT) или удаляет (DELETE) одну
+// из них и отвечает всеми настройками. Настройки видит и меняет только сам пользователь или администратор
+func (db *Database) servePreferences(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeUser(actor, userID); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    if _, err := db.GetUserByID(userID); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    switch r.Method {
+    case http.MethodPut:
+        var body preferenceBody
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        err = db.SetPreference(userID, r.PathValue("name"), body.Value)
+    case http.MethodDelete:
+        err = db.DeletePreference(userID, r.PathValue("name"))
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    preferences, err := db.GetPreferences(userID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, preferences)
+}
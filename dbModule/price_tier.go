@@ -0,0 +1,87 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// PriceTier - уровень цен ресторана, от дешевого к дорогому. Это не сумма денег: средний чек
+// в валюте хранится отдельно (см. Restaurant.Price). В базе и в JSON уровень - число от 1 до 5,
+// при разборе принимается и имя уровня (см. ParsePriceTier)
+type PriceTier int
+
+// Уровни цен
+const (
+    PriceTierBudget PriceTier = iota + 1
+    PriceTierModerate
+    PriceTierUpscale
+    PriceTierPremium
+    PriceTierLuxury
+)
+
+// priceTierNames - имена уровней цен по порядку, начиная с PriceTierBudget
+var priceTierNames = []string{"budget", "moderate", "upscale", "premium", "luxury"}
+
+// Valid сообщает, что уровень - один из объявленных
+func (t PriceTier) Valid() bool {
+    return t >= PriceTierBudget && t <= PriceTierLuxury
+}
+
+// String возвращает имя уровня; у необъявленного - число
+func (t PriceTier) String() string {
+    if !t.Valid() {
+        return strconv.Itoa(int(t))
+    }
+    return priceTierNames[t-PriceTierBudget]
+}
+
+// ParsePriceTier разбирает уровень цен: число от 1 до 5 или имя (budget, moderate, upscale,
+// premium, luxury) в любом регистре
+func ParsePriceTier(value string) (PriceTier, error) {
+    value = strings.TrimSpace(value)
+    for i, name := range priceTierNames {
+        if strings.EqualFold(value, name) {
+            return PriceTierBudget + PriceTier(i), nil
+        }
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil || !PriceTier(n).Valid() {
+        return 0, fmt.Errorf("price tier %q: expected 1 to 5 or %s", value, strings.Join(priceTierNames, ", "))
+    }
+    return PriceTier(n), nil
+}
+
+// parsePriceTiers разбирает уровни цен через запятую, как их принимает фильтр tier
+func parsePriceTiers(value string) ([]PriceTier, error) {
+    var tiers []PriceTier
+    for _, part := range strings.Split(value, ",") {
+        tier, err := ParsePriceTier(part)
+        if err != nil {
+            return nil, err
+        }
+        tiers = append(tiers, tier)
+    }
+    return tiers, nil
+}
+
+// UnmarshalJSON принимает уровень числом или именем: 2 и "moderate" - один уровень. Число вне
+// диапазона сохраняется как есть, чтобы проверка записи назвала поле (см. RegisterInvariant)
+func (t *PriceTier) UnmarshalJSON(data []byte) error {
+    var name string
+    if err := json.Unmarshal(data, &name); err != nil {
+        var n int
+        if err := json.Unmarshal(data, &n); err != nil {
+            return fmt.Errorf("price tier must be a number from 1 to 5 or one of %s", strings.Join(priceTierNames, ", "))
+        }
+        *t = PriceTier(n)
+        return nil
+    }
+    tier, err := ParsePriceTier(name)
+    if err != nil {
+        return err
+    }
+    *t = tier
+    return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "dbModule/httpapi"
+)
+
+// WriteQueueReadyLimit - доля заполнения очереди записи (см. writeQueue), с которой /readyz
+// снимает сервер с балансировки: новые записи уже почти наверняка будут ждать места в очереди
+var WriteQueueReadyLimit = 0.9
+
+// ProbeHandler возвращает пробы Kubernetes, которые обслуживаются без аутентификации и ограничения частоты:
+//   GET /healthz - живость: основная база отвечает (см. HealthCheck)
+//   GET /readyz - готовность принимать запросы: база отвечает, все миграции применены
+//     и очередь записи заполнена меньше чем на WriteQueueReadyLimit
+// Ответ - httpapi.ProbeResult с результатом каждой проверки, 503 - проверка не прошла
+func (db *Database) ProbeHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.Handle("GET /healthz", httpapi.Probe(0, db.databaseCheck()))
+    mux.Handle("GET /readyz", httpapi.Probe(0, db.databaseCheck(), db.migrationsCheck(), db.writeQueueCheck()))
+    return mux
+}
+
+// databaseCheck проверяет, что основная база отвечает; реплики и автомат отключения попадают в подробности
+func (db *Database) databaseCheck() httpapi.Check {
+    return httpapi.Check{Name: "database", Run: func(ctx context.Context) (string, error) {
+        health, err := db.HealthCheck(ctx)
+        return fmt.Sprintf("%s, circuit %s", health.Status, health.Circuit.State), err
+    }}
+}
+
+// migrationsCheck проверяет, что схема базы не отстает от миграций конфигурации
+func (db *Database) migrationsCheck() httpapi.Check {
+    return httpapi.Check{Name: "migrations", Run: func(ctx context.Context) (string, error) {
+        pending, err := db.WithRequestContext(ctx).PendingMigrations()
+        if err != nil {
+            return "", err
+        }
+        if len(pending) > 0 {
+            return "", fmt.Errorf("%d pending: %s", len(pending), strings.Join(pending, ", "))
+        }
+        return "up to date", nil
+    }}
+}
+
+// writeQueueCheck проверяет, что в очереди записи есть место (см. WriteQueueReadyLimit)
+func (db *Database) writeQueueCheck() httpapi.Check {
+    return httpapi.Check{Name: "write_queue", Run: func(ctx context.Context) (string, error) {
+        waiting, capacity := db.writes.depth()
+        if capacity == 0 {
+            return "disabled", nil
+        }
+        detail := fmt.Sprintf("%d of %d writes waiting", waiting, capacity)
+        if float64(waiting) >= WriteQueueReadyLimit*float64(capacity) {
+            return detail, fmt.Errorf("write queue is %d%% full", waiting*100/capacity)
+        }
+        return detail, nil
+    }}
+}
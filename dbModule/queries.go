@@ -0,0 +1,473 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+
+    "gopkg.in/yaml.v2"
+)
+
+// ErrQueryNotFound возвращается, если запрос с таким именем не зарегистрирован
+var ErrQueryNotFound = errors.New("query not found")
+
+// QueryRegistry хранит именованные SQL-запросы с пространствами имен (users.insert, restaurants.select).
+// Безопасен для одновременного использования: ReloadQueries заменяет запросы на ходу
+type QueryRegistry struct {
+    // mu защищает запросы от замены при перезагрузке (см. replace)
+    mu      sync.RWMutex
+    queries map[string]string
+    // deprecated содержит причину вывода из употребления для устаревших запросов
+    deprecated map[string]string
+    // reports - описания отчетов из reports.yaml по имени без пространства имен (см. RunReport)
+    reports map[string]*Report
+    // strict - реестр загружен с QueryLoadOptions.Strict: ошибки файлов копятся в problems,
+    // а sources хранит файл каждого запроса для сообщений о повторах
+    strict   bool
+    sources  map[string]string
+    problems []string
+}
+
+// QueryLoadOptions настраивают LoadQueriesWithOptions
+type QueryLoadOptions struct {
+    // Strict - файлы запросов разбираются строго: повторный ключ в файле, неизвестное поле отчета,
+    // пустой запрос (ключ без SQL) и запрос, объявленный в нескольких файлах, - ошибки, и загрузка
+    // завершается списком всех таких ключей сразу. Без Strict повторный ключ файла молча заменяет
+    // прежний, а ключ без SQL дает пустой запрос или не регистрирует ничего
+    Strict bool
+}
+
+// NewQueryRegistry создает пустой реестр запросов
+func NewQueryRegistry() *QueryRegistry {
+    return &QueryRegistry{queries: make(map[string]string), deprecated: make(map[string]string), reports: make(map[string]*Report)}
+}
+
+// queryDefinition - значение запроса в YAML: либо строка с SQL, либо объект
+// {sql: ..., deprecated: <причина>} для запросов, которые выводятся из употребления.
+// В объекте можно задать и тексты для отдельных диалектов: {sql: ..., postgres: ..., mysql: ...}
+// регистрирует общий запрос name и варианты name@postgres, name@mysql (см. lookupQuery).
+// Без sql запрос есть только у перечисленных диалектов
+type queryDefinition struct {
+    SQL        string `yaml:"sql"`
+    Deprecated string `yaml:"deprecated"`
+    // Variants - тексты запроса по именам диалектов (см. dialectNames)
+    Variants map[string]string `yaml:",inline"`
+}
+
+// UnmarshalYAML принимает обе формы записи запроса
+func (d *queryDefinition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    if err := unmarshal(&d.SQL); err == nil {
+        return nil
+    }
+    type plain queryDefinition
+    if err := unmarshal((*plain)(d)); err != nil {
+        return err
+    }
+    for dialect := range d.Variants {
+        if !isDialectName(dialect) {
+            return fmt.Errorf("query definition: unknown dialect %q (expected one of %s)", dialect, strings.Join(dialectNames, ", "))
+        }
+    }
+    if d.SQL == "" && len(d.Variants) == 0 {
+        return fmt.Errorf("query definition without sql")
+    }
+    return nil
+}
+
+// LoadQueries загружает SQL-запросы из YAML файла или из всех YAML файлов каталога.
+// Имя файла без расширения становится пространством имен: insert из users.yaml → users.insert.
+// ${VAR} в файлах заменяются переменными окружения (см. expandVariables). Файл reports.yaml
+// описывает отчеты с параметрами и колонками (см. Report). Связанные запросы можно разнести по
+// файлам подкаталога и подключить через include (см. LoadFile)
+func LoadQueries(path string) (*QueryRegistry, error) {
+    return LoadQueriesWithOptions(path, QueryLoadOptions{})
+}
+
+// LoadQueriesWithOptions загружает запросы, как LoadQueries, с настройками options
+func LoadQueriesWithOptions(path string, options QueryLoadOptions) (*QueryRegistry, error) {
+    registry := NewQueryRegistry()
+    registry.strict = options.Strict
+
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+
+    files := []string{path}
+    if info.IsDir() {
+        files, err = queryFiles(path)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    for _, file := range files {
+        if err := registry.LoadFile(file); err != nil {
+            return nil, err
+        }
+    }
+    if len(registry.problems) > 0 {
+        sort.Strings(registry.problems)
+        return nil, fmt.Errorf("strict queries %s: %w: %s", path, ErrValidation, strings.Join(registry.problems, "; "))
+    }
+    return registry, nil
+}
+
+// unmarshalYAML разбирает файл запросов filename в value: в строгом реестре - yaml.UnmarshalStrict,
+// и его ошибки (повторные ключи, неизвестные поля) копятся в problems, а value заполняется остальными ключами
+func (r *QueryRegistry) unmarshalYAML(filename string, data []byte, value interface{}) error {
+    if !r.strict {
+        return yaml.Unmarshal(data, value)
+    }
+    err := yaml.UnmarshalStrict(data, value)
+    var typeErr *yaml.TypeError
+    if errors.As(err, &typeErr) {
+        for _, problem := range typeErr.Errors {
+            r.problems = append(r.problems, filename+": "+problem)
+        }
+        return nil
+    }
+    return err
+}
+
+// queryFiles возвращает отсортированный список YAML файлов каталога
+func queryFiles(dir string) ([]string, error) {
+    var files []string
+    for _, pattern := range []string{"*.yaml", "*.yml"} {
+        matches, err := filepath.Glob(filepath.Join(dir, pattern))
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, matches...)
+    }
+    sort.Strings(files)
+    return files, nil
+}
+
+// LoadFile добавляет в реестр запросы из одного YAML файла. Ключ include подключает другие файлы
+// в то же пространство имен (см. queryIncludes), а вложенная группа запросов добавляет к именам свой
+// ключ: admin: {select: ...} в users.yaml → users.admin.select (см. queryEntry)
+func (r *QueryRegistry) LoadFile(filename string) error {
+    namespace := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+    return r.loadFile(filename, namespace, nil)
+}
+
+// loadFile загружает файл в пространство имен namespace; chain - цепочка файлов, подключивших его
+// через include, по ней обнаруживаются циклы
+func (r *QueryRegistry) loadFile(filename, namespace string, chain []string) error {
+    path, err := filepath.Abs(filename)
+    if err != nil {
+        return err
+    }
+    for i, included := range chain {
+        if included == path {
+            return fmt.Errorf("include cycle: %s", strings.Join(append(chain[i:], path), " -> "))
+        }
+    }
+    chain = append(chain, path)
+
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return err
+    }
+    data, err = expandVariables(data)
+    if err != nil {
+        return fmt.Errorf("%s: %v", filename, err)
+    }
+    expanded := data
+    data, includes, err := splitIncludes(data)
+    if err != nil {
+        return fmt.Errorf("%s: %v", filename, err)
+    }
+
+    if namespace+"." == reportNamespace {
+        if err := r.loadReports(filename, data); err != nil {
+            return fmt.Errorf("%s: %v", filename, err)
+        }
+    } else {
+        var queries map[string]queryEntry
+        if r.strict {
+            // строгий разбор идет по файлу с include, чтобы номера строк в ошибках совпадали с ним
+            var file struct {
+                Include queryIncludes         `yaml:"include"`
+                Queries map[string]queryEntry `yaml:",inline"`
+            }
+            err = r.unmarshalYAML(filename, expanded, &file)
+            queries = file.Queries
+        } else {
+            err = r.unmarshalYAML(filename, data, &queries)
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %v", filename, err)
+        }
+        if err := r.addEntries(filename, namespace, queries); err != nil {
+            return fmt.Errorf("%s: %v", filename, err)
+        }
+    }
+
+    for _, pattern := range includes {
+        if !filepath.IsAbs(pattern) {
+            pattern = filepath.Join(filepath.Dir(filename), pattern)
+        }
+        files, err := filepath.Glob(pattern)
+        if err != nil {
+            return fmt.Errorf("%s: include %s: %v", filename, pattern, err)
+        }
+        if len(files) == 0 {
+            return fmt.Errorf("%s: include %s: no such files", filename, pattern)
+        }
+        sort.Strings(files)
+        for _, file := range files {
+            if err := r.loadFile(file, namespace, chain); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// addEntries регистрирует запросы и вложенные группы файла filename под префиксом prefix
+func (r *QueryRegistry) addEntries(filename, prefix string, entries map[string]queryEntry) error {
+    for key, entry := range entries {
+        name := prefix + "." + key
+        if entry.group != nil {
+            if strings.Contains(key, "@") {
+                return fmt.Errorf("query group %s: a dialect variant must be a query", name)
+            }
+            if err := r.addEntries(filename, name, entry.group); err != nil {
+                return err
+            }
+            continue
+        }
+        if len(entry.query.Variants) > 0 && strings.Contains(key, "@") {
+            return fmt.Errorf("query %s: a dialect variant cannot have its own dialect variants", name)
+        }
+        if r.strict && entry.query.SQL == "" && len(entry.query.Variants) == 0 {
+            r.problems = append(r.problems, fmt.Sprintf("%s: query %s is empty", filename, name))
+            continue
+        }
+        names := make([]string, 0, len(entry.query.Variants)+1)
+        if entry.query.SQL != "" {
+            if err := r.add(filename, name, entry.query.SQL); err != nil {
+                return err
+            }
+            names = append(names, name)
+        }
+        for dialect, query := range entry.query.Variants {
+            if err := r.add(filename, name+"@"+dialect, query); err != nil {
+                return err
+            }
+            names = append(names, name+"@"+dialect)
+        }
+        if entry.query.Deprecated != "" {
+            for _, name := range names {
+                r.Deprecate(name, entry.query.Deprecated)
+            }
+        }
+    }
+    return nil
+}
+
+// queryEntry - значение ключа в файле запросов: запрос (см. queryDefinition) или группа запросов,
+// связанных между собой. Объект с ключом sql, deprecated или именем диалекта - запрос, любой другой
+// объект - группа
+type queryEntry struct {
+    query queryDefinition
+    group map[string]queryEntry
+}
+
+// UnmarshalYAML отличает группу от запроса
+func (e *queryEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var fields map[string]interface{}
+    if err := unmarshal(&fields); err == nil {
+        isQuery := false
+        for key := range fields {
+            isQuery = isQuery || key == "sql" || key == "deprecated" || isDialectName(key)
+        }
+        if !isQuery {
+            return unmarshal(&e.group)
+        }
+    }
+    return unmarshal(&e.query)
+}
+
+// queryIncludes - ключ include файла запросов: путь или список путей к файлам, запросы которых
+// добавляются в пространство имен подключающего файла. Пути отсчитываются от его каталога
+// и могут быть шаблонами (users/*.yaml); подключаемые файлы кладут в подкаталоги, иначе
+// LoadQueries загрузит их еще раз как самостоятельные
+type queryIncludes []string
+
+// UnmarshalYAML принимает и один путь, и список
+func (i *queryIncludes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var single string
+    if err := unmarshal(&single); err == nil {
+        *i = queryIncludes{single}
+        return nil
+    }
+    return unmarshal((*[]string)(i))
+}
+
+// splitIncludes отделяет ключ include от остального содержимого файла запросов
+func splitIncludes(data []byte) ([]byte, queryIncludes, error) {
+    var file yaml.MapSlice
+    // повторные ключи остаются в MapSlice и находятся строгим разбором оставшегося файла
+    if err := yaml.Unmarshal(data, &file); err != nil {
+        return nil, nil, err
+    }
+    for i, item := range file {
+        if item.Key != "include" {
+            continue
+        }
+        raw, err := yaml.Marshal(item.Value)
+        if err != nil {
+            return nil, nil, err
+        }
+        var includes queryIncludes
+        if err := yaml.Unmarshal(raw, &includes); err != nil {
+            return nil, nil, fmt.Errorf("include: %v", err)
+        }
+        rest, err := yaml.Marshal(append(file[:i:i], file[i+1:]...))
+        return rest, includes, err
+    }
+    return data, nil, nil
+}
+
+// Add регистрирует запрос под полным именем; повторная регистрация считается ошибкой
+func (r *QueryRegistry) Add(name, query string) error {
+    return r.add("", name, query)
+}
+
+// add регистрирует запрос из файла filename. Строгий реестр не прерывается на повторе,
+// а записывает в problems оба файла, где объявлен запрос
+func (r *QueryRegistry) add(filename, name, query string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if _, exists := r.queries[name]; exists {
+        if !r.strict {
+            return fmt.Errorf("duplicate query %q", name)
+        }
+        r.problems = append(r.problems, fmt.Sprintf("%s: query %s is already declared in %s", filename, name, r.sources[name]))
+        return nil
+    }
+    r.queries[name] = query
+    if r.strict {
+        if r.sources == nil {
+            r.sources = make(map[string]string)
+        }
+        r.sources[name] = filename
+    }
+    return nil
+}
+
+// Deprecate помечает запрос устаревшим; reason подсказывает, чем его заменить
+func (r *QueryRegistry) Deprecate(name, reason string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.deprecated[name] = reason
+}
+
+// Deprecated возвращает причину, если запрос помечен устаревшим
+func (r *QueryRegistry) Deprecated(name string) (string, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    reason, ok := r.deprecated[name]
+    return reason, ok
+}
+
+// DeprecatedNames возвращает отсортированный список устаревших запросов
+func (r *QueryRegistry) DeprecatedNames() []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    names := make([]string, 0, len(r.deprecated))
+    for name := range r.deprecated {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// tablePrefixPlaceholder отмечает в запросах место префикса перед именами таблиц, индексов,
+// представлений и триггеров: FROM {{prefix}}users (см. Config.TablePrefix)
+const tablePrefixPlaceholder = "{{prefix}}"
+
+// tablePrefixPattern - допустимый префикс: его можно подставить в имя без кавычек во всех диалектах
+var tablePrefixPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// withTablePrefix возвращает копию реестра, в запросах которой {{prefix}} заменен на prefix
+func (r *QueryRegistry) withTablePrefix(prefix string) *QueryRegistry {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    prefixed := NewQueryRegistry()
+    prefixed.strict = r.strict
+    for name, query := range r.queries {
+        prefixed.queries[name] = strings.ReplaceAll(query, tablePrefixPlaceholder, prefix)
+    }
+    for name, reason := range r.deprecated {
+        prefixed.deprecated[name] = reason
+    }
+    for name, report := range r.reports {
+        prefixed.reports[name] = report
+    }
+    return prefixed
+}
+
+// Get возвращает запрос по полному имени
+func (r *QueryRegistry) Get(name string) (string, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    query, ok := r.queries[name]
+    if !ok {
+        return "", fmt.Errorf("%w: %s", ErrQueryNotFound, name)
+    }
+    return query, nil
+}
+
+// Has проверяет, зарегистрирован ли запрос
+func (r *QueryRegistry) Has(name string) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    _, ok := r.queries[name]
+    return ok
+}
+
+// Dialects возвращает отсортированные диалекты, для которых у запроса name есть варианты name@<диалект>
+func (r *QueryRegistry) Dialects(name string) []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    var dialects []string
+    for registered := range r.queries {
+        if base, dialect, ok := strings.Cut(registered, "@"); ok && base == name {
+            dialects = append(dialects, dialect)
+        }
+    }
+    sort.Strings(dialects)
+    return dialects
+}
+
+// Names возвращает отсортированный список имен всех запросов
+func (r *QueryRegistry) Names() []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    names := make([]string, 0, len(r.queries))
+    for name := range r.queries {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// replace заменяет все запросы, пометки устаревания и отчеты реестра содержимым from одним шагом:
+// конкурентные читатели видят либо прежний набор, либо новый, но не их смесь
+func (r *QueryRegistry) replace(from *QueryRegistry) {
+    from.mu.RLock()
+    defer from.mu.RUnlock()
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.queries, r.deprecated, r.reports = from.queries, from.deprecated, from.reports
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+    "fmt"
+    "maps"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// queryCacheDependencies - какие сущности читает каждый кешируемый список: изменение сущности
+// через этот процесс (см. audit) сбрасывает списки, которые от нее зависят
+var queryCacheDependencies = map[string][]string{
+    "users.select":                {"user"},
+    "restaurants.select":          {"restaurant"},
+    "restaurants.select_join":     {"user", "restaurant"},
+    "users.select_filtered":       {"user"},
+    "restaurants.select_filtered": {"restaurant", "category", "restaurant_category", "restaurant_hours"},
+    "reviews.select_filtered":     {"review"},
+}
+
+// queryCacheKey - список одной площадки в кеше; args - параметры выборки (фильтр и страница)
+type queryCacheKey struct {
+    name   string
+    tenant int
+    args   string
+}
+
+// queryCacheEntry - закешированный список
+type queryCacheEntry struct {
+    value   interface{}
+    loaded  time.Time
+    expires time.Time
+}
+
+// queryCache - кеш результатов списков (SelectUsers, SelectRestaurants, SelectJoin и страницы UsersPage,
+// RestaurantsPage, ReviewsPage, ListingsPage), общий для всех копий Database. TTL задается отдельно для каждого запроса;
+// списки сбрасываются при изменении их сущностей через этот процесс, изменения из других процессов
+// видны по истечении TTL. Истекший список хранится еще stale (см. SetQueryCacheStale) и отдается,
+// если база недоступна. Чтения сессии, которая уже писала, и чтения с WithFreshReads идут мимо кеша
+type queryCache struct {
+    mu      sync.Mutex
+    ttls    map[string]time.Duration
+    stale   map[string]time.Duration
+    entries map[queryCacheKey]queryCacheEntry
+    // generation растет при каждом сбросе, как у entityCache
+    generation uint64
+}
+
+// SetQueryCache включает кеш результатов запроса name на ttl или выключает его (ttl == 0).
+// Кешировать можно только списки из queryCacheDependencies
+func (db *Database) SetQueryCache(name string, ttl time.Duration) error {
+    if _, ok := queryCacheDependencies[name]; !ok {
+        return fmt.Errorf("query %q cannot be cached (cacheable: %s)", name, strings.Join(cacheableQueries(), ", "))
+    }
+    c := db.results
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.ttls == nil {
+        c.ttls = make(map[string]time.Duration)
+    }
+    c.ttls[name] = ttl
+    c.dropLocked(name)
+    return nil
+}
+
+// SetQueryCacheStale разрешает отдавать истекший результат запроса name еще maxStale после
+// истечения TTL, если при обновлении база недоступна (см. isUnavailableError); 0 - не отдавать.
+// Обновление по-прежнему выполняется при каждом чтении истекшего результата, так что после
+// восстановления базы списки сразу свежие. Кеш запроса включается отдельно через SetQueryCache
+func (db *Database) SetQueryCacheStale(name string, maxStale time.Duration) error {
+    if _, ok := queryCacheDependencies[name]; !ok {
+        return fmt.Errorf("query %q cannot be cached (cacheable: %s)", name, strings.Join(cacheableQueries(), ", "))
+    }
+    c := db.results
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.stale == nil {
+        c.stale = make(map[string]time.Duration)
+    }
+    c.stale[name] = maxStale
+    return nil
+}
+
+// InvalidateQueryCache сбрасывает закешированные результаты запросов names, без names - все
+func (db *Database) InvalidateQueryCache(names ...string) {
+    c := db.results
+    if c == nil {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if len(names) == 0 {
+        c.entries = nil
+        c.generation++
+        return
+    }
+    for _, name := range names {
+        c.dropLocked(name)
+    }
+}
+
+// cacheableQueries возвращает имена запросов, которые можно кешировать
+func cacheableQueries() []string {
+    names := make([]string, 0, len(queryCacheDependencies))
+    for name := range queryCacheDependencies {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// cachedQuery возвращает список name из кеша или загружает его через load. Возвращается копия,
+// чтобы изменения вызывающего не попали в кеш, с пределом строк копии Database (см. SetMaxRows).
+// Внутри транзакции кеш не используется
+func cachedQuery[T any](db *Database, name string, load func() ([]T, error)) ([]T, error) {
+    items, err := cachedValue(db, name, "", load, slices.Clone[[]T])
+    if err != nil {
+        return items, err
+    }
+    return limitRows(db, name, items)
+}
+
+// cachedPage возвращает страницу запроса name с параметрами args из кеша или загружает ее через load
+func cachedPage[T any](db *Database, name, args string, load func() (Page[T], error)) (Page[T], error) {
+    return cachedValue(db, name, args, load, func(page Page[T]) Page[T] {
+        page.Items = slices.Clone(page.Items)
+        page.Filters = maps.Clone(page.Filters)
+        return page
+    })
+}
+
+// cachedValue - общая часть cachedQuery и cachedPage: clone копирует значение на входе в кеш и на выходе.
+// Если load не смог прочитать истекшее значение из-за недоступности базы, а срок SetQueryCacheStale
+// еще не прошел, возвращается истекшее значение, и чтение отмечается в StaleReads копии Database
+func cachedValue[T any](db *Database, name, args string, load func() (T, error), clone func(T) T) (T, error) {
+    c := db.results
+    if c == nil || db.tx != nil || db.freshReads() {
+        return load()
+    }
+    key := queryCacheKey{name: name, tenant: db.tenant, args: args}
+
+    c.mu.Lock()
+    ttl, maxStale := c.ttls[name], c.stale[name]
+    entry, ok := c.entries[key]
+    generation := c.generation
+    c.mu.Unlock()
+    if ttl <= 0 {
+        return load()
+    }
+    now := db.now()
+    if ok && now.Before(entry.expires) {
+        return clone(entry.value.(T)), nil
+    }
+
+    value, err := load()
+    if err != nil {
+        if ok && now.Before(entry.expires.Add(maxStale)) && isUnavailableError(err) {
+            db.staleReads.add(StaleRead{Query: name, Age: now.Sub(entry.loaded), Err: err})
+            return clone(entry.value.(T)), nil
+        }
+        return value, err
+    }
+    c.put(key, clone(value), ttl, generation, now)
+    return value, nil
+}
+
+// StaleRead - список, отданный из кеша устаревшим, потому что база была недоступна
+type StaleRead struct {
+    Query string
+    // Age - сколько прошло с выборки отданного значения
+    Age time.Duration
+    // Err - ошибка, с которой не удалось обновить значение
+    Err error
+}
+
+// StaleReads собирает устаревшие чтения копии Database (см. WithStaleReads); безопасен
+// для одновременного использования
+type StaleReads struct {
+    mu    sync.Mutex
+    reads []StaleRead
+}
+
+// Reads возвращает устаревшие чтения в порядке их выполнения
+func (s *StaleReads) Reads() []StaleRead {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]StaleRead(nil), s.reads...)
+}
+
+// MaxAge возвращает возраст самого старого отданного значения; 0 - устаревших чтений не было
+func (s *StaleReads) MaxAge() time.Duration {
+    var age time.Duration
+    for _, read := range s.Reads() {
+        age = max(age, read.Age)
+    }
+    return age
+}
+
+// add отмечает устаревшее чтение; у копии Database без StaleReads ничего не делает
+func (s *StaleReads) add(read StaleRead) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.reads = append(s.reads, read)
+}
+
+// WithStaleReads возвращает копию Database, устаревшие чтения из кеша которой записываются в reads,
+// чтобы отметить ответ как устаревший. Без него устаревшие значения отдаются без отметки
+func (db *Database) WithStaleReads(reads *StaleReads) *Database {
+    scoped := *db
+    scoped.staleReads = reads
+    return &scoped
+}
+
+// put кладет значение в кеш, если с начала его выборки (now) ничего не сбрасывалось, и удаляет
+// значения, которые уже нельзя отдать даже устаревшими
+func (c *queryCache) put(key queryCacheKey, value interface{}, ttl time.Duration, generation uint64, now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if generation != c.generation {
+        return
+    }
+    if c.entries == nil {
+        c.entries = make(map[queryCacheKey]queryCacheEntry)
+    }
+    for old, entry := range c.entries {
+        if !now.Before(entry.expires.Add(c.stale[old.name])) {
+            delete(c.entries, old)
+        }
+    }
+    c.entries[key] = queryCacheEntry{value: value, loaded: now, expires: now.Add(ttl)}
+}
+
+// invalidateEntities сбрасывает списки, которые читают измененные сущности
+func (c *queryCache) invalidateEntities(keys ...entityKey) {
+    if c == nil {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for name, entities := range queryCacheDependencies {
+        for _, key := range keys {
+            if slices.Contains(entities, key.entity) {
+                c.dropLocked(name)
+                break
+            }
+        }
+    }
+}
+
+// dropLocked сбрасывает все площадки запроса name; c.mu должен быть захвачен
+func (c *queryCache) dropLocked(name string) {
+    c.generation++
+    for key := range c.entries {
+        if key.name == name {
+            delete(c.entries, key)
+        }
+    }
+}
+
+// parseQueryCache разбирает значение -query-cache и -query-cache-stale: пары name=длительность
+// через запятую, например "restaurants.select=10s,users.select=1m"
+func parseQueryCache(value string) (map[string]time.Duration, error) {
+    ttls := make(map[string]time.Duration)
+    for _, part := range strings.Split(value, ",") {
+        if part = strings.TrimSpace(part); part == "" {
+            continue
+        }
+        name, ttl, ok := strings.Cut(part, "=")
+        if !ok {
+            return nil, fmt.Errorf("query cache %q: want name=ttl", part)
+        }
+        duration, err := time.ParseDuration(ttl)
+        if err != nil {
+            return nil, fmt.Errorf("query cache %q: %v", part, err)
+        }
+        ttls[name] = duration
+    }
+    return ttls, nil
+}
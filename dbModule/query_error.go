@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "regexp"
+    "syscall"
+)
+
+// QueryError - ошибка именованного запроса с контекстом для разбора: имя запроса, аргументы
+// со скрытыми секретами, вид операции и подсказка, что делать. Достается через errors.As;
+// errors.Is по-прежнему видит исходную ошибку драйвера и виды ошибок модуля.
+// Аргументы не входят в текст ошибки, чтобы его можно было показать клиенту API
+type QueryError struct {
+    Query string
+    // Args - аргументы в виде для лога (см. formatArgs): Secret скрыты, двоичные данные заменены размером
+    Args string
+    // Op - read, write или migration
+    Op string
+    // Hint - подсказка по исправлению; пустая, если причина не распознана
+    Hint string
+    Err  error
+}
+
+func (e *QueryError) Error() string {
+    if e.Hint == "" {
+        return fmt.Sprintf("%s: %v", e.Query, e.Err)
+    }
+    return fmt.Sprintf("%s: %v (hint: %s)", e.Query, e.Err, e.Hint)
+}
+
+// Unwrap возвращает исходную ошибку для errors.Is и errors.As
+func (e *QueryError) Unwrap() error {
+    return e.Err
+}
+
+// queryErrorHints сопоставляет тексты ошибок драйверов с подсказками. Тексты у СУБД разные,
+// поэтому у каждой подсказки сообщения SQLite, PostgreSQL, SQL Server, Oracle и MySQL
+var queryErrorHints = []struct {
+    pattern *regexp.Regexp
+    hint    string
+}{
+    {regexp.MustCompile(`(?i)no such table|relation "[^"]+" does not exist|invalid object name|ORA-00942|table '[^']+' doesn't exist`), "run migrations: table is missing"},
+    {regexp.MustCompile(`(?i)no such column|column "[^"]+"( of relation "[^"]+")? does not exist|invalid column name|ORA-00904|unknown column`), "run migrations: column is missing"},
+    {regexp.MustCompile(`(?i)database is locked|database table is locked|SQLITE_BUSY`), "another connection holds the write lock: keep -write-queue enabled or raise -busy-timeout"},
+    {regexp.MustCompile(`(?i)readonly database|read-only`), "the database is read-only: check file permissions or the replica role"},
+    {regexp.MustCompile(`(?i)syntax error|incorrect syntax|ORA-00933`), "check the query text in the queries directory for this dialect"},
+}
+
+// queryError оборачивает ошибку запроса name в *QueryError; nil и уже обернутые ошибки возвращаются как есть
+func (db *Database) queryError(name string, args []interface{}, op operation, err error) error {
+    var queryErr *QueryError
+    if err == nil || errors.As(err, &queryErr) {
+        return err
+    }
+    return &QueryError{Query: name, Args: formatArgs(args), Op: op.String(), Hint: db.queryErrorHint(err), Err: err}
+}
+
+// queryErrorHint подбирает подсказку по виду ошибки
+func (db *Database) queryErrorHint(err error) string {
+    switch {
+    case errors.Is(err, ErrQueryNotFound):
+        return "the query is not defined in the loaded queries directory"
+    case errors.Is(err, ErrColumnMismatch):
+        return "the query's SELECT list and the struct's db tags disagree: add the column to the query or the tag to the struct"
+    case errors.Is(err, context.DeadlineExceeded):
+        return "the query hit its timeout: add an index or raise -read-timeout, -write-timeout or -migration-timeout"
+    case errors.Is(err, context.Canceled):
+        return "the read was canceled together with its request, e.g. the HTTP client disconnected"
+    case db.driver.isUniqueError(err):
+        return "a row with the same unique key already exists"
+    case db.driver.isForeignKeyError(err):
+        return "the referenced row does not exist or the row is still referenced"
+    }
+    for _, h := range queryErrorHints {
+        if h.pattern.MatchString(err.Error()) {
+            return h.hint
+        }
+    }
+    return ""
+}
+
+// unavailablePattern - тексты ошибок драйверов о недоступной базе, а не о неверном запросе
+var unavailablePattern = regexp.MustCompile(`(?i)sql: database is closed|unable to open database|connection refused|connection reset|broken pipe|bad connection|no such host|server closed the connection|the database system is (starting up|shutting down)|ORA-03113|ORA-03114|ORA-12541|ORA-12514`)
+
+// isUnavailableError проверяет, что запрос не выполнен из-за недоступности базы: нет соединения,
+// сеть или таймаут. По таким ошибкам кеш списков может отдать устаревший результат (см. SetQueryCacheStale)
+func isUnavailableError(err error) bool {
+    var netErr net.Error
+    switch {
+    case err == nil, errors.Is(err, ErrClosed):
+        return false
+    case errors.Is(err, ErrCircuitOpen), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, context.DeadlineExceeded),
+        errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+        errors.As(err, &netErr):
+        return true
+    }
+    return unavailablePattern.MatchString(err.Error())
+}
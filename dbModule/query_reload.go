@@ -0,0 +1,205 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// QueryReload - итог перезагрузки запросов: какие запросы появились, изменились и пропали
+type QueryReload struct {
+    Added   []string
+    Changed []string
+    Removed []string
+}
+
+// Empty сообщает, что перезагрузка не изменила ни одного запроса
+func (r QueryReload) Empty() bool {
+    return len(r.Added) == 0 && len(r.Changed) == 0 && len(r.Removed) == 0
+}
+
+func (r QueryReload) String() string {
+    return fmt.Sprintf("%d added, %d changed, %d removed", len(r.Added), len(r.Changed), len(r.Removed))
+}
+
+// queryReloadHooks - обработчики OnQueriesReloaded, общие для всех копий Database
+type queryReloadHooks struct {
+    mu    sync.Mutex
+    hooks []func(QueryReload)
+}
+
+// reloadOnlyWithRestart - пространства имен, запросы которых применяются к схеме при запуске
+// (миграции, представления, триггеры): их изменение на ходу ничего бы не изменило в базе
+var reloadOnlyWithRestart = []string{schemaNamespace, viewsNamespace, triggersNamespace}
+
+// OnQueriesReloaded добавляет обработчик, который вызывается после каждой перезагрузки запросов,
+// изменившей хотя бы один запрос, например чтобы сбросить свои кеши или записать событие.
+// Обработчики вызываются по порядку добавления в горутине перезагрузки
+func (db *Database) OnQueriesReloaded(hook func(QueryReload)) {
+    db.reloadHooks.mu.Lock()
+    defer db.reloadHooks.mu.Unlock()
+    db.reloadHooks.hooks = append(db.reloadHooks.hooks, hook)
+}
+
+// ReloadQueries перечитывает запросы из path (файла или каталога, как LoadQueries), проверяет их
+// и заменяет ими запросы базы для всех ее копий одним шагом. Проверка: ни один запрос не пропал
+// (код на него ссылается), миграции, представления и триггеры не менялись (они применяются только
+// при запуске), а каждый новый и измененный запрос текущего диалекта готовится СУБД без ошибок.
+// При любой ошибке остаются прежние запросы. После замены сбрасываются подготовленные запросы
+// с прежним текстом и кеш списков (см. SetQueryCache) и вызываются обработчики OnQueriesReloaded
+func (db *Database) ReloadQueries(path string) (QueryReload, error) {
+    fresh, err := LoadQueriesWithOptions(path, QueryLoadOptions{Strict: db.queries.strict})
+    if err != nil {
+        return QueryReload{}, fmt.Errorf("reload queries: %w", err)
+    }
+    fresh = fresh.withTablePrefix(db.tablePrefix)
+
+    var reload QueryReload
+    var problems, previous []string
+    for _, name := range db.queries.Names() {
+        old, _ := db.queries.Get(name)
+        query, err := fresh.Get(name)
+        switch {
+        case err != nil:
+            reload.Removed = append(reload.Removed, name)
+            problems = append(problems, name+" was removed")
+        case query != old:
+            reload.Changed = append(reload.Changed, name)
+            previous = append(previous, db.driver.dialect.Rebind(old))
+        }
+    }
+    for _, name := range fresh.Names() {
+        if !db.queries.Has(name) {
+            reload.Added = append(reload.Added, name)
+        }
+    }
+    for _, name := range append(append([]string(nil), reload.Added...), reload.Changed...) {
+        if problem := db.checkReloadedQuery(fresh, name); problem != "" {
+            problems = append(problems, problem)
+        }
+    }
+    if len(problems) > 0 {
+        sort.Strings(problems)
+        return reload, fmt.Errorf("reload queries from %s: %w: %s", path, ErrValidation, strings.Join(problems, "; "))
+    }
+    if reload.Empty() {
+        return reload, nil
+    }
+
+    db.queries.replace(fresh)
+    for _, query := range previous {
+        db.statements.remove(query)
+    }
+    db.InvalidateQueryCache()
+
+    db.reloadHooks.mu.Lock()
+    hooks := append([]func(QueryReload){}, db.reloadHooks.hooks...)
+    db.reloadHooks.mu.Unlock()
+    for _, hook := range hooks {
+        hook(reload)
+    }
+    return reload, nil
+}
+
+// checkReloadedQuery проверяет новый или измененный запрос name реестра fresh и возвращает
+// описание проблемы или "". Запросы других диалектов и отчеты (их параметры :name заменяются
+// только при выполнении) не готовятся
+func (db *Database) checkReloadedQuery(fresh *QueryRegistry, name string) string {
+    for _, namespace := range reloadOnlyWithRestart {
+        if strings.HasPrefix(name, namespace) {
+            return name + " changed, but " + strings.TrimSuffix(namespace, ".") + " queries are applied only at startup"
+        }
+    }
+    base, dialect, hasDialect := strings.Cut(name, "@")
+    if strings.HasPrefix(name, reportNamespace) || (hasDialect && dialect != db.driver.dialect.Name()) {
+        return ""
+    }
+    if !hasDialect && fresh.Has(base+"@"+db.driver.dialect.Name()) {
+        // выполняется вариант диалекта, он проверяется сам
+        return ""
+    }
+    query, _ := fresh.Get(name)
+    stmt, err := db.DB.Prepare(db.driver.dialect.Rebind(query))
+    if err != nil {
+        return fmt.Sprintf("%s: %v", name, err)
+    }
+    stmt.Close()
+    return ""
+}
+
+// WatchQueries проверяет файлы запросов в path раз в interval и при изменении перезагружает
+// запросы (см. ReloadQueries). Ошибки перезагрузки пишутся в лог, запросы остаются прежними
+// до следующего изменения файлов. Работает до отмены ctx; interval <= 0 выключает наблюдение
+func (db *Database) WatchQueries(ctx context.Context, path string, interval time.Duration) {
+    if interval <= 0 {
+        return
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    seen, err := queryFilesState(path)
+    if err != nil {
+        log.Printf("query reload: %v", err)
+    }
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            state, err := queryFilesState(path)
+            if err != nil {
+                log.Printf("query reload: %v", err)
+                continue
+            }
+            if state == seen {
+                continue
+            }
+            seen = state
+            reload, err := db.ReloadQueries(path)
+            if err != nil {
+                log.Printf("query reload: %v; keeping the previous queries", err)
+                continue
+            }
+            log.Printf("query reload: %s", reload)
+        }
+    }
+}
+
+// queryFilesState возвращает отпечаток файлов запросов path: имена, размеры и время изменения.
+// Отпечаток меняется, когда файл добавлен, удален или записан. У каталога учитываются и YAML файлы
+// подкаталогов - там лежат файлы, подключаемые через include (см. LoadFile)
+func queryFilesState(path string) (string, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return "", err
+    }
+    files := []string{path}
+    if info.IsDir() {
+        files = nil
+        err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+            if err == nil && !entry.IsDir() && (filepath.Ext(file) == ".yaml" || filepath.Ext(file) == ".yml") {
+                files = append(files, file)
+            }
+            return err
+        })
+        if err != nil {
+            return "", err
+        }
+    }
+    var state strings.Builder
+    for _, file := range files {
+        info, err := os.Stat(file)
+        if err != nil {
+            return "", err
+        }
+        fmt.Fprintf(&state, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+    }
+    return state.String(), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "math/rand"
+    "strings"
+    "time"
+)
+
+// queryRows оборачивает sql.Rows, чтобы посчитать прочитанные строки
+// и записать статистику запроса при закрытии
+type queryRows struct {
+    *sql.Rows
+    db      *Database
+    name    string
+    query   string
+    args    []interface{}
+    started time.Time
+    count   int64
+    closed  bool
+    // done отмечает окончание запроса для Close базы
+    done func()
+    // ctx ограничивает запрос таймаутом op; cancel освобождает его при закрытии курсора
+    ctx    context.Context
+    cancel context.CancelFunc
+    op     operation
+}
+
+// Next переходит к следующей строке и учитывает ее в статистике
+func (r *queryRows) Next() bool {
+    if r.Rows.Next() {
+        r.count++
+        return true
+    }
+    return false
+}
+
+// Close закрывает курсор и один раз записывает статистику запроса и отмечает его окончание
+func (r *queryRows) Close() error {
+    err := r.Rows.Close()
+    if !r.closed {
+        r.closed = true
+        r.db.sampleQuery(r.name, r.query, time.Since(r.started), r.count)
+        r.cancel()
+        r.done()
+    }
+    return err
+}
+
+// Err возвращает ошибку чтения строк, в том числе истекший таймаут запроса, как *QueryError
+func (r *queryRows) Err() error {
+    return r.db.queryError(r.name, r.args, r.op, r.db.timeoutError(r.ctx, r.name, r.op, r.Rows.Err()))
+}
+
+// QueryStat - строка отчета о самых затратных именованных запросах за день.
+// Оценки пересчитываются с учетом доли выборки, с которой строки были записаны
+type QueryStat struct {
+    Day            string
+    Name           string
+    Samples        int
+    EstimatedCalls float64
+    EstimatedTime  time.Duration
+    AverageTime    time.Duration
+    AverageRows    float64
+}
+
+// SetQuerySampleRate задает долю запросов (от 0 до 1), которые записываются в query_stats
+func (db *Database) SetQuerySampleRate(rate float64) error {
+    if rate < 0 || rate > 1 {
+        return fmt.Errorf("query sample rate must be between 0 and 1, got %v", rate)
+    }
+    db.sampleRate = rate
+    return nil
+}
+
+// sampleQuery с вероятностью sampleRate записывает время и число строк запроса.
+// Ошибки записи только логируются, чтобы статистика не ломала основной запрос
+func (db *Database) sampleQuery(name, query string, elapsed time.Duration, rows int64) {
+    if db.sampleRate <= 0 || rand.Float64() >= db.sampleRate {
+        return
+    }
+
+    insert, err := db.lookupQuery("query_stats.insert")
+    if err == nil {
+        _, err = db.conn().ExecContext(context.Background(), db.driver.dialect.Rebind(insert), name, queryShape(query), elapsed.Microseconds(), rows, db.sampleRate)
+    }
+    if err != nil {
+        log.Printf("Error sampling query %s: %v", name, err)
+    }
+}
+
+// queryShape приводит текст запроса к виду, удобному для группировки: схлопывает пробелы
+func queryShape(query string) string {
+    return strings.Join(strings.Fields(query), " ")
+}
+
+// TopQueriesByDay возвращает для каждого из последних days дней top самых затратных запросов
+func (db *Database) TopQueriesByDay(days, top int) ([]QueryStat, error) {
+    rows, err := db.queryNamed("query_stats.top_by_day", days, top)
+    if err != nil {
+        return nil, db.opError("report", "query stats", nil, err)
+    }
+    defer rows.Close()
+
+    var stats []QueryStat
+    for rows.Next() {
+        var stat QueryStat
+        var estimatedUs, averageUs float64
+        if err := rows.Scan(&stat.Day, &stat.Name, &stat.Samples, &stat.EstimatedCalls, &estimatedUs, &averageUs, &stat.AverageRows); err != nil {
+            return nil, db.opError("report", "query stats", nil, err)
+        }
+        stat.EstimatedTime = time.Duration(estimatedUs) * time.Microsecond
+        stat.AverageTime = time.Duration(averageUs) * time.Microsecond
+        stats = append(stats, stat)
+    }
+    return stats, db.opError("report", "query stats", nil, rows.Err())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "strings"
+)
+
+// defaultRebuildBatchSize - сколько строк по rowid копируется одним INSERT при пересборке таблицы
+const defaultRebuildBatchSize = 1000
+
+// TableRebuild описывает пересборку таблицы SQLite, которую нельзя изменить через ALTER TABLE
+// (смена типа или ограничений колонки): новая таблица создается рядом, заполняется копией строк
+// и занимает место старой. Таблица должна быть с rowid (не WITHOUT ROWID)
+type TableRebuild struct {
+    // Table - имя таблицы без префикса таблиц (см. Config.TablePrefix)
+    Table string
+    // Create - CREATE TABLE новой схемы для таблицы с временным именем Table + "_new"; имена таблиц
+    // в нем пишутся с {{prefix}}, как в файлах запросов
+    Create string
+    // Columns - колонки новой таблицы, заполняемые из старой
+    Columns []string
+    // Select - выражения над строками старой таблицы для каждой из Columns, например
+    // "CAST(average_price AS REAL)"; nil - колонки с теми же именами
+    Select []string
+    // BatchSize - строк в одном INSERT при копировании; 0 - defaultRebuildBatchSize
+    BatchSize int
+}
+
+// RebuildTable пересобирает таблицу SQLite вне миграций. Внешние ключи на время пересборки
+// выключаются на выделенном соединении, иначе DROP старой таблицы выполнил бы ON DELETE CASCADE
+// у ссылающихся на нее таблиц. Все шаги идут в одной транзакции: читатели до фиксации видят
+// старую таблицу, а при ошибке база остается прежней
+func (db *Database) RebuildTable(rebuild TableRebuild) error {
+    if err := db.requireSQLite("table rebuild"); err != nil {
+        return err
+    }
+
+    done, err := db.beginOperation()
+    if err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    defer done()
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    defer conn.Close()
+
+    if err := db.execOn(ctx, conn, "rebuild.foreign_keys_off"); err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    // без таймаута: после истекшего ctx соединение вернулось бы в пул с выключенными внешними ключами
+    defer db.execOn(context.Background(), conn, "rebuild.foreign_keys_on")
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    defer tx.Rollback()
+
+    if err := db.rebuildTable(ctx, tx, rebuild); err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    if err := tx.Commit(); err != nil {
+        return db.opError("rebuild", "table", rebuild.Table, err)
+    }
+    db.clearEntityCache()
+    return nil
+}
+
+// rebuildTable выполняет пересборку в транзакции tx по шагам из документации SQLite
+// (https://www.sqlite.org/lang_altertable.html#otheralter): внешние ключи уже должны быть выключены
+func (db *Database) rebuildTable(ctx context.Context, tx *sql.Tx, rebuild TableRebuild) error {
+    rebuild.Table = db.table(rebuild.Table)
+    rebuild.Create = strings.ReplaceAll(rebuild.Create, tablePrefixPlaceholder, db.tablePrefix)
+
+    query, err := db.lookupQuery("rebuild.foreign_keys")
+    if err != nil {
+        return err
+    }
+    var foreignKeys bool
+    if err := tx.QueryRowContext(ctx, query).Scan(&foreignKeys); err != nil {
+        return err
+    }
+    if foreignKeys {
+        return fmt.Errorf("rebuild %s: foreign keys must be off, use RebuildTable or a Rebuild migration", rebuild.Table)
+    }
+
+    objects, err := db.selectObjects(ctx, tx, "rebuild.table_objects", rebuild.Table)
+    if err != nil {
+        return err
+    }
+
+    create := rebuild.Create
+    if db.strict {
+        if create, err = strictSchema(create); err != nil {
+            return fmt.Errorf("rebuild %s: %w", rebuild.Table, err)
+        }
+    }
+    if _, err := tx.ExecContext(ctx, create); err != nil {
+        return fmt.Errorf("rebuild %s: create new table: %w", rebuild.Table, err)
+    }
+
+    copied, err := copyTableRows(ctx, tx, rebuild)
+    if err != nil {
+        return fmt.Errorf("rebuild %s: copy rows: %w", rebuild.Table, err)
+    }
+    var rows int64
+    if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s;", quoteIdentifier(rebuild.Table))).Scan(&rows); err != nil {
+        return err
+    }
+    if copied != rows {
+        return fmt.Errorf("rebuild %s: copied %d of %d rows", rebuild.Table, copied, rows)
+    }
+
+    table, newTable := quoteIdentifier(rebuild.Table), quoteIdentifier(rebuild.Table+"_new")
+    if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s;", table)); err != nil {
+        return fmt.Errorf("rebuild %s: drop old table: %w", rebuild.Table, err)
+    }
+    if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", newTable, table)); err != nil {
+        return fmt.Errorf("rebuild %s: rename new table: %w", rebuild.Table, err)
+    }
+
+    for _, object := range objects {
+        if _, err := tx.ExecContext(ctx, object.sql); err != nil {
+            return fmt.Errorf("rebuild %s: create %s %s: %w", rebuild.Table, object.kind, object.name, err)
+        }
+    }
+    return db.checkForeignKeys(ctx, tx)
+}
+
+// copyTableRows переносит строки старой таблицы в новую диапазонами rowid по BatchSize
+// и возвращает число скопированных строк
+func copyTableRows(ctx context.Context, tx *sql.Tx, rebuild TableRebuild) (int64, error) {
+    batch := int64(rebuild.BatchSize)
+    if batch <= 0 {
+        batch = defaultRebuildBatchSize
+    }
+    selects := rebuild.Select
+    if selects == nil {
+        selects = rebuild.Columns
+    }
+    if len(selects) != len(rebuild.Columns) || len(selects) == 0 {
+        return 0, fmt.Errorf("%d select expressions for %d columns", len(selects), len(rebuild.Columns))
+    }
+
+    columns := make([]string, len(rebuild.Columns))
+    for i, column := range rebuild.Columns {
+        columns[i] = quoteIdentifier(column)
+    }
+    table := quoteIdentifier(rebuild.Table)
+    insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE rowid BETWEEN ? AND ?;",
+        quoteIdentifier(rebuild.Table+"_new"), strings.Join(columns, ", "), strings.Join(selects, ", "), table)
+
+    var first, last sql.NullInt64
+    if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT MIN(rowid), MAX(rowid) FROM %s;", table)).Scan(&first, &last); err != nil {
+        return 0, err
+    }
+
+    var copied int64
+    for from := first.Int64; first.Valid && from <= last.Int64; from += batch {
+        result, err := tx.ExecContext(ctx, insert, from, from+batch-1)
+        if err != nil {
+            return copied, err
+        }
+        affected, err := result.RowsAffected()
+        if err != nil {
+            return copied, err
+        }
+        copied += affected
+    }
+    return copied, nil
+}
+
+// checkForeignKeys завершается ошибкой, если какая-либо строка ссылается на несуществующую
+func (db *Database) checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+    query, err := db.lookupQuery("rebuild.foreign_key_check")
+    if err != nil {
+        return err
+    }
+    rows, err := tx.QueryContext(ctx, query)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        var table, parent string
+        var rowID, index sql.NullInt64
+        if err := rows.Scan(&table, &rowID, &parent, &index); err != nil {
+            return err
+        }
+        return fmt.Errorf("foreign key check: %s row %d references a missing %s row: %w", table, rowID.Int64, parent, ErrForeignKeyViolation)
+    }
+    return rows.Err()
+}
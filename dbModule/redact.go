@@ -0,0 +1,159 @@
+package main
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// redacted заменяет секретные значения в выводе и логах
+const redacted = "[REDACTED]"
+
+// Secret - аргумент запроса, который передается драйверу как есть,
+// но при печати и в логах запросов выводится как [REDACTED]
+type Secret string
+
+// Value передает драйверу настоящее значение
+func (s Secret) Value() (driver.Value, error) { return string(s), nil }
+
+// String скрывает значение при печати через fmt
+func (s Secret) String() string { return redacted }
+
+// GoString скрывает значение и при печати через %#v
+func (s Secret) GoString() string { return redacted }
+
+// MarshalJSON скрывает значение в JSON
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(redacted) }
+
+// PublicUser - представление пользователя для других пользователей (например, поклонников ресторана):
+// без пароля и телефона; из профиля видны только аватар и текст "о себе", а дата рождения и язык - нет
+type PublicUser struct {
+    ID        int     `json:"id" yaml:"id"`
+    Name      string  `json:"name" yaml:"name"`
+    Lastname  string  `json:"lastname" yaml:"lastname"`
+    Email     string  `json:"email" yaml:"email"`
+    Version   int     `json:"version" yaml:"version"`
+    TenantID  int     `json:"tenant_id" yaml:"tenant_id"`
+    Role      string  `json:"role" yaml:"role"`
+    PublicID  string  `json:"public_id,omitempty" yaml:"public_id,omitempty"`
+    AvatarURL *string `json:"avatar_url" yaml:"avatar_url"`
+    Bio       *string `json:"bio" yaml:"bio"`
+}
+
+// AdminUser - полное представление пользователя для него самого, администраторов, выгрузок
+// и журнала аудита: все поля, кроме пароля, который не выводится никогда
+type AdminUser struct {
+    ID        int     `json:"id" yaml:"id"`
+    Name      string  `json:"name" yaml:"name"`
+    Lastname  string  `json:"lastname" yaml:"lastname"`
+    Email     string  `json:"email" yaml:"email"`
+    Phone     *string `json:"phone" yaml:"phone"`
+    Version   int     `json:"version" yaml:"version"`
+    TenantID  int     `json:"tenant_id" yaml:"tenant_id"`
+    Role      string  `json:"role" yaml:"role"`
+    PublicID  string  `json:"public_id,omitempty" yaml:"public_id,omitempty"`
+    AvatarURL *string `json:"avatar_url" yaml:"avatar_url"`
+    Bio       *string `json:"bio" yaml:"bio"`
+    Birthdate *string `json:"birthdate" yaml:"birthdate"`
+    Locale    *string `json:"locale" yaml:"locale"`
+}
+
+// Public возвращает пользователя без пароля, телефона, даты рождения и языка
+func (u User) Public() PublicUser {
+    return PublicUser{ID: u.ID, Name: u.Name, Lastname: u.Lastname, Email: u.Email, Version: u.Version, TenantID: u.TenantID, Role: u.Role, PublicID: u.PublicID,
+        AvatarURL: u.AvatarURL, Bio: u.Bio}
+}
+
+// Admin возвращает пользователя со всеми полями, кроме пароля
+func (u User) Admin() AdminUser {
+    return AdminUser{ID: u.ID, Name: u.Name, Lastname: u.Lastname, Email: u.Email, Phone: u.Phone, Version: u.Version, TenantID: u.TenantID, Role: u.Role, PublicID: u.PublicID,
+        AvatarURL: u.AvatarURL, Bio: u.Bio, Birthdate: u.Birthdate, Locale: u.Locale}
+}
+
+// String печатает пользователя со скрытым паролем и замаскированным телефоном,
+// чтобы %v и %+v было безопасно писать в логи
+func (u User) String() string {
+    password := ""
+    if u.Password != "" {
+        password = redacted
+    }
+    phone := "<nil>"
+    if u.Phone != nil {
+        phone = maskPhone(*u.Phone)
+    }
+    return fmt.Sprintf("{ID:%d Name:%s Lastname:%s Password:%s Email:%s Phone:%s Version:%d TenantID:%d Role:%s}",
+        u.ID, u.Name, u.Lastname, password, u.Email, phone, u.Version, u.TenantID, u.Role)
+}
+
+// MarshalJSON сериализует пользователя в представлении AdminUser, чтобы пароль не попал в JSON,
+// даже если пользователя передали в json.Marshal напрямую. Ответы API выбирают представление явно
+func (u User) MarshalJSON() ([]byte, error) {
+    return json.Marshal(u.Admin())
+}
+
+// MarshalYAML сериализует пользователя в YAML так же, как MarshalJSON
+func (u User) MarshalYAML() (interface{}, error) {
+    return u.Admin(), nil
+}
+
+// maskPhone заменяет цифры телефона звездочками, кроме двух последних
+func maskPhone(phone string) string {
+    digits := 0
+    for _, r := range phone {
+        if r >= '0' && r <= '9' {
+            digits++
+        }
+    }
+
+    var b strings.Builder
+    for _, r := range phone {
+        if r >= '0' && r <= '9' {
+            digits--
+            if digits >= 2 {
+                r = '*'
+            }
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
+
+// formatArgs печатает аргументы запроса для лога: Secret скрываются, двоичные данные заменяются размером
+func formatArgs(args []interface{}) string {
+    parts := make([]string, len(args))
+    for i, arg := range args {
+        switch v := arg.(type) {
+        case Secret:
+            parts[i] = redacted
+        case []byte:
+            parts[i] = fmt.Sprintf("<%d bytes>", len(v))
+        case string:
+            parts[i] = fmt.Sprintf("%q", v)
+        default:
+            parts[i] = fmt.Sprintf("%v", v)
+        }
+    }
+    return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// SetQueryLog включает лог выполненных запросов с аргументами; nil выключает его.
+// Секреты, переданные как Secret (например, пароли), в лог не попадают
+func (db *Database) SetQueryLog(logger *log.Logger) {
+    db.queryLog = logger
+}
+
+// logQuery пишет выполненный запрос в лог запросов, если он включен SetQueryLog; строка начинается
+// с идентификатора корреляции в квадратных скобках, если он есть (см. WithCorrelationID)
+func (db *Database) logQuery(name string, args []interface{}, elapsed time.Duration, err error) {
+    if db.queryLog == nil {
+        return
+    }
+    if err != nil {
+        db.queryLog.Printf("%s%s %s %v: %v", db.correlationPrefix(), name, formatArgs(args), elapsed, err)
+        return
+    }
+    db.queryLog.Printf("%s%s %s %v", db.correlationPrefix(), name, formatArgs(args), elapsed)
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+    "cmp"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+)
+
+// reportNamespace - пространство имен отчетов: их SQL регистрируется как reports.<имя>
+const reportNamespace = "reports."
+
+// reportTenantParam - параметр, который подставляется в каждый отчет сам: площадка базы
+const reportTenantParam = "tenant_id"
+
+// Типы параметров и колонок отчетов
+const (
+    ReportInt    = "int"
+    ReportFloat  = "float"
+    ReportString = "string"
+    ReportBool   = "bool"
+    ReportDate   = "date"
+    ReportTime   = "time"
+)
+
+// Report - отчет из reports.yaml: SELECT с именованными параметрами :name и объявленными типами
+// параметров и колонок. Отчеты добавляются без изменений кода и выполняются RunReport
+type Report struct {
+    Name        string         `json:"name" yaml:"-"`
+    Description string         `json:"description,omitempty" yaml:"description"`
+    Params      []ReportParam  `json:"params" yaml:"params"`
+    Columns     []ReportColumn `json:"columns" yaml:"columns"`
+}
+
+// ReportParam - параметр отчета. Параметр без Default обязателен, если не Optional. Values, Min, Max
+// и Pattern ограничивают значения: RunReport проверяет их до выполнения запроса
+type ReportParam struct {
+    Name        string      `json:"name" yaml:"name"`
+    Type        string      `json:"type" yaml:"type"`
+    Description string      `json:"description,omitempty" yaml:"description"`
+    Default     interface{} `json:"default,omitempty" yaml:"default"`
+    // Optional - пропущенный параметр без Default передается в запрос как NULL
+    Optional bool `json:"optional,omitempty" yaml:"optional"`
+    // Values - допустимые значения; пусто - любое значение типа
+    Values []interface{} `json:"values,omitempty" yaml:"values"`
+    // Min и Max - границы значений int, float, date и time включительно
+    Min interface{} `json:"min,omitempty" yaml:"min"`
+    Max interface{} `json:"max,omitempty" yaml:"max"`
+    // Pattern - регулярное выражение, которому строка должна соответствовать целиком
+    Pattern string `json:"pattern,omitempty" yaml:"pattern"`
+
+    pattern *regexp.Regexp
+}
+
+// ReportColumn - колонка результата отчета; значения приводятся к ее типу
+type ReportColumn struct {
+    Name string `json:"name" yaml:"name"`
+    Type string `json:"type" yaml:"type"`
+}
+
+// reportDefinition - отчет в reports.yaml. У вариантов для диалектов (name@postgres) задается
+// только sql - строкой или полем, параметры и колонки берутся из основного отчета
+type reportDefinition struct {
+    Report `yaml:",inline"`
+    SQL    string `yaml:"sql"`
+}
+
+// UnmarshalYAML принимает отчет объектом, а вариант для диалекта - и строкой с SQL
+func (d *reportDefinition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    if err := unmarshal(&d.SQL); err == nil {
+        return nil
+    }
+    type plain reportDefinition
+    return unmarshal((*plain)(d))
+}
+
+// reportParamPattern находит именованный параметр :name; :: (приведение типа PostgreSQL) не параметр
+var reportParamPattern = regexp.MustCompile(`^:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// loadReports регистрирует отчеты файла reports.yaml: SQL - как запросы reports.<имя>, описания - в r.reports
+func (r *QueryRegistry) loadReports(filename string, data []byte) error {
+    var definitions map[string]reportDefinition
+    if err := r.unmarshalYAML(filename, data, &definitions); err != nil {
+        return err
+    }
+
+    for key, definition := range definitions {
+        if definition.SQL == "" {
+            return fmt.Errorf("report %s without sql", key)
+        }
+        if err := r.add(filename, reportNamespace+key, definition.SQL); err != nil {
+            return err
+        }
+        if strings.Contains(key, "@") {
+            if len(definition.Params) > 0 || len(definition.Columns) > 0 {
+                return fmt.Errorf("report %s: a dialect variant takes only sql", key)
+            }
+            continue
+        }
+        report := definition.Report
+        report.Name = key
+        if err := report.check(); err != nil {
+            return fmt.Errorf("report %s: %v", key, err)
+        }
+        r.reports[key] = &report
+    }
+
+    // параметры каждого варианта должны быть объявлены в основном отчете
+    for key, definition := range definitions {
+        base, _, _ := strings.Cut(key, "@")
+        report, ok := r.reports[base]
+        if !ok {
+            return fmt.Errorf("report variant %s without report %s", key, base)
+        }
+        if err := report.checkSQL(definition.SQL); err != nil {
+            return fmt.Errorf("report %s: %v", key, err)
+        }
+    }
+    return nil
+}
+
+// check проверяет описание отчета: типы, имена и значения по умолчанию
+func (report *Report) check() error {
+    if len(report.Columns) == 0 {
+        return fmt.Errorf("no columns declared")
+    }
+    seen := make(map[string]bool)
+    for _, column := range report.Columns {
+        if !validReportType(column.Type) {
+            return fmt.Errorf("column %s: unknown type %q", column.Name, column.Type)
+        }
+        if column.Name == "" || seen[strings.ToLower(column.Name)] {
+            return fmt.Errorf("column %q is empty or declared twice", column.Name)
+        }
+        seen[strings.ToLower(column.Name)] = true
+    }
+
+    seen = map[string]bool{reportTenantParam: true}
+    for i, param := range report.Params {
+        if !validReportType(param.Type) {
+            return fmt.Errorf("param %s: unknown type %q", param.Name, param.Type)
+        }
+        if !reportParamPattern.MatchString(":"+param.Name) || seen[param.Name] {
+            return fmt.Errorf("param %q is not a valid name, is declared twice or is the reserved %s", param.Name, reportTenantParam)
+        }
+        seen[param.Name] = true
+        if err := report.Params[i].compile(); err != nil {
+            return fmt.Errorf("param %s: %v", param.Name, err)
+        }
+    }
+    return nil
+}
+
+// compile приводит значение по умолчанию и ограничения параметра к его типу и проверяет, что
+// значение по умолчанию им удовлетворяет
+func (param *ReportParam) compile() error {
+    for i, allowed := range param.Values {
+        value, err := reportValue(param.Type, allowed)
+        if err != nil {
+            return fmt.Errorf("values: %v", err)
+        }
+        param.Values[i] = value
+    }
+    for _, bound := range []*interface{}{&param.Min, &param.Max} {
+        if *bound == nil {
+            continue
+        }
+        switch param.Type {
+        case ReportInt, ReportFloat, ReportDate, ReportTime:
+        default:
+            return fmt.Errorf("min and max apply only to int, float, date and time")
+        }
+        value, err := reportValue(param.Type, *bound)
+        if err != nil {
+            return fmt.Errorf("min or max: %v", err)
+        }
+        *bound = value
+    }
+    if param.Min != nil && param.Max != nil && compareReportValues(param.Min, param.Max) > 0 {
+        return fmt.Errorf("min is greater than max")
+    }
+    if param.Pattern != "" {
+        if param.Type != ReportString {
+            return fmt.Errorf("pattern applies only to string")
+        }
+        pattern, err := regexp.Compile(`^(?:` + param.Pattern + `)$`)
+        if err != nil {
+            return fmt.Errorf("pattern: %v", err)
+        }
+        param.pattern = pattern
+    }
+    if param.Default != nil {
+        value, err := reportValue(param.Type, param.Default)
+        if err != nil {
+            return fmt.Errorf("default: %v", err)
+        }
+        if message := param.validate(value); message != "" {
+            return fmt.Errorf("default %s", message)
+        }
+        param.Default = value
+    }
+    return nil
+}
+
+// validate проверяет значение, уже приведенное к типу параметра, по Values, Min, Max и Pattern.
+// Возвращает сообщение о нарушении или пустую строку
+func (param *ReportParam) validate(value interface{}) string {
+    if len(param.Values) > 0 {
+        allowed := make([]string, len(param.Values))
+        for i, v := range param.Values {
+            if compareReportValues(value, v) == 0 {
+                return ""
+            }
+            allowed[i] = formatReportValue(v, param.Type)
+        }
+        return "must be one of " + strings.Join(allowed, ", ")
+    }
+    if param.Min != nil && compareReportValues(value, param.Min) < 0 {
+        return "must be at least " + formatReportValue(param.Min, param.Type)
+    }
+    if param.Max != nil && compareReportValues(value, param.Max) > 0 {
+        return "must be at most " + formatReportValue(param.Max, param.Type)
+    }
+    if text, ok := value.(string); ok && param.pattern != nil && !param.pattern.MatchString(text) {
+        return "must match " + param.Pattern
+    }
+    return ""
+}
+
+// compareReportValues сравнивает два значения одного типа отчета: -1, 0 или 1.
+// Булевы значения только равны или не равны
+func compareReportValues(a, b interface{}) int {
+    switch x := a.(type) {
+    case int64:
+        if y, ok := b.(int64); ok {
+            return cmp.Compare(x, y)
+        }
+    case float64:
+        if y, ok := b.(float64); ok {
+            return cmp.Compare(x, y)
+        }
+    case string:
+        if y, ok := b.(string); ok {
+            return strings.Compare(x, y)
+        }
+    case time.Time:
+        if y, ok := b.(time.Time); ok {
+            return x.Compare(y)
+        }
+    }
+    if a == b {
+        return 0
+    }
+    return 1
+}
+
+// checkSQL проверяет, что query - один SELECT и в нем только объявленные параметры
+func (report *Report) checkSQL(query string) error {
+    keyword := ""
+    if fields := strings.Fields(query); len(fields) > 0 {
+        keyword = strings.ToUpper(fields[0])
+    }
+    if keyword != "SELECT" && keyword != "WITH" {
+        return fmt.Errorf("sql must be a SELECT")
+    }
+    _, names := reportPlaceholders(query)
+    for _, name := range names {
+        if name != reportTenantParam && report.param(name) == nil {
+            return fmt.Errorf("sql uses undeclared param :%s", name)
+        }
+    }
+    return nil
+}
+
+// param возвращает параметр отчета по имени или nil
+func (report *Report) param(name string) *ReportParam {
+    for i := range report.Params {
+        if report.Params[i].Name == name {
+            return &report.Params[i]
+        }
+    }
+    return nil
+}
+
+// bind приводит значения параметров к объявленным типам, проверяет их ограничения и подставляет
+// значения по умолчанию. Неизвестные, пропущенные обязательные и неверные параметры возвращаются
+// все сразу в *InvariantError сущности "report", по нарушению на параметр
+func (report *Report) bind(params map[string]interface{}, tenant int) (map[string]interface{}, error) {
+    var violations []*ValidationError
+    var unknown []string
+    for name := range params {
+        if report.param(name) == nil {
+            unknown = append(unknown, name)
+        }
+    }
+    sort.Strings(unknown)
+    for _, name := range unknown {
+        violations = append(violations, &ValidationError{Field: name, Message: "is not a parameter of report " + report.Name})
+    }
+
+    values := map[string]interface{}{reportTenantParam: tenant}
+    for i := range report.Params {
+        param := &report.Params[i]
+        value, ok := params[param.Name]
+        switch {
+        case ok && value != nil:
+            converted, err := reportValue(param.Type, value)
+            if err != nil {
+                violations = append(violations, &ValidationError{Field: param.Name, Message: err.Error()})
+                continue
+            }
+            if message := param.validate(converted); message != "" {
+                violations = append(violations, &ValidationError{Field: param.Name, Message: message})
+                continue
+            }
+            values[param.Name] = converted
+        case param.Default != nil:
+            values[param.Name] = param.Default
+        case param.Optional:
+            values[param.Name] = nil
+        default:
+            violations = append(violations, &ValidationError{Field: param.Name, Message: "is required"})
+        }
+    }
+    if len(violations) > 0 {
+        return nil, &InvariantError{Entity: "report", Violations: violations}
+    }
+    return values, nil
+}
+
+// reportPlaceholders заменяет параметры :name запроса на ? и возвращает их имена по порядку.
+// Строки в кавычках и приведения типа :: не затрагиваются
+func reportPlaceholders(query string) (string, []string) {
+    var b strings.Builder
+    var names []string
+    var quote byte
+    for i := 0; i < len(query); i++ {
+        c := query[i]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '\'' || c == '"':
+            quote = c
+        case c == ':' && i+1 < len(query) && query[i+1] == ':':
+            b.WriteString("::")
+            i++
+            continue
+        case c == ':':
+            if match := reportParamPattern.FindStringSubmatch(query[i:]); match != nil {
+                names = append(names, match[1])
+                b.WriteByte('?')
+                i += len(match[0]) - 1
+                continue
+            }
+        }
+        b.WriteByte(c)
+    }
+    return b.String(), names
+}
+
+// validReportType проверяет тип параметра или колонки отчета
+func validReportType(t string) bool {
+    switch t {
+    case ReportInt, ReportFloat, ReportString, ReportBool, ReportDate, ReportTime:
+        return true
+    }
+    return false
+}
+
+// reportTimeLayouts - форматы времени в тексте: параметров и значений, которые драйвер отдал строкой
+var reportTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02"}
+
+// reportValue приводит значение параметра или колонки к типу отчета t. Строки разбираются,
+// так что параметры можно передать из командной строки или строки запроса HTTP
+func reportValue(t string, value interface{}) (interface{}, error) {
+    if raw, ok := value.([]byte); ok {
+        value = string(raw)
+    }
+    if value == nil {
+        return nil, nil
+    }
+    text, isText := value.(string)
+
+    switch t {
+    case ReportString:
+        if isText {
+            return text, nil
+        }
+        return fmt.Sprint(value), nil
+    case ReportInt:
+        switch v := value.(type) {
+        case int:
+            return int64(v), nil
+        case int64:
+            return v, nil
+        case float64:
+            if v == float64(int64(v)) {
+                return int64(v), nil
+            }
+        case json.Number:
+            if n, err := v.Int64(); err == nil {
+                return n, nil
+            }
+        case string:
+            if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+                return n, nil
+            }
+        }
+        return nil, fmt.Errorf("must be an integer")
+    case ReportFloat:
+        switch v := value.(type) {
+        case int:
+            return float64(v), nil
+        case int64:
+            return float64(v), nil
+        case float64:
+            return v, nil
+        case json.Number:
+            if f, err := v.Float64(); err == nil {
+                return f, nil
+            }
+        case string:
+            if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+                return f, nil
+            }
+        }
+        return nil, fmt.Errorf("must be a number")
+    case ReportBool:
+        switch v := value.(type) {
+        case bool:
+            return v, nil
+        case int64:
+            return v != 0, nil
+        case string:
+            if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+                return b, nil
+            }
+        }
+        return nil, fmt.Errorf("must be true or false")
+    case ReportDate, ReportTime:
+        if v, ok := value.(time.Time); ok {
+            return v, nil
+        }
+        if isText {
+            for _, layout := range reportTimeLayouts {
+                if parsed, err := time.Parse(layout, strings.TrimSpace(text)); err == nil {
+                    return parsed, nil
+                }
+            }
+        }
+        if t == ReportDate {
+            return nil, fmt.Errorf("must be a date, e.g. 2006-01-02")
+        }
+        return nil, fmt.Errorf("must be a time in RFC 3339, e.g. 2006-01-02T15:04:05Z")
+    }
+    return nil, fmt.Errorf("unknown type %q", t)
+}
+
+// Report возвращает описание отчета по имени
+func (r *QueryRegistry) Report(name string) (*Report, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    report, ok := r.reports[name]
+    return report, ok
+}
+
+// Reports возвращает описания всех отчетов по имени
+func (r *QueryRegistry) Reports() []Report {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    reports := make([]Report, 0, len(r.reports))
+    for _, report := range r.reports {
+        reports = append(reports, *report)
+    }
+    sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+    return reports
+}
+
+// reportNames возвращает имена отчетов
+func (db *Database) reportNames() []string {
+    var names []string
+    for _, report := range db.queries.Reports() {
+        names = append(names, report.Name)
+    }
+    return names
+}
+
+// RunReport выполняет отчет name из reports.yaml для площадки базы: params приводятся к объявленным
+// типам (строки разбираются) и проверяются по ограничениям параметров до обращения к базе,
+// :tenant_id подставляется сам. Возвращает строки по именам колонок со значениями, приведенными
+// к типам колонок, и сами колонки в объявленном порядке. Неизвестный отчет - ErrNotFound, неверные
+// параметры - *InvariantError со всеми нарушениями, а колонки результата, не совпадающие
+// с объявленными, - ошибка отчета, а не данных
+func (db *Database) RunReport(name string, params map[string]interface{}) ([]map[string]interface{}, []ReportColumn, error) {
+    report, ok := db.queries.Report(name)
+    if !ok {
+        return nil, nil, db.opError("run", "report", name, ErrNotFound)
+    }
+    values, err := report.bind(params, db.tenant)
+    if err != nil {
+        return nil, nil, db.opError("run", "report", name, err)
+    }
+
+    query, err := db.lookupQuery(reportNamespace + name)
+    if err != nil {
+        return nil, nil, db.opError("run", "report", name, err)
+    }
+    query, names := reportPlaceholders(query)
+    args := make([]interface{}, len(names))
+    for i, param := range names {
+        args[i] = values[param]
+    }
+
+    rows, err := db.queryText(reportNamespace+name, query, args...)
+    if err != nil {
+        return nil, nil, db.opError("run", "report", name, err)
+    }
+    defer rows.Close()
+
+    // Oracle отдает имена колонок заглавными, поэтому они сравниваются без учета регистра
+    columns, err := rows.Columns()
+    if err != nil {
+        return nil, nil, db.opError("run", "report", name, err)
+    }
+    declared := make([]ReportColumn, len(columns))
+    for i, column := range columns {
+        found := false
+        for _, c := range report.Columns {
+            if strings.EqualFold(c.Name, column) {
+                declared[i], found = c, true
+            }
+        }
+        if !found {
+            return nil, nil, db.opError("run", "report", name, fmt.Errorf("column %s is not declared in the report", column))
+        }
+    }
+    if len(columns) != len(report.Columns) {
+        return nil, nil, db.opError("run", "report", name, fmt.Errorf("query returns %d columns, %d declared", len(columns), len(report.Columns)))
+    }
+
+    raw := make([]interface{}, len(columns))
+    pointers := make([]interface{}, len(columns))
+    for i := range raw {
+        pointers[i] = &raw[i]
+    }
+    result := []map[string]interface{}{}
+    for rows.Next() {
+        if err := rows.Scan(pointers...); err != nil {
+            return nil, nil, db.opError("run", "report", name, err)
+        }
+        record := make(map[string]interface{}, len(columns))
+        for i, column := range declared {
+            value, err := reportValue(column.Type, raw[i])
+            if err != nil {
+                return nil, nil, db.opError("run", "report", name, fmt.Errorf("column %s: %v", column.Name, err))
+            }
+            record[column.Name] = value
+        }
+        result = append(result, record)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, nil, db.opError("run", "report", name, err)
+    }
+    return result, report.Columns, nil
+}
+
+// reportResult - ответ GET /reports/{name}
+type reportResult struct {
+    Columns []ReportColumn           `json:"columns"`
+    Rows    []map[string]interface{} `json:"rows"`
+}
+
+// serveReports отдает описания отчетов
+func (db *Database) serveReports(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, db.queries.Reports())
+}
+
+// serveReport выполняет отчет с параметрами из строки запроса. Неверные параметры перечисляются
+// в violations ответа 400, путь нарушения - имя параметра
+func (db *Database) serveReport(w http.ResponseWriter, r *http.Request) {
+    params := make(map[string]interface{})
+    for key, values := range r.URL.Query() {
+        params[key] = values[len(values)-1]
+    }
+    rows, columns, err := db.RunReport(r.PathValue("name"), params)
+    var invalid *InvariantError
+    if errors.As(err, &invalid) {
+        violations := make([]SchemaViolation, len(invalid.Violations))
+        for i, violation := range invalid.Violations {
+            violations[i] = SchemaViolation{Path: violation.Field, Message: violation.Message}
+        }
+        writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error(), Violations: violations})
+        return
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, reportResult{Columns: columns, Rows: rows})
+}
+
+// runReport выполняет команду report: report list печатает отчеты и их параметры,
+// report run <имя> [параметр=значение ...] - результат таблицей или JSON
+func runReport(db *Database, args []string) error {
+    if len(args) == 0 || args[0] != "list" && args[0] != "run" {
+        return fmt.Errorf("usage: report list | run [-format table|json] <name> [param=value ...]")
+    }
+    if args[0] == "list" {
+        for _, report := range db.queries.Reports() {
+            var params []string
+            for _, param := range report.Params {
+                params = append(params, param.Name+" "+param.Type)
+            }
+            fmt.Printf("%-24s (%s) %s\n", report.Name, strings.Join(params, ", "), report.Description)
+        }
+        return nil
+    }
+
+    flags := flag.NewFlagSet("report run", flag.ContinueOnError)
+    format := flags.String("format", "table", "output format: table or json")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+    if flags.NArg() == 0 {
+        return fmt.Errorf("usage: report run [-format table|json] <name> [param=value ...]")
+    }
+    params := make(map[string]interface{})
+    for _, arg := range flags.Args()[1:] {
+        key, value, ok := strings.Cut(arg, "=")
+        if !ok {
+            return fmt.Errorf("report parameter %q must be written as name=value", arg)
+        }
+        params[key] = value
+    }
+
+    rows, columns, err := db.RunReport(flags.Arg(0), params)
+    if err != nil {
+        return err
+    }
+    switch *format {
+    case "json":
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(reportResult{Columns: columns, Rows: rows})
+    case "table":
+        w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+        for i, column := range columns {
+            if i > 0 {
+                fmt.Fprint(w, "\t")
+            }
+            fmt.Fprint(w, column.Name)
+        }
+        fmt.Fprintln(w)
+        for _, row := range rows {
+            for i, column := range columns {
+                if i > 0 {
+                    fmt.Fprint(w, "\t")
+                }
+                fmt.Fprint(w, formatReportValue(row[column.Name], column.Type))
+            }
+            fmt.Fprintln(w)
+        }
+        return w.Flush()
+    }
+    return fmt.Errorf("unknown report format %q, expected table or json", *format)
+}
+
+// formatReportValue записывает значение колонки для таблицы: NULL пустым, даты без времени
+func formatReportValue(value interface{}, t string) string {
+    switch v := value.(type) {
+    case nil:
+        return ""
+    case time.Time:
+        if t == ReportDate {
+            return v.Format("2006-01-02")
+        }
+        return v.Format(time.RFC3339)
+    }
+    return fmt.Sprint(value)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+// errRequestFailed откатывает транзакцию запроса, обработчик которого ответил ошибкой
+var errRequestFailed = errors.New("request failed")
+
+// requestDatabaseKey - ключ Database транзакции запроса в контексте
+type requestDatabaseKey struct{}
+
+// ContextWithDatabase добавляет к контексту копию Database, через которую обработчик работает с базой
+func ContextWithDatabase(ctx context.Context, db *Database) context.Context {
+    return context.WithValue(ctx, requestDatabaseKey{}, db)
+}
+
+// DatabaseFromContext возвращает копию Database транзакции запроса (см. Transactional)
+func DatabaseFromContext(ctx context.Context) (*Database, bool) {
+    db, ok := ctx.Value(requestDatabaseKey{}).(*Database)
+    return db, ok
+}
+
+// Transactional возвращает обработчик, который выполняет next в одной транзакции на запрос:
+// копия Database этой транзакции передается в контексте запроса (см. DatabaseFromContext).
+// Ответ next копится в памяти и отправляется только после фиксации; ответ с кодом 400 и выше
+// или паника откатывают все изменения запроса. Транзакция занимает очередь записи до конца
+// обработчика, поэтому для долгих и потоковых ответов (выгрузок) не подходит
+func (db *Database) Transactional(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        db.serveInTx(w, r, func(tx *Database, w http.ResponseWriter, r *http.Request) {
+            next.ServeHTTP(w, r)
+        })
+    })
+}
+
+// inRequestTx выполняет обработчик маршрута в транзакции запроса (см. Transactional)
+func inRequestTx(serve func(db *Database, w http.ResponseWriter, r *http.Request)) func(db *Database, w http.ResponseWriter, r *http.Request) {
+    return func(db *Database, w http.ResponseWriter, r *http.Request) {
+        db.serveInTx(w, r, serve)
+    }
+}
+
+// serveInTx выполняет serve в транзакции и отвечает его ответом, если транзакция зафиксирована
+// или откачена из-за ответа с ошибкой, иначе - ошибкой транзакции
+func (db *Database) serveInTx(w http.ResponseWriter, r *http.Request, serve func(db *Database, w http.ResponseWriter, r *http.Request)) {
+    var response *bufferedResponse
+    err := db.InTx(func(tx *Database) (err error) {
+        response = &bufferedResponse{header: make(http.Header)}
+        defer func() {
+            if recovered := recover(); recovered != nil {
+                err = fmt.Errorf("panic in %s %s: %v", r.Method, r.URL.Path, recovered)
+            }
+        }()
+        serve(tx, response, r.WithContext(ContextWithDatabase(r.Context(), tx)))
+        if response.status >= http.StatusBadRequest {
+            return errRequestFailed
+        }
+        return nil
+    })
+    if err != nil && !errors.Is(err, errRequestFailed) {
+        writeError(w, err)
+        return
+    }
+    response.flush(w)
+}
+
+// bufferedResponse копит ответ обработчика до фиксации транзакции запроса
+type bufferedResponse struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+    return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+    if b.status == 0 {
+        b.status = status
+    }
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+    b.WriteHeader(http.StatusOK)
+    return b.body.Write(data)
+}
+
+// flush отправляет накопленный ответ
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+    for key, values := range b.header {
+        w.Header()[key] = values
+    }
+    if b.status == 0 {
+        b.status = http.StatusOK
+    }
+    w.WriteHeader(b.status)
+    w.Write(b.body.Bytes())
+}
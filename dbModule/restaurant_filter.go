@@ -0,0 +1,232 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RestaurantFilter задает условия выборки ресторанов; пустые поля не ограничивают выборку
+type RestaurantFilter struct {
+    Type       string
+    // MinPrice и MaxPrice ограничивают уровень цен (см. PriceTier) диапазоном, Tiers - перечнем уровней
+    MinPrice   *PriceTier
+    MaxPrice   *PriceTier
+    Tiers      []PriceTier
+    UserID     *int
+    NamePrefix string
+    // CategoryID оставляет рестораны, входящие в категорию (см. SetRestaurantCategories)
+    CategoryID *int
+    // PriceFrom и PriceTo ограничивают средний чек (см. Restaurant.Price) диапазоном в валюте этих сумм:
+    // рестораны с чеком в другой валюте или без чека не подходят. Валюты границ должны совпадать
+    PriceFrom  *Money
+    PriceTo    *Money
+    // OpenAt оставляет рестораны, открытые в этот момент по часам работы (см. SetRestaurantHours)
+    OpenAt     *time.Time
+    // Limit и Offset задают страницу результата; 0 - без ограничения
+    Limit  int
+    Offset int
+}
+
+// RestaurantSortField - поле, по которому можно сортировать рестораны
+type RestaurantSortField string
+
+// Поля сортировки ресторанов
+const (
+    SortByName  RestaurantSortField = "name"
+    SortByPrice RestaurantSortField = "price"
+)
+
+// restaurantSortColumns сопоставляет поля сортировки с колонками таблицы.
+// В ORDER BY попадают только колонки из этого списка
+var restaurantSortColumns = map[RestaurantSortField]string{
+    SortByName:  "name",
+    SortByPrice: "average_price",
+}
+
+// RestaurantSort описывает один ключ сортировки
+type RestaurantSort struct {
+    Field      RestaurantSortField
+    Descending bool
+}
+
+// SelectRestaurantsWhere выбирает рестораны по фильтру с сортировкой по заданным ключам
+func (db *Database) SelectRestaurantsWhere(filter RestaurantFilter, sorts ...RestaurantSort) ([]Restaurant, error) {
+    query, err := db.selectNamed("restaurants.select_filtered")
+    if err != nil {
+        return nil, db.opError("select", "restaurants", nil, err)
+    }
+
+    query.Where("tenant_id = ?", db.tenant)
+    filter.applyWhere(query)
+    if err := applyRestaurantSorts(query, sorts); err != nil {
+        return nil, db.opError("select", "restaurants", nil, err)
+    }
+    query.Limit(filter.Limit, filter.Offset)
+
+    rows, err := db.queryBuilt("restaurants.select_filtered", query)
+    if err != nil {
+        return nil, db.opError("select", "restaurants", nil, err)
+    }
+    restaurants, err := collectRows(db, rows, scanRestaurant)
+    return restaurants, db.opError("select", "restaurants", nil, err)
+}
+
+// applyRestaurantSorts добавляет в запрос ключи сортировки, проверяя поля по restaurantSortColumns
+func applyRestaurantSorts(query *SelectBuilder, sorts []RestaurantSort) error {
+    for _, sort := range sorts {
+        column, ok := restaurantSortColumns[sort.Field]
+        if !ok {
+            return &ValidationError{Field: "sort field", Message: fmt.Sprintf("%q is unknown", sort.Field)}
+        }
+        query.OrderBy(column, sort.Descending)
+    }
+    return nil
+}
+
+// set задает поле фильтра по имени из parseRestaurantFilter и параметров HTTP API
+func (filter *RestaurantFilter) set(key, value string) error {
+    switch key {
+    case "type":
+        filter.Type = value
+    case "name_prefix":
+        filter.NamePrefix = value
+    case "price_from", "price_to":
+        price, err := ParseMoney(value)
+        if err != nil {
+            return fmt.Errorf("filter %s: %v", key, err)
+        }
+        if key == "price_from" {
+            filter.PriceFrom = &price
+        } else {
+            filter.PriceTo = &price
+        }
+    case "open_at":
+        at := time.Now()
+        if value != "now" {
+            var err error
+            if at, err = time.Parse(time.RFC3339, value); err != nil {
+                return fmt.Errorf("filter %s: %q is not now or a time like 2006-01-02T15:04:05+03:00", key, value)
+            }
+        }
+        filter.OpenAt = &at
+    case "min_price", "max_price":
+        tier, err := ParsePriceTier(value)
+        if err != nil {
+            return fmt.Errorf("filter %s: %v", key, err)
+        }
+        if key == "min_price" {
+            filter.MinPrice = &tier
+        } else {
+            filter.MaxPrice = &tier
+        }
+    case "tier":
+        tiers, err := parsePriceTiers(value)
+        if err != nil {
+            return fmt.Errorf("filter %s: %v", key, err)
+        }
+        filter.Tiers = tiers
+    case "user_id", "category_id":
+        n, err := strconv.Atoi(value)
+        if err != nil {
+            return fmt.Errorf("filter %s: %v", key, err)
+        }
+        switch key {
+        case "category_id":
+            filter.CategoryID = &n
+        default:
+            filter.UserID = &n
+        }
+    default:
+        return fmt.Errorf("unknown filter field %q (expected type, name_prefix, min_price, max_price, tier, price_from, price_to, user_id, category_id or open_at)", key)
+    }
+    return nil
+}
+
+// values возвращает заданные поля фильтра под теми же именами, что принимает set
+func (filter RestaurantFilter) values() map[string]string {
+    values := make(map[string]string)
+    if filter.Type != "" {
+        values["type"] = filter.Type
+    }
+    if filter.NamePrefix != "" {
+        values["name_prefix"] = filter.NamePrefix
+    }
+    if filter.MinPrice != nil {
+        values["min_price"] = strconv.Itoa(int(*filter.MinPrice))
+    }
+    if filter.MaxPrice != nil {
+        values["max_price"] = strconv.Itoa(int(*filter.MaxPrice))
+    }
+    if len(filter.Tiers) > 0 {
+        tiers := make([]string, len(filter.Tiers))
+        for i, tier := range filter.Tiers {
+            tiers[i] = tier.String()
+        }
+        values["tier"] = strings.Join(tiers, ",")
+    }
+    if filter.UserID != nil {
+        values["user_id"] = strconv.Itoa(*filter.UserID)
+    }
+    if filter.CategoryID != nil {
+        values["category_id"] = strconv.Itoa(*filter.CategoryID)
+    }
+    if filter.PriceFrom != nil {
+        values["price_from"] = filter.PriceFrom.String()
+    }
+    if filter.PriceTo != nil {
+        values["price_to"] = filter.PriceTo.String()
+    }
+    if filter.OpenAt != nil {
+        values["open_at"] = filter.OpenAt.Format(time.RFC3339)
+    }
+    return values
+}
+
+// applyWhere добавляет в запрос условия фильтра. Limit и Offset в условие не входят
+func (filter RestaurantFilter) applyWhere(query *SelectBuilder) {
+    if filter.Type != "" {
+        query.Where("type = ?", filter.Type)
+    }
+    if filter.MinPrice != nil {
+        query.Where("average_price >= ?", *filter.MinPrice)
+    }
+    if filter.MaxPrice != nil {
+        query.Where("average_price <= ?", *filter.MaxPrice)
+    }
+    if len(filter.Tiers) > 0 {
+        placeholders := make([]string, len(filter.Tiers))
+        args := make([]interface{}, len(filter.Tiers))
+        for i, tier := range filter.Tiers {
+            placeholders[i], args[i] = "?", int(tier)
+        }
+        query.Where("average_price IN ("+strings.Join(placeholders, ", ")+")", args...)
+    }
+    if filter.PriceFrom != nil && filter.PriceTo != nil && filter.PriceFrom.Currency != filter.PriceTo.Currency {
+        query.fail(&ValidationError{Field: "price_to", Message: fmt.Sprintf("is in %s, but price_from is in %s", filter.PriceTo.Currency, filter.PriceFrom.Currency)})
+    }
+    if filter.PriceFrom != nil {
+        query.Where("price_currency = ? AND price_amount >= ?", filter.PriceFrom.Currency, filter.PriceFrom.Amount)
+    }
+    if filter.PriceTo != nil {
+        query.Where("price_currency = ? AND price_amount <= ?", filter.PriceTo.Currency, filter.PriceTo.Amount)
+    }
+    if filter.UserID != nil {
+        query.Where("user_id = ?", *filter.UserID)
+    }
+    if filter.CategoryID != nil {
+        query.Where("id IN (SELECT restaurant_id FROM {{prefix}}restaurant_categories WHERE category_id = ?)", *filter.CategoryID)
+    }
+    if filter.OpenAt != nil {
+        applyOpenAt(query, *filter.OpenAt)
+    }
+    if filter.NamePrefix != "" {
+        query.Where(`name LIKE ? ESCAPE '\'`, escapeLike(filter.NamePrefix)+"%")
+    }
+}
+
+// escapeLike экранирует спецсимволы LIKE, чтобы пользовательский ввод сравнивался буквально
+func escapeLike(value string) string {
+    return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
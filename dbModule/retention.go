@@ -0,0 +1,305 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v2"
+)
+
+// Действия политик хранения
+const (
+    // RetentionDelete удаляет строки таблицы старше срока
+    RetentionDelete = "delete"
+    // RetentionAnonymize обезличивает пользователей, не входивших дольше срока (см. AnonymizeUser);
+    // применяется только к таблице users и колонке last_active_at
+    RetentionAnonymize = "anonymize"
+)
+
+// activityResolution - как часто обновляется users.last_active_at: вход с Basic-аутентификацией
+// проверяет пароль на каждом запросе, и писать строку каждый раз незачем
+const activityResolution = time.Hour
+
+// RetentionPolicy - политика хранения из retention.yaml: строки таблицы, у которых время в Column
+// старше OlderThan, удаляются или обезличиваются задачей retention
+type RetentionPolicy struct {
+    Name string `yaml:"-"`
+    // Table - таблица без префикса таблиц (см. Config.TablePrefix)
+    Table string `yaml:"table"`
+    // Column - колонка времени, по которой определяется возраст строки
+    Column string `yaml:"column"`
+    // OlderThan - срок хранения: длительность Go (720h) или число дней (365d)
+    OlderThan retentionAge `yaml:"older_than"`
+    // Action - RetentionDelete или RetentionAnonymize
+    Action string `yaml:"action"`
+    // DryRun - только считать строки, которых коснулась бы политика, ничего не меняя: так новую
+    // политику проверяют по отчету задачи, прежде чем включить
+    DryRun bool `yaml:"dry_run"`
+}
+
+// retentionAge - срок хранения политики; в YAML записывается как 720h или 365d
+type retentionAge time.Duration
+
+// UnmarshalYAML читает срок хранения в часах, минутах и секундах Go или в днях
+func (age *retentionAge) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var text string
+    if err := unmarshal(&text); err != nil {
+        return err
+    }
+    if days, ok := strings.CutSuffix(text, "d"); ok {
+        n, err := strconv.Atoi(days)
+        if err != nil || n <= 0 {
+            return fmt.Errorf("older_than %q: want a positive number of days like 365d", text)
+        }
+        *age = retentionAge(time.Duration(n) * 24 * time.Hour)
+        return nil
+    }
+    duration, err := time.ParseDuration(text)
+    if err != nil || duration <= 0 {
+        return fmt.Errorf("older_than %q: want a positive duration like 720h or a number of days like 365d", text)
+    }
+    *age = retentionAge(duration)
+    return nil
+}
+
+// String возвращает срок хранения в днях, если он кратен дню
+func (age retentionAge) String() string {
+    duration := time.Duration(age)
+    if duration%(24*time.Hour) == 0 {
+        return fmt.Sprintf("%dd", duration/(24*time.Hour))
+    }
+    return duration.String()
+}
+
+// retentionIdentifier - допустимые имена таблиц и колонок политик: они подставляются в текст запроса
+var retentionIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// check проверяет политику до выполнения
+func (policy RetentionPolicy) check() error {
+    if !retentionIdentifier.MatchString(policy.Table) || !retentionIdentifier.MatchString(policy.Column) {
+        return fmt.Errorf("table and column must be lowercase identifiers, got %q and %q", policy.Table, policy.Column)
+    }
+    if policy.OlderThan <= 0 {
+        return fmt.Errorf("older_than is required")
+    }
+    switch policy.Action {
+    case RetentionDelete:
+        if policy.Table == "migrations" || policy.Table == "settings" || policy.Table == "locks" {
+            return fmt.Errorf("table %s is not subject to retention", policy.Table)
+        }
+    case RetentionAnonymize:
+        if policy.Table != "users" || policy.Column != "last_active_at" {
+            return fmt.Errorf("anonymize applies only to table users with column last_active_at")
+        }
+    default:
+        return fmt.Errorf("unknown action %q (expected %s or %s)", policy.Action, RetentionDelete, RetentionAnonymize)
+    }
+    return nil
+}
+
+// LoadRetentionPolicies читает политики хранения из YAML файла: имя политики - ключ, политики
+// возвращаются по именам. Отсутствующий файл не считается ошибкой: политик просто нет
+func LoadRetentionPolicies(path string) ([]RetentionPolicy, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    data, err = expandVariables(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    var definitions map[string]RetentionPolicy
+    if err := yaml.UnmarshalStrict(data, &definitions); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    policies := make([]RetentionPolicy, 0, len(definitions))
+    for name, policy := range definitions {
+        policy.Name = name
+        if err := policy.check(); err != nil {
+            return nil, fmt.Errorf("%s: retention policy %s: %v", path, name, err)
+        }
+        policies = append(policies, policy)
+    }
+    sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+    return policies, nil
+}
+
+// SetRetentionPolicies задает политики хранения, которые применяет задача retention (см. RunScheduledTasks);
+// вызывается до начала работы
+func (db *Database) SetRetentionPolicies(policies []RetentionPolicy) {
+    db.retention = policies
+}
+
+// RetentionResult - итог применения одной политики хранения
+type RetentionResult struct {
+    Policy string
+    Action string
+    // Rows - сколько строк удалено или пользователей обезличено; с DryRun - сколько было бы
+    Rows   int64
+    DryRun bool
+}
+
+// String описывает итог для статуса задачи и вывода команды
+func (r RetentionResult) String() string {
+    verb := map[string]string{RetentionDelete: "deleted", RetentionAnonymize: "anonymized"}[r.Action]
+    if r.DryRun {
+        return fmt.Sprintf("%s: %d would be %s (dry run)", r.Policy, r.Rows, verb)
+    }
+    return fmt.Sprintf("%s: %d %s", r.Policy, r.Rows, verb)
+}
+
+// ApplyRetention применяет политики хранения ко всем площадкам; с dryRun (или DryRun политики) только
+// считает строки, которых они коснулись бы. Удаление выполняется одним запросом на политику, обезличивание -
+// отдельной транзакцией на пользователя. Ошибка политики прерывает применение следующих и возвращается
+// вместе с итогами уже примененных
+func (db *Database) ApplyRetention(policies []RetentionPolicy, dryRun bool) ([]RetentionResult, error) {
+    var results []RetentionResult
+    for _, policy := range policies {
+        if err := policy.check(); err != nil {
+            return results, db.opError("apply", "retention policy", policy.Name, fmt.Errorf("%w: %v", ErrValidation, err))
+        }
+        result := RetentionResult{Policy: policy.Name, Action: policy.Action, DryRun: dryRun || policy.DryRun}
+        cutoff := db.now().UTC().Add(-time.Duration(policy.OlderThan))
+        var err error
+        switch {
+        case result.DryRun:
+            result.Rows, err = db.countRetained(policy, cutoff)
+        case policy.Action == RetentionDelete:
+            result.Rows, err = db.deleteRetained(policy, cutoff)
+        case policy.Action == RetentionAnonymize:
+            result.Rows, err = db.anonymizeInactive(cutoff)
+        }
+        if err != nil {
+            return results, db.opError("apply", "retention policy", policy.Name, err)
+        }
+        results = append(results, result)
+    }
+    return results, nil
+}
+
+// retentionWhere возвращает таблицу и условие строк политики старше срока
+func (db *Database) retentionWhere(policy RetentionPolicy) string {
+    return fmt.Sprintf("%s WHERE %s < ?", db.tableIdentifier(db.table(policy.Table)), db.tableIdentifier(policy.Column))
+}
+
+// countRetained считает строки, которых коснулась бы политика
+func (db *Database) countRetained(policy RetentionPolicy, cutoff time.Time) (int64, error) {
+    rows, err := db.WithPrimary().queryText("retention.count", "SELECT COUNT(*) FROM "+db.retentionWhere(policy), cutoff)
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+    var count int64
+    if rows.Next() {
+        if err := rows.Scan(&count); err != nil {
+            return 0, err
+        }
+    }
+    return count, rows.Err()
+}
+
+// deleteRetained удаляет строки политики старше срока
+func (db *Database) deleteRetained(policy RetentionPolicy, cutoff time.Time) (int64, error) {
+    result, err := db.execText("retention.delete", "DELETE FROM "+db.retentionWhere(policy), cutoff)
+    if err != nil {
+        return 0, err
+    }
+    db.clearEntityCache()
+    return result.RowsAffected()
+}
+
+// anonymizeInactive обезличивает пользователей всех площадок, не входивших с cutoff
+func (db *Database) anonymizeInactive(cutoff time.Time) (int64, error) {
+    rows, err := db.WithPrimary().queryNamed("users.select_inactive", cutoff)
+    if err != nil {
+        return 0, err
+    }
+    type inactiveUser struct {
+        ID       int `db:"id"`
+        TenantID int `db:"tenant_id"`
+    }
+    users, err := collectRows(db.WithMaxRows(0), rows, func(rows *queryRows) (inactiveUser, error) {
+        var user inactiveUser
+        err := rows.ScanStruct(&user)
+        return user, err
+    })
+    if err != nil {
+        return 0, err
+    }
+    for i, user := range users {
+        if err := db.WithTenant(user.TenantID).AnonymizeUser(user.ID); err != nil {
+            return int64(i), err
+        }
+    }
+    return int64(len(users)), nil
+}
+
+// touchUserActivity отмечает в users.last_active_at, что пользователь вошел. Запись пропускается,
+// если отметка свежее activityResolution, и в режиме обслуживания: вход в нем не ломается
+func (db *Database) touchUserActivity(userID int) error {
+    now := db.now().UTC()
+    _, err := db.execNamed("users.touch_active", now, userID, db.tenant, now.Add(-activityResolution))
+    if errors.Is(err, ErrMaintenance) {
+        return nil
+    }
+    return err
+}
+
+// runRetentionTask применяет политики хранения, заданные SetRetentionPolicies
+func runRetentionTask(ctx context.Context, db *Database) (string, error) {
+    if len(db.retention) == 0 {
+        return "no retention policies", nil
+    }
+    results, err := db.ApplyRetention(db.retention, false)
+    summary := make([]string, len(results))
+    for i, result := range results {
+        summary[i] = result.String()
+    }
+    return strings.Join(summary, "; "), err
+}
+
+// runRetention выполняет команду retention: list печатает политики из -retention, apply применяет их,
+// с -dry-run только показывая, сколько строк они затронули бы
+func runRetention(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: retention list | apply [-dry-run]")
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("retention", flag.ContinueOnError)
+    dryRun := flags.Bool("dry-run", false, "report how many rows each policy would delete or anonymize without changing them")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "list":
+        for _, policy := range db.retention {
+            mode := ""
+            if policy.DryRun {
+                mode = " (dry run)"
+            }
+            fmt.Printf("%s | %s %s.%s older than %s%s\n", policy.Name, policy.Action, policy.Table, policy.Column, policy.OlderThan, mode)
+        }
+        return nil
+    case "apply":
+        results, err := db.ApplyRetention(db.retention, *dryRun)
+        for _, result := range results {
+            fmt.Println(result)
+        }
+        return err
+    }
+    return usage
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// Review - отзыв пользователя о ресторане
+type Review struct {
+    ID           int       `json:"id"`
+    UserID       int       `json:"user_id"`
+    RestaurantID int       `json:"restaurant_id"`
+    // Rating - оценка от 1 до 5
+    Rating       int       `json:"rating"`
+    Comment      string    `json:"comment"`
+    CreatedAt    time.Time `json:"created_at"`
+    // TenantID - площадка отзыва; при записи берется из WithTenant
+    TenantID     int       `json:"tenant_id"`
+}
+
+// InsertReview добавляет отзыв и заполняет его ID, время создания и площадку.
+// Отзывы удаляются вместе с автором или рестораном
+func (db *Database) InsertReview(review *Review) error {
+    if err := validateReview(*review); err != nil {
+        return db.opError("insert", "review", nil, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        if err := beforeInsert(tx, "review", review, validateReview); err != nil {
+            return err
+        }
+        id, err := tx.insertNamed("reviews.insert", review.UserID, review.RestaurantID, review.Rating, review.Comment, tx.tenant, tx.now().UTC())
+        if tx.driver.isForeignKeyError(err) {
+            return fmt.Errorf("user %d or restaurant %d does not exist: %w", review.UserID, review.RestaurantID, ErrForeignKeyViolation)
+        }
+        if err != nil {
+            return err
+        }
+        created, err := tx.findReview(int(id))
+        if err != nil {
+            return err
+        }
+        if created == nil {
+            return ErrNotFound
+        }
+        *review = *created
+        return tx.audit("review", review.ID, AuditInsert, nil, created)
+    })
+    return db.opError("insert", "review", nil, err)
+}
+
+// GetReviewByID возвращает отзыв по ID или ErrNotFound; результат кешируется (см. SetEntityCache)
+func (db *Database) GetReviewByID(id int) (Review, error) {
+    review, err := cachedLookup(db, "review", id, func() (Review, error) {
+        review, err := db.findReview(id)
+        if err != nil || review == nil {
+            return Review{}, notFoundIfNil(err)
+        }
+        return *review, nil
+    })
+    return review, db.opError("get", "review", id, err)
+}
+
+// ReviewsByRestaurant возвращает отзывы о ресторане в порядке добавления
+func (db *Database) ReviewsByRestaurant(restaurantID int) ([]Review, error) {
+    rows, err := db.queryNamed("reviews.select_by_restaurant", restaurantID, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "reviews", restaurantID, err)
+    }
+    defer rows.Close()
+
+    var reviews []Review
+    for rows.Next() {
+        review, err := scanReview(rows)
+        if err != nil {
+            return nil, db.opError("list", "reviews", restaurantID, err)
+        }
+        reviews = append(reviews, review)
+    }
+    return reviews, db.opError("list", "reviews", restaurantID, rows.Err())
+}
+
+// UpdateReview сохраняет новую оценку и комментарий отзыва; автор и ресторан не меняются
+func (db *Database) UpdateReview(review *Review) error {
+    if err := validateReview(*review); err != nil {
+        return db.opError("update", "review", review.ID, err)
+    }
+
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findReview(review.ID)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("reviews.update", review.Rating, review.Comment, review.ID, tx.tenant); err != nil {
+            return err
+        }
+        current, err := tx.findReview(review.ID)
+        if err != nil {
+            return err
+        }
+        *review = *current
+        return tx.audit("review", review.ID, AuditUpdate, old, current)
+    })
+    return db.opError("update", "review", review.ID, err)
+}
+
+// DeleteReview удаляет отзыв; ErrNotFound, если его нет
+func (db *Database) DeleteReview(id int) error {
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findReview(id)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if _, err := tx.execNamed("reviews.delete", id, tx.tenant); err != nil {
+            return err
+        }
+        return tx.audit("review", id, AuditDelete, old, nil)
+    })
+    return db.opError("delete", "review", id, err)
+}
+
+// findReview читает отзыв текущей площадки; nil, если его нет
+func (db *Database) findReview(id int) (*Review, error) {
+    rows, err := db.queryNamed("reviews.select_by_id", id, db.tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    review, err := scanReview(rows)
+    if err != nil {
+        return nil, err
+    }
+    return &review, rows.Close()
+}
+
+// scanReview читает отзыв из текущей строки
+func scanReview(row *queryRows) (Review, error) {
+    var review Review
+    var comment sql.NullString
+    err := row.Scan(&review.ID, &review.UserID, &review.RestaurantID, &review.Rating, &comment, &review.CreatedAt, &review.TenantID)
+    review.Comment = comment.String
+    return review, err
+}
+
+// validateReview проверяет отзыв перед записью по правилам сущности "review"
+func validateReview(review Review) error {
+    return checkInvariants("review", review)
+}
@@ -0,0 +1,212 @@
+package main
+
+import "fmt"
+
+// Роли пользователей
+const (
+    RoleAdmin    = "admin"
+    RoleOwner    = "owner"
+    RoleCustomer = "customer"
+)
+
+// SystemActor - actorID изменений от имени самого модуля: команд CLI, задач обслуживания и HTTP API,
+// запущенного без -auth. Права таких изменений не проверяются; ID пользователей базы больше нуля
+const SystemActor = 0
+
+// Permission - действие, доступ к которому зависит от роли
+type Permission string
+
+// Права, которые проверяют слои API перед изменяющими запросами
+const (
+    // PermissionManageUsers - создание, изменение и удаление любых пользователей
+    PermissionManageUsers Permission = "manage_users"
+    // PermissionManageRestaurants - изменение любых ресторанов, в том числе чужих
+    PermissionManageRestaurants Permission = "manage_restaurants"
+    // PermissionManageOwnRestaurants - создание ресторанов и изменение своих ресторанов и их меню
+    PermissionManageOwnRestaurants Permission = "manage_own_restaurants"
+    // PermissionWriteReviews - добавление и изменение своих отзывов
+    PermissionWriteReviews Permission = "write_reviews"
+    // PermissionManageCategories - создание, переименование и удаление категорий площадки
+    PermissionManageCategories Permission = "manage_categories"
+    // PermissionManageJobs - отмена чужих фоновых заданий
+    PermissionManageJobs Permission = "manage_jobs"
+)
+
+// rolePermissions - права каждой роли
+var rolePermissions = map[string][]Permission{
+    RoleAdmin:    {PermissionManageUsers, PermissionManageRestaurants, PermissionManageOwnRestaurants, PermissionWriteReviews, PermissionManageCategories, PermissionManageJobs},
+    RoleOwner:    {PermissionManageOwnRestaurants, PermissionWriteReviews},
+    RoleCustomer: {PermissionWriteReviews},
+}
+
+// userRole возвращает роль, записываемую в базу: RoleCustomer для пустой
+func userRole(user User) string {
+    if user.Role == "" {
+        return RoleCustomer
+    }
+    return user.Role
+}
+
+// Can проверяет, есть ли у роли пользователя право permission
+func (u User) Can(permission Permission) bool {
+    for _, granted := range rolePermissions[userRole(u)] {
+        if granted == permission {
+            return true
+        }
+    }
+    return false
+}
+
+// CanManageRestaurant проверяет, может ли пользователь изменять ресторан:
+// администратор - любой, владелец - только свой
+func (u User) CanManageRestaurant(restaurant Restaurant) bool {
+    if u.Can(PermissionManageRestaurants) {
+        return true
+    }
+    return u.Can(PermissionManageOwnRestaurants) && restaurant.UserID == u.ID
+}
+
+// authorizeRestaurant проверяет в транзакции tx, что пользователь actorID может изменять ресторан
+// restaurant (см. CanManageRestaurant), и возвращает ErrForbidden, если нет. Неизвестный на площадке
+// пользователь не может ничего, SystemActor - все
+func (db *Database) authorizeRestaurant(actorID int, restaurant Restaurant) error {
+    if actorID == SystemActor {
+        return nil
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return err
+    }
+    if actor == nil {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    if !actor.CanManageRestaurant(restaurant) {
+        return fmt.Errorf("user %d with role %s cannot change restaurant %d of user %d: %w", actorID, userRole(*actor), restaurant.ID, restaurant.UserID, ErrForbidden)
+    }
+    return nil
+}
+
+// authorizeRestaurantID проверяет, как authorizeRestaurant, права на ресторан id; ErrNotFound, если его нет
+func (db *Database) authorizeRestaurantID(actorID, id int) error {
+    restaurant, err := db.WithPrimary().findRestaurant("restaurants.select_by_id", id, db.tenant)
+    if err != nil {
+        return err
+    }
+    if restaurant == nil {
+        return ErrNotFound
+    }
+    return db.authorizeRestaurant(actorID, *restaurant)
+}
+
+// authorizeNewRestaurant проверяет, что пользователь actorID может добавить ресторан restaurant:
+// администратор - любой, владелец - только себе (restaurant.UserID == actorID), а покупатель не
+// может добавлять рестораны, которые потом не смог бы изменить (см. CanManageRestaurant)
+func (db *Database) authorizeNewRestaurant(actorID int, restaurant Restaurant) error {
+    if actorID == SystemActor {
+        return nil
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return err
+    }
+    if actor == nil {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    if err := RequirePermission(*actor, PermissionManageOwnRestaurants); err != nil {
+        return err
+    }
+    if !actor.CanManageRestaurant(restaurant) {
+        return fmt.Errorf("user %d with role %s cannot add a restaurant of user %d: %w", actorID, userRole(*actor), restaurant.UserID, ErrForbidden)
+    }
+    return nil
+}
+
+// authorizeUser проверяет, что пользователь actorID может изменять пользователя userID: себя
+// или любого, если у него есть PermissionManageUsers, и возвращает ErrForbidden, если нет
+func (db *Database) authorizeUser(actorID, userID int) error {
+    if actorID == SystemActor || actorID == userID {
+        return nil
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return err
+    }
+    if actor == nil {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    if !actor.Can(PermissionManageUsers) {
+        return fmt.Errorf("user %d with role %s cannot change user %d: %w", actorID, userRole(*actor), userID, ErrForbidden)
+    }
+    return nil
+}
+
+// authorizeUserFields проверяет, как authorizeUser, что пользователь actorID может изменить поля fields
+// пользователя userID. Роль меняет только пользователь с PermissionManageUsers, в том числе свою:
+// иначе покупатель мог бы сделать себя администратором
+func (db *Database) authorizeUserFields(actorID, userID int, fields map[string]interface{}) error {
+    if _, ok := fields["role"]; !ok || actorID == SystemActor {
+        return db.authorizeUser(actorID, userID)
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return err
+    }
+    if actor == nil {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    if !actor.Can(PermissionManageUsers) {
+        return fmt.Errorf("user %d with role %s cannot change the role of user %d: %w", actorID, userRole(*actor), userID, ErrPermissionDenied)
+    }
+    return nil
+}
+
+// grantableRole возвращает роль нового пользователя, которого создает пользователь actorID: role, если
+// у actorID есть PermissionManageUsers, иначе fallback. Роль не выбирает себе сам регистрирующийся, поэтому
+// SystemActor (HTTP API без -auth) здесь прав не имеет; пустая role - fallback
+func (db *Database) grantableRole(actorID int, role, fallback string) (string, error) {
+    if role == "" || role == fallback || actorID <= 0 {
+        return fallback, nil
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return "", err
+    }
+    if actor == nil || !actor.Can(PermissionManageUsers) {
+        return fallback, nil
+    }
+    return role, nil
+}
+
+// authorizePermission проверяет, что у пользователя actorID есть право permission, и возвращает
+// ErrPermissionDenied, если нет (см. RequirePermission); SystemActor может все
+func (db *Database) authorizePermission(actorID int, permission Permission) error {
+    if actorID == SystemActor {
+        return nil
+    }
+    actor, err := db.findUser("users.select_by_id", actorID, db.tenant)
+    if err != nil {
+        return err
+    }
+    if actor == nil {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    return RequirePermission(*actor, permission)
+}
+
+// RequirePermission возвращает ErrPermissionDenied, если у пользователя нет права permission
+func RequirePermission(user User, permission Permission) error {
+    if !user.Can(permission) {
+        return fmt.Errorf("user %d with role %s cannot %s: %w", user.ID, userRole(user), permission, ErrPermissionDenied)
+    }
+    return nil
+}
+
+// SelectUsersByRole выбирает пользователей площадки с ролью role
+func (db *Database) SelectUsersByRole(role string) ([]User, error) {
+    rows, err := db.queryNamed("users.select_by_role", role, db.tenant)
+    if err != nil {
+        return nil, db.opError("select", "users", role, err)
+    }
+    users, err := collectRows(db, rows, db.scanUser)
+    return users, db.opError("select", "users", role, err)
+}
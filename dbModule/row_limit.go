@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+)
+
+// RowLimitError - выборка длиннее предела строк (см. SetMaxRows). Без усечения методы Select
+// возвращают только эту ошибку, с усечением - первые Limit строк вместе с ней. Лишние строки
+// не читаются. errors.Is(err, ErrRowLimit) выполняется
+type RowLimitError struct {
+    Query string
+    Limit int
+    // Truncated - строки возвращены, но не все
+    Truncated bool
+}
+
+func (e *RowLimitError) Error() string {
+    if e.Truncated {
+        return fmt.Sprintf("query %s: result truncated to %d rows", e.Query, e.Limit)
+    }
+    return fmt.Sprintf("query %s: more than %d rows", e.Query, e.Limit)
+}
+
+// Unwrap возвращает ErrRowLimit
+func (e *RowLimitError) Unwrap() error {
+    return ErrRowLimit
+}
+
+// SetMaxRows ограничивает число строк, которое методы Select, Query и другие списки читают в память;
+// 0 - без ограничения. Выборка длиннее limit - *RowLimitError, а с truncate методы возвращают первые
+// limit строк вместе с ней. Итераторы (SelectUsersIter) и постраничные выборки строки в память
+// не копят и не ограничиваются. Для отдельного вызова предел меняет WithMaxRows.
+// Вызывается до начала работы
+func (db *Database) SetMaxRows(limit int, truncate bool) {
+    db.maxRows = max(limit, 0)
+    db.truncateRows = truncate
+}
+
+// WithMaxRows возвращает копию Database с пределом строк limit вместо заданного SetMaxRows;
+// 0 снимает ограничение, например для выгрузки, которой точно нужны все строки
+func (db *Database) WithMaxRows(limit int) *Database {
+    scoped := *db
+    scoped.maxRows = max(limit, 0)
+    return &scoped
+}
+
+// limitRows применяет предел строк к уже прочитанному списку, например взятому из кеша,
+// который могла заполнить копия с другим пределом
+func limitRows[T any](db *Database, query string, items []T) ([]T, error) {
+    if db.maxRows == 0 || len(items) <= db.maxRows {
+        return items, nil
+    }
+    err := &RowLimitError{Query: query, Limit: db.maxRows, Truncated: db.truncateRows}
+    if !db.truncateRows {
+        return nil, err
+    }
+    return items[:db.maxRows], err
+}
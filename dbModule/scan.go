@@ -0,0 +1,155 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+    "time"
+)
+
+// ErrColumnMismatch - колонки результата не совпадают с полями структуры, в которую он читается
+var ErrColumnMismatch = errors.New("result columns do not match the struct fields")
+
+// columnField - поле структуры, в которое читается колонка; index - путь к нему через вложенные структуры
+type columnField struct {
+    index []int
+    // null - тег db:"колонка,null": NULL читается как нулевое значение поля
+    null bool
+}
+
+// ScanStruct читает текущую строку в структуру dest (указатель) по именам колонок: колонка
+// попадает в поле с тегом db:"колонка", порядок колонок в запросе не важен. Колонка без поля
+// и поле без колонки - ошибка ErrColumnMismatch, а не молча потерянное значение.
+// NULL допускается в указателях и полях с тегом db:"колонка,null", которые получают нулевое значение.
+// Поле-структура с тегом db:"префикс,prefix" читается из колонок с этим префиксом: например, объединение
+// читается в struct { User User `db:"user_,prefix"`; Restaurant Restaurant `db:"restaurant_,prefix"` }
+// из колонок user_id, user_name, ..., restaurant_id, restaurant_name, ... (см. Listing)
+func (r *queryRows) ScanStruct(dest interface{}) error {
+    columns, err := r.Rows.Columns()
+    if err != nil {
+        return r.db.queryError(r.name, r.args, r.op, err)
+    }
+    targets, assign, err := columnTargets(columns, dest)
+    if err != nil {
+        return r.db.queryError(r.name, r.args, r.op, err)
+    }
+    if err := r.Scan(targets...); err != nil {
+        return err
+    }
+    assign()
+    return nil
+}
+
+// Query выполняет именованный запрос и читает каждую строку в новую структуру T по тегам db ее полей,
+// как ScanStruct, например для отчетов и объединений, у которых нет своей модели. Колонки результата
+// и поля T должны совпадать, иначе ErrColumnMismatch. Чтение вне транзакции уходит на реплику, как у queryNamed
+func Query[T any](db *Database, query string, args ...interface{}) ([]T, error) {
+    if t := reflect.TypeFor[T](); t.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("query %s: rows are read into structs, not %v", query, t)
+    }
+    rows, err := db.queryNamed(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    return collectRows(db, rows, func(rows *queryRows) (T, error) {
+        var row T
+        err := rows.ScanStruct(&row)
+        return row, err
+    })
+}
+
+// columnTargets возвращает назначения Scan для колонок columns в полях dest и функцию,
+// которая переносит прочитанные NULL-совместимые значения в поля
+func columnTargets(columns []string, dest interface{}) ([]interface{}, func(), error) {
+    v := reflect.ValueOf(dest)
+    if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+        return nil, nil, fmt.Errorf("scanning columns: want a pointer to a struct, got %T", dest)
+    }
+    v = v.Elem()
+    fields := structFields(v.Type())
+
+    var missing []string
+    seen := make(map[string]bool, len(columns))
+    targets := make([]interface{}, len(columns))
+    var assigns []func()
+    for i, column := range columns {
+        column = strings.ToLower(column)
+        field, ok := fields[column]
+        if !ok {
+            return nil, nil, fmt.Errorf("%w: column %s has no field with tag db:%q in %s", ErrColumnMismatch, column, column, v.Type())
+        }
+        if seen[column] {
+            return nil, nil, fmt.Errorf("%w: column %s appears twice", ErrColumnMismatch, column)
+        }
+        seen[column] = true
+        targets[i] = v.FieldByIndex(field.index).Addr().Interface()
+        if field.null {
+            target, assign := nullTarget(v.FieldByIndex(field.index))
+            if target != nil {
+                targets[i] = target
+                assigns = append(assigns, assign)
+            }
+        }
+    }
+    for column := range fields {
+        if !seen[column] {
+            missing = append(missing, column)
+        }
+    }
+    if len(missing) > 0 {
+        return nil, nil, fmt.Errorf("%w: the result has no columns %s for %s", ErrColumnMismatch, strings.Join(missing, ", "), v.Type())
+    }
+    return targets, func() {
+        for _, assign := range assigns {
+            assign()
+        }
+    }, nil
+}
+
+// structFields возвращает поля структуры с тегом db по именам колонок, включая поля
+// вложенных структур с тегом db:"префикс,prefix" под именами с префиксом
+func structFields(t reflect.Type) map[string]columnField {
+    fields := map[string]columnField{}
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        column, options, _ := strings.Cut(field.Tag.Get("db"), ",")
+        if column == "" || column == "-" || !field.IsExported() {
+            continue
+        }
+        if options == "prefix" && field.Type.Kind() == reflect.Struct {
+            for name, nested := range structFields(field.Type) {
+                nested.index = append([]int{i}, nested.index...)
+                fields[strings.ToLower(column)+name] = nested
+            }
+            continue
+        }
+        fields[strings.ToLower(column)] = columnField{index: []int{i}, null: options == "null"}
+    }
+    return fields
+}
+
+// nullTarget возвращает sql.Null* для чтения поля field, допускающего NULL, и функцию,
+// которая переносит значение в поле. Для типов без подходящего sql.Null* возвращается nil
+func nullTarget(field reflect.Value) (interface{}, func()) {
+    switch field.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        var n sql.NullInt64
+        return &n, func() { field.SetInt(n.Int64) }
+    case reflect.Float32, reflect.Float64:
+        var n sql.NullFloat64
+        return &n, func() { field.SetFloat(n.Float64) }
+    case reflect.String:
+        var n sql.NullString
+        return &n, func() { field.SetString(n.String) }
+    case reflect.Bool:
+        var n sql.NullBool
+        return &n, func() { field.SetBool(n.Bool) }
+    }
+    if field.Type() == reflect.TypeOf(time.Time{}) {
+        var n sql.NullTime
+        return &n, func() { field.Set(reflect.ValueOf(n.Time)) }
+    }
+    return nil, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Задачи обслуживания, встроенные в модуль
+const (
+    TaskVacuum    = "vacuum"
+    TaskAnalyze   = "analyze"
+    TaskSessions  = "sessions"
+    TaskPurge     = "purge"
+    // TaskRetention применяет политики хранения (см. SetRetentionPolicies)
+    TaskRetention = "retention"
+)
+
+// DefaultSchedule - расписание задач обслуживания по умолчанию: прежняя ежечасная очистка сессий
+const DefaultSchedule = "sessions=@hourly"
+
+// PurgeAfter - сколько хранятся завершенные задания и недоставленные события вебхуков,
+// прежде чем их удалит задача purge
+var PurgeAfter = 30 * 24 * time.Hour
+
+// ScheduledTaskFunc выполняет задачу обслуживания и возвращает ее итог для статуса,
+// например число удаленных строк
+type ScheduledTaskFunc func(ctx context.Context, db *Database) (string, error)
+
+// scheduledTasks - зарегистрированные задачи обслуживания
+var scheduledTasks = map[string]ScheduledTaskFunc{}
+
+// RegisterScheduledTask регистрирует задачу обслуживания name; вызывается из init()
+func RegisterScheduledTask(name string, run ScheduledTaskFunc) {
+    if _, ok := scheduledTasks[name]; ok {
+        panic(fmt.Sprintf("scheduled task %s is already registered", name))
+    }
+    scheduledTasks[name] = run
+}
+
+// ScheduledTaskNames возвращает отсортированные имена зарегистрированных задач обслуживания
+func ScheduledTaskNames() []string {
+    names := make([]string, 0, len(scheduledTasks))
+    for name := range scheduledTasks {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func init() {
+    RegisterScheduledTask(TaskVacuum, runVacuumTask)
+    RegisterScheduledTask(TaskAnalyze, runAnalyzeTask)
+    RegisterScheduledTask(TaskSessions, runSessionsTask)
+    RegisterScheduledTask(TaskPurge, runPurgeTask)
+    RegisterScheduledTask(TaskRetention, runRetentionTask)
+}
+
+// ScheduledTask - задача обслуживания с расписанием (см. ParseSchedule)
+type ScheduledTask struct {
+    Name     string
+    Schedule string
+}
+
+// TaskStatus - состояние задачи обслуживания в этом процессе
+type TaskStatus struct {
+    Name     string `json:"name"`
+    Schedule string `json:"schedule,omitempty"`
+    // NextRun - когда задача запустится по расписанию; пусто, если она запускалась только вручную
+    NextRun *time.Time `json:"next_run,omitempty"`
+    // LastRun, Duration, Result и Error - последний запуск; Error пуст, если он удался
+    LastRun  *time.Time    `json:"last_run,omitempty"`
+    Duration time.Duration `json:"duration_ns,omitempty"`
+    Result   string        `json:"result,omitempty"`
+    Error    string        `json:"error,omitempty"`
+    // Runs и Failures - сколько раз задача выполнялась и сколько из них с ошибкой; запуски, пропущенные
+    // из-за того, что задачу выполняет другой экземпляр, не считаются
+    Runs     int `json:"runs"`
+    Failures int `json:"failures"`
+}
+
+// taskScheduler хранит состояние задач обслуживания, общее для всех копий Database
+type taskScheduler struct {
+    mu     sync.Mutex
+    status map[string]*TaskStatus
+}
+
+// update изменяет состояние задачи name под блокировкой
+func (s *taskScheduler) update(name string, change func(status *TaskStatus)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.status == nil {
+        s.status = make(map[string]*TaskStatus)
+    }
+    status, ok := s.status[name]
+    if !ok {
+        status = &TaskStatus{Name: name}
+        s.status[name] = status
+    }
+    change(status)
+}
+
+// TaskStatuses возвращает состояние задач обслуживания, запускавшихся или запланированных
+// в этом процессе, по именам
+func (db *Database) TaskStatuses() []TaskStatus {
+    db.scheduler.mu.Lock()
+    defer db.scheduler.mu.Unlock()
+    statuses := make([]TaskStatus, 0, len(db.scheduler.status))
+    for _, status := range db.scheduler.status {
+        statuses = append(statuses, *status)
+    }
+    sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+    return statuses
+}
+
+// ParseScheduledTasks разбирает расписание задач вида "sessions=@hourly;vacuum=0 3 * * 0":
+// задачи разделяются точкой с запятой, так как запятые встречаются в выражениях cron
+func ParseScheduledTasks(value string) ([]ScheduledTask, error) {
+    var tasks []ScheduledTask
+    for _, part := range strings.Split(value, ";") {
+        if part = strings.TrimSpace(part); part == "" {
+            continue
+        }
+        name, spec, ok := strings.Cut(part, "=")
+        if !ok {
+            return nil, fmt.Errorf("scheduled task %q: want name=schedule", part)
+        }
+        tasks = append(tasks, ScheduledTask{Name: strings.TrimSpace(name), Schedule: strings.TrimSpace(spec)})
+    }
+    return tasks, nil
+}
+
+// RunScheduledTasks проверяет расписания и запускает задачи обслуживания в фоне, пока не отменен ctx.
+// Ошибка возвращается только для неизвестной задачи или неверного расписания; ошибки запусков
+// логируются и попадают в TaskStatuses.
+// Задачи выполняются по очереди, поэтому долгий VACUUM откладывает следующие. Каждая задача берет
+// блокировку task:<name> (см. WithLockOptions), и из нескольких экземпляров с одной базой ее выполняет один
+func (db *Database) RunScheduledTasks(ctx context.Context, tasks []ScheduledTask) error {
+    schedules := make([]Schedule, len(tasks))
+    next := make([]time.Time, len(tasks))
+    now := time.Now()
+    for i, task := range tasks {
+        if _, ok := scheduledTasks[task.Name]; !ok {
+            return fmt.Errorf("unknown scheduled task %q (expected one of %s)", task.Name, strings.Join(ScheduledTaskNames(), ", "))
+        }
+        schedule, err := ParseSchedule(task.Schedule)
+        if err != nil {
+            return fmt.Errorf("scheduled task %s: %w", task.Name, err)
+        }
+        schedules[i] = schedule
+        if next[i] = schedule.Next(now); next[i].IsZero() {
+            return fmt.Errorf("scheduled task %s: schedule %q never fires", task.Name, task.Schedule)
+        }
+        db.scheduleTask(task, next[i])
+    }
+    if len(tasks) == 0 {
+        return nil
+    }
+
+    go func() {
+        for {
+            due := 0
+            for i := range next {
+                if next[i].Before(next[due]) {
+                    due = i
+                }
+            }
+            timer := time.NewTimer(time.Until(next[due]))
+            select {
+            case <-ctx.Done():
+                timer.Stop()
+                return
+            case <-timer.C:
+            }
+
+            if _, err := db.RunTask(ctx, tasks[due].Name); err != nil && !errors.Is(err, ErrLocked) && !errors.Is(err, ErrClosed) {
+                log.Printf("scheduled task %s: %v", tasks[due].Name, err)
+            }
+            next[due] = schedules[due].Next(time.Now())
+            db.scheduleTask(tasks[due], next[due])
+        }
+    }()
+    return nil
+}
+
+// scheduleTask отмечает в состоянии задачи ее расписание и следующий запуск
+func (db *Database) scheduleTask(task ScheduledTask, next time.Time) {
+    db.scheduler.update(task.Name, func(status *TaskStatus) {
+        status.Schedule = task.Schedule
+        next = next.UTC()
+        status.NextRun = &next
+    })
+}
+
+// RunTask сразу выполняет задачу обслуживания name под ее блокировкой и возвращает итог.
+// Если задачу в это время выполняет другой экземпляр, возвращает ErrLocked, не дожидаясь его
+func (db *Database) RunTask(ctx context.Context, name string) (string, error) {
+    run, ok := scheduledTasks[name]
+    if !ok {
+        return "", fmt.Errorf("unknown scheduled task %q (expected one of %s)", name, strings.Join(ScheduledTaskNames(), ", "))
+    }
+
+    var result string
+    started := time.Now()
+    err := db.WithLockOptions("task:"+name, LockOptions{TTL: time.Minute}, func() error {
+        var err error
+        result, err = run(ctx, db)
+        return err
+    })
+    if errors.Is(err, ErrLocked) {
+        return "", db.opError("run", "task", name, err)
+    }
+
+    db.scheduler.update(name, func(status *TaskStatus) {
+        lastRun := started.UTC()
+        status.LastRun = &lastRun
+        status.Duration = time.Since(started)
+        status.Result = result
+        status.Error = ""
+        status.Runs++
+        if err != nil {
+            status.Error = err.Error()
+            status.Failures++
+        }
+    })
+    return result, db.opError("run", "task", name, err)
+}
+
+// runVacuumTask пересобирает файл базы, возвращая системе место удаленных строк
+func runVacuumTask(ctx context.Context, db *Database) (string, error) {
+    if err := db.requireVacuum(TaskVacuum); err != nil {
+        return "", err
+    }
+    _, err := db.execNamed("scheduler.vacuum")
+    return "", err
+}
+
+// runAnalyzeTask обновляет статистику, по которой планировщик запросов выбирает индексы
+func runAnalyzeTask(ctx context.Context, db *Database) (string, error) {
+    if err := db.requireVacuum(TaskAnalyze); err != nil {
+        return "", err
+    }
+    _, err := db.execNamed("scheduler.analyze")
+    return "", err
+}
+
+// requireVacuum проверяет, что СУБД понимает VACUUM и ANALYZE без аргументов
+func (db *Database) requireVacuum(task string) error {
+    if name := db.driver.dialect.Name(); name != "sqlite" && name != "postgres" {
+        return fmt.Errorf("%s is only supported for SQLite and PostgreSQL, current dialect is %s", task, name)
+    }
+    return nil
+}
+
+// runSessionsTask удаляет просроченные сессии, токены сброса пароля и ключи идемпотентности
+func runSessionsTask(ctx context.Context, db *Database) (string, error) {
+    sessions, err := db.DeleteExpiredSessions()
+    if err != nil {
+        return "", err
+    }
+    resets, err := db.DeleteExpiredPasswordResets()
+    if err != nil {
+        return "", err
+    }
+    keys, err := db.DeleteExpiredIdempotencyKeys()
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%d sessions, %d password resets, %d idempotency keys deleted", sessions, resets, keys), nil
+}
+
+// runPurgeTask удаляет завершенные задания и недоставленные события вебхуков старше PurgeAfter.
+// Мягкого удаления у пользователей и ресторанов нет: их строки удаляются сразу, поэтому чистятся
+// только служебные таблицы, которые иначе растут без ограничений
+func runPurgeTask(ctx context.Context, db *Database) (string, error) {
+    cutoff := db.now().UTC().Add(-PurgeAfter)
+    jobs, err := db.execNamed("jobs.delete_finished", cutoff)
+    if err != nil {
+        return "", err
+    }
+    letters, err := db.execNamed("webhook_dead_letters.delete_older", cutoff)
+    if err != nil {
+        return "", err
+    }
+    jobCount, _ := jobs.RowsAffected()
+    letterCount, _ := letters.RowsAffected()
+    return fmt.Sprintf("%d jobs, %d webhook dead letters deleted", jobCount, letterCount), nil
+}
+
+// runTasks выполняет команду tasks: list печатает задачи и расписание из -schedule, run выполняет задачу сразу
+func runTasks(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: tasks list | run -name %s", strings.Join(ScheduledTaskNames(), "|"))
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("tasks", flag.ContinueOnError)
+    name := flags.String("name", "", "task to run: "+strings.Join(ScheduledTaskNames(), ", "))
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "list":
+        tasks, err := ParseScheduledTasks(*scheduleFlag)
+        if err != nil {
+            return err
+        }
+        schedules := make(map[string]string, len(tasks))
+        for _, task := range tasks {
+            schedules[task.Name] = task.Schedule
+        }
+        for _, task := range ScheduledTaskNames() {
+            schedule := schedules[task]
+            if schedule == "" {
+                schedule = "not scheduled"
+            }
+            fmt.Printf("%s | %s\n", task, schedule)
+        }
+        return nil
+    case "run":
+        result, err := db.RunTask(context.Background(), *name)
+        if err != nil {
+            return err
+        }
+        if result == "" {
+            result = "done"
+        }
+        fmt.Printf("%s: %s\n", *name, result)
+        return nil
+    }
+    return usage
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Виды расхождения схемы базы с ожидаемой
+const (
+    driftMissingTable  = "missing table"
+    driftMissingColumn = "missing column"
+    driftTypeMismatch  = "type mismatch"
+)
+
+// SchemaDrift - одно расхождение живой схемы с ожидаемой
+type SchemaDrift struct {
+    Table string
+    // Column пустая, если в базе нет всей таблицы
+    Column string
+    // Problem - missing table, missing column или type mismatch
+    Problem string
+    // Expected и Actual - типы колонки при type mismatch
+    Expected string
+    Actual   string
+}
+
+func (d SchemaDrift) String() string {
+    switch d.Problem {
+    case driftMissingTable:
+        return fmt.Sprintf("%s: %s", d.Table, d.Problem)
+    case driftTypeMismatch:
+        return fmt.Sprintf("%s.%s: %s: expected %s, got %s", d.Table, d.Column, d.Problem, d.Expected, d.Actual)
+    }
+    return fmt.Sprintf("%s.%s: %s (%s)", d.Table, d.Column, d.Problem, d.Expected)
+}
+
+// SchemaDriftError возвращается CheckSchema, если схема базы разошлась с миграциями; Error содержит весь отчет
+type SchemaDriftError struct {
+    Drift []SchemaDrift
+}
+
+func (e *SchemaDriftError) Error() string {
+    lines := make([]string, len(e.Drift))
+    for i, drift := range e.Drift {
+        lines[i] = "  " + drift.String()
+    }
+    return fmt.Sprintf("schema drift: %d differences from the migrations:\n%s", len(e.Drift), strings.Join(lines, "\n"))
+}
+
+// VerifySchema сравнивает таблицы и колонки базы с expected и возвращает расхождения:
+// отсутствующие таблицы и колонки и колонки другого типа. Лишние таблицы и колонки
+// расхождением не считаются: их может создать само приложение
+func (db *Database) VerifySchema(expected []TableDoc) ([]SchemaDrift, error) {
+    actual, err := db.DescribeSchema(nil)
+    if err != nil {
+        return nil, err
+    }
+    tables := make(map[string]TableDoc, len(actual.Tables))
+    for _, table := range actual.Tables {
+        tables[strings.ToLower(table.Name)] = table
+    }
+
+    var drift []SchemaDrift
+    for _, want := range expected {
+        got, ok := tables[strings.ToLower(want.Name)]
+        if !ok {
+            drift = append(drift, SchemaDrift{Table: want.Name, Problem: driftMissingTable})
+            continue
+        }
+        columns := make(map[string]ColumnDoc, len(got.Columns))
+        for _, column := range got.Columns {
+            columns[strings.ToLower(column.Name)] = column
+        }
+        for _, column := range want.Columns {
+            existing, ok := columns[strings.ToLower(column.Name)]
+            switch {
+            case !ok:
+                drift = append(drift, SchemaDrift{Table: want.Name, Column: column.Name, Problem: driftMissingColumn, Expected: column.Type})
+            case normalizeColumnType(existing.Type) != normalizeColumnType(column.Type):
+                drift = append(drift, SchemaDrift{Table: want.Name, Column: column.Name, Problem: driftTypeMismatch, Expected: column.Type, Actual: existing.Type})
+            }
+        }
+    }
+    return drift, nil
+}
+
+// MigrationSchema возвращает схему, которую дают все миграции: они применяются к чистой
+// временной базе тем же драйвером и в том же режиме, а затем ее таблицы читаются из каталога.
+// Временную базу можно создать только для SQLite
+func (db *Database) MigrationSchema() ([]TableDoc, error) {
+    if err := db.requireSQLite("schema verification against migrations"); err != nil {
+        return nil, err
+    }
+    dir, err := ioutil.TempDir("", "dbmodule-schema")
+    if err != nil {
+        return nil, db.opError("build", "migration schema", nil, err)
+    }
+    defer os.RemoveAll(dir)
+
+    config := Config{Driver: db.driver.name, DSN: filepath.Join(dir, "schema.db"), Timeouts: db.timeouts, Strict: db.strict, TablePrefix: db.tablePrefix}
+    scratch, err := NewDatabaseWithConfig(config, db.queries)
+    if err != nil {
+        return nil, db.opError("build", "migration schema", nil, err)
+    }
+    defer scratch.Close()
+    if err := scratch.Migrate(); err != nil {
+        return nil, fmt.Errorf("migrating scratch database: %w", err)
+    }
+    doc, err := scratch.DescribeSchema(nil)
+    if err != nil {
+        return nil, db.opError("build", "migration schema", nil, err)
+    }
+    return doc.Tables, nil
+}
+
+// CheckSchema сверяет схему базы с миграциями (см. MigrationSchema и VerifySchema)
+// и возвращает *SchemaDriftError с полным отчетом, если они разошлись
+func (db *Database) CheckSchema() error {
+    expected, err := db.MigrationSchema()
+    if err != nil {
+        return err
+    }
+    // миграции применяются к основной базе, реплики могут еще не догнать ее
+    drift, err := db.WithPrimary().VerifySchema(expected)
+    if err != nil {
+        return err
+    }
+    if len(drift) > 0 {
+        return &SchemaDriftError{Drift: drift}
+    }
+    return nil
+}
+
+// normalizeColumnType приводит тип колонки к виду для сравнения: регистр и пробелы не важны
+func normalizeColumnType(columnType string) string {
+    return strings.Join(strings.Fields(strings.ToUpper(columnType)), " ")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "go/format"
+    "io"
+    "os"
+    "strings"
+)
+
+// WriteSQL выводит CREATE TABLE и CREATE INDEX таблиц схемы такими, какие они в базе сейчас.
+// Операторы восстановлены по каталогу (колонки, первичный и внешние ключи, индексы), поэтому
+// ограничения CHECK, AUTOINCREMENT и STRICT в них не попадают; индексы первичных ключей пропускаются
+func (doc SchemaDoc) WriteSQL(w io.Writer) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "-- Database schema (%s), read from the database catalog\n", doc.Dialect)
+    for _, table := range doc.Tables {
+        fmt.Fprintf(&b, "\nCREATE TABLE %s (\n", table.Name)
+        var lines, primaryKey []string
+        for _, column := range table.Columns {
+            line := "    " + column.Name + " " + column.Type
+            if column.NotNull {
+                line += " NOT NULL"
+            }
+            if column.Default != "" {
+                line += " DEFAULT " + column.Default
+            }
+            lines = append(lines, line)
+            if column.PrimaryKey {
+                primaryKey = append(primaryKey, column.Name)
+            }
+        }
+        if len(primaryKey) > 0 {
+            lines = append(lines, "    PRIMARY KEY ("+strings.Join(primaryKey, ", ")+")")
+        }
+        var indexes []IndexDoc
+        for _, index := range table.Indexes {
+            switch {
+            case index.Unique && index.Columns == strings.Join(primaryKey, ", "):
+            case strings.HasPrefix(index.Name, "sqlite_autoindex_"):
+                // индексы ограничений UNIQUE SQLite нельзя создать под их именами
+                lines = append(lines, "    UNIQUE ("+index.Columns+")")
+            default:
+                indexes = append(indexes, index)
+            }
+        }
+        for _, fk := range table.ForeignKeys {
+            line := fmt.Sprintf("    FOREIGN KEY (%s) REFERENCES %s (%s)", fk.Column, fk.ReferencedTable, fk.ReferencedColumn)
+            if onDelete := strings.ToUpper(fk.OnDelete); onDelete != "" && onDelete != "NO ACTION" {
+                line += " ON DELETE " + onDelete
+            }
+            lines = append(lines, line)
+        }
+        b.WriteString(strings.Join(lines, ",\n"))
+        b.WriteString("\n);\n")
+
+        for _, index := range indexes {
+            unique := ""
+            if index.Unique {
+                unique = "UNIQUE "
+            }
+            fmt.Fprintf(&b, "CREATE %sINDEX %s ON %s (%s);\n", unique, index.Name, table.Name, index.Columns)
+        }
+    }
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// WriteGoStructs выводит структуры Go пакета pkg по таблицам схемы: по одной на таблицу, с тегами
+// json и db по именам колонок, как у User и Restaurant. Колонки, допускающие NULL, становятся указателями.
+// Имя структуры - имя таблицы без префикса в единственном числе, например menu_items - MenuItem
+func (doc SchemaDoc) WriteGoStructs(w io.Writer, pkg string) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "// Code generated by dbModule schema dump from the %s database; DO NOT EDIT.\n\npackage %s\n", doc.Dialect, pkg)
+
+    var body strings.Builder
+    usesTime := false
+    for _, table := range doc.Tables {
+        if doc.writeGoStruct(&body, table) {
+            usesTime = true
+        }
+    }
+    if usesTime {
+        b.WriteString("\nimport \"time\"\n")
+    }
+    b.WriteString(body.String())
+
+    source, err := format.Source([]byte(b.String()))
+    if err != nil {
+        return fmt.Errorf("formatting generated structs: %w", err)
+    }
+    _, err = w.Write(source)
+    return err
+}
+
+// writeGoStruct выводит структуру строки таблицы в b и сообщает, использует ли она time.Time
+func (doc SchemaDoc) writeGoStruct(b *strings.Builder, table TableDoc) bool {
+    usesTime := false
+    name := doc.goTableName(table)
+    fmt.Fprintf(b, "\n// %s - строка таблицы %s\n", name, table.Name)
+    if table.Description != "" {
+        fmt.Fprintf(b, "// %s\n", table.Description)
+    }
+    fmt.Fprintf(b, "type %s struct {\n", name)
+    for _, column := range table.Columns {
+        goType := goColumnType(column.Type)
+        if goType == "time.Time" {
+            usesTime = true
+        }
+        if !column.NotNull && !column.PrimaryKey && goType != "[]byte" && goType != "interface{}" {
+            goType = "*" + goType
+        }
+        if column.Description != "" {
+            fmt.Fprintf(b, "// %s\n", column.Description)
+        }
+        fmt.Fprintf(b, "%s %s `json:%q db:%q`\n", goIdentifier(column.Name), goType, strings.ToLower(column.Name), strings.ToLower(column.Name))
+    }
+    b.WriteString("}\n")
+    return usesTime
+}
+
+// goTableName возвращает имя структуры строки таблицы (см. goStructName)
+func (doc SchemaDoc) goTableName(table TableDoc) string {
+    return goStructName(doc.unprefixed(table))
+}
+
+// unprefixed возвращает имя таблицы без префикса экземпляра в нижнем регистре
+func (doc SchemaDoc) unprefixed(table TableDoc) string {
+    return strings.TrimPrefix(strings.ToLower(table.Name), strings.ToLower(doc.TablePrefix))
+}
+
+// goInitialisms - части имен колонок, которые в Go пишутся заглавными целиком
+var goInitialisms = map[string]bool{"id": true, "ip": true, "json": true, "pii": true, "sql": true, "uid": true, "url": true, "uuid": true}
+
+// goIdentifier переводит имя колонки или таблицы в snake_case в экспортируемое имя Go: user_id - UserID
+func goIdentifier(name string) string {
+    var b strings.Builder
+    for _, part := range strings.Split(strings.ToLower(name), "_") {
+        if part == "" {
+            continue
+        }
+        if goInitialisms[part] {
+            b.WriteString(strings.ToUpper(part))
+            continue
+        }
+        b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+    }
+    if b.Len() == 0 || b.String()[0] >= '0' && b.String()[0] <= '9' {
+        return "X" + b.String()
+    }
+    return b.String()
+}
+
+// goStructName возвращает имя структуры для таблицы: последнее слово в единственном числе
+func goStructName(table string) string {
+    switch {
+    case strings.HasSuffix(table, "ies"):
+        table = strings.TrimSuffix(table, "ies") + "y"
+    case strings.HasSuffix(table, "sses"):
+        table = strings.TrimSuffix(table, "es")
+    case strings.HasSuffix(table, "s") && !strings.HasSuffix(table, "ss"):
+        table = strings.TrimSuffix(table, "s")
+    }
+    return goIdentifier(table)
+}
+
+// goColumnType сопоставляет тип колонки из каталога любой поддерживаемой СУБД с типом Go
+func goColumnType(columnType string) string {
+    t := normalizeColumnType(columnType)
+    base, _, _ := strings.Cut(t, "(")
+    base = strings.TrimSpace(base)
+    switch {
+    case base == "BIGINT" || base == "BIGSERIAL":
+        return "int64"
+    case strings.Contains(base, "INT") || base == "SERIAL" || base == "NUMBER" && !strings.Contains(t, ","):
+        return "int"
+    case base == "BOOLEAN" || base == "BOOL" || base == "BIT":
+        return "bool"
+    case strings.Contains(base, "CHAR") || strings.Contains(base, "TEXT") || strings.Contains(base, "CLOB") || base == "UUID" || strings.HasPrefix(base, "JSON"):
+        return "string"
+    case strings.Contains(base, "TIME") || base == "DATE":
+        return "time.Time"
+    case strings.Contains(base, "BLOB") || strings.Contains(base, "BINARY") || base == "BYTEA" || base == "RAW":
+        return "[]byte"
+    case base == "REAL" || strings.Contains(base, "FLOAT") || strings.Contains(base, "DOUBLE") || base == "NUMERIC" || base == "DECIMAL" || base == "NUMBER":
+        return "float64"
+    }
+    return "interface{}"
+}
+
+// runSchema выполняет команду schema: schema dump выводит схему базы как SQL или структуры Go
+func runSchema(db *Database, args []string) error {
+    if len(args) == 0 || args[0] != "dump" {
+        return fmt.Errorf("usage: schema dump [-format sql|go] [-package P] [-out F]")
+    }
+    flags := flag.NewFlagSet("schema dump", flag.ContinueOnError)
+    dumpFormat := flags.String("format", "sql", "output format: sql (CREATE statements) or go (struct definitions)")
+    pkg := flags.String("package", "models", "package name of the generated Go file")
+    output := flags.String("out", "", "output file (default: stdout)")
+    descriptionsPath := flags.String("descriptions", "./config/schema_docs.yaml", "YAML file with table and column descriptions for Go doc comments")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    descriptions, err := LoadSchemaDescriptions(*descriptionsPath)
+    if err != nil {
+        return err
+    }
+    doc, err := db.WithPrimary().DescribeSchema(descriptions)
+    if err != nil {
+        return err
+    }
+
+    w := io.Writer(os.Stdout)
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        w = file
+    }
+
+    switch *dumpFormat {
+    case "sql":
+        return doc.WriteSQL(w)
+    case "go":
+        return doc.WriteGoStructs(w, *pkg)
+    }
+    return fmt.Errorf("unknown schema dump format %q, expected sql or go", *dumpFormat)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// Пространства имен реестра с определениями представлений и триггеров
+const (
+    viewsNamespace    = "views."
+    triggersNamespace = "triggers."
+)
+
+// Виды объектов схемы, записываемые в таблицу schema_objects
+const (
+    SchemaObjectView    = "view"
+    SchemaObjectTrigger = "trigger"
+)
+
+// SchemaObject - представление или триггер из конфигурации схемы. В отличие от миграций
+// определение можно менять на месте: Migrate пересоздает объект, если определение изменилось
+type SchemaObject struct {
+    Name string
+    Kind string
+    // Create - CREATE VIEW или CREATE TRIGGER для текущего диалекта
+    Create string
+    // Drop удаляет объект, если он есть
+    Drop string
+    // Hash - SHA-256 определения, по которому видно, что объект нужно пересоздать
+    Hash string
+}
+
+// triggerTablePattern находит таблицу триггера в CREATE TRIGGER: первое ON после имени триггера
+var triggerTablePattern = regexp.MustCompile(`(?is)\bCREATE\s+(?:OR\s+REPLACE\s+)?TRIGGER\s+\S+.*?\bON\s+([A-Za-z_][A-Za-z0-9_.]*)`)
+
+// SchemaObjects возвращает представления из views.yaml (ключ - имя, значение - SELECT)
+// и триггеры из triggers.yaml (ключ - имя, значение - полный CREATE TRIGGER) для текущего диалекта.
+// К имени из ключа добавляется префикс таблиц, поэтому в CREATE TRIGGER оно пишется как {{prefix}}имя.
+// Сначала идут представления, затем триггеры, внутри вида - по имени
+func (db *Database) SchemaObjects() ([]SchemaObject, error) {
+    var objects []SchemaObject
+    for _, kind := range []string{SchemaObjectView, SchemaObjectTrigger} {
+        namespace := viewsNamespace
+        if kind == SchemaObjectTrigger {
+            namespace = triggersNamespace
+        }
+        for _, name := range db.queries.Names() {
+            // варианты для диалектов подставляет lookupQuery
+            if !strings.HasPrefix(name, namespace) || strings.Contains(name, "@") {
+                continue
+            }
+            query, err := db.lookupQuery(name)
+            if err != nil {
+                return nil, db.opError("list", "schema objects", nil, err)
+            }
+            object, err := db.schemaObject(kind, db.table(strings.TrimPrefix(name, namespace)), query)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %w", name, err)
+            }
+            objects = append(objects, object)
+        }
+    }
+    return objects, nil
+}
+
+// schemaObject составляет CREATE и DROP объекта схемы для текущего диалекта
+func (db *Database) schemaObject(kind, name, definition string) (SchemaObject, error) {
+    object := SchemaObject{Name: name, Kind: kind}
+    oracle := db.driver.dialect.Name() == "oracle"
+    switch kind {
+    case SchemaObjectView:
+        object.Create = fmt.Sprintf("CREATE VIEW %s AS %s", name, strings.TrimSuffix(strings.TrimSpace(definition), ";"))
+        object.Drop = "DROP VIEW IF EXISTS " + name
+        if oracle {
+            object.Drop = fmt.Sprintf("BEGIN EXECUTE IMMEDIATE 'DROP VIEW %s'; EXCEPTION WHEN OTHERS THEN IF SQLCODE != -942 THEN RAISE; END IF; END;", name)
+        }
+    case SchemaObjectTrigger:
+        object.Create = definition
+        object.Drop = "DROP TRIGGER IF EXISTS " + name
+        switch db.driver.dialect.Name() {
+        case "postgres":
+            // в PostgreSQL имя триггера уникально только в пределах таблицы
+            match := triggerTablePattern.FindStringSubmatch(definition)
+            if match == nil {
+                return object, fmt.Errorf("cannot find the table of trigger %s", name)
+            }
+            object.Drop += " ON " + match[1]
+        case "oracle":
+            object.Drop = fmt.Sprintf("BEGIN EXECUTE IMMEDIATE 'DROP TRIGGER %s'; EXCEPTION WHEN OTHERS THEN IF SQLCODE != -4080 THEN RAISE; END IF; END;", name)
+        }
+    }
+    sum := sha256.Sum256([]byte(kind + "\n" + name + "\n" + object.Create))
+    object.Hash = hex.EncodeToString(sum[:])
+    return object, nil
+}
+
+// installedSchemaObjects читает из schema_objects, какие объекты и с каким определением созданы
+func (db *Database) installedSchemaObjects(ctx context.Context, tx sqlExecer) (map[string]string, error) {
+    query, err := db.lookupQuery("schema_objects.select")
+    if err != nil {
+        return nil, err
+    }
+    rows, err := tx.QueryContext(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    installed := make(map[string]string)
+    for rows.Next() {
+        var name, kind, hash string
+        if err := rows.Scan(&name, &kind, &hash); err != nil {
+            return nil, err
+        }
+        installed[kind+" "+name] = hash
+    }
+    return installed, rows.Err()
+}
+
+// syncSchemaObjects приводит представления и триггеры базы к конфигурации. Если хоть один объект
+// добавлен, изменен, удален из конфигурации или пропал из базы, пересоздаются все: представления могут зависеть
+// друг от друга, и PostgreSQL не даст удалить одно, пока на него ссылается другое
+func (db *Database) syncSchemaObjects() error {
+    objects, err := db.SchemaObjects()
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    installed, err := db.installedSchemaObjects(ctx, tx)
+    if err != nil {
+        return err
+    }
+    existing, err := db.catalogSchemaObjects(ctx, tx)
+    if err != nil {
+        return err
+    }
+    if len(installed) == len(objects) {
+        current := true
+        for _, object := range objects {
+            key := object.Kind + " " + object.Name
+            current = current && installed[key] == object.Hash && existing[strings.ToLower(key)]
+        }
+        if current {
+            return nil
+        }
+    }
+
+    if err := db.dropSchemaObjects(ctx, tx, objects, installed); err != nil {
+        return err
+    }
+    insert, err := db.lookupQuery("schema_objects.insert")
+    if err != nil {
+        return err
+    }
+    for _, object := range objects {
+        if _, err := tx.ExecContext(ctx, object.Create); err != nil {
+            return fmt.Errorf("creating %s %s: %w", object.Kind, object.Name, err)
+        }
+        if _, err := tx.ExecContext(ctx, db.driver.dialect.Rebind(insert), object.Name, object.Kind, object.Hash); err != nil {
+            return err
+        }
+    }
+    return db.timeoutError(ctx, "schema objects", operationMigration, tx.Commit())
+}
+
+// clearSchemaObjects удаляет представления и триггеры перед миграциями, которые могут пересобирать
+// и переименовывать таблицы под ними; syncSchemaObjects создаст их заново. Без tracked таблицы
+// schema_objects еще нет (Initialize): тогда удаляются только представления из конфигурации,
+// а триггеры удаляются вместе со своими таблицами
+func (db *Database) clearSchemaObjects(tracked bool) error {
+    objects, err := db.SchemaObjects()
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var installed map[string]string
+    if tracked {
+        if installed, err = db.installedSchemaObjects(ctx, tx); err != nil {
+            return err
+        }
+    } else {
+        var views []SchemaObject
+        for _, object := range objects {
+            if object.Kind == SchemaObjectView {
+                views = append(views, object)
+            }
+        }
+        objects = views
+    }
+    if err := db.dropSchemaObjects(ctx, tx, objects, installed); err != nil {
+        return err
+    }
+    return db.timeoutError(ctx, "schema objects", operationMigration, tx.Commit())
+}
+
+// dropSchemaObjects удаляет объекты конфигурации и объекты installed, созданные по прежней
+// конфигурации, и очищает schema_objects, если installed задан. Сначала удаляются триггеры,
+// затем представления в порядке, обратном созданию
+func (db *Database) dropSchemaObjects(ctx context.Context, tx *sql.Tx, objects []SchemaObject, installed map[string]string) error {
+    drops := make(map[string]SchemaObject)
+    for _, object := range objects {
+        drops[object.Kind+" "+object.Name] = object
+    }
+    for key := range installed {
+        if _, ok := drops[key]; ok {
+            continue
+        }
+        // определения уже нет; DROP TRIGGER в PostgreSQL требует таблицу, ее берем из каталога
+        kind, name, _ := strings.Cut(key, " ")
+        definition := ""
+        if kind == SchemaObjectTrigger {
+            definition = fmt.Sprintf("CREATE TRIGGER %s ON %s", name, db.triggerTable(ctx, tx, name))
+        }
+        // триггер, которого нет в каталоге, удалять не нужно
+        if object, err := db.schemaObject(kind, name, definition); err == nil {
+            drops[key] = object
+        }
+    }
+
+    ordered := make([]SchemaObject, 0, len(drops))
+    for _, object := range drops {
+        ordered = append(ordered, object)
+    }
+    sort.Slice(ordered, func(i, j int) bool {
+        if ordered[i].Kind != ordered[j].Kind {
+            return ordered[i].Kind == SchemaObjectTrigger
+        }
+        return ordered[i].Name > ordered[j].Name
+    })
+    for _, object := range ordered {
+        if _, err := tx.ExecContext(ctx, object.Drop); err != nil {
+            return fmt.Errorf("dropping %s %s: %w", object.Kind, object.Name, err)
+        }
+    }
+
+    if installed == nil {
+        return nil
+    }
+    deleteAll, err := db.lookupQuery("schema_objects.delete_all")
+    if err != nil {
+        return err
+    }
+    _, err = tx.ExecContext(ctx, deleteAll)
+    return err
+}
+
+// catalogSchemaObjects возвращает представления и триггеры, которые есть в базе, в виде "вид имя" в нижнем регистре
+func (db *Database) catalogSchemaObjects(ctx context.Context, conn sqlExecer) (map[string]bool, error) {
+    existing := make(map[string]bool)
+    for kind, name := range map[string]string{SchemaObjectView: "introspection.views", SchemaObjectTrigger: "introspection.triggers"} {
+        query, err := db.lookupQuery(name)
+        if err != nil {
+            return nil, err
+        }
+        rows, err := conn.QueryContext(ctx, query)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var object string
+            if err := rows.Scan(&object); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            existing[kind+" "+strings.ToLower(object)] = true
+        }
+        err = rows.Err()
+        rows.Close()
+        if err != nil {
+            return nil, err
+        }
+    }
+    return existing, nil
+}
+
+// triggerTable возвращает таблицу триггера из каталога базы; пустая строка, если триггера нет
+func (db *Database) triggerTable(ctx context.Context, tx *sql.Tx, name string) string {
+    query, err := db.lookupQuery("introspection.trigger_table")
+    if err != nil {
+        return ""
+    }
+    var table string
+    if tx.QueryRowContext(ctx, db.driver.dialect.Rebind(query), name).Scan(&table) != nil {
+        return ""
+    }
+    return table
+}
+
+// SchemaObjectStatus - результат проверки одного представления или триггера
+type SchemaObjectStatus struct {
+    Name string
+    Kind string
+    // Status - ok, missing (объекта нет в базе), outdated (создан по другому определению)
+    // или stale (удален из конфигурации, но остался в базе)
+    Status string
+}
+
+// OK сообщает, что объект соответствует конфигурации
+func (s SchemaObjectStatus) OK() bool {
+    return s.Status == "ok"
+}
+
+// VerifySchemaObjects сравнивает представления и триггеры базы с конфигурацией
+func (db *Database) VerifySchemaObjects() ([]SchemaObjectStatus, error) {
+    objects, err := db.SchemaObjects()
+    if err != nil {
+        return nil, err
+    }
+    ctx, cancel := db.operationContext(operationRead)
+    defer cancel()
+    installed, err := db.installedSchemaObjects(ctx, db.DB)
+    if err != nil {
+        return nil, db.opError("verify", "schema objects", nil, err)
+    }
+
+    existing, err := db.catalogSchemaObjects(ctx, db.DB)
+    if err != nil {
+        return nil, db.opError("verify", "schema objects", nil, err)
+    }
+
+    var statuses []SchemaObjectStatus
+    defined := make(map[string]bool)
+    for _, object := range objects {
+        key := object.Kind + " " + object.Name
+        defined[key] = true
+        status := SchemaObjectStatus{Name: object.Name, Kind: object.Kind, Status: "ok"}
+        switch {
+        case !existing[strings.ToLower(key)]:
+            status.Status = "missing"
+        case installed[key] != object.Hash:
+            status.Status = "outdated"
+        }
+        statuses = append(statuses, status)
+    }
+    var stale []SchemaObjectStatus
+    for key := range installed {
+        if !defined[key] {
+            kind, name, _ := strings.Cut(key, " ")
+            stale = append(stale, SchemaObjectStatus{Name: name, Kind: kind, Status: "stale"})
+        }
+    }
+    sort.Slice(stale, func(i, j int) bool { return stale[i].Kind+stale[i].Name < stale[j].Kind+stale[j].Name })
+    return append(statuses, stale...), nil
+}
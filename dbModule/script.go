@@ -0,0 +1,353 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+)
+
+// ScriptStatement - одна команда SQL-скрипта
+type ScriptStatement struct {
+    // Line - строка скрипта, с которой начинается команда
+    Line int
+    // SQL - текст команды без завершающей точки с запятой
+    SQL string
+    // Block - команда содержит блок BEGIN ... END (триггер, анонимный блок PL/SQL)
+    Block bool
+}
+
+// scriptTransactionWords - первые слова команд, которые сами управляют транзакцией
+var scriptTransactionWords = map[string]bool{
+    "BEGIN":    true,
+    "START":    true,
+    "COMMIT":   true,
+    "ROLLBACK": true,
+    "END":      true,
+}
+
+// RunScriptFile выполняет SQL-скрипт из файла (см. RunScript)
+func (db *Database) RunScriptFile(path string) (int, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+    return db.RunScript(path, file)
+}
+
+// RunSQL выполняет команды SQL, разделенные точкой с запятой, как скрипт (см. RunScript),
+// например заготовленный в коде скрипт наполнения базы
+func (db *Database) RunSQL(sql string) (int, error) {
+    return db.RunScript("sql", strings.NewReader(sql))
+}
+
+// RunScript выполняет команды SQL-скрипта в одной транзакции, например разовую миграцию или
+// восстановление из дампа, и возвращает число команд. Ошибка содержит имя скрипта source и строку команды.
+// Внутри InTx команды выполняются в ее транзакции и фиксируются вместе с остальными изменениями.
+// Скрипт не должен сам открывать и фиксировать транзакции. В MySQL и Oracle DDL фиксирует
+// транзакцию сам, поэтому там откатываются только изменения данных после последнего DDL
+func (db *Database) RunScript(source string, r io.Reader) (int, error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return 0, db.opError("run", "script", source, err)
+    }
+    statements, err := SplitScript(string(data))
+    if err != nil {
+        return 0, fmt.Errorf("%s:%w", source, err)
+    }
+    for _, statement := range statements {
+        if !statement.Block && scriptTransactionWords[firstWord(statement.SQL)] {
+            return 0, fmt.Errorf("%s:%d: transaction control is not allowed: the script already runs in one transaction", source, statement.Line)
+        }
+    }
+
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    if db.tx != nil {
+        if err := db.execScript(ctx, db.tx, source, statements); err != nil {
+            return 0, err
+        }
+        return len(statements), nil
+    }
+
+    done, err := db.beginOperation()
+    if err != nil {
+        return 0, db.opError("run", "script", source, err)
+    }
+    defer done()
+
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, db.opError("run", "script", source, err)
+    }
+    defer tx.Rollback()
+
+    if err := db.execScript(ctx, tx, source, statements); err != nil {
+        return 0, err
+    }
+    if err := tx.Commit(); err != nil {
+        return 0, db.opError("run", "script", source, db.timeoutError(ctx, "script", operationMigration, err))
+    }
+    return len(statements), nil
+}
+
+// execScript выполняет команды скрипта source в транзакции tx по порядку до первой ошибки
+func (db *Database) execScript(ctx context.Context, tx *sql.Tx, source string, statements []ScriptStatement) error {
+    oracle := db.driver.dialect.Name() == "oracle"
+    for _, statement := range statements {
+        query := statement.SQL
+        // PL/SQL-блок в Oracle должен заканчиваться "END;", обычная команда - без точки с запятой
+        if oracle && statement.Block {
+            query += ";"
+        }
+        started := time.Now()
+        _, err := tx.ExecContext(ctx, query)
+        err = db.timeoutError(ctx, "script", operationMigration, err)
+        db.logQuery(fmt.Sprintf("%s:%d", source, statement.Line), nil, time.Since(started), err)
+        if err != nil {
+            return fmt.Errorf("%s:%d: %w", source, statement.Line, err)
+        }
+    }
+    return nil
+}
+
+// SplitScript делит SQL-скрипт на команды по точке с запятой. Точка с запятой не разделяет команды
+// внутри строк и идентификаторов в кавычках, комментариев, блоков $tag$ ... $tag$ (PostgreSQL)
+// и блоков BEGIN ... END (тела триггеров, DECLARE/BEGIN в PL/SQL). Хранимые процедуры
+// с разделом объявлений после AS/IS не распознаются: их тело нужно оформить как BEGIN ... END
+func SplitScript(script string) ([]ScriptStatement, error) {
+    s := &scriptScanner{text: script, line: 1}
+    var statements []ScriptStatement
+    for {
+        statement, ok, err := s.next()
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            return statements, nil
+        }
+        if statement.SQL != "" {
+            statements = append(statements, statement)
+        }
+    }
+}
+
+// scriptScanner читает команды скрипта по одной
+type scriptScanner struct {
+    text string
+    pos  int
+    line int
+}
+
+// next возвращает следующую команду; ok = false в конце скрипта
+func (s *scriptScanner) next() (statement ScriptStatement, ok bool, err error) {
+    start, depth := -1, 0
+    // declared - блок открыт DECLARE, и BEGIN после объявлений не открывает новый уровень
+    declared := false
+    words := 0
+
+    for s.pos < len(s.text) {
+        c := s.text[s.pos]
+        switch {
+        case c == '\n':
+            s.line++
+            s.pos++
+            continue
+        case c == ' ' || c == '\t' || c == '\r':
+            s.pos++
+            continue
+        case strings.HasPrefix(s.text[s.pos:], "--"):
+            end := strings.IndexByte(s.text[s.pos:], '\n')
+            if end < 0 {
+                end = len(s.text) - s.pos
+            }
+            s.pos += end
+            continue
+        case strings.HasPrefix(s.text[s.pos:], "/*"):
+            if err := s.skipUntil("/*", "*/", "comment"); err != nil {
+                return statement, false, err
+            }
+            continue
+        }
+
+        if start < 0 {
+            start, statement.Line = s.pos, s.line
+        }
+        switch {
+        case c == ';' && depth == 0:
+            statement.SQL = strings.TrimSpace(s.text[start:s.pos])
+            s.pos++
+            return statement, true, nil
+        case c == '\'' || c == '"' || c == '`':
+            if err := s.skipQuoted(c); err != nil {
+                return statement, false, err
+            }
+        case c == '$':
+            if err := s.skipDollarQuoted(); err != nil {
+                return statement, false, err
+            }
+        case isWordByte(c):
+            word := strings.ToUpper(s.word())
+            words++
+            switch word {
+            case "DECLARE":
+                // DECLARE @x в SQL Server - отдельная команда, а не начало блока
+                if words == 1 && s.peek() != '@' {
+                    depth, declared, statement.Block = depth+1, true, true
+                }
+            case "BEGIN":
+                switch {
+                case words == 1 && s.transactionBegin():
+                case declared && depth == 1:
+                    declared = false
+                default:
+                    depth++
+                    statement.Block = true
+                }
+            case "CASE":
+                depth++
+            case "END":
+                switch next := strings.ToUpper(s.peekWord()); next {
+                case "IF", "LOOP", "WHILE":
+                    s.word()
+                case "CASE":
+                    s.word()
+                    depth = max(depth-1, 0)
+                default:
+                    depth = max(depth-1, 0)
+                }
+            }
+        default:
+            s.pos++
+        }
+    }
+
+    if start < 0 {
+        return statement, false, nil
+    }
+    if depth > 0 {
+        return statement, false, fmt.Errorf("%d: unterminated BEGIN ... END block", statement.Line)
+    }
+    statement.SQL = strings.TrimSpace(s.text[start:])
+    return statement, true, nil
+}
+
+// skipQuoted пропускает строку или идентификатор в кавычках quote; удвоенная кавычка - часть значения
+func (s *scriptScanner) skipQuoted(quote byte) error {
+    line := s.line
+    for s.pos++; s.pos < len(s.text); s.pos++ {
+        switch s.text[s.pos] {
+        case '\n':
+            s.line++
+        case quote:
+            if s.pos+1 < len(s.text) && s.text[s.pos+1] == quote {
+                s.pos++
+                continue
+            }
+            s.pos++
+            return nil
+        }
+    }
+    return fmt.Errorf("%d: unterminated %c quote", line, quote)
+}
+
+// skipDollarQuoted пропускает блок $tag$ ... $tag$; одиночный $ (например, параметр $1) пропускается как есть
+func (s *scriptScanner) skipDollarQuoted() error {
+    end := s.pos + 1
+    if end < len(s.text) && s.text[end] >= '0' && s.text[end] <= '9' {
+        s.pos++
+        return nil
+    }
+    for end < len(s.text) && isWordByte(s.text[end]) {
+        end++
+    }
+    if end >= len(s.text) || s.text[end] != '$' {
+        s.pos++
+        return nil
+    }
+    tag := s.text[s.pos : end+1]
+    return s.skipUntil(tag, tag, "dollar-quoted block "+tag)
+}
+
+// skipUntil пропускает текст от открывающей последовательности open до закрывающей close
+func (s *scriptScanner) skipUntil(open, close, what string) error {
+    line := s.line
+    end := strings.Index(s.text[s.pos+len(open):], close)
+    if end < 0 {
+        return fmt.Errorf("%d: unterminated %s", line, what)
+    }
+    end += s.pos + len(open) + len(close)
+    s.line += strings.Count(s.text[s.pos:end], "\n")
+    s.pos = end
+    return nil
+}
+
+// word читает слово с текущей позиции
+func (s *scriptScanner) word() string {
+    for s.pos < len(s.text) && (s.text[s.pos] == ' ' || s.text[s.pos] == '\t') {
+        s.pos++
+    }
+    start := s.pos
+    for s.pos < len(s.text) && isWordByte(s.text[s.pos]) {
+        s.pos++
+    }
+    return s.text[start:s.pos]
+}
+
+// peekWord возвращает следующее слово, не сдвигая позицию
+func (s *scriptScanner) peekWord() string {
+    pos := s.pos
+    word := s.word()
+    s.pos = pos
+    return word
+}
+
+// peek возвращает следующий значимый символ после пробелов; 0 в конце скрипта
+func (s *scriptScanner) peek() byte {
+    rest := strings.TrimLeft(s.text[s.pos:], " \t\r\n")
+    if rest == "" {
+        return 0
+    }
+    return rest[0]
+}
+
+// transactionBegin сообщает, что BEGIN в начале команды открывает транзакцию, а не блок
+func (s *scriptScanner) transactionBegin() bool {
+    if next := s.peek(); next == ';' || next == 0 {
+        return true
+    }
+    switch strings.ToUpper(s.peekWord()) {
+    case "TRANSACTION", "TRAN", "WORK", "DEFERRED", "IMMEDIATE", "EXCLUSIVE":
+        return true
+    }
+    return false
+}
+
+// isWordByte сообщает, что символ может входить в ключевое слово или идентификатор
+func isWordByte(c byte) bool {
+    return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// firstWord возвращает первое слово команды в верхнем регистре
+func firstWord(sql string) string {
+    s := &scriptScanner{text: sql}
+    return strings.ToUpper(s.word())
+}
+
+// runScript выполняет SQL-скрипт из файла в одной транзакции
+func runScript(db *Database, args []string) error {
+    path, err := parsePathArg("script", args)
+    if err != nil {
+        return err
+    }
+    count, err := db.RunScriptFile(path)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Script %s: %d statements executed\n", path, count)
+    return nil
+}
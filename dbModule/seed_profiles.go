@@ -0,0 +1,170 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+)
+
+// SeedProfileEnv - переменная окружения с профилем сидов по умолчанию, например staging (см. -seed-profile)
+const SeedProfileEnv = "DBMODULE_ENV"
+
+// SeedProfile - профиль сидов окружения: наборы фикстур, которые загружаются вместе, и задачи после загрузки.
+// Профили описываются в YAML файле (см. LoadSeedProfiles), чтобы демонстрационный набор с сотнями
+// ресторанов попадал только в профиль demo, а не в staging
+type SeedProfile struct {
+    Name    string   `yaml:"-"`
+    // Extends - профили, наборы и задачи которых идут перед своими, в порядке перечисления
+    Extends []string `yaml:"extends"`
+    // Sets - наборы фикстур каталога -fixtures (см. Seeder)
+    Sets    []string `yaml:"sets"`
+    // Hooks - задачи после загрузки по порядку: зарегистрированные RegisterSeedHook или задачи
+    // обслуживания (см. RegisterScheduledTask), например public-ids, analyze
+    Hooks   []string `yaml:"hooks"`
+}
+
+// SeedHookResult - итог одной задачи после загрузки сидов
+type SeedHookResult struct {
+    Hook   string
+    Result string
+}
+
+// SeedHookFunc выполняется после загрузки наборов профиля и возвращает итог для вывода
+type SeedHookFunc func(ctx context.Context, db *Database) (string, error)
+
+// seedHooks - зарегистрированные задачи после загрузки сидов
+var seedHooks = map[string]SeedHookFunc{}
+
+// RegisterSeedHook регистрирует задачу после загрузки сидов name; вызывается из init()
+func RegisterSeedHook(name string, run SeedHookFunc) {
+    if _, ok := seedHooks[name]; ok {
+        panic(fmt.Sprintf("seed hook %s is already registered", name))
+    }
+    seedHooks[name] = run
+}
+
+// seedHookNames возвращает отсортированные имена задач, которые можно указать в hooks профиля
+func seedHookNames() []string {
+    names := ScheduledTaskNames()
+    for name := range seedHooks {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func init() {
+    RegisterSeedHook("public-ids", func(ctx context.Context, db *Database) (string, error) {
+        assigned, err := db.AssignPublicIDs()
+        return strconv.Itoa(assigned) + " public IDs assigned", err
+    })
+}
+
+// LoadSeedProfiles читает профили сидов из YAML файла: имя профиля - ключ. Профили проверяются
+// сразу: неизвестные поля, задачи и профили в extends, циклы extends и профили без наборов - ошибки
+func LoadSeedProfiles(path string) (map[string]SeedProfile, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    data, err = expandVariables(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    var profiles map[string]SeedProfile
+    if err := yaml.UnmarshalStrict(data, &profiles); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    for name, profile := range profiles {
+        profile.Name = name
+        profiles[name] = profile
+    }
+    for name := range profiles {
+        if _, err := resolveSeedProfile(profiles, name); err != nil {
+            return nil, fmt.Errorf("%s: %v", path, err)
+        }
+    }
+    return profiles, nil
+}
+
+// resolveSeedProfile возвращает профиль name с наборами и задачами профилей extends перед его
+// собственными; набор или задача, уже подключенные раньше, не повторяются
+func resolveSeedProfile(profiles map[string]SeedProfile, name string) (SeedProfile, error) {
+    resolved := SeedProfile{Name: name}
+    var visit func(name string, chain []string) error
+    visit = func(name string, chain []string) error {
+        if slices.Contains(chain, name) {
+            return fmt.Errorf("seed profile %s extends itself: %s", chain[0], strings.Join(append(chain, name), " -> "))
+        }
+        profile, ok := profiles[name]
+        if !ok {
+            names := make([]string, 0, len(profiles))
+            for known := range profiles {
+                names = append(names, known)
+            }
+            sort.Strings(names)
+            return fmt.Errorf("unknown seed profile %q (expected one of %s)", name, strings.Join(names, ", "))
+        }
+        for _, parent := range profile.Extends {
+            if err := visit(parent, append(chain, name)); err != nil {
+                return err
+            }
+        }
+        for _, set := range profile.Sets {
+            if !slices.Contains(resolved.Sets, set) {
+                resolved.Sets = append(resolved.Sets, set)
+            }
+        }
+        for _, hook := range profile.Hooks {
+            if _, ok := seedHooks[hook]; !ok && scheduledTasks[hook] == nil {
+                return fmt.Errorf("seed profile %s: unknown hook %q (expected one of %s)", name, hook, strings.Join(seedHookNames(), ", "))
+            }
+            if !slices.Contains(resolved.Hooks, hook) {
+                resolved.Hooks = append(resolved.Hooks, hook)
+            }
+        }
+        return nil
+    }
+    if err := visit(name, nil); err != nil {
+        return SeedProfile{}, err
+    }
+    if len(resolved.Sets) == 0 {
+        return SeedProfile{}, fmt.Errorf("seed profile %s has no fixture sets", name)
+    }
+    return resolved, nil
+}
+
+// SeedProfile загружает наборы профиля name из profiles одной транзакцией, как один набор (см. SeedSets),
+// а затем выполняет его задачи по порядку и возвращает их итоги. Ошибка задачи не откатывает
+// загруженные наборы
+func (s *Seeder) SeedProfile(ctx context.Context, profiles map[string]SeedProfile, name string) ([]SeedHookResult, error) {
+    profile, err := resolveSeedProfile(profiles, name)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.SeedSets(profile.Sets...); err != nil {
+        return nil, fmt.Errorf("seed profile %s: %w", name, err)
+    }
+
+    var results []SeedHookResult
+    for _, hook := range profile.Hooks {
+        var result string
+        if run, ok := seedHooks[hook]; ok {
+            result, err = run(ctx, s.db)
+        } else {
+            result, err = s.db.RunTask(ctx, hook)
+        }
+        if err != nil {
+            return results, fmt.Errorf("seed profile %s: hook %s: %w", name, hook, err)
+        }
+        results = append(results, SeedHookResult{Hook: hook, Result: result})
+    }
+    return results, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+
+    "gopkg.in/yaml.v2"
+)
+
+// Fixture - содержимое одного файла фикстур. Рестораны ссылаются на владельцев через ref пользователя
+type Fixture struct {
+    Users       []UserFixture       `yaml:"users" json:"users"`
+    Restaurants []RestaurantFixture `yaml:"restaurants" json:"restaurants"`
+    // Scripts - SQL-скрипты набора (файлы *.sql), выполняются после пользователей и ресторанов
+    Scripts     []string            `yaml:"-" json:"-"`
+}
+
+// UserFixture описывает пользователя в фикстуре
+type UserFixture struct {
+    Ref      string  `yaml:"ref" json:"ref"`
+    Name     string  `yaml:"name" json:"name"`
+    Lastname string  `yaml:"lastname" json:"lastname"`
+    Password string  `yaml:"password" json:"password"`
+    Email    string  `yaml:"email" json:"email"`
+    Phone    *string `yaml:"phone" json:"phone"`
+    Role     string  `yaml:"role" json:"role"`
+}
+
+// RestaurantFixture описывает ресторан в фикстуре; Owner - ref пользователя из того же набора
+type RestaurantFixture struct {
+    Ref          string    `yaml:"ref" json:"ref"`
+    Name         string    `yaml:"name" json:"name"`
+    Type         string    `yaml:"type" json:"type"`
+    Keys         *string   `yaml:"keys" json:"keys"`
+    AveragePrice PriceTier `yaml:"average_price" json:"average_price"`
+    // Price - средний чек в виде "12.50 USD" (см. ParseMoney); пустой - не указан
+    Price        string    `yaml:"price,omitempty" json:"price,omitempty"`
+    Owner        string    `yaml:"owner" json:"owner"`
+}
+
+// Seeder загружает именованные наборы фикстур: каждый набор - каталог <dir>/<set> с YAML/JSON файлами
+// и SQL-скриптами для данных, которые не описываются фикстурами
+type Seeder struct {
+    db  *Database
+    dir string
+}
+
+// NewSeeder создает загрузчик фикстур из каталога dir
+func NewSeeder(db *Database, dir string) *Seeder {
+    return &Seeder{db: db, dir: dir}
+}
+
+// Seed вставляет все фикстуры набора в одной транзакции: при любой ошибке база остается без изменений.
+// Сначала создаются все пользователи набора, затем рестораны, поэтому ссылки работают между файлами;
+// SQL-скрипты набора выполняются последними в порядке имен и могут ссылаться на созданные строки
+func (s *Seeder) Seed(set string) error {
+    return s.SeedSets(set)
+}
+
+// SeedSets вставляет наборы sets в одной транзакции, как один набор из их файлов по порядку наборов:
+// рестораны набора могут ссылаться на пользователей предыдущих (см. SeedProfile)
+func (s *Seeder) SeedSets(sets ...string) error {
+    var fixture Fixture
+    for _, set := range sets {
+        loaded, err := s.load(set)
+        if err != nil {
+            return err
+        }
+        fixture.Users = append(fixture.Users, loaded.Users...)
+        fixture.Restaurants = append(fixture.Restaurants, loaded.Restaurants...)
+        fixture.Scripts = append(fixture.Scripts, loaded.Scripts...)
+    }
+
+    // сиды, запущенные двумя экземплярами одновременно, иначе вставили бы набор дважды
+    return s.db.WithLock("seed", func() error {
+        return s.seedInTx(fixture)
+    })
+}
+
+// seedInTx вставляет набор fixture в одной транзакции
+func (s *Seeder) seedInTx(fixture Fixture) error {
+    return s.db.InTx(func(tx *Database) error {
+        userIDs := make(map[string]int)
+        for _, u := range fixture.Users {
+            id, err := tx.insertUser(User{Name: u.Name, Lastname: u.Lastname, Password: u.Password, Email: u.Email, Phone: u.Phone, Role: u.Role})
+            if err != nil {
+                return fmt.Errorf("fixture user %q: %w", u.Ref, err)
+            }
+            if u.Ref != "" {
+                userIDs[u.Ref] = int(id)
+            }
+        }
+
+        for _, r := range fixture.Restaurants {
+            ownerID, ok := userIDs[r.Owner]
+            if !ok {
+                return fmt.Errorf("fixture restaurant %q: unknown owner ref %q", r.Ref, r.Owner)
+            }
+            restaurant := Restaurant{Name: r.Name, Type: r.Type, Keys: r.Keys, AveragePrice: r.AveragePrice, UserID: ownerID}
+            if r.Price != "" {
+                price, err := ParseMoney(r.Price)
+                if err != nil {
+                    return fmt.Errorf("fixture restaurant %q: %w", r.Ref, err)
+                }
+                restaurant.SetPrice(&price)
+            }
+            if _, err := tx.insertRestaurant(restaurant); err != nil {
+                return fmt.Errorf("fixture restaurant %q: %w", r.Ref, err)
+            }
+        }
+
+        for _, script := range fixture.Scripts {
+            if _, err := tx.RunScriptFile(script); err != nil {
+                return fmt.Errorf("fixture script: %w", err)
+            }
+        }
+        return nil
+    })
+}
+
+// load читает и объединяет все файлы набора в порядке имен
+func (s *Seeder) load(set string) (Fixture, error) {
+    var merged Fixture
+
+    dir := filepath.Join(s.dir, set)
+    if _, err := os.Stat(dir); err != nil {
+        return merged, fmt.Errorf("fixture set %q: %w", set, err)
+    }
+
+    var files []string
+    for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+        matches, err := filepath.Glob(filepath.Join(dir, pattern))
+        if err != nil {
+            return merged, err
+        }
+        files = append(files, matches...)
+    }
+    sort.Strings(files)
+
+    scripts, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+    if err != nil {
+        return merged, err
+    }
+    merged.Scripts = scripts
+
+    for _, file := range files {
+        data, err := ioutil.ReadFile(file)
+        if err != nil {
+            return merged, err
+        }
+
+        var fixture Fixture
+        if filepath.Ext(file) == ".json" {
+            err = json.Unmarshal(data, &fixture)
+        } else {
+            err = yaml.Unmarshal(data, &fixture)
+        }
+        if err != nil {
+            return merged, fmt.Errorf("%s: %v", file, err)
+        }
+
+        merged.Users = append(merged.Users, fixture.Users...)
+        merged.Restaurants = append(merged.Restaurants, fixture.Restaurants...)
+    }
+    return merged, nil
+}
+
+// runSeed загружает в существующую базу набор фикстур или профиль сидов окружения:
+// seed [-set S | -profile P]
+func runSeed(db *Database, args []string) error {
+    flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+    set := flags.String("set", "", "fixture set to load (default: the sets of -profile, or -fixture-set without a profile)")
+    profile := flags.String("profile", *seedProfileFlag, "seed profile from -seed-profiles to load, e.g. dev, staging or demo")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *set != "" {
+        return NewSeeder(db, *fixturesFlag).Seed(*set)
+    }
+    return seedDatabase(db, *profile)
+}
+
+// seedDatabase загружает профиль сидов profile и печатает итоги его задач, а без профиля - набор -fixture-set
+func seedDatabase(db *Database, profile string) error {
+    seeder := NewSeeder(db, *fixturesFlag)
+    if profile == "" {
+        return seeder.Seed(*fixtureSetFlag)
+    }
+    profiles, err := LoadSeedProfiles(*seedFileFlag)
+    if err != nil {
+        return err
+    }
+    results, err := seeder.SeedProfile(db.baseContext(), profiles, profile)
+    for _, result := range results {
+        if result.Result == "" {
+            result.Result = "done"
+        }
+        fmt.Printf("%s: %s\n", result.Hook, result.Result)
+    }
+    return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// SelectBuilder собирает SELECT из базового запроса реестра, условий WHERE, сортировки и страницы.
+// Значения передаются только аргументами с плейсхолдерами ?, а в текст запроса попадают
+// лишь условия и имена колонок, заданные кодом модуля
+type SelectBuilder struct {
+    base string
+    // tablePrefix подставляется вместо {{prefix}} в условиях, как в запросах реестра
+    tablePrefix string
+    conditions  []string
+    args        []interface{}
+    order       []string
+    limit       int
+    offset      int
+    err         error
+}
+
+// NewSelectBuilder начинает запрос с базового SELECT без WHERE и ORDER BY
+func NewSelectBuilder(base string) *SelectBuilder {
+    return &SelectBuilder{base: strings.TrimSuffix(strings.TrimSpace(base), ";")}
+}
+
+// Where добавляет условие, объединяемое с остальными через AND.
+// Число плейсхолдеров ? в условии должно совпадать с числом аргументов
+func (b *SelectBuilder) Where(condition string, args ...interface{}) *SelectBuilder {
+    if n := strings.Count(condition, "?"); n != len(args) {
+        b.fail(fmt.Errorf("condition %q has %d placeholders for %d arguments", condition, n, len(args)))
+        return b
+    }
+    b.conditions = append(b.conditions, strings.ReplaceAll(condition, tablePrefixPlaceholder, b.tablePrefix))
+    b.args = append(b.args, args...)
+    return b
+}
+
+// OrderBy добавляет ключ сортировки. Колонка должна быть простым идентификатором,
+// поэтому значения извне сначала сопоставляются со списком допустимых колонок
+func (b *SelectBuilder) OrderBy(column string, descending bool) *SelectBuilder {
+    if !isSimpleIdentifier(column) {
+        b.fail(fmt.Errorf("invalid order by column %q", column))
+        return b
+    }
+    if descending {
+        column += " DESC"
+    }
+    b.order = append(b.order, column)
+    return b
+}
+
+// Limit задает страницу результата; 0 - без ограничения
+func (b *SelectBuilder) Limit(limit, offset int) *SelectBuilder {
+    b.limit, b.offset = limit, offset
+    return b
+}
+
+// Build возвращает текст запроса с плейсхолдерами ? и его аргументы.
+// Постраничная выборка записывается в синтаксисе диалекта, плейсхолдеры переписывает queryText
+func (b *SelectBuilder) Build(dialect sqlDialect) (string, []interface{}, error) {
+    if b.err != nil {
+        return "", nil, b.err
+    }
+
+    query := b.base
+    if len(b.conditions) > 0 {
+        query += " WHERE " + strings.Join(b.conditions, " AND ")
+    }
+    if len(b.order) > 0 {
+        query += " ORDER BY " + strings.Join(b.order, ", ")
+    }
+    query += dialect.LimitOffset(b.limit, b.offset, len(b.order) > 0)
+    return query, b.args, nil
+}
+
+// fail запоминает первую ошибку построения, Build вернет ее вместо запроса
+func (b *SelectBuilder) fail(err error) {
+    if b.err == nil {
+        b.err = err
+    }
+}
+
+// isSimpleIdentifier проверяет, что имя состоит из букв, цифр, _ и точки (table.column)
+func isSimpleIdentifier(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, r := range name {
+        if !(r == '_' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+            return false
+        }
+    }
+    return true
+}
+
+// selectNamed начинает построение запроса с именованного запроса реестра
+func (db *Database) selectNamed(name string) (*SelectBuilder, error) {
+    base, err := db.lookupQuery(name)
+    if err != nil {
+        return nil, err
+    }
+    query := NewSelectBuilder(base)
+    query.tablePrefix = db.tablePrefix
+    return query, nil
+}
+
+// queryBuilt выполняет собранный запрос; статистика пишется под именем базового запроса
+func (db *Database) queryBuilt(name string, b *SelectBuilder) (*queryRows, error) {
+    query, args, err := b.Build(db.driver.dialect)
+    if err != nil {
+        return nil, err
+    }
+    return db.queryText(name, query, args...)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "dbModule/httpapi"
+)
+
+// SessionTTL - срок действия сессии, выданной CreateSession
+var SessionTTL = 30 * 24 * time.Hour
+
+// tokenBytes - длина случайной части токенов сессий и сброса пароля
+const tokenBytes = 32
+
+// Session - сессия пользователя
+type Session struct {
+    // Token возвращается только из CreateSession: в базе хранится лишь его хеш
+    Token     string
+    UserID    int
+    TenantID  int
+    CreatedAt time.Time
+    ExpiresAt time.Time
+}
+
+// CreateSession выдает пользователю новую сессию со случайным токеном на SessionTTL.
+// Токен нужно передать клиенту: восстановить его по базе нельзя
+func (db *Database) CreateSession(userID int) (Session, error) {
+    token, err := db.newID()
+    if err != nil {
+        return Session{}, db.opError("create", "session", userID, err)
+    }
+
+    now := db.now().UTC()
+    session := Session{
+        Token:     token,
+        UserID:    userID,
+        TenantID:  db.tenant,
+        CreatedAt: now,
+        ExpiresAt: now.Add(SessionTTL),
+    }
+    _, err = db.execNamed("sessions.insert", hashToken(session.Token), userID, session.CreatedAt, session.ExpiresAt, db.tenant)
+    if db.driver.isForeignKeyError(err) {
+        err = fmt.Errorf("user %d does not exist: %w", userID, ErrForeignKeyViolation)
+    }
+    if err != nil {
+        return Session{}, db.opError("create", "session", userID, err)
+    }
+    if err := db.touchUserActivity(userID); err != nil {
+        return Session{}, db.opError("create", "session", userID, err)
+    }
+    return session, nil
+}
+
+// ValidateSession возвращает сессию по токену или ErrInvalidSession,
+// если токен неизвестен, отозван или просрочен. Сессия читается из основной базы:
+// отставшая реплика не должна принимать отозванный токен или отвергать только что выданный
+func (db *Database) ValidateSession(token string) (Session, error) {
+    rows, err := db.WithPrimary().queryNamed("sessions.select_valid", hashToken(token), db.tenant, db.now().UTC())
+    if err != nil {
+        return Session{}, db.opError("validate", "session", nil, err)
+    }
+    defer rows.Close()
+
+    if !rows.Next() {
+        if err := rows.Err(); err != nil {
+            return Session{}, db.opError("validate", "session", nil, err)
+        }
+        return Session{}, ErrInvalidSession
+    }
+    session := Session{Token: token}
+    if err := rows.Scan(&session.UserID, &session.CreatedAt, &session.ExpiresAt, &session.TenantID); err != nil {
+        return Session{}, db.opError("validate", "session", nil, err)
+    }
+    return session, nil
+}
+
+// RequestUser возвращает ID пользователя, аутентифицированного httpapi.Authenticator, или из сессии
+// в заголовке Authorization: Bearer <токен>, или "", если заголовка нет или сессия недействительна
+// (см. httpapi.RateLimitOptions.User)
+func (db *Database) RequestUser(r *http.Request) string {
+    if user, ok := httpapi.UserFromContext(r.Context()); ok {
+        return user
+    }
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || token == "" {
+        return ""
+    }
+    session, err := db.ValidateSession(strings.TrimSpace(token))
+    if err != nil {
+        return ""
+    }
+    return strconv.Itoa(session.UserID)
+}
+
+// RevokeSession отзывает сессию; отзыв неизвестного токена не считается ошибкой
+func (db *Database) RevokeSession(token string) error {
+    _, err := db.execNamed("sessions.delete", hashToken(token), db.tenant)
+    return db.opError("revoke", "session", nil, err)
+}
+
+// RevokeUserSessions отзывает все сессии пользователя, например после смены пароля
+func (db *Database) RevokeUserSessions(userID int) error {
+    _, err := db.execNamed("sessions.delete_by_user", userID, db.tenant)
+    return db.opError("revoke", "sessions", userID, err)
+}
+
+// DeleteExpiredSessions удаляет просроченные сессии всех площадок и возвращает их число
+func (db *Database) DeleteExpiredSessions() (int64, error) {
+    result, err := db.execNamed("sessions.delete_expired", db.now().UTC())
+    if err != nil {
+        return 0, db.opError("delete expired", "sessions", nil, err)
+    }
+    return result.RowsAffected()
+}
+
+// CleanupSessions удаляет просроченные сессии, токены сброса пароля и ключи идемпотентности каждые interval, пока не отменен ctx.
+// Запускается в отдельной горутине: go db.CleanupSessions(ctx, time.Hour). Ошибки только логируются,
+// так как просроченные сессии и без очистки не проходят ValidateSession
+func (db *Database) CleanupSessions(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if _, err := db.DeleteExpiredSessions(); err != nil {
+                log.Printf("session cleanup: %v", err)
+            }
+            if _, err := db.DeleteExpiredPasswordResets(); err != nil {
+                log.Printf("password reset cleanup: %v", err)
+            }
+            if _, err := db.DeleteExpiredIdempotencyKeys(); err != nil {
+                log.Printf("idempotency key cleanup: %v", err)
+            }
+        }
+    }
+}
+
+// newToken возвращает случайный токен для передачи клиенту (источник идентификаторов по умолчанию, см. SetIDGenerator)
+func newToken() (string, error) {
+    raw := make([]byte, tokenBytes)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken возвращает хеш токена, под которым он хранится в базе (сессии, сброс пароля):
+// утечка таблицы не дает готовых токенов
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// runSessionGC удаляет просроченные сессии и токены сброса пароля
+func runSessionGC(db *Database, args []string) error {
+    flags := flag.NewFlagSet("session-gc", flag.ContinueOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    removed, err := db.DeleteExpiredSessions()
+    if err != nil {
+        return err
+    }
+    resets, err := db.DeleteExpiredPasswordResets()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Removed %d expired sessions, %d password reset tokens\n", removed, resets)
+    return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+)
+
+// SnapshotOptions задает, какую часть базы выгружать в фикстуры
+type SnapshotOptions struct {
+    // Users - максимальное число пользователей в снимке
+    Users int
+    // Filter отбирает рестораны; в снимок попадают их владельцы.
+    // Пустой фильтр берет первых пользователей вместе со всеми их ресторанами
+    Filter RestaurantFilter
+}
+
+// Snapshot выгружает небольшое согласованное подмножество данных в виде фикстуры:
+// каждый ресторан снимка ссылается на владельца из того же снимка. Персональные данные
+// пользователей заменяются обезличенными, пароли не выгружаются
+func (db *Database) Snapshot(options SnapshotOptions) (Fixture, error) {
+    var fixture Fixture
+    err := db.InTx(func(tx *Database) error {
+        owners, matching, err := tx.snapshotOwners(options)
+        if err != nil {
+            return err
+        }
+
+        for i, id := range owners {
+            aggregate, err := tx.GetUserWithRestaurants(id)
+            if err != nil {
+                return err
+            }
+            ref := fmt.Sprintf("user%d", i+1)
+            fixture.Users = append(fixture.Users, anonymizeUser(ref, i+1, aggregate.User))
+
+            for _, restaurant := range aggregate.Restaurants {
+                if matching != nil && !matching[restaurant.ID] {
+                    continue
+                }
+                restaurantFixture := RestaurantFixture{
+                    Ref:          fmt.Sprintf("restaurant%d", restaurant.ID),
+                    Name:         restaurant.Name,
+                    Type:         restaurant.Type,
+                    Keys:         restaurant.Keys,
+                    AveragePrice: restaurant.AveragePrice,
+                    Owner:        ref,
+                }
+                if price, ok := restaurant.Price(); ok {
+                    restaurantFixture.Price = price.String()
+                }
+                fixture.Restaurants = append(fixture.Restaurants, restaurantFixture)
+            }
+        }
+        return nil
+    })
+    return fixture, db.opError("snapshot", "database", nil, err)
+}
+
+// snapshotOwners выбирает ID пользователей снимка и, если задан фильтр, ID подходящих под него
+// ресторанов. Users <= 0 снимает ограничение на число пользователей
+func (db *Database) snapshotOwners(options SnapshotOptions) ([]int, map[int]bool, error) {
+    var ids []int
+    full := func() bool { return options.Users > 0 && len(ids) >= options.Users }
+
+    if len(options.Filter.values()) == 0 {
+        for user, err := range db.SelectUsersIter() {
+            if err != nil {
+                return nil, nil, err
+            }
+            if full() {
+                break
+            }
+            ids = append(ids, user.ID)
+        }
+        return ids, nil, nil
+    }
+
+    restaurants, err := db.SelectRestaurantsWhere(options.Filter)
+    if err != nil {
+        return nil, nil, err
+    }
+    matching := make(map[int]bool)
+    seen := make(map[int]bool)
+    for _, restaurant := range restaurants {
+        matching[restaurant.ID] = true
+        // рестораны без владельца не попадают в снимок: ссылаться им не на кого
+        if restaurant.UserID == 0 || seen[restaurant.UserID] || full() {
+            continue
+        }
+        seen[restaurant.UserID] = true
+        ids = append(ids, restaurant.UserID)
+    }
+    return ids, matching, nil
+}
+
+// anonymizeUser заменяет персональные данные пользователя обезличенными значениями
+func anonymizeUser(ref string, n int, user User) UserFixture {
+    fixture := UserFixture{
+        Ref:      ref,
+        Name:     fmt.Sprintf("User%d", n),
+        Lastname: "Snapshot",
+        Email:    fmt.Sprintf("%s@example.invalid", ref),
+        Role:     user.Role,
+    }
+    if user.Phone != nil {
+        fixture.Phone = stringPtr(fmt.Sprintf("+7000%07d", n))
+    }
+    return fixture
+}
+
+// parseRestaurantFilter разбирает фильтр вида "type=italian,min_price=2,max_price=4,name_prefix=Pa"
+func parseRestaurantFilter(expression string) (RestaurantFilter, error) {
+    var filter RestaurantFilter
+    if expression == "" {
+        return filter, nil
+    }
+
+    for _, part := range strings.Split(expression, ",") {
+        key, value, ok := strings.Cut(part, "=")
+        if !ok {
+            return filter, fmt.Errorf("invalid filter condition %q, expected key=value", part)
+        }
+        if err := filter.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+            return filter, err
+        }
+    }
+    return filter, nil
+}
+
+// runSnapshot сохраняет обезличенный снимок базы как набор фикстур для команды seed
+func runSnapshot(db *Database, args []string) error {
+    flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+    set := flags.String("set", "snapshot", "name of the fixture set to write")
+    users := flags.Int("users", 100, "maximum number of users")
+    filterExpression := flags.String("filter", "", "restaurant filter, e.g. type=italian,min_price=2")
+    force := flags.Bool("force", false, "overwrite an existing snapshot file")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    filter, err := parseRestaurantFilter(*filterExpression)
+    if err != nil {
+        return err
+    }
+    fixture, err := db.Snapshot(SnapshotOptions{Users: *users, Filter: filter})
+    if err != nil {
+        return err
+    }
+
+    data, err := yaml.Marshal(fixture)
+    if err != nil {
+        return err
+    }
+    dir := filepath.Join(*fixturesFlag, *set)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    path := filepath.Join(dir, "snapshot.yaml")
+    if _, err := os.Stat(path); err == nil && !*force {
+        return fmt.Errorf("%s already exists, use -force to overwrite", path)
+    }
+    if err := ioutil.WriteFile(path, data, 0644); err != nil {
+        return err
+    }
+
+    fmt.Printf("Saved %d users and %d restaurants to %s\n", len(fixture.Users), len(fixture.Restaurants), path)
+    return nil
+}
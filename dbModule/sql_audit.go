@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// SQLAudit - режим проверки параметризации. Проверяющий помечает входные данные метками
+// (Mark), и перед выполнением каждого запроса его текст ищется на эти метки: метка в тексте
+// означает, что значение извне попало в SQL, а не в аргумент запроса. Находки копятся и не
+// мешают выполнению, чтобы один прогон показал все такие пути. Используется командой
+// sql-fuzz (см. RunSQLFuzz) и в тестах (см. AuditTestSQL); в рабочем режиме не включается
+type SQLAudit struct {
+    mu       sync.Mutex
+    markers  []string
+    findings []SQLAuditFinding
+}
+
+// SQLAuditFinding - запрос, в текст которого попала метка входных данных
+type SQLAuditFinding struct {
+    Marker string
+    Query  string
+}
+
+// NewSQLAudit создает проверку с начальными метками
+func NewSQLAudit(markers ...string) *SQLAudit {
+    audit := &SQLAudit{}
+    audit.Mark(markers...)
+    return audit
+}
+
+// Mark добавляет метки. Метка должна быть достаточно редкой, чтобы не встретиться
+// в самих запросах реестра; регистр при поиске не важен
+func (a *SQLAudit) Mark(markers ...string) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for _, marker := range markers {
+        if marker != "" {
+            a.markers = append(a.markers, strings.ToLower(marker))
+        }
+    }
+}
+
+// Findings возвращает находки в порядке выполнения запросов
+func (a *SQLAudit) Findings() []SQLAuditFinding {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return append([]SQLAuditFinding(nil), a.findings...)
+}
+
+// Err возвращает ошибку со всеми находками или nil
+func (a *SQLAudit) Err() error {
+    findings := a.Findings()
+    if len(findings) == 0 {
+        return nil
+    }
+    lines := make([]string, len(findings))
+    for i, finding := range findings {
+        lines[i] = fmt.Sprintf("  %s in %s", finding.Marker, finding.Query)
+    }
+    return fmt.Errorf("input reached SQL text in %d queries:\n%s", len(findings), strings.Join(lines, "\n"))
+}
+
+// check ищет метки в тексте запроса
+func (a *SQLAudit) check(query string) {
+    lower := strings.ToLower(query)
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for _, marker := range a.markers {
+        if strings.Contains(lower, marker) {
+            a.findings = append(a.findings, SQLAuditFinding{Marker: marker, Query: query})
+        }
+    }
+}
+
+// WithSQLAudit возвращает копию Database, тексты всех запросов которой проверяются audit
+func (db *Database) WithSQLAudit(audit *SQLAudit) *Database {
+    audited := *db
+    audited.sqlAudit = audit
+    return &audited
+}
+
+// auditedConn проверяет текст запроса перед выполнением (см. SQLAudit)
+type auditedConn struct {
+    Executor
+    audit *SQLAudit
+}
+
+// ExecContext выполняет запрос без строк результата
+func (c auditedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    c.audit.check(query)
+    return c.Executor.ExecContext(ctx, query, args...)
+}
+
+// QueryContext выполняет запрос, возвращающий строки
+func (c auditedConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+    c.audit.check(query)
+    return c.Executor.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext выполняет запрос, возвращающий одну строку
+func (c auditedConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+    c.audit.check(query)
+    return c.Executor.QueryRowContext(ctx, query, args...)
+}
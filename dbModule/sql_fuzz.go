@@ -0,0 +1,328 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// sqlFuzzPayloads - встроенный набор враждебных входных данных: кавычки и комментарии SQL,
+// составные команды, плейсхолдеры разных СУБД, подстановочные символы LIKE, NUL и длинные строки
+var sqlFuzzPayloads = []string{
+    `'`,
+    `"`,
+    "`",
+    `\`,
+    `' OR '1'='1`,
+    `' OR 1=1 --`,
+    `'; DROP TABLE users; --`,
+    `"); DELETE FROM restaurants; --`,
+    `1) OR (1=1`,
+    `' UNION SELECT password, email FROM users --`,
+    `' || (SELECT password FROM users LIMIT 1) || '`,
+    `/* comment */`,
+    `name DESC; DELETE FROM users`,
+    `price, (SELECT 1)`,
+    `?`,
+    `$1`,
+    `:1`,
+    `@p1`,
+    `%`,
+    `_`,
+    `%_\%`,
+    "\x00",
+    "line\nbreak\r\n",
+    `Пельменная ' --`,
+    `😀'`,
+    strings.Repeat(`'`, 64),
+    strings.Repeat("x", 1000),
+}
+
+// sqlFuzzBrokenPattern - ошибки СУБД, означающие, что входные данные исказили текст запроса
+var sqlFuzzBrokenPattern = regexp.MustCompile(`(?i)syntax error|incorrect syntax|unrecognized token|incomplete input|no such column|ORA-00933|ORA-00904`)
+
+// SQLFuzzFailure - находка RunSQLFuzz: входные данные попали в текст запроса, исказили его
+// или вызвали панику
+type SQLFuzzFailure struct {
+    Step    string
+    Input   string
+    Problem string
+}
+
+func (f SQLFuzzFailure) String() string {
+    return fmt.Sprintf("%s with %q: %s", f.Step, f.Input, f.Problem)
+}
+
+// SQLFuzzReport - итоги прогона RunSQLFuzz
+type SQLFuzzReport struct {
+    Inputs   int
+    Steps    int
+    Failures []SQLFuzzFailure
+}
+
+// RunSQLFuzz прогоняет каждый из inputs через публичные API записи и поиска - прямые вызовы
+// и HTTP API - в режиме проверки параметризации (см. SQLAudit). К каждому входу дописывается
+// своя метка, поэтому находка указывает на конкретный вход и шаг. Находки - метка в тексте
+// запроса, ошибка разбора SQL от СУБД и паника; ожидаемые ошибки проверки данных находками
+// не считаются. db должна быть чистой базой с примененными миграциями: прогон пишет в нее
+func RunSQLFuzz(db *Database, inputs []string) (SQLFuzzReport, error) {
+    report := SQLFuzzReport{Inputs: len(inputs)}
+    for i, input := range inputs {
+        marker := fmt.Sprintf("zqfuzz%dq", i)
+        audit := NewSQLAudit(marker)
+        // входы служат и паролями: политика паролей отвергла бы короткие раньше, чем они дойдут до SQL
+        fuzz := &sqlFuzzRun{db: db.withoutPasswordPolicy().WithSQLAudit(audit), input: input, value: input + marker, report: &report}
+        fuzz.run()
+
+        for _, finding := range audit.Findings() {
+            report.Failures = append(report.Failures, SQLFuzzFailure{Step: "audit", Input: input, Problem: "input reached SQL text: " + finding.Query})
+        }
+    }
+
+    // враждебный вход не должен был удалить или переписать таблицы
+    if _, err := db.CountUsers(); err != nil {
+        return report, fmt.Errorf("database damaged after fuzzing: %w", err)
+    }
+    if _, err := db.CountRestaurants(RestaurantFilter{}); err != nil {
+        return report, fmt.Errorf("database damaged after fuzzing: %w", err)
+    }
+    return report, nil
+}
+
+// errSQLFuzzServerError - HTTP API ответил 500: ошибка базы не была распознана как ожидаемая
+var errSQLFuzzServerError = errors.New("internal server error")
+
+// sqlFuzzRun - прогон одного входа; value - вход с меткой
+type sqlFuzzRun struct {
+    db     *Database
+    input  string
+    value  string
+    report *SQLFuzzReport
+}
+
+// step выполняет шаг и записывает находку, если он исказил запрос или упал
+func (f *sqlFuzzRun) step(name string, fn func() error) {
+    f.report.Steps++
+    defer func() {
+        if r := recover(); r != nil {
+            f.report.Failures = append(f.report.Failures, SQLFuzzFailure{Step: name, Input: f.input, Problem: fmt.Sprintf("panic: %v", r)})
+        }
+    }()
+    err := fn()
+    if err != nil && (errors.Is(err, errSQLFuzzServerError) || sqlFuzzBrokenPattern.MatchString(err.Error())) {
+        f.report.Failures = append(f.report.Failures, SQLFuzzFailure{Step: name, Input: f.input, Problem: err.Error()})
+    }
+}
+
+// run вызывает API с входом во всех строковых параметрах
+func (f *sqlFuzzRun) run() {
+    db, v := f.db, f.value
+    email := strings.NewReplacer("@", "", " ", "").Replace(v) + "@example.invalid"
+
+    var userID, restaurantID int
+    f.step("InsertUser", func() error {
+        id, err := db.insertUser(User{Name: v, Lastname: v, Password: v, Email: email, Phone: stringPtr(v)})
+        userID = int(id)
+        return err
+    })
+    f.step("UpsertUser", func() error {
+        return db.UpsertUser(User{Name: v, Lastname: v, Password: v, Email: email, Phone: stringPtr(v)})
+    })
+    f.step("UpdateUserFields", func() error {
+        _, err := db.UpdateUserFields(userID, map[string]interface{}{"name": v, "lastname": v, "phone": v})
+        return err
+    })
+    f.step("UpdateUserFields field name", func() error {
+        _, err := db.UpdateUserFields(userID, map[string]interface{}{v: v})
+        return err
+    })
+    f.step("SelectUsersByRole", func() error {
+        _, err := db.SelectUsersByRole(v)
+        return err
+    })
+    f.step("UsersPage", func() error {
+        _, err := db.UsersPage(v, PageRequest{Cursor: v, Total: TotalExact})
+        return err
+    })
+    f.step("GeneratePasswordResetToken", func() error {
+        _, err := db.GeneratePasswordResetToken(email)
+        return err
+    })
+    f.step("ResetPassword", func() error {
+        return db.ResetPassword(v, v)
+    })
+    f.step("ValidateSession", func() error {
+        _, err := db.ValidateSession(v)
+        return err
+    })
+    f.step("RevokeSession", func() error {
+        return db.RevokeSession(v)
+    })
+
+    f.step("InsertRestaurant", func() error {
+        id, err := db.insertRestaurant(Restaurant{Name: v, Type: v, Keys: stringPtr(v), AveragePrice: 1, UserID: userID})
+        restaurantID = int(id)
+        return err
+    })
+    f.step("UpsertRestaurant", func() error {
+        return db.UpsertRestaurant(Restaurant{Name: v, Type: v, Keys: stringPtr(v), AveragePrice: 2, UserID: userID})
+    })
+    f.step("UpdateRestaurantFields", func() error {
+        _, err := db.UpdateRestaurantFields(SystemActor, restaurantID, map[string]interface{}{"type": v, "keys": v})
+        return err
+    })
+    filter := RestaurantFilter{Type: v, NamePrefix: v}
+    f.step("SelectRestaurantsWhere", func() error {
+        _, err := db.SelectRestaurantsWhere(filter, RestaurantSort{Field: RestaurantSortField(v)})
+        return err
+    })
+    f.step("RestaurantsPage", func() error {
+        _, err := db.RestaurantsPage(filter, PageRequest{Cursor: v, Total: TotalEstimate})
+        return err
+    })
+    f.step("CountRestaurants", func() error {
+        _, err := db.CountRestaurants(filter)
+        return err
+    })
+    f.step("AverageRestaurantPrice", func() error {
+        _, err := db.AverageRestaurantPrice(filter)
+        return err
+    })
+    f.step("InsertMenuItems", func() error {
+        return db.InsertMenuItems([]MenuItem{{RestaurantID: restaurantID, Name: v, Category: v}})
+    })
+    f.step("InsertReview", func() error {
+        return db.InsertReview(&Review{UserID: userID, RestaurantID: restaurantID, Rating: 3, Comment: v})
+    })
+    f.step("ImportListings", func() error {
+        document, err := json.Marshal(ListingDocument{Version: ListingVersion, Restaurants: []RestaurantListing{{
+            Name: v + " listing", Type: v, AveragePrice: 1, OwnerID: userID,
+            Menu: []ListingMenuItem{{Name: v, Category: v}},
+        }}})
+        if err != nil {
+            return err
+        }
+        _, err = db.ImportListings(SystemActor, document)
+        return err
+    })
+
+    f.step("GetSetting", func() error {
+        _, _, err := db.GetSetting(v)
+        return err
+    })
+    f.step("IncrementCounter", func() error {
+        _, err := db.IncrementCounter(v)
+        return err
+    })
+    f.step("AuditTrail", func() error {
+        _, err := db.AuditTrail(v, userID)
+        return err
+    })
+    f.step("Export", func() error {
+        _, err := db.Export(io.Discard, v, CompressionNone)
+        return err
+    })
+
+    f.runHTTP(restaurantID)
+}
+
+// runHTTP отправляет вход в параметрах пути, строки запроса и тела HTTP API.
+// Ответ 500 означает необработанную ошибку базы и тоже считается находкой
+func (f *sqlFuzzRun) runHTTP(restaurantID int) {
+    handler := f.db.Handler()
+    v := url.QueryEscape(f.value)
+    requests := []struct {
+        method, target, body string
+    }{
+        {"GET", "/restaurants?type=" + v + "&name_prefix=" + v + "&sort=" + v + "&cursor=" + v, ""},
+        {"GET", "/restaurants?min_price=" + v + "&user_id=" + v + "&total=" + v, ""},
+        {"GET", "/restaurants/" + url.PathEscape(f.value) + "/reviews?cursor=" + v, ""},
+        {"GET", "/export/" + url.PathEscape(f.value), ""},
+        {"PATCH", fmt.Sprintf("/restaurants/%d", restaurantID), fmt.Sprintf(`{%q: 1, "type": %q}`, f.value, f.value)},
+        {"PATCH", fmt.Sprintf("/restaurants/%d", restaurantID), fmt.Sprintf(`{"type": %q}`, f.value)},
+    }
+    for _, r := range requests {
+        name := r.method + " " + strings.SplitN(r.target, "?", 2)[0]
+        f.step("HTTP "+name, func() error {
+            request := httptest.NewRequest(r.method, "/", strings.NewReader(r.body))
+            // путь с произвольными байтами собирается вручную: NewRequest разобрал бы его как URL
+            parsed, err := url.Parse(r.target)
+            if err != nil {
+                return nil
+            }
+            request.URL = parsed
+            recorder := httptest.NewRecorder()
+            handler.ServeHTTP(recorder, request)
+            if recorder.Code == http.StatusInternalServerError {
+                return errSQLFuzzServerError
+            }
+            return nil
+        })
+    }
+}
+
+// runSQLFuzz прогоняет RunSQLFuzz на временной базе SQLite и завершается ошибкой при находках
+func runSQLFuzz(db *Database, args []string) error {
+    flags := flag.NewFlagSet("sql-fuzz", flag.ContinueOnError)
+    corpus := flags.String("inputs", "", "file with additional hostile inputs, one per line")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if err := db.requireSQLite("sql-fuzz"); err != nil {
+        return err
+    }
+
+    inputs := append([]string(nil), sqlFuzzPayloads...)
+    if *corpus != "" {
+        file, err := os.Open(*corpus)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        scanner := bufio.NewScanner(file)
+        for scanner.Scan() {
+            inputs = append(inputs, scanner.Text())
+        }
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+    }
+
+    dir, err := ioutil.TempDir("", "dbmodule-fuzz")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+    scratch, err := NewDatabaseWithConfig(Config{Driver: db.driver.name, DSN: filepath.Join(dir, "fuzz.db"), Timeouts: db.timeouts}, db.queries)
+    if err != nil {
+        return err
+    }
+    defer scratch.Close()
+    if err := scratch.Migrate(); err != nil {
+        return err
+    }
+
+    report, err := RunSQLFuzz(scratch, inputs)
+    if err != nil {
+        return err
+    }
+    for _, failure := range report.Failures {
+        fmt.Println(failure)
+    }
+    if len(report.Failures) > 0 {
+        return fmt.Errorf("%d problems in %d steps over %d inputs", len(report.Failures), report.Steps, report.Inputs)
+    }
+    fmt.Printf("%d inputs, %d steps: all input stayed in bound parameters\n", report.Inputs, report.Steps)
+    return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+// FuzzSQLInput начинает со встроенного набора sql-fuzz и ищет входы, которые попадают в текст
+// запроса, ломают его разбор или роняют API:
+//   go test -run '^$' -fuzz FuzzSQLInput -fuzztime 1m
+func FuzzSQLInput(f *testing.F) {
+    for _, payload := range sqlFuzzPayloads {
+        f.Add(payload)
+    }
+    f.Fuzz(func(t *testing.T, input string) {
+        checkSQLInput(t, input)
+    })
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+    "unicode/utf8"
+)
+
+// SQLShellMaxRows - сколько строк результата команда sql выводит по умолчанию
+var SQLShellMaxRows = 200
+
+// sqlShellHistoryFile - файл истории команды sql в домашнем каталоге
+const sqlShellHistoryFile = ".dbmodule_sql_history"
+
+// sqlReadOnlyWords - первые слова команд, которые sql выполняет без -write
+// и которые выполняются как запросы со строками результата
+var sqlReadOnlyWords = map[string]bool{"SELECT": true, "WITH": true, "VALUES": true, "EXPLAIN": true, "SHOW": true, "DESCRIBE": true, "DESC": true, "PRAGMA": true}
+
+// sqlShellHelp - подсказка по командам sql
+const sqlShellHelp = `Enter SQL statements ending with ";"; a statement may span several lines.
+Placeholders ? take the values of \bind, e.g. \bind 42 "Some name" null, then SELECT ... WHERE id = ? AND name = ?;
+Commands:
+  \bind VALUE ...   values of ? in the next statement: numbers, null, true, false or text ("quoted" with spaces)
+  \tables           list tables
+  \history [N]      print the last N statements (default 20)
+  \help, \quit`
+
+// sqlShell - состояние сеанса команды sql
+type sqlShell struct {
+    db          *Database
+    out         io.Writer
+    write       bool
+    interactive bool
+    maxRows     int
+    // params - значения \bind для следующей команды
+    params  []interface{}
+    history []string
+    // historyPath - файл истории; "" - история не сохраняется
+    historyPath string
+    confirm     *bufio.Scanner
+}
+
+// runSQLShell выполняет команду sql: интерактивный SQL по DSN -db для операторов, у которых на машине
+// есть только этот бинарник. По умолчанию сеанс только читает: принимаются команды SELECT, WITH, EXPLAIN
+// и подобные, и каждая выполняется в транзакции, которая затем откатывается, так что изменения не
+// сохранятся, даже если команда их сделала. С -write команды фиксируются по одной, режим обслуживания
+// соблюдается, а UPDATE и DELETE без WHERE требуют подтверждения. Значения секретных колонок не выводятся,
+// площадки SQL не ограничивает
+func runSQLShell(db *Database, args []string) error {
+    flags := flag.NewFlagSet("sql", flag.ContinueOnError)
+    write := flags.Bool("write", false, "allow statements that change data or schema; each is committed on its own")
+    maxRows := flags.Int("max-rows", SQLShellMaxRows, "rows of a result to print")
+    history := flags.String("history", defaultSQLShellHistory(), "file where statements are saved between sessions (empty disables)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *maxRows <= 0 {
+        return fmt.Errorf("max rows must be positive, got %d", *maxRows)
+    }
+
+    scanner := bufio.NewScanner(os.Stdin)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    s := &sqlShell{db: db, out: os.Stdout, write: *write, interactive: isTerminal(os.Stdin), maxRows: *maxRows, historyPath: *history, confirm: scanner}
+    s.loadHistory()
+    if s.interactive {
+        mode := "read-only, changes are rolled back; restart with -write to change data"
+        if s.write {
+            mode = "WRITE mode, each statement is committed"
+        }
+        fmt.Fprintf(s.out, "SQL shell on %s database (%s). Type \\help for commands.\n", db.driver.dialect.Name(), mode)
+    }
+
+    var statement strings.Builder
+    for {
+        if s.interactive {
+            switch {
+            case statement.Len() > 0:
+                fmt.Fprint(s.out, "  ...> ")
+            case s.write:
+                fmt.Fprint(s.out, "sql(write)> ")
+            default:
+                fmt.Fprint(s.out, "sql> ")
+            }
+        }
+        if !scanner.Scan() {
+            if rest := strings.TrimSpace(statement.String()); rest != "" {
+                s.execute(rest)
+            }
+            return scanner.Err()
+        }
+        line := scanner.Text()
+        if statement.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+            words, err := splitBrowseArgs(strings.TrimSpace(line))
+            if err != nil {
+                fmt.Fprintln(s.out, "error:", err)
+                continue
+            }
+            if words[0] == `\quit` || words[0] == `\q` {
+                return nil
+            }
+            if err := s.command(words[0], words[1:]); err != nil {
+                fmt.Fprintln(s.out, "error:", err)
+            }
+            continue
+        }
+        statement.WriteString(line)
+        statement.WriteString("\n")
+        if strings.HasSuffix(strings.TrimSpace(line), ";") {
+            s.execute(strings.TrimSpace(statement.String()))
+            statement.Reset()
+        }
+    }
+}
+
+// defaultSQLShellHistory возвращает путь файла истории в домашнем каталоге или "", если его нет
+func defaultSQLShellHistory() string {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(home, sqlShellHistoryFile)
+}
+
+// command выполняет команду сеанса, начинающуюся с \
+func (s *sqlShell) command(name string, args []string) error {
+    switch name {
+    case `\help`, `\h`, `\?`:
+        fmt.Fprintln(s.out, sqlShellHelp)
+    case `\bind`:
+        s.params = make([]interface{}, len(args))
+        for i, arg := range args {
+            s.params[i] = parseSQLShellValue(arg)
+        }
+    case `\tables`:
+        tables, err := s.db.introspectStrings("introspection.tables")
+        if err != nil {
+            return err
+        }
+        for _, table := range s.db.ownTables(tables) {
+            fmt.Fprintln(s.out, table)
+        }
+    case `\history`:
+        n := 20
+        if len(args) > 0 {
+            var err error
+            if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+                return fmt.Errorf("usage: \\history [N], N positive")
+            }
+        }
+        for _, statement := range s.history[max(len(s.history)-n, 0):] {
+            fmt.Fprintln(s.out, statement)
+        }
+    default:
+        return fmt.Errorf("unknown command %s, type \\help for commands", name)
+    }
+    return nil
+}
+
+// parseSQLShellValue переводит значение \bind в параметр запроса: число, NULL, логическое значение или текст
+func parseSQLShellValue(value string) interface{} {
+    switch strings.ToLower(value) {
+    case "null":
+        return nil
+    case "true":
+        return true
+    case "false":
+        return false
+    }
+    if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+        return n
+    }
+    if f, err := strconv.ParseFloat(value, 64); err == nil {
+        return f
+    }
+    return value
+}
+
+// execute выполняет одну команду, печатает результат или ошибку и сохраняет команду в истории
+func (s *sqlShell) execute(statement string) {
+    params := s.params
+    s.params = nil
+    s.remember(statement)
+    started := time.Now()
+    if err := s.run(statement, params); err != nil {
+        fmt.Fprintln(s.out, "error:", err)
+        return
+    }
+    if s.interactive {
+        fmt.Fprintf(s.out, "-- %s\n", time.Since(started).Round(time.Millisecond))
+    }
+}
+
+// run проверяет команду по режиму сеанса и выполняет ее в своей транзакции: без -write она
+// откатывается всегда, с -write - фиксируется, если команда выполнилась
+func (s *sqlShell) run(statement string, params []interface{}) error {
+    statements, err := SplitScript(statement)
+    if err != nil {
+        return err
+    }
+    if len(statements) != 1 {
+        return fmt.Errorf("enter one statement at a time, got %d", len(statements))
+    }
+    query := statements[0].SQL
+    code := sqlCode(query)
+    word := firstWord(code)
+    if scriptTransactionWords[word] {
+        return fmt.Errorf("transaction control is not allowed: every statement runs in its own transaction")
+    }
+    if placeholders := strings.Count(code, "?"); placeholders != len(params) {
+        return fmt.Errorf("the statement has %d ? placeholders, but %d values are bound with \\bind", placeholders, len(params))
+    }
+
+    op := operationRead
+    if !s.write {
+        // PRAGMA с присваиванием меняет настройки соединения, а их откат транзакции не отменяет
+        if !sqlReadOnlyWords[word] || word == "PRAGMA" && strings.Contains(code, "=") {
+            return fmt.Errorf("%s is not allowed in read-only mode: restart with sql -write to change data", word)
+        }
+    } else {
+        op = operationWrite
+        if err := s.db.checkWritable("sql.shell"); err != nil {
+            return err
+        }
+        if (word == "UPDATE" || word == "DELETE") && !hasSQLWord(code, "WHERE") {
+            if err := s.confirmUnfiltered(word); err != nil {
+                return err
+            }
+        }
+    }
+
+    ctx, cancel := s.db.operationContext(op)
+    defer cancel()
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    query = s.db.driver.dialect.Rebind(query)
+    returnsRows := sqlReadOnlyWords[word] || hasSQLWord(code, "RETURNING") || hasSQLWord(code, "OUTPUT")
+    if err := s.print(ctx, tx, query, params, returnsRows); err != nil {
+        return s.db.timeoutOr(ctx, "sql", op, err)
+    }
+    if !s.write {
+        return nil
+    }
+    return tx.Commit()
+}
+
+// timeoutOr дополняет ошибку прерванной таймаутом команды лимитом (см. timeoutError) или возвращает ее как есть
+func (db *Database) timeoutOr(ctx context.Context, name string, op operation, err error) error {
+    if timeout := db.timeoutError(ctx, name, op, err); timeout != nil {
+        return timeout
+    }
+    return err
+}
+
+// sqlCode возвращает текст команды, в котором строки в кавычках и комментарии заменены пробелами:
+// по нему ищутся ключевые слова и заполнители ? самой команды
+func sqlCode(query string) string {
+    code := []byte(query)
+    s := &scriptScanner{text: query}
+    for s.pos < len(s.text) {
+        start := s.pos
+        switch c := s.text[s.pos]; {
+        case c == '\'' || c == '"' || c == '`':
+            if s.skipQuoted(c) != nil {
+                s.pos = len(s.text)
+            }
+        case strings.HasPrefix(s.text[s.pos:], "--"):
+            if end := strings.IndexByte(s.text[s.pos:], '\n'); end >= 0 {
+                s.pos += end
+            } else {
+                s.pos = len(s.text)
+            }
+        case strings.HasPrefix(s.text[s.pos:], "/*"):
+            if s.skipUntil("/*", "*/", "comment") != nil {
+                s.pos = len(s.text)
+            }
+        default:
+            s.pos++
+            continue
+        }
+        for i := start; i < s.pos; i++ {
+            code[i] = ' '
+        }
+    }
+    return string(code)
+}
+
+// hasSQLWord сообщает, что в тексте code (см. sqlCode) есть ключевое слово word без учета регистра
+func hasSQLWord(code, word string) bool {
+    words := strings.FieldsFunc(code, func(r rune) bool {
+        return r >= utf8.RuneSelf || !isWordByte(byte(r))
+    })
+    return slices.ContainsFunc(words, func(w string) bool { return strings.EqualFold(w, word) })
+}
+
+// confirmUnfiltered спрашивает подтверждение UPDATE или DELETE без WHERE; без терминала такие команды не выполняются
+func (s *sqlShell) confirmUnfiltered(verb string) error {
+    if !s.interactive {
+        return fmt.Errorf("%s without WHERE changes every row and needs confirmation in an interactive session", verb)
+    }
+    fmt.Fprintf(s.out, "%s without WHERE changes every row of the table. Type yes to run it: ", verb)
+    if !s.confirm.Scan() || strings.TrimSpace(s.confirm.Text()) != "yes" {
+        return fmt.Errorf("%s cancelled", verb)
+    }
+    return nil
+}
+
+// print выполняет команду и выводит строки результата таблицей, а для команд без строк - число измененных строк
+func (s *sqlShell) print(ctx context.Context, tx *sql.Tx, query string, params []interface{}, returnsRows bool) error {
+    if !returnsRows {
+        result, err := tx.ExecContext(ctx, query, params...)
+        if err != nil {
+            return err
+        }
+        if affected, err := result.RowsAffected(); err == nil {
+            fmt.Fprintf(s.out, "OK, %d rows affected\n", affected)
+        } else {
+            fmt.Fprintln(s.out, "OK")
+        }
+        return nil
+    }
+
+    rows, err := tx.QueryContext(ctx, query, params...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+    columns, err := rows.Columns()
+    if err != nil {
+        return err
+    }
+
+    w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+    values := make([]interface{}, len(columns))
+    pointers := make([]interface{}, len(columns))
+    for i := range values {
+        pointers[i] = &values[i]
+    }
+    count := 0
+    for rows.Next() {
+        count++
+        if count > s.maxRows {
+            continue
+        }
+        if err := rows.Scan(pointers...); err != nil {
+            return err
+        }
+        cells := make([]string, len(values))
+        for i, value := range values {
+            cells[i] = truncateBrowseValue(formatBrowseValue(value))
+            if value != nil && browseHiddenColumns[strings.ToLower(columns[i])] {
+                cells[i] = redacted
+            }
+        }
+        fmt.Fprintln(w, strings.Join(cells, "\t"))
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+    if count > s.maxRows {
+        fmt.Fprintf(s.out, "(%d rows, first %d shown; see -max-rows)\n", count, s.maxRows)
+    } else {
+        fmt.Fprintf(s.out, "(%d rows)\n", count)
+    }
+    return nil
+}
+
+// loadHistory читает историю прошлых сеансов; недоступный файл истории не мешает работе
+func (s *sqlShell) loadHistory() {
+    if s.historyPath == "" {
+        return
+    }
+    data, err := os.ReadFile(s.historyPath)
+    if err != nil {
+        return
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        if line = strings.TrimSpace(line); line != "" {
+            s.history = append(s.history, line)
+        }
+    }
+}
+
+// remember добавляет команду в историю сеанса и дописывает в файл истории одной строкой
+func (s *sqlShell) remember(statement string) {
+    statement = strings.Join(strings.Fields(statement), " ")
+    s.history = append(s.history, statement)
+    if s.historyPath == "" {
+        return
+    }
+    file, err := os.OpenFile(s.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "sql history:", err)
+        s.historyPath = ""
+        return
+    }
+    defer file.Close()
+    fmt.Fprintln(file, statement)
+}
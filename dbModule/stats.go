@@ -0,0 +1,139 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// DatabaseStats - снимок размеров базы и состояния пула соединений для планирования емкости
+type DatabaseStats struct {
+    Dialect     string    `json:"dialect"`
+    CollectedAt time.Time `json:"collected_at"`
+    // SizeBytes - размер базы: файла SQLite или занятого ею места на сервере
+    SizeBytes int64        `json:"size_bytes"`
+    Tables    []TableStats `json:"tables"`
+    // Indexes пуст, если СУБД не отдает размеры индексов (SQLite без таблицы dbstat)
+    Indexes []IndexStats `json:"indexes"`
+    Pool    sql.DBStats  `json:"pool"`
+    // Tasks - последние запуски задач обслуживания этого процесса (см. RunScheduledTasks)
+    Tasks []TaskStatus `json:"tasks,omitempty"`
+}
+
+// TableStats - число строк таблицы всех площадок
+type TableStats struct {
+    Name string `json:"name"`
+    Rows int64  `json:"rows"`
+}
+
+// IndexStats - размер индекса в байтах
+type IndexStats struct {
+    Name      string `json:"name"`
+    Table     string `json:"table"`
+    SizeBytes int64  `json:"size_bytes"`
+}
+
+// Stats собирает размер базы, число строк каждой таблицы, размеры индексов, метрики пула соединений
+// и состояние задач обслуживания этого процесса.
+// Строки считаются точно (COUNT(*)), поэтому на больших таблицах вызов занимает время.
+// Все читается из основной базы, а не с реплик
+func (db *Database) Stats() (DatabaseStats, error) {
+    primary := db.WithPrimary()
+    stats := DatabaseStats{Dialect: db.driver.dialect.Name(), CollectedAt: db.now().UTC(), Pool: db.DB.Stats(), Tasks: db.TaskStatuses()}
+
+    rows, err := primary.queryNamed("introspection.database_size")
+    if err != nil {
+        return DatabaseStats{}, db.opError("collect", "stats", nil, err)
+    }
+    if rows.Next() {
+        err = rows.Scan(&stats.SizeBytes)
+    }
+    rows.Close()
+    if err != nil {
+        return DatabaseStats{}, db.opError("collect", "stats", nil, err)
+    }
+
+    tables, err := primary.introspectStrings("introspection.tables")
+    if err != nil {
+        return DatabaseStats{}, db.opError("collect", "stats", nil, err)
+    }
+    for _, table := range primary.ownTables(tables) {
+        count, err := primary.countRows(table)
+        if err != nil {
+            return DatabaseStats{}, db.opError("collect", "stats", nil, err)
+        }
+        stats.Tables = append(stats.Tables, TableStats{Name: table, Rows: count})
+    }
+
+    if stats.Indexes, err = primary.indexSizes(); err != nil {
+        log.Printf("stats: index sizes are unavailable: %v", err)
+    }
+    return stats, nil
+}
+
+// countRows считает строки таблицы, имя которой взято из каталога базы
+func (db *Database) countRows(table string) (int64, error) {
+    rows, err := db.queryText("stats.count_rows", fmt.Sprintf("SELECT COUNT(*) FROM %s", db.tableIdentifier(table)))
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+
+    var count int64
+    if rows.Next() {
+        if err := rows.Scan(&count); err != nil {
+            return 0, err
+        }
+    }
+    return count, rows.Err()
+}
+
+// tableIdentifier заключает имя таблицы в кавычки диалекта. Oracle хранит имена в верхнем регистре,
+// а introspection.tables возвращает их в нижнем
+func (db *Database) tableIdentifier(table string) string {
+    switch db.driver.dialect.Name() {
+    case "mysql":
+        return "`" + strings.ReplaceAll(table, "`", "``") + "`"
+    case "oracle":
+        return quoteIdentifier(strings.ToUpper(table))
+    }
+    return quoteIdentifier(table)
+}
+
+// indexSizes возвращает размеры индексов таблиц этого экземпляра в текущей схеме
+func (db *Database) indexSizes() ([]IndexStats, error) {
+    rows, err := db.queryNamed("introspection.index_sizes")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var indexes []IndexStats
+    for rows.Next() {
+        var index IndexStats
+        if err := rows.Scan(&index.Name, &index.Table, &index.SizeBytes); err != nil {
+            return nil, err
+        }
+        if !db.ownTable(index.Table) {
+            continue
+        }
+        indexes = append(indexes, index)
+    }
+    return indexes, rows.Err()
+}
+
+// StatsHandler отдает Stats в JSON на GET /debug/dbstats. Ответ раскрывает имена таблиц
+// и объем данных всех площадок, поэтому обработчик подключается отдельно (см. -debug-dbstats)
+func (db *Database) StatsHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        stats, err := db.Stats()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, stats)
+    })
+}
@@ -0,0 +1,51 @@
+package main
+
+// Store - операции с данными площадки, от которых зависят обработчики и сервисы: пользователи, рестораны,
+// отзывы, избранное и настройки. Его реализуют *Database, MemoryStore (данные в памяти, для тестов
+// обработчиков без файла SQLite) и MockStore (заданные ответы и запись вызовов, см. mockgen).
+//
+// Store намеренно покрывает лишь малую часть методов *Database: в него входит только то, что MemoryStore
+// может повторить без SQL с тем же видимым поведением (записи по ID, версии, права, внешние ключи
+// и каскады). Остальное остается методами *Database, потому что держится на самой базе:
+//   - настройка и копии с другим контекстом: Set*, With*;
+//   - схема и обслуживание: Migrate, Backup, WarmUp, блокировки, режим обслуживания;
+//   - выборки, которые строит СУБД: фильтры, поиск, страницы с курсорами, отчеты, аналитика, выгрузки;
+//   - транзакции и фоновые задания: InTx, Transactional, SubmitJob;
+//   - прочие сущности: категории, часы работы, переводы, меню, брони, сессии, журнал аудита.
+// Метод добавляется в Store вместе с его реализацией в MemoryStore; MockStore после этого перегенерируется
+// командой mockgen -out store_mock.go
+type Store interface {
+    InsertUserReturningID(user *User) (int, error)
+    GetUserByID(id int) (User, error)
+    SelectUsers() ([]User, error)
+    UpdateUser(user *User) (Diff, error)
+    DeleteUser(id int, policy DeletePolicy) error
+
+    InsertRestaurantReturningID(restaurant *Restaurant) (int, error)
+    GetRestaurantByID(id int) (Restaurant, error)
+    SelectRestaurants() ([]Restaurant, error)
+    UpdateRestaurant(actorID int, restaurant *Restaurant) (Diff, error)
+    DeleteRestaurant(actorID, id int) error
+    SelectJoin() ([]Listing, error)
+
+    InsertReview(review *Review) error
+    GetReviewByID(id int) (Review, error)
+    ReviewsByRestaurant(restaurantID int) ([]Review, error)
+    UpdateReview(review *Review) error
+    DeleteReview(id int) error
+    AverageRating(restaurantID int) (float64, error)
+
+    AddFavorite(userID, restaurantID int) error
+    RemoveFavorite(userID, restaurantID int) error
+    ListFavorites(userID int) ([]Restaurant, error)
+
+    SetPreference(userID int, name, value string) error
+    DeletePreference(userID int, name string) error
+    GetPreferences(userID int) (Preferences, error)
+}
+
+var (
+    _ Store = (*Database)(nil)
+    _ Store = (*MemoryStore)(nil)
+    _ Store = (*MockStore)(nil)
+)
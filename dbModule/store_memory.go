@@ -0,0 +1,476 @@
+package main
+
+import (
+    "fmt"
+    "maps"
+    "slices"
+    "sync"
+    "time"
+)
+
+// MemoryStore - Store, который держит данные в памяти: для тестов обработчиков и сервисов без базы.
+// Он повторяет поведение Database, которое видно вызывающему: проверки сущностей (см. RegisterInvariant),
+// версии и ErrStaleVersion, права на рестораны, внешние ключи и каскадные удаления, ошибки OpError
+// с ErrNotFound, ErrConflict и ErrForeignKeyViolation. Пароли хранятся как есть, без хеширования,
+// журнала аудита, событий изменений и площадок (TenantID всегда 0) нет. Нулевое значение готово к работе
+type MemoryStore struct {
+    // Clock задает время создания отзывов и добавления в избранное; nil - системные часы
+    Clock Clock
+
+    mu          sync.Mutex
+    lastID      int
+    users       map[int]User
+    restaurants map[int]Restaurant
+    reviews     map[int]Review
+    // favorites - избранное пользователя: ресторан и время добавления
+    favorites   map[int]map[int]time.Time
+    preferences map[int]Preferences
+}
+
+// NewMemoryStore возвращает пустой MemoryStore с часами clock (nil - системные)
+func NewMemoryStore(clock Clock) *MemoryStore {
+    return &MemoryStore{Clock: clock}
+}
+
+// now возвращает текущее время по часам хранилища
+func (s *MemoryStore) now() time.Time {
+    if s.Clock == nil {
+        return time.Now()
+    }
+    return s.Clock.Now()
+}
+
+// nextID выдает ID новой записи; счетчик один на все сущности, поэтому ID разных сущностей не совпадают
+func (s *MemoryStore) nextID() int {
+    s.lastID++
+    return s.lastID
+}
+
+// memoryError оборачивает ошибку операции так же, как Database.opError
+func memoryError(op, entity string, key interface{}, err error) error {
+    if err == nil {
+        return nil
+    }
+    return &OpError{Op: op, Entity: entity, Key: key, Err: err}
+}
+
+// sortedValues возвращает записи по возрастанию ID
+func sortedValues[T any](records map[int]T) []T {
+    values := make([]T, 0, len(records))
+    for _, id := range slices.Sorted(maps.Keys(records)) {
+        values = append(values, records[id])
+    }
+    return values
+}
+
+func (s *MemoryStore) InsertUserReturningID(user *User) (int, error) {
+    if err := validateUser(*user); err != nil {
+        return 0, memoryError("insert", "user", user.Email, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, existing := range s.users {
+        if existing.Email == user.Email {
+            return 0, memoryError("insert", "user", user.Email, fmt.Errorf("email %s is taken: %w", user.Email, ErrConflict))
+        }
+    }
+    created := *user
+    created.ID, created.Version, created.TenantID, created.Role = s.nextID(), 1, 0, userRole(created)
+    if s.users == nil {
+        s.users = make(map[int]User)
+    }
+    s.users[created.ID] = created
+    user.ID = created.ID
+    return user.ID, nil
+}
+
+func (s *MemoryStore) GetUserByID(id int) (User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    user, ok := s.users[id]
+    if !ok {
+        return User{}, memoryError("get", "user", id, ErrNotFound)
+    }
+    return user, nil
+}
+
+func (s *MemoryStore) SelectUsers() ([]User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return sortedValues(s.users), nil
+}
+
+func (s *MemoryStore) UpdateUser(user *User) (Diff, error) {
+    if err := validateUser(*user); err != nil {
+        return nil, memoryError("update", "user", user.ID, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    old, ok := s.users[user.ID]
+    if !ok {
+        return nil, memoryError("update", "user", user.ID, ErrNotFound)
+    }
+    diff := diffUser(old, *user)
+    if old.Version != user.Version {
+        return nil, memoryError("update", "user", user.ID, fmt.Errorf("version %d: %w", user.Version, ErrStaleVersion))
+    }
+    if len(diff) == 0 {
+        return Diff{}, nil
+    }
+    for id, existing := range s.users {
+        if id != user.ID && existing.Email == user.Email {
+            return nil, memoryError("update", "user", user.ID, fmt.Errorf("email %s is taken: %w", user.Email, ErrConflict))
+        }
+    }
+    updated := *user
+    updated.Version, updated.TenantID, updated.Role = old.Version+1, old.TenantID, userRole(updated)
+    s.users[user.ID] = updated
+    user.Version = updated.Version
+    return diff, nil
+}
+
+func (s *MemoryStore) DeleteUser(id int, policy DeletePolicy) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[id]; !ok {
+        return nil
+    }
+    for _, restaurant := range sortedValues(s.restaurants) {
+        if restaurant.UserID != id {
+            continue
+        }
+        if policy != DeleteCascade {
+            return &RestrictedDeleteError{Entity: "user", ID: id, ReferencedBy: "restaurants"}
+        }
+        s.deleteRestaurantLocked(restaurant.ID)
+    }
+    for reviewID, review := range s.reviews {
+        if review.UserID == id {
+            delete(s.reviews, reviewID)
+        }
+    }
+    delete(s.favorites, id)
+    delete(s.preferences, id)
+    delete(s.users, id)
+    return nil
+}
+
+// authorizeRestaurant проверяет права пользователя actorID на ресторан, как Database.authorizeRestaurant
+func (s *MemoryStore) authorizeRestaurant(actorID int, restaurant Restaurant) error {
+    if actorID == SystemActor {
+        return nil
+    }
+    actor, ok := s.users[actorID]
+    if !ok {
+        return fmt.Errorf("user %d does not exist: %w", actorID, ErrForbidden)
+    }
+    if !actor.CanManageRestaurant(restaurant) {
+        return fmt.Errorf("user %d with role %s cannot change restaurant %d of user %d: %w", actorID, userRole(actor), restaurant.ID, restaurant.UserID, ErrForbidden)
+    }
+    return nil
+}
+
+// checkRestaurantKeys проверяет владельца и уникальность имени ресторана у владельца
+func (s *MemoryStore) checkRestaurantKeys(restaurant Restaurant) error {
+    if _, ok := s.users[restaurant.UserID]; restaurant.UserID != 0 && !ok {
+        return fmt.Errorf("user %d does not exist: %w", restaurant.UserID, ErrForeignKeyViolation)
+    }
+    for id, existing := range s.restaurants {
+        if id != restaurant.ID && restaurant.UserID != 0 && existing.Name == restaurant.Name && existing.UserID == restaurant.UserID {
+            return fmt.Errorf("user %d already has restaurant %q: %w", restaurant.UserID, restaurant.Name, ErrConflict)
+        }
+    }
+    return nil
+}
+
+func (s *MemoryStore) InsertRestaurantReturningID(restaurant *Restaurant) (int, error) {
+    if err := validateRestaurant(*restaurant); err != nil {
+        return 0, memoryError("insert", "restaurant", restaurant.Name, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    created := *restaurant
+    created.ID = 0
+    if err := s.checkRestaurantKeys(created); err != nil {
+        return 0, memoryError("insert", "restaurant", restaurant.Name, err)
+    }
+    created.ID, created.Version, created.TenantID = s.nextID(), 1, 0
+    if s.restaurants == nil {
+        s.restaurants = make(map[int]Restaurant)
+    }
+    s.restaurants[created.ID] = created
+    restaurant.ID = created.ID
+    return restaurant.ID, nil
+}
+
+func (s *MemoryStore) GetRestaurantByID(id int) (Restaurant, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    restaurant, ok := s.restaurants[id]
+    if !ok {
+        return Restaurant{}, memoryError("get", "restaurant", id, ErrNotFound)
+    }
+    return restaurant, nil
+}
+
+func (s *MemoryStore) SelectRestaurants() ([]Restaurant, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return sortedValues(s.restaurants), nil
+}
+
+func (s *MemoryStore) UpdateRestaurant(actorID int, restaurant *Restaurant) (Diff, error) {
+    if err := validateRestaurant(*restaurant); err != nil {
+        return nil, memoryError("update", "restaurant", restaurant.ID, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    old, ok := s.restaurants[restaurant.ID]
+    if !ok {
+        return nil, memoryError("update", "restaurant", restaurant.ID, ErrNotFound)
+    }
+    diff := diffRestaurant(old, *restaurant)
+    if old.Version == restaurant.Version && len(diff) == 0 {
+        return Diff{}, memoryError("update", "restaurant", restaurant.ID, s.authorizeRestaurant(actorID, old))
+    }
+    for _, checked := range []Restaurant{old, *restaurant} {
+        if err := s.authorizeRestaurant(actorID, checked); err != nil {
+            return nil, memoryError("update", "restaurant", restaurant.ID, err)
+        }
+    }
+    if old.Version != restaurant.Version {
+        return nil, memoryError("update", "restaurant", restaurant.ID, fmt.Errorf("version %d: %w", restaurant.Version, ErrStaleVersion))
+    }
+    if err := s.checkRestaurantKeys(*restaurant); err != nil {
+        return nil, memoryError("update", "restaurant", restaurant.ID, err)
+    }
+    updated := *restaurant
+    updated.Version, updated.TenantID = old.Version+1, old.TenantID
+    s.restaurants[restaurant.ID] = updated
+    restaurant.Version = updated.Version
+    return diff, nil
+}
+
+func (s *MemoryStore) DeleteRestaurant(actorID, id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    old, ok := s.restaurants[id]
+    if !ok {
+        return memoryError("delete", "restaurant", id, ErrNotFound)
+    }
+    if err := s.authorizeRestaurant(actorID, old); err != nil {
+        return memoryError("delete", "restaurant", id, err)
+    }
+    s.deleteRestaurantLocked(id)
+    return nil
+}
+
+// deleteRestaurantLocked удаляет ресторан вместе с его отзывами и отметками избранного
+func (s *MemoryStore) deleteRestaurantLocked(id int) {
+    delete(s.restaurants, id)
+    for reviewID, review := range s.reviews {
+        if review.RestaurantID == id {
+            delete(s.reviews, reviewID)
+        }
+    }
+    for _, favorites := range s.favorites {
+        delete(favorites, id)
+    }
+}
+
+func (s *MemoryStore) SelectJoin() ([]Listing, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var listings []Listing
+    for _, restaurant := range sortedValues(s.restaurants) {
+        if user, ok := s.users[restaurant.UserID]; ok {
+            listings = append(listings, Listing{User: user, Restaurant: restaurant})
+        }
+    }
+    return listings, nil
+}
+
+func (s *MemoryStore) InsertReview(review *Review) error {
+    if err := validateReview(*review); err != nil {
+        return memoryError("insert", "review", nil, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    _, userExists := s.users[review.UserID]
+    _, restaurantExists := s.restaurants[review.RestaurantID]
+    if !userExists || !restaurantExists {
+        return memoryError("insert", "review", nil, fmt.Errorf("user %d or restaurant %d does not exist: %w", review.UserID, review.RestaurantID, ErrForeignKeyViolation))
+    }
+    review.ID, review.CreatedAt, review.TenantID = s.nextID(), s.now().UTC(), 0
+    if s.reviews == nil {
+        s.reviews = make(map[int]Review)
+    }
+    s.reviews[review.ID] = *review
+    return nil
+}
+
+func (s *MemoryStore) GetReviewByID(id int) (Review, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    review, ok := s.reviews[id]
+    if !ok {
+        return Review{}, memoryError("get", "review", id, ErrNotFound)
+    }
+    return review, nil
+}
+
+func (s *MemoryStore) ReviewsByRestaurant(restaurantID int) ([]Review, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var reviews []Review
+    for _, review := range sortedValues(s.reviews) {
+        if review.RestaurantID == restaurantID {
+            reviews = append(reviews, review)
+        }
+    }
+    return reviews, nil
+}
+
+func (s *MemoryStore) UpdateReview(review *Review) error {
+    if err := validateReview(*review); err != nil {
+        return memoryError("update", "review", review.ID, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    current, ok := s.reviews[review.ID]
+    if !ok {
+        return memoryError("update", "review", review.ID, ErrNotFound)
+    }
+    current.Rating, current.Comment = review.Rating, review.Comment
+    s.reviews[review.ID] = current
+    *review = current
+    return nil
+}
+
+func (s *MemoryStore) DeleteReview(id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.reviews[id]; !ok {
+        return memoryError("delete", "review", id, ErrNotFound)
+    }
+    delete(s.reviews, id)
+    return nil
+}
+
+func (s *MemoryStore) AverageRating(restaurantID int) (float64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var sum, count int
+    for _, review := range s.reviews {
+        if review.RestaurantID == restaurantID {
+            sum += review.Rating
+            count++
+        }
+    }
+    if count == 0 {
+        return 0, nil
+    }
+    return float64(sum) / float64(count), nil
+}
+
+func (s *MemoryStore) AddFavorite(userID, restaurantID int) error {
+    favorite := Favorite{UserID: userID, RestaurantID: restaurantID}
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    _, userExists := s.users[userID]
+    _, restaurantExists := s.restaurants[restaurantID]
+    if !userExists || !restaurantExists {
+        return memoryError("insert", "favorite", favorite, fmt.Errorf("user %d or restaurant %d does not exist: %w", userID, restaurantID, ErrForeignKeyViolation))
+    }
+    if _, ok := s.favorites[userID][restaurantID]; ok {
+        return nil
+    }
+    if s.favorites == nil {
+        s.favorites = make(map[int]map[int]time.Time)
+    }
+    if s.favorites[userID] == nil {
+        s.favorites[userID] = make(map[int]time.Time)
+    }
+    s.favorites[userID][restaurantID] = s.now().UTC()
+    return nil
+}
+
+func (s *MemoryStore) RemoveFavorite(userID, restaurantID int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.favorites[userID][restaurantID]; !ok {
+        return memoryError("delete", "favorite", Favorite{UserID: userID, RestaurantID: restaurantID}, ErrNotFound)
+    }
+    delete(s.favorites[userID], restaurantID)
+    return nil
+}
+
+// ListFavorites возвращает избранное пользователя начиная с последнего добавленного, как Database
+func (s *MemoryStore) ListFavorites(userID int) ([]Restaurant, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    favorites := s.favorites[userID]
+    ids := slices.SortedFunc(maps.Keys(favorites), func(a, b int) int {
+        if c := favorites[b].Compare(favorites[a]); c != 0 {
+            return c
+        }
+        return a - b
+    })
+    restaurants := make([]Restaurant, 0, len(ids))
+    for _, id := range ids {
+        restaurants = append(restaurants, s.restaurants[id])
+    }
+    return restaurants, nil
+}
+
+func (s *MemoryStore) SetPreference(userID int, name, value string) error {
+    key := fmt.Sprintf("%d/%s", userID, name)
+    if err := checkPreference(name, value); err != nil {
+        return memoryError("set", "preference", key, err)
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[userID]; !ok {
+        return memoryError("set", "preference", key, fmt.Errorf("user %d does not exist: %w", userID, ErrForeignKeyViolation))
+    }
+    if s.preferences == nil {
+        s.preferences = make(map[int]Preferences)
+    }
+    if s.preferences[userID] == nil {
+        s.preferences[userID] = make(Preferences)
+    }
+    s.preferences[userID][name] = value
+    return nil
+}
+
+func (s *MemoryStore) DeletePreference(userID int, name string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.preferences[userID][name]; !ok {
+        return memoryError("delete", "preference", fmt.Sprintf("%d/%s", userID, name), ErrNotFound)
+    }
+    delete(s.preferences[userID], name)
+    return nil
+}
+
+func (s *MemoryStore) GetPreferences(userID int) (Preferences, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    preferences := make(Preferences)
+    maps.Copy(preferences, s.preferences[userID])
+    return preferences, nil
+}
@@ -0,0 +1,263 @@
+// Code generated by dbModule mockgen from store.go; DO NOT EDIT.
+
+package main
+
+import "sync"
+
+// MockStore - Store с ответами, заданными полями <Метод>Func; вызовы записываются в Calls
+type MockStore struct {
+    InsertUserReturningIDFunc       func(user *User) (int, error)
+    GetUserByIDFunc                 func(id int) (User, error)
+    SelectUsersFunc                 func() ([]User, error)
+    UpdateUserFunc                  func(user *User) (Diff, error)
+    DeleteUserFunc                  func(id int, policy DeletePolicy) error
+    InsertRestaurantReturningIDFunc func(restaurant *Restaurant) (int, error)
+    GetRestaurantByIDFunc           func(id int) (Restaurant, error)
+    SelectRestaurantsFunc           func() ([]Restaurant, error)
+    UpdateRestaurantFunc            func(actorID int, restaurant *Restaurant) (Diff, error)
+    DeleteRestaurantFunc            func(actorID int, id int) error
+    SelectJoinFunc                  func() ([]Listing, error)
+    InsertReviewFunc                func(review *Review) error
+    GetReviewByIDFunc               func(id int) (Review, error)
+    ReviewsByRestaurantFunc         func(restaurantID int) ([]Review, error)
+    UpdateReviewFunc                func(review *Review) error
+    DeleteReviewFunc                func(id int) error
+    AverageRatingFunc               func(restaurantID int) (float64, error)
+    AddFavoriteFunc                 func(userID int, restaurantID int) error
+    RemoveFavoriteFunc              func(userID int, restaurantID int) error
+    ListFavoritesFunc               func(userID int) ([]Restaurant, error)
+    SetPreferenceFunc               func(userID int, name string, value string) error
+    DeletePreferenceFunc            func(userID int, name string) error
+    GetPreferencesFunc              func(userID int) (Preferences, error)
+
+    mu sync.Mutex
+    // Calls - вызовы методов по порядку
+    Calls []MockCall
+}
+
+// record добавляет вызов метода в Calls
+func (m *MockStore) record(method string, args ...interface{}) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.Calls = append(m.Calls, MockCall{Method: method, Args: args})
+}
+
+// CallsTo возвращает вызовы метода method по порядку
+func (m *MockStore) CallsTo(method string) []MockCall {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var calls []MockCall
+    for _, call := range m.Calls {
+        if call.Method == method {
+            calls = append(calls, call)
+        }
+    }
+    return calls
+}
+
+// InsertUserReturningID записывает вызов и возвращает ответ InsertUserReturningIDFunc
+func (m *MockStore) InsertUserReturningID(user *User) (int, error) {
+    m.record("InsertUserReturningID", user)
+    if m.InsertUserReturningIDFunc == nil {
+        panic("MockStore.InsertUserReturningID: InsertUserReturningIDFunc is not set")
+    }
+    return m.InsertUserReturningIDFunc(user)
+}
+
+// GetUserByID записывает вызов и возвращает ответ GetUserByIDFunc
+func (m *MockStore) GetUserByID(id int) (User, error) {
+    m.record("GetUserByID", id)
+    if m.GetUserByIDFunc == nil {
+        panic("MockStore.GetUserByID: GetUserByIDFunc is not set")
+    }
+    return m.GetUserByIDFunc(id)
+}
+
+// SelectUsers записывает вызов и возвращает ответ SelectUsersFunc
+func (m *MockStore) SelectUsers() ([]User, error) {
+    m.record("SelectUsers")
+    if m.SelectUsersFunc == nil {
+        panic("MockStore.SelectUsers: SelectUsersFunc is not set")
+    }
+    return m.SelectUsersFunc()
+}
+
+// UpdateUser записывает вызов и возвращает ответ UpdateUserFunc
+func (m *MockStore) UpdateUser(user *User) (Diff, error) {
+    m.record("UpdateUser", user)
+    if m.UpdateUserFunc == nil {
+        panic("MockStore.UpdateUser: UpdateUserFunc is not set")
+    }
+    return m.UpdateUserFunc(user)
+}
+
+// DeleteUser записывает вызов и возвращает ответ DeleteUserFunc
+func (m *MockStore) DeleteUser(id int, policy DeletePolicy) error {
+    m.record("DeleteUser", id, policy)
+    if m.DeleteUserFunc == nil {
+        panic("MockStore.DeleteUser: DeleteUserFunc is not set")
+    }
+    return m.DeleteUserFunc(id, policy)
+}
+
+// InsertRestaurantReturningID записывает вызов и возвращает ответ InsertRestaurantReturningIDFunc
+func (m *MockStore) InsertRestaurantReturningID(restaurant *Restaurant) (int, error) {
+    m.record("InsertRestaurantReturningID", restaurant)
+    if m.InsertRestaurantReturningIDFunc == nil {
+        panic("MockStore.InsertRestaurantReturningID: InsertRestaurantReturningIDFunc is not set")
+    }
+    return m.InsertRestaurantReturningIDFunc(restaurant)
+}
+
+// GetRestaurantByID записывает вызов и возвращает ответ GetRestaurantByIDFunc
+func (m *MockStore) GetRestaurantByID(id int) (Restaurant, error) {
+    m.record("GetRestaurantByID", id)
+    if m.GetRestaurantByIDFunc == nil {
+        panic("MockStore.GetRestaurantByID: GetRestaurantByIDFunc is not set")
+    }
+    return m.GetRestaurantByIDFunc(id)
+}
+
+// SelectRestaurants записывает вызов и возвращает ответ SelectRestaurantsFunc
+func (m *MockStore) SelectRestaurants() ([]Restaurant, error) {
+    m.record("SelectRestaurants")
+    if m.SelectRestaurantsFunc == nil {
+        panic("MockStore.SelectRestaurants: SelectRestaurantsFunc is not set")
+    }
+    return m.SelectRestaurantsFunc()
+}
+
+// UpdateRestaurant записывает вызов и возвращает ответ UpdateRestaurantFunc
+func (m *MockStore) UpdateRestaurant(actorID int, restaurant *Restaurant) (Diff, error) {
+    m.record("UpdateRestaurant", actorID, restaurant)
+    if m.UpdateRestaurantFunc == nil {
+        panic("MockStore.UpdateRestaurant: UpdateRestaurantFunc is not set")
+    }
+    return m.UpdateRestaurantFunc(actorID, restaurant)
+}
+
+// DeleteRestaurant записывает вызов и возвращает ответ DeleteRestaurantFunc
+func (m *MockStore) DeleteRestaurant(actorID int, id int) error {
+    m.record("DeleteRestaurant", actorID, id)
+    if m.DeleteRestaurantFunc == nil {
+        panic("MockStore.DeleteRestaurant: DeleteRestaurantFunc is not set")
+    }
+    return m.DeleteRestaurantFunc(actorID, id)
+}
+
+// SelectJoin записывает вызов и возвращает ответ SelectJoinFunc
+func (m *MockStore) SelectJoin() ([]Listing, error) {
+    m.record("SelectJoin")
+    if m.SelectJoinFunc == nil {
+        panic("MockStore.SelectJoin: SelectJoinFunc is not set")
+    }
+    return m.SelectJoinFunc()
+}
+
+// InsertReview записывает вызов и возвращает ответ InsertReviewFunc
+func (m *MockStore) InsertReview(review *Review) error {
+    m.record("InsertReview", review)
+    if m.InsertReviewFunc == nil {
+        panic("MockStore.InsertReview: InsertReviewFunc is not set")
+    }
+    return m.InsertReviewFunc(review)
+}
+
+// GetReviewByID записывает вызов и возвращает ответ GetReviewByIDFunc
+func (m *MockStore) GetReviewByID(id int) (Review, error) {
+    m.record("GetReviewByID", id)
+    if m.GetReviewByIDFunc == nil {
+        panic("MockStore.GetReviewByID: GetReviewByIDFunc is not set")
+    }
+    return m.GetReviewByIDFunc(id)
+}
+
+// ReviewsByRestaurant записывает вызов и возвращает ответ ReviewsByRestaurantFunc
+func (m *MockStore) ReviewsByRestaurant(restaurantID int) ([]Review, error) {
+    m.record("ReviewsByRestaurant", restaurantID)
+    if m.ReviewsByRestaurantFunc == nil {
+        panic("MockStore.ReviewsByRestaurant: ReviewsByRestaurantFunc is not set")
+    }
+    return m.ReviewsByRestaurantFunc(restaurantID)
+}
+
+// UpdateReview записывает вызов и возвращает ответ UpdateReviewFunc
+func (m *MockStore) UpdateReview(review *Review) error {
+    m.record("UpdateReview", review)
+    if m.UpdateReviewFunc == nil {
+        panic("MockStore.UpdateReview: UpdateReviewFunc is not set")
+    }
+    return m.UpdateReviewFunc(review)
+}
+
+// DeleteReview записывает вызов и возвращает ответ DeleteReviewFunc
+func (m *MockStore) DeleteReview(id int) error {
+    m.record("DeleteReview", id)
+    if m.DeleteReviewFunc == nil {
+        panic("MockStore.DeleteReview: DeleteReviewFunc is not set")
+    }
+    return m.DeleteReviewFunc(id)
+}
+
+// AverageRating записывает вызов и возвращает ответ AverageRatingFunc
+func (m *MockStore) AverageRating(restaurantID int) (float64, error) {
+    m.record("AverageRating", restaurantID)
+    if m.AverageRatingFunc == nil {
+        panic("MockStore.AverageRating: AverageRatingFunc is not set")
+    }
+    return m.AverageRatingFunc(restaurantID)
+}
+
+// AddFavorite записывает вызов и возвращает ответ AddFavoriteFunc
+func (m *MockStore) AddFavorite(userID int, restaurantID int) error {
+    m.record("AddFavorite", userID, restaurantID)
+    if m.AddFavoriteFunc == nil {
+        panic("MockStore.AddFavorite: AddFavoriteFunc is not set")
+    }
+    return m.AddFavoriteFunc(userID, restaurantID)
+}
+
+// RemoveFavorite записывает вызов и возвращает ответ RemoveFavoriteFunc
+func (m *MockStore) RemoveFavorite(userID int, restaurantID int) error {
+    m.record("RemoveFavorite", userID, restaurantID)
+    if m.RemoveFavoriteFunc == nil {
+        panic("MockStore.RemoveFavorite: RemoveFavoriteFunc is not set")
+    }
+    return m.RemoveFavoriteFunc(userID, restaurantID)
+}
+
+// ListFavorites записывает вызов и возвращает ответ ListFavoritesFunc
+func (m *MockStore) ListFavorites(userID int) ([]Restaurant, error) {
+    m.record("ListFavorites", userID)
+    if m.ListFavoritesFunc == nil {
+        panic("MockStore.ListFavorites: ListFavoritesFunc is not set")
+    }
+    return m.ListFavoritesFunc(userID)
+}
+
+// SetPreference записывает вызов и возвращает ответ SetPreferenceFunc
+func (m *MockStore) SetPreference(userID int, name string, value string) error {
+    m.record("SetPreference", userID, name, value)
+    if m.SetPreferenceFunc == nil {
+        panic("MockStore.SetPreference: SetPreferenceFunc is not set")
+    }
+    return m.SetPreferenceFunc(userID, name, value)
+}
+
+// DeletePreference записывает вызов и возвращает ответ DeletePreferenceFunc
+func (m *MockStore) DeletePreference(userID int, name string) error {
+    m.record("DeletePreference", userID, name)
+    if m.DeletePreferenceFunc == nil {
+        panic("MockStore.DeletePreference: DeletePreferenceFunc is not set")
+    }
+    return m.DeletePreferenceFunc(userID, name)
+}
+
+// GetPreferences записывает вызов и возвращает ответ GetPreferencesFunc
+func (m *MockStore) GetPreferences(userID int) (Preferences, error) {
+    m.record("GetPreferences", userID)
+    if m.GetPreferencesFunc == nil {
+        panic("MockStore.GetPreferences: GetPreferencesFunc is not set")
+    }
+    return m.GetPreferencesFunc(userID)
+}
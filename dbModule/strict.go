@@ -0,0 +1,330 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// strictMinVersion - первая версия SQLite с таблицами STRICT
+var strictMinVersion = [3]int{3, 37, 0}
+
+// strictTimeFormats - форматы, в которых драйверы SQLite записывают time.Time и CURRENT_TIMESTAMP.
+// В таблице STRICT у колонки нет типа TIMESTAMP, поэтому драйвер отдает время строкой
+var strictTimeFormats = []string{
+    "2006-01-02 15:04:05.999999999-07:00",
+    "2006-01-02T15:04:05.999999999-07:00",
+    "2006-01-02 15:04:05.999999999",
+    "2006-01-02T15:04:05.999999999",
+    "2006-01-02 15:04",
+    "2006-01-02T15:04",
+    "2006-01-02",
+}
+
+var (
+    strictCreatePattern = regexp.MustCompile(`(?is)^CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?[^\s(]+\s*\(`)
+    strictAlterPattern  = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+\S+\s+ADD\s+(?:COLUMN\s+)?`)
+)
+
+// strictConstraintWords - слова, с которых в определении таблицы начинается ограничение, а не колонка,
+// и которыми в определении колонки заканчивается ее тип
+var strictConstraintWords = map[string]bool{
+    "CONSTRAINT": true,
+    "PRIMARY":    true,
+    "FOREIGN":    true,
+    "UNIQUE":     true,
+    "CHECK":      true,
+    "NOT":        true,
+    "NULL":       true,
+    "DEFAULT":    true,
+    "REFERENCES": true,
+    "COLLATE":    true,
+    "GENERATED":  true,
+    "AS":         true,
+}
+
+// checkStrict проверяет, что строгий режим поддерживается: он есть только у SQLite начиная с 3.37
+func (db *Database) checkStrict() error {
+    if err := db.requireSQLite("strict mode"); err != nil {
+        return err
+    }
+    rows, err := db.queryNamed("pragmas.sqlite_version")
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var version string
+    if rows.Next() {
+        if err := rows.Scan(&version); err != nil {
+            return err
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    var got [3]int
+    for i, part := range strings.SplitN(version, ".", 3) {
+        got[i], _ = strconv.Atoi(part)
+    }
+    for i := range got {
+        if got[i] != strictMinVersion[i] {
+            if got[i] < strictMinVersion[i] {
+                return fmt.Errorf("strict mode requires SQLite %d.%d or newer, got %s", strictMinVersion[0], strictMinVersion[1], version)
+            }
+            break
+        }
+    }
+    return nil
+}
+
+// strictSchema переписывает DDL миграции SQLite для строгого режима: CREATE TABLE получает
+// STRICT, а типы колонок в нем и в ALTER TABLE ... ADD COLUMN заменяются на допустимые
+// в таблицах STRICT (см. strictColumnType). Остальные команды не меняются
+func strictSchema(query string) (string, error) {
+    statements, err := SplitScript(query)
+    if err != nil {
+        return "", err
+    }
+    parts := make([]string, len(statements))
+    for i, statement := range statements {
+        parts[i] = statement.SQL
+        if statement.Block {
+            continue
+        }
+        if loc := strictCreatePattern.FindStringIndex(statement.SQL); loc != nil {
+            if parts[i], err = strictCreateTable(statement.SQL, loc[1]); err != nil {
+                return "", fmt.Errorf("%d: %w", statement.Line, err)
+            }
+        } else if loc := strictAlterPattern.FindStringIndex(statement.SQL); loc != nil {
+            parts[i] = statement.SQL[:loc[1]] + strictColumn(statement.SQL[loc[1]:])
+        }
+    }
+    return strings.Join(parts, "; ") + ";", nil
+}
+
+// strictCreateTable переписывает колонки CREATE TABLE, тело которого начинается с позиции body
+func strictCreateTable(query string, body int) (string, error) {
+    var columns []string
+    depth, start, end := 0, body, -1
+    for i := body; i < len(query) && end < 0; i++ {
+        switch c := query[i]; c {
+        case '\'', '"', '`':
+            closing := strings.IndexByte(query[i+1:], c)
+            if closing < 0 {
+                return "", fmt.Errorf("unterminated %c quote", c)
+            }
+            i += closing + 1
+        case '(':
+            depth++
+        case ')':
+            if depth > 0 {
+                depth--
+                continue
+            }
+            end = i
+            fallthrough
+        case ',':
+            if depth == 0 {
+                columns = append(columns, strictColumn(strings.TrimSpace(query[start:i])))
+                start = i + 1
+            }
+        }
+    }
+    if end < 0 {
+        return "", fmt.Errorf("unterminated column list")
+    }
+
+    options := strings.TrimSpace(query[end+1:])
+    switch {
+    case strings.Contains(strings.ToUpper(options), "STRICT"):
+    case options == "":
+        options = "STRICT"
+    default:
+        options += ", STRICT"
+    }
+    return query[:body] + strings.Join(columns, ", ") + ") " + options, nil
+}
+
+// strictColumn заменяет тип в определении колонки; ограничения таблицы и колонки
+// с именем в кавычках возвращаются как есть
+func strictColumn(definition string) string {
+    s := &scriptScanner{text: definition}
+    if name := s.word(); name == "" || strictConstraintWords[strings.ToUpper(name)] {
+        return definition
+    }
+    nameEnd := s.pos
+
+    typeEnd := nameEnd
+    for {
+        word := s.word()
+        if word == "" || strictConstraintWords[strings.ToUpper(word)] {
+            break
+        }
+        typeEnd = s.pos
+    }
+    s.pos = typeEnd
+    if s.peek() == '(' {
+        if end := strings.IndexByte(definition[typeEnd:], ')'); end >= 0 {
+            typeEnd += end + 1
+        }
+    }
+    declared := strings.TrimSpace(definition[nameEnd:typeEnd])
+    return strings.TrimRight(definition[:nameEnd]+" "+strictColumnType(declared)+" "+strings.TrimSpace(definition[typeEnd:]), " ")
+}
+
+// strictColumnType сводит объявленный тип к типу таблицы STRICT по правилам affinity SQLite.
+// Время хранится в TEXT: при чтении строгий режим разбирает его обратно в time.Time
+func strictColumnType(declared string) string {
+    upper := strings.ToUpper(declared)
+    switch {
+    case upper == "":
+        return "ANY"
+    case strings.Contains(upper, "INT"):
+        return "INTEGER"
+    case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"):
+        return "TEXT"
+    case strings.Contains(upper, "BLOB"):
+        return "BLOB"
+    case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+        return "REAL"
+    case strings.Contains(upper, "DATE"), strings.Contains(upper, "TIME"):
+        return "TEXT"
+    }
+    return "ANY"
+}
+
+// Scan читает текущую строку. В строгом режиме (см. Config.Strict) значение, тип которого не совпадает
+// с типом назначения, - ошибка, а не молчаливое преобразование: например, цена, записанная текстом,
+// не превращается в число. Время, хранящееся в TEXT, разбирается в time.Time. Ошибки - *QueryError
+func (r *queryRows) Scan(dest ...interface{}) error {
+    if !r.db.strict {
+        return r.db.queryError(r.name, r.args, r.op, r.Rows.Scan(dest...))
+    }
+    values := make([]interface{}, len(dest))
+    pointers := make([]interface{}, len(dest))
+    for i := range values {
+        pointers[i] = &values[i]
+    }
+    if err := r.Rows.Scan(pointers...); err != nil {
+        return r.db.queryError(r.name, r.args, r.op, err)
+    }
+    columns, err := r.Rows.Columns()
+    if err != nil {
+        return r.db.queryError(r.name, r.args, r.op, err)
+    }
+
+    targets := make([]interface{}, len(dest))
+    for i, value := range values {
+        targets[i] = dest[i]
+        assigned, err := strictValue(dest[i], value)
+        if err != nil {
+            return r.db.queryError(r.name, r.args, r.op, fmt.Errorf("column %s: %w", columns[i], err))
+        }
+        if assigned {
+            // время уже разобрано, повторное чтение строкой в time.Time завершилось бы ошибкой
+            targets[i] = new(interface{})
+        }
+    }
+    return r.db.queryError(r.name, r.args, r.op, r.Rows.Scan(targets...))
+}
+
+// strictValue проверяет, что значение value можно прочитать в dest без преобразования типа.
+// Время из TEXT сразу записывается в dest (assigned), остальные значения записывает Rows.Scan
+func strictValue(dest, value interface{}) (assigned bool, err error) {
+    want := strictDestKind(dest)
+    if want == "" || value == nil {
+        return false, nil
+    }
+    got := strictValueKind(value)
+    if text, ok := value.(string); ok && want == "time" {
+        parsed, err := parseStrictTime(text)
+        if err != nil {
+            return false, err
+        }
+        switch dest := dest.(type) {
+        case *time.Time:
+            *dest = parsed
+        case *sql.NullTime:
+            *dest = sql.NullTime{Time: parsed, Valid: true}
+        }
+        return true, nil
+    }
+    if got == want || want == "real" && got == "integer" || want == "blob" && got == "text" {
+        return false, nil
+    }
+    return false, fmt.Errorf("strict mode: %s value %v cannot be scanned into %s", got, value, reflect.TypeOf(dest).Elem())
+}
+
+// strictDestKind возвращает вид значения, которого ждет dest; пустой - dest проверяет значение сам
+func strictDestKind(dest interface{}) string {
+    switch dest.(type) {
+    case *time.Time, *sql.NullTime:
+        return "time"
+    case *sql.NullInt64, *sql.NullInt32, *sql.NullInt16, *sql.NullByte, *sql.NullBool:
+        return "integer"
+    case *sql.NullFloat64:
+        return "real"
+    case *sql.NullString:
+        return "text"
+    case *sql.RawBytes:
+        return "blob"
+    case sql.Scanner:
+        return ""
+    }
+    t := reflect.TypeOf(dest)
+    if t == nil || t.Kind() != reflect.Pointer {
+        return ""
+    }
+    t = t.Elem()
+    // необязательные поля читаются в указатель: NULL оставляет его nil, остальное проверяется как обычно
+    if t.Kind() == reflect.Pointer {
+        t = t.Elem()
+    }
+    switch t.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool:
+        return "integer"
+    case reflect.Float32, reflect.Float64:
+        return "real"
+    case reflect.String:
+        return "text"
+    case reflect.Slice:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return "blob"
+        }
+    }
+    return ""
+}
+
+// strictValueKind возвращает вид значения, полученного от драйвера
+func strictValueKind(value interface{}) string {
+    switch value.(type) {
+    case int64, bool:
+        return "integer"
+    case float64:
+        return "real"
+    case string:
+        return "text"
+    case []byte:
+        return "blob"
+    case time.Time:
+        return "time"
+    }
+    return fmt.Sprintf("%T", value)
+}
+
+// parseStrictTime разбирает время, записанное драйвером SQLite или CURRENT_TIMESTAMP, в UTC
+func parseStrictTime(text string) (time.Time, error) {
+    text = strings.TrimSuffix(text, "Z")
+    for _, format := range strictTimeFormats {
+        if parsed, err := time.ParseInLocation(format, text, time.UTC); err == nil {
+            return parsed, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("strict mode: cannot parse %q as time", text)
+}
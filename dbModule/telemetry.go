@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Telemetry - приемник метрик и трассировки слоя базы. Модуль не зависит от конкретного стека:
+// в нем есть реализации для Prometheus, statsd и OpenTelemetry (OTLPTelemetry, без SDK OpenTelemetry -
+// модуль сам отправляет данные по OTLP/HTTP), другие стеки подключаются оберткой над этим интерфейсом
+type Telemetry interface {
+    // Count увеличивает счетчик name на delta
+    Count(name string, delta float64, labels ...Label)
+    // Observe добавляет значение в гистограмму name
+    Observe(name string, value float64, labels ...Label)
+    // StartSpan начинает участок трассировки; его нужно завершить через End
+    StartSpan(name string, attributes ...Label) Span
+}
+
+// Span - участок трассировки
+type Span interface {
+    // End завершает участок; err - ошибка операции или nil
+    End(err error)
+}
+
+// TracingTelemetry - приемник, участки которого продолжают распределенную трассировку: родитель
+// берется из контекста (см. WithContext), а новый участок возвращается в контексте для вложенных.
+// Такому приемнику модуль передает и атрибуты запроса db.statement, db.operation, db.system
+// и correlation_id (см. WithCorrelationID), которые не годятся в метки метрик
+type TracingTelemetry interface {
+    Telemetry
+    StartSpanContext(ctx context.Context, name string, attributes ...Label) (context.Context, Span)
+}
+
+// AttributedSpan - участок, к которому можно добавить атрибуты после начала, например число строк
+type AttributedSpan interface {
+    Span
+    SetAttributes(attributes ...Label)
+}
+
+// Label - метка метрики или атрибут участка трассировки
+type Label struct {
+    Key   string
+    Value string
+}
+
+// Метрики и участки, которые пишет модуль
+const (
+    // metricQueries - счетчик запросов с метками query, operation и status (ok или error)
+    metricQueries = "db.queries"
+    // metricQueryDuration - гистограмма длительности запросов в секундах с метками query и operation
+    metricQueryDuration = "db.query.duration_seconds"
+    // spanQuery и spanTransaction - участки одного запроса и транзакции InTx
+    spanQuery       = "db.query"
+    spanTransaction = "db.transaction"
+)
+
+// NoopTelemetry ничего не записывает; используется, пока SetTelemetry не вызван
+type NoopTelemetry struct{}
+
+// Count ничего не делает
+func (NoopTelemetry) Count(string, float64, ...Label) {}
+
+// Observe ничего не делает
+func (NoopTelemetry) Observe(string, float64, ...Label) {}
+
+// StartSpan возвращает участок, который ничего не записывает
+func (NoopTelemetry) StartSpan(string, ...Label) Span { return noopSpan{} }
+
+// noopSpan - участок NoopTelemetry
+type noopSpan struct{}
+
+// End ничего не делает
+func (noopSpan) End(error) {}
+
+// SetTelemetry задает приемник метрик и трассировки; nil выключает их
+func (db *Database) SetTelemetry(telemetry Telemetry) {
+    db.telemetry = telemetry
+}
+
+// telemetrySink возвращает заданный приемник или NoopTelemetry
+func (db *Database) telemetrySink() Telemetry {
+    if db.telemetry == nil {
+        return NoopTelemetry{}
+    }
+    return db.telemetry
+}
+
+// startSpan начинает участок name; приемник TracingTelemetry связывает его с трассировкой ctx
+// и возвращает контекст нового участка, остальные возвращают ctx как есть
+func (db *Database) startSpan(ctx context.Context, name string, attributes ...Label) (context.Context, Span) {
+    telemetry := db.telemetrySink()
+    if tracing, ok := telemetry.(TracingTelemetry); ok {
+        return tracing.StartSpanContext(ctx, name, withCorrelationLabel(ctx, attributes)...)
+    }
+    return ctx, telemetry.StartSpan(name, attributes...)
+}
+
+// startQuerySpan начинает участок трассировки именованного запроса; трассировка получает
+// и текст запроса с плейсхолдерами (значения аргументов в участок не попадают)
+func (db *Database) startQuerySpan(ctx context.Context, name, query string, op operation) (context.Context, Span) {
+    attributes := []Label{{"query", name}, {"operation", op.String()}}
+    telemetry := db.telemetrySink()
+    if tracing, ok := telemetry.(TracingTelemetry); ok {
+        attributes = append(attributes, Label{"db.system", otelDBSystem(db.driver.dialect.Name())},
+            Label{"db.statement", query}, Label{"db.operation", sqlVerb(query)})
+        return tracing.StartSpanContext(ctx, spanQuery, withCorrelationLabel(ctx, attributes)...)
+    }
+    return ctx, telemetry.StartSpan(spanQuery, attributes...)
+}
+
+// withCorrelationLabel добавляет к атрибутам участка трассировки correlation_id из ctx, если он есть.
+// Приемникам без трассировки он не передается: в метках метрик у него слишком много значений
+func withCorrelationLabel(ctx context.Context, attributes []Label) []Label {
+    if id := CorrelationID(ctx); id != "" {
+        return append(attributes, Label{"correlation_id", id})
+    }
+    return attributes
+}
+
+// setSpanRowsAffected добавляет к участку изменяющего запроса число измененных строк
+func setSpanRowsAffected(span Span, rows int64) {
+    if attributed, ok := span.(AttributedSpan); ok {
+        attributed.SetAttributes(Label{"db.rows_affected", strconv.FormatInt(rows, 10)})
+    }
+}
+
+// sqlVerb возвращает первое ключевое слово запроса заглавными: SELECT, INSERT, BEGIN
+func sqlVerb(query string) string {
+    fields := strings.Fields(strings.TrimLeft(query, "( "))
+    if len(fields) == 0 {
+        return ""
+    }
+    return strings.ToUpper(strings.TrimRight(fields[0], ";("))
+}
+
+// otelDBSystem возвращает значение db.system по соглашениям OpenTelemetry для диалекта
+func otelDBSystem(dialect string) string {
+    if dialect == "postgres" {
+        return "postgresql"
+    }
+    return dialect
+}
+
+// finishQuery завершает выполненный запрос: лог, лог медленных запросов, бюджет, метрики и участок трассировки
+func (db *Database) finishQuery(span Span, name, query string, args []interface{}, op operation, elapsed time.Duration, err error) {
+    db.logQuery(name, args, elapsed, err)
+    db.logSlowQuery(name, query, args, elapsed)
+    db.chargeBudget(name, elapsed)
+    db.breaker.record(err, db.now())
+
+    status := "ok"
+    if err != nil {
+        status = "error"
+    }
+    telemetry := db.telemetrySink()
+    telemetry.Count(metricQueries, 1, Label{"query", name}, Label{"operation", op.String()}, Label{"status", status})
+    telemetry.Observe(metricQueryDuration, elapsed.Seconds(), Label{"query", name}, Label{"operation", op.String()})
+    span.End(err)
+}
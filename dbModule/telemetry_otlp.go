@@ -0,0 +1,422 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// OTLPBatchSize - сколько участков OTLPTelemetry отправляет одним запросом
+var OTLPBatchSize = 512
+
+// OTLPFlushInterval - как часто OTLPTelemetry отправляет накопленные участки
+var OTLPFlushInterval = 5 * time.Second
+
+// otlpQueueSize - сколько завершенных участков ждут отправки; участки сверх очереди отбрасываются
+const otlpQueueSize = 4096
+
+// otlpIntAttributes - атрибуты, которые отправляются числами, а не строками
+var otlpIntAttributes = map[string]bool{"db.rows_affected": true}
+
+// TraceContext - контекст трассировки W3C Trace Context: трассировка, текущий участок и решение о записи
+type TraceContext struct {
+    TraceID [16]byte
+    SpanID  [8]byte
+    Sampled bool
+}
+
+// traceContextKey - ключ TraceContext в контексте
+type traceContextKey struct{}
+
+// ContextWithTraceParent добавляет к ctx контекст трассировки из заголовка traceparent
+// (00-<trace-id>-<parent-id>-<flags>), чтобы участки запросов к базе продолжили трассировку
+// вызывающего сервиса. Пустой или неверный заголовок оставляет ctx без изменений
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+    parts := strings.Split(strings.TrimSpace(traceparent), "-")
+    if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+        return ctx
+    }
+    var trace TraceContext
+    var flags [1]byte
+    _, errTrace := hex.Decode(trace.TraceID[:], []byte(parts[1]))
+    _, errSpan := hex.Decode(trace.SpanID[:], []byte(parts[2]))
+    _, errFlags := hex.Decode(flags[:], []byte(parts[3]))
+    if errTrace != nil || errSpan != nil || errFlags != nil || trace.TraceID == [16]byte{} || trace.SpanID == [8]byte{} {
+        return ctx
+    }
+    trace.Sampled = flags[0]&1 == 1
+    return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// TraceParent возвращает заголовок traceparent текущего участка ctx для передачи дальше или ""
+func TraceParent(ctx context.Context) string {
+    trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+    if !ok {
+        return ""
+    }
+    flags := "00"
+    if trace.Sampled {
+        flags = "01"
+    }
+    return "00-" + hex.EncodeToString(trace.TraceID[:]) + "-" + hex.EncodeToString(trace.SpanID[:]) + "-" + flags
+}
+
+// OTLPTelemetry отправляет трассировку и метрики коллектору OpenTelemetry по OTLP/HTTP в формате JSON.
+// Участки - на каждый запрос (с db.statement, db.operation, db.system и db.rows_affected) и на каждую
+// транзакцию InTx; они продолжают трассировку из контекста (см. WithContext и ContextWithTraceParent),
+// а без нее начинают новую. Счетчики и гистограммы копятся в памяти и отправляются накопительными
+// (cumulative) суммами, гистограммы - с границами prometheusBuckets. Участки и метрики отправляются
+// в фоне: участки - пачками, метрики - раз в OTLPFlushInterval; ошибки отправки пишутся в лог и не ломают запросы
+type OTLPTelemetry struct {
+    endpoint string
+    service  string
+    client   *http.Client
+    spans    chan *otlpSpan
+    stop     chan struct{}
+    stopped  chan struct{}
+    once     sync.Once
+
+    mu sync.Mutex
+    // started - начало накопления метрик (startTimeUnixNano точек)
+    started    time.Time
+    counters   map[string]map[string]*otlpSeries
+    histograms map[string]map[string]*otlpSeries
+}
+
+// otlpSeries - одна серия метрики: значение счетчика или гистограмма
+type otlpSeries struct {
+    labels []Label
+    value  float64
+    // buckets - число значений в каждом интервале границ prometheusBuckets и выше последней границы
+    buckets []uint64
+    sum     float64
+    count   uint64
+}
+
+// NewOTLPTelemetry начинает отправлять трассировку и метрики коллектору по адресу endpoint, например
+// http://127.0.0.1:4318 (участки идут на /v1/traces, метрики - на /v1/metrics), от имени сервиса service
+func NewOTLPTelemetry(endpoint, service string) *OTLPTelemetry {
+    t := &OTLPTelemetry{
+        endpoint:   strings.TrimSuffix(endpoint, "/"),
+        service:    service,
+        client:     &http.Client{Timeout: 10 * time.Second},
+        spans:      make(chan *otlpSpan, otlpQueueSize),
+        stop:       make(chan struct{}),
+        stopped:    make(chan struct{}),
+        started:    time.Now(),
+        counters:   make(map[string]map[string]*otlpSeries),
+        histograms: make(map[string]map[string]*otlpSeries),
+    }
+    go t.run()
+    return t
+}
+
+// Count увеличивает счетчик name на delta
+func (t *OTLPTelemetry) Count(name string, delta float64, labels ...Label) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    otlpSeriesOf(t.counters, name, labels).value += delta
+}
+
+// Observe добавляет значение в гистограмму name
+func (t *OTLPTelemetry) Observe(name string, value float64, labels ...Label) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    series := otlpSeriesOf(t.histograms, name, labels)
+    if series.buckets == nil {
+        series.buckets = make([]uint64, len(prometheusBuckets)+1)
+    }
+    bucket := len(prometheusBuckets)
+    for i, bound := range prometheusBuckets {
+        if value <= bound {
+            bucket = i
+            break
+        }
+    }
+    series.buckets[bucket]++
+    series.sum += value
+    series.count++
+}
+
+// otlpSeriesOf возвращает серию метрики name с метками labels, создавая ее при первом обращении
+func otlpSeriesOf(metrics map[string]map[string]*otlpSeries, name string, labels []Label) *otlpSeries {
+    if metrics[name] == nil {
+        metrics[name] = make(map[string]*otlpSeries)
+    }
+    var key strings.Builder
+    for _, label := range labels {
+        fmt.Fprintf(&key, "%q=%q,", label.Key, label.Value)
+    }
+    series := metrics[name][key.String()]
+    if series == nil {
+        series = &otlpSeries{labels: labels}
+        metrics[name][key.String()] = series
+    }
+    return series
+}
+
+// StartSpan начинает участок новой трассировки
+func (t *OTLPTelemetry) StartSpan(name string, attributes ...Label) Span {
+    _, span := t.StartSpanContext(context.Background(), name, attributes...)
+    return span
+}
+
+// StartSpanContext начинает участок, вложенный в участок ctx, и возвращает контекст с новым участком.
+// Трассировки, которые вызывающий сервис решил не записывать, не записываются и здесь
+func (t *OTLPTelemetry) StartSpanContext(ctx context.Context, name string, attributes ...Label) (context.Context, Span) {
+    parent, ok := ctx.Value(traceContextKey{}).(TraceContext)
+    if ok && !parent.Sampled {
+        return ctx, noopSpan{}
+    }
+    span := &otlpSpan{telemetry: t, name: name, attributes: attributes, started: time.Now()}
+    if ok {
+        span.traceID, span.parentID = parent.TraceID, parent.SpanID
+    } else {
+        rand.Read(span.traceID[:])
+    }
+    rand.Read(span.spanID[:])
+    return context.WithValue(ctx, traceContextKey{}, TraceContext{TraceID: span.traceID, SpanID: span.spanID, Sampled: true}), span
+}
+
+// Close отправляет накопленные участки и метрики и останавливает отправку
+func (t *OTLPTelemetry) Close() error {
+    t.once.Do(func() { close(t.stop) })
+    <-t.stopped
+    return nil
+}
+
+// run отправляет участки пачками по OTLPBatchSize или раз в OTLPFlushInterval, а метрики -
+// раз в OTLPFlushInterval, пока не вызван Close; при остановке отправляется все накопленное
+func (t *OTLPTelemetry) run() {
+    defer close(t.stopped)
+    ticker := time.NewTicker(OTLPFlushInterval)
+    defer ticker.Stop()
+
+    var batch []*otlpSpan
+    flush := func() {
+        if len(batch) == 0 {
+            return
+        }
+        if err := t.export(batch); err != nil {
+            log.Printf("otlp: dropped %d spans: %v", len(batch), err)
+        }
+        batch = nil
+    }
+    flushMetrics := func() {
+        if err := t.exportMetrics(); err != nil {
+            log.Printf("otlp: metrics not sent: %v", err)
+        }
+    }
+    for {
+        select {
+        case span := <-t.spans:
+            batch = append(batch, span)
+            if len(batch) >= OTLPBatchSize {
+                flush()
+            }
+        case <-ticker.C:
+            flush()
+            flushMetrics()
+        case <-t.stop:
+            for {
+                select {
+                case span := <-t.spans:
+                    batch = append(batch, span)
+                default:
+                    flush()
+                    flushMetrics()
+                    return
+                }
+            }
+        }
+    }
+}
+
+// export отправляет пачку участков одним запросом ExportTraceServiceRequest
+func (t *OTLPTelemetry) export(batch []*otlpSpan) error {
+    spans := make([]interface{}, len(batch))
+    for i, span := range batch {
+        spans[i] = span.otlp()
+    }
+    request := map[string]interface{}{
+        "resourceSpans": []interface{}{map[string]interface{}{
+            "resource": map[string]interface{}{
+                "attributes": otlpAttributes([]Label{{"service.name", t.service}}),
+            },
+            "scopeSpans": []interface{}{map[string]interface{}{
+                "scope": map[string]interface{}{"name": "dbModule"},
+                "spans": spans,
+            }},
+        }},
+    }
+    return t.post("/v1/traces", request)
+}
+
+// exportMetrics отправляет накопленные метрики одним запросом ExportMetricsServiceRequest;
+// без метрик ничего не отправляется
+func (t *OTLPTelemetry) exportMetrics() error {
+    now := strconv.FormatInt(time.Now().UnixNano(), 10)
+    var metrics []interface{}
+
+    t.mu.Lock()
+    started := strconv.FormatInt(t.started.UnixNano(), 10)
+    for _, name := range sortedKeys(t.counters) {
+        var points []interface{}
+        for _, key := range sortedKeys(t.counters[name]) {
+            series := t.counters[name][key]
+            points = append(points, map[string]interface{}{
+                "attributes":        otlpAttributes(series.labels),
+                "startTimeUnixNano": started,
+                "timeUnixNano":      now,
+                "asDouble":          series.value,
+            })
+        }
+        metrics = append(metrics, map[string]interface{}{
+            "name": name,
+            // 2 - AGGREGATION_TEMPORALITY_CUMULATIVE: значения растут с начала накопления
+            "sum": map[string]interface{}{"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": points},
+        })
+    }
+    for _, name := range sortedKeys(t.histograms) {
+        var points []interface{}
+        for _, key := range sortedKeys(t.histograms[name]) {
+            series := t.histograms[name][key]
+            buckets := make([]string, len(series.buckets))
+            for i, n := range series.buckets {
+                buckets[i] = strconv.FormatUint(n, 10)
+            }
+            points = append(points, map[string]interface{}{
+                "attributes":        otlpAttributes(series.labels),
+                "startTimeUnixNano": started,
+                "timeUnixNano":      now,
+                "count":             strconv.FormatUint(series.count, 10),
+                "sum":               series.sum,
+                "bucketCounts":      buckets,
+                "explicitBounds":    prometheusBuckets,
+            })
+        }
+        metrics = append(metrics, map[string]interface{}{
+            "name":      name,
+            "histogram": map[string]interface{}{"aggregationTemporality": 2, "dataPoints": points},
+        })
+    }
+    t.mu.Unlock()
+
+    if len(metrics) == 0 {
+        return nil
+    }
+    request := map[string]interface{}{
+        "resourceMetrics": []interface{}{map[string]interface{}{
+            "resource": map[string]interface{}{
+                "attributes": otlpAttributes([]Label{{"service.name", t.service}}),
+            },
+            "scopeMetrics": []interface{}{map[string]interface{}{
+                "scope":   map[string]interface{}{"name": "dbModule"},
+                "metrics": metrics,
+            }},
+        }},
+    }
+    return t.post("/v1/metrics", request)
+}
+
+// post отправляет запрос OTLP в JSON на путь path коллектора
+func (t *OTLPTelemetry) post(path string, request interface{}) error {
+    body, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    url := t.endpoint + path
+    resp, err := t.client.Post(url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("collector %s answered %s", url, resp.Status)
+    }
+    return nil
+}
+
+// otlpSpan - участок OTLPTelemetry
+type otlpSpan struct {
+    telemetry  *OTLPTelemetry
+    name       string
+    attributes []Label
+    traceID    [16]byte
+    spanID     [8]byte
+    parentID   [8]byte
+    started    time.Time
+    ended      time.Time
+    err        string
+}
+
+// SetAttributes добавляет атрибуты к участку до его завершения
+func (span *otlpSpan) SetAttributes(attributes ...Label) {
+    span.attributes = append(span.attributes, attributes...)
+}
+
+// End завершает участок и ставит его в очередь отправки; при переполненной очереди участок теряется
+func (span *otlpSpan) End(err error) {
+    span.ended = time.Now()
+    if err != nil {
+        span.err = err.Error()
+    }
+    select {
+    case span.telemetry.spans <- span:
+    default:
+    }
+}
+
+// otlp возвращает участок в JSON-представлении OTLP. Участки запросов (с db.statement) - вида
+// CLIENT и называются именем запроса, остальные - INTERNAL
+func (span *otlpSpan) otlp() map[string]interface{} {
+    name, kind := span.name, 1
+    for _, attribute := range span.attributes {
+        switch attribute.Key {
+        case "db.statement":
+            kind = 3
+        case "query":
+            name = attribute.Value
+        }
+    }
+    result := map[string]interface{}{
+        "traceId":           hex.EncodeToString(span.traceID[:]),
+        "spanId":            hex.EncodeToString(span.spanID[:]),
+        "name":              name,
+        "kind":              kind,
+        "startTimeUnixNano": strconv.FormatInt(span.started.UnixNano(), 10),
+        "endTimeUnixNano":   strconv.FormatInt(span.ended.UnixNano(), 10),
+        "attributes":        otlpAttributes(span.attributes),
+    }
+    if span.parentID != [8]byte{} {
+        result["parentSpanId"] = hex.EncodeToString(span.parentID[:])
+    }
+    if span.err != "" {
+        result["status"] = map[string]interface{}{"code": 2, "message": span.err}
+    } else {
+        result["status"] = map[string]interface{}{"code": 1}
+    }
+    return result
+}
+
+// otlpAttributes переводит метки в атрибуты OTLP
+func otlpAttributes(labels []Label) []interface{} {
+    attributes := make([]interface{}, len(labels))
+    for i, label := range labels {
+        value := map[string]interface{}{"stringValue": label.Value}
+        if otlpIntAttributes[label.Key] {
+            value = map[string]interface{}{"intValue": label.Value}
+        }
+        attributes[i] = map[string]interface{}{"key": label.Key, "value": value}
+    }
+    return attributes
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "sync"
+    "testing"
+)
+
+// otlpCollector - коллектор OTLP/HTTP для тестов: запоминает присланные запросы по путям
+type otlpCollector struct {
+    mu       sync.Mutex
+    requests map[string][]map[string]interface{}
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    body, _ := io.ReadAll(r.Body)
+    var request map[string]interface{}
+    if err := json.Unmarshal(body, &request); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.requests[r.URL.Path] = append(c.requests[r.URL.Path], request)
+}
+
+// otlpPath проходит по вложенным объектам и массивам JSON: строки - ключи, числа - индексы
+func otlpPath(t *testing.T, value interface{}, path ...interface{}) interface{} {
+    t.Helper()
+    for _, step := range path {
+        switch step := step.(type) {
+        case string:
+            object, ok := value.(map[string]interface{})
+            if !ok {
+                t.Fatalf("no %q in %v", step, value)
+            }
+            value = object[step]
+        case int:
+            array, ok := value.([]interface{})
+            if !ok || step >= len(array) {
+                t.Fatalf("no item %d in %v", step, value)
+            }
+            value = array[step]
+        }
+    }
+    return value
+}
+
+func TestOTLPTelemetryExportsSpansAndMetrics(t *testing.T) {
+    collector := &otlpCollector{requests: make(map[string][]map[string]interface{})}
+    server := httptest.NewServer(collector)
+    defer server.Close()
+
+    telemetry := NewOTLPTelemetry(server.URL, "dbmodule-test")
+    db := NewTestDatabase(t)
+    db.SetTelemetry(telemetry)
+    const selects = 3
+    for i := 0; i < selects; i++ {
+        if _, err := db.SelectUsers(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Close отправляет все накопленное, не дожидаясь OTLPFlushInterval
+    telemetry.Close()
+
+    collector.mu.Lock()
+    defer collector.mu.Unlock()
+    if len(collector.requests["/v1/traces"]) == 0 {
+        t.Fatal("no spans were sent to /v1/traces")
+    }
+    metricsRequests := collector.requests["/v1/metrics"]
+    if len(metricsRequests) != 1 {
+        t.Fatalf("got %d metrics requests, want 1", len(metricsRequests))
+    }
+    service := otlpPath(t, metricsRequests[0], "resourceMetrics", 0, "resource", "attributes", 0, "value", "stringValue")
+    if service != "dbmodule-test" {
+        t.Errorf("service.name = %v, want dbmodule-test", service)
+    }
+
+    metrics := otlpPath(t, metricsRequests[0], "resourceMetrics", 0, "scopeMetrics", 0, "metrics").([]interface{})
+    found := map[string]bool{}
+    // точки users.select в счетчике и в гистограмме
+    selectPoints := 0
+    for _, metric := range metrics {
+        name := otlpPath(t, metric, "name").(string)
+        found[name] = true
+        if name != metricQueries && name != metricQueryDuration {
+            continue
+        }
+        var points []interface{}
+        if name == metricQueries {
+            points = otlpPath(t, metric, "sum", "dataPoints").([]interface{})
+        } else {
+            points = otlpPath(t, metric, "histogram", "dataPoints").([]interface{})
+        }
+        for _, point := range points {
+            attributes := otlpPath(t, point, "attributes").([]interface{})
+            if otlpPath(t, attributes[0], "value", "stringValue") != "users.select" {
+                continue
+            }
+            selectPoints++
+            if name == metricQueries {
+                if value := otlpPath(t, point, "asDouble"); value != float64(selects) {
+                    t.Errorf("%s for users.select = %v, want %d", name, value, selects)
+                }
+                continue
+            }
+            if count := otlpPath(t, point, "count"); count != strconv.Itoa(selects) {
+                t.Errorf("%s count for users.select = %v, want %d", name, count, selects)
+            }
+            buckets := otlpPath(t, point, "bucketCounts").([]interface{})
+            bounds := otlpPath(t, point, "explicitBounds").([]interface{})
+            if len(buckets) != len(bounds)+1 {
+                t.Errorf("%d bucket counts for %d bounds, want one more than bounds", len(buckets), len(bounds))
+            }
+        }
+    }
+    if selectPoints != 2 {
+        t.Errorf("got %d users.select points, want one counter and one histogram point", selectPoints)
+    }
+    for _, name := range []string{metricQueries, metricQueryDuration} {
+        if !found[name] {
+            t.Errorf("metric %s was not sent", name)
+        }
+    }
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// prometheusBuckets - границы гистограмм в секундах, как у клиента Prometheus по умолчанию
+var prometheusBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusTelemetry копит счетчики и гистограммы в памяти и отдает их как http.Handler
+// в текстовом формате Prometheus. Точки в именах заменяются на "_", например db_queries_total.
+// Трассировки у Prometheus нет, поэтому участки не записываются
+type PrometheusTelemetry struct {
+    mu         sync.Mutex
+    counters   map[string]map[string]float64
+    histograms map[string]map[string]*promHistogram
+}
+
+// promHistogram - одна серия гистограммы
+type promHistogram struct {
+    buckets []uint64
+    sum     float64
+    count   uint64
+}
+
+// NewPrometheusTelemetry создает пустой PrometheusTelemetry
+func NewPrometheusTelemetry() *PrometheusTelemetry {
+    return &PrometheusTelemetry{
+        counters:   make(map[string]map[string]float64),
+        histograms: make(map[string]map[string]*promHistogram),
+    }
+}
+
+// Count увеличивает счетчик; в выдаче к имени добавляется суффикс _total
+func (p *PrometheusTelemetry) Count(name string, delta float64, labels ...Label) {
+    name, series := promName(name)+"_total", promLabels(labels)
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.counters[name] == nil {
+        p.counters[name] = make(map[string]float64)
+    }
+    p.counters[name][series] += delta
+}
+
+// Observe добавляет значение в гистограмму с границами prometheusBuckets
+func (p *PrometheusTelemetry) Observe(name string, value float64, labels ...Label) {
+    name, series := promName(name), promLabels(labels)
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.histograms[name] == nil {
+        p.histograms[name] = make(map[string]*promHistogram)
+    }
+    h := p.histograms[name][series]
+    if h == nil {
+        h = &promHistogram{buckets: make([]uint64, len(prometheusBuckets))}
+        p.histograms[name][series] = h
+    }
+    for i, bound := range prometheusBuckets {
+        if value <= bound {
+            h.buckets[i]++
+        }
+    }
+    h.sum += value
+    h.count++
+}
+
+// StartSpan возвращает участок, который ничего не записывает
+func (p *PrometheusTelemetry) StartSpan(string, ...Label) Span {
+    return noopSpan{}
+}
+
+// ServeHTTP отдает накопленные метрики, например на /metrics
+func (p *PrometheusTelemetry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var b strings.Builder
+    p.mu.Lock()
+    for _, name := range sortedKeys(p.counters) {
+        fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+        for _, series := range sortedKeys(p.counters[name]) {
+            fmt.Fprintf(&b, "%s%s %s\n", name, series, promValue(p.counters[name][series]))
+        }
+    }
+    for _, name := range sortedKeys(p.histograms) {
+        fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+        for _, series := range sortedKeys(p.histograms[name]) {
+            h := p.histograms[name][series]
+            for i, bound := range prometheusBuckets {
+                fmt.Fprintf(&b, "%s_bucket%s %d\n", name, withLabel(series, "le", promValue(bound)), h.buckets[i])
+            }
+            fmt.Fprintf(&b, "%s_bucket%s %d\n", name, withLabel(series, "le", "+Inf"), h.count)
+            fmt.Fprintf(&b, "%s_sum%s %s\n", name, series, promValue(h.sum))
+            fmt.Fprintf(&b, "%s_count%s %d\n", name, series, h.count)
+        }
+    }
+    p.mu.Unlock()
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.Write([]byte(b.String()))
+}
+
+// promName переводит имя метрики модуля в допустимое для Prometheus
+func promName(name string) string {
+    return strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+            return r
+        }
+        return '_'
+    }, name)
+}
+
+// promLabels записывает метки серии в виде {key="value",...}, отсортированными по ключу
+func promLabels(labels []Label) string {
+    if len(labels) == 0 {
+        return ""
+    }
+    sorted := append([]Label(nil), labels...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+    parts := make([]string, len(sorted))
+    for i, label := range sorted {
+        parts[i] = fmt.Sprintf("%s=%s", promName(label.Key), strconv.Quote(label.Value))
+    }
+    return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withLabel добавляет метку к уже записанным меткам серии
+func withLabel(series, key, value string) string {
+    label := fmt.Sprintf("%s=%q", key, value)
+    if series == "" {
+        return "{" + label + "}"
+    }
+    return strings.TrimSuffix(series, "}") + "," + label + "}"
+}
+
+// promValue записывает число так, как его читает Prometheus
+func promValue(value float64) string {
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys возвращает ключи карты по возрастанию, чтобы выдача была стабильной
+func sortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
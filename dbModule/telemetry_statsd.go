@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+// StatsdTelemetry отправляет метрики по UDP в statsd с тегами в формате DogStatsD (|#key:value).
+// Гистограммы отправляются как |h, участки трассировки - как время выполнения |ms
+// с тегом status. Ошибки отправки игнорируются: метрики не должны ломать запросы
+type StatsdTelemetry struct {
+    mu   sync.Mutex
+    conn net.Conn
+}
+
+// NewStatsdTelemetry подключается к statsd по адресу addr, например "127.0.0.1:8125"
+func NewStatsdTelemetry(addr string) (*StatsdTelemetry, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, fmt.Errorf("connecting to statsd %s: %w", addr, err)
+    }
+    return &StatsdTelemetry{conn: conn}, nil
+}
+
+// Count отправляет приращение счетчика
+func (s *StatsdTelemetry) Count(name string, delta float64, labels ...Label) {
+    s.send(name, delta, "c", labels)
+}
+
+// Observe отправляет значение гистограммы
+func (s *StatsdTelemetry) Observe(name string, value float64, labels ...Label) {
+    s.send(name, value, "h", labels)
+}
+
+// StartSpan начинает участок; при End отправляется его длительность в миллисекундах
+func (s *StatsdTelemetry) StartSpan(name string, attributes ...Label) Span {
+    return &statsdSpan{statsd: s, name: name, attributes: attributes, started: time.Now()}
+}
+
+// Close закрывает соединение с statsd
+func (s *StatsdTelemetry) Close() error {
+    return s.conn.Close()
+}
+
+// send отправляет одну строку протокола statsd
+func (s *StatsdTelemetry) send(name string, value float64, kind string, labels []Label) {
+    line := fmt.Sprintf("%s:%g|%s", name, value, kind)
+    if len(labels) > 0 {
+        tags := make([]string, len(labels))
+        for i, label := range labels {
+            tags[i] = statsdTag(label.Key) + ":" + statsdTag(label.Value)
+        }
+        line += "|#" + strings.Join(tags, ",")
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.conn.Write([]byte(line))
+}
+
+// statsdTag убирает из тега символы, которые разделяют поля протокола
+func statsdTag(value string) string {
+    return strings.NewReplacer("|", "_", ",", "_", "#", "_", ":", "_", "\n", "_").Replace(value)
+}
+
+// statsdSpan - участок StatsdTelemetry
+type statsdSpan struct {
+    statsd     *StatsdTelemetry
+    name       string
+    attributes []Label
+    started    time.Time
+}
+
+// End отправляет длительность участка с тегом status=ok или status=error
+func (span *statsdSpan) End(err error) {
+    status := "ok"
+    if err != nil {
+        status = "error"
+    }
+    labels := append(append([]Label(nil), span.attributes...), Label{"status", status})
+    span.statsd.send(span.name, float64(time.Since(span.started).Microseconds())/1000, "ms", labels)
+}
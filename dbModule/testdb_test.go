@@ -0,0 +1,222 @@
+package main
+
+// Помощники тестов модуля. Пакет main нельзя импортировать, поэтому помощники, которые возвращают
+// Database, живут в файле _test.go пакета: тесты модуля их видят, а в бинарник ни они, ни пакет testing
+// не попадают. Сами базы - in-memory SQLite, схемы PostgreSQL, копии файлов - готовит пакет dbtest,
+// который могут импортировать и тесты других пакетов
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "dbModule/dbtest"
+)
+
+// NewTestDatabase создает in-memory базу SQLite с примененными миграциями (см. dbtest.MemoryDSN);
+// она удаляется при закрытии в t.Cleanup. Параллельные запросы теста (t.Parallel, горутины) ждут
+// друг друга в пределах busy_timeout
+func NewTestDatabase(t testing.TB) *Database {
+    t.Helper()
+
+    return newMigratedTestDatabase(t, "", dbtest.MemoryDSN())
+}
+
+// testTemplate - мигрированная in-memory база, с которой копируются базы NewIsolatedTestDatabase.
+// Создается один раз на процесс тестов и живет до его конца
+var testTemplate struct {
+    once sync.Once
+    db   *Database
+    err  error
+}
+
+// NewIsolatedTestDatabase создает для теста отдельный файл SQLite в t.TempDir, поэтому тесты
+// с t.Parallel() не видят данных друг друга. Миграции применяются один раз на процесс к шаблону,
+// а база теста - его копия через Backup (VACUUM INTO): это быстрее, чем мигрировать каждую заново.
+// Файл удаляется вместе с временным каталогом теста
+func NewIsolatedTestDatabase(t testing.TB) *Database {
+    t.Helper()
+
+    template, err := migratedTestTemplate()
+    if err != nil {
+        t.Fatalf("prepare template database: %v", err)
+    }
+    path := filepath.Join(t.TempDir(), "test.db")
+    if err := template.Backup(path); err != nil {
+        t.Fatalf("clone template database: %v", err)
+    }
+
+    db, err := NewDatabaseWithConfig(DefaultConfig(path), template.queries)
+    if err != nil {
+        t.Fatalf("open test database: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+// NewDatabaseFromSnapshot открывает для теста копию эталонной базы path (например, testdata/base.db),
+// собранной BuildGoldenSnapshot: схема и фикстуры уже на месте, и тест не тратит время на миграции
+// и загрузку данных. Копия лежит в t.TempDir, поэтому тесты не меняют эталон и не видят данных
+// друг друга. Если у эталона не хватает миграций, тест проваливается с командой, которая его пересоберет
+func NewDatabaseFromSnapshot(t testing.TB, path string) *Database {
+    t.Helper()
+
+    if _, err := os.Stat(path); err != nil {
+        t.Fatalf("read golden database (create it with `dbModule golden -out %s`): %v", path, err)
+    }
+    copied := dbtest.CopyFile(t, path)
+
+    queries, err := LoadQueries(*queriesFlag)
+    if err != nil {
+        t.Fatalf("load queries: %v", err)
+    }
+    db, err := NewDatabaseWithConfig(DefaultConfig(copied), queries)
+    if err != nil {
+        t.Fatalf("open golden database: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    pending, err := db.PendingMigrations()
+    if err != nil {
+        t.Fatalf("check golden database migrations: %v", err)
+    }
+    if len(pending) > 0 {
+        t.Fatalf("golden database %s lacks migrations %s, regenerate it with `dbModule golden -out %s`", path, strings.Join(pending, ", "), path)
+    }
+    return db
+}
+
+// migratedTestTemplate открывает и мигрирует шаблон при первом вызове
+func migratedTestTemplate() (*Database, error) {
+    testTemplate.once.Do(func() {
+        queries, err := LoadQueries(*queriesFlag)
+        if err != nil {
+            testTemplate.err = err
+            return
+        }
+        name := dbtest.UniqueName("template")
+        // без очереди записи: копирование шаблона только читает его, и параллельные копии не ждут друг друга
+        config := Config{DSN: "file:" + name + "?mode=memory&cache=shared", Timeouts: DefaultOperationTimeouts}
+        db, err := NewDatabaseWithConfig(config, queries)
+        if err != nil {
+            testTemplate.err = err
+            return
+        }
+        if err := db.Migrate(); err != nil {
+            db.Close()
+            testTemplate.err = err
+            return
+        }
+        testTemplate.db = db
+    })
+    return testTemplate.db, testTemplate.err
+}
+
+// NewIsolatedPostgresTestDatabase создает для теста отдельную схему в базе DBMODULE_TEST_POSTGRES_DSN
+// и применяет в ней миграции, поэтому тесты с t.Parallel() не видят данных друг друга; схема
+// удаляется в t.Cleanup (см. dbtest.PostgresSchema). Без DSN или драйвера (-tags postgres) тест пропускается
+func NewIsolatedPostgresTestDatabase(t testing.TB) *Database {
+    t.Helper()
+
+    if _, err := lookupDriver("postgres"); err != nil {
+        t.Skip("postgres driver is not compiled in, run tests with -tags postgres")
+    }
+    dsn, schema := dbtest.PostgresSchema(t)
+    return newMigratedTestDatabase(t, "postgres", appendDSNParam(dsn, "search_path="+schema+",public"))
+}
+
+// NewPostgresTestDatabase создает базу PostgreSQL для интеграционных тестов (см. dbtest.PostgresDSN):
+// из DBMODULE_TEST_POSTGRES_DSN или временного контейнера при DBMODULE_TEST_POSTGRES=docker.
+// Без этих переменных или без драйвера (-tags postgres) тест пропускается
+func NewPostgresTestDatabase(t testing.TB) *Database {
+    t.Helper()
+
+    if _, err := lookupDriver("postgres"); err != nil {
+        t.Skip("postgres driver is not compiled in, run tests with -tags postgres")
+    }
+    db := newMigratedTestDatabase(t, "postgres", dbtest.PostgresDSN(t))
+    // внешняя база может быть общей, поэтому начинаем без данных предыдущих запусков
+    if _, err := db.TruncateAll(TruncateOptions{Confirm: true}); err != nil {
+        t.Fatalf("truncate test database: %v", err)
+    }
+    return db
+}
+
+// newMigratedTestDatabase открывает базу, применяет миграции и регистрирует закрытие
+func newMigratedTestDatabase(t testing.TB, driverName, dsn string) *Database {
+    t.Helper()
+
+    queries, err := LoadQueries(*queriesFlag)
+    if err != nil {
+        t.Fatalf("load queries: %v", err)
+    }
+
+    db, err := NewDatabaseWithDriver(driverName, dsn, queries)
+    if err != nil {
+        t.Fatalf("open test database: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    if err := db.Migrate(); err != nil {
+        t.Fatalf("migrate test database: %v", err)
+    }
+    return db
+}
+
+// FreezeTestClock останавливает часы db на at и включает предсказуемые идентификаторы
+// test-1, test-2 и т. д. (см. SetClock, SetIDGenerator). Время идет только через Advance или Set
+// возвращенных часов, поэтому сроки сессий, истечение кешей и времена создания записей повторяются
+func FreezeTestClock(t testing.TB, db *Database, at time.Time) *ManualClock {
+    t.Helper()
+
+    clock := NewManualClock(at)
+    db.SetClock(clock)
+    db.SetIDGenerator(&SequentialIDs{Prefix: "test"})
+    return clock
+}
+
+// AuditTestSQL включает для db проверку параметризации (см. SQLAudit) с метками markers
+// и проваливает тест в t.Cleanup, если какая-то метка попала в текст запроса.
+// Метки дописываются к входным данным теста, например strings.Repeat("'", 3)+"zqmark"
+func AuditTestSQL(t testing.TB, db *Database, markers ...string) *Database {
+    t.Helper()
+
+    audit := NewSQLAudit(markers...)
+    t.Cleanup(func() {
+        if err := audit.Err(); err != nil {
+            t.Error(err)
+        }
+    })
+    return db.WithSQLAudit(audit)
+}
+
+// sqlInputMarker дописывается к входу checkSQLInput, чтобы AuditTestSQL нашел его в тексте запроса
+const sqlInputMarker = "zqinputq"
+
+// checkSQLInput прогоняет input через API модуля теми же шагами, что RunSQLFuzz, на изолированной базе
+// с проверкой параметризации (см. AuditTestSQL) и проваливает тест при находках. Предназначена для цели
+// f.Fuzz нативного фаззинга Go (см. FuzzSQLInput)
+func checkSQLInput(t testing.TB, input string) {
+    t.Helper()
+
+    db := NewIsolatedTestDatabase(t)
+    // вход служит и паролем: политика паролей отвергла бы короткий раньше, чем он дойдет до SQL
+    audited := AuditTestSQL(t, db.withoutPasswordPolicy(), sqlInputMarker)
+    report := SQLFuzzReport{Inputs: 1}
+    fuzz := &sqlFuzzRun{db: audited, input: input, value: input + sqlInputMarker, report: &report}
+    fuzz.run()
+    for _, failure := range report.Failures {
+        t.Error(failure)
+    }
+
+    // враждебный вход не должен был удалить или переписать таблицы
+    if _, err := db.CountUsers(); err != nil {
+        t.Errorf("database damaged by %q: %v", input, err)
+    }
+    if _, err := db.CountRestaurants(RestaurantFilter{}); err != nil {
+        t.Errorf("database damaged by %q: %v", input, err)
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// OperationTimeouts ограничивают время выполнения запросов по видам операций; 0 - без ограничения.
+// По истечении запрос прерывается драйвером и возвращает ошибку с context.DeadlineExceeded
+type OperationTimeouts struct {
+    // Read - один запрос на чтение, включая чтение всех его строк
+    Read time.Duration
+    // Write - один изменяющий запрос, а также транзакция InTx целиком
+    Write time.Duration
+    // Migration - одна миграция, перестройка таблицы или восстановление из снимка
+    Migration time.Duration
+}
+
+// DefaultOperationTimeouts - медленный JOIN не подвешивает сервис, а миграциям больших таблиц хватает времени
+var DefaultOperationTimeouts = OperationTimeouts{
+    Read:      30 * time.Second,
+    Write:     30 * time.Second,
+    Migration: 30 * time.Minute,
+}
+
+// migrationNamespaces - запросы, которые выполняются с таймаутом миграций
+var migrationNamespaces = map[string]bool{
+    "schema":        true,
+    "migrations":    true,
+    "rebuild":       true,
+    "backup":        true,
+    // перенос данных читает таблицы целиком (см. MigrateData)
+    "migrate_data":  true,
+    // ALTER TABLE изменений схемы без долгих блокировок (см. AddColumnWithDefault)
+    "online_schema": true,
+}
+
+// operation - вид операции для выбора таймаута
+type operation int
+
+const (
+    operationRead operation = iota
+    operationWrite
+    operationMigration
+)
+
+// String возвращает название вида операции для сообщений об ошибках
+func (op operation) String() string {
+    switch op {
+    case operationWrite:
+        return "write"
+    case operationMigration:
+        return "migration"
+    }
+    return "read"
+}
+
+// queryOperation определяет вид операции именованного запроса
+func queryOperation(name string, write bool) operation {
+    if migrationNamespaces[strings.SplitN(name, ".", 2)[0]] {
+        return operationMigration
+    }
+    if write {
+        return operationWrite
+    }
+    return operationRead
+}
+
+// timeout возвращает таймаут вида операции
+func (t OperationTimeouts) timeout(op operation) time.Duration {
+    switch op {
+    case operationWrite:
+        return t.Write
+    case operationMigration:
+        return t.Migration
+    }
+    return t.Read
+}
+
+// WithContext возвращает копию Database, запросы которой получают значения ctx, например контекст
+// трассировки входящего HTTP-запроса (см. ContextWithTraceParent). Отмена и срок ctx не передаются:
+// запросы ограничены своими таймаутами (см. OperationTimeouts) и не обрываются вместе с ctx
+func (db *Database) WithContext(ctx context.Context) *Database {
+    scoped := *db
+    scoped.ctx = context.WithoutCancel(ctx)
+    return &scoped
+}
+
+// WithRequestContext возвращает копию Database, как WithContext, но чтения вне транзакций обрываются
+// вместе с ctx: когда клиент HTTP отключился, запрос к базе отменяется, а цикл по строкам списка
+// останавливается - Next возвращает false, Err - ошибку с context.Canceled. Запись и транзакции
+// отмену ctx не видят и доводятся до конца, чтобы уход клиента не оставлял изменения наполовину
+func (db *Database) WithRequestContext(ctx context.Context) *Database {
+    scoped := db.WithContext(ctx)
+    scoped.requestCtx = ctx
+    return scoped
+}
+
+// baseContext возвращает контекст, от которого отсчитываются контексты операций (см. WithContext)
+func (db *Database) baseContext() context.Context {
+    if db.ctx == nil {
+        return context.Background()
+    }
+    return db.ctx
+}
+
+// operationContext возвращает контекст с таймаутом вида операции; cancel нужно вызвать по ее окончании
+func (db *Database) operationContext(op operation) (context.Context, context.CancelFunc) {
+    base := db.baseContext()
+    if op == operationRead && db.tx == nil && db.requestCtx != nil {
+        base = db.requestCtx
+    }
+    if timeout := db.timeouts.timeout(op); timeout > 0 {
+        return context.WithTimeout(base, timeout)
+    }
+    return context.WithCancel(base)
+}
+
+// timeoutError дополняет ошибку запроса, прерванного таймаутом ctx, названием запроса и лимитом.
+// Драйверы сообщают о прерывании по-разному, поэтому context.DeadlineExceeded добавляется явно
+func (db *Database) timeoutError(ctx context.Context, name string, op operation, err error) error {
+    if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return err
+    }
+    message := fmt.Sprintf("%s exceeded %s timeout %v", name, op, db.timeouts.timeout(op))
+    if errors.Is(err, context.DeadlineExceeded) {
+        return fmt.Errorf("%s: %w", message, err)
+    }
+    return fmt.Errorf("%s: %w: %w", message, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// TransferRestaurant передает ресторан restaurantID от владельца fromUserID пользователю toUserID
+// в одной транзакции. Если ресторан уже принадлежит другому, ошибка - ErrConflict, как у устаревшей
+// версии: передача не должна перехватить ресторан, проданный кому-то еще. Меню, часы работы, слоты
+// и брони, отзывы, вложения и переводы принадлежат ресторану и переходят к новому владельцу вместе
+// с ним. Покупатель с ролью RoleCustomer становится RoleOwner, чтобы управлять рестораном; роли
+// администратора и прежнего владельца не меняются. Ресторан и изменение роли попадают в журнал аудита
+func (db *Database) TransferRestaurant(restaurantID, fromUserID, toUserID int) error {
+    if fromUserID == toUserID {
+        return db.opError("transfer", "restaurant", restaurantID, &ValidationError{Field: "to_user_id", Message: "must differ from the current owner"})
+    }
+    err := db.InTx(func(tx *Database) error {
+        old, err := tx.findRestaurant("restaurants.select_by_id", restaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if old == nil {
+            return ErrNotFound
+        }
+        if old.UserID != fromUserID {
+            return fmt.Errorf("restaurant is owned by user %d, not %d: %w", old.UserID, fromUserID, ErrConflict)
+        }
+        owner, err := tx.findUser("users.select_by_id", toUserID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if owner == nil {
+            return fmt.Errorf("restaurant owner %d does not exist: %w", toUserID, ErrForeignKeyViolation)
+        }
+
+        result, err := tx.execNamed("restaurants.transfer", toUserID, restaurantID, fromUserID, tx.tenant)
+        if tx.driver.isUniqueError(err) {
+            return fmt.Errorf("user %d already owns a restaurant named %q: %w", toUserID, old.Name, ErrConflict)
+        }
+        if err != nil {
+            return err
+        }
+        if affected, err := result.RowsAffected(); err != nil {
+            return err
+        } else if affected == 0 {
+            return fmt.Errorf("restaurant changed owner concurrently: %w", ErrConflict)
+        }
+        current, err := tx.findRestaurant("restaurants.select_by_id", restaurantID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        if err := tx.audit("restaurant", restaurantID, AuditUpdate, old, current); err != nil {
+            return err
+        }
+
+        if userRole(*owner) != RoleCustomer {
+            return nil
+        }
+        if _, err := tx.execNamed("users.set_role", RoleOwner, toUserID, tx.tenant); err != nil {
+            return err
+        }
+        promoted, err := tx.findUser("users.select_by_id", toUserID, tx.tenant)
+        if err != nil {
+            return err
+        }
+        return tx.audit("user", toUserID, AuditUpdate, owner, promoted)
+    })
+    return db.opError("transfer", "restaurant", restaurantID, err)
+}
+
+// restaurantTransferBody - тело POST /restaurants/{id}/transfer
+type restaurantTransferBody struct {
+    // FromUserID - владелец, от которого передается ресторан; если владелец уже другой, ответ - 409
+    FromUserID int `json:"from_user_id"`
+    ToUserID   int `json:"to_user_id"`
+}
+
+// serveTransferRestaurant передает ресторан новому владельцу и отвечает рестораном после передачи.
+// Пользователь, вошедший через -auth, передает только свой ресторан, если он не администратор
+func (db *Database) serveTransferRestaurant(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    var body restaurantTransferBody
+    if err := decodeBody(r, &body); err != nil {
+        writeError(w, err)
+        return
+    }
+    if actor, ok := requestActor(r); ok {
+        if err := db.authorizeRestaurantID(actor, id); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    if err := db.TransferRestaurant(id, body.FromUserID, body.ToUserID); err != nil {
+        writeError(w, err)
+        return
+    }
+    restaurant, err := db.WithPrimary().GetRestaurantByID(id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, restaurant)
+}
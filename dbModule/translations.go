@@ -0,0 +1,319 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultLocale - язык, на который переводы откатываются, если перевода на запрошенный язык нет
+var DefaultLocale = "ru"
+
+// Translation - перевод поля записи на язык
+type Translation struct {
+    // Entity и EntityID - переведенная запись, например "restaurant" и ее ID
+    Entity    string    `json:"entity" db:"entity"`
+    EntityID  int       `json:"entity_id" db:"entity_id"`
+    Field     string    `json:"field" db:"field"`
+    Locale    string    `json:"locale" db:"locale"`
+    Value     string    `json:"value" db:"value"`
+    UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// translatableEntities - записи, поля которых можно переводить: переводимые поля и проверка, что запись
+// есть на площадке. Внешнего ключа у translations нет, поэтому переводы удаленных записей находит fsck
+var translatableEntities = map[string]struct {
+    fields []string
+    exists func(db *Database, id int) (bool, error)
+}{
+    "restaurant": {
+        fields: []string{"description", "name"},
+        exists: func(db *Database, id int) (bool, error) {
+            restaurant, err := db.findRestaurant("restaurants.select_by_id", id, db.tenant)
+            return restaurant != nil, err
+        },
+    },
+}
+
+// localePattern - язык ISO 639 с необязательным регионом ISO 3166: ru, en, en-US
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// NormalizeLocale приводит язык к виду en или en-US (en_us, EN-us - en-US) и проверяет его
+func NormalizeLocale(locale string) (string, error) {
+    language, region, hasRegion := strings.Cut(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+    normalized := strings.ToLower(language)
+    if hasRegion {
+        normalized += "-" + strings.ToUpper(region)
+    }
+    if !localePattern.MatchString(normalized) {
+        return "", &ValidationError{Field: "locale", Message: fmt.Sprintf("%q is not a language like en or en-US", locale)}
+    }
+    return normalized, nil
+}
+
+// localeFallbacks возвращает языки, на которых ищется перевод для locale, по порядку:
+// сам язык, язык без региона и DefaultLocale
+func localeFallbacks(locale string) []string {
+    locales := []string{locale}
+    if language, _, ok := strings.Cut(locale, "-"); ok {
+        locales = append(locales, language)
+    }
+    if !slices.Contains(locales, DefaultLocale) {
+        locales = append(locales, DefaultLocale)
+    }
+    return locales
+}
+
+// checkTranslatable проверяет, что поле field записи entity можно переводить ("" - любое поле),
+// и что запись id есть на площадке
+func (db *Database) checkTranslatable(entity string, id int, field string) error {
+    spec, ok := translatableEntities[entity]
+    if !ok {
+        return fmt.Errorf("%w: %q has no translatable fields", ErrValidation, entity)
+    }
+    if field != "" && !slices.Contains(spec.fields, field) {
+        return &ValidationError{Field: "field", Message: fmt.Sprintf("%q of %s cannot be translated (expected %s)", field, entity, strings.Join(spec.fields, " or "))}
+    }
+    found, err := spec.exists(db, id)
+    if err == nil && !found {
+        err = fmt.Errorf("%s %d: %w", entity, id, ErrNotFound)
+    }
+    return err
+}
+
+// SetTranslation записывает перевод поля field записи entity с ID id на язык locale.
+// Пустой value удаляет перевод. Изменение пишется в журнал аудита
+func (db *Database) SetTranslation(entity string, id int, field, locale, value string) error {
+    key := fmt.Sprintf("%s %d %s/%s", entity, id, field, locale)
+    locale, err := NormalizeLocale(locale)
+    if err != nil {
+        return db.opError("set", "translation", key, err)
+    }
+    err = db.InTx(func(tx *Database) error {
+        return tx.setTranslation(entity, id, field, locale, value)
+    })
+    return db.opError("set", "translation", key, err)
+}
+
+// setTranslation - SetTranslation в транзакции tx
+func (db *Database) setTranslation(entity string, id int, field, locale, value string) error {
+    if err := db.checkTranslatable(entity, id, field); err != nil {
+        return err
+    }
+    translations, err := db.Translations(entity, id)
+    if err != nil {
+        return err
+    }
+    var old *Translation
+    for i := range translations {
+        if translations[i].Field == field && translations[i].Locale == locale {
+            old = &translations[i]
+        }
+    }
+
+    if value == "" {
+        if old == nil {
+            return nil
+        }
+        if _, err := db.execNamed("translations.delete", entity, id, field, locale, db.tenant); err != nil {
+            return err
+        }
+        return db.audit("translation", id, AuditDelete, *old, nil)
+    }
+
+    translation := Translation{Entity: entity, EntityID: id, Field: field, Locale: locale, Value: value, UpdatedAt: db.now().UTC()}
+    if old == nil {
+        if _, err := db.execNamed("translations.insert", db.tenant, entity, id, field, locale, value, translation.UpdatedAt); err != nil {
+            return err
+        }
+        return db.audit("translation", id, AuditInsert, nil, translation)
+    }
+    if old.Value == value {
+        return nil
+    }
+    if _, err := db.execNamed("translations.update", value, translation.UpdatedAt, entity, id, field, locale, db.tenant); err != nil {
+        return err
+    }
+    return db.audit("translation", id, AuditUpdate, *old, translation)
+}
+
+// Translations возвращает все переводы записи entity с ID id по языкам и полям
+func (db *Database) Translations(entity string, id int) ([]Translation, error) {
+    rows, err := db.queryNamed("translations.select_by_entity", entity, id, db.tenant)
+    if err != nil {
+        return nil, db.opError("list", "translations", fmt.Sprintf("%s %d", entity, id), err)
+    }
+    translations, err := collectRows(db, rows, func(rows *queryRows) (Translation, error) {
+        var translation Translation
+        err := rows.ScanStruct(&translation)
+        return translation, err
+    })
+    return translations, db.opError("list", "translations", fmt.Sprintf("%s %d", entity, id), err)
+}
+
+// Translate возвращает переведенные поля записи для языка locale с откатом на язык без региона
+// и DefaultLocale (см. localeFallbacks): значение поля и язык, с которого оно взято.
+// Поля без перевода ни на один из этих языков в результат не попадают
+func (db *Database) Translate(entity string, id int, locale string) (values, locales map[string]string, err error) {
+    if locale, err = NormalizeLocale(locale); err != nil {
+        return nil, nil, err
+    }
+    translations, err := db.Translations(entity, id)
+    if err != nil {
+        return nil, nil, err
+    }
+    values, locales = map[string]string{}, map[string]string{}
+    for _, fallback := range slices.Backward(localeFallbacks(locale)) {
+        for _, translation := range translations {
+            if translation.Locale == fallback {
+                values[translation.Field], locales[translation.Field] = translation.Value, fallback
+            }
+        }
+    }
+    return values, locales, nil
+}
+
+// RestaurantTranslation - перевод полей ресторана на один язык: nil не меняет перевод поля,
+// пустая строка удаляет его
+type RestaurantTranslation struct {
+    Name        *string `json:"name,omitempty"`
+    Description *string `json:"description,omitempty"`
+}
+
+// SetRestaurantTranslation записывает перевод названия и описания ресторана на язык locale
+// одной транзакцией и возвращает все переводы ресторана
+func (db *Database) SetRestaurantTranslation(id int, locale string, translation RestaurantTranslation) ([]Translation, error) {
+    normalized, err := NormalizeLocale(locale)
+    if err != nil {
+        return nil, db.opError("set", "translation", fmt.Sprintf("restaurant %d/%s", id, locale), err)
+    }
+    var translations []Translation
+    err = db.InTx(func(tx *Database) error {
+        if err := tx.checkTranslatable("restaurant", id, ""); err != nil {
+            return err
+        }
+        fields := []struct {
+            name  string
+            value *string
+        }{{"name", translation.Name}, {"description", translation.Description}}
+        for _, field := range fields {
+            if field.value != nil {
+                if err := tx.setTranslation("restaurant", id, field.name, normalized, *field.value); err != nil {
+                    return err
+                }
+            }
+        }
+        translations, err = tx.Translations("restaurant", id)
+        return err
+    })
+    return translations, db.opError("set", "translation", fmt.Sprintf("restaurant %d/%s", id, normalized), err)
+}
+
+// DeleteRestaurantTranslation удаляет перевод ресторана на язык locale и возвращает оставшиеся переводы
+func (db *Database) DeleteRestaurantTranslation(id int, locale string) ([]Translation, error) {
+    empty := ""
+    return db.SetRestaurantTranslation(id, locale, RestaurantTranslation{Name: &empty, Description: &empty})
+}
+
+// LocalizedRestaurant - ресторан с названием и описанием на запрошенном языке
+type LocalizedRestaurant struct {
+    Restaurant
+    // Name - переведенное название или исходное, если перевода нет
+    Name        string `json:"name"`
+    Description string `json:"description,omitempty"`
+    // Locales - язык, с которого взято каждое переведенное поле (см. Translate)
+    Locales     map[string]string `json:"locales"`
+}
+
+// GetLocalizedRestaurant возвращает ресторан с названием и описанием на языке locale
+// с откатом на язык без региона и DefaultLocale; без перевода остается исходное название
+func (db *Database) GetLocalizedRestaurant(id int, locale string) (LocalizedRestaurant, error) {
+    restaurant, err := db.findRestaurant("restaurants.select_by_id", id, db.tenant)
+    if err == nil && restaurant == nil {
+        err = ErrNotFound
+    }
+    if err != nil {
+        return LocalizedRestaurant{}, db.opError("get", "restaurant", id, err)
+    }
+    values, locales, err := db.Translate("restaurant", id, locale)
+    if err != nil {
+        return LocalizedRestaurant{}, db.opError("get", "restaurant", id, err)
+    }
+    localized := LocalizedRestaurant{Restaurant: *restaurant, Name: restaurant.Name, Description: values["description"], Locales: locales}
+    if name, ok := values["name"]; ok {
+        localized.Name = name
+    }
+    return localized, nil
+}
+
+// requestLocale возвращает язык запроса: параметр locale, первый язык Accept-Language или DefaultLocale
+func requestLocale(r *http.Request) string {
+    if locale := r.URL.Query().Get("locale"); locale != "" {
+        return locale
+    }
+    first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+    if tag, _, _ := strings.Cut(first, ";"); strings.TrimSpace(tag) != "" && strings.TrimSpace(tag) != "*" {
+        return strings.TrimSpace(tag)
+    }
+    return DefaultLocale
+}
+
+// serveLocalizedRestaurant отдает ресторан на языке запроса (см. requestLocale)
+func (db *Database) serveLocalizedRestaurant(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    restaurant, err := db.GetLocalizedRestaurant(id, requestLocale(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, restaurant)
+}
+
+// serveRestaurantTranslations отдает переводы ресторана (GET), записывает перевод на язык пути (PUT)
+// или удаляет его (DELETE) и отвечает всеми переводами ресторана. Пользователь, вошедший через -auth,
+// меняет переводы только своего ресторана, если он не администратор
+func (db *Database) serveRestaurantTranslations(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, &ValidationError{Field: "id", Message: "must be an integer"})
+        return
+    }
+    if actor, ok := requestActor(r); ok && (r.Method == http.MethodPut || r.Method == http.MethodDelete) {
+        if err := db.authorizeRestaurantID(actor, id); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+    var translations []Translation
+    switch r.Method {
+    case http.MethodPut:
+        var body RestaurantTranslation
+        if err := decodeBody(r, &body); err != nil {
+            writeError(w, err)
+            return
+        }
+        translations, err = db.SetRestaurantTranslation(id, r.PathValue("locale"), body)
+    case http.MethodDelete:
+        translations, err = db.DeleteRestaurantTranslation(id, r.PathValue("locale"))
+    default:
+        if err = db.checkTranslatable("restaurant", id, ""); err == nil {
+            translations, err = db.Translations("restaurant", id)
+        }
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if translations == nil {
+        translations = []Translation{}
+    }
+    writeJSON(w, http.StatusOK, translations)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+// Метрики потребления ресурсов в tenant_usage
+const (
+    UsageUsers       = "users"
+    UsageRestaurants = "restaurants"
+    UsageMenuItems   = "menu_items"
+    UsageReviews     = "reviews"
+    // UsageBlobBytes - байты изображений ресторанов и документов пользователей
+    UsageBlobBytes = "blob_bytes"
+    // UsageRequests - запросы к HTTP API и учтенные CountRequest; копится за период, а не пересчитывается
+    UsageRequests = "requests"
+)
+
+// usagePeriodLayout - формат периода учета: календарный месяц по UTC
+const usagePeriodLayout = "2006-01"
+
+// usageStorageQueries - запросы объема хранения и метрика, в которую они складываются
+var usageStorageQueries = []struct {
+    name   string
+    metric string
+}{
+    {"tenant_usage.storage_users", UsageUsers},
+    {"tenant_usage.storage_restaurants", UsageRestaurants},
+    {"tenant_usage.storage_menu_items", UsageMenuItems},
+    {"tenant_usage.storage_reviews", UsageReviews},
+    {"tenant_usage.storage_image_bytes", UsageBlobBytes},
+    {"tenant_usage.storage_document_bytes", UsageBlobBytes},
+}
+
+// ErrQuotaExceeded возвращается CheckQuota, если потребление достигло квоты
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// UsageRecord - значение метрики площадки или ее владельца за период
+type UsageRecord struct {
+    Tenant int
+    // Owner - пользователь, которому принадлежат ресурсы; 0 - площадка целиком
+    Owner  int
+    Metric string
+    // Period - календарный месяц в формате 2026-10
+    Period    string
+    Value     int64
+    UpdatedAt time.Time
+}
+
+// UsageQuota - предельные значения метрик; метрики без предела не ограничены
+type UsageQuota map[string]int64
+
+// QuotaError описывает превышенную квоту; errors.Is(err, ErrQuotaExceeded) выполняется
+type QuotaError struct {
+    Tenant int
+    Owner  int
+    Metric string
+    Limit  int64
+    Used   int64
+}
+
+func (e *QuotaError) Error() string {
+    return fmt.Sprintf("%s: tenant %d owner %d: %s %d of %d", ErrQuotaExceeded, e.Tenant, e.Owner, e.Metric, e.Used, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error {
+    return ErrQuotaExceeded
+}
+
+// usageKey - площадка и владелец, к которым относится значение
+type usageKey struct {
+    tenant int
+    owner  int
+}
+
+// usageCounter копит запросы до записи в tenant_usage, общий для всех копий Database
+type usageCounter struct {
+    mu       sync.Mutex
+    requests map[usageKey]int64
+}
+
+// add учитывает n запросов владельца и его площадки
+func (c *usageCounter) add(key usageKey, n int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.requests == nil {
+        c.requests = map[usageKey]int64{}
+    }
+    c.requests[key] += n
+    if key.owner != 0 {
+        c.requests[usageKey{tenant: key.tenant}] += n
+    }
+}
+
+// take возвращает накопленные запросы и начинает счет заново
+func (c *usageCounter) take() map[usageKey]int64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    requests := c.requests
+    c.requests = nil
+    return requests
+}
+
+// restore возвращает в счетчик запросы, которые не удалось записать
+func (c *usageCounter) restore(requests map[usageKey]int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.requests == nil {
+        c.requests = map[usageKey]int64{}
+    }
+    for key, n := range requests {
+        c.requests[key] += n
+    }
+}
+
+// pending возвращает еще не записанные запросы владельца
+func (c *usageCounter) pending(key usageKey) int64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.requests[key]
+}
+
+// UsagePeriod возвращает период учета, в который попадает t
+func UsagePeriod(t time.Time) string {
+    return t.UTC().Format(usagePeriodLayout)
+}
+
+// CountRequest учитывает запрос владельца ownerID на площадке копии (0 - без владельца, только площадке).
+// Handler считает так каждый HTTP-запрос; приложение, знающее пользователя запроса, вызывает его само.
+// Счетчик хранится в памяти до следующего RecordUsage
+func (db *Database) CountRequest(ownerID int) {
+    db.usage.add(usageKey{tenant: db.tenant, owner: ownerID}, 1)
+}
+
+// RecordUsage пересчитывает объем хранения всех площадок и их владельцев за текущий период
+// и добавляет к нему накопленные запросы. Хранение - снимок на момент вызова, запросы - сумма за период.
+// Вызывается по расписанию (см. RecordUsageEvery) или командой usage -record
+func (db *Database) RecordUsage() error {
+    period := UsagePeriod(db.now())
+    requests := db.usage.take()
+    err := db.InTx(func(tx *Database) error {
+        now := tx.now().UTC()
+        storage, err := tx.storageUsage()
+        if err != nil {
+            return err
+        }
+        if _, err := tx.execNamed("tenant_usage.reset_storage", now, period); err != nil {
+            return err
+        }
+        for key, metrics := range storage {
+            for metric, value := range metrics {
+                if err := tx.writeUsage("tenant_usage.set", key, metric, period, value, now); err != nil {
+                    return err
+                }
+            }
+        }
+        for key, n := range requests {
+            if err := tx.writeUsage("tenant_usage.add", key, UsageRequests, period, n, now); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        db.usage.restore(requests)
+    }
+    return db.opError("record", "usage", period, err)
+}
+
+// storageUsage считает объем хранения по площадкам и владельцам; значения владельцев
+// складываются и в итог площадки
+func (db *Database) storageUsage() (map[usageKey]map[string]int64, error) {
+    usage := map[usageKey]map[string]int64{}
+    add := func(key usageKey, metric string, value int64) {
+        if usage[key] == nil {
+            usage[key] = map[string]int64{}
+        }
+        usage[key][metric] += value
+    }
+    for _, query := range usageStorageQueries {
+        rows, err := db.queryNamed(query.name)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var key usageKey
+            var value int64
+            if err := rows.Scan(&key.tenant, &key.owner, &value); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            add(key, query.metric, value)
+            if key.owner != 0 {
+                add(usageKey{tenant: key.tenant}, query.metric, value)
+            }
+        }
+        if err := rows.Close(); err != nil {
+            return nil, err
+        }
+    }
+    return usage, nil
+}
+
+// writeUsage обновляет метрику запросом update (set или add) или создает ее строку
+func (db *Database) writeUsage(update string, key usageKey, metric, period string, value int64, now time.Time) error {
+    result, err := db.execNamed(update, value, now, key.tenant, key.owner, metric, period)
+    if err != nil {
+        return err
+    }
+    if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+        return err
+    }
+    _, err = db.execNamed("tenant_usage.create", value, now, key.tenant, key.owner, metric, period)
+    return err
+}
+
+// RecordUsageEvery вызывает RecordUsage каждые interval, пока не отменен ctx. Запускается
+// в отдельной горутине; ошибки только логируются, а незаписанные запросы остаются до следующего раза.
+// Перед закрытием базы RecordUsage стоит вызвать еще раз, чтобы не потерять последние запросы
+func (db *Database) RecordUsageEvery(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := db.RecordUsage(); err != nil {
+                log.Printf("usage: %v", err)
+            }
+        }
+    }
+}
+
+// Usage возвращает потребление площадки копии и ее владельцев за period (см. UsagePeriod)
+func (db *Database) Usage(period string) ([]UsageRecord, error) {
+    records, err := db.selectUsage("tenant_usage.select_by_tenant", db.tenant, period)
+    return records, db.opError("select", "usage", period, err)
+}
+
+// OwnerUsage возвращает потребление владельца ownerID за period по метрикам
+func (db *Database) OwnerUsage(ownerID int, period string) (map[string]int64, error) {
+    records, err := db.selectUsage("tenant_usage.select_by_owner", db.tenant, ownerID, period)
+    if err != nil {
+        return nil, db.opError("select", "usage", ownerID, err)
+    }
+    usage := make(map[string]int64, len(records))
+    for _, record := range records {
+        usage[record.Metric] = record.Value
+    }
+    return usage, nil
+}
+
+// BillingUsage возвращает потребление всех площадок за period для выставления счетов
+func (db *Database) BillingUsage(period string) ([]UsageRecord, error) {
+    records, err := db.selectUsage("tenant_usage.select_by_period", period)
+    return records, db.opError("select", "usage", period, err)
+}
+
+// CheckQuota сравнивает потребление владельца ownerID (0 - площадки целиком) в текущем периоде
+// с quota и возвращает *QuotaError для первой достигнутой квоты. Хранение берется по последнему
+// RecordUsage, к запросам добавляются еще не записанные
+func (db *Database) CheckQuota(ownerID int, quota UsageQuota) error {
+    usage, err := db.OwnerUsage(ownerID, UsagePeriod(db.now()))
+    if err != nil {
+        return err
+    }
+    usage[UsageRequests] += db.usage.pending(usageKey{tenant: db.tenant, owner: ownerID})
+    for _, metric := range []string{UsageUsers, UsageRestaurants, UsageMenuItems, UsageReviews, UsageBlobBytes, UsageRequests} {
+        if limit, ok := quota[metric]; ok && usage[metric] >= limit {
+            return &QuotaError{Tenant: db.tenant, Owner: ownerID, Metric: metric, Limit: limit, Used: usage[metric]}
+        }
+    }
+    return nil
+}
+
+// selectUsage читает строки tenant_usage
+func (db *Database) selectUsage(name string, args ...interface{}) ([]UsageRecord, error) {
+    rows, err := db.queryNamed(name, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var records []UsageRecord
+    for rows.Next() {
+        var record UsageRecord
+        if err := rows.Scan(&record.Tenant, &record.Owner, &record.Metric, &record.Period, &record.Value, &record.UpdatedAt); err != nil {
+            return nil, err
+        }
+        records = append(records, record)
+    }
+    return records, rows.Err()
+}
+
+// runUsage печатает потребление площадки за период, с -record сначала пересчитывает его
+func runUsage(db *Database, args []string) error {
+    flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+    record := flags.Bool("record", false, "recalculate storage and flush request counts before printing")
+    period := flags.String("period", UsagePeriod(db.now()), "billing period (month) to print, e.g. 2026-10")
+    all := flags.Bool("all", false, "print every tenant instead of -tenant")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    if *record {
+        if err := db.RecordUsage(); err != nil {
+            return err
+        }
+    }
+    var records []UsageRecord
+    var err error
+    if *all {
+        records, err = db.BillingUsage(*period)
+    } else {
+        records, err = db.Usage(*period)
+    }
+    if err != nil {
+        return err
+    }
+    for _, r := range records {
+        owner := "total"
+        if r.Owner != 0 {
+            owner = fmt.Sprintf("user %d", r.Owner)
+        }
+        fmt.Printf("%s tenant %d %-9s %-12s %d\n", r.Period, r.Tenant, owner, r.Metric, r.Value)
+    }
+    return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import "strings"
+
+// userSearchMaxLimit ограничивает выдачу SearchUsers: поле поиска показывает несколько подсказок,
+// а не выгружает таблицу
+const userSearchMaxLimit = 100
+
+// SearchUsers ищет пользователей площадки, у которых имя, фамилия или email содержат query без учета
+// регистра, для поиска в админке. Сначала идут те, у кого поле начинается с query, затем остальные,
+// внутри групп - по ID; limit <= 0 или больше userSearchMaxLimit - userSearchMaxLimit.
+// % и _ в query ищутся буквально. Зашифрованный email (см. PIIEncryption) совпадает только целиком.
+// В SQLite LOWER меняет регистр только латиницы, поэтому кириллица там ищется с учетом регистра
+func (db *Database) SearchUsers(query string, limit int) ([]User, error) {
+    query = strings.TrimSpace(query)
+    if query == "" {
+        return nil, &ValidationError{Field: "query", Message: "must not be empty"}
+    }
+    if limit <= 0 || limit > userSearchMaxLimit {
+        limit = userSearchMaxLimit
+    }
+
+    escaped := escapeLike(strings.ToLower(query))
+    users, err := db.searchUsers(query, escaped+"%", limit)
+    if err != nil || len(users) >= limit {
+        return users, db.opError("search", "users", nil, err)
+    }
+
+    // в выдачу по вхождению попадут и уже найденные по началу поля, поэтому строк берется с запасом
+    found := make(map[int]bool, len(users))
+    for _, user := range users {
+        found[user.ID] = true
+    }
+    more, err := db.searchUsers(query, "%"+escaped+"%", limit+len(users))
+    if err != nil {
+        return nil, db.opError("search", "users", nil, err)
+    }
+    for _, user := range more {
+        if len(users) == limit {
+            break
+        }
+        if !found[user.ID] {
+            users = append(users, user)
+        }
+    }
+    return users, nil
+}
+
+// searchUsers выбирает не больше limit пользователей, у которых имя, фамилия или email подходят под
+// шаблон LIKE pattern в нижнем регистре; зашифрованный email сравнивается с query целиком
+func (db *Database) searchUsers(query, pattern string, limit int) ([]User, error) {
+    builder, err := db.selectNamed("users.select_filtered")
+    if err != nil {
+        return nil, err
+    }
+    conditions := []string{`LOWER(name) LIKE ? ESCAPE '\'`, `LOWER(lastname) LIKE ? ESCAPE '\'`}
+    args := []interface{}{pattern, pattern}
+    if db.pii.encrypts("email") {
+        candidates, err := db.pii.candidates("email", query)
+        if err != nil {
+            return nil, err
+        }
+        conditions = append(conditions, "email IN (?"+strings.Repeat(", ?", len(candidates)-1)+")")
+        for _, candidate := range candidates {
+            args = append(args, candidate)
+        }
+    } else {
+        conditions = append(conditions, `LOWER(email) LIKE ? ESCAPE '\'`)
+        args = append(args, pattern)
+    }
+
+    builder.Where("tenant_id = ?", db.tenant)
+    builder.Where("("+strings.Join(conditions, " OR ")+")", args...)
+    builder.OrderBy("id", false)
+    builder.Limit(limit, 0)
+
+    rows, err := db.queryBuilt("users.select_filtered", builder)
+    if err != nil {
+        return nil, err
+    }
+    return collectRows(db, rows, db.scanUser)
+}
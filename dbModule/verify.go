@@ -0,0 +1,116 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "strings"
+)
+
+// QueryCalls - сколько раз именованный запрос попал в выборку query_stats
+type QueryCalls struct {
+    Name           string
+    Samples        int
+    EstimatedCalls float64
+}
+
+// QueryCallsSince возвращает число выполнений запросов за последние days дней по данным query_stats
+func (db *Database) QueryCallsSince(days int) (map[string]QueryCalls, error) {
+    rows, err := db.queryNamed("query_stats.calls_by_name", days)
+    if err != nil {
+        return nil, db.opError("count", "query calls", nil, err)
+    }
+    defer rows.Close()
+
+    calls := make(map[string]QueryCalls)
+    for rows.Next() {
+        var c QueryCalls
+        if err := rows.Scan(&c.Name, &c.Samples, &c.EstimatedCalls); err != nil {
+            return nil, db.opError("count", "query calls", nil, err)
+        }
+        calls[c.Name] = c
+    }
+    return calls, db.opError("count", "query calls", nil, rows.Err())
+}
+
+// runVerify проверяет конфигурацию запросов: выводит устаревшие запросы и то, выполнялись ли они
+// за последние дни по выборке query_stats. С -strict использование устаревшего запроса - ошибка.
+// Затем сверяет представления и триггеры базы с конфигурацией, а в SQLite - таблицы и колонки
+// с миграциями (см. CheckSchema); расхождение - всегда ошибка. Колонки фильтров без индекса
+// (см. UnindexedColumns) только выводятся
+func runVerify(db *Database, args []string) error {
+    flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+    days := flags.Int("days", 30, "number of days of query_stats to check for usage")
+    strict := flags.Bool("strict", false, "fail if a deprecated query is still in use")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    calls, err := db.QueryCallsSince(*days)
+    if err != nil {
+        return err
+    }
+
+    inUse := 0
+    deprecated := db.queries.DeprecatedNames()
+    for _, name := range deprecated {
+        reason, _ := db.queries.Deprecated(name)
+        // статистика пишется под общим именем запроса, даже если выполнялся вариант диалекта
+        base := strings.SplitN(name, "@", 2)[0]
+        status := "not used"
+        if c, ok := calls[base]; ok {
+            inUse++
+            status = fmt.Sprintf("in use: %d samples, ~%.0f calls", c.Samples, c.EstimatedCalls)
+        }
+        fmt.Printf("deprecated %-32s | %s | %s\n", name, status, reason)
+    }
+
+    fmt.Printf("%d deprecated queries, %d still in use in the last %d days\n", len(deprecated), inUse, *days)
+
+    objects, err := db.VerifySchemaObjects()
+    if err != nil {
+        return err
+    }
+    mismatched := 0
+    for _, object := range objects {
+        if !object.OK() {
+            mismatched++
+        }
+        fmt.Printf("%-7s %-32s | %s\n", object.Kind, object.Name, object.Status)
+    }
+    fmt.Printf("%d views and triggers, %d do not match the configuration\n", len(objects), mismatched)
+
+    var drift []SchemaDrift
+    if db.driver.dialect.Name() == "sqlite" {
+        expected, err := db.MigrationSchema()
+        if err != nil {
+            return err
+        }
+        if drift, err = db.VerifySchema(expected); err != nil {
+            return err
+        }
+        for _, d := range drift {
+            fmt.Printf("drift   %s\n", d)
+        }
+        fmt.Printf("%d tables checked against the migrations, %d differences\n", len(expected), len(drift))
+    }
+
+    unindexed, err := db.UnindexedColumns()
+    if err != nil {
+        return err
+    }
+    for _, column := range unindexed {
+        fmt.Printf("no index %s\n", column)
+    }
+    fmt.Printf("%d filter columns without an index\n", len(unindexed))
+
+    if *strict && inUse > 0 {
+        return fmt.Errorf("%d deprecated queries are still in use", inUse)
+    }
+    if mismatched > 0 {
+        return fmt.Errorf("%d views and triggers do not match the configuration, run migrations to recreate them", mismatched)
+    }
+    if len(drift) > 0 {
+        return fmt.Errorf("schema differs from the migrations in %d places", len(drift))
+    }
+    return nil
+}
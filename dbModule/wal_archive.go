@@ -0,0 +1,796 @@
+package main
+
+import (
+    "bufio"
+    "compress/gzip"
+    "context"
+    "database/sql"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// WALArchiveInterval - как часто ArchiveWAL отправляет новые кадры WAL в архив по умолчанию
+var WALArchiveInterval = 10 * time.Second
+
+// WALArchiveCheckpointFrames - после скольких кадров в WAL архиватор переносит их в файл базы,
+// чтобы SQLite начала WAL заново. Как wal_autocheckpoint, но под контролем архиватора
+var WALArchiveCheckpointFrames = 1000
+
+// walManifestKey - ключ манифеста архива в хранилище
+const walManifestKey = "manifest.json"
+
+// walGenerationLayout - имя поколения по моменту его начала: по имени поколения сортируются по порядку
+const walGenerationLayout = "20060102T150405.000Z"
+
+// Размеры заголовков файла WAL и его кадров (см. https://www.sqlite.org/fileformat.html#the_write_ahead_log)
+const (
+    walHeaderSize      = 32
+    walFrameHeaderSize = 24
+)
+
+// WALArchiveOptions - настройки непрерывного архивирования WAL
+type WALArchiveOptions struct {
+    // Interval - как часто отправлять новые кадры; 0 - WALArchiveInterval
+    Interval time.Duration
+    // KeepGenerations - сколько последних поколений хранить, старые удаляются из архива; 0 - все
+    KeepGenerations int
+}
+
+// WALArchiveManifest - содержимое архива WAL, manifest.json в его корне. Пишется после каждого
+// отправленного файла, поэтому все перечисленные в нем файлы уже в архиве
+type WALArchiveManifest struct {
+    Generations []WALGeneration `json:"generations"`
+}
+
+// WALGeneration - полная копия файла базы и кадры WAL, записанные после нее. Новое поколение начинается,
+// когда архиватор не может доказать, что видел все кадры: при первом запуске, после перерыва
+// в архивировании или если WAL начался заново без него
+type WALGeneration struct {
+    ID string `json:"id"`
+    // Base - ключ копии файла базы, сжатой gzip
+    Base      string       `json:"base"`
+    CreatedAt time.Time    `json:"created_at"`
+    Segments  []WALSegment `json:"segments"`
+}
+
+// WALSegment - кусок файла WAL от Offset длиной Size, сжатый gzip. Кусок заканчивается кадром фиксации,
+// поэтому база, восстановленная по куску, согласована и соответствует моменту CreatedAt.
+// Epoch - номер WAL внутри поколения: WAL начинается заново после каждой контрольной точки архиватора
+type WALSegment struct {
+    Key       string    `json:"key"`
+    Epoch     int       `json:"epoch"`
+    Salt1     uint32    `json:"salt1"`
+    Salt2     uint32    `json:"salt2"`
+    Offset    int64     `json:"offset"`
+    Size      int64     `json:"size"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// WALRestorePoint - какое состояние восстановлено из архива
+type WALRestorePoint struct {
+    Generation string
+    // Segments - сколько кусков WAL применено к копии файла
+    Segments int
+    // Time - момент, которому соответствует восстановленная база
+    Time time.Time
+}
+
+// walHeader - заголовок файла WAL
+type walHeader struct {
+    // bigEndian - порядок слов контрольных сумм, задается магическим числом
+    bigEndian bool
+    pageSize  int
+    salt1     uint32
+    salt2     uint32
+    checksum  [2]uint32
+}
+
+// walArchiver хранит состояние ArchiveWAL между шагами
+type walArchiver struct {
+    db       *Database
+    storage  AttachmentStorage
+    options  WALArchiveOptions
+    path     string
+    file     *os.File
+    manifest WALArchiveManifest
+    // reader держит открытой читающую транзакцию между шагами: пока она открыта, SQLite не может
+    // начать WAL заново, не дав архиватору отправить его кадры. nil - снимка нет (первый шаг или ошибка)
+    reader *sql.Conn
+    // sealed - WAL мог начаться заново только после отправки всех его кадров (снимок держался с прошлого шага)
+    sealed bool
+}
+
+// ArchiveWAL непрерывно архивирует базу SQLite в storage, пока не отменен ctx: раз в options.Interval
+// отправляет новые кадры WAL, а в начале и после перерыва - полную копию файла (новое поколение).
+// По архиву база восстанавливается на любой шаг архивирования (см. RestoreWALArchive), так что после
+// повреждения файла теряются секунды, а не все изменения после последнего Backup.
+// Кадры читаются, пока соединение архиватора держит блокировку записи, поэтому запись в базу
+// на это время ждет. База должна быть в режиме WAL; архиватор в процессе должен быть один
+func (db *Database) ArchiveWAL(ctx context.Context, storage AttachmentStorage, options WALArchiveOptions) error {
+    if err := db.requireSQLite("wal archive"); err != nil {
+        return db.opError("archive", "wal", nil, err)
+    }
+    if options.Interval <= 0 {
+        options.Interval = WALArchiveInterval
+    }
+    primary := db.WithPrimary()
+    archiver := &walArchiver{db: primary, storage: storage, options: options}
+    defer archiver.releaseSnapshot()
+
+    var err error
+    if archiver.path, err = primary.walDatabaseFile(ctx); err != nil {
+        return db.opError("archive", "wal", nil, err)
+    }
+    if archiver.file, err = openDatabaseFile(archiver.path); err != nil {
+        return db.opError("archive", "wal", nil, err)
+    }
+    if archiver.manifest, err = ReadWALArchiveManifest(ctx, storage); err != nil {
+        return db.opError("archive", "wal", nil, err)
+    }
+
+    ticker := time.NewTicker(options.Interval)
+    defer ticker.Stop()
+    for {
+        if err := archiver.step(ctx); err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            // без снимка следующий шаг не поверит, что видел все кадры, и при сбросе WAL начнет поколение
+            archiver.releaseSnapshot()
+            log.Printf("wal archive: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-ticker.C:
+        }
+    }
+}
+
+// walDatabaseFile возвращает путь к файлу основной базы и проверяет, что она в режиме WAL
+func (db *Database) walDatabaseFile(ctx context.Context) (string, error) {
+    query, err := db.lookupQuery("backup.database_file")
+    if err != nil {
+        return "", err
+    }
+    var path string
+    if err := db.QueryRowContext(ctx, query).Scan(&path); err != nil {
+        return "", err
+    }
+    if path == "" {
+        return "", errors.New("an in-memory database cannot be archived")
+    }
+    query, err = db.lookupQuery("pragmas.journal_mode")
+    if err != nil {
+        return "", err
+    }
+    var mode string
+    if err := db.QueryRowContext(ctx, query).Scan(&mode); err != nil {
+        return "", err
+    }
+    if !strings.EqualFold(mode, "wal") {
+        return "", fmt.Errorf("WAL archiving needs journal_mode=wal, the database is in %s mode", mode)
+    }
+    return path, nil
+}
+
+// walDatabaseFiles - файлы баз, открытые ArchiveWAL для копирования. Они не закрываются до конца процесса:
+// закрытие любого дескриптора файла снимает блокировки POSIX всех соединений SQLite процесса с этим файлом,
+// и другой процесс смог бы, например, удалить WAL, пока соединения считают, что читают его
+var walDatabaseFiles = struct {
+    sync.Mutex
+    files map[string]*os.File
+}{files: map[string]*os.File{}}
+
+// openDatabaseFile открывает файл базы для чтения один раз за процесс (см. walDatabaseFiles)
+func openDatabaseFile(path string) (*os.File, error) {
+    walDatabaseFiles.Lock()
+    defer walDatabaseFiles.Unlock()
+    if file, ok := walDatabaseFiles.files[path]; ok {
+        return file, nil
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    walDatabaseFiles.files[path] = file
+    return file, nil
+}
+
+// walStaged - файл, скопированный под блокировкой записи и ожидающий отправки в архив
+type walStaged struct {
+    path       string
+    key        string
+    generation *WALGeneration
+    segment    *WALSegment
+}
+
+// step выполняет шаг архивирования: под блокировкой записи копирует новые кадры WAL (и файл базы,
+// если нужно новое поколение) во временные файлы, обновляет снимок чтения, снимает блокировку
+// и только потом отправляет файлы в хранилище, чтобы запись не ждала сети
+func (a *walArchiver) step(ctx context.Context) error {
+    db := a.db
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if err := db.execOn(ctx, conn, "backup.begin_immediate"); err != nil {
+        return err
+    }
+    locked := true
+    unlock := func() error {
+        if !locked {
+            return nil
+        }
+        locked = false
+        return db.execOn(context.WithoutCancel(ctx), conn, "backup.rollback")
+    }
+    defer unlock()
+
+    tmp, err := os.MkdirTemp("", "wal-archive-*")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(tmp)
+
+    header, end, frames, err := readWAL(a.path + "-wal")
+    if err != nil {
+        return err
+    }
+    now := db.now().UTC()
+    generation, segment := a.next(header, end)
+    var staged []walStaged
+    if generation != nil {
+        generation.ID = now.Format(walGenerationLayout)
+        generation.Base = generation.ID + "/base.db.gz"
+        generation.CreatedAt = now
+        base := filepath.Join(tmp, "base.db")
+        info, err := a.file.Stat()
+        if err != nil {
+            return err
+        }
+        if err := copyFileRange(a.file, base, 0, info.Size()); err != nil {
+            return fmt.Errorf("copy database file: %w", err)
+        }
+        staged = append(staged, walStaged{path: base, key: generation.Base, generation: generation})
+    }
+    if segment != nil {
+        segment.Salt1, segment.Salt2 = header.salt1, header.salt2
+        segment.Size = end - segment.Offset
+        segment.CreatedAt = now
+        segment.Key = fmt.Sprintf("%s/%04d-%012d.wal.gz", a.currentID(generation), segment.Epoch, segment.Offset)
+        path := filepath.Join(tmp, "segment.wal")
+        wal, err := os.Open(a.path + "-wal")
+        if err != nil {
+            return err
+        }
+        err = copyFileRange(wal, path, segment.Offset, segment.Size)
+        wal.Close()
+        if err != nil {
+            return fmt.Errorf("copy WAL: %w", err)
+        }
+        staged = append(staged, walStaged{path: path, key: segment.Key, segment: segment})
+    }
+
+    // пока держится блокировка, WAL не меняется: старый снимок можно отпустить, перенести кадры в базу
+    // и взять новый. Если все кадры перенесены, SQLite начнет WAL заново при следующей записи
+    if err := a.endSnapshot(ctx); err != nil {
+        return err
+    }
+    if frames >= WALArchiveCheckpointFrames {
+        if err := db.execOn(ctx, a.reader, "backup.checkpoint"); err != nil {
+            return err
+        }
+    }
+    if err := a.takeSnapshot(ctx); err != nil {
+        return err
+    }
+    if err := unlock(); err != nil {
+        return err
+    }
+
+    // поколение и кусок попадают в манифест, только когда их файл уже в архиве
+    for _, file := range staged {
+        if err := a.put(ctx, file.path, file.key); err != nil {
+            return err
+        }
+        if file.generation != nil {
+            a.manifest.Generations = append(a.manifest.Generations, *file.generation)
+        }
+        if file.segment != nil {
+            current := &a.manifest.Generations[len(a.manifest.Generations)-1]
+            current.Segments = append(current.Segments, *file.segment)
+        }
+        if err := a.writeManifest(ctx); err != nil {
+            return err
+        }
+    }
+    if generation != nil {
+        return a.prune(ctx)
+    }
+    return nil
+}
+
+// next решает по заголовку WAL, что отправить: новое поколение, кусок WAL или ничего
+func (a *walArchiver) next(header *walHeader, end int64) (*WALGeneration, *WALSegment) {
+    sealed := a.sealed
+    var last *WALSegment
+    if n := len(a.manifest.Generations); n > 0 {
+        if segments := a.manifest.Generations[n-1].Segments; len(segments) > 0 {
+            last = &segments[len(segments)-1]
+        }
+    }
+    continues := len(a.manifest.Generations) > 0
+    switch {
+    case !continues:
+    case header == nil:
+        // WAL пуст: кадров нет, а начнется он с новой солью - тот же WAL после контрольной точки
+        if sealed {
+            return nil, nil
+        }
+        continues = false
+    case last == nil:
+        // поколение начато без WAL; снимок держался, и это первый WAL после копии файла
+        if sealed {
+            return nil, &WALSegment{}
+        }
+        continues = false
+    case header.salt1 == last.Salt1 && header.salt2 == last.Salt2 && end >= last.Offset+last.Size:
+        // тот же WAL, возможно, с новыми кадрами; он только растет, поэтому отправленное не изменилось
+        if end == last.Offset+last.Size {
+            return nil, nil
+        }
+        return nil, &WALSegment{Epoch: last.Epoch, Offset: last.Offset + last.Size}
+    case sealed && header.salt1 == last.Salt1+1:
+        // SQLite начала WAL заново после контрольной точки, все кадры прошлого WAL уже в архиве
+        return nil, &WALSegment{Epoch: last.Epoch + 1}
+    default:
+        continues = false
+    }
+    if continues {
+        return nil, nil
+    }
+    if header == nil {
+        return &WALGeneration{}, nil
+    }
+    return &WALGeneration{}, &WALSegment{}
+}
+
+// currentID возвращает поколение, к которому относится новый кусок
+func (a *walArchiver) currentID(generation *WALGeneration) string {
+    if generation != nil {
+        return generation.ID
+    }
+    return a.manifest.Generations[len(a.manifest.Generations)-1].ID
+}
+
+// endSnapshot завершает читающую транзакцию снимка; соединение снимка остается у архиватора
+// (создается при необходимости), и контрольную точку можно выполнить на нем
+func (a *walArchiver) endSnapshot(ctx context.Context) error {
+    if a.reader == nil {
+        reader, err := a.db.Conn(ctx)
+        if err != nil {
+            return err
+        }
+        a.reader = reader
+        return nil
+    }
+    a.sealed = false
+    return a.db.execOn(ctx, a.reader, "backup.rollback")
+}
+
+// takeSnapshot начинает читающую транзакцию на соединении снимка
+func (a *walArchiver) takeSnapshot(ctx context.Context) error {
+    db := a.db
+    query, err := db.lookupQuery("backup.snapshot")
+    if err != nil {
+        return err
+    }
+    if err := db.execOn(ctx, a.reader, "backup.begin"); err != nil {
+        return err
+    }
+    var count int
+    if err := a.reader.QueryRowContext(ctx, query).Scan(&count); err != nil {
+        return err
+    }
+    a.sealed = true
+    return nil
+}
+
+// releaseSnapshot завершает снимок и возвращает его соединение в пул
+func (a *walArchiver) releaseSnapshot() {
+    a.sealed = false
+    if a.reader == nil {
+        return
+    }
+    a.db.execOn(context.Background(), a.reader, "backup.rollback")
+    a.reader.Close()
+    a.reader = nil
+}
+
+// put сжимает файл gzip и отправляет его в хранилище под ключом key
+func (a *walArchiver) put(ctx context.Context, path, key string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    reader, writer := io.Pipe()
+    go func() {
+        compressed := gzip.NewWriter(writer)
+        _, err := io.Copy(compressed, bufio.NewReader(file))
+        if err == nil {
+            err = compressed.Close()
+        }
+        writer.CloseWithError(err)
+    }()
+    _, err = a.storage.Put(ctx, key, "application/gzip", reader)
+    reader.CloseWithError(err)
+    if err != nil {
+        return fmt.Errorf("upload %s: %w", key, err)
+    }
+    return nil
+}
+
+// writeManifest сохраняет манифест архива
+func (a *walArchiver) writeManifest(ctx context.Context) error {
+    data, err := json.MarshalIndent(a.manifest, "", "  ")
+    if err != nil {
+        return err
+    }
+    if _, err := a.storage.Put(ctx, walManifestKey, "application/json", strings.NewReader(string(data))); err != nil {
+        return fmt.Errorf("upload %s: %w", walManifestKey, err)
+    }
+    return nil
+}
+
+// prune удаляет из архива поколения старше options.KeepGenerations последних
+func (a *walArchiver) prune(ctx context.Context) error {
+    keep := a.options.KeepGenerations
+    if keep <= 0 || len(a.manifest.Generations) <= keep {
+        return nil
+    }
+    old := a.manifest.Generations[:len(a.manifest.Generations)-keep]
+    a.manifest.Generations = append([]WALGeneration(nil), a.manifest.Generations[len(old):]...)
+    // манифест пишется первым: файлы, которых в нем нет, уже не нужны для восстановления
+    if err := a.writeManifest(ctx); err != nil {
+        return err
+    }
+    for _, generation := range old {
+        for _, segment := range generation.Segments {
+            if err := a.storage.Delete(ctx, segment.Key); err != nil {
+                return err
+            }
+        }
+        if err := a.storage.Delete(ctx, generation.Base); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ReadWALArchiveManifest читает манифест архива WAL; пустой архив - пустой манифест
+func ReadWALArchiveManifest(ctx context.Context, storage AttachmentStorage) (WALArchiveManifest, error) {
+    var manifest WALArchiveManifest
+    file, err := storage.Open(ctx, walManifestKey)
+    if errors.Is(err, ErrNotFound) {
+        return manifest, nil
+    }
+    if err != nil {
+        return manifest, err
+    }
+    defer file.Close()
+    if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+        return manifest, fmt.Errorf("%s: %w", walManifestKey, err)
+    }
+    return manifest, nil
+}
+
+// readWAL проверяет файл WAL и возвращает его заголовок, конец последнего кадра фиксации и число
+// кадров до него. Кадры проверяются по соли и контрольным суммам, как при восстановлении WAL в SQLite:
+// после первого неверного кадра идут остатки прошлого WAL или незафиксированная транзакция.
+// Пустой или отсутствующий WAL - nil без ошибки
+func readWAL(path string) (*walHeader, int64, int, error) {
+    file, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, 0, 0, nil
+    }
+    if err != nil {
+        return nil, 0, 0, err
+    }
+    defer file.Close()
+    reader := bufio.NewReaderSize(file, 1<<16)
+
+    buf := make([]byte, walHeaderSize)
+    if _, err := io.ReadFull(reader, buf); err != nil {
+        if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+            return nil, 0, 0, nil
+        }
+        return nil, 0, 0, err
+    }
+    magic := binary.BigEndian.Uint32(buf[0:])
+    if magic&^1 != 0x377f0682 {
+        return nil, 0, 0, fmt.Errorf("%s is not a WAL file", path)
+    }
+    header := &walHeader{
+        bigEndian: magic&1 == 1,
+        pageSize:  int(binary.BigEndian.Uint32(buf[8:])),
+        salt1:     binary.BigEndian.Uint32(buf[16:]),
+        salt2:     binary.BigEndian.Uint32(buf[20:]),
+    }
+    if header.pageSize == 1 {
+        header.pageSize = 65536
+    }
+    header.checksum = walChecksum(header.bigEndian, buf[:24], [2]uint32{})
+    if header.checksum != [2]uint32{binary.BigEndian.Uint32(buf[24:]), binary.BigEndian.Uint32(buf[28:])} {
+        return nil, 0, 0, nil
+    }
+
+    end, committed, frames := int64(walHeaderSize), 0, 0
+    checksum := header.checksum
+    frame := make([]byte, walFrameHeaderSize+header.pageSize)
+    for {
+        if _, err := io.ReadFull(reader, frame); err != nil {
+            if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+                break
+            }
+            return nil, 0, 0, err
+        }
+        if binary.BigEndian.Uint32(frame[8:]) != header.salt1 || binary.BigEndian.Uint32(frame[12:]) != header.salt2 {
+            break
+        }
+        checksum = walChecksum(header.bigEndian, frame[:8], checksum)
+        checksum = walChecksum(header.bigEndian, frame[walFrameHeaderSize:], checksum)
+        if checksum != [2]uint32{binary.BigEndian.Uint32(frame[16:]), binary.BigEndian.Uint32(frame[20:])} {
+            break
+        }
+        frames++
+        // ненулевой размер базы отмечает кадр фиксации транзакции
+        if binary.BigEndian.Uint32(frame[4:]) != 0 {
+            committed = frames
+            end = walHeaderSize + int64(frames)*int64(len(frame))
+        }
+    }
+    return header, end, committed, nil
+}
+
+// walChecksum продолжает контрольную сумму WAL s по данным data (длина кратна 8)
+func walChecksum(bigEndian bool, data []byte, s [2]uint32) [2]uint32 {
+    var order binary.ByteOrder = binary.LittleEndian
+    if bigEndian {
+        order = binary.BigEndian
+    }
+    for i := 0; i+8 <= len(data); i += 8 {
+        s[0] += order.Uint32(data[i:]) + s[1]
+        s[1] += order.Uint32(data[i+4:]) + s[0]
+    }
+    return s
+}
+
+// copyFileRange копирует из src в новый файл dst size байт начиная с offset
+func copyFileRange(src io.ReaderAt, dst string, offset, size int64) error {
+    out, err := os.Create(dst)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, io.NewSectionReader(src, offset, size)); err != nil {
+        out.Close()
+        return err
+    }
+    return out.Close()
+}
+
+// RestoreWALArchive собирает из архива ArchiveWAL файл базы path в состоянии на момент at (нулевой -
+// последнее): берет последнее поколение, начатое не позже at, и применяет к копии файла куски WAL,
+// отправленные не позже at. Точность - шаг архивирования. Файл path не должен существовать;
+// заменить им содержимое работающей базы можно через RestoreFrom
+func (db *Database) RestoreWALArchive(ctx context.Context, storage AttachmentStorage, at time.Time, path string) (WALRestorePoint, error) {
+    point, err := db.restoreWALArchive(ctx, storage, at, path)
+    if err != nil {
+        os.Remove(path)
+        os.Remove(path + "-wal")
+        os.Remove(path + "-shm")
+        return WALRestorePoint{}, db.opError("restore", "wal", path, err)
+    }
+    return point, nil
+}
+
+// restoreWALArchive выполняет RestoreWALArchive
+func (db *Database) restoreWALArchive(ctx context.Context, storage AttachmentStorage, at time.Time, path string) (WALRestorePoint, error) {
+    if err := db.requireSQLite("wal restore"); err != nil {
+        return WALRestorePoint{}, err
+    }
+    if _, err := os.Stat(path); err == nil {
+        return WALRestorePoint{}, fmt.Errorf("%s already exists", path)
+    }
+    manifest, err := ReadWALArchiveManifest(ctx, storage)
+    if err != nil {
+        return WALRestorePoint{}, err
+    }
+    var generation *WALGeneration
+    for i := range manifest.Generations {
+        if at.IsZero() || !manifest.Generations[i].CreatedAt.After(at) {
+            generation = &manifest.Generations[i]
+        }
+    }
+    if generation == nil {
+        return WALRestorePoint{}, fmt.Errorf("the archive has no backup made at or before %s", at.Format(time.RFC3339))
+    }
+
+    if err := fetchGzip(ctx, storage, generation.Base, path, false); err != nil {
+        return WALRestorePoint{}, err
+    }
+    point := WALRestorePoint{Generation: generation.ID, Time: generation.CreatedAt}
+    segments := generation.Segments
+    for len(segments) > 0 {
+        epoch := segments[0].Epoch
+        applied := 0
+        for _, segment := range segments {
+            if segment.Epoch != epoch || !at.IsZero() && segment.CreatedAt.After(at) {
+                break
+            }
+            if err := fetchGzip(ctx, storage, segment.Key, path+"-wal", segment.Offset > 0); err != nil {
+                return WALRestorePoint{}, err
+            }
+            applied++
+            point.Segments++
+            point.Time = segment.CreatedAt
+        }
+        if applied > 0 {
+            if err := db.applyWAL(path); err != nil {
+                return WALRestorePoint{}, fmt.Errorf("apply WAL epoch %d: %w", epoch, err)
+            }
+        }
+        if applied < len(segments) && segments[applied].Epoch == epoch {
+            break
+        }
+        segments = segments[applied:]
+    }
+    return point, nil
+}
+
+// applyWAL переносит кадры файла path-wal в файл path: SQLite восстанавливает WAL, когда подключает
+// файл, а контрольная точка записывает кадры в базу и очищает WAL
+func (db *Database) applyWAL(path string) error {
+    ctx, cancel := db.operationContext(operationMigration)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if err := db.execOn(ctx, conn, "backup.attach", path); err != nil {
+        return err
+    }
+    err = db.execOn(ctx, conn, "backup.checkpoint_backup")
+    if detachErr := db.execOn(ctx, conn, "backup.detach"); err == nil {
+        err = detachErr
+    }
+    if err != nil {
+        return err
+    }
+    os.Remove(path + "-wal")
+    os.Remove(path + "-shm")
+    return nil
+}
+
+// fetchGzip скачивает сжатый gzip файл архива в path, дописывая в конец, если appendTo
+func fetchGzip(ctx context.Context, storage AttachmentStorage, key, path string, appendTo bool) error {
+    source, err := storage.Open(ctx, key)
+    if err != nil {
+        return err
+    }
+    defer source.Close()
+    reader, err := gzip.NewReader(source)
+    if err != nil {
+        return fmt.Errorf("%s: %w", key, err)
+    }
+    mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+    if appendTo {
+        mode = os.O_WRONLY | os.O_APPEND
+    }
+    out, err := os.OpenFile(path, mode, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, reader); err != nil {
+        out.Close()
+        return fmt.Errorf("%s: %w", key, err)
+    }
+    return out.Close()
+}
+
+// runWALArchive выполняет команду wal-archive: run архивирует до прерывания, list печатает точки
+// восстановления, restore восстанавливает базу на момент -at
+func runWALArchive(db *Database, args []string) error {
+    usage := fmt.Errorf("usage: wal-archive run -archive DEST [-interval D] [-keep N] | list -archive DEST | restore -archive DEST [-at TIME] [-out FILE]")
+    if len(args) == 0 {
+        return usage
+    }
+    flags := flag.NewFlagSet("wal-archive", flag.ContinueOnError)
+    location := flags.String("archive", "", "archive directory, or s3://bucket/prefix (see -s3-endpoint)")
+    interval := flags.Duration("interval", WALArchiveInterval, "how often to ship new WAL frames (run)")
+    keep := flags.Int("keep", 2, "how many generations to keep in the archive, 0 keeps all (run)")
+    at := flags.String("at", "", "restore the state at this RFC 3339 time instead of the latest one (restore)")
+    output := flags.String("out", "", "write the restored database to this file instead of replacing the current contents (restore)")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+    if *location == "" {
+        return fmt.Errorf("wal-archive needs -archive with a directory or s3://bucket/prefix")
+    }
+    storage, err := newStorage(*location)
+    if err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "run":
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+        fmt.Printf("Archiving WAL to %s every %s, press Ctrl+C to stop\n", *location, *interval)
+        return db.ArchiveWAL(ctx, storage, WALArchiveOptions{Interval: *interval, KeepGenerations: *keep})
+    case "list":
+        manifest, err := ReadWALArchiveManifest(context.Background(), storage)
+        if err != nil {
+            return err
+        }
+        if len(manifest.Generations) == 0 {
+            fmt.Printf("%s has no backups\n", *location)
+        }
+        for _, generation := range manifest.Generations {
+            latest := generation.CreatedAt
+            var size int64
+            for _, segment := range generation.Segments {
+                latest = segment.CreatedAt
+                size += segment.Size
+            }
+            fmt.Printf("%s  %s .. %s  %d WAL segments, %d bytes\n", generation.ID,
+                generation.CreatedAt.Format(time.RFC3339), latest.Format(time.RFC3339), len(generation.Segments), size)
+        }
+        return nil
+    case "restore":
+        var point time.Time
+        if *at != "" {
+            if point, err = time.Parse(time.RFC3339, *at); err != nil {
+                return fmt.Errorf("-at: %w", err)
+            }
+        }
+        path := *output
+        if path == "" {
+            dir, err := os.MkdirTemp("", "wal-restore-*")
+            if err != nil {
+                return err
+            }
+            defer os.RemoveAll(dir)
+            path = filepath.Join(dir, "restored.db")
+        }
+        restored, err := db.RestoreWALArchive(context.Background(), storage, point, path)
+        if err != nil {
+            return err
+        }
+        if *output == "" {
+            if err := db.RestoreFrom(path); err != nil {
+                return err
+            }
+            path = "the database"
+        }
+        fmt.Printf("Restored %s to %s from generation %s and %d WAL segments\n",
+            path, restored.Time.Format(time.RFC3339), restored.Generation, restored.Segments)
+        return nil
+    }
+    return usage
+}
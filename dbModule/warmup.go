@@ -0,0 +1,224 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// warmUpNamespace - пространство имен запросов, которые читают ключевые индексы при прогреве
+const warmUpNamespace = "warmup."
+
+// WarmUpReport - итоги прогрева для лога запуска
+type WarmUpReport struct {
+    // Prepared - сколько именованных запросов подготовлено и закешировано
+    Prepared int
+    // Failed - запросы, которые не удалось подготовить (например, для отсутствующих таблиц);
+    // они выполняются как обычно, без кеша
+    Failed      []string
+    PrepareTime time.Duration
+    // Indexes - сколько запросов warmup прочитали свои индексы
+    Indexes   int
+    PrimeTime time.Duration
+}
+
+// statementCache - подготовленные при прогреве запросы по тексту после Rebind, общие для всех копий Database
+type statementCache struct {
+    mu         sync.RWMutex
+    statements map[string]*cachedStatement
+}
+
+// cachedStatement - подготовленный запрос кеша. Замена при прогреве или перезагрузке запросов
+// закрывает его, только дождавшись запросов, которые уже начали с ним работать (см. preparedConn.use):
+// иначе они получили бы "sql: statement is closed"
+type cachedStatement struct {
+    mu sync.RWMutex
+    // stmt - nil после close
+    stmt *sql.Stmt
+}
+
+// close закрывает запрос, дождавшись начатых с ним запросов
+func (s *cachedStatement) close() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.stmt != nil {
+        s.stmt.Close()
+        s.stmt = nil
+    }
+}
+
+// get возвращает подготовленный запрос или nil, если он не прогревался
+func (c *statementCache) get(query string) *cachedStatement {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.statements[query]
+}
+
+// put запоминает подготовленный запрос, закрывая прежний с тем же текстом
+func (c *statementCache) put(query string, stmt *sql.Stmt) {
+    c.mu.Lock()
+    if c.statements == nil {
+        c.statements = make(map[string]*cachedStatement)
+    }
+    old := c.statements[query]
+    c.statements[query] = &cachedStatement{stmt: stmt}
+    c.mu.Unlock()
+
+    // прежний закрывается вне c.mu: ожидание его запросов не задерживает остальные
+    if old != nil {
+        old.close()
+    }
+}
+
+// remove закрывает и забывает подготовленный запрос с текстом query, если он есть
+func (c *statementCache) remove(query string) {
+    c.mu.Lock()
+    old := c.statements[query]
+    delete(c.statements, query)
+    c.mu.Unlock()
+
+    if old != nil {
+        old.close()
+    }
+}
+
+// closeAll закрывает все подготовленные запросы и очищает кеш
+func (c *statementCache) closeAll() {
+    c.mu.Lock()
+    statements := c.statements
+    c.statements = nil
+    c.mu.Unlock()
+
+    for _, stmt := range statements {
+        stmt.close()
+    }
+}
+
+// preparedConn выполняет запросы из кеша подготовленными, а остальные - через conn как обычно
+type preparedConn struct {
+    Executor
+    tx    *sql.Tx
+    cache *statementCache
+}
+
+// use вызывает fn с закешированным запросом, привязанным к транзакции, если она есть, и не дает
+// закрыть его, пока fn не вернется. Строки, открытые в fn, остаются рабочими и после закрытия запроса.
+// false - запроса нет в кеше или он уже закрыт, и его нужно выполнить как обычно
+func (c preparedConn) use(query string, fn func(stmt *sql.Stmt)) bool {
+    cached := c.cache.get(query)
+    if cached == nil {
+        return false
+    }
+    cached.mu.RLock()
+    defer cached.mu.RUnlock()
+    if cached.stmt == nil {
+        return false
+    }
+    stmt := cached.stmt
+    if c.tx != nil {
+        stmt = c.tx.Stmt(stmt)
+    }
+    fn(stmt)
+    return true
+}
+
+// ExecContext выполняет запрос без строк результата
+func (c preparedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+    if c.use(query, func(stmt *sql.Stmt) { result, err = stmt.ExecContext(ctx, args...) }) {
+        return result, err
+    }
+    return c.Executor.ExecContext(ctx, query, args...)
+}
+
+// QueryContext выполняет запрос, возвращающий строки
+func (c preparedConn) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+    if c.use(query, func(stmt *sql.Stmt) { rows, err = stmt.QueryContext(ctx, args...) }) {
+        return rows, err
+    }
+    return c.Executor.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext выполняет запрос, возвращающий одну строку
+func (c preparedConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) (row *sql.Row) {
+    if c.use(query, func(stmt *sql.Stmt) { row = stmt.QueryRowContext(ctx, args...) }) {
+        return row
+    }
+    return c.Executor.QueryRowContext(ctx, query, args...)
+}
+
+// WarmUp сокращает задержку первых запросов после запуска: готовит все именованные запросы
+// реестра (кроме миграций) и читает ключевые индексы запросами warmup, чтобы их страницы
+// попали в кеш СУБД. Вызывается после Migrate, когда все таблицы уже созданы
+func (db *Database) WarmUp() (WarmUpReport, error) {
+    var report WarmUpReport
+
+    started := time.Now()
+    for _, name := range db.warmUpStatements() {
+        // lookupQuery не используется, чтобы прогрев не считался вызовом устаревших запросов
+        if variant := name + "@" + db.driver.dialect.Name(); db.queries.Has(variant) {
+            name = variant
+        }
+        query, err := db.queries.Get(name)
+        if err != nil {
+            return report, db.opError("warm up", "queries", name, err)
+        }
+        query = db.driver.dialect.Rebind(query)
+        stmt, err := db.DB.Prepare(query)
+        if err != nil {
+            report.Failed = append(report.Failed, name)
+            continue
+        }
+        db.statements.put(query, stmt)
+        report.Prepared++
+    }
+    report.PrepareTime = time.Since(started)
+
+    started = time.Now()
+    for _, name := range db.queries.Names() {
+        if !strings.HasPrefix(name, warmUpNamespace) || strings.Contains(name, "@") {
+            continue
+        }
+        rows, err := db.queryNamed(name)
+        if err != nil {
+            return report, db.opError("warm up", "index", name, err)
+        }
+        for rows.Next() {
+        }
+        if err := rows.Close(); err != nil {
+            return report, db.opError("warm up", "index", name, err)
+        }
+        report.Indexes++
+    }
+    report.PrimeTime = time.Since(started)
+    return report, nil
+}
+
+// warmUpStatements возвращает имена запросов для подготовки без суффиксов диалекта:
+// вариант для текущего диалекта подставит lookupQuery, а варианты других диалектов пропускаются.
+// Отчеты не готовятся: их параметры :name заменяются на плейсхолдеры только при выполнении
+func (db *Database) warmUpStatements() []string {
+    seen := make(map[string]bool)
+    var names []string
+    for _, name := range db.queries.Names() {
+        if strings.HasPrefix(name, schemaNamespace) || strings.HasPrefix(name, warmUpNamespace) ||
+            strings.HasPrefix(name, viewsNamespace) || strings.HasPrefix(name, triggersNamespace) ||
+            strings.HasPrefix(name, reportNamespace) {
+            continue
+        }
+        if i := strings.Index(name, "@"); i >= 0 {
+            if name[i+1:] != db.driver.dialect.Name() {
+                continue
+            }
+            name = name[:i]
+        }
+        if !seen[name] {
+            seen[name] = true
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// WebhookSecretEnv - переменная окружения с секретом, которым подписываются запросы вебхуков
+const WebhookSecretEnv = "DBMODULE_WEBHOOK_SECRET"
+
+// DefaultWebhookEvents - события, которые получает вебхук без своего списка
+var DefaultWebhookEvents = []string{"user.created", "restaurant.created"}
+
+// WebhookAttempts - сколько раз отправляется событие, прежде чем оно попадет в webhook_dead_letters
+var WebhookAttempts = 5
+
+// WebhookRetryDelay - пауза перед второй попыткой; перед каждой следующей она удваивается
+var WebhookRetryDelay = time.Second
+
+// WebhookTimeout ограничивает одну попытку отправки
+var WebhookTimeout = 10 * time.Second
+
+// webhookQueueSize - сколько событий может ждать отправки; события сверх очереди сразу
+// записываются в webhook_dead_letters, чтобы не задерживать запись в базу
+const webhookQueueSize = 1000
+
+// webhookWorkers - сколько событий отправляется одновременно
+const webhookWorkers = 4
+
+// webhookEventOps - окончания имен событий вебхуков по действиям ChangeEvent.Op
+var webhookEventOps = map[string]string{
+    AuditInsert: "created",
+    AuditUpdate: "updated",
+    AuditDelete: "deleted",
+}
+
+// WebhookEndpoint - адрес, на который отправляются события
+type WebhookEndpoint struct {
+    URL string
+    // Events - имена событий <сущность>.<created|updated|deleted>, например user.created;
+    // пустой - DefaultWebhookEvents
+    Events []string
+}
+
+// WebhookPayload - тело запроса вебхука
+type WebhookPayload struct {
+    // ID - идентификатор события: повторные попытки и webhooks redeliver отправляют его с тем же ID,
+    // и получатель может отбросить дубликат
+    ID     string    `json:"id"`
+    Event  string    `json:"event"`
+    Tenant int       `json:"tenant"`
+    Time   time.Time `json:"time"`
+    // Data - запись после изменения, для deleted - до него, как в ChangeEvent.Payload
+    Data json.RawMessage `json:"data"`
+}
+
+// DeadLetter - событие, которое не удалось доставить на вебхук (см. DeadLetters)
+type DeadLetter struct {
+    ID        int             `json:"id"`
+    Tenant    int             `json:"tenant"`
+    URL       string          `json:"url"`
+    Event     string          `json:"event"`
+    Payload   json.RawMessage `json:"payload"`
+    Attempts  int             `json:"attempts"`
+    LastError string          `json:"last_error"`
+    CreatedAt time.Time       `json:"created_at"`
+}
+
+// SignWebhook возвращает значение заголовка X-Webhook-Signature запроса с телом body, отправленного
+// в момент timestamp (Unix, секунды): "t=<timestamp>,v1=<HMAC-SHA256 секрета от "<timestamp>.<body>" в hex>".
+// Получатель считает подпись так же, сравнивает ее за постоянное время и отклоняет старые timestamp,
+// чтобы перехваченный запрос нельзя было повторить
+func SignWebhook(secret []byte, timestamp int64, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+    mac.Write(body)
+    return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// SetWebhooks включает отправку событий изменений (см. ChangeEvent) на вебхуки endpoints: каждое
+// событие - POST с телом WebhookPayload, подписанным secret (см. SignWebhook). Отправка идет в фоне
+// и не задерживает запись; неудачная попытка (сеть, 5xx, 408, 429) повторяется WebhookAttempts раз
+// с удваивающейся паузой, а событие, которое так и не доставлено, записывается в webhook_dead_letters
+// (см. DeadLetters, RedeliverDeadLetters). Вебхуки добавляются к потоку изменений, поэтому работают
+// и с outbox (см. SetOutbox). Вызывается до начала работы, после SetPublisher
+func (db *Database) SetWebhooks(endpoints []WebhookEndpoint, secret string) error {
+    if secret == "" {
+        return fmt.Errorf("webhooks need a signing secret in %s", WebhookSecretEnv)
+    }
+    for i, endpoint := range endpoints {
+        parsed, err := url.Parse(endpoint.URL)
+        if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+            return fmt.Errorf("webhook URL %q must be an absolute http or https URL", endpoint.URL)
+        }
+        if len(endpoint.Events) == 0 {
+            endpoints[i].Events = DefaultWebhookEvents
+        }
+        for _, event := range endpoints[i].Events {
+            if !validWebhookEvent(event) {
+                return fmt.Errorf("webhook event %q: expected <entity>.created, <entity>.updated or <entity>.deleted", event)
+            }
+        }
+    }
+
+    dispatcher := &webhookDispatcher{
+        db:        db.WithPrimary(),
+        endpoints: endpoints,
+        secret:    []byte(secret),
+        client:    &http.Client{Timeout: WebhookTimeout},
+        queue:     make(chan webhookDelivery, webhookQueueSize),
+        stopping:  make(chan struct{}),
+    }
+    for i := 0; i < webhookWorkers; i++ {
+        dispatcher.wg.Add(1)
+        go dispatcher.work()
+    }
+    db.webhooks = dispatcher
+    db.publisher = combinePublishers(db.publisher, dispatcher)
+    return nil
+}
+
+// validWebhookEvent проверяет имя события вебхука
+func validWebhookEvent(event string) bool {
+    entity, op, ok := strings.Cut(event, ".")
+    if !ok || entity == "" {
+        return false
+    }
+    for _, suffix := range webhookEventOps {
+        if op == suffix {
+            return true
+        }
+    }
+    return false
+}
+
+// webhookDispatcher отправляет события на вебхуки в фоне, общий для всех копий Database
+type webhookDispatcher struct {
+    db        *Database
+    endpoints []WebhookEndpoint
+    secret    []byte
+    client    *http.Client
+
+    mu       sync.Mutex
+    closed   bool
+    queue    chan webhookDelivery
+    stopping chan struct{}
+    wg       sync.WaitGroup
+}
+
+// webhookDelivery - событие для одного вебхука
+type webhookDelivery struct {
+    tenant int
+    url    string
+    event  string
+    body   []byte
+}
+
+// webhookStatusError - вебхук ответил кодом не из 2xx
+type webhookStatusError struct {
+    status int
+}
+
+func (e *webhookStatusError) Error() string {
+    return fmt.Sprintf("webhook answered %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryableWebhookError сообщает, есть ли смысл повторять попытку: остальные ответы 4xx не изменятся от повтора
+func retryableWebhookError(err error) bool {
+    var status *webhookStatusError
+    if !errors.As(err, &status) {
+        return true
+    }
+    return status.status >= 500 || status.status == http.StatusRequestTimeout || status.status == http.StatusTooManyRequests
+}
+
+// Publish ставит события в очередь отправки на вебхуки, подписанные на них, и сразу возвращается.
+// Ошибка - только если событие не удалось и отправить, и записать в webhook_dead_letters
+func (d *webhookDispatcher) Publish(ctx context.Context, events []ChangeEvent) error {
+    var errs []error
+    for _, event := range events {
+        op, ok := webhookEventOps[event.Op]
+        if !ok {
+            continue
+        }
+        name := event.Entity + "." + op
+        var body []byte
+        for _, endpoint := range d.endpoints {
+            if !webhookSubscribed(endpoint, name) {
+                continue
+            }
+            if body == nil {
+                var err error
+                if body, err = d.payload(name, event); err != nil {
+                    errs = append(errs, err)
+                    break
+                }
+            }
+            delivery := webhookDelivery{tenant: event.Tenant, url: endpoint.URL, event: name, body: body}
+            if err := d.enqueue(delivery); err != nil {
+                errs = append(errs, d.deadLetter(delivery, 0, err))
+            }
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// webhookSubscribed сообщает, подписан ли вебхук на событие
+func webhookSubscribed(endpoint WebhookEndpoint, event string) bool {
+    for _, name := range endpoint.Events {
+        if name == event {
+            return true
+        }
+    }
+    return false
+}
+
+// payload собирает тело запроса. У событий из outbox ID выводится из номера события, поэтому
+// повторная отправка пачки outbox приходит получателю с теми же ID
+func (d *webhookDispatcher) payload(name string, event ChangeEvent) ([]byte, error) {
+    id := "outbox-" + strconv.FormatInt(event.EventID, 10)
+    if event.EventID == 0 {
+        var err error
+        if id, err = d.db.newID(); err != nil {
+            return nil, err
+        }
+    }
+    data := event.Payload
+    if len(data) == 0 {
+        data = json.RawMessage("null")
+    }
+    return json.Marshal(WebhookPayload{ID: id, Event: name, Tenant: event.Tenant, Time: event.Time, Data: data})
+}
+
+// enqueue ставит отправку в очередь, если в ней есть место и отправка не остановлена
+func (d *webhookDispatcher) enqueue(delivery webhookDelivery) error {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.closed {
+        return errors.New("webhooks are shutting down")
+    }
+    select {
+    case d.queue <- delivery:
+        return nil
+    default:
+        return errors.New("delivery queue is full")
+    }
+}
+
+// work отправляет события из очереди, пока она не закрыта
+func (d *webhookDispatcher) work() {
+    defer d.wg.Done()
+    for delivery := range d.queue {
+        d.deliver(delivery)
+    }
+}
+
+// deliver отправляет событие с повторами и записывает его в webhook_dead_letters, если все попытки
+// неудачны. После начала остановки (см. stop) событие записывается туда без новых попыток
+func (d *webhookDispatcher) deliver(delivery webhookDelivery) {
+    delay := WebhookRetryDelay
+    attempts := 0
+    var err error
+    for {
+        select {
+        case <-d.stopping:
+            if err == nil {
+                err = errors.New("not delivered before shutdown")
+            }
+        default:
+            attempts++
+            if err = d.send(delivery.url, delivery.event, delivery.body); err == nil {
+                return
+            }
+            if attempts < WebhookAttempts && retryableWebhookError(err) {
+                select {
+                case <-time.After(delay):
+                    delay *= 2
+                    continue
+                case <-d.stopping:
+                }
+            }
+        }
+        if err := d.deadLetter(delivery, attempts, err); err != nil {
+            log.Printf("webhooks: %v", err)
+        }
+        return
+    }
+}
+
+// send отправляет подписанный запрос вебхука один раз
+func (d *webhookDispatcher) send(target, event string, body []byte) error {
+    ctx, cancel := context.WithTimeout(context.Background(), WebhookTimeout)
+    defer cancel()
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", "application/json")
+    request.Header.Set("User-Agent", "dbModule-webhooks")
+    request.Header.Set("X-Webhook-Event", event)
+    request.Header.Set("X-Webhook-Signature", SignWebhook(d.secret, d.db.now().Unix(), body))
+
+    response, err := d.client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    // тело ответа дочитывается, чтобы соединение вернулось в пул
+    io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return &webhookStatusError{status: response.StatusCode}
+    }
+    return nil
+}
+
+// deadLetter записывает недоставленное событие в webhook_dead_letters
+func (d *webhookDispatcher) deadLetter(delivery webhookDelivery, attempts int, cause error) error {
+    log.Printf("webhooks: %s to %s is not delivered after %d attempts: %v", delivery.event, delivery.url, attempts, cause)
+    _, err := d.db.execNamed("webhook_dead_letters.insert", delivery.tenant, delivery.url, delivery.event,
+        string(delivery.body), attempts, cause.Error(), d.db.now().UTC())
+    if err != nil {
+        return fmt.Errorf("saving undelivered %s to %s: %w", delivery.event, delivery.url, err)
+    }
+    return nil
+}
+
+// stop останавливает отправку: новые события и события из очереди записываются в webhook_dead_letters,
+// а начатые попытки дожидаются до отмены ctx
+func (d *webhookDispatcher) stop(ctx context.Context) {
+    d.mu.Lock()
+    if d.closed {
+        d.mu.Unlock()
+        return
+    }
+    d.closed = true
+    close(d.stopping)
+    close(d.queue)
+    d.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        d.wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-ctx.Done():
+        log.Printf("webhooks: stopped with deliveries still running: %v", ctx.Err())
+    }
+}
+
+// DeadLetters возвращает события всех площадок, которые не удалось доставить на вебхуки
+func (db *Database) DeadLetters() ([]DeadLetter, error) {
+    rows, err := db.WithPrimary().queryNamed("webhook_dead_letters.select")
+    if err != nil {
+        return nil, db.opError("list", "webhook_dead_letters", nil, err)
+    }
+    letters, err := collectRows(db, rows, scanDeadLetter)
+    return letters, db.opError("list", "webhook_dead_letters", nil, err)
+}
+
+// scanDeadLetter читает строку webhook_dead_letters
+func scanDeadLetter(rows *queryRows) (DeadLetter, error) {
+    var letter DeadLetter
+    var payload string
+    var lastError sql.NullString
+    err := rows.Scan(&letter.ID, &letter.Tenant, &letter.URL, &letter.Event, &payload, &letter.Attempts, &lastError, &letter.CreatedAt)
+    letter.Payload, letter.LastError = json.RawMessage(payload), lastError.String
+    return letter, err
+}
+
+// RedeliverDeadLetters еще раз отправляет недоставленные события, одной попыткой каждое: доставленное
+// удаляется из webhook_dead_letters, у остальных растет счетчик попыток. id > 0 - только это событие.
+// Возвращает число доставленных и недоставленных событий
+func (db *Database) RedeliverDeadLetters(id int) (delivered, failed int, err error) {
+    if db.webhooks == nil {
+        return 0, 0, errors.New("webhooks are not configured (see -webhooks)")
+    }
+    primary := db.WithPrimary()
+    var rows *queryRows
+    if id > 0 {
+        rows, err = primary.queryNamed("webhook_dead_letters.select_by_id", id)
+    } else {
+        rows, err = primary.queryNamed("webhook_dead_letters.select")
+    }
+    if err != nil {
+        return 0, 0, db.opError("redeliver", "webhook_dead_letters", nil, err)
+    }
+    letters, err := collectRows(db, rows, scanDeadLetter)
+    if err != nil {
+        return 0, 0, db.opError("redeliver", "webhook_dead_letters", nil, err)
+    }
+    if id > 0 && len(letters) == 0 {
+        return 0, 0, db.opError("redeliver", "webhook_dead_letters", id, sql.ErrNoRows)
+    }
+
+    for _, letter := range letters {
+        sendErr := db.webhooks.send(letter.URL, letter.Event, letter.Payload)
+        if sendErr == nil {
+            delivered++
+            _, err = primary.execNamed("webhook_dead_letters.delete", letter.ID)
+        } else {
+            failed++
+            _, err = primary.execNamed("webhook_dead_letters.mark_failed", 1, sendErr.Error(), letter.ID)
+        }
+        if err != nil {
+            return delivered, failed, db.opError("redeliver", "webhook_dead_letters", letter.ID, err)
+        }
+    }
+    return delivered, failed, nil
+}
+
+// parseWebhooks разбирает -webhooks: адреса через запятую, все с событиями events
+func parseWebhooks(urls, events string) []WebhookEndpoint {
+    var names []string
+    for _, name := range strings.Split(events, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            names = append(names, name)
+        }
+    }
+    var endpoints []WebhookEndpoint
+    for _, target := range strings.Split(urls, ",") {
+        if target = strings.TrimSpace(target); target != "" {
+            endpoints = append(endpoints, WebhookEndpoint{URL: target, Events: names})
+        }
+    }
+    return endpoints
+}
+
+// runWebhooks выполняет команду webhooks: dead-letters печатает недоставленные события,
+// redeliver отправляет их еще раз
+func runWebhooks(db *Database, args []string) error {
+    if len(args) == 0 || args[0] != "dead-letters" && args[0] != "redeliver" {
+        return fmt.Errorf("usage: webhooks dead-letters | redeliver [-id N]")
+    }
+    flags := flag.NewFlagSet("webhooks", flag.ContinueOnError)
+    id := flags.Int("id", 0, "redeliver only this dead letter")
+    if err := flags.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    if args[0] == "redeliver" {
+        delivered, failed, err := db.RedeliverDeadLetters(*id)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%d delivered, %d still failing\n", delivered, failed)
+        return nil
+    }
+
+    letters, err := db.DeadLetters()
+    if err != nil {
+        return err
+    }
+    for _, letter := range letters {
+        fmt.Printf("%-6d %-20s %-40s tenant %d, %d attempts, since %s: %s\n", letter.ID, letter.Event, letter.URL,
+            letter.Tenant, letter.Attempts, letter.CreatedAt.Format(time.RFC3339), letter.LastError)
+    }
+    fmt.Printf("%d dead letters\n", len(letters))
+    return nil
+}
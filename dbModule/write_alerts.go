@@ -0,0 +1,122 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// WriteRateOptions задает, какое отклонение скорости записи считать аномальным
+type WriteRateOptions struct {
+    // Window - проверяемый интервал, округляется до целых часов
+    Window time.Duration
+    // Baseline - предшествующий Window период, по которому считается обычная скорость
+    Baseline time.Duration
+    // Factor - во сколько раз скорость записи должна превысить обычную
+    Factor float64
+    // MinRows - меньше стольких строк за Window предупреждений не бывает, даже без истории
+    MinRows float64
+}
+
+// DefaultWriteRateOptions сравнивает последний час с неделей до него
+var DefaultWriteRateOptions = WriteRateOptions{
+    Window:   time.Hour,
+    Baseline: 7 * 24 * time.Hour,
+    Factor:   10,
+    MinRows:  100,
+}
+
+// WriteRateAlert - запрос на запись, изменивший за окно намного больше строк, чем обычно
+type WriteRateAlert struct {
+    Query string
+    // Rows - оценка измененных строк за окно
+    Rows float64
+    // ExpectedRows - столько строк обычно меняется за такое же окно; 0 - истории нет
+    ExpectedRows float64
+}
+
+// writeActions - префиксы имен запросов реестра, изменяющих строки
+var writeActions = []string{"insert", "update", "upsert", "delete"}
+
+// isWriteQuery проверяет по имени, что запрос изменяет строки, например users.delete
+func isWriteQuery(name string) bool {
+    action := name[strings.LastIndex(name, ".")+1:]
+    for _, prefix := range writeActions {
+        if strings.HasPrefix(action, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// WriteRateAlerts сравнивает число строк, измененных каждым запросом на запись за последнее окно,
+// с обычной скоростью за предшествующий период. Данные берутся из выборки query_stats,
+// поэтому запросы учитываются, только если включена запись статистики (SetQuerySampleRate)
+func (db *Database) WriteRateAlerts(options WriteRateOptions) ([]WriteRateAlert, error) {
+    window := int(options.Window / time.Hour)
+    if window < 1 {
+        window = 1
+    }
+    baseline := int(options.Baseline / time.Hour)
+    if baseline < 1 {
+        return nil, &ValidationError{Field: "baseline", Message: "must be at least one hour"}
+    }
+
+    rows, err := db.queryNamed("query_stats.rows_by_window", window, window, window+baseline)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var alerts []WriteRateAlert
+    for rows.Next() {
+        var name string
+        var current, previous float64
+        if err := rows.Scan(&name, &current, &previous); err != nil {
+            return nil, db.opError("check", "write rates", nil, err)
+        }
+        if !isWriteQuery(name) || current < options.MinRows {
+            continue
+        }
+        expected := previous / float64(baseline) * float64(window)
+        if current >= expected*options.Factor {
+            alerts = append(alerts, WriteRateAlert{Query: name, Rows: current, ExpectedRows: expected})
+        }
+    }
+    return alerts, db.opError("check", "write rates", nil, rows.Err())
+}
+
+// runWriteAlerts выводит запросы на запись с аномальной скоростью изменений.
+// С -strict команда завершается ошибкой, если предупреждения есть, чтобы ее можно было запускать из cron
+func runWriteAlerts(db *Database, args []string) error {
+    options := DefaultWriteRateOptions
+    flags := flag.NewFlagSet("write-alerts", flag.ContinueOnError)
+    flags.DurationVar(&options.Window, "window", options.Window, "interval to check, in whole hours")
+    flags.DurationVar(&options.Baseline, "baseline", options.Baseline, "preceding period that defines the normal write rate")
+    flags.Float64Var(&options.Factor, "factor", options.Factor, "how many times the normal rate counts as an anomaly")
+    flags.Float64Var(&options.MinRows, "min-rows", options.MinRows, "ignore queries that changed fewer rows in the window")
+    strict := flags.Bool("strict", false, "fail if there are alerts")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    alerts, err := db.WriteRateAlerts(options)
+    if err != nil {
+        return err
+    }
+    for _, alert := range alerts {
+        if alert.ExpectedRows == 0 {
+            fmt.Printf("ALERT %-32s | ~%.0f rows in the last %v, no writes before\n", alert.Query, alert.Rows, options.Window)
+            continue
+        }
+        fmt.Printf("ALERT %-32s | ~%.0f rows in the last %v, %.1fx the usual ~%.1f\n",
+            alert.Query, alert.Rows, options.Window, alert.Rows/alert.ExpectedRows, alert.ExpectedRows)
+    }
+
+    fmt.Printf("%d write rate alerts\n", len(alerts))
+    if *strict && len(alerts) > 0 {
+        return fmt.Errorf("%d queries write at an abnormal rate", len(alerts))
+    }
+    return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+// DefaultWriteQueueSize - сколько записей могут ждать очереди, прежде чем следующая начнет ждать места в ней
+const DefaultWriteQueueSize = 128
+
+// writeQueue выстраивает записи SQLite в одну очередь: SQLite допускает только одного писателя,
+// и параллельные транзакции иначе получают SQLITE_BUSY. Горутина-диспетчер по одной выдает
+// записям очередь в порядке постановки, а сами записи выполняются в горутинах вызывающих:
+// так сохраняются их стеки (см. callSite), паники и таймауты
+type writeQueue struct {
+    mu     sync.RWMutex
+    closed bool
+    turns  chan *writeTurn
+}
+
+// writeTurn - место одной записи в очереди
+type writeTurn struct {
+    // start закрывается диспетчером, когда подошла очередь записи
+    start chan struct{}
+    // finished закрывается, когда запись закончена или ожидание прервано
+    finished chan struct{}
+    once     sync.Once
+}
+
+// newWriteQueue запускает диспетчер очереди записи на size ожидающих записей
+func newWriteQueue(size int) *writeQueue {
+    q := &writeQueue{turns: make(chan *writeTurn, size)}
+    go q.dispatch()
+    return q
+}
+
+// dispatch выдает очередь записям по одной, пока очередь не закрыта
+func (q *writeQueue) dispatch() {
+    for turn := range q.turns {
+        close(turn.start)
+        <-turn.finished
+    }
+}
+
+// enqueue ставит запись в конец очереди; без очереди (не SQLite) возвращает nil.
+// Если очередь заполнена, ждет места в ней
+func (q *writeQueue) enqueue() (*writeTurn, error) {
+    if q == nil {
+        return nil, nil
+    }
+    q.mu.RLock()
+    defer q.mu.RUnlock()
+    if q.closed {
+        return nil, ErrClosed
+    }
+    turn := &writeTurn{start: make(chan struct{}), finished: make(chan struct{})}
+    q.turns <- turn
+    return turn, nil
+}
+
+// depth возвращает, сколько записей ждут очереди, и сколько их может ждать; без очереди - 0, 0
+func (q *writeQueue) depth() (waiting, capacity int) {
+    if q == nil {
+        return 0, 0
+    }
+    return len(q.turns), cap(q.turns)
+}
+
+// close закрывает очередь: новые записи получают ErrClosed, уже поставленные дожидаются своей очереди
+func (q *writeQueue) close() {
+    if q == nil {
+        return
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if !q.closed {
+        q.closed = true
+        close(q.turns)
+    }
+}
+
+// wait ждет очереди записи до отмены ctx. При отмене место освобождается,
+// и следующие записи не ждут прерванную
+func (t *writeTurn) wait(ctx context.Context) error {
+    if t == nil {
+        return nil
+    }
+    select {
+    case <-t.start:
+        return nil
+    case <-ctx.Done():
+        t.release()
+        return ctx.Err()
+    }
+}
+
+// release отдает очередь следующей записи; повторный вызов ничего не делает
+func (t *writeTurn) release() {
+    if t == nil {
+        return
+    }
+    t.once.Do(func() { close(t.finished) })
+}
+
+// awaitWrite ставит запись вне транзакции в очередь и ждет ее до отмены ctx;
+// release нужно вызвать после записи. Запросы внутри транзакции уже выполняются в ее очереди
+func (db *Database) awaitWrite(ctx context.Context, name string, op operation) (release func(), err error) {
+    if db.tx != nil || db.writes == nil {
+        return func() {}, nil
+    }
+    turn, err := db.writes.enqueue()
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    if err := turn.wait(ctx); err != nil {
+        if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+            return nil, db.timeoutError(ctx, name+" (waiting for the write queue)", op, err)
+        }
+        return nil, fmt.Errorf("%s (waiting for the write queue): %w", name, err)
+    }
+    return turn.release, nil
+}
+
+// InTxAsync ставит транзакцию fn в очередь записи и сразу возвращает канал, в который придет
+// ее результат (см. InTx). Транзакции выполняются в порядке вызовов InTxAsync и InTx.
+// Если очередь заполнена, InTxAsync ждет места в ней. Внутри транзакции fn выполняется сразу
+func (db *Database) InTxAsync(fn func(tx *Database) error) <-chan error {
+    result := make(chan error, 1)
+    if db.tx != nil {
+        result <- fn(db)
+        return result
+    }
+    run, err := db.queueTx()
+    if err != nil {
+        result <- err
+        return result
+    }
+    go func() {
+        result <- run(fn)
+    }()
+    return result
+}